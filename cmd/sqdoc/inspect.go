@@ -0,0 +1,313 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"sqdoc/pkg/sqdoc"
+)
+
+func newFlagSet(name string, stderr io.Writer) *flag.FlagSet {
+	fs := flag.NewFlagSet("sqdoc "+name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	return fs
+}
+
+func runInfo(args []string, stdout, stderr io.Writer) int {
+	fs := newFlagSet("info", stderr)
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fmt.Fprintln(stderr, "usage: sqdoc info <file.sqdoc>...")
+		return 2
+	}
+	status := 0
+	for i, path := range fs.Args() {
+		if i > 0 {
+			fmt.Fprintln(stdout)
+		}
+		if code := printInfo(path, stdout, stderr); code != 0 {
+			status = code
+		}
+	}
+	return status
+}
+
+func printInfo(path string, stdout, stderr io.Writer) int {
+	st, err := os.Stat(path)
+	if err != nil {
+		return fail(stderr, path, err)
+	}
+	env, err := sqdoc.InspectEnvelope(path)
+	if err != nil {
+		return fail(stderr, path, err)
+	}
+	doc, err := loadDocument(path, stderr)
+	if err != nil {
+		return fail(stderr, path, err)
+	}
+
+	var runs, textBytes int
+	for _, tb := range doc.TextBlocks() {
+		runs += len(tb.Runs)
+		textBytes += len(tb.UTF8)
+	}
+	m := doc.Metadata
+	w := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "File:\t%s\n", path)
+	fmt.Fprintf(w, "Size:\t%d bytes\n", st.Size())
+	fmt.Fprintf(w, "Envelope:\t%s\n", envelopeSummary(env))
+	fmt.Fprintf(w, "Title:\t%s\n", m.Title)
+	fmt.Fprintf(w, "Author:\t%s\n", m.Author)
+	if m.Language != "" {
+		fmt.Fprintf(w, "Language:\t%s\n", m.Language)
+	}
+	fmt.Fprintf(w, "Created:\t%s\n", unixString(m.CreatedUnix))
+	fmt.Fprintf(w, "Modified:\t%s\n", unixString(m.ModifiedUnix))
+	fmt.Fprintf(w, "Paged mode:\t%t\n", m.PagedMode)
+	fmt.Fprintf(w, "Paragraph gap:\t%d\n", m.ParagraphGap)
+	fmt.Fprintf(w, "Font family:\t%s\n", fontFamilyName(m.PreferredFontFamily))
+	fmt.Fprintf(w, "Blocks:\t%d\n", len(doc.Blocks))
+	fmt.Fprintf(w, "Style runs:\t%d\n", runs)
+	fmt.Fprintf(w, "Text bytes:\t%d\n", textBytes)
+	if err := w.Flush(); err != nil {
+		return fail(stderr, path, err)
+	}
+	return 0
+}
+
+type layoutJSON struct {
+	File         string        `json:"file"`
+	HeaderLength uint32        `json:"headerLength"`
+	IndexOffset  uint64        `json:"indexOffset"`
+	IndexLength  uint32        `json:"indexLength"`
+	FileSize     uint64        `json:"fileSize"`
+	Segments     []segmentJSON `json:"segments"`
+}
+
+type segmentJSON struct {
+	Name    string `json:"name"`
+	Kind    uint8  `json:"kind"`
+	BlockID uint64 `json:"blockId"`
+	Offset  uint64 `json:"offset"`
+	Length  uint32 `json:"length"`
+	CRC32   string `json:"crc32,omitempty"`
+}
+
+func runLayout(args []string, stdout, stderr io.Writer) int {
+	fs := newFlagSet("layout", stderr)
+	asJSON := fs.Bool("json", false, "print JSON instead of a table")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fmt.Fprintln(stderr, "usage: sqdoc layout [-json] <file.sqdoc>...")
+		return 2
+	}
+	status := 0
+	var out []layoutJSON
+	for i, path := range fs.Args() {
+		opts, err := loadOptions(path, stderr)
+		if err != nil {
+			status = fail(stderr, path, err)
+			continue
+		}
+		info, err := sqdoc.InspectFileLayout(path, opts)
+		if err != nil {
+			status = fail(stderr, path, err)
+			continue
+		}
+		if *asJSON {
+			out = append(out, layoutToJSON(path, info))
+			continue
+		}
+		if i > 0 {
+			fmt.Fprintln(stdout)
+		}
+		printLayoutTable(stdout, path, info)
+	}
+	if *asJSON {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		var v any = out
+		if len(out) == 1 {
+			v = out[0]
+		}
+		if err := enc.Encode(v); err != nil {
+			fmt.Fprintf(stderr, "sqdoc: %v\n", err)
+			return 1
+		}
+	}
+	return status
+}
+
+func layoutToJSON(path string, info *sqdoc.LayoutInfo) layoutJSON {
+	out := layoutJSON{
+		File:         path,
+		HeaderLength: info.HeaderLength,
+		IndexOffset:  info.IndexOffset,
+		IndexLength:  info.IndexLength,
+		FileSize:     info.FileSize,
+	}
+	for _, s := range info.Segments {
+		seg := segmentJSON{Name: s.Name, Kind: uint8(s.Kind), BlockID: s.BlockID, Offset: s.Offset, Length: s.Length}
+		if hasCRC(s) {
+			seg.CRC32 = fmt.Sprintf("%08x", s.CRC32)
+		}
+		out.Segments = append(out.Segments, seg)
+	}
+	return out
+}
+
+func printLayoutTable(stdout io.Writer, path string, info *sqdoc.LayoutInfo) {
+	fmt.Fprintf(stdout, "%s (%d bytes, index at %d)\n", path, info.FileSize, info.IndexOffset)
+	w := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "OFFSET\tLENGTH\tCRC32\tBLOCK\t  SEGMENT")
+	for _, s := range info.Segments {
+		crc := "-"
+		if hasCRC(s) {
+			crc = fmt.Sprintf("%08x", s.CRC32)
+		}
+		id := fmt.Sprintf("%d", s.BlockID)
+		if s.BlockID == ^uint64(0) {
+			id = "fmt"
+		}
+		fmt.Fprintf(w, "%d\t%d\t%s\t%s\t  %s\n", s.Offset, s.Length, crc, id, s.Name)
+	}
+	_ = w.Flush()
+}
+
+// hasCRC is false for the header and index, which the TOC does not checksum.
+func hasCRC(s sqdoc.LayoutSegment) bool {
+	return s.Name != "Header" && s.Name != "Index"
+}
+
+func runValidate(args []string, stdout, stderr io.Writer) int {
+	fs := newFlagSet("validate", stderr)
+	quiet := fs.Bool("q", false, "only print problems")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fmt.Fprintln(stderr, "usage: sqdoc validate [-q] <file.sqdoc>...")
+		return 2
+	}
+	status := 0
+	for _, path := range fs.Args() {
+		opts, err := loadOptions(path, stderr)
+		if err != nil {
+			status = fail(stderr, path, err)
+			continue
+		}
+		problems := sqdoc.ValidateFile(path, opts)
+		if len(problems) == 0 {
+			if !*quiet {
+				fmt.Fprintf(stdout, "%s: ok\n", path)
+			}
+			continue
+		}
+		status = 1
+		for _, p := range problems {
+			fmt.Fprintf(stdout, "%s: %s\n", path, problemText(p))
+		}
+	}
+	return status
+}
+
+func runCat(args []string, stdout, stderr io.Writer) int {
+	fs := newFlagSet("cat", stderr)
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fmt.Fprintln(stderr, "usage: sqdoc cat <file.sqdoc>...")
+		return 2
+	}
+	status := 0
+	for _, path := range fs.Args() {
+		doc, err := loadDocument(path, stderr)
+		if err != nil {
+			status = fail(stderr, path, err)
+			continue
+		}
+		lines := make([]string, 0, len(doc.Blocks))
+		for _, b := range doc.Blocks {
+			if b.Text != nil {
+				lines = append(lines, plainText(b.Text.UTF8))
+			}
+			if b.Table == nil {
+				continue
+			}
+			// Table rows print as tab-separated cells.
+			for _, row := range b.Table.Rows {
+				cells := make([]string, len(row))
+				for c, cell := range row {
+					if cell.Text != nil {
+						cells[c] = strings.ReplaceAll(plainText(cell.Text.UTF8), "\n", " ")
+					}
+				}
+				lines = append(lines, strings.Join(cells, "\t"))
+			}
+		}
+		fmt.Fprintln(stdout, strings.Join(lines, "\n"))
+	}
+	return status
+}
+
+// plainText replaces inline image tokens with an [image: name] note, so
+// cat prints what a reader sees rather than the encoded token.
+func plainText(text []byte) string {
+	tokens := sqdoc.ParseImageTokens(text)
+	if len(tokens) == 0 {
+		return string(text)
+	}
+	var b strings.Builder
+	last := 0
+	for _, t := range tokens {
+		b.Write(text[last:t.Start])
+		fmt.Fprintf(&b, "[image: %s]", filepath.Base(t.Path))
+		last = t.End
+	}
+	b.Write(text[last:])
+	return b.String()
+}
+
+func envelopeSummary(env sqdoc.EnvelopeInfo) string {
+	if !env.Wrapped {
+		return "plain"
+	}
+	parts := []string{fmt.Sprintf("secure v%d", env.EnvelopeVer)}
+	if env.Compressed {
+		parts = append(parts, "compressed")
+	}
+	if env.Encrypted {
+		parts = append(parts, "encrypted")
+	}
+	return strings.Join(parts, ", ")
+}
+
+func unixString(sec int64) string {
+	if sec == 0 {
+		return "-"
+	}
+	return time.Unix(sec, 0).UTC().Format(time.RFC3339)
+}
+
+func fontFamilyName(f sqdoc.FontFamily) string {
+	switch f {
+	case sqdoc.FontFamilySerif:
+		return "serif"
+	case sqdoc.FontFamilyMonospace:
+		return "monospace"
+	default:
+		return "sans"
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"sqdoc/pkg/sqdoc"
+)
+
+func TestInspectCommands(t *testing.T) {
+	dir := t.TempDir()
+	good := filepath.Join(dir, "good.sqdoc")
+	writeTestDoc(t, good, "before "+sqdoc.MakeImageToken("/media/pic.png", 40, 30)+" after", "second")
+	doc, err := sqdoc.Load(good)
+	if err != nil {
+		t.Fatal(err)
+	}
+	table := sqdoc.NewTable(1, 2)
+	table.Rows[0][0].Text.UTF8 = []byte("left")
+	table.Rows[0][1].Text.UTF8 = []byte("two\nlines")
+	doc.Blocks = append(doc.Blocks, sqdoc.Block{ID: 3, Kind: sqdoc.BlockKindTable, Table: table})
+	if err := sqdoc.Save(good, doc); err != nil {
+		t.Fatal(err)
+	}
+	bad := filepath.Join(dir, "bad.sqdoc")
+	if err := os.WriteFile(bad, []byte("not a document"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	missing := filepath.Join(dir, "missing.sqdoc")
+
+	tests := []struct {
+		name   string
+		args   []string
+		want   int
+		stdout []string
+		stderr string
+	}{
+		{name: "info", args: []string{"info", good}, want: 0, stdout: []string{"Title:", "Draft", "Envelope:", "plain", "Blocks:", "3"}},
+		{name: "info missing", args: []string{"info", missing}, want: 1, stderr: "missing.sqdoc"},
+		{name: "info usage", args: []string{"info"}, want: 2, stderr: "usage: sqdoc info"},
+		{name: "layout", args: []string{"layout", good}, want: 0, stdout: []string{"OFFSET", "Header", "Index"}},
+		{name: "layout json", args: []string{"layout", "-json", good}, want: 0, stdout: []string{`"file": `, `"segments"`, `"name": "Header"`}},
+		{name: "layout bad", args: []string{"layout", bad}, want: 1, stderr: "bad.sqdoc"},
+		{name: "layout bad flag", args: []string{"layout", "-nope", good}, want: 2, stderr: "flag provided but not defined"},
+		{name: "validate", args: []string{"validate", good}, want: 0, stdout: []string{good + ": ok"}},
+		{name: "validate quiet", args: []string{"validate", "-q", good}, want: 0},
+		{name: "validate bad", args: []string{"validate", "-q", good, bad}, want: 1, stdout: []string{bad + ": "}},
+		{name: "validate usage", args: []string{"validate"}, want: 2, stderr: "usage: sqdoc validate"},
+		{name: "cat", args: []string{"cat", good}, want: 0, stdout: []string{"before [image: pic.png] after\nsecond\nleft\ttwo lines\n"}},
+		{name: "cat keeps going", args: []string{"cat", missing, good}, want: 1, stdout: []string{"second"}, stderr: "missing.sqdoc"},
+		{name: "unknown command", args: []string{"nope"}, want: 2, stderr: `unknown command "nope"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			if got := run(tt.args, &stdout, &stderr); got != tt.want {
+				t.Fatalf("exit = %d, want %d\nstdout:\n%s\nstderr:\n%s", got, tt.want, stdout.String(), stderr.String())
+			}
+			for _, want := range tt.stdout {
+				if !strings.Contains(stdout.String(), want) {
+					t.Errorf("stdout missing %q:\n%s", want, stdout.String())
+				}
+			}
+			if len(tt.stdout) == 0 && stdout.Len() > 0 && tt.want == 0 {
+				t.Errorf("expected no output, got:\n%s", stdout.String())
+			}
+			if !strings.Contains(stderr.String(), tt.stderr) {
+				t.Errorf("stderr missing %q:\n%s", tt.stderr, stderr.String())
+			}
+			if strings.Contains(stdout.String(), sqdoc.ImageTokenPrefix) {
+				t.Errorf("stdout leaks an image token:\n%s", stdout.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"sqdoc/pkg/sqdoc"
+
+	"golang.org/x/term"
+)
+
+const passwordEnv = "SQDOC_PASSWORD"
+
+type command struct {
+	name    string
+	summary string
+	run     func(args []string, stdout, stderr io.Writer) int
+}
+
+var commands []command
+
+func init() {
+	commands = []command{
+		{name: "info", summary: "show envelope flags, metadata and block counts", run: runInfo},
+		{name: "layout", summary: "list stored segments with offsets, lengths and CRCs", run: runLayout},
+		{name: "validate", summary: "check files and report every problem found", run: runValidate},
+		{name: "cat", summary: "print document text", run: runCat},
+		{name: "reencode", summary: "rewrite documents with new envelope settings", run: runReencode},
+		{name: "pdf", summary: "export documents to PDF", run: runPDF},
+	}
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 || args[0] == "-h" || args[0] == "--help" || args[0] == "help" {
+		usage(stderr)
+		if len(args) == 0 {
+			return 2
+		}
+		return 0
+	}
+	for _, c := range commands {
+		if c.name == args[0] {
+			return c.run(args[1:], stdout, stderr)
+		}
+	}
+	fmt.Fprintf(stderr, "sqdoc: unknown command %q\n\n", args[0])
+	usage(stderr)
+	return 2
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "usage: sqdoc <command> [flags] <file.sqdoc>...")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "commands:")
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %-10s %s\n", c.name, c.summary)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "Encrypted files read the password from $%s, or prompt when run in a terminal.\n", passwordEnv)
+	fmt.Fprintf(w, "reencode reads the password for newly encrypted output from $%s.\n", newPasswordEnv)
+}
+
+// loadOptions resolves the password for path. Plain and compressed-only
+// files never prompt.
+func loadOptions(path string, stderr io.Writer) (sqdoc.LoadOptions, error) {
+	env, err := sqdoc.InspectEnvelope(path)
+	if err != nil {
+		return sqdoc.LoadOptions{}, err
+	}
+	if !env.Encrypted {
+		return sqdoc.LoadOptions{}, nil
+	}
+	if pw, ok := os.LookupEnv(passwordEnv); ok {
+		return sqdoc.LoadOptions{Password: pw}, nil
+	}
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return sqdoc.LoadOptions{}, fmt.Errorf("%w (set %s)", sqdoc.ErrPasswordRequired, passwordEnv)
+	}
+	fmt.Fprintf(stderr, "Password for %s: ", path)
+	pw, err := term.ReadPassword(fd)
+	fmt.Fprintln(stderr)
+	if err != nil {
+		return sqdoc.LoadOptions{}, err
+	}
+	return sqdoc.LoadOptions{Password: string(pw)}, nil
+}
+
+func loadDocument(path string, stderr io.Writer) (*sqdoc.Document, error) {
+	opts, err := loadOptions(path, stderr)
+	if err != nil {
+		return nil, err
+	}
+	return sqdoc.LoadWithOptions(path, opts)
+}
+
+func fail(stderr io.Writer, path string, err error) int {
+	if errors.Is(err, sqdoc.ErrInvalidPassword) {
+		err = errors.New("incorrect password")
+	}
+	fmt.Fprintf(stderr, "sqdoc: %s: %s\n", path, problemText(err))
+	return 1
+}
+
+func problemText(err error) string {
+	return strings.TrimPrefix(err.Error(), "sqdoc: ")
+}
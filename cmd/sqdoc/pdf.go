@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"sqdoc/pkg/convert/pdf"
+)
+
+var pageSizes = map[string]pdf.PageSize{
+	"a4":     pdf.PageA4,
+	"letter": pdf.PageLetter,
+	"legal":  pdf.PageLegal,
+}
+
+func runPDF(args []string, stdout, stderr io.Writer) int {
+	fs := newFlagSet("pdf", stderr)
+	page := fs.String("page", "", "page size: a4, letter, legal or WIDTHxHEIGHT in points (default: the document's)")
+	margin := fs.Float64("margin", 0, "margin on every side, in points (default: the document's)")
+	out := fs.String("o", "", "output file (default: input name with .pdf)")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 || (*out != "" && fs.NArg() > 1) {
+		fmt.Fprintln(stderr, "usage: sqdoc pdf [-page a4|letter|legal|WxH] [-margin pt] [-o out.pdf] <file.sqdoc>...")
+		return 2
+	}
+	var size pdf.PageSize
+	if *page != "" {
+		var err error
+		if size, err = parsePageSize(*page); err != nil {
+			fmt.Fprintf(stderr, "sqdoc: %v\n", err)
+			return 2
+		}
+	}
+	if *margin < 0 {
+		fmt.Fprintln(stderr, "sqdoc: -margin must be positive")
+		return 2
+	}
+	var margins pdf.Margins
+	if *margin > 0 {
+		margins = pdf.UniformMargins(*margin)
+	}
+
+	status := 0
+	for _, path := range fs.Args() {
+		doc, err := loadDocument(path, stderr)
+		if err != nil {
+			status = fail(stderr, path, err)
+			continue
+		}
+		dst := *out
+		if dst == "" {
+			dst = strings.TrimSuffix(path, filepath.Ext(path)) + ".pdf"
+		}
+		opts := pdf.Options{Page: size, Margins: margins, BaseDir: filepath.Dir(path)}
+		if err := pdf.ExportFile(dst, doc, opts); err != nil {
+			status = fail(stderr, path, err)
+			continue
+		}
+		fmt.Fprintf(stdout, "%s -> %s\n", path, dst)
+	}
+	return status
+}
+
+func parsePageSize(s string) (pdf.PageSize, error) {
+	if size, ok := pageSizes[strings.ToLower(s)]; ok {
+		return size, nil
+	}
+	w, h, ok := strings.Cut(strings.ToLower(s), "x")
+	if ok {
+		width, errW := strconv.ParseFloat(w, 64)
+		height, errH := strconv.ParseFloat(h, 64)
+		if errW == nil && errH == nil && width > 0 && height > 0 {
+			return pdf.PageSize{Width: width, Height: height}, nil
+		}
+	}
+	return pdf.PageSize{}, fmt.Errorf("unknown page size %q", s)
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	iofs "io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+
+	"sqdoc/pkg/sqdoc"
+
+	"golang.org/x/term"
+)
+
+const newPasswordEnv = "SQDOC_NEW_PASSWORD"
+
+type reencodeJob struct {
+	path   string
+	env    sqdoc.EnvelopeInfo
+	target sqdoc.SaveOptions
+}
+
+type reencodeResult struct {
+	path      string
+	from, to  string
+	sizeIn    int64
+	sizeOut   int64
+	err       error
+	skipped   bool
+	skipCause string
+}
+
+func runReencode(args []string, stdout, stderr io.Writer) int {
+	fs := newFlagSet("reencode", stderr)
+	compress := fs.Bool("compress", false, "zlib-compress output (default: keep each file's setting)")
+	encrypt := fs.Bool("encrypt", false, "encrypt output with $"+newPasswordEnv+" or a prompted password (default: keep)")
+	workers := fs.Int("j", runtime.NumCPU(), "number of files to process in parallel")
+	dryRun := fs.Bool("n", false, "dry run: report what would change without writing")
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "usage: sqdoc reencode [flags] <file|dir|glob>...")
+		fmt.Fprintln(stderr)
+		fmt.Fprintln(stderr, "Rewrites each document with the target envelope settings. Files are")
+		fmt.Fprintln(stderr, "always re-normalised to the current encoding, even when settings match.")
+		fmt.Fprintln(stderr)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	if *workers < 1 {
+		*workers = 1
+	}
+
+	paths, unreadable, err := expandDocumentArgs(fs.Args())
+	if err != nil {
+		fmt.Fprintf(stderr, "sqdoc: %v\n", err)
+		return 2
+	}
+	if len(paths) == 0 {
+		fmt.Fprintln(stderr, "sqdoc: no .sqdoc files matched")
+		return 1
+	}
+
+	jobs := make([]reencodeJob, 0, len(paths))
+	results := make([]reencodeResult, len(paths))
+	needOld, needNew := false, false
+	for i, path := range paths {
+		if err := unreadable[path]; err != nil {
+			results[i] = reencodeResult{path: path, err: err}
+			continue
+		}
+		env, err := sqdoc.InspectEnvelope(path)
+		if err != nil {
+			results[i] = reencodeResult{path: path, err: err}
+			continue
+		}
+		target := sqdoc.SaveOptions{Compression: env.Compressed, Encryption: sqdoc.EncryptionOptions{Enabled: env.Encrypted}}
+		if set["compress"] {
+			target.Compression = *compress
+		}
+		if set["encrypt"] {
+			target.Encryption.Enabled = *encrypt
+		}
+		needOld = needOld || env.Encrypted
+		needNew = needNew || target.Encryption.Enabled
+		jobs = append(jobs, reencodeJob{path: path, env: env, target: target})
+		results[i].path = path
+	}
+
+	var oldPassword, newPassword string
+	if needOld && !*dryRun {
+		if oldPassword, err = batchPassword(passwordEnv, "Current password", stderr); err != nil {
+			fmt.Fprintf(stderr, "sqdoc: %s\n", problemText(err))
+			return 2
+		}
+	}
+	if needNew && !*dryRun {
+		if pw, ok := os.LookupEnv(newPasswordEnv); ok {
+			newPassword = pw
+		} else if set["encrypt"] {
+			if newPassword, err = batchPassword(newPasswordEnv, "New password", stderr); err != nil {
+				fmt.Fprintf(stderr, "sqdoc: %s\n", problemText(err))
+				return 2
+			}
+		} else {
+			// Files that stay encrypted keep their current password.
+			newPassword = oldPassword
+		}
+		if strings.TrimSpace(newPassword) == "" {
+			fmt.Fprintln(stderr, "sqdoc: encrypted output needs a non-empty password")
+			return 2
+		}
+	}
+
+	index := make(map[string]int, len(paths))
+	for i, p := range paths {
+		index[p] = i
+	}
+	queue := make(chan reencodeJob)
+	var wg sync.WaitGroup
+	for w := 0; w < *workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range queue {
+				job.target.Encryption.Password = newPassword
+				results[index[job.path]] = reencodeFile(job, oldPassword, *dryRun)
+			}
+		}()
+	}
+	for _, job := range jobs {
+		queue <- job
+	}
+	close(queue)
+	wg.Wait()
+
+	return reportReencode(results, *dryRun, stdout)
+}
+
+func reencodeFile(job reencodeJob, password string, dryRun bool) reencodeResult {
+	res := reencodeResult{path: job.path, from: envelopeSummary(job.env), to: targetSummary(job.target)}
+	if st, err := os.Stat(job.path); err == nil {
+		res.sizeIn = st.Size()
+	}
+	if dryRun {
+		return res
+	}
+
+	lock, err := sqdoc.AcquireLock(job.path)
+	if err != nil {
+		var locked *sqdoc.LockedError
+		if errors.As(err, &locked) {
+			res.skipped = true
+			res.skipCause = "locked by " + locked.Info.Owner()
+			return res
+		}
+		res.err = err
+		return res
+	}
+	defer lock.Release()
+
+	doc, err := sqdoc.LoadWithOptions(job.path, sqdoc.LoadOptions{Password: password})
+	if err != nil {
+		res.err = err
+		return res
+	}
+	if err := sqdoc.SaveWithOptions(job.path, doc, job.target); err != nil {
+		_ = os.Remove(job.path + ".tmp")
+		res.err = err
+		return res
+	}
+	if st, err := os.Stat(job.path); err == nil {
+		res.sizeOut = st.Size()
+	}
+	return res
+}
+
+func reportReencode(results []reencodeResult, dryRun bool, stdout io.Writer) int {
+	var done, skipped, failed int
+	for _, r := range results {
+		switch {
+		case r.err != nil:
+			failed++
+			fmt.Fprintf(stdout, "FAIL  %s: %s\n", r.path, problemText(r.err))
+		case r.skipped:
+			skipped++
+			fmt.Fprintf(stdout, "SKIP  %s: %s\n", r.path, r.skipCause)
+		case dryRun:
+			done++
+			fmt.Fprintf(stdout, "WOULD %s: %s -> %s\n", r.path, r.from, r.to)
+		default:
+			done++
+			fmt.Fprintf(stdout, "OK    %s: %s -> %s (%d -> %d bytes)\n", r.path, r.from, r.to, r.sizeIn, r.sizeOut)
+		}
+	}
+	verb := "rewritten"
+	if dryRun {
+		verb = "would be rewritten"
+	}
+	fmt.Fprintf(stdout, "%d %s, %d skipped, %d failed\n", done, verb, skipped, failed)
+	if failed > 0 || skipped > 0 {
+		return 1
+	}
+	return 0
+}
+
+func targetSummary(opts sqdoc.SaveOptions) string {
+	return envelopeSummary(sqdoc.EnvelopeInfo{
+		Wrapped:     opts.Compression || opts.Encryption.Enabled,
+		Compressed:  opts.Compression,
+		Encrypted:   opts.Encryption.Enabled,
+		EnvelopeVer: 1,
+	})
+}
+
+// batchPassword reads one password for the whole batch so a folder of
+// encrypted files only prompts once.
+func batchPassword(envName, prompt string, stderr io.Writer) (string, error) {
+	if pw, ok := os.LookupEnv(envName); ok {
+		return pw, nil
+	}
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return "", fmt.Errorf("%w (set %s)", sqdoc.ErrPasswordRequired, envName)
+	}
+	fmt.Fprintf(stderr, "%s: ", prompt)
+	pw, err := term.ReadPassword(fd)
+	fmt.Fprintln(stderr)
+	if err != nil {
+		return "", err
+	}
+	return string(pw), nil
+}
+
+// expandDocumentArgs resolves each argument as a glob; directories are
+// walked for *.sqdoc files. Results are sorted and de-duplicated. Paths that
+// cannot be read are still listed, with their error in unreadable, so they
+// fail on their own instead of stopping the batch.
+func expandDocumentArgs(args []string) (paths []string, unreadable map[string]error, err error) {
+	seen := map[string]bool{}
+	unreadable = map[string]error{}
+	add := func(p string, err error) {
+		p = filepath.Clean(p)
+		if !seen[p] {
+			seen[p] = true
+			paths = append(paths, p)
+		}
+		if err != nil && unreadable[p] == nil {
+			unreadable[p] = err
+		}
+	}
+	for _, arg := range args {
+		matches, err := filepath.Glob(arg)
+		if err != nil {
+			return nil, nil, fmt.Errorf("bad pattern %q: %w", arg, err)
+		}
+		if matches == nil {
+			matches = []string{arg}
+		}
+		for _, m := range matches {
+			st, err := os.Stat(m)
+			if err != nil || !st.IsDir() {
+				add(m, err)
+				continue
+			}
+			_ = filepath.WalkDir(m, func(p string, d iofs.DirEntry, err error) error {
+				if err != nil {
+					// Unreadable folders are reported and skipped.
+					add(p, err)
+					return nil
+				}
+				if !d.IsDir() && strings.EqualFold(filepath.Ext(p), ".sqdoc") {
+					add(p, nil)
+				}
+				return nil
+			})
+		}
+	}
+	sort.Strings(paths)
+	return paths, unreadable, nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"sqdoc/pkg/sqdoc"
+)
+
+func writeTestDoc(t *testing.T, path string, paragraphs ...string) {
+	t.Helper()
+	doc := sqdoc.NewDocument("Alex", "Draft")
+	for i, p := range paragraphs {
+		doc.Blocks = append(doc.Blocks, sqdoc.Block{
+			ID:   uint64(i + 1),
+			Kind: sqdoc.BlockKindText,
+			Text: &sqdoc.TextBlock{
+				UTF8: []byte(p),
+				Runs: []sqdoc.StyleRun{{Start: 0, End: uint32(len(p)), Attr: sqdoc.StyleAttr{FontSizePt: 12}}},
+			},
+		})
+	}
+	if err := sqdoc.Save(path, doc); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExpandDocumentArgsKeepsUnreadablePaths(t *testing.T) {
+	dir := t.TempDir()
+	doc := filepath.Join(dir, "a.sqdoc")
+	writeTestDoc(t, doc, "hello")
+	if err := os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("skip"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	missing := filepath.Join(dir, "missing.sqdoc")
+
+	paths, unreadable, err := expandDocumentArgs([]string{dir, missing, doc})
+	if err != nil {
+		t.Fatalf("expand failed: %v", err)
+	}
+	if want := []string{doc, missing}; strings.Join(paths, "|") != strings.Join(want, "|") {
+		t.Fatalf("paths = %q, want %q", paths, want)
+	}
+	if unreadable[missing] == nil || unreadable[doc] != nil {
+		t.Fatalf("unreadable = %v, want only %s", unreadable, missing)
+	}
+
+	if _, _, err := expandDocumentArgs([]string{"[bad"}); err == nil {
+		t.Fatal("expected a bad pattern to fail")
+	}
+}
+
+func TestRunReencode(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     func(dir string) []string
+		want     int
+		stdout   []string
+		stderr   string
+		compress bool
+	}{
+		{
+			name:     "rewrites",
+			args:     func(dir string) []string { return []string{"-compress", "-j", "1", dir} },
+			want:     0,
+			stdout:   []string{"OK    ", "-> secure v1, compressed", "1 rewritten, 0 skipped, 0 failed"},
+			compress: true,
+		},
+		{
+			name:   "dry run",
+			args:   func(dir string) []string { return []string{"-n", "-compress", filepath.Join(dir, "*.sqdoc")} },
+			want:   0,
+			stdout: []string{"WOULD ", "plain -> secure v1, compressed", "1 would be rewritten, 0 skipped, 0 failed"},
+		},
+		{
+			name:     "missing file fails alone",
+			args:     func(dir string) []string { return []string{"-compress", filepath.Join(dir, "gone.sqdoc"), dir} },
+			want:     1,
+			stdout:   []string{"FAIL  ", "gone.sqdoc: ", "OK    ", "1 rewritten, 0 skipped, 1 failed"},
+			compress: true,
+		},
+		{
+			name:   "bad pattern",
+			args:   func(dir string) []string { return []string{"[bad"} },
+			want:   2,
+			stderr: "bad pattern",
+		},
+		{
+			name:   "no arguments",
+			args:   func(dir string) []string { return nil },
+			want:   2,
+			stderr: "usage: sqdoc reencode",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			doc := filepath.Join(dir, "a.sqdoc")
+			writeTestDoc(t, doc, "hello")
+
+			var stdout, stderr bytes.Buffer
+			if got := runReencode(tt.args(dir), &stdout, &stderr); got != tt.want {
+				t.Fatalf("exit = %d, want %d\nstdout:\n%s\nstderr:\n%s", got, tt.want, stdout.String(), stderr.String())
+			}
+			for _, want := range tt.stdout {
+				if !strings.Contains(stdout.String(), want) {
+					t.Errorf("stdout missing %q:\n%s", want, stdout.String())
+				}
+			}
+			if !strings.Contains(stderr.String(), tt.stderr) {
+				t.Errorf("stderr missing %q:\n%s", tt.stderr, stderr.String())
+			}
+			env, err := sqdoc.InspectEnvelope(doc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if env.Compressed != tt.compress {
+				t.Errorf("compressed = %t, want %t", env.Compressed, tt.compress)
+			}
+		})
+	}
+}
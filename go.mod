@@ -6,9 +6,12 @@ require (
 	github.com/atotto/clipboard v0.1.4
 	github.com/hajimehoshi/ebiten/v2 v2.9.8
 	github.com/sqweek/dialog v0.0.0-20260123140253-64c163d53aac
+	github.com/yuin/goldmark v1.8.2
 	golang.design/x/clipboard v0.7.1
 	golang.org/x/crypto v0.48.0
 	golang.org/x/image v0.31.0
+	golang.org/x/net v0.50.0
+	golang.org/x/term v0.40.0
 )
 
 require (
@@ -2,8 +2,6 @@ package app
 
 import (
 	"bytes"
-	"encoding/base64"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"image"
@@ -25,6 +23,7 @@ import (
 	"unicode/utf8"
 
 	"sqdoc/internal/editor"
+	"sqdoc/internal/fonts"
 	"sqdoc/internal/render"
 	"sqdoc/internal/ui"
 	"sqdoc/pkg/sqdoc"
@@ -57,6 +56,8 @@ type snapshot struct {
 	doc          *sqdoc.Document
 	currentBlock int
 	caretByte    int
+	// cellRow and cellCol are the edited cell when the caret is in a table.
+	cellRow, cellCol int
 }
 
 type rect struct {
@@ -98,6 +99,10 @@ type documentTab struct {
 	pagedMode           bool
 	paragraphGap        int
 	preferredFontFamily sqdoc.FontFamily
+
+	disk     diskWatch
+	docLock  *sqdoc.Lock
+	readOnly bool
 }
 
 type colorSwatch struct {
@@ -122,6 +127,10 @@ type lineSegment struct {
 	imagePath string
 	imageW    int
 	imageH    int
+	// stretched marks a space widened to justify its line.
+	stretched bool
+	// rise lifts note anchors above the baseline.
+	rise int
 }
 
 type lineLayout struct {
@@ -137,6 +146,16 @@ type lineLayout struct {
 	height    int
 	ascent    int
 	width     int
+	// marker is the list label drawn before the first line of a list item.
+	marker     string
+	markerFace font.Face
+	markerAttr sqdoc.StyleAttr
+	markerDocX int
+	// inCell marks lines of a table cell, at row and col of the table.
+	inCell   bool
+	row, col int
+	// page is the page the line is on in paged mode, from 0.
+	page int
 }
 
 type inlineImageToken struct {
@@ -194,20 +213,20 @@ type fontBank struct {
 func newFontBank() fontBank {
 	bank := fontBank{cache: map[fontKey]font.Face{}}
 
-	bank.sansRegular = parseFontBytes(fontSansRegular, goregular.TTF)
-	bank.sansBold = parseFontBytes(fontSansBold, gobold.TTF)
-	bank.sansItalic = parseFontBytes(fontSansItalic, goitalic.TTF)
-	bank.sansBoldItalic = parseFontBytes(fontSansBoldItalic, gobolditalic.TTF)
+	bank.sansRegular = parseFontBytes(fonts.SansRegular, goregular.TTF)
+	bank.sansBold = parseFontBytes(fonts.SansBold, gobold.TTF)
+	bank.sansItalic = parseFontBytes(fonts.SansItalic, goitalic.TTF)
+	bank.sansBoldItalic = parseFontBytes(fonts.SansBoldItalic, gobolditalic.TTF)
 
-	bank.serifRegular = parseFontBytes(fontSerifRegular, gomedium.TTF)
-	bank.serifBold = parseFontBytes(fontSerifBold, gomedium.TTF)
-	bank.serifItalic = parseFontBytes(fontSerifItalic, gomediumitalic.TTF)
-	bank.serifBoldItalic = parseFontBytes(fontSerifBoldItalic, gomediumitalic.TTF)
+	bank.serifRegular = parseFontBytes(fonts.SerifRegular, gomedium.TTF)
+	bank.serifBold = parseFontBytes(fonts.SerifBold, gomedium.TTF)
+	bank.serifItalic = parseFontBytes(fonts.SerifItalic, gomediumitalic.TTF)
+	bank.serifBoldItalic = parseFontBytes(fonts.SerifBoldItalic, gomediumitalic.TTF)
 
-	bank.monoRegular = parseFontBytes(fontMonoRegular, gomono.TTF)
-	bank.monoBold = parseFontBytes(fontMonoBold, gomonobold.TTF)
-	bank.monoItalic = parseFontBytes(fontMonoItalic, gomonoitalic.TTF)
-	bank.monoBoldItalic = parseFontBytes(fontMonoBoldItalic, gomonobolditalic.TTF)
+	bank.monoRegular = parseFontBytes(fonts.MonoRegular, gomono.TTF)
+	bank.monoBold = parseFontBytes(fonts.MonoBold, gomonobold.TTF)
+	bank.monoItalic = parseFontBytes(fonts.MonoItalic, gomonoitalic.TTF)
+	bank.monoBoldItalic = parseFontBytes(fonts.MonoBoldItalic, gomonobolditalic.TTF)
 
 	return bank
 }
@@ -253,6 +272,7 @@ type App struct {
 	undoHistory []snapshot
 	redoHistory []snapshot
 	maxHistory  int
+	docRevision uint64
 
 	topActions      []actionButton
 	tabActions      []actionButton
@@ -266,9 +286,23 @@ type App struct {
 	contentRect     rect
 	dataMapRect     rect
 	lineLayouts     []lineLayout
+	cellLayouts     []cellLayout
+	codeLayouts     []codeLayout
+	// noteRules are the document y of the rules above the notes.
+	noteRules       []int
+	pages           pageView
 	dataMapLabels   []dataMapLabel
 	showColorPicker bool
 	showDataMap     bool
+	find            findBar
+	link            linkDialog
+	bookmark        bookmarkDialog
+	pageSetup       pageSetupDialog
+	crossRef        crossRefDialog
+	outline         outlinePanel
+	comments        commentPanel
+	comment         commentDialog
+	refsKey         refsKey
 
 	fontInputRect   rect
 	fontInputActive bool
@@ -284,6 +318,41 @@ type App struct {
 	insertMenuRect      rect
 	insertImageFileRect rect
 	insertImageClipRect rect
+	insertLinkRect      rect
+	insertBookmarkRect  rect
+	insertCrossRefRect  rect
+	insertFootnoteRect  rect
+	insertEndnoteRect   rect
+	insertCommentRect   rect
+
+	showConvertMenu  bool
+	convertMenuRect  rect
+	convertMenuItems []convertMenuItem
+
+	showStyleMenu  bool
+	styleMenuRect  rect
+	styleMenuItems []styleMenuItem
+
+	showParagraphMenu  bool
+	paragraphMenuRect  rect
+	paragraphMenuItems []menuItem
+
+	showListMenu  bool
+	listMenuRect  rect
+	listMenuItems []menuItem
+
+	showTableMenu   bool
+	tableMenuRect   rect
+	tableMenuItems  []menuItem
+	showCodeMenu    bool
+	codeMenuRect    rect
+	codeMenuItems   []menuItem
+	showReviewMenu  bool
+	reviewMenuRect  rect
+	reviewMenuItems []menuItem
+	showPageMenu    bool
+	pageMenuRect    rect
+	pageMenuItems   []menuItem
 
 	showEncryption        bool
 	encryptionPanel       rect
@@ -315,6 +384,14 @@ type App struct {
 	passwordPromptError   string
 	passwordPromptFocused bool
 
+	disk               diskWatch
+	docLock            *sqdoc.Lock
+	readOnly           bool
+	externalBannerRect rect
+	externalReloadRect rect
+	externalMergeRect  rect
+	externalKeepRect   rect
+
 	scrollX float64
 	scrollY float64
 	maxX    float64
@@ -337,6 +414,7 @@ type App struct {
 	imageClipboardReady    bool
 	imageClipboardInitDone bool
 	pendingFollowCaret     bool
+	lastCopy               clipboardFlavours
 
 	selectedImageValid bool
 	selectedImage      imageHit
@@ -402,6 +480,7 @@ func (a *App) Run() error {
 	ebiten.SetWindowResizingMode(ebiten.WindowResizingModeEnabled)
 	ebiten.SetWindowSizeLimits(900, 560, -1, -1)
 	ebiten.MaximizeWindow()
+	defer a.releaseAllDocumentLocks()
 	if err := ebiten.RunGame(a); err != nil {
 		return fmt.Errorf("run game loop: %w", err)
 	}
@@ -431,16 +510,70 @@ func (a *App) Update() error {
 		a.layoutHelpDialogBounds(winW, winH)
 	}
 	a.handleDroppedImages()
+	a.pollExternalChanges()
+	a.refreshDocumentLocks()
 
 	if inpututil.IsKeyJustPressed(ebiten.KeyEscape) {
 		if a.showPasswordPrompt {
 			a.closePasswordPrompt()
 			return nil
 		}
+		if a.link.visible {
+			a.closeLinkDialog()
+			return nil
+		}
+		if a.bookmark.visible {
+			a.closeBookmarkDialog()
+			return nil
+		}
+		if a.pageSetup.visible {
+			a.closePageSetupDialog()
+			return nil
+		}
+		if a.crossRef.visible {
+			a.closeCrossRefDialog()
+			return nil
+		}
+		if a.comment.visible {
+			a.closeCommentDialog()
+			return nil
+		}
 		if a.showInsertMenu {
 			a.showInsertMenu = false
 			return nil
 		}
+		if a.showConvertMenu {
+			a.showConvertMenu = false
+			return nil
+		}
+		if a.showStyleMenu {
+			a.showStyleMenu = false
+			return nil
+		}
+		if a.showParagraphMenu {
+			a.showParagraphMenu = false
+			return nil
+		}
+		if a.showListMenu {
+			a.showListMenu = false
+			return nil
+		}
+		if a.showTableMenu {
+			a.showTableMenu = false
+			return nil
+		}
+		if a.showCodeMenu {
+			a.showCodeMenu = false
+			return nil
+		}
+		if a.showReviewMenu {
+			a.showReviewMenu = false
+			return nil
+		}
+		if a.showPageMenu {
+			a.showPageMenu = false
+			return nil
+		}
 		if a.showTabChooser {
 			a.showTabChooser = false
 			return nil
@@ -466,22 +599,113 @@ func (a *App) Update() error {
 			a.showColorPicker = false
 			return nil
 		}
+		if a.find.visible {
+			a.closeFindBar()
+			return nil
+		}
 		a.status = "Esc closes dialogs. Use Alt+F4 to exit."
 		return nil
 	}
+	if a.link.visible {
+		if inpututil.IsMouseButtonJustPressed(ebiten.MouseButtonLeft) {
+			x, y := ebiten.CursorPosition()
+			a.handleLinkDialogClick(x, y)
+		}
+		a.handleLinkDialogInput(ctrl)
+		a.clampScroll()
+		return nil
+	}
+	if a.bookmark.visible {
+		if inpututil.IsMouseButtonJustPressed(ebiten.MouseButtonLeft) {
+			x, y := ebiten.CursorPosition()
+			a.handleBookmarkDialogClick(x, y)
+		}
+		a.handleBookmarkDialogInput(ctrl)
+		return nil
+	}
+	if a.pageSetup.visible {
+		if inpututil.IsMouseButtonJustPressed(ebiten.MouseButtonLeft) {
+			x, y := ebiten.CursorPosition()
+			a.handlePageSetupDialogClick(x, y)
+		}
+		a.handlePageSetupDialogInput()
+		return nil
+	}
+	if a.crossRef.visible {
+		if inpututil.IsMouseButtonJustPressed(ebiten.MouseButtonLeft) {
+			x, y := ebiten.CursorPosition()
+			a.handleCrossRefDialogClick(x, y)
+		}
+		a.handleCrossRefDialogInput()
+		return nil
+	}
+	if a.comment.visible {
+		if inpututil.IsMouseButtonJustPressed(ebiten.MouseButtonLeft) {
+			x, y := ebiten.CursorPosition()
+			a.handleCommentDialogClick(x, y)
+		}
+		a.handleCommentDialogInput(ctrl)
+		return nil
+	}
 	if inpututil.IsKeyJustPressed(ebiten.KeyF1) {
 		a.showHelp = !a.showHelp
 	}
 	if ctrl && inpututil.IsKeyJustPressed(ebiten.KeyP) {
 		a.showDataMap = !a.showDataMap
 	}
-	if ctrl && inpututil.IsKeyJustPressed(ebiten.KeyE) {
+	if ctrl && shift && inpututil.IsKeyJustPressed(ebiten.KeyO) {
+		a.toggleOutline()
+	}
+	if ctrl && shift && inpututil.IsKeyJustPressed(ebiten.KeyE) {
 		a.showEncryption = !a.showEncryption
 		a.encryptionInputActive = a.showEncryption && a.encryptionEnabled
 	}
 	if ctrl && inpututil.IsKeyJustPressed(ebiten.KeyT) {
 		a.showTabChooser = true
 	}
+	if ctrl && alt && inpututil.IsKeyJustPressed(ebiten.KeyF) {
+		a.invokeAction("insert_footnote")
+		return nil
+	}
+	if ctrl && alt && inpututil.IsKeyJustPressed(ebiten.KeyD) {
+		a.invokeAction("insert_endnote")
+		return nil
+	}
+	if ctrl && alt && inpututil.IsKeyJustPressed(ebiten.KeyM) {
+		a.invokeAction("add_comment")
+		return nil
+	}
+	if ctrl && alt && inpututil.IsKeyJustPressed(ebiten.KeyE) {
+		a.toggleSuggesting()
+		return nil
+	}
+	if ctrl && !shift && inpututil.IsKeyJustPressed(ebiten.KeyF) {
+		a.openFindBar(false)
+		return nil
+	}
+	if ctrl && !shift && inpututil.IsKeyJustPressed(ebiten.KeyH) {
+		a.openFindBar(true)
+		return nil
+	}
+	if ctrl && !shift && inpututil.IsKeyJustPressed(ebiten.KeyK) {
+		a.openLinkDialog()
+		return nil
+	}
+	if ctrl && (inpututil.IsKeyJustPressed(ebiten.KeyEnter) || inpututil.IsKeyJustPressed(ebiten.KeyKPEnter)) {
+		a.invokeAction("insert_page_break")
+		return nil
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyF3) {
+		if !a.find.visible {
+			a.openFindBar(false)
+		}
+		if shift {
+			a.findStep(-1)
+		} else {
+			a.findStep(1)
+		}
+		return nil
+	}
 	if ctrl && shift && inpututil.IsKeyJustPressed(ebiten.KeyI) {
 		a.invokeAction("insert_image_file")
 		return nil
@@ -514,6 +738,70 @@ func (a *App) Update() error {
 			}
 		}
 	}
+	if a.showConvertMenu {
+		if inpututil.IsMouseButtonJustPressed(ebiten.MouseButtonLeft) {
+			x, y := ebiten.CursorPosition()
+			if a.handleConvertMenuClick(x, y) {
+				return nil
+			}
+		}
+	}
+	if a.showStyleMenu {
+		if inpututil.IsMouseButtonJustPressed(ebiten.MouseButtonLeft) {
+			x, y := ebiten.CursorPosition()
+			if a.handleStyleMenuClick(x, y) {
+				return nil
+			}
+		}
+	}
+	if a.showParagraphMenu {
+		if inpututil.IsMouseButtonJustPressed(ebiten.MouseButtonLeft) {
+			x, y := ebiten.CursorPosition()
+			if a.handleParagraphMenuClick(x, y) {
+				return nil
+			}
+		}
+	}
+	if a.showListMenu {
+		if inpututil.IsMouseButtonJustPressed(ebiten.MouseButtonLeft) {
+			x, y := ebiten.CursorPosition()
+			if a.handleListMenuClick(x, y) {
+				return nil
+			}
+		}
+	}
+	if a.showTableMenu {
+		if inpututil.IsMouseButtonJustPressed(ebiten.MouseButtonLeft) {
+			x, y := ebiten.CursorPosition()
+			if a.handleTableMenuClick(x, y) {
+				return nil
+			}
+		}
+	}
+	if a.showCodeMenu {
+		if inpututil.IsMouseButtonJustPressed(ebiten.MouseButtonLeft) {
+			x, y := ebiten.CursorPosition()
+			if a.handleCodeMenuClick(x, y) {
+				return nil
+			}
+		}
+	}
+	if a.showReviewMenu {
+		if inpututil.IsMouseButtonJustPressed(ebiten.MouseButtonLeft) {
+			x, y := ebiten.CursorPosition()
+			if a.handleReviewMenuClick(x, y) {
+				return nil
+			}
+		}
+	}
+	if a.showPageMenu {
+		if inpututil.IsMouseButtonJustPressed(ebiten.MouseButtonLeft) {
+			x, y := ebiten.CursorPosition()
+			if a.handlePageMenuClick(x, y) {
+				return nil
+			}
+		}
+	}
 	if a.showHelp {
 		if inpututil.IsMouseButtonJustPressed(ebiten.MouseButtonLeft) {
 			x, y := ebiten.CursorPosition()
@@ -531,12 +819,15 @@ func (a *App) Update() error {
 	}
 
 	wheelX, wheelY := ebiten.Wheel()
-	if shift && wheelY != 0 && !a.pagedMode {
+	if a.scrollOutline(wheelY) || a.scrollComments(wheelY) {
+		wheelY = 0
+	}
+	if shift && wheelY != 0 {
 		a.scrollX -= wheelY * 48
 	} else if wheelY != 0 {
 		a.scrollY -= wheelY * 42
 	}
-	if wheelX != 0 && !a.pagedMode {
+	if wheelX != 0 {
 		a.scrollX -= wheelX * 48
 	}
 	a.clampScroll()
@@ -561,6 +852,13 @@ func (a *App) Update() error {
 			}
 			return nil
 		}
+		if a.handleExternalBannerClick(x, y) {
+			return nil
+		}
+		if a.handleFindBarClick(x, y) {
+			return nil
+		}
+		a.find.focused = false
 		if id, ok := a.actionAt(x, y); ok {
 			a.invokeAction(id)
 			return nil
@@ -574,7 +872,22 @@ func (a *App) Update() error {
 		if a.showColorPicker && !a.colorPopupRect.contains(x, y) {
 			a.showColorPicker = false
 		}
+		if a.handleOutlineClick(x, y) || a.handleCommentPanelClick(x, y) {
+			return nil
+		}
 		if a.contentRect.contains(x, y) {
+			if id := a.noteAtPoint(x, y); ctrl && id != 0 {
+				a.followNote(id)
+				return nil
+			}
+			if target := a.linkAtPoint(x, y); ctrl && target != "" {
+				a.followLink(target)
+				return nil
+			}
+			if bi, ok := a.noteNumberAt(x, y); ok && !shift {
+				a.returnFromNote(bi)
+				return nil
+			}
 			if !shift {
 				if img, ok := a.imageAtPoint(x, y); ok {
 					a.selectedImageValid = true
@@ -683,8 +996,7 @@ func (a *App) Update() error {
 	if inpututil.IsMouseButtonJustReleased(ebiten.MouseButtonLeft) {
 		if a.resizeImageActive {
 			a.resizeImageActive = false
-			if a.selectedImageValid && (absInt(a.resizePreviewW-a.resizeBaseW) > 1 || absInt(a.resizePreviewH-a.resizeBaseH) > 1) {
-				a.pushUndoSnapshot()
+			if a.selectedImageValid && (absInt(a.resizePreviewW-a.resizeBaseW) > 1 || absInt(a.resizePreviewH-a.resizeBaseH) > 1) && a.beginEdit() {
 				if err := a.updateSelectedImageTokenSize(a.resizePreviewW, a.resizePreviewH); err != nil {
 					a.status = "Image resize failed: " + err.Error()
 				} else {
@@ -694,8 +1006,7 @@ func (a *App) Update() error {
 		}
 		if a.dragImageActive {
 			a.dragImageActive = false
-			if a.selectedImageValid {
-				a.pushUndoSnapshot()
+			if a.selectedImageValid && a.beginEdit() {
 				if err := a.moveSelectedImageToken(a.dragImageDropBlock, a.dragImageDropByte); err != nil {
 					a.status = "Image move failed: " + err.Error()
 				} else {
@@ -712,14 +1023,20 @@ func (a *App) Update() error {
 		a.clampScroll()
 		return nil
 	}
+	if a.handleFindInput(ctrl, shift, alt) {
+		a.clampScroll()
+		return nil
+	}
 
+	// recordMutation snapshots the document before the first edit of the
+	// frame and reports whether edits may go ahead.
 	didSnapshot := false
-	recordMutation := func() {
+	recordMutation := func() bool {
 		if didSnapshot {
-			return
+			return true
 		}
-		a.pushUndoSnapshot()
-		didSnapshot = true
+		didSnapshot = a.beginEdit()
+		return didSnapshot
 	}
 
 	if ctrl && inpututil.IsKeyJustPressed(ebiten.KeyZ) {
@@ -734,7 +1051,7 @@ func (a *App) Update() error {
 		a.invokeAction("new")
 		return nil
 	}
-	if ctrl && inpututil.IsKeyJustPressed(ebiten.KeyO) {
+	if ctrl && !shift && inpututil.IsKeyJustPressed(ebiten.KeyO) {
 		a.invokeAction("open")
 		return nil
 	}
@@ -751,16 +1068,14 @@ func (a *App) Update() error {
 	}
 	if ctrl && inpututil.IsKeyJustPressed(ebiten.KeyC) && !shift {
 		if a.state.HasSelection() {
-			if err := textclipboard.WriteAll(a.state.SelectedText()); err != nil {
+			if err := a.copySelection(); err != nil {
 				a.status = "Copy failed: " + err.Error()
 			}
 		}
 	}
 	if ctrl && inpututil.IsKeyJustPressed(ebiten.KeyX) {
-		if a.state.HasSelection() {
-			recordMutation()
-			selected := a.state.SelectedText()
-			if err := textclipboard.WriteAll(selected); err != nil {
+		if a.state.HasSelection() && recordMutation() {
+			if err := a.copySelection(); err != nil {
 				a.status = "Cut failed: " + err.Error()
 			} else {
 				a.state.DeleteSelection()
@@ -768,25 +1083,43 @@ func (a *App) Update() error {
 			}
 		}
 	}
-	if ctrl && inpututil.IsKeyJustPressed(ebiten.KeyV) {
-		insertedImage, imgErr := a.tryInsertImageFromClipboard()
-		if insertedImage {
-			if imgErr != nil {
-				a.status = "Paste image failed: " + imgErr.Error()
+	if ctrl && inpututil.IsKeyJustPressed(ebiten.KeyV) && a.editable() {
+		// Ctrl+Shift+V pastes plain text, skipping formatting and images.
+		plainOnly := shift
+		var imgErr error
+		if !plainOnly {
+			if blocks, ok := a.clipboardBlocks(); ok && recordMutation() {
+				a.snapCaretOutOfInlineImage(0)
+				a.selectedImageValid = false
+				if err := a.state.InsertBlocksAtCaret(blocks); err != nil {
+					a.status = "Paste failed: " + err.Error()
+				}
+				a.clampScroll()
+				a.ensureCaretVisible()
+				return nil
+			}
+			var insertedImage bool
+			insertedImage, imgErr = a.tryInsertImageFromClipboard()
+			if insertedImage {
+				if imgErr != nil {
+					a.status = "Paste image failed: " + imgErr.Error()
+				}
+				a.clampScroll()
+				a.ensureCaretVisible()
+				return nil
 			}
-			a.clampScroll()
-			a.ensureCaretVisible()
-			return nil
 		}
 		paste, err := textclipboard.ReadAll()
+		if plainOnly {
+			paste = stripImageTokens(paste)
+		}
 		if err != nil {
 			if imgErr != nil {
 				a.status = "Paste failed: " + imgErr.Error()
 			} else {
 				a.status = "Paste failed: " + err.Error()
 			}
-		} else if paste != "" {
-			recordMutation()
+		} else if paste != "" && recordMutation() {
 			a.snapCaretOutOfInlineImage(0)
 			a.selectedImageValid = false
 			if err := a.state.InsertTextAtCaret(paste); err != nil {
@@ -806,43 +1139,57 @@ func (a *App) Update() error {
 		a.bumpUIScale(-1)
 		a.status = fmt.Sprintf("UI scale %.0f%%", a.uiScales[a.uiScaleIdx]*100)
 	}
-	if ctrl && inpututil.IsKeyJustPressed(ebiten.KeyB) {
-		recordMutation()
+	if ctrl && inpututil.IsKeyJustPressed(ebiten.KeyB) && recordMutation() {
 		a.state.ToggleBold()
 	}
-	if ctrl && inpututil.IsKeyJustPressed(ebiten.KeyI) {
-		recordMutation()
+	if ctrl && inpututil.IsKeyJustPressed(ebiten.KeyI) && recordMutation() {
 		a.state.ToggleItalic()
 	}
-	if ctrl && inpututil.IsKeyJustPressed(ebiten.KeyU) {
-		recordMutation()
+	if ctrl && inpututil.IsKeyJustPressed(ebiten.KeyU) && recordMutation() {
 		a.state.ToggleUnderline()
 	}
-	if ctrl && inpututil.IsKeyJustPressed(ebiten.KeyPeriod) {
-		recordMutation()
+	if ctrl && inpututil.IsKeyJustPressed(ebiten.KeyPeriod) && recordMutation() {
 		a.state.IncreaseFontSize()
 	}
-	if ctrl && inpututil.IsKeyJustPressed(ebiten.KeyComma) {
-		recordMutation()
+	if ctrl && inpututil.IsKeyJustPressed(ebiten.KeyComma) && recordMutation() {
 		a.state.DecreaseFontSize()
 	}
-	if ctrl && shift && inpututil.IsKeyJustPressed(ebiten.KeyC) {
-		recordMutation()
+	if ctrl && shift && inpututil.IsKeyJustPressed(ebiten.KeyC) && recordMutation() {
 		a.state.CycleColor()
 	}
-	if ctrl && shift && inpututil.IsKeyJustPressed(ebiten.KeyH) {
-		recordMutation()
+	if ctrl && shift && inpututil.IsKeyJustPressed(ebiten.KeyH) && recordMutation() {
 		a.state.ToggleHighlight()
 	}
-	if ctrl && inpututil.IsKeyJustPressed(ebiten.KeyBackspace) {
-		recordMutation()
+	for _, k := range alignmentKeys {
+		if ctrl && !shift && inpututil.IsKeyJustPressed(k.key) && recordMutation() {
+			a.state.SetAlignment(k.align)
+		}
+	}
+	if ctrl && inpututil.IsKeyJustPressed(ebiten.KeyM) && recordMutation() {
+		if shift {
+			a.state.AdjustIndent(-indentStepPt)
+		} else {
+			a.state.AdjustIndent(indentStepPt)
+		}
+	}
+	if ctrl && shift && inpututil.IsKeyJustPressed(ebiten.Key8) && recordMutation() {
+		a.state.ToggleList(sqdoc.ListBullet)
+	}
+	if ctrl && shift && inpututil.IsKeyJustPressed(ebiten.Key7) && recordMutation() {
+		a.state.ToggleList(sqdoc.ListDecimal)
+	}
+	for _, k := range lineHeightKeys {
+		if ctrl && !shift && inpututil.IsKeyJustPressed(k.key) && recordMutation() {
+			a.state.SetLineHeight(k.percent)
+		}
+	}
+	if ctrl && inpututil.IsKeyJustPressed(ebiten.KeyBackspace) && recordMutation() {
 		if !a.deleteSelectedOrAdjacentImage(true) {
 			a.state.DeleteWordBackward()
 		}
 		followCaret = true
 	}
-	if ctrl && inpututil.IsKeyJustPressed(ebiten.KeyDelete) {
-		recordMutation()
+	if ctrl && inpututil.IsKeyJustPressed(ebiten.KeyDelete) && recordMutation() {
 		if !a.deleteSelectedOrAdjacentImage(false) {
 			a.state.DeleteWordForward()
 		}
@@ -867,24 +1214,32 @@ func (a *App) Update() error {
 		if ctrl {
 			moveWithSelection(0, func() {
 				a.state.MoveBlock(-1)
-				a.state.MoveCaretToLineStart()
+				a.state.CaretByte = 0
 			})
 		} else if alt {
 			a.scrollY -= float64(a.contentRect.h) * 0.8
 		} else {
-			moveWithSelection(0, func() { a.state.MoveBlock(-1) })
+			moveWithSelection(0, func() {
+				if !a.state.MoveCaretLine(-1) {
+					a.state.MoveBlock(-1)
+				}
+			})
 		}
 	}
 	if inpututil.IsKeyJustPressed(ebiten.KeyArrowDown) {
 		if ctrl {
 			moveWithSelection(0, func() {
 				a.state.MoveBlock(1)
-				a.state.MoveCaretToLineStart()
+				a.state.CaretByte = 0
 			})
 		} else if alt {
 			a.scrollY += float64(a.contentRect.h) * 0.8
 		} else {
-			moveWithSelection(0, func() { a.state.MoveBlock(1) })
+			moveWithSelection(0, func() {
+				if !a.state.MoveCaretLine(1) {
+					a.state.MoveBlock(1)
+				}
+			})
 		}
 	}
 	if inpututil.IsKeyJustPressed(ebiten.KeyArrowLeft) {
@@ -933,35 +1288,40 @@ func (a *App) Update() error {
 		return nil
 	}
 
-	if inpututil.IsKeyJustPressed(ebiten.KeyEnter) || inpututil.IsKeyJustPressed(ebiten.KeyKPEnter) {
-		recordMutation()
+	if (inpututil.IsKeyJustPressed(ebiten.KeyEnter) || inpututil.IsKeyJustPressed(ebiten.KeyKPEnter)) && recordMutation() {
 		a.snapCaretOutOfInlineImage(0)
 		a.selectedImageValid = false
-		if err := a.state.InsertTextAtCaret("\n"); err != nil {
-			a.status = "Insert newline failed: " + err.Error()
-		} else {
-			followCaret = true
-		}
+		a.state.SplitBlockAtCaret()
+		followCaret = true
 	}
-	if inpututil.IsKeyJustPressed(ebiten.KeyBackspace) {
-		recordMutation()
+	if inpututil.IsKeyJustPressed(ebiten.KeyBackspace) && recordMutation() {
 		if !a.deleteSelectedOrAdjacentImage(true) {
 			a.state.Backspace()
 		}
 		followCaret = true
 	}
-	if inpututil.IsKeyJustPressed(ebiten.KeyDelete) {
-		recordMutation()
+	if inpututil.IsKeyJustPressed(ebiten.KeyDelete) && recordMutation() {
 		if !a.deleteSelectedOrAdjacentImage(false) {
 			a.state.DeleteForward()
 		}
 		followCaret = true
 	}
-	if inpututil.IsKeyJustPressed(ebiten.KeyTab) {
-		recordMutation()
+	if inpututil.IsKeyJustPressed(ebiten.KeyTab) && recordMutation() {
 		a.snapCaretOutOfInlineImage(0)
 		a.selectedImageValid = false
-		_ = a.state.InsertTextAtCaret("    ")
+		if a.state.IsTable(a.state.CurrentBlock) {
+			if shift {
+				a.state.NextCell(-1)
+			} else {
+				a.state.NextCell(1)
+			}
+		} else if a.state.IsCode(a.state.CurrentBlock) {
+			a.state.IndentCode(shift)
+		} else if shift {
+			a.state.IndentList(-1)
+		} else if !a.state.IndentList(1) {
+			_ = a.state.InsertTextAtCaret("    ")
+		}
 		followCaret = true
 	}
 
@@ -969,10 +1329,15 @@ func (a *App) Update() error {
 		if r < 0x20 || !utf8.ValidRune(r) {
 			continue
 		}
-		recordMutation()
+		if !recordMutation() {
+			break
+		}
 		a.snapCaretOutOfInlineImage(0)
 		a.selectedImageValid = false
 		_ = a.state.InsertTextAtCaret(string(r))
+		if r == ' ' {
+			a.state.AutoFormatList()
+		}
 		followCaret = true
 	}
 
@@ -1133,13 +1498,7 @@ func (a *App) handleEncryptionClick(x, y int) bool {
 		return true
 	}
 	if a.encryptionPagedRect.contains(x, y) {
-		a.pagedMode = !a.pagedMode
-		if a.pagedMode {
-			a.scrollX = 0
-			a.status = "Paged mode enabled"
-		} else {
-			a.status = "Paged mode disabled"
-		}
+		a.togglePagedMode()
 		return true
 	}
 	if a.encryptionGapDownRect.contains(x, y) {
@@ -1158,8 +1517,7 @@ func (a *App) handleEncryptionClick(x, y int) bool {
 	}
 	if a.encryptionFontSans.contains(x, y) {
 		a.preferredFontFamily = sqdoc.FontFamilySans
-		if a.state != nil {
-			a.pushUndoSnapshot()
+		if a.state != nil && a.beginEdit() {
 			a.state.SetFontFamily(sqdoc.FontFamilySans)
 		}
 		a.status = "Font family: Sans Serif"
@@ -1167,8 +1525,7 @@ func (a *App) handleEncryptionClick(x, y int) bool {
 	}
 	if a.encryptionFontSerif.contains(x, y) {
 		a.preferredFontFamily = sqdoc.FontFamilySerif
-		if a.state != nil {
-			a.pushUndoSnapshot()
+		if a.state != nil && a.beginEdit() {
 			a.state.SetFontFamily(sqdoc.FontFamilySerif)
 		}
 		a.status = "Font family: Serif"
@@ -1176,8 +1533,7 @@ func (a *App) handleEncryptionClick(x, y int) bool {
 	}
 	if a.encryptionFontMono.contains(x, y) {
 		a.preferredFontFamily = sqdoc.FontFamilyMonospace
-		if a.state != nil {
-			a.pushUndoSnapshot()
+		if a.state != nil && a.beginEdit() {
 			a.state.SetFontFamily(sqdoc.FontFamilyMonospace)
 		}
 		a.status = "Font family: Monospace"
@@ -1233,6 +1589,7 @@ func (a *App) submitPasswordPrompt() {
 		return
 	}
 
+	a.takeDocumentLock(path)
 	a.state = editor.NewState(doc)
 	a.filePath = path
 	a.status = "Opened " + filepath.Base(path)
@@ -1243,6 +1600,10 @@ func (a *App) submitPasswordPrompt() {
 	a.encryptionPassword = a.passwordPromptInput
 	a.applyEnvelopeSettings(env)
 	a.applyDocumentMetadataSettings(doc.Metadata)
+	a.recordDiskState(path, doc)
+	if a.readOnly {
+		a.status += " (read-only)"
+	}
 	a.closePasswordPrompt()
 }
 
@@ -1276,8 +1637,9 @@ func (a *App) applyDocumentMetadataSettings(meta sqdoc.Metadata) {
 func (a *App) handleToolbarClick(x, y int) bool {
 	for _, sw := range a.colorSwatches {
 		if sw.r.contains(x, y) {
-			a.pushUndoSnapshot()
-			a.state.SetColor(sw.value)
+			if a.beginEdit() {
+				a.state.SetColor(sw.value)
+			}
 			a.showColorPicker = false
 			a.status = "Applied text color"
 			return true
@@ -1317,7 +1679,9 @@ func (a *App) applyFontInput() {
 	if sz > 96 {
 		sz = 96
 	}
-	a.pushUndoSnapshot()
+	if !a.beginEdit() {
+		return
+	}
 	a.state.SetFontSize(uint16(sz))
 	a.status = fmt.Sprintf("Font size set to %dpt", sz)
 }
@@ -1326,6 +1690,12 @@ func (a *App) layoutInsertMenuBounds() {
 	a.insertMenuRect = rect{}
 	a.insertImageFileRect = rect{}
 	a.insertImageClipRect = rect{}
+	a.insertLinkRect = rect{}
+	a.insertBookmarkRect = rect{}
+	a.insertCrossRefRect = rect{}
+	a.insertFootnoteRect = rect{}
+	a.insertEndnoteRect = rect{}
+	a.insertCommentRect = rect{}
 	if !a.showInsertMenu {
 		return
 	}
@@ -1346,12 +1716,18 @@ func (a *App) layoutInsertMenuBounds() {
 	if rowH < 24 {
 		rowH = 24
 	}
-	h := rowH*2 + 8
+	h := rowH*8 + 8
 	x := anchor.x
 	y := anchor.y + anchor.h + 2
 	a.insertMenuRect = rect{x: x, y: y, w: w, h: h}
 	a.insertImageFileRect = rect{x: x + 4, y: y + 4, w: w - 8, h: rowH}
 	a.insertImageClipRect = rect{x: x + 4, y: y + 4 + rowH, w: w - 8, h: rowH}
+	a.insertLinkRect = rect{x: x + 4, y: y + 4 + rowH*2, w: w - 8, h: rowH}
+	a.insertBookmarkRect = rect{x: x + 4, y: y + 4 + rowH*3, w: w - 8, h: rowH}
+	a.insertCrossRefRect = rect{x: x + 4, y: y + 4 + rowH*4, w: w - 8, h: rowH}
+	a.insertFootnoteRect = rect{x: x + 4, y: y + 4 + rowH*5, w: w - 8, h: rowH}
+	a.insertEndnoteRect = rect{x: x + 4, y: y + 4 + rowH*6, w: w - 8, h: rowH}
+	a.insertCommentRect = rect{x: x + 4, y: y + 4 + rowH*7, w: w - 8, h: rowH}
 }
 
 func (a *App) drawInsertMenu(screen *ebiten.Image, face font.Face) {
@@ -1379,6 +1755,13 @@ func (a *App) drawInsertMenu(screen *ebiten.Image, face font.Face) {
 		text.Draw(screen, label, face, rr.x+10, rr.y+rr.h-8, color.RGBA{R: 42, G: 58, B: 82, A: 255})
 	}
 	drawMenuItem(a.insertImageFileRect, "Image from file...")
+	drawMenuItem(a.insertImageClipRect, "Image from clipboard")
+	drawMenuItem(a.insertLinkRect, "Link...  Ctrl+K")
+	drawMenuItem(a.insertBookmarkRect, "Bookmark...")
+	drawMenuItem(a.insertCrossRefRect, "Cross-reference...")
+	drawMenuItem(a.insertFootnoteRect, "Footnote  Ctrl+Alt+F")
+	drawMenuItem(a.insertEndnoteRect, "Endnote  Ctrl+Alt+D")
+	drawMenuItem(a.insertCommentRect, "Comment...  Ctrl+Alt+M")
 }
 
 func (a *App) handleInsertMenuClick(x, y int) bool {
@@ -1403,6 +1786,36 @@ func (a *App) handleInsertMenuClick(x, y int) bool {
 		a.invokeAction("insert_image_clipboard")
 		return true
 	}
+	if a.insertLinkRect.contains(x, y) {
+		a.showInsertMenu = false
+		a.invokeAction("insert_link")
+		return true
+	}
+	if a.insertBookmarkRect.contains(x, y) {
+		a.showInsertMenu = false
+		a.invokeAction("insert_bookmark")
+		return true
+	}
+	if a.insertCrossRefRect.contains(x, y) {
+		a.showInsertMenu = false
+		a.invokeAction("insert_cross_ref")
+		return true
+	}
+	if a.insertFootnoteRect.contains(x, y) {
+		a.showInsertMenu = false
+		a.invokeAction("insert_footnote")
+		return true
+	}
+	if a.insertEndnoteRect.contains(x, y) {
+		a.showInsertMenu = false
+		a.invokeAction("insert_endnote")
+		return true
+	}
+	if a.insertCommentRect.contains(x, y) {
+		a.showInsertMenu = false
+		a.invokeAction("add_comment")
+		return true
+	}
 	return true
 }
 
@@ -1435,6 +1848,8 @@ func (a *App) invokeAction(id string) {
 		a.state = editor.NewState(doc)
 		_ = a.state.UpdateCurrentText("")
 		a.state.SetFontFamily(doc.Metadata.PreferredFontFamily)
+		a.releaseDocumentLock()
+		a.disk = diskWatch{}
 		a.filePath = ""
 		a.status = "New document"
 		a.scrollX, a.scrollY = 0, 0
@@ -1451,6 +1866,30 @@ func (a *App) invokeAction(id string) {
 		}
 	case "insert":
 		a.showInsertMenu = !a.showInsertMenu
+		a.showConvertMenu = false
+		a.showStyleMenu = false
+		a.showParagraphMenu = false
+		a.showListMenu = false
+		a.showTableMenu = false
+		a.showCodeMenu = false
+		a.showReviewMenu = false
+		a.showPageMenu = false
+	case "convert":
+		a.showConvertMenu = !a.showConvertMenu
+		a.showInsertMenu = false
+		a.showStyleMenu = false
+		a.showParagraphMenu = false
+		a.showListMenu = false
+		a.showTableMenu = false
+		a.showCodeMenu = false
+		a.showReviewMenu = false
+		a.showPageMenu = false
+	case "style_menu":
+		a.showStyleMenu = !a.showStyleMenu
+		a.showParagraphMenu = false
+		a.showListMenu = false
+		a.showInsertMenu = false
+		a.showConvertMenu = false
 	case "new_tab":
 		a.showTabChooser = true
 	case "save":
@@ -1475,11 +1914,17 @@ func (a *App) invokeAction(id string) {
 		a.showHelp = !a.showHelp
 	case "data_map":
 		a.showDataMap = !a.showDataMap
+	case "outline":
+		a.toggleOutline()
+	case "comments":
+		a.toggleComments()
 	case "encryption":
 		a.showEncryption = !a.showEncryption
 		a.encryptionInputActive = a.showEncryption && a.encryptionEnabled
 	case "bold":
-		a.pushUndoSnapshot()
+		if !a.beginEdit() {
+			return
+		}
 		a.state.ToggleBold()
 		if a.state.CurrentStyleAttr().Bold {
 			a.status = "Bold on"
@@ -1487,7 +1932,9 @@ func (a *App) invokeAction(id string) {
 			a.status = "Bold off"
 		}
 	case "italic":
-		a.pushUndoSnapshot()
+		if !a.beginEdit() {
+			return
+		}
 		a.state.ToggleItalic()
 		if a.state.CurrentStyleAttr().Italic {
 			a.status = "Italic on"
@@ -1495,7 +1942,9 @@ func (a *App) invokeAction(id string) {
 			a.status = "Italic off"
 		}
 	case "underline":
-		a.pushUndoSnapshot()
+		if !a.beginEdit() {
+			return
+		}
 		a.state.ToggleUnderline()
 		if a.state.CurrentStyleAttr().Underline {
 			a.status = "Underline on"
@@ -1503,7 +1952,9 @@ func (a *App) invokeAction(id string) {
 			a.status = "Underline off"
 		}
 	case "highlight":
-		a.pushUndoSnapshot()
+		if !a.beginEdit() {
+			return
+		}
 		a.state.ToggleHighlight()
 		if a.state.CurrentStyleAttr().Highlight {
 			a.status = "Highlight on"
@@ -1511,11 +1962,15 @@ func (a *App) invokeAction(id string) {
 			a.status = "Highlight off"
 		}
 	case "font_down":
-		a.pushUndoSnapshot()
+		if !a.beginEdit() {
+			return
+		}
 		a.state.DecreaseFontSize()
 		a.status = fmt.Sprintf("Font size %dpt", a.state.CurrentStyleAttr().FontSizePt)
 	case "font_up":
-		a.pushUndoSnapshot()
+		if !a.beginEdit() {
+			return
+		}
 		a.state.IncreaseFontSize()
 		a.status = fmt.Sprintf("Font size %dpt", a.state.CurrentStyleAttr().FontSizePt)
 	case "font_edit":
@@ -1524,20 +1979,82 @@ func (a *App) invokeAction(id string) {
 	case "color_toggle":
 		a.showColorPicker = !a.showColorPicker
 	case "font_sans":
-		a.pushUndoSnapshot()
+		if !a.beginEdit() {
+			return
+		}
 		a.preferredFontFamily = sqdoc.FontFamilySans
 		a.state.SetFontFamily(sqdoc.FontFamilySans)
 		a.status = "Font family: Sans Serif"
 	case "font_serif":
-		a.pushUndoSnapshot()
+		if !a.beginEdit() {
+			return
+		}
 		a.preferredFontFamily = sqdoc.FontFamilySerif
 		a.state.SetFontFamily(sqdoc.FontFamilySerif)
 		a.status = "Font family: Serif"
 	case "font_mono":
-		a.pushUndoSnapshot()
+		if !a.beginEdit() {
+			return
+		}
 		a.preferredFontFamily = sqdoc.FontFamilyMonospace
 		a.state.SetFontFamily(sqdoc.FontFamilyMonospace)
 		a.status = "Font family: Monospace"
+	case "align_left", "align_center", "align_right", "align_justify":
+		if !a.beginEdit() {
+			return
+		}
+		for _, k := range alignmentKeys {
+			if k.id == id {
+				a.state.SetAlignment(k.align)
+				a.status = "Aligned " + k.name
+			}
+		}
+	case "paragraph_menu":
+		a.showParagraphMenu = !a.showParagraphMenu
+		a.showStyleMenu = false
+		a.showListMenu = false
+		a.showInsertMenu = false
+		a.showConvertMenu = false
+	case "list_menu":
+		a.showListMenu = !a.showListMenu
+		a.showStyleMenu = false
+		a.showParagraphMenu = false
+		a.showInsertMenu = false
+		a.showConvertMenu = false
+		a.showTableMenu = false
+		a.showCodeMenu = false
+		a.showReviewMenu = false
+		a.showPageMenu = false
+	case "table_menu":
+		a.showTableMenu = !a.showTableMenu
+		a.showStyleMenu = false
+		a.showParagraphMenu = false
+		a.showListMenu = false
+		a.showInsertMenu = false
+		a.showConvertMenu = false
+		a.showCodeMenu = false
+		a.showReviewMenu = false
+		a.showPageMenu = false
+	case "code_menu":
+		a.showCodeMenu = !a.showCodeMenu
+		a.showStyleMenu = false
+		a.showParagraphMenu = false
+		a.showListMenu = false
+		a.showInsertMenu = false
+		a.showConvertMenu = false
+		a.showTableMenu = false
+		a.showReviewMenu = false
+		a.showPageMenu = false
+	case "review_menu":
+		a.showReviewMenu = !a.showReviewMenu
+		a.showPageMenu = false
+		a.showStyleMenu = false
+		a.showParagraphMenu = false
+		a.showListMenu = false
+		a.showInsertMenu = false
+		a.showConvertMenu = false
+		a.showTableMenu = false
+		a.showCodeMenu = false
 	case "insert_image_file":
 		if err := a.insertImageFromFileDialog(); err != nil {
 			if !errors.Is(err, dialog.ErrCancelled) {
@@ -1548,6 +2065,33 @@ func (a *App) invokeAction(id string) {
 		if err := a.insertImageFromClipboard(); err != nil {
 			a.status = "Insert image failed: " + err.Error()
 		}
+	case "insert_link":
+		a.openLinkDialog()
+	case "insert_bookmark":
+		a.openBookmarkDialog()
+	case "insert_cross_ref":
+		a.openCrossRefDialog()
+	case "page_menu":
+		a.showPageMenu = !a.showPageMenu
+		a.showStyleMenu = false
+		a.showParagraphMenu = false
+		a.showListMenu = false
+		a.showInsertMenu = false
+		a.showConvertMenu = false
+		a.showTableMenu = false
+		a.showCodeMenu = false
+		a.showReviewMenu = false
+	case "insert_page_break":
+		if !a.beginEdit() {
+			return
+		}
+		a.insertPageBreak()
+	case "insert_footnote":
+		a.insertNote(sqdoc.NoteFootnote)
+	case "insert_endnote":
+		a.insertNote(sqdoc.NoteEndnote)
+	case "add_comment":
+		a.openCommentDialog(0)
 	}
 }
 
@@ -1568,14 +2112,27 @@ func (a *App) Draw(screen *ebiten.Image) {
 	a.layoutTabBar(menuFace, layout)
 	a.layoutToolbarControls(toolbarFace, layout)
 	a.layoutContentRects(layout)
+	a.layoutFindBar(toolbarFace, a.contentRect)
+	bannerTop := a.contentRect
+	if a.find.visible {
+		bannerTop.y = a.find.rect.y - 2
+	}
+	a.layoutExternalBanner(toolbarFace, bannerTop)
 
 	a.drawDocumentChrome(layout)
+	a.refreshCrossRefs()
 	a.layoutDocumentLines()
 	if a.pendingFollowCaret {
 		a.ensureCaretVisible()
 		a.layoutDocumentLines()
 		a.pendingFollowCaret = false
 	}
+	a.refreshFindMatches()
+	a.drawPageSheets()
+	a.drawTableCells()
+	a.drawCodeBlocks()
+	a.drawCommentHighlights()
+	a.drawFindHighlights()
 	a.drawDocumentSelectionAndCaret()
 	a.drawScrollbars()
 	a.drawDataMapPanel()
@@ -1595,6 +2152,10 @@ func (a *App) Draw(screen *ebiten.Image) {
 	a.drawDocumentText(screen)
 	a.drawImageInteractionOverlay(screen)
 	a.drawDataMapLabels(screen, panelFace)
+	a.drawOutlinePanel(screen, panelFace)
+	a.drawCommentPanel(screen, panelFace)
+	a.drawExternalBanner(screen, toolbarFace)
+	a.drawFindBar(screen, toolbarFace)
 
 	name := a.filePath
 	if name == "" {
@@ -1610,6 +2171,18 @@ func (a *App) Draw(screen *ebiten.Image) {
 	}
 	attr := a.state.CurrentStyleAttr()
 	statusLeft := fmt.Sprintf("[ Block %d/%d ] [ Caret %d ] [ Font %dpt ]", a.state.CurrentBlock+1, a.state.BlockCount(), a.state.CaretByte, attr.FontSizePt)
+	if count := a.findMatchCount(); a.find.visible && count != "" {
+		statusLeft += " [ " + count + " ]"
+	}
+	if a.state.Suggesting {
+		statusLeft += " [ Suggesting ]"
+	}
+	if change := a.revisionStatus(); change != "" {
+		statusLeft += " [ " + change + " ]"
+	}
+	if page := a.pageStatus(); page != "" {
+		statusLeft += " [ " + page + " ]"
+	}
 	statusRight := fmt.Sprintf("[ %s ] [ Scroll X %.0f%% Y %.0f%% ] [ %s ]", name, scrollXPct, scrollYPct, a.status)
 	statusBand := rect{x: 0, y: layout.StatusBar, w: w, h: layout.StatusH}
 	statusBaseline := a.centeredTextBaseline(statusBand, statusFace)
@@ -1625,12 +2198,26 @@ func (a *App) Draw(screen *ebiten.Image) {
 	text.Draw(screen, statusLeft, statusFace, leftX, statusBaseline, color.RGBA{R: 42, G: 56, B: 80, A: 255})
 	text.Draw(screen, statusRight, statusFace, rightX, statusBaseline, color.RGBA{R: 42, G: 56, B: 80, A: 255})
 
+	a.drawLinkTooltip(screen, statusFace)
 	a.drawInsertMenu(screen, menuFace)
+	a.drawConvertMenu(screen, menuFace)
+	a.drawStyleMenu(screen, menuFace)
+	a.drawParagraphMenu(screen, menuFace)
+	a.drawListMenu(screen, menuFace)
+	a.drawTableMenu(screen, menuFace)
+	a.drawCodeMenu(screen, menuFace)
+	a.drawReviewMenu(screen, menuFace)
+	a.drawPageMenu(screen, menuFace)
 	a.drawColorPickerOverlay(screen)
 	a.drawTabChooser(screen, w, h)
 	a.drawEncryptionPanel(screen, w, h)
 	a.drawEncryptionLabels(screen, toolbarFace)
 	a.drawPasswordPrompt(screen, w, h)
+	a.drawLinkDialog(screen, w, h, toolbarFace)
+	a.drawBookmarkDialog(screen, w, h, toolbarFace)
+	a.drawPageSetupDialog(screen, w, h, toolbarFace)
+	a.drawCrossRefDialog(screen, w, h, toolbarFace)
+	a.drawCommentDialog(screen, w, h, toolbarFace)
 
 	if a.showHelp {
 		a.drawHelpOverlay(screen, toolbarFace)
@@ -1647,6 +2234,14 @@ func (a *App) layoutContentRects(layout ui.Layout) {
 		textBox.y += barH + 6
 		textBox.h -= barH + 6
 	}
+	if bannerH := a.externalBannerHeight(); bannerH > 0 {
+		textBox.y += bannerH + 6
+		textBox.h -= bannerH + 6
+	}
+	if findH := a.findBarHeight(); findH > 0 {
+		textBox.y += findH + 6
+		textBox.h -= findH + 6
+	}
 	if textBox.w < 360 {
 		textBox.w = 360
 	}
@@ -1665,6 +2260,19 @@ func (a *App) layoutContentRects(layout ui.Layout) {
 		a.dataMapRect = rect{x: textBox.x + textBox.w - panelW, y: textBox.y, w: panelW, h: textBox.h}
 		textBox.w -= panelW + 12
 	}
+	a.comments.rect = rect{}
+	if a.comments.visible {
+		panelW := min(max(int(280*a.uiScales[a.uiScaleIdx]), 220), textBox.w/3)
+		a.comments.rect = rect{x: textBox.x + textBox.w - panelW, y: textBox.y, w: panelW, h: textBox.h}
+		textBox.w -= panelW + 12
+	}
+	a.outline.rect = rect{}
+	if a.outline.visible {
+		panelW := min(max(int(240*a.uiScales[a.uiScaleIdx]), 200), textBox.w/3)
+		a.outline.rect = rect{x: textBox.x, y: textBox.y, w: panelW, h: textBox.h}
+		textBox.x += panelW + 12
+		textBox.w -= panelW + 12
+	}
 	if textBox.w < 260 {
 		textBox.w = 260
 	}
@@ -1927,6 +2535,12 @@ func colorForSegment(kind sqdoc.BlockKind, name string, idx int) color.RGBA {
 	switch kind {
 	case sqdoc.BlockKindStyle:
 		return color.RGBA{R: 188, G: 92, B: 66, A: 255}
+	case sqdoc.BlockKindTable:
+		return color.RGBA{R: 150, G: 98, B: 168, A: 255}
+	case sqdoc.BlockKindNote:
+		return color.RGBA{R: 176, G: 148, B: 64, A: 255}
+	case sqdoc.BlockKindComment:
+		return color.RGBA{R: 224, G: 150, B: 60, A: 255}
 	case sqdoc.BlockKindText:
 		palette := []color.RGBA{
 			{R: 81, G: 142, B: 93, A: 255},
@@ -2000,6 +2614,9 @@ func (a *App) captureRuntimeAsTab() documentTab {
 		pagedMode:           a.pagedMode,
 		paragraphGap:        a.paragraphGap,
 		preferredFontFamily: normalizeFontFamilyApp(a.preferredFontFamily),
+		disk:                a.disk,
+		docLock:             a.docLock,
+		readOnly:            a.readOnly,
 	}
 	if tab.state == nil {
 		doc := sqdoc.NewDocument("", "Untitled")
@@ -2034,6 +2651,9 @@ func (a *App) syncActiveTabFromRuntime() {
 	tab.pagedMode = a.pagedMode
 	tab.paragraphGap = a.paragraphGap
 	tab.preferredFontFamily = normalizeFontFamilyApp(a.preferredFontFamily)
+	tab.disk = a.disk
+	tab.docLock = a.docLock
+	tab.readOnly = a.readOnly
 }
 
 func (a *App) restoreRuntimeFromTab(idx int) {
@@ -2058,6 +2678,9 @@ func (a *App) restoreRuntimeFromTab(idx int) {
 		a.paragraphGap = 8
 	}
 	a.preferredFontFamily = normalizeFontFamilyApp(tab.preferredFontFamily)
+	a.disk = tab.disk
+	a.docLock = tab.docLock
+	a.readOnly = tab.readOnly
 	if a.state == nil {
 		doc := sqdoc.NewDocument("", "Untitled")
 		a.state = editor.NewState(doc)
@@ -2074,6 +2697,14 @@ func (a *App) switchTab(index int) {
 	a.restoreRuntimeFromTab(index)
 	a.showColorPicker = false
 	a.showInsertMenu = false
+	a.showConvertMenu = false
+	a.showStyleMenu = false
+	a.showParagraphMenu = false
+	a.showListMenu = false
+	a.showTableMenu = false
+	a.showCodeMenu = false
+	a.showReviewMenu = false
+	a.showPageMenu = false
 	a.showEncryption = false
 	a.encryptionInputActive = false
 	a.showPasswordPrompt = false
@@ -2100,6 +2731,10 @@ func (a *App) closeTab(index int) {
 		return
 	}
 	a.syncActiveTabFromRuntime()
+	if a.tabs[index].docLock != nil {
+		_ = a.tabs[index].docLock.Release()
+		a.tabs[index].docLock = nil
+	}
 	if len(a.tabs) == 1 {
 		doc := sqdoc.NewDocument("", "Untitled")
 		doc.Metadata.PagedMode = a.pagedMode
@@ -2207,6 +2842,9 @@ func (a *App) tabTitle(index int) string {
 	}
 	tab := a.tabs[index]
 	if tab.filePath != "" {
+		if tab.readOnly {
+			return filepath.Base(tab.filePath) + " (read-only)"
+		}
 		return filepath.Base(tab.filePath)
 	}
 	if tab.state != nil && tab.state.Doc != nil {
@@ -2471,8 +3109,11 @@ func (a *App) layoutTopActions(face font.Face, layout ui.Layout) {
 		{id: "save", label: "Save"},
 		{id: "save_as", label: "Save As"},
 		{id: "insert", label: "Insert", active: a.showInsertMenu},
+		{id: "convert", label: "Import/Export", active: a.showConvertMenu},
 		{id: "undo", label: "Undo"},
 		{id: "redo", label: "Redo"},
+		{id: "outline", label: "Outline", active: a.outline.visible},
+		{id: "comments", label: "Comments", active: a.comments.visible},
 		{id: "data_map", label: "Data Map", active: a.showDataMap},
 		{id: "encryption", label: "Doc Settings", active: a.showEncryption},
 		{id: "scale_down", label: "A-"},
@@ -2581,6 +3222,9 @@ func (a *App) layoutToolbarControls(face font.Face, layout ui.Layout) {
 		return r
 	}
 
+	addBtn("style_menu", a.currentStyleName(), 110, a.showStyleMenu)
+	x += max(2, int(4*scale))
+
 	addBtn("bold", "Bold", 58, attr.Bold)
 	addBtn("italic", "Italic", 58, attr.Italic)
 	addBtn("underline", "Underline", 78, attr.Underline)
@@ -2610,6 +3254,19 @@ func (a *App) layoutToolbarControls(face font.Face, layout ui.Layout) {
 	addBtn("font_sans", "Sans", 60, fam == sqdoc.FontFamilySans)
 	addBtn("font_serif", "Serif", 62, fam == sqdoc.FontFamilySerif)
 	addBtn("font_mono", "Mono", 60, fam == sqdoc.FontFamilyMonospace)
+	x += max(2, int(4*scale))
+
+	align := a.state.ParagraphFormat().Align
+	addBtn("align_left", "L", 30, align == sqdoc.AlignLeft)
+	addBtn("align_center", "C", 30, align == sqdoc.AlignCenter)
+	addBtn("align_right", "R", 30, align == sqdoc.AlignRight)
+	addBtn("align_justify", "J", 30, align == sqdoc.AlignJustify)
+	addBtn("paragraph_menu", "Paragraph", 90, a.showParagraphMenu)
+	addBtn("list_menu", "List", 48, a.showListMenu || a.state.ListItem().ID != 0)
+	addBtn("table_menu", "Table", 56, a.showTableMenu || a.state.IsTable(a.state.CurrentBlock))
+	addBtn("code_menu", "Code", 52, a.showCodeMenu || a.state.CodeBlock() != nil)
+	addBtn("review_menu", "Review", 64, a.showReviewMenu || a.state.Suggesting)
+	addBtn("page_menu", "Page", 52, a.showPageMenu || a.pagedMode)
 
 	if a.showColorPicker {
 		scale := a.uiScales[a.uiScaleIdx]
@@ -2724,231 +3381,441 @@ func (a *App) uiFace(size int, bold, italic bool, family sqdoc.FontFamily) font.
 
 func (a *App) layoutDocumentLines() {
 	a.lineLayouts = a.lineLayouts[:0]
+	a.cellLayouts = a.cellLayouts[:0]
+	a.codeLayouts = a.codeLayouts[:0]
+	a.noteRules = a.noteRules[:0]
+	a.pages = pageView{}
 	if a.state == nil || a.contentRect.w <= 0 || a.contentRect.h <= 0 {
 		return
 	}
 
 	docY := 4
-	lineGap := int(4 * a.uiScales[a.uiScaleIdx])
-	if lineGap < 2 {
-		lineGap = 2
-	}
 	blockGap := int(float32(max(0, a.paragraphGap)) * a.uiScales[a.uiScaleIdx])
 	if blockGap < 0 {
 		blockGap = 0
 	}
 	maxWidth := 0
+	// Code never wraps, so it alone can scroll sideways in paged mode.
+	codeWidth := 0
 	wrapWidth := a.contentRect.w - 18
 	if wrapWidth < 80 {
 		wrapWidth = 80
 	}
+	if a.pagedMode {
+		wrapWidth = a.pageTextWidth()
+	}
 	allTexts := a.state.AllBlockTexts()
 
+	scaled := func(pt int) int { return int(float32(pt) * a.uiScales[a.uiScaleIdx]) }
+	markers := a.state.Doc.ListMarkers()
+	noteNumbers := a.state.Doc.NoteNumbers()
+
+	// flow records each block for paged mode to move onto its page.
+	var flow []flowBlock
 	for bi := 0; bi < a.state.BlockCount(); bi++ {
-		textBytes := []byte(allTexts[bi])
-		runs := a.state.BlockRuns(bi)
-		if len(runs) == 0 {
-			runs = []sqdoc.StyleRun{{Start: 0, End: uint32(len(textBytes)), Attr: defaultAttr()}}
+		fb := flowBlock{block: bi, lines: len(a.lineLayouts), cells: len(a.cellLayouts), codes: len(a.codeLayouts), rules: len(a.noteRules), whole: true}
+		if a.state.IsTable(bi) {
+			bottom, right, groups := a.layoutTable(bi, docY, wrapWidth)
+			maxWidth = max(maxWidth, right)
+			if len(groups) == 0 {
+				fb.top, fb.bottom = docY, bottom
+				groups = append(groups, fb)
+			}
+			groups[len(groups)-1].after = blockGap
+			flow = append(flow, groups...)
+			docY = bottom + blockGap
+			continue
+		}
+		tb := a.state.Doc.Blocks[bi].Text
+		fb.breakBefore = tb.Para.PageBreakBefore
+		fb.before = scaled(int(tb.Para.SpaceBefore))
+		fb.after = blockGap + scaled(int(tb.Para.SpaceAfter))
+		if tb.Code != nil {
+			docY += fb.before
+			bottom, right := a.layoutCode(bi, docY, wrapWidth)
+			codeWidth = max(codeWidth, right)
+			fb.top, fb.bottom = docY, bottom
+			flow = append(flow, fb)
+			docY = bottom + fb.after
+			continue
+		}
+		fb.whole = false
+		start := docY
+		listIndent := 0
+		if tb.List.ID != 0 {
+			listIndent = scaled(listIndentPt * (int(tb.List.Level) + 1))
+		}
+		marker := markers[bi]
+		if a.state.IsNote(bi) {
+			var rule bool
+			marker, rule = a.noteMarker(bi, noteNumbers)
+			listIndent = scaled(listIndentPt)
+			if rule {
+				docY += scaled(12)
+				a.noteRules = append(a.noteRules, docY)
+				docY += scaled(12)
+			}
+		}
+		docY += scaled(int(tb.Para.SpaceBefore))
+		lines, bottom := a.layoutParagraph(paragraphLayout{
+			block:      bi,
+			text:       []byte(allTexts[bi]),
+			runs:       a.state.BlockRuns(bi),
+			para:       tb.Para,
+			marker:     marker,
+			listIndent: listIndent,
+			left:       8,
+			width:      wrapWidth,
+			wrap:       a.pagedMode,
+		}, docY)
+		for _, ll := range lines {
+			maxWidth = max(maxWidth, ll.docX+ll.width)
+		}
+		a.lineLayouts = append(a.lineLayouts, lines...)
+		fb.before, fb.top, fb.bottom = docY-start, docY, bottom
+		flow = append(flow, fb)
+		docY = bottom + fb.after
+	}
+
+	contentW := max(1, a.contentRect.w-12)
+	totalHeight := docY + 6
+	if a.pagedMode {
+		// Sheets are as wide as the page, and only code can stick out.
+		totalHeight, maxWidth = a.paginate(flow)
+		codeWidth += a.pages.shift
+	}
+	a.maxY = math.Max(0, float64(totalHeight-a.contentRect.h))
+	a.maxX = math.Max(0, float64(max(maxWidth, codeWidth)-contentW))
+	a.clampScroll()
+
+	for i := range a.lineLayouts {
+		a.lineLayouts[i].y = a.contentRect.y + a.lineLayouts[i].docY - int(a.scrollY)
+		a.lineLayouts[i].viewX = a.contentRect.x + a.lineLayouts[i].docX - int(a.scrollX)
+		a.lineLayouts[i].baseline = a.lineLayouts[i].y + a.lineLayouts[i].ascent + 1
+	}
+	for i := range a.cellLayouts {
+		a.cellLayouts[i].y = a.contentRect.y + a.cellLayouts[i].docY - int(a.scrollY)
+		a.cellLayouts[i].x = a.contentRect.x + a.cellLayouts[i].docX - int(a.scrollX)
+	}
+	for i := range a.codeLayouts {
+		a.codeLayouts[i].y = a.contentRect.y + a.codeLayouts[i].docY - int(a.scrollY)
+		a.codeLayouts[i].x = a.contentRect.x + a.codeLayouts[i].docX - int(a.scrollX)
+	}
+}
+
+// paragraphLayout is a paragraph to lay out and the box it goes in, in
+// document pixels.
+type paragraphLayout struct {
+	block      int
+	text       []byte
+	runs       []sqdoc.StyleRun
+	para       sqdoc.ParagraphFormat
+	marker     string
+	listIndent int
+	left       int
+	width      int
+	wrap       bool
+}
+
+// layoutParagraph lays out the lines of p from docY down and returns them
+// with the y below the last one.
+func (a *App) layoutParagraph(p paragraphLayout, docY int) ([]lineLayout, int) {
+	var lines []lineLayout
+	lineGap := int(4 * a.uiScales[a.uiScaleIdx])
+	if lineGap < 2 {
+		lineGap = 2
+	}
+	scaled := func(pt int) int { return int(float32(pt) * a.uiScales[a.uiScaleIdx]) }
+	textBytes, runs, para := p.text, p.runs, p.para
+	if len(runs) == 0 {
+		runs = []sqdoc.StyleRun{{Start: 0, End: uint32(len(textBytes)), Attr: defaultAttr()}}
+	}
+	firstLine := true
+
+	logicalStart := 0
+	for {
+		relEnd := bytes.IndexByte(textBytes[logicalStart:], '\n')
+		logicalEnd := len(textBytes)
+		hasNL := false
+		if relEnd >= 0 {
+			logicalEnd = logicalStart + relEnd
+			hasNL = true
 		}
 
-		logicalStart := 0
+		wrapStart := logicalStart
 		for {
-			relEnd := bytes.IndexByte(textBytes[logicalStart:], '\n')
-			logicalEnd := len(textBytes)
-			hasNL := false
-			if relEnd >= 0 {
-				logicalEnd = logicalStart + relEnd
-				hasNL = true
+			indent := scaled(int(para.IndentLeft)) + p.listIndent
+			if firstLine {
+				indent += scaled(int(para.IndentFirst))
 			}
-
-			wrapStart := logicalStart
-			for {
-				lineEnd := logicalEnd
-				if a.pagedMode && wrapStart < logicalEnd {
-					lineEnd = a.wrapSegmentEnd(bi, textBytes, runs, wrapStart, logicalEnd, wrapWidth)
+			available := max(40, p.width-indent-scaled(int(para.IndentRight)))
+			lineEnd := logicalEnd
+			if p.wrap && wrapStart < logicalEnd {
+				lineEnd = a.wrapSegmentEnd(p.block, textBytes, runs, wrapStart, logicalEnd, available)
+			}
+			if lineEnd <= wrapStart && wrapStart < logicalEnd {
+				lineEnd = nextRuneBoundary(textBytes, wrapStart)
+			}
+			lineBytes := append([]byte(nil), textBytes[wrapStart:lineEnd]...)
+			lineLen := len(lineBytes)
+			imageTokens := parseInlineImageTokens(lineBytes)
+			segments := make([]lineSegment, 0, len(runs))
+			lineWidth := 0
+			maxAscent := 0
+			maxDescent := 0
+			addedImages := map[int]bool{}
+
+			for _, run := range runs {
+				rs := int(run.Start)
+				re := int(run.End)
+				if re <= wrapStart || rs >= lineEnd {
+					continue
 				}
-				if lineEnd <= wrapStart && wrapStart < logicalEnd {
-					lineEnd = nextRuneBoundary(textBytes, wrapStart)
+				segStart := max(rs, wrapStart) - wrapStart
+				segEnd := min(re, lineEnd) - wrapStart
+				if segEnd < segStart {
+					continue
 				}
-				lineBytes := append([]byte(nil), textBytes[wrapStart:lineEnd]...)
-				lineLen := len(lineBytes)
-				imageTokens := parseInlineImageTokens(lineBytes)
-				segments := make([]lineSegment, 0, len(runs))
-				lineWidth := 0
-				maxAscent := 0
-				maxDescent := 0
-				addedImages := map[int]bool{}
-
-				for _, run := range runs {
-					rs := int(run.Start)
-					re := int(run.End)
-					if re <= wrapStart || rs >= lineEnd {
-						continue
-					}
-					segStart := max(rs, wrapStart) - wrapStart
-					segEnd := min(re, lineEnd) - wrapStart
-					if segEnd < segStart {
-						continue
-					}
-					attr := normalizeStyleAttr(run.Attr, a.preferredFontFamily)
-					face := a.uiFace(int(attr.FontSizePt), attr.Bold, attr.Italic, attr.FontFamily)
-					cursor := segStart
-					for cursor < segEnd {
-						token := imageTokenAt(imageTokens, cursor, segEnd)
-						if token == nil {
-							if cursor < segEnd && segEnd <= lineLen {
-								segText := string(lineBytes[cursor:segEnd])
-								segW := a.measureString(face, segText)
-								m := face.Metrics()
-								if asc := m.Ascent.Round(); asc > maxAscent {
-									maxAscent = asc
-								}
-								if des := m.Descent.Round(); des > maxDescent {
-									maxDescent = des
-								}
-								segments = append(segments, lineSegment{
-									start: cursor,
-									end:   segEnd,
-									text:  segText,
-									attr:  attr,
-									face:  face,
-									width: segW,
-								})
-								lineWidth += segW
+				attr := normalizeStyleAttr(run.Attr, a.preferredFontFamily)
+				if attr.Link != "" {
+					attr.Underline = true
+					attr.ColorRGBA = a.linkColor()
+				} else if attr.Ref.Target != "" || attr.Note != 0 {
+					attr.ColorRGBA = a.linkColor()
+				}
+				a.markRevision(&attr)
+				size, rise := int(attr.FontSizePt), 0
+				if attr.Note != 0 {
+					// Note anchors are superscript.
+					size, rise = max(6, size*2/3), size/3
+				}
+				face := a.uiFace(size, attr.Bold, attr.Italic, attr.FontFamily)
+				cursor := segStart
+				for cursor < segEnd {
+					token := imageTokenAt(imageTokens, cursor, segEnd)
+					if token == nil {
+						if cursor < segEnd && segEnd <= lineLen {
+							segText := string(lineBytes[cursor:segEnd])
+							segW := a.measureString(face, segText)
+							m := face.Metrics()
+							if asc := m.Ascent.Round() + rise; asc > maxAscent {
+								maxAscent = asc
 							}
-							break
-						}
-						if token.start > cursor {
-							textEnd := min(token.start, segEnd)
-							if cursor < textEnd && textEnd <= lineLen {
-								segText := string(lineBytes[cursor:textEnd])
-								segW := a.measureString(face, segText)
-								m := face.Metrics()
-								if asc := m.Ascent.Round(); asc > maxAscent {
-									maxAscent = asc
-								}
-								if des := m.Descent.Round(); des > maxDescent {
-									maxDescent = des
-								}
-								segments = append(segments, lineSegment{
-									start: cursor,
-									end:   textEnd,
-									text:  segText,
-									attr:  attr,
-									face:  face,
-									width: segW,
-								})
-								lineWidth += segW
+							if des := m.Descent.Round(); des > maxDescent {
+								maxDescent = des
 							}
-							cursor = textEnd
-							continue
-						}
-						// Cursor is inside/at token range.
-						if !addedImages[token.start] && segEnd >= token.end {
-							imageW, imageH := a.tokenDisplaySize(bi, *token, int(attr.FontSizePt), wrapStart+token.start)
 							segments = append(segments, lineSegment{
-								start:     token.start,
-								end:       token.end,
-								attr:      attr,
-								face:      face,
-								width:     imageW,
-								isImage:   true,
-								imagePath: token.path,
-								imageW:    imageW,
-								imageH:    imageH,
+								start: cursor,
+								end:   segEnd,
+								text:  segText,
+								attr:  attr,
+								face:  face,
+								width: segW,
+								rise:  rise,
 							})
-							lineWidth += imageW
-							if imageH > maxAscent {
-								maxAscent = imageH
+							lineWidth += segW
+						}
+						break
+					}
+					if token.start > cursor {
+						textEnd := min(token.start, segEnd)
+						if cursor < textEnd && textEnd <= lineLen {
+							segText := string(lineBytes[cursor:textEnd])
+							segW := a.measureString(face, segText)
+							m := face.Metrics()
+							if asc := m.Ascent.Round() + rise; asc > maxAscent {
+								maxAscent = asc
 							}
-							if maxDescent < 2 {
-								maxDescent = 2
+							if des := m.Descent.Round(); des > maxDescent {
+								maxDescent = des
 							}
-							addedImages[token.start] = true
+							segments = append(segments, lineSegment{
+								start: cursor,
+								end:   textEnd,
+								text:  segText,
+								attr:  attr,
+								face:  face,
+								width: segW,
+								rise:  rise,
+							})
+							lineWidth += segW
+						}
+						cursor = textEnd
+						continue
+					}
+					// Cursor is inside/at token range.
+					if !addedImages[token.start] && segEnd >= token.end {
+						imageW, imageH := a.tokenDisplaySize(p.block, *token, int(attr.FontSizePt), wrapStart+token.start)
+						segments = append(segments, lineSegment{
+							start:     token.start,
+							end:       token.end,
+							attr:      attr,
+							face:      face,
+							width:     imageW,
+							isImage:   true,
+							imagePath: token.path,
+							imageW:    imageW,
+							imageH:    imageH,
+						})
+						lineWidth += imageW
+						if imageH > maxAscent {
+							maxAscent = imageH
 						}
-						cursor = min(segEnd, token.end)
-						if cursor <= token.start {
-							cursor = token.start + 1
+						if maxDescent < 2 {
+							maxDescent = 2
 						}
+						addedImages[token.start] = true
 					}
-				}
-
-				if len(segments) == 0 {
-					attr := normalizeStyleAttr(defaultAttr(), a.preferredFontFamily)
-					if len(runs) > 0 {
-						attr = normalizeStyleAttr(runs[0].Attr, a.preferredFontFamily)
+					cursor = min(segEnd, token.end)
+					if cursor <= token.start {
+						cursor = token.start + 1
 					}
-					face := a.uiFace(int(attr.FontSizePt), attr.Bold, attr.Italic, attr.FontFamily)
-					m := face.Metrics()
-					maxAscent = m.Ascent.Round()
-					maxDescent = m.Descent.Round()
-					segments = append(segments, lineSegment{
-						start: 0,
-						end:   lineLen,
-						text:  string(lineBytes),
-						attr:  attr,
-						face:  face,
-						width: a.measureString(face, string(lineBytes)),
-					})
-					lineWidth = segments[0].width
 				}
+			}
 
-				height := maxAscent + maxDescent + int(6*a.uiScales[a.uiScaleIdx])
-				if height < 18 {
-					height = 18
+			if len(segments) == 0 {
+				attr := normalizeStyleAttr(defaultAttr(), a.preferredFontFamily)
+				if len(runs) > 0 {
+					attr = normalizeStyleAttr(runs[0].Attr, a.preferredFontFamily)
 				}
-				a.lineLayouts = append(a.lineLayouts, lineLayout{
-					block:     bi,
-					startByte: wrapStart,
-					text:      lineBytes,
-					segments:  segments,
-					docX:      8,
-					docY:      docY,
-					height:    height,
-					ascent:    maxAscent,
-					width:     lineWidth,
+				face := a.uiFace(int(attr.FontSizePt), attr.Bold, attr.Italic, attr.FontFamily)
+				m := face.Metrics()
+				maxAscent = m.Ascent.Round()
+				maxDescent = m.Descent.Round()
+				segments = append(segments, lineSegment{
+					start: 0,
+					end:   lineLen,
+					text:  string(lineBytes),
+					attr:  attr,
+					face:  face,
+					width: a.measureString(face, string(lineBytes)),
 				})
+				lineWidth = segments[0].width
+			}
 
-				if 8+lineWidth > maxWidth {
-					maxWidth = 8 + lineWidth
+			docX := p.left + indent
+			free := max(0, available-lineWidth)
+			switch para.Align {
+			case sqdoc.AlignCenter:
+				docX += free / 2
+			case sqdoc.AlignRight:
+				docX += free
+			case sqdoc.AlignJustify:
+				// The last line of a paragraph stays ragged.
+				if p.wrap && lineEnd < logicalEnd {
+					segments, lineWidth = a.justifySegments(segments, lineBytes, available)
 				}
-				docY += height + lineGap
+			}
 
-				if !a.pagedMode || lineEnd >= logicalEnd {
-					break
-				}
-				wrapStart = lineEnd
-				for wrapStart < logicalEnd {
-					r, size := utf8.DecodeRune(textBytes[wrapStart:logicalEnd])
-					if size <= 0 || !unicode.IsSpace(r) {
-						break
-					}
-					wrapStart += size
+			height := maxAscent + maxDescent + int(6*a.uiScales[a.uiScaleIdx])
+			if para.LineHeight != 0 {
+				height = height * int(para.LineHeight) / 100
+			}
+			if height < 18 {
+				height = 18
+			}
+			ll := lineLayout{
+				block:     p.block,
+				startByte: wrapStart,
+				text:      lineBytes,
+				segments:  segments,
+				docX:      docX,
+				docY:      docY,
+				height:    height,
+				ascent:    maxAscent,
+				width:     lineWidth,
+			}
+			if firstLine && p.marker != "" {
+				ll.markerAttr = normalizeStyleAttr(defaultAttr(), a.preferredFontFamily)
+				if len(runs) > 0 {
+					ll.markerAttr = normalizeStyleAttr(runs[0].Attr, a.preferredFontFamily)
 				}
+				ll.markerFace = a.uiFace(int(ll.markerAttr.FontSizePt), ll.markerAttr.Bold, ll.markerAttr.Italic, ll.markerAttr.FontFamily)
+				ll.marker = p.marker
+				ll.markerDocX = max(0, p.left+indent-a.measureString(ll.markerFace, ll.marker)-scaled(6))
 			}
-			if !hasNL {
+			lines = append(lines, ll)
+
+			docY += height + lineGap
+			firstLine = false
+
+			if !p.wrap || lineEnd >= logicalEnd {
 				break
 			}
-			logicalStart = logicalEnd + 1
+			wrapStart = lineEnd
+			for wrapStart < logicalEnd {
+				r, size := utf8.DecodeRune(textBytes[wrapStart:logicalEnd])
+				if size <= 0 || !unicode.IsSpace(r) {
+					break
+				}
+				wrapStart += size
+			}
 		}
-		docY += blockGap
+		if !hasNL {
+			break
+		}
+		logicalStart = logicalEnd + 1
 	}
+	return lines, docY
+}
 
-	contentW := max(1, a.contentRect.w-12)
-	totalHeight := docY + 6
-	a.maxY = math.Max(0, float64(totalHeight-a.contentRect.h))
-	if a.pagedMode {
-		a.maxX = 0
-	} else {
-		a.maxX = math.Max(0, float64(maxWidth-contentW))
+// justifySegments splits a line's text at spaces and widens the spaces
+// between words so the line fills width. Spaces after the last word keep
+// their width.
+func (a *App) justifySegments(segments []lineSegment, line []byte, width int) ([]lineSegment, int) {
+	var out []lineSegment
+	var gaps []int
+	for _, seg := range segments {
+		if seg.isImage {
+			out = append(out, seg)
+			continue
+		}
+		pieceStart := seg.start
+		flush := func(end int) {
+			if end > pieceStart {
+				piece := seg
+				piece.start, piece.end = pieceStart, end
+				piece.text = string(line[pieceStart:end])
+				piece.width = a.measureString(seg.face, piece.text)
+				out = append(out, piece)
+			}
+			pieceStart = end
+		}
+		for i := seg.start; i < seg.end; i++ {
+			if line[i] == ' ' {
+				flush(i)
+				gaps = append(gaps, len(out))
+				flush(i + 1)
+			}
+		}
+		flush(seg.end)
 	}
-	a.clampScroll()
-
-	for i := range a.lineLayouts {
-		a.lineLayouts[i].y = a.contentRect.y + a.lineLayouts[i].docY - int(a.scrollY)
-		a.lineLayouts[i].viewX = a.contentRect.x + a.lineLayouts[i].docX - int(a.scrollX)
-		a.lineLayouts[i].baseline = a.lineLayouts[i].y + a.lineLayouts[i].ascent + 1
+	last := len(out) - 1
+	for len(gaps) > 0 && gaps[len(gaps)-1] == last {
+		gaps = gaps[:len(gaps)-1]
+		last--
 	}
+	used, total := 0, 0
+	for i, seg := range out {
+		if i <= last {
+			used += seg.width
+		}
+		total += seg.width
+	}
+	extra := width - used
+	if len(gaps) == 0 || extra <= 0 {
+		return segments, total
+	}
+	for i, g := range gaps {
+		add := extra / len(gaps)
+		if i < extra%len(gaps) {
+			add++
+		}
+		out[g].width += add
+		out[g].stretched = true
+		total += add
+	}
+	return out, total
 }
 
 func normalizeStyleAttr(attr sqdoc.StyleAttr, fallbackFamily sqdoc.FontFamily) sqdoc.StyleAttr {
@@ -2988,43 +3855,19 @@ func (a *App) wrapSegmentEnd(block int, text []byte, runs []sqdoc.StyleRun, star
 		lineTokens[i].end += relStart
 	}
 
-	width := 0
-	lastBreak := -1
-	pos := start
-	for pos < lineEnd {
+	return render.WrapEnd(text, start, lineEnd, maxWidth, func(pos int) (int, int) {
+		attr := normalizeStyleAttr(styleAttrAtOffset(runs, pos), a.preferredFontFamily)
 		if tok := imageTokenAt(lineTokens, pos, lineEnd); tok != nil && tok.start == pos {
-			attr := normalizeStyleAttr(styleAttrAtOffset(runs, pos), a.preferredFontFamily)
 			imageW, _ := a.tokenDisplaySize(block, *tok, int(attr.FontSizePt), tok.start)
-			if width+imageW > maxWidth && pos > start {
-				if lastBreak > start {
-					return lastBreak
-				}
-				return pos
-			}
-			width += imageW
-			pos = tok.end
-			continue
+			return imageW, tok.end
 		}
-		r, size := utf8.DecodeRune(text[pos:lineEnd])
+		_, size := utf8.DecodeRune(text[pos:lineEnd])
 		if size <= 0 {
 			size = 1
 		}
-		attr := normalizeStyleAttr(styleAttrAtOffset(runs, pos), a.preferredFontFamily)
 		face := a.uiFace(int(attr.FontSizePt), attr.Bold, attr.Italic, attr.FontFamily)
-		rw := a.measureString(face, string(text[pos:pos+size]))
-		if width+rw > maxWidth && pos > start {
-			if lastBreak > start {
-				return lastBreak
-			}
-			return pos
-		}
-		width += rw
-		if unicode.IsSpace(r) {
-			lastBreak = pos
-		}
-		pos += size
-	}
-	return lineEnd
+		return a.measureString(face, string(text[pos:pos+size])), pos + size
+	})
 }
 
 func styleAttrAtOffset(runs []sqdoc.StyleRun, offset int) sqdoc.StyleAttr {
@@ -3054,6 +3897,9 @@ func (a *App) drawDocumentText(screen *ebiten.Image) {
 		}
 		x := ll.viewX - a.contentRect.x
 		baseline := ll.baseline - a.contentRect.y
+		if ll.marker != "" {
+			text.Draw(a.docLayer, ll.marker, ll.markerFace, ll.markerDocX-int(a.scrollX), baseline, rgbaFromUint32(ll.markerAttr.ColorRGBA))
+		}
 		for _, seg := range ll.segments {
 			segX := x
 			if seg.attr.Highlight && seg.width > 0 && !seg.isImage {
@@ -3084,15 +3930,18 @@ func (a *App) drawDocumentText(screen *ebiten.Image) {
 				}
 			} else if seg.text != "" {
 				clr := rgbaFromUint32(seg.attr.ColorRGBA)
-				text.Draw(a.docLayer, seg.text, seg.face, segX, baseline, clr)
+				text.Draw(a.docLayer, seg.text, seg.face, segX, baseline-seg.rise, clr)
 				if seg.attr.Underline {
-					underlineY := float64(baseline + max(1, seg.face.Metrics().Descent.Round()/2))
+					underlineY := float64(baseline - seg.rise + max(1, seg.face.Metrics().Descent.Round()/2))
 					ebitenutil.DrawLine(a.docLayer, float64(segX), underlineY, float64(segX+seg.width), underlineY, clr)
 				}
+				a.drawRevisionMark(a.docLayer, seg.face, seg.attr, segX, baseline-seg.rise, seg.width)
 			}
 			x += seg.width
 		}
+		a.drawBreakMark(a.docLayer, ll, x, baseline)
 	}
+	a.drawNoteRules(a.docLayer)
 
 	op := &ebiten.DrawImageOptions{}
 	op.GeoM.Translate(float64(a.contentRect.x), float64(a.contentRect.y))
@@ -3106,8 +3955,11 @@ func (a *App) drawDocumentSelectionAndCaret() {
 
 	selColor := color.RGBA{R: 191, G: 214, B: 255, A: 255}
 	if start, end, ok := a.state.SelectionRange(); ok {
+		if start.Block != end.Block {
+			a.fillSelectedCells(start.Block, end.Block, selColor)
+		}
 		for _, ll := range a.lineLayouts {
-			if ll.block < start.Block || ll.block > end.Block {
+			if ll.block < start.Block || ll.block > end.Block || ll.inCell && (start.Block != end.Block || !a.lineInActiveCell(ll)) {
 				continue
 			}
 			lineStart := ll.startByte
@@ -3137,7 +3989,7 @@ func (a *App) drawDocumentSelectionAndCaret() {
 	block := a.state.CurrentBlock
 	caret := a.state.CaretByte
 	for _, ll := range a.lineLayouts {
-		if ll.block != block {
+		if ll.block != block || !a.lineInActiveCell(ll) {
 			continue
 		}
 		lineStart := ll.startByte
@@ -3169,7 +4021,7 @@ func (a *App) drawScrollbars() {
 		}
 		a.frameBuffer.FillRect(trackX, thumbY, 4, thumbH, color.RGBA{R: 156, G: 170, B: 190, A: 255})
 	}
-	if !a.pagedMode && a.maxX > 0 {
+	if a.maxX > 0 {
 		trackX := a.contentRect.x + 2
 		trackY := a.contentRect.y + a.contentRect.h - 6
 		trackW := a.contentRect.w - 8
@@ -3305,20 +4157,37 @@ func (a *App) drawPasswordPrompt(screen *ebiten.Image, w, h int) {
 	text.Draw(screen, "Cancel", labelFace, a.passwordCancelRect.x+20, a.passwordCancelRect.y+20, color.RGBA{R: 52, G: 66, B: 92, A: 255})
 }
 
+// hitTestPosition returns the caret position under x, y. Over a table it
+// also makes the cell there the table's edited cell, since positions in a
+// table refer to that cell.
 func (a *App) hitTestPosition(x, y int) (int, int) {
-	if len(a.lineLayouts) == 0 {
+	cell, inTable := a.cellAt(x, y)
+	var lines []lineLayout
+	for _, ll := range a.lineLayouts {
+		if ll.inCell == inTable && (!inTable || ll.block == cell.block && ll.row == cell.row && ll.col == cell.col) {
+			lines = append(lines, ll)
+		}
+	}
+	if len(lines) == 0 {
 		return a.state.CurrentBlock, a.state.CaretByte
 	}
-	first := a.lineLayouts[0]
+	if inTable {
+		a.state.SelectCell(cell.block, cell.row, cell.col)
+	}
+	first := lines[0]
 	if y <= first.y {
 		return first.block, first.startByte + a.byteAtX(first, x-first.viewX)
 	}
-	for _, ll := range a.lineLayouts {
+	// Between lines, and between pages, the line above takes the click.
+	last := first
+	for _, ll := range lines {
 		if y >= ll.y && y <= ll.y+ll.height {
 			return ll.block, ll.startByte + a.byteAtX(ll, x-ll.viewX)
 		}
+		if ll.y <= y {
+			last = ll
+		}
 	}
-	last := a.lineLayouts[len(a.lineLayouts)-1]
 	return last.block, last.startByte + a.byteAtX(last, x-last.viewX)
 }
 
@@ -3359,7 +4228,7 @@ func (a *App) byteAtX(line lineLayout, relX int) int {
 			x += seg.width
 			continue
 		}
-		if seg.isImage {
+		if seg.isImage || seg.stretched {
 			if relX < x+seg.width/2 {
 				return seg.start
 			}
@@ -3387,10 +4256,6 @@ func (a *App) byteAtX(line lineLayout, relX int) int {
 }
 
 func (a *App) clampScroll() {
-	if a.pagedMode {
-		a.scrollX = 0
-		a.maxX = 0
-	}
 	if a.scrollX < 0 {
 		a.scrollX = 0
 	}
@@ -3412,7 +4277,7 @@ func (a *App) ensureCaretVisible() {
 	block := a.state.CurrentBlock
 	caret := a.state.CaretByte
 	for _, ll := range a.lineLayouts {
-		if ll.block != block {
+		if ll.block != block || !a.lineInActiveCell(ll) {
 			continue
 		}
 		lineStart := ll.startByte
@@ -3431,18 +4296,16 @@ func (a *App) ensureCaretVisible() {
 			a.scrollY = bottom - float64(a.contentRect.h)
 		}
 
-		if !a.pagedMode {
-			rel := caret - lineStart
-			caretDocX := float64(ll.docX + a.lineAdvance(ll, rel))
-			viewLeft := a.scrollX
-			viewRight := a.scrollX + float64(a.contentRect.w-12)
-			padding := 16.0
-			if caretDocX < viewLeft+padding {
-				a.scrollX = math.Max(0, caretDocX-padding)
-			}
-			if caretDocX > viewRight-padding {
-				a.scrollX = caretDocX - float64(a.contentRect.w-12) + padding
-			}
+		rel := caret - lineStart
+		caretDocX := float64(ll.docX + a.lineAdvance(ll, rel))
+		viewLeft := a.scrollX
+		viewRight := a.scrollX + float64(a.contentRect.w-12)
+		padding := 16.0
+		if caretDocX < viewLeft+padding {
+			a.scrollX = math.Max(0, caretDocX-padding)
+		}
+		if caretDocX > viewRight-padding {
+			a.scrollX = caretDocX - float64(a.contentRect.w-12) + padding
 		}
 		break
 	}
@@ -3457,13 +4320,28 @@ func (a *App) pushUndoSnapshot() {
 	if doc == nil {
 		return
 	}
-	snap := snapshot{doc: doc, currentBlock: a.state.CurrentBlock, caretByte: a.state.CaretByte}
-	a.undoHistory = append(a.undoHistory, snap)
+	a.undoHistory = append(a.undoHistory, a.snapshotOf(doc))
+	a.docRevision++
 	if len(a.undoHistory) > a.maxHistory {
 		a.undoHistory = a.undoHistory[1:]
 	}
 }
 
+// snapshotOf records doc with the caret position of the current state.
+func (a *App) snapshotOf(doc *sqdoc.Document) snapshot {
+	row, col := a.state.ActiveCell(a.state.CurrentBlock)
+	return snapshot{doc: doc, currentBlock: a.state.CurrentBlock, caretByte: a.state.CaretByte, cellRow: row, cellCol: col}
+}
+
+func (a *App) restoreSnapshot(snap snapshot) {
+	suggesting, author := a.state.Suggesting, a.state.Author
+	a.state = editor.NewState(snap.doc)
+	a.state.Suggesting, a.state.Author = suggesting, author
+	a.state.SelectCell(snap.currentBlock, snap.cellRow, snap.cellCol)
+	a.state.CurrentBlock = snap.currentBlock
+	a.state.CaretByte = snap.caretByte
+}
+
 func (a *App) undo() {
 	if len(a.undoHistory) == 0 {
 		return
@@ -3474,12 +4352,10 @@ func (a *App) undo() {
 		// push current into redo
 		cur := sqdoc.CloneDocument(a.state.Doc)
 		if cur != nil {
-			a.redoHistory = append(a.redoHistory, snapshot{doc: cur, currentBlock: a.state.CurrentBlock, caretByte: a.state.CaretByte})
+			a.redoHistory = append(a.redoHistory, a.snapshotOf(cur))
 		}
 	}
-	a.state = editor.NewState(last.doc)
-	a.state.CurrentBlock = last.currentBlock
-	a.state.CaretByte = last.caretByte
+	a.restoreSnapshot(last)
 }
 
 func (a *App) redo() {
@@ -3491,12 +4367,10 @@ func (a *App) redo() {
 	if a.state != nil && a.state.Doc != nil {
 		cur := sqdoc.CloneDocument(a.state.Doc)
 		if cur != nil {
-			a.undoHistory = append(a.undoHistory, snapshot{doc: cur, currentBlock: a.state.CurrentBlock, caretByte: a.state.CaretByte})
+			a.undoHistory = append(a.undoHistory, a.snapshotOf(cur))
 		}
 	}
-	a.state = editor.NewState(last.doc)
-	a.state.CurrentBlock = last.currentBlock
-	a.state.CaretByte = last.caretByte
+	a.restoreSnapshot(last)
 }
 
 func (a *App) insertImageFromFileDialog() error {
@@ -3519,6 +4393,9 @@ func (a *App) insertImageFromClipboard() error {
 }
 
 func (a *App) insertImageAtCaret(path string) error {
+	if !a.editable() {
+		return errReadOnly
+	}
 	path = normalizeImagePath(path)
 	if path == "" {
 		return errors.New("no image selected")
@@ -3570,6 +4447,9 @@ func (a *App) tryInsertImageFromClipboard() (bool, error) {
 }
 
 func (a *App) insertImageBytesAtCaret(data []byte, nameHint string) error {
+	if !a.editable() {
+		return errReadOnly
+	}
 	if len(data) == 0 {
 		return errors.New("empty image data")
 	}
@@ -3675,6 +4555,9 @@ func (a *App) handleDroppedImages() {
 		a.status = "Drop ignored: drop images inside the document area"
 		return
 	}
+	if !a.editable() {
+		return
+	}
 	block, bytePos := a.hitTestPosition(dropX, dropY)
 	a.state.SetCaret(block, bytePos)
 	a.state.ClearSelection()
@@ -3761,7 +4644,7 @@ func (a *App) handleDroppedImages() {
 }
 
 func (a *App) openDocumentDialog() error {
-	path, err := dialog.File().Filter("SQDoc files", "sqdoc").Load()
+	path, err := documentFileDialog(false).Load()
 	if err != nil {
 		if errors.Is(err, dialog.ErrCancelled) {
 			return nil
@@ -3772,6 +4655,9 @@ func (a *App) openDocumentDialog() error {
 		return errors.New("no file selected")
 	}
 	path = filepath.Clean(path)
+	if f, ok := formatForPath(path, false); ok {
+		return a.importPath(f, path)
+	}
 	env, err := sqdoc.InspectEnvelope(path)
 	if err != nil {
 		return err
@@ -3805,6 +4691,7 @@ func (a *App) openDocumentDialog() error {
 		}
 		return err
 	}
+	a.takeDocumentLock(path)
 	a.state = editor.NewState(doc)
 	a.filePath = path
 	a.status = "Opened " + filepath.Base(path)
@@ -3814,13 +4701,17 @@ func (a *App) openDocumentDialog() error {
 	a.redoHistory = a.redoHistory[:0]
 	a.applyEnvelopeSettings(env)
 	a.applyDocumentMetadataSettings(doc.Metadata)
+	a.recordDiskState(path, doc)
+	if a.readOnly {
+		a.status += " (read-only)"
+	}
 	return nil
 }
 
 func (a *App) saveDocument(saveAs bool) error {
 	path := a.filePath
 	if saveAs || path == "" {
-		p, err := dialog.File().Filter("SQDoc files", "sqdoc").Save()
+		p, err := documentFileDialog(true).Save()
 		if err != nil {
 			if errors.Is(err, dialog.ErrCancelled) {
 				return nil
@@ -3828,6 +4719,11 @@ func (a *App) saveDocument(saveAs bool) error {
 			return err
 		}
 		path = p
+		// Saving under a foreign extension exports a copy; the document
+		// keeps its own path.
+		if f, ok := formatForPath(path, true); ok && a.state != nil && a.state.Doc != nil {
+			return a.exportPath(f, filepath.Clean(path))
+		}
 	}
 	if path == "" {
 		return errors.New("no file selected")
@@ -3835,6 +4731,18 @@ func (a *App) saveDocument(saveAs bool) error {
 	if a.state == nil || a.state.Doc == nil {
 		return errors.New("no document to save")
 	}
+	if a.readOnly && path == a.filePath {
+		a.status = "Document is read-only; use Save As to keep a copy"
+		return nil
+	}
+	if path != a.filePath && !a.claimSaveTarget(path) {
+		a.status = "Save cancelled; " + filepath.Base(path) + " is in use"
+		return nil
+	}
+	if !a.confirmOverwriteExternal(path) {
+		a.status = "Save cancelled; file changed on disk"
+		return nil
+	}
 	a.state.Doc.Metadata.PagedMode = a.pagedMode
 	a.state.Doc.Metadata.ParagraphGap = uint16(max(0, a.paragraphGap))
 	a.state.Doc.Metadata.PreferredFontFamily = normalizeFontFamilyApp(a.preferredFontFamily)
@@ -3843,6 +4751,7 @@ func (a *App) saveDocument(saveAs bool) error {
 		return err
 	}
 	a.filePath = path
+	a.recordDiskState(path, a.state.Doc)
 	a.status = "Saved " + filepath.Base(path)
 	return nil
 }
@@ -3901,11 +4810,25 @@ func (a *App) drawHelpOverlay(screen *ebiten.Image, face font.Face) {
 		"Ctrl+O: Open | Ctrl+N: New | Ctrl+T: New Tab",
 		"Ctrl+Tab / Ctrl+Shift+Tab: Switch tabs",
 		"Insert > Image to embed inline images",
-		"Ctrl+V: Paste text or image from clipboard",
+		"Ctrl+V: Paste rich text, text or image from clipboard",
+		"Ctrl+Shift+V: Paste as plain text",
 		"Drag image files from Explorer/Finder into document",
 		"Ctrl+Z: Undo | Ctrl+Y: Redo",
+		"Ctrl+F: Find | Ctrl+H: Replace | F3 / Shift+F3: Next / previous match",
 		"Ctrl+B/I/U: Bold / Italic / Underline",
 		"Ctrl+Shift+H: Toggle text highlight",
+		"Style button: apply or update paragraph and character styles",
+		"Ctrl+L/E/R/J: Align left / centre / right / justify",
+		"Ctrl+M / Ctrl+Shift+M: Indent / outdent | Ctrl+1/5/2: Line spacing 1, 1.5, 2",
+		"Ctrl+Shift+8 / Ctrl+Shift+7: Bulleted / numbered list | Tab / Shift+Tab: List level",
+		"Table button: insert and edit tables | Tab / Shift+Tab in a table: Next / previous cell",
+		"Code button: code blocks, language and line numbers | Tab / Shift+Tab in code: Indent / outdent",
+		"Ctrl+K: Insert / edit link | Ctrl+Click a link: Open it, or jump to a #heading or bookmark",
+		"Ctrl+Shift+O: Outline panel | Insert > Bookmark / Cross-reference",
+		"Ctrl+Alt+F / Ctrl+Alt+D: Footnote / endnote | Ctrl+Click a note anchor to open it; click its number to go back",
+		"Ctrl+Alt+M: Comment on the selection | Comments button: review panel with reply / resolve / delete",
+		"Ctrl+Alt+E: Suggest changes | Review button: next / previous change, accept / reject one or all",
+		"Ctrl+Enter: Page break | Page button: page view, size, orientation and margins",
 		"Ctrl+Backspace / Ctrl+Delete: Delete previous/next word",
 		"Mouse wheel: vertical scroll | Shift+wheel: horizontal",
 		"Click inside document to set caret; drag to select",
@@ -3945,8 +4868,9 @@ func (a *App) fillRectWithinContent(x, y, w, h int, c color.RGBA) {
 }
 
 func (a *App) imageAtPoint(x, y int) (imageHit, bool) {
+	// Images in table cells are drawn but not picked.
 	for _, ll := range a.lineLayouts {
-		if y < ll.y || y > ll.y+ll.height {
+		if y < ll.y || y > ll.y+ll.height || ll.inCell {
 			continue
 		}
 		segX := ll.viewX
@@ -3978,7 +4902,7 @@ func (a *App) selectedImageOnScreen() (imageHit, bool) {
 	}
 	target := a.selectedImage
 	for _, ll := range a.lineLayouts {
-		if ll.block != target.block {
+		if ll.block != target.block || ll.inCell {
 			continue
 		}
 		segX := ll.viewX
@@ -4069,7 +4993,7 @@ func (a *App) drawImageInteractionOverlay(screen *ebiten.Image) {
 
 func (a *App) caretVisualPosition(block, bytePos int) (int, int, int, bool) {
 	for _, ll := range a.lineLayouts {
-		if ll.block != block {
+		if ll.block != block || !a.lineInActiveCell(ll) {
 			continue
 		}
 		lineStart := ll.startByte
@@ -4267,73 +5191,26 @@ func (a *App) clearImageInteraction() {
 	a.resizeImageActive = false
 }
 
-const (
-	inlineImageTokenPrefix = "[[imgb64:"
-	inlineImageTokenSuffix = "]]"
-)
-
-type inlineImagePayload struct {
-	Path string `json:"p"`
-	W    int    `json:"w,omitempty"`
-	H    int    `json:"h,omitempty"`
-}
-
 func makeInlineImageToken(path string) string {
 	return makeInlineImageTokenSized(path, 0, 0)
 }
 
 func makeInlineImageTokenSized(path string, w, h int) string {
-	payload := inlineImagePayload{
-		Path: normalizeImagePath(path),
-		W:    max(0, w),
-		H:    max(0, h),
-	}
-	blob, err := json.Marshal(payload)
-	if err != nil {
-		blob = []byte(payload.Path)
-	}
-	enc := base64.RawURLEncoding.EncodeToString(blob)
-	return inlineImageTokenPrefix + enc + inlineImageTokenSuffix
+	return sqdoc.MakeImageToken(normalizeImagePath(path), w, h)
 }
 
 func parseInlineImageTokens(line []byte) []inlineImageToken {
-	if len(line) == 0 {
+	tokens := sqdoc.ParseImageTokens(line)
+	if len(tokens) == 0 {
 		return nil
 	}
-	s := string(line)
-	out := make([]inlineImageToken, 0, 2)
-	pos := 0
-	for pos < len(s) {
-		start := strings.Index(s[pos:], inlineImageTokenPrefix)
-		if start < 0 {
-			break
-		}
-		start += pos
-		payloadStart := start + len(inlineImageTokenPrefix)
-		endRel := strings.Index(s[payloadStart:], inlineImageTokenSuffix)
-		if endRel < 0 {
-			break
-		}
-		payloadEnd := payloadStart + endRel
-		end := payloadEnd + len(inlineImageTokenSuffix)
-		decoded, err := base64.RawURLEncoding.DecodeString(s[payloadStart:payloadEnd])
-		if err == nil {
-			path := ""
-			w := 0
-			h := 0
-			var payload inlineImagePayload
-			if json.Unmarshal(decoded, &payload) == nil && strings.TrimSpace(payload.Path) != "" {
-				path = normalizeImagePath(payload.Path)
-				w = max(0, payload.W)
-				h = max(0, payload.H)
-			} else {
-				path = normalizeImagePath(string(decoded))
-			}
-			if path != "" {
-				out = append(out, inlineImageToken{start: start, end: end, path: path, w: w, h: h})
-			}
+	out := make([]inlineImageToken, 0, len(tokens))
+	for _, t := range tokens {
+		path := normalizeImagePath(t.Path)
+		if path == "" {
+			continue
 		}
-		pos = end
+		out = append(out, inlineImageToken{start: t.Start, end: t.End, path: path, w: t.Width, h: t.Height})
 	}
 	return out
 }
@@ -0,0 +1,361 @@
+package app
+
+import (
+	"image/color"
+	"strings"
+	"unicode/utf8"
+
+	"sqdoc/internal/editor"
+	"sqdoc/pkg/sqdoc"
+
+	textclipboard "github.com/atotto/clipboard"
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+	"github.com/hajimehoshi/ebiten/v2/text"
+	"golang.org/x/image/font"
+)
+
+// bookmarkDialog adds, moves or removes the bookmark named in it.
+type bookmarkDialog struct {
+	visible bool
+	name    string
+	err     string
+
+	rect       rect
+	nameRect   rect
+	addRect    rect
+	removeRect rect
+	cancelRect rect
+}
+
+func (a *App) openBookmarkDialog() {
+	a.showInsertMenu = false
+	name := a.state.BookmarkAtCaret()
+	if name == "" {
+		if start, end, has := a.state.SelectionRange(); has && start.Block == end.Block {
+			name = trimBookmarkName(sqdoc.HeadingAnchor(a.state.SelectedText()))
+		}
+	}
+	a.bookmark = bookmarkDialog{visible: true, name: name}
+}
+
+func (a *App) closeBookmarkDialog() {
+	a.bookmark = bookmarkDialog{}
+}
+
+func (a *App) hasBookmark(name string) bool {
+	for _, bm := range a.state.Doc.Bookmarks {
+		if bm.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *App) applyBookmarkDialog() {
+	name := a.bookmark.name
+	if name == "" {
+		a.bookmark.err = "Enter a name"
+		return
+	}
+	moved := a.hasBookmark(name)
+	if !a.beginEdit() {
+		return
+	}
+	if err := a.state.AddBookmark(name); err != nil {
+		a.bookmark.err = err.Error()
+		return
+	}
+	a.status = "Bookmark " + name + " added"
+	if moved {
+		a.status = "Bookmark " + name + " moved"
+	}
+	a.closeBookmarkDialog()
+}
+
+func (a *App) removeDialogBookmark() {
+	if !a.beginEdit() {
+		return
+	}
+	a.state.RemoveBookmark(a.bookmark.name)
+	a.status = "Bookmark " + a.bookmark.name + " removed"
+	a.closeBookmarkDialog()
+}
+
+func trimBookmarkName(s string) string {
+	for len(s) > sqdoc.MaxBookmarkName {
+		_, size := utf8.DecodeLastRuneInString(s)
+		s = s[:len(s)-size]
+	}
+	return s
+}
+
+// handleBookmarkDialogInput feeds keys to the bookmark dialog. Characters a
+// name cannot hold are dropped as they are typed.
+func (a *App) handleBookmarkDialogInput(ctrl bool) {
+	switch {
+	case inpututil.IsKeyJustPressed(ebiten.KeyEnter) || inpututil.IsKeyJustPressed(ebiten.KeyKPEnter):
+		a.applyBookmarkDialog()
+		return
+	case ctrl && inpututil.IsKeyJustPressed(ebiten.KeyV):
+		if clip, err := textclipboard.ReadAll(); err == nil {
+			for _, r := range strings.TrimSpace(firstLine(clip)) {
+				a.typeBookmarkRune(r)
+			}
+		}
+		return
+	case inpututil.IsKeyJustPressed(ebiten.KeyBackspace):
+		if len(a.bookmark.name) > 0 {
+			_, size := utf8.DecodeLastRuneInString(a.bookmark.name)
+			a.bookmark.name = a.bookmark.name[:len(a.bookmark.name)-size]
+		}
+	}
+	if ctrl {
+		return
+	}
+	for _, r := range ebiten.AppendInputChars(nil) {
+		a.typeBookmarkRune(r)
+	}
+}
+
+func (a *App) typeBookmarkRune(r rune) {
+	if r == ' ' {
+		r = '-'
+	}
+	if name := a.bookmark.name + string(r); sqdoc.ValidBookmarkName(name) {
+		a.bookmark.name = name
+		a.bookmark.err = ""
+	}
+}
+
+func (a *App) layoutBookmarkDialog(w, h int, face font.Face) {
+	scale := a.uiScales[a.uiScaleIdx]
+	pw := min(int(420*scale), w-40)
+	ph := min(int(150*scale), h-40)
+	a.bookmark.rect = rect{x: (w - pw) / 2, y: (h - ph) / 2, w: pw, h: ph}
+	r := a.bookmark.rect
+	labelW := a.measureString(face, "Name") + 30
+	rowH := int(30 * scale)
+	a.bookmark.nameRect = rect{x: r.x + labelW, y: r.y + int(44*scale), w: pw - labelW - 20, h: rowH}
+	labels := []string{"Cancel", "Add"}
+	exists := a.hasBookmark(a.bookmark.name)
+	if exists {
+		labels[1] = "Move here"
+		labels = append(labels, "Remove")
+	}
+	buttons := a.placeDialogButtons(face, r, rowH, labels...)
+	a.bookmark.cancelRect, a.bookmark.addRect, a.bookmark.removeRect = buttons[0], buttons[1], rect{}
+	if exists {
+		a.bookmark.removeRect = buttons[2]
+	}
+}
+
+func (a *App) handleBookmarkDialogClick(x, y int) {
+	switch {
+	case !a.bookmark.rect.contains(x, y) || a.bookmark.cancelRect.contains(x, y):
+		a.closeBookmarkDialog()
+	case a.bookmark.addRect.contains(x, y):
+		a.applyBookmarkDialog()
+	case a.bookmark.removeRect.contains(x, y):
+		a.removeDialogBookmark()
+	}
+}
+
+func (a *App) drawBookmarkDialog(screen *ebiten.Image, w, h int, face font.Face) {
+	if !a.bookmark.visible {
+		return
+	}
+	a.layoutBookmarkDialog(w, h, face)
+	r := a.bookmark.rect
+	a.drawDialogFrame(screen, w, h, r, "Bookmark")
+	text.Draw(screen, "Name", face, r.x+20, a.centeredTextBaseline(a.bookmark.nameRect, face), dialogLabelColor)
+	a.drawFindInput(screen, face, a.bookmark.nameRect, a.bookmark.name, true)
+	add := "Add"
+	if a.bookmark.removeRect.w > 0 {
+		add = "Move here"
+	}
+	a.drawDialogNote(screen, face, r.x+20, a.bookmark.addRect, a.bookmark.err, "Link to it as #name")
+	a.drawDialogButtons(screen, face, dialogButton{a.bookmark.addRect, add}, dialogButton{a.bookmark.cancelRect, "Cancel"}, dialogButton{a.bookmark.removeRect, "Remove"})
+}
+
+// crossRefDialog picks a heading or bookmark to insert a cross-reference to.
+type crossRefDialog struct {
+	visible  bool
+	show     sqdoc.RefShow
+	targets  []outlineEntry
+	selected int
+	scroll   int
+	err      string
+
+	rect       rect
+	textRect   rect
+	numberRect rect
+	listRect   rect
+	rows       []rect
+	insertRect rect
+	cancelRect rect
+}
+
+func (a *App) openCrossRefDialog() {
+	a.showInsertMenu = false
+	var targets []outlineEntry
+	for _, e := range a.outlineEntries() {
+		if e.name != "" {
+			targets = append(targets, e)
+		}
+	}
+	a.crossRef = crossRefDialog{visible: true, targets: targets}
+	if len(targets) == 0 {
+		a.crossRef.selected = -1
+		a.crossRef.err = "Add headings or bookmarks to refer to"
+	}
+}
+
+func (a *App) closeCrossRefDialog() {
+	a.crossRef = crossRefDialog{}
+}
+
+func (a *App) applyCrossRefDialog() {
+	if a.crossRef.selected < 0 || a.crossRef.selected >= len(a.crossRef.targets) {
+		return
+	}
+	target := a.crossRef.targets[a.crossRef.selected].name
+	if !a.beginEdit() {
+		return
+	}
+	if err := a.state.InsertCrossRef(target, a.crossRef.show); err != nil {
+		a.crossRef.err = err.Error()
+		return
+	}
+	a.status = "Cross-reference to #" + target + " inserted"
+	a.pendingFollowCaret = true
+	a.closeCrossRefDialog()
+}
+
+func (a *App) handleCrossRefDialogInput() {
+	n := len(a.crossRef.targets)
+	switch {
+	case inpututil.IsKeyJustPressed(ebiten.KeyEnter) || inpututil.IsKeyJustPressed(ebiten.KeyKPEnter):
+		a.applyCrossRefDialog()
+	case inpututil.IsKeyJustPressed(ebiten.KeyArrowDown) && n > 0:
+		a.crossRef.selected = min(a.crossRef.selected+1, n-1)
+	case inpututil.IsKeyJustPressed(ebiten.KeyArrowUp) && n > 0:
+		a.crossRef.selected = max(a.crossRef.selected-1, 0)
+	case inpututil.IsKeyJustPressed(ebiten.KeyTab):
+		a.crossRef.show = 1 - a.crossRef.show
+	}
+	if _, wheelY := ebiten.Wheel(); wheelY > 0 {
+		a.crossRef.scroll--
+	} else if wheelY < 0 {
+		a.crossRef.scroll++
+	}
+}
+
+func (a *App) layoutCrossRefDialog(w, h int, face font.Face) {
+	scale := a.uiScales[a.uiScaleIdx]
+	pw := min(int(480*scale), w-40)
+	ph := min(int(400*scale), h-40)
+	a.crossRef.rect = rect{x: (w - pw) / 2, y: (h - ph) / 2, w: pw, h: ph}
+	r := a.crossRef.rect
+	rowH := int(30 * scale)
+	x := r.x + 20 + a.measureString(face, "Show") + 16
+	a.crossRef.textRect = rect{x: x, y: r.y + int(44*scale), w: a.measureString(face, "Heading text") + 24, h: rowH}
+	a.crossRef.numberRect = rect{x: a.crossRef.textRect.x + a.crossRef.textRect.w + 8, y: a.crossRef.textRect.y, w: a.measureString(face, "Heading number") + 24, h: rowH}
+	buttons := a.placeDialogButtons(face, r, rowH, "Cancel", "Insert")
+	a.crossRef.cancelRect, a.crossRef.insertRect = buttons[0], buttons[1]
+	top := a.crossRef.textRect.y + rowH + 10
+	a.crossRef.listRect = rect{x: r.x + 20, y: top, w: pw - 40, h: buttons[0].y - 10 - top}
+
+	itemH := a.outlineRowHeight()
+	lr := a.crossRef.listRect
+	visible := max((lr.h-4)/itemH, 1)
+	n := len(a.crossRef.targets)
+	if a.crossRef.selected >= 0 {
+		// Keep the selection in view as the arrow keys move it.
+		a.crossRef.scroll = min(a.crossRef.scroll, a.crossRef.selected)
+		a.crossRef.scroll = max(a.crossRef.scroll, a.crossRef.selected-visible+1)
+	}
+	a.crossRef.scroll = max(min(a.crossRef.scroll, n-visible), 0)
+	a.crossRef.rows = a.crossRef.rows[:0]
+	for i := a.crossRef.scroll; i < n && len(a.crossRef.rows) < visible; i++ {
+		a.crossRef.rows = append(a.crossRef.rows, rect{x: lr.x + 2, y: lr.y + 2 + itemH*len(a.crossRef.rows), w: lr.w - 4, h: itemH})
+	}
+}
+
+func (a *App) handleCrossRefDialogClick(x, y int) {
+	switch {
+	case !a.crossRef.rect.contains(x, y) || a.crossRef.cancelRect.contains(x, y):
+		a.closeCrossRefDialog()
+	case a.crossRef.textRect.contains(x, y):
+		a.crossRef.show = sqdoc.RefShowText
+	case a.crossRef.numberRect.contains(x, y):
+		a.crossRef.show = sqdoc.RefShowNumber
+	case a.crossRef.insertRect.contains(x, y):
+		a.applyCrossRefDialog()
+	}
+	for n, row := range a.crossRef.rows {
+		if row.contains(x, y) {
+			a.crossRef.selected = a.crossRef.scroll + n
+		}
+	}
+}
+
+func (a *App) drawCrossRefDialog(screen *ebiten.Image, w, h int, face font.Face) {
+	if !a.crossRef.visible {
+		return
+	}
+	a.layoutCrossRefDialog(w, h, face)
+	r := a.crossRef.rect
+	a.drawDialogFrame(screen, w, h, r, "Insert Cross-reference")
+	text.Draw(screen, "Show", face, r.x+20, a.centeredTextBaseline(a.crossRef.textRect, face), dialogLabelColor)
+	for _, opt := range []struct {
+		r     rect
+		label string
+		on    bool
+	}{
+		{a.crossRef.textRect, "Heading text", a.crossRef.show == sqdoc.RefShowText},
+		{a.crossRef.numberRect, "Heading number", a.crossRef.show == sqdoc.RefShowNumber},
+	} {
+		bg := color.RGBA{R: 241, G: 245, B: 251, A: 255}
+		if opt.on {
+			bg = color.RGBA{R: 200, G: 220, B: 246, A: 255}
+		}
+		a.drawFilledRectOnScreen(screen, opt.r.x, opt.r.y, opt.r.w, opt.r.h, bg)
+		text.Draw(screen, opt.label, face, opt.r.x+12, a.centeredTextBaseline(opt.r, face), dialogLabelColor)
+	}
+
+	lr := a.crossRef.listRect
+	a.drawFilledRectOnScreen(screen, lr.x, lr.y, lr.w, lr.h, color.RGBA{R: 255, G: 255, B: 255, A: 255})
+	indent := int(14 * a.uiScales[a.uiScaleIdx])
+	for n, row := range a.crossRef.rows {
+		i := a.crossRef.scroll + n
+		if i == a.crossRef.selected {
+			a.drawFilledRectOnScreen(screen, row.x, row.y, row.w, row.h, color.RGBA{R: 215, G: 229, B: 248, A: 255})
+		}
+		e := a.crossRef.targets[i]
+		x := row.x + 8 + e.depth*indent
+		text.Draw(screen, a.fitLabel(face, e.label, row.x+row.w-4-x), face, x, a.centeredTextBaseline(row, face), dialogLabelColor)
+	}
+	a.drawDialogNote(screen, face, r.x+20, a.crossRef.insertRect, a.crossRef.err, "Fields update as the target changes")
+	a.drawDialogButtons(screen, face, dialogButton{a.crossRef.insertRect, "Insert"}, dialogButton{a.crossRef.cancelRect, "Cancel"})
+}
+
+type refsKey struct {
+	state    *editor.State
+	revision uint64
+}
+
+// refreshCrossRefs brings cross-reference fields and note numbers up to
+// date after edits.
+// The refresh is part of the edit, so it takes no undo step of its own.
+func (a *App) refreshCrossRefs() {
+	key := refsKey{state: a.state, revision: a.docRevision}
+	if key == a.refsKey {
+		return
+	}
+	refs := a.state.UpdateCrossRefs()
+	if notes := a.state.UpdateNoteNumbers(); refs || notes {
+		a.docRevision++
+	}
+	a.refsKey = refsKey{state: a.state, revision: a.docRevision}
+}
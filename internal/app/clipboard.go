@@ -0,0 +1,114 @@
+package app
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"sqdoc/pkg/convert/fragment"
+	htmlconv "sqdoc/pkg/convert/html"
+	"sqdoc/pkg/convert/rtf"
+	"sqdoc/pkg/sqdoc"
+
+	textclipboard "github.com/atotto/clipboard"
+)
+
+// Clipboard flavours by MIME type; clipboard_windows.go maps them to the
+// registered Windows format names.
+const (
+	flavourRTF    = "text/rtf"
+	flavourHTML   = "text/html"
+	flavourNative = fragment.MediaType
+)
+
+// clipboardFlavours is everything one copy offers. Empty flavours are not
+// published.
+type clipboardFlavours struct {
+	plain  string
+	rtf    []byte
+	html   []byte
+	native []byte
+}
+
+// copySelection puts the selection on the clipboard as plain text and, where
+// the platform allows several flavours at once, as SIDE's own fragment
+// format, RTF and HTML so other programs keep as much formatting as they
+// understand.
+func (a *App) copySelection() error {
+	doc := &sqdoc.Document{
+		Metadata: sqdoc.Metadata{
+			ParagraphGap:        a.state.Doc.Metadata.ParagraphGap,
+			PreferredFontFamily: a.state.Doc.Metadata.PreferredFontFamily,
+		},
+		Blocks: a.state.SelectedBlocks(),
+		Styles: a.state.Doc.Styles,
+	}
+	flavours := clipboardFlavours{plain: stripImageTokens(a.state.SelectedText())}
+	// A flavour that fails to export is left out rather than failing the copy.
+	flavours.native, _ = fragment.Export(doc, fragment.Options{})
+	if a.state.SelectionInCode() {
+		// Code goes to other programs as the raw text only, so editors
+		// paste it without fonts or colours.
+		flavours.plain = a.state.SelectedText()
+	} else {
+		flavours.rtf, _ = rtf.Export(doc, rtf.Options{})
+		flavours.html, _ = htmlconv.Export(doc, htmlconv.Options{})
+	}
+	a.lastCopy = flavours
+	return writeClipboardFlavours(flavours)
+}
+
+// clipboardBlocks reads the clipboard as styled blocks, preferring SIDE's
+// own format, then RTF, then HTML. Unreadable data is ignored so paste falls
+// back to plain text.
+func (a *App) clipboardBlocks() ([]sqdoc.Block, bool) {
+	data, ok := readClipboardFlavour(flavourNative)
+	if !ok && a.lastCopy.native != nil {
+		// Where only plain text could be published, the last copy is still
+		// ours as long as the clipboard text has not changed since.
+		if plain, err := textclipboard.ReadAll(); err == nil && plain == a.lastCopy.plain {
+			data, ok = a.lastCopy.native, true
+		}
+	}
+	if ok {
+		if doc, err := fragment.Import(data, fragment.Options{MediaDir: a.managedImageDir()}); err == nil {
+			return doc.Blocks, true
+		}
+	}
+	if data, ok := readClipboardFlavour(flavourRTF); ok {
+		if doc, _, err := rtf.Import(data, rtf.Options{MediaDir: a.pasteMediaDir()}); err == nil {
+			return doc.Blocks, true
+		}
+	}
+	if data, ok := readClipboardFlavour(flavourHTML); ok {
+		if doc, _, err := htmlconv.Import(data, htmlconv.Options{MediaDir: a.pasteMediaDir()}); err == nil {
+			return doc.Blocks, true
+		}
+	}
+	return nil, false
+}
+
+// pasteMediaDir is a new folder under the managed image folder for the
+// pictures one paste extracts; importers only create it when they write
+// an image.
+func (a *App) pasteMediaDir() string {
+	return filepath.Join(a.managedImageDir(), fmt.Sprintf("paste_%d", time.Now().UnixNano()))
+}
+
+// stripImageTokens drops inline image tokens, which mean nothing to other
+// programs and would come back as pictures in a plain-text paste.
+func stripImageTokens(s string) string {
+	tokens := sqdoc.ParseImageTokens([]byte(s))
+	if len(tokens) == 0 {
+		return s
+	}
+	var b strings.Builder
+	last := 0
+	for _, t := range tokens {
+		b.WriteString(s[last:t.Start])
+		last = t.End
+	}
+	b.WriteString(s[last:])
+	return b.String()
+}
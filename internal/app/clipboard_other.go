@@ -0,0 +1,41 @@
+//go:build !windows
+
+package app
+
+import (
+	"os"
+	"os/exec"
+
+	textclipboard "github.com/atotto/clipboard"
+)
+
+// writeClipboardFlavours publishes plain text only: xclip, xsel and
+// wl-copy serve a single flavour per selection, so offering anything richer
+// would hide the text from programs that do not read it. Pasting between
+// SIDE tabs still keeps formatting through App.lastCopy.
+func writeClipboardFlavours(f clipboardFlavours) error {
+	return textclipboard.WriteAll(f.plain)
+}
+
+// readClipboardFlavour asks the same helpers atotto/clipboard uses for the
+// given MIME type, such as the RTF word processors and browsers offer.
+func readClipboardFlavour(mime string) ([]byte, bool) {
+	var cmd *exec.Cmd
+	switch {
+	case os.Getenv("WAYLAND_DISPLAY") != "":
+		if _, err := exec.LookPath("wl-paste"); err != nil {
+			return nil, false
+		}
+		cmd = exec.Command("wl-paste", "--no-newline", "--type", mime)
+	default:
+		if _, err := exec.LookPath("xclip"); err != nil {
+			return nil, false
+		}
+		cmd = exec.Command("xclip", "-out", "-selection", "clipboard", "-target", mime)
+	}
+	out, err := cmd.Output()
+	if err != nil || len(out) == 0 {
+		return nil, false
+	}
+	return out, true
+}
@@ -0,0 +1,224 @@
+//go:build windows
+
+package app
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"unicode/utf16"
+	"unsafe"
+)
+
+const (
+	cfUnicodeText = 13
+	gmemMoveable  = 0x0002
+)
+
+var (
+	user32                     = syscall.NewLazyDLL("user32.dll")
+	kernel32                   = syscall.NewLazyDLL("kernel32.dll")
+	openClipboard              = user32.NewProc("OpenClipboard")
+	closeClipboard             = user32.NewProc("CloseClipboard")
+	emptyClipboard             = user32.NewProc("EmptyClipboard")
+	getClipboardData           = user32.NewProc("GetClipboardData")
+	setClipboardData           = user32.NewProc("SetClipboardData")
+	isClipboardFormatAvailable = user32.NewProc("IsClipboardFormatAvailable")
+	registerClipboardFormat    = user32.NewProc("RegisterClipboardFormatW")
+	globalAlloc                = kernel32.NewProc("GlobalAlloc")
+	globalFree                 = kernel32.NewProc("GlobalFree")
+	globalLock                 = kernel32.NewProc("GlobalLock")
+	globalUnlock               = kernel32.NewProc("GlobalUnlock")
+	globalSize                 = kernel32.NewProc("GlobalSize")
+	moveMemory                 = kernel32.NewProc("RtlMoveMemory")
+)
+
+// windowsFormatNames are the registered names other Windows programs use
+// for each flavour.
+var windowsFormatNames = map[string]string{
+	flavourRTF:    "Rich Text Format",
+	flavourHTML:   "HTML Format",
+	flavourNative: flavourNative,
+}
+
+func registeredFormat(mime string) uintptr {
+	name, _ := syscall.UTF16PtrFromString(windowsFormatNames[mime])
+	format, _, _ := registerClipboardFormat.Call(uintptr(unsafe.Pointer(name)))
+	return format
+}
+
+// openClipboardRetry waits briefly for another program to release the
+// clipboard, which it can hold for a few milliseconds after changing it.
+func openClipboardRetry() error {
+	for i := 0; i < 10; i++ {
+		if r, _, _ := openClipboard.Call(0); r != 0 {
+			return nil
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return errors.New("clipboard is busy")
+}
+
+// writeClipboardFlavours publishes every flavour together so each program
+// picks the richest one it understands.
+func writeClipboardFlavours(f clipboardFlavours) error {
+	rich := []struct {
+		format uintptr
+		data   []byte
+	}{
+		{registeredFormat(flavourNative), f.native},
+		{registeredFormat(flavourRTF), nulTerminated(f.rtf)},
+		{registeredFormat(flavourHTML), nulTerminated(cfHTML(f.html))},
+	}
+	if err := openClipboardRetry(); err != nil {
+		return err
+	}
+	defer closeClipboard.Call()
+	if r, _, err := emptyClipboard.Call(); r == 0 {
+		return err
+	}
+	text := utf16.Encode([]rune(f.plain + "\x00"))
+	if err := setClipboardBytes(cfUnicodeText, unsafe.Slice((*byte)(unsafe.Pointer(&text[0])), len(text)*2)); err != nil {
+		return err
+	}
+	for _, r := range rich {
+		if r.format == 0 || len(r.data) == 0 {
+			continue
+		}
+		if err := setClipboardBytes(r.format, r.data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func nulTerminated(b []byte) []byte {
+	if len(b) == 0 {
+		return nil
+	}
+	return append(append([]byte(nil), b...), 0)
+}
+
+// cfHTML wraps an HTML document in the CF_HTML header, whose byte offsets
+// mark the whole document and the fragment between the body tags.
+func cfHTML(doc []byte) []byte {
+	if len(doc) == 0 {
+		return nil
+	}
+	const (
+		header     = "Version:0.9\r\nStartHTML:%010d\r\nEndHTML:%010d\r\nStartFragment:%010d\r\nEndFragment:%010d\r\n"
+		startMark  = "<!--StartFragment-->"
+		endMark    = "<!--EndFragment-->"
+		headerSize = len(header) - 4*len("%010d") + 4*10
+	)
+	html := string(doc)
+	start, end := strings.Index(html, "<body>"), strings.LastIndex(html, "</body>")
+	if start < 0 || end < start {
+		start, end = 0, len(html)
+	} else {
+		start += len("<body>")
+	}
+	html = html[:start] + startMark + html[start:end] + endMark + html[end:]
+	fragStart := headerSize + start + len(startMark)
+	fragEnd := headerSize + end + len(startMark)
+	return append(fmt.Appendf(nil, header, headerSize, headerSize+len(html), fragStart, fragEnd), html...)
+}
+
+func setClipboardBytes(format uintptr, data []byte) error {
+	h, _, err := globalAlloc.Call(gmemMoveable, uintptr(len(data)))
+	if h == 0 {
+		return err
+	}
+	p, _, err := globalLock.Call(h)
+	if p == 0 {
+		globalFree.Call(h)
+		return err
+	}
+	moveMemory.Call(p, uintptr(unsafe.Pointer(&data[0])), uintptr(len(data)))
+	globalUnlock.Call(h)
+	if r, _, err := setClipboardData.Call(format, h); r == 0 {
+		globalFree.Call(h)
+		return err
+	}
+	return nil
+}
+
+// readClipboardFlavour returns the clipboard data for mime, if any.
+// Text flavours are cut at their terminating NUL.
+func readClipboardFlavour(mime string) ([]byte, bool) {
+	format := registeredFormat(mime)
+	if format == 0 {
+		return nil, false
+	}
+	if r, _, _ := isClipboardFormatAvailable.Call(format); r == 0 {
+		return nil, false
+	}
+	if openClipboardRetry() != nil {
+		return nil, false
+	}
+	defer closeClipboard.Call()
+	h, _, _ := getClipboardData.Call(format)
+	if h == 0 {
+		return nil, false
+	}
+	p, _, _ := globalLock.Call(h)
+	if p == 0 {
+		return nil, false
+	}
+	defer globalUnlock.Call(h)
+	n, _, _ := globalSize.Call(h)
+	if n == 0 {
+		return nil, false
+	}
+	data := make([]byte, n)
+	moveMemory.Call(uintptr(unsafe.Pointer(&data[0])), p, n)
+	if mime != flavourNative {
+		if i := bytes.IndexByte(data, 0); i >= 0 {
+			data = data[:i]
+		}
+	}
+	if mime == flavourHTML {
+		data = htmlFromCFHTML(data)
+	}
+	return data, len(data) > 0
+}
+
+// htmlFromCFHTML strips the CF_HTML description header. Writers that give
+// no surrounding context mark StartHTML as -1, so the fragment offsets and
+// finally the first tag are tried in turn.
+func htmlFromCFHTML(data []byte) []byte {
+	offset := func(key string) int {
+		i := bytes.Index(data, []byte(key+":"))
+		if i < 0 {
+			return -1
+		}
+		rest := data[i+len(key)+1:]
+		if end := bytes.IndexAny(rest, "\r\n"); end >= 0 {
+			rest = rest[:end]
+		}
+		n, err := strconv.Atoi(string(bytes.TrimSpace(rest)))
+		if err != nil || n > len(data) {
+			return -1
+		}
+		return n
+	}
+	start := offset("StartHTML")
+	if start < 0 {
+		start = offset("StartFragment")
+	}
+	if start < 0 {
+		start = max(bytes.IndexByte(data, '<'), 0)
+	}
+	end := offset("EndHTML")
+	if end < start {
+		end = offset("EndFragment")
+	}
+	if end < start {
+		end = len(data)
+	}
+	return data[start:end]
+}
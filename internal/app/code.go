@@ -0,0 +1,201 @@
+package app
+
+import (
+	"image/color"
+	"strconv"
+	"strings"
+
+	"sqdoc/pkg/highlight"
+	"sqdoc/pkg/sqdoc"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"golang.org/x/image/font"
+)
+
+// codePaddingPt is the gap between a code block's shading and its text.
+const codePaddingPt = 6
+
+var codeLanguages = []struct {
+	tag, label string
+}{
+	{"", "Plain text"},
+	{"go", "Go"},
+	{"python", "Python"},
+	{"javascript", "JavaScript"},
+	{"json", "JSON"},
+	{"shell", "Shell"},
+	{"sql", "SQL"},
+	{"yaml", "YAML"},
+}
+
+var tokenColors = map[highlight.Kind]uint32{
+	highlight.Keyword:  0x8A3FA0FF,
+	highlight.Builtin:  0x1F63A8FF,
+	highlight.String:   0x2E7D32FF,
+	highlight.Number:   0xB35900FF,
+	highlight.Comment:  0x78838FFF,
+	highlight.Key:      0xA8323EFF,
+	highlight.Variable: 0x00796BFF,
+}
+
+// codeLayout is the shaded box of a code block, in document pixels and on
+// screen, with the width of its line number gutter.
+type codeLayout struct {
+	docX, docY int
+	x, y       int
+	w, h       int
+	gutter     int
+}
+
+// layoutCode lays out code block bi from docY down and returns the y below
+// it and its right edge. Code never wraps; its lines are numbered in the
+// gutter when the block asks for it.
+func (a *App) layoutCode(bi, docY, width int) (int, int) {
+	tb := a.state.Doc.Blocks[bi].Text
+	pad := max(3, int(float32(codePaddingPt)*a.uiScales[a.uiScaleIdx]))
+	runs := codeRuns(tb, a.state.BlockRuns(bi))
+	numberAttr := normalizeStyleAttr(runs[0].Attr, a.preferredFontFamily)
+	numberAttr.ColorRGBA = 0x8A94A3FF
+	numberFace := a.uiFace(int(numberAttr.FontSizePt), false, false, sqdoc.FontFamilyMonospace)
+	gutter := 0
+	if tb.Code.LineNumbers {
+		digits := len(strconv.Itoa(strings.Count(string(tb.UTF8), "\n") + 1))
+		gutter = a.measureString(numberFace, strings.Repeat("0", digits)) + 2*pad
+	}
+	para := tb.Para
+	para.Align = sqdoc.AlignLeft
+	lines, bottom := a.layoutParagraph(paragraphLayout{
+		block:      bi,
+		text:       tb.UTF8,
+		runs:       runs,
+		para:       para,
+		listIndent: gutter,
+		left:       8 + pad,
+		width:      width - 2*pad,
+	}, docY+pad)
+
+	right := 0
+	n := 0
+	for i := range lines {
+		ll := &lines[i]
+		right = max(right, ll.docX+ll.width+pad)
+		if ll.startByte > 0 && tb.UTF8[ll.startByte-1] != '\n' {
+			continue
+		}
+		n++
+		if gutter > 0 {
+			ll.marker = strconv.Itoa(n)
+			ll.markerFace = numberFace
+			ll.markerAttr = numberAttr
+			ll.markerDocX = 8 + gutter - pad - a.measureString(numberFace, ll.marker)
+		}
+	}
+	a.lineLayouts = append(a.lineLayouts, lines...)
+	bottom += pad
+	a.codeLayouts = append(a.codeLayouts, codeLayout{docX: 8, docY: docY, w: max(width, right-8), h: bottom - docY, gutter: gutter})
+	return bottom, right
+}
+
+// codeRuns restyles a code block's runs for display: the monospace face,
+// coloured by the lexer for the block's language. Colours are never
+// stored, so changing the language recolours the block at once.
+func codeRuns(tb *sqdoc.TextBlock, runs []sqdoc.StyleRun) []sqdoc.StyleRun {
+	if len(runs) == 0 {
+		runs = []sqdoc.StyleRun{{Start: 0, End: uint32(len(tb.UTF8)), Attr: defaultAttr()}}
+	}
+	tokens := highlight.Tokens(tb.Code.Language, tb.UTF8)
+	out := make([]sqdoc.StyleRun, 0, len(runs)+2*len(tokens))
+	add := func(start, end int, attr sqdoc.StyleAttr, kind highlight.Kind) {
+		attr.FontFamily = sqdoc.FontFamilyMonospace
+		if kind != highlight.Plain {
+			attr.ColorRGBA = tokenColors[kind]
+			attr.Italic = attr.Italic || kind == highlight.Comment
+		}
+		out = append(out, sqdoc.StyleRun{Start: uint32(start), End: uint32(end), Attr: attr})
+	}
+	next := 0
+	for _, r := range runs {
+		pos, end := int(r.Start), int(r.End)
+		if pos == end {
+			add(pos, end, r.Attr, highlight.Plain)
+			continue
+		}
+		for next < len(tokens) && tokens[next].End <= pos {
+			next++
+		}
+		for t := next; t < len(tokens) && tokens[t].Start < end; t++ {
+			tok := tokens[t]
+			if tok.Start > pos {
+				add(pos, tok.Start, r.Attr, highlight.Plain)
+				pos = tok.Start
+			}
+			stop := min(tok.End, end)
+			add(pos, stop, r.Attr, tok.Kind)
+			pos = stop
+		}
+		if pos < end {
+			add(pos, end, r.Attr, highlight.Plain)
+		}
+	}
+	return out
+}
+
+// drawCodeBlocks shades code blocks and their gutters under the selection
+// and document text.
+func (a *App) drawCodeBlocks() {
+	for _, cl := range a.codeLayouts {
+		a.fillRectWithinContent(cl.x, cl.y, cl.w, cl.h, color.RGBA{R: 243, G: 245, B: 248, A: 255})
+		if cl.gutter > 0 {
+			a.fillRectWithinContent(cl.x, cl.y, cl.gutter, cl.h, color.RGBA{R: 232, G: 236, B: 241, A: 255})
+		}
+		a.fillRectWithinContent(cl.x, cl.y, 2, cl.h, color.RGBA{R: 176, G: 188, B: 204, A: 255})
+	}
+}
+
+func (a *App) layoutCodeMenuBounds() {
+	a.codeMenuRect = rect{}
+	a.codeMenuItems = a.codeMenuItems[:0]
+	if !a.showCodeMenu {
+		return
+	}
+	add := func(label string, active bool, apply func()) {
+		a.codeMenuItems = append(a.codeMenuItems, menuItem{label: label, active: active, apply: apply})
+	}
+	code := a.state.CodeBlock()
+	if code == nil {
+		for _, l := range codeLanguages {
+			tag := l.tag
+			add("Code block: "+l.label, false, func() {
+				a.state.ToggleCodeBlock(tag)
+				a.showCodeMenu = false
+			})
+		}
+		a.codeMenuRect = a.layoutMenuItems("code_menu", a.codeMenuItems, 200)
+		return
+	}
+	current := highlight.Canonical(code.Language)
+	for _, l := range codeLanguages {
+		tag := l.tag
+		add(l.label, current == tag, func() { a.state.SetCodeLanguage(tag) })
+	}
+	numbers := code.LineNumbers
+	add("Line numbers", numbers, func() { a.state.SetCodeLineNumbers(!numbers) })
+	add("Back to paragraphs", false, func() {
+		a.state.ToggleCodeBlock("")
+		a.showCodeMenu = false
+	})
+	a.codeMenuRect = a.layoutMenuItems("code_menu", a.codeMenuItems, 200)
+}
+
+func (a *App) drawCodeMenu(screen *ebiten.Image, face font.Face) {
+	if !a.showCodeMenu {
+		return
+	}
+	a.layoutCodeMenuBounds()
+	a.drawMenuItems(screen, face, a.codeMenuRect, a.codeMenuItems)
+}
+
+func (a *App) handleCodeMenuClick(x, y int) bool {
+	a.layoutCodeMenuBounds()
+	return a.clickMenuItems(x, y, "code_menu", a.codeMenuRect, a.codeMenuItems, &a.showCodeMenu)
+}
@@ -0,0 +1,411 @@
+package app
+
+import (
+	"image/color"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"sqdoc/internal/editor"
+	"sqdoc/pkg/sqdoc"
+
+	textclipboard "github.com/atotto/clipboard"
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+	"github.com/hajimehoshi/ebiten/v2/text"
+	"golang.org/x/image/font"
+)
+
+// commentPanel is the sidebar right of the document listing the comment
+// threads.
+type commentPanel struct {
+	visible bool
+	rect    rect
+	// scroll is the number of threads scrolled past.
+	scroll int
+	cards  []commentCard
+}
+
+// commentCard is a thread as the panel last drew it.
+type commentCard struct {
+	id       uint64
+	resolved bool
+	r        rect
+	reply    rect
+	resolve  rect
+	remove   rect
+}
+
+// commentDialog takes the text of a new comment or, when replyTo is set, of
+// a reply.
+type commentDialog struct {
+	visible bool
+	replyTo uint64
+	text    string
+	err     string
+
+	rect       rect
+	textRect   rect
+	postRect   rect
+	cancelRect rect
+}
+
+// maxCommentLines caps the lines each comment of a card takes.
+const maxCommentLines = 4
+
+func (a *App) toggleComments() {
+	a.comments.visible = !a.comments.visible
+	a.comments.scroll = 0
+}
+
+func (a *App) openCommentDialog(replyTo uint64) {
+	a.showInsertMenu = false
+	a.comments.visible = true
+	a.comment = commentDialog{visible: true, replyTo: replyTo}
+}
+
+func (a *App) closeCommentDialog() {
+	a.comment = commentDialog{}
+}
+
+func (a *App) applyCommentDialog() {
+	author, now := sqdoc.UserName(), time.Now().Unix()
+	if !a.beginEdit() {
+		return
+	}
+	if a.comment.replyTo != 0 {
+		if err := a.state.ReplyToComment(a.comment.replyTo, author, a.comment.text, now); err != nil {
+			a.comment.err = err.Error()
+			return
+		}
+		a.status = "Reply added"
+	} else {
+		if _, err := a.state.AddComment(author, a.comment.text, now); err != nil {
+			a.comment.err = err.Error()
+			return
+		}
+		a.status = "Comment added"
+	}
+	a.closeCommentDialog()
+}
+
+func (a *App) resolveComment(id uint64, resolved bool) {
+	if !a.beginEdit() {
+		return
+	}
+	if !a.state.ResolveComment(id, resolved) {
+		return
+	}
+	a.status = "Comment resolved"
+	if !resolved {
+		a.status = "Comment reopened"
+	}
+}
+
+func (a *App) deleteComment(id uint64) {
+	if !a.beginEdit() {
+		return
+	}
+	if a.state.DeleteComment(id) {
+		a.status = "Comment deleted"
+	}
+}
+
+// currentComment returns the thread at the caret, or at the start of the
+// selection, or 0.
+func (a *App) currentComment() uint64 {
+	pos := editor.Position{Block: a.state.CurrentBlock, Byte: a.state.CaretByte}
+	if start, _, has := a.state.SelectionRange(); has {
+		pos = start
+	}
+	ids := a.state.CommentsAt(pos.Block, pos.Byte)
+	if len(ids) == 0 && pos.Byte > 0 {
+		ids = a.state.CommentsAt(pos.Block, pos.Byte-1)
+	}
+	if len(ids) == 0 {
+		return 0
+	}
+	return ids[len(ids)-1]
+}
+
+// handleCommentDialogInput feeds keys to the comment dialog. Pasted text is
+// joined onto one line.
+func (a *App) handleCommentDialogInput(ctrl bool) {
+	switch {
+	case inpututil.IsKeyJustPressed(ebiten.KeyEnter) || inpututil.IsKeyJustPressed(ebiten.KeyKPEnter):
+		a.applyCommentDialog()
+		return
+	case ctrl && inpututil.IsKeyJustPressed(ebiten.KeyV):
+		if clip, err := textclipboard.ReadAll(); err == nil {
+			a.typeComment(strings.Join(strings.Fields(clip), " "))
+		}
+		return
+	case inpututil.IsKeyJustPressed(ebiten.KeyBackspace):
+		if len(a.comment.text) > 0 {
+			_, size := utf8.DecodeLastRuneInString(a.comment.text)
+			a.comment.text = a.comment.text[:len(a.comment.text)-size]
+		}
+	}
+	if ctrl {
+		return
+	}
+	a.typeComment(string(ebiten.AppendInputChars(nil)))
+}
+
+func (a *App) typeComment(s string) {
+	if s == "" || len(a.comment.text)+len(s) > sqdoc.MaxCommentText {
+		return
+	}
+	a.comment.text += s
+	a.comment.err = ""
+}
+
+func (a *App) layoutCommentDialog(w, h int, face font.Face) {
+	scale := a.uiScales[a.uiScaleIdx]
+	pw := min(int(480*scale), w-40)
+	ph := min(int(150*scale), h-40)
+	a.comment.rect = rect{x: (w - pw) / 2, y: (h - ph) / 2, w: pw, h: ph}
+	r := a.comment.rect
+	rowH := int(30 * scale)
+	a.comment.textRect = rect{x: r.x + 20, y: r.y + int(44*scale), w: pw - 40, h: rowH}
+	buttons := a.placeDialogButtons(face, r, rowH, "Cancel", "Post")
+	a.comment.cancelRect, a.comment.postRect = buttons[0], buttons[1]
+}
+
+func (a *App) handleCommentDialogClick(x, y int) {
+	switch {
+	case !a.comment.rect.contains(x, y) || a.comment.cancelRect.contains(x, y):
+		a.closeCommentDialog()
+	case a.comment.postRect.contains(x, y):
+		a.applyCommentDialog()
+	}
+}
+
+func (a *App) drawCommentDialog(screen *ebiten.Image, w, h int, face font.Face) {
+	if !a.comment.visible {
+		return
+	}
+	a.layoutCommentDialog(w, h, face)
+	r := a.comment.rect
+	title, hint := "New Comment", "On the selection or the word at the caret"
+	if a.comment.replyTo != 0 {
+		title, hint = "Reply", ""
+	}
+	a.drawDialogFrame(screen, w, h, r, title)
+	a.drawFindInput(screen, face, a.comment.textRect, a.comment.text, true)
+	a.drawDialogNote(screen, face, r.x+20, a.comment.postRect, a.comment.err, hint)
+	a.drawDialogButtons(screen, face, dialogButton{a.comment.postRect, "Post"}, dialogButton{a.comment.cancelRect, "Cancel"})
+}
+
+// drawCommentHighlights shades the text of the open threads, the one at the
+// caret more strongly. Resolved threads are not shaded.
+func (a *App) drawCommentHighlights() {
+	if len(a.state.Doc.Comments) == 0 {
+		return
+	}
+	current := a.currentComment()
+	for _, c := range a.state.Comments() {
+		if c.Resolved {
+			continue
+		}
+		clr := color.RGBA{R: 255, G: 240, B: 200, A: 255}
+		if c.ID == current {
+			clr = color.RGBA{R: 255, G: 214, B: 140, A: 255}
+		}
+		for _, ll := range a.lineLayouts {
+			if ll.block != c.Block || ll.inCell || ll.y+ll.height < a.contentRect.y || ll.y > a.contentRect.y+a.contentRect.h {
+				continue
+			}
+			from := max(int(c.Start), ll.startByte)
+			to := min(int(c.End), ll.startByte+len(ll.text))
+			if to <= from {
+				continue
+			}
+			x0 := ll.viewX + a.lineAdvance(ll, from-ll.startByte)
+			x1 := ll.viewX + a.lineAdvance(ll, to-ll.startByte)
+			a.fillRectWithinContent(x0, ll.y+1, x1-x0, ll.height-2, clr)
+		}
+	}
+}
+
+// wrapWords breaks s into lines of at most w pixels, keeping at most limit
+// lines and ending a cut one with an ellipsis.
+func (a *App) wrapWords(face font.Face, s string, w, limit int) []string {
+	var lines []string
+	line := ""
+	for _, word := range strings.Fields(s) {
+		if line != "" && a.measureString(face, line+" "+word) > w {
+			lines = append(lines, line)
+			line = ""
+		}
+		if line != "" {
+			line += " "
+		}
+		line += word
+	}
+	if line != "" {
+		lines = append(lines, line)
+	}
+	if len(lines) > limit {
+		lines = lines[:limit]
+		lines[limit-1] = a.fitLabel(face, lines[limit-1]+" …", w)
+	}
+	for i := range lines {
+		lines[i] = a.fitLabel(face, lines[i], w)
+	}
+	return lines
+}
+
+func commentByline(c sqdoc.Comment) string {
+	by := c.Author
+	if by == "" {
+		by = "Unknown"
+	}
+	if c.CreatedUnix != 0 {
+		by += "  " + time.Unix(c.CreatedUnix, 0).Format("Jan 2 15:04")
+	}
+	return by
+}
+
+func (a *App) drawCommentPanel(screen *ebiten.Image, face font.Face) {
+	r := a.comments.rect
+	a.comments.cards = a.comments.cards[:0]
+	if !a.comments.visible || r.w <= 0 || r.h <= 0 {
+		return
+	}
+	a.drawFilledRectOnScreen(screen, r.x, r.y, r.w, r.h, color.RGBA{R: 247, G: 250, B: 254, A: 255})
+	a.drawFilledRectOnScreen(screen, r.x, r.y, r.w, 26, color.RGBA{R: 235, G: 241, B: 249, A: 255})
+	a.drawFilledRectOnScreen(screen, r.x, r.y, 1, r.h, color.RGBA{R: 188, G: 198, B: 214, A: 255})
+	text.Draw(screen, "Comments", face, r.x+10, r.y+17, color.RGBA{R: 47, G: 60, B: 78, A: 255})
+
+	threads := a.state.Comments()
+	if len(threads) == 0 {
+		text.Draw(screen, "No comments", face, r.x+10, r.y+48, color.RGBA{R: 110, G: 122, B: 140, A: 255})
+		return
+	}
+	a.comments.scroll = max(min(a.comments.scroll, len(threads)-1), 0)
+	scale := a.uiScales[a.uiScaleIdx]
+	lineH := max(int(18*scale), 15)
+	buttonH := max(int(22*scale), 18)
+	current := a.currentComment()
+	bold := a.uiFace(10, true, false, sqdoc.FontFamilySans)
+	y := r.y + 32
+	for _, c := range threads[a.comments.scroll:] {
+		card := commentCard{id: c.ID, resolved: c.Resolved}
+		textW := r.w - 36
+		type row struct {
+			s    string
+			bold bool
+			x    int
+		}
+		var rows []row
+		for n, m := range c.Comments {
+			if c.Resolved && n > 0 {
+				break
+			}
+			x := r.x + 18
+			if n > 0 {
+				x += int(12 * scale)
+			}
+			rows = append(rows, row{s: a.fitLabel(bold, commentByline(m), textW), bold: true, x: x})
+			limit := maxCommentLines
+			if c.Resolved {
+				limit = 1
+			}
+			for _, s := range a.wrapWords(face, m.Text, textW-(x-r.x-18), limit) {
+				rows = append(rows, row{s: s, x: x})
+			}
+		}
+		h := len(rows)*lineH + buttonH + 16
+		if y+h > r.y+r.h-4 {
+			break
+		}
+		card.r = rect{x: r.x + 8, y: y, w: r.w - 16, h: h}
+		bg := color.RGBA{R: 255, G: 255, B: 255, A: 255}
+		switch {
+		case c.ID == current:
+			bg = color.RGBA{R: 255, G: 244, B: 222, A: 255}
+		case c.Resolved:
+			bg = color.RGBA{R: 240, G: 243, B: 247, A: 255}
+		}
+		a.drawFilledRectOnScreen(screen, card.r.x, card.r.y, card.r.w, card.r.h, bg)
+		edge := color.RGBA{R: 240, G: 170, B: 70, A: 255}
+		if c.Resolved {
+			edge = color.RGBA{R: 176, G: 186, B: 200, A: 255}
+		}
+		a.drawFilledRectOnScreen(screen, card.r.x, card.r.y, 3, card.r.h, edge)
+		fg := color.RGBA{R: 42, G: 58, B: 82, A: 255}
+		if c.Resolved {
+			fg = color.RGBA{R: 120, G: 130, B: 146, A: 255}
+		}
+		ty := y + 4
+		for _, rw := range rows {
+			f := face
+			if rw.bold {
+				f = bold
+			}
+			text.Draw(screen, rw.s, f, rw.x, a.centeredTextBaseline(rect{y: ty, h: lineH}, f), fg)
+			ty += lineH
+		}
+		x := card.r.x + 10
+		place := func(label string) rect {
+			b := rect{x: x, y: ty + 4, w: a.measureString(face, label) + 16, h: buttonH}
+			x += b.w + 6
+			return b
+		}
+		resolve := "Resolve"
+		if c.Resolved {
+			resolve = "Reopen"
+		}
+		card.reply = place("Reply")
+		card.resolve = place(resolve)
+		card.remove = place("Delete")
+		a.drawDialogButtons(screen, face, dialogButton{card.reply, "Reply"}, dialogButton{card.resolve, resolve}, dialogButton{card.remove, "Delete"})
+		a.comments.cards = append(a.comments.cards, card)
+		y += h + 8
+	}
+}
+
+// handleCommentPanelClick runs the clicked button of a card, or selects
+// the text of the clicked thread. It reports whether the click was on the
+// panel.
+func (a *App) handleCommentPanelClick(x, y int) bool {
+	if !a.comments.visible || !a.comments.rect.contains(x, y) {
+		return false
+	}
+	for _, card := range a.comments.cards {
+		switch {
+		case !card.r.contains(x, y):
+			continue
+		case card.reply.contains(x, y):
+			a.openCommentDialog(card.id)
+		case card.resolve.contains(x, y):
+			a.resolveComment(card.id, !card.resolved)
+		case card.remove.contains(x, y):
+			a.deleteComment(card.id)
+		default:
+			if a.state.SelectComment(card.id) {
+				a.selectedImageValid = false
+				a.pendingFollowCaret = true
+			}
+		}
+		return true
+	}
+	return true
+}
+
+// scrollComments scrolls the panel when the mouse is over it, reporting
+// whether it took the wheel.
+func (a *App) scrollComments(wheelY float64) bool {
+	mx, my := ebiten.CursorPosition()
+	if !a.comments.visible || !a.comments.rect.contains(mx, my) {
+		return false
+	}
+	if wheelY > 0 {
+		a.comments.scroll--
+	} else if wheelY < 0 {
+		a.comments.scroll++
+	}
+	a.comments.scroll = max(a.comments.scroll, 0)
+	return true
+}
@@ -0,0 +1,69 @@
+package app
+
+import (
+	"image/color"
+
+	"sqdoc/pkg/sqdoc"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+	"github.com/hajimehoshi/ebiten/v2/text"
+	"golang.org/x/image/font"
+)
+
+// dialogButton is a push button of a modal dialog; buttons with no width
+// are not shown.
+type dialogButton struct {
+	r     rect
+	label string
+}
+
+var dialogLabelColor = color.RGBA{R: 42, G: 56, B: 80, A: 255}
+
+// placeDialogButtons lays out buttons right to left along the bottom of the
+// dialog r.
+func (a *App) placeDialogButtons(face font.Face, r rect, rowH int, labels ...string) []rect {
+	out := make([]rect, len(labels))
+	x := r.x + r.w - 20
+	for i, label := range labels {
+		bw := a.measureString(face, label) + 24
+		x -= bw
+		out[i] = rect{x: x, y: r.y + r.h - rowH - 14, w: bw, h: rowH}
+		x -= 8
+	}
+	return out
+}
+
+// drawDialogFrame dims the window and draws the panel of a modal dialog
+// with its title.
+func (a *App) drawDialogFrame(screen *ebiten.Image, w, h int, r rect, title string) {
+	a.drawFilledRectOnScreen(screen, 0, 0, w, h, color.RGBA{A: 90})
+	border := color.RGBA{R: 160, G: 176, B: 198, A: 255}
+	a.drawFilledRectOnScreen(screen, r.x, r.y, r.w, r.h, color.RGBA{R: 249, G: 251, B: 254, A: 255})
+	ebitenutil.DrawLine(screen, float64(r.x), float64(r.y), float64(r.x+r.w), float64(r.y), border)
+	ebitenutil.DrawLine(screen, float64(r.x), float64(r.y+r.h), float64(r.x+r.w), float64(r.y+r.h), border)
+	ebitenutil.DrawLine(screen, float64(r.x), float64(r.y), float64(r.x), float64(r.y+r.h), border)
+	ebitenutil.DrawLine(screen, float64(r.x+r.w), float64(r.y), float64(r.x+r.w), float64(r.y+r.h), border)
+	text.Draw(screen, title, a.uiFace(12, true, false, sqdoc.FontFamilySans), r.x+20, r.y+28, color.RGBA{R: 24, G: 38, B: 56, A: 255})
+}
+
+func (a *App) drawDialogButtons(screen *ebiten.Image, face font.Face, buttons ...dialogButton) {
+	for _, btn := range buttons {
+		if btn.r.w <= 0 {
+			continue
+		}
+		a.drawFilledRectOnScreen(screen, btn.r.x, btn.r.y, btn.r.w, btn.r.h, color.RGBA{R: 226, G: 236, B: 249, A: 255})
+		tw := a.measureString(face, btn.label)
+		text.Draw(screen, btn.label, face, btn.r.x+(btn.r.w-tw)/2, a.centeredTextBaseline(btn.r, face), dialogLabelColor)
+	}
+}
+
+// drawDialogNote writes an error, or failing that a hint, on the button row
+// of a dialog.
+func (a *App) drawDialogNote(screen *ebiten.Image, face font.Face, x int, row rect, err, hint string) {
+	if err != "" {
+		text.Draw(screen, err, face, x, a.centeredTextBaseline(row, face), color.RGBA{R: 165, G: 35, B: 35, A: 255})
+	} else if hint != "" {
+		text.Draw(screen, hint, face, x, a.centeredTextBaseline(row, face), color.RGBA{R: 110, G: 122, B: 140, A: 255})
+	}
+}
@@ -0,0 +1,123 @@
+package app
+
+import (
+	"errors"
+	"path/filepath"
+
+	"sqdoc/pkg/sqdoc"
+
+	"github.com/sqweek/dialog"
+)
+
+const lockRefreshFrames = 60 * 60 * 5
+
+// takeDocumentLock swaps the current tab's lock for one on path. When another
+// live editor holds it the user picks between read-only and taking it over.
+func (a *App) takeDocumentLock(path string) {
+	a.releaseDocumentLock()
+	lock, err := sqdoc.AcquireLock(path)
+	if err == nil {
+		a.docLock = lock
+		return
+	}
+	var locked *sqdoc.LockedError
+	if !errors.As(err, &locked) {
+		// Unwritable folders still open normally; the lock is advisory.
+		return
+	}
+	readOnly := dialog.Message("%s is being edited by %s.\n\nOpen it read-only?\n\nChoose No to open anyway and take over the lock.", filepath.Base(path), locked.Info.Owner()).
+		Title("Document in use").
+		YesNo()
+	if readOnly {
+		a.readOnly = true
+		return
+	}
+	if lock, err := sqdoc.ForceLock(path); err == nil {
+		a.docLock = lock
+	}
+}
+
+func (a *App) releaseDocumentLock() {
+	if a.docLock != nil {
+		_ = a.docLock.Release()
+	}
+	a.docLock = nil
+	a.readOnly = false
+}
+
+func (a *App) releaseAllDocumentLocks() {
+	a.syncActiveTabFromRuntime()
+	for i := range a.tabs {
+		if a.tabs[i].docLock != nil {
+			_ = a.tabs[i].docLock.Release()
+			a.tabs[i].docLock = nil
+		}
+	}
+	a.releaseDocumentLock()
+}
+
+func (a *App) refreshDocumentLocks() {
+	if a.frameTick%lockRefreshFrames != 0 {
+		return
+	}
+	if err := a.docLock.Refresh(); err != nil {
+		var locked *sqdoc.LockedError
+		if errors.As(err, &locked) {
+			a.status = "Lock taken over by " + locked.Info.Owner()
+		}
+	}
+	for i := range a.tabs {
+		if i != a.activeTab {
+			_ = a.tabs[i].docLock.Refresh()
+		}
+	}
+}
+
+// errReadOnly is the error of edits refused because the tab is read-only.
+var errReadOnly = errors.New("document is read-only; use Save As to keep a copy")
+
+// editable reports whether the current tab may be edited, telling the user
+// why not when it is read-only. Edits ask before they snapshot or touch the
+// document, so a refused one leaves no trace.
+func (a *App) editable() bool {
+	if !a.readOnly {
+		return true
+	}
+	a.status = "Document is read-only; use Save As to keep a copy"
+	return false
+}
+
+// beginEdit snapshots the document for undo ahead of an edit, reporting
+// false, with nothing recorded, when the tab is read-only.
+func (a *App) beginEdit() bool {
+	if !a.editable() {
+		return false
+	}
+	a.pushUndoSnapshot()
+	return true
+}
+
+// claimSaveTarget moves the lock to a Save As destination, asking before
+// writing over a file someone else has open.
+func (a *App) claimSaveTarget(path string) bool {
+	lock, err := sqdoc.AcquireLock(path)
+	if err != nil {
+		var locked *sqdoc.LockedError
+		if !errors.As(err, &locked) {
+			lock = nil
+		} else {
+			overwrite := dialog.Message("%s is being edited by %s.\n\nSave over it anyway?", filepath.Base(path), locked.Info.Owner()).
+				Title("Document in use").
+				YesNo()
+			if !overwrite {
+				return false
+			}
+			if lock, err = sqdoc.ForceLock(path); err != nil {
+				lock = nil
+			}
+		}
+	}
+	a.releaseDocumentLock()
+	a.docLock = lock
+	return true
+}
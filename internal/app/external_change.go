@@ -0,0 +1,259 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"image/color"
+	"os"
+	"path/filepath"
+
+	"sqdoc/internal/editor"
+	"sqdoc/pkg/sqdoc"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+	"github.com/hajimehoshi/ebiten/v2/text"
+	"github.com/sqweek/dialog"
+	"golang.org/x/image/font"
+)
+
+const diskPollFrames = 60
+
+// diskWatch tracks what a tab last loaded from or saved to disk, so external
+// edits can be detected and merged against a common base.
+type diskWatch struct {
+	stamp sqdoc.FileStamp
+	valid bool
+	base  *sqdoc.Document
+
+	seen      sqdoc.FileStamp
+	seenValid bool
+	pending   bool
+}
+
+func (a *App) recordDiskState(path string, doc *sqdoc.Document) {
+	a.disk = diskWatch{}
+	if path == "" || doc == nil {
+		return
+	}
+	stamp, err := sqdoc.StampFile(path)
+	if err != nil {
+		return
+	}
+	a.disk.stamp = stamp
+	a.disk.valid = true
+	a.disk.base = sqdoc.CloneDocument(doc)
+}
+
+func (a *App) pollExternalChanges() {
+	if a.frameTick%diskPollFrames != 0 {
+		return
+	}
+	if a.checkDiskWatch(a.filePath, &a.disk) {
+		a.status = filepath.Base(a.filePath) + " changed on disk"
+	}
+	for i := range a.tabs {
+		if i == a.activeTab {
+			continue
+		}
+		a.checkDiskWatch(a.tabs[i].filePath, &a.tabs[i].disk)
+	}
+}
+
+// checkDiskWatch only hashes the file when size or mtime moved, and reports
+// true the first time a given external revision is seen.
+func (a *App) checkDiskWatch(path string, w *diskWatch) bool {
+	if path == "" || !w.valid {
+		return false
+	}
+	last := w.stamp
+	if w.seenValid {
+		last = w.seen
+	}
+	info, err := os.Stat(path)
+	if err != nil || !last.StatChanged(info) {
+		return false
+	}
+	stamp, err := sqdoc.StampFile(path)
+	if err != nil {
+		return false
+	}
+	if stamp.SameContent(w.stamp) {
+		w.stamp = stamp
+		w.seenValid = false
+		w.pending = false
+		return false
+	}
+	if w.seenValid && stamp.SameContent(w.seen) {
+		w.seen = stamp
+		return false
+	}
+	w.seen = stamp
+	w.seenValid = true
+	w.pending = true
+	return true
+}
+
+// diskChangedSinceLoad re-stats the current file before a save so the
+// overwrite check does not depend on the poll interval.
+func (a *App) diskChangedSinceLoad(path string) bool {
+	if !a.disk.valid || path != a.filePath {
+		return false
+	}
+	info, err := os.Stat(path)
+	if err != nil || !a.disk.stamp.StatChanged(info) {
+		return false
+	}
+	stamp, err := sqdoc.StampFile(path)
+	if err != nil {
+		return false
+	}
+	return !stamp.SameContent(a.disk.stamp)
+}
+
+func (a *App) confirmOverwriteExternal(path string) bool {
+	if !a.diskChangedSinceLoad(path) {
+		return true
+	}
+	return dialog.Message("%s was changed by another program since it was opened.\n\nOverwrite those changes with your version?", filepath.Base(path)).
+		Title("File changed on disk").
+		YesNo()
+}
+
+func (a *App) loadDiskRevision() (*sqdoc.Document, error) {
+	if a.filePath == "" {
+		return nil, errors.New("document has no file")
+	}
+	return sqdoc.LoadWithOptions(a.filePath, sqdoc.LoadOptions{Password: a.encryptionPassword})
+}
+
+func (a *App) reloadFromDisk() error {
+	doc, err := a.loadDiskRevision()
+	if err != nil {
+		return err
+	}
+	// The other program may have saved with other envelope settings, and
+	// the next save should keep what is on disk now.
+	env, err := sqdoc.InspectEnvelope(a.filePath)
+	if err != nil {
+		return err
+	}
+	a.pushUndoSnapshot()
+	a.state = editor.NewState(doc)
+	a.applyEnvelopeSettings(env)
+	a.applyDocumentMetadataSettings(doc.Metadata)
+	a.clearImageInteraction()
+	a.recordDiskState(a.filePath, doc)
+	a.status = "Reloaded " + filepath.Base(a.filePath) + " from disk"
+	return nil
+}
+
+func (a *App) keepLocalVersion() {
+	a.disk.pending = false
+	a.status = "Keeping your version; saving will ask before overwriting"
+}
+
+func (a *App) mergeWithDisk() error {
+	remote, err := a.loadDiskRevision()
+	if err != nil {
+		return err
+	}
+	stamp, err := sqdoc.StampFile(a.filePath)
+	if err != nil {
+		return err
+	}
+	merged, conflicts := sqdoc.MergeDocuments(a.disk.base, a.state.Doc, remote)
+	if len(merged.Blocks) == 0 {
+		merged.Blocks = append(merged.Blocks, sqdoc.Block{ID: 1, Kind: sqdoc.BlockKindText, Text: &sqdoc.TextBlock{}})
+	}
+	a.pushUndoSnapshot()
+	block, caret := a.state.CurrentBlock, a.state.CaretByte
+	a.state = editor.NewState(merged)
+	a.state.SetCaret(block, caret)
+	a.applyDocumentMetadataSettings(merged.Metadata)
+	a.clearImageInteraction()
+	a.disk = diskWatch{stamp: stamp, valid: true, base: remote}
+	if len(conflicts) > 0 {
+		a.status = fmt.Sprintf("Merged with disk: %d conflicting block(s) kept side by side", len(conflicts))
+	} else {
+		a.status = "Merged changes from disk"
+	}
+	return nil
+}
+
+func (a *App) externalBannerHeight() int {
+	if !a.disk.pending {
+		return 0
+	}
+	return int(34 * a.uiScales[a.uiScaleIdx])
+}
+
+func (a *App) layoutExternalBanner(face font.Face, top rect) {
+	a.externalBannerRect = rect{}
+	a.externalReloadRect = rect{}
+	a.externalKeepRect = rect{}
+	a.externalMergeRect = rect{}
+	h := a.externalBannerHeight()
+	if h <= 0 {
+		return
+	}
+	a.externalBannerRect = rect{x: top.x, y: top.y - h - 4, w: top.w, h: h}
+	pad := 6
+	btnH := h - pad*2
+	x := top.x + top.w - pad
+	place := func(label string) rect {
+		w := a.measureString(face, label) + 24
+		x -= w
+		r := rect{x: x, y: a.externalBannerRect.y + pad, w: w, h: btnH}
+		x -= pad
+		return r
+	}
+	a.externalKeepRect = place("Keep mine")
+	a.externalMergeRect = place("Merge")
+	a.externalReloadRect = place("Reload")
+}
+
+func (a *App) handleExternalBannerClick(x, y int) bool {
+	if !a.disk.pending || !a.externalBannerRect.contains(x, y) {
+		return false
+	}
+	var err error
+	switch {
+	case a.externalReloadRect.contains(x, y):
+		err = a.reloadFromDisk()
+	case a.externalMergeRect.contains(x, y):
+		err = a.mergeWithDisk()
+	case a.externalKeepRect.contains(x, y):
+		a.keepLocalVersion()
+	}
+	if err != nil {
+		a.status = "Disk update failed: " + err.Error()
+	}
+	return true
+}
+
+func (a *App) drawExternalBanner(screen *ebiten.Image, face font.Face) {
+	if !a.disk.pending {
+		return
+	}
+	r := a.externalBannerRect
+	a.drawFilledRectOnScreen(screen, r.x, r.y, r.w, r.h, color.RGBA{R: 255, G: 243, B: 205, A: 255})
+	border := color.RGBA{R: 214, G: 178, B: 92, A: 255}
+	ebitenutil.DrawLine(screen, float64(r.x), float64(r.y), float64(r.x+r.w), float64(r.y), border)
+	ebitenutil.DrawLine(screen, float64(r.x), float64(r.y+r.h), float64(r.x+r.w), float64(r.y+r.h), border)
+
+	msg := filepath.Base(a.filePath) + " was changed by another program."
+	text.Draw(screen, msg, face, r.x+12, a.centeredTextBaseline(r, face), color.RGBA{R: 92, G: 64, B: 8, A: 255})
+	for _, btn := range []struct {
+		r     rect
+		label string
+	}{
+		{a.externalReloadRect, "Reload"},
+		{a.externalMergeRect, "Merge"},
+		{a.externalKeepRect, "Keep mine"},
+	} {
+		a.drawFilledRectOnScreen(screen, btn.r.x, btn.r.y, btn.r.w, btn.r.h, color.RGBA{R: 255, G: 251, B: 238, A: 255})
+		tw := a.measureString(face, btn.label)
+		text.Draw(screen, btn.label, face, btn.r.x+(btn.r.w-tw)/2, a.centeredTextBaseline(btn.r, face), color.RGBA{R: 92, G: 64, B: 8, A: 255})
+	}
+}
@@ -0,0 +1,526 @@
+package app
+
+import (
+	"fmt"
+	"image/color"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"sqdoc/internal/editor"
+
+	textclipboard "github.com/atotto/clipboard"
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+	"github.com/hajimehoshi/ebiten/v2/text"
+	"golang.org/x/image/font"
+)
+
+const findInputLimit = 512
+
+// findBar is the find and replace strip above the document. Matches are
+// recomputed whenever the query, its options or the document change.
+type findBar struct {
+	visible     bool
+	replacing   bool
+	focused     bool
+	onReplace   bool
+	query       string
+	replacement string
+	opts        editor.SearchOptions
+
+	search  *editor.Search
+	err     string
+	matches []editor.Match
+	current int
+	key     findKey
+
+	rect           rect
+	queryRect      rect
+	replaceRect    rect
+	caseRect       rect
+	wordRect       rect
+	regexRect      rect
+	prevRect       rect
+	nextRect       rect
+	closeRect      rect
+	replaceOneRect rect
+	replaceAllRect rect
+}
+
+type findButton struct {
+	r     rect
+	label string
+	on    bool
+}
+
+// findKey identifies the document revision and query the matches belong to.
+type findKey struct {
+	state    *editor.State
+	revision uint64
+	query    string
+	opts     editor.SearchOptions
+}
+
+func (a *App) openFindBar(replace bool) {
+	a.find.visible = true
+	a.find.replacing = replace
+	a.find.focused = true
+	a.find.onReplace = false
+	if sel := a.state.SelectedText(); sel != "" && utf8.RuneCountInString(sel) <= 128 && !strings.ContainsAny(sel, "\r\n") {
+		a.find.query = sel
+	}
+}
+
+func (a *App) closeFindBar() {
+	a.find.visible = false
+	a.find.focused = false
+	a.find.matches = nil
+	a.find.key = findKey{}
+}
+
+// refreshFindMatches recompiles the query and searches again when anything
+// it depends on has changed since the last search.
+func (a *App) refreshFindMatches() {
+	if !a.find.visible {
+		return
+	}
+	key := findKey{state: a.state, revision: a.docRevision, query: a.find.query, opts: a.find.opts}
+	if key == a.find.key {
+		return
+	}
+	a.find.key = key
+	a.find.matches = nil
+	a.find.current = -1
+	a.find.search = nil
+	a.find.err = ""
+	if a.find.query == "" {
+		return
+	}
+	q, err := editor.CompileSearch(a.find.query, a.find.opts)
+	if err != nil {
+		a.find.err = "Invalid pattern"
+		return
+	}
+	a.find.search = q
+	a.find.matches = a.state.FindAll(q)
+	a.find.current = a.selectedMatch()
+}
+
+// selectedMatch is the index of the match the selection covers exactly, or -1.
+func (a *App) selectedMatch() int {
+	start, _, ok := a.state.SelectionRange()
+	if !ok {
+		return -1
+	}
+	i := a.state.MatchAfterCaret(a.find.matches, start)
+	if i < 0 || !a.state.MatchSelected(a.find.matches[i]) {
+		return -1
+	}
+	return i
+}
+
+// findStep selects the next match after the caret, or the previous one
+// before the selection when delta is negative.
+func (a *App) findStep(delta int) {
+	a.refreshFindMatches()
+	if len(a.find.matches) == 0 {
+		if a.find.err == "" && a.find.query != "" {
+			a.status = "No matches for " + a.find.query
+		}
+		return
+	}
+	var i int
+	if delta < 0 {
+		from := editor.Position{Block: a.state.CurrentBlock, Byte: a.state.CaretByte}
+		if start, _, ok := a.state.SelectionRange(); ok {
+			from = start
+		}
+		i = a.state.MatchBeforeCaret(a.find.matches, from)
+	} else {
+		i = a.state.MatchAfterCaret(a.find.matches, editor.Position{Block: a.state.CurrentBlock, Byte: a.state.CaretByte})
+	}
+	a.selectFindMatch(i)
+}
+
+func (a *App) selectFindMatch(i int) {
+	m := a.find.matches[i]
+	a.state.SelectMatch(m)
+	a.selectedImageValid = false
+	a.find.current = i
+	a.pendingFollowCaret = true
+}
+
+// replaceCurrentMatch replaces the selected match, or selects the next one
+// first when the selection is not a match, like most editors' Replace.
+func (a *App) replaceCurrentMatch() {
+	a.refreshFindMatches()
+	i := a.selectedMatch()
+	if i < 0 {
+		a.findStep(1)
+		return
+	}
+	if !a.beginEdit() {
+		return
+	}
+	m := a.find.matches[i]
+	if err := a.state.ReplaceMatch(m, a.find.search.Expand(m, a.find.replacement)); err != nil {
+		a.status = "Replace failed: " + err.Error()
+		return
+	}
+	a.refreshFindMatches()
+	if len(a.find.matches) > 0 {
+		a.findStep(1)
+	}
+	a.pendingFollowCaret = true
+}
+
+// replaceAllMatches replaces every match as a single undo step.
+func (a *App) replaceAllMatches() {
+	a.refreshFindMatches()
+	if a.find.search == nil || len(a.find.matches) == 0 {
+		a.status = "Nothing to replace"
+		return
+	}
+	if !a.beginEdit() {
+		return
+	}
+	n, err := a.state.ReplaceAll(a.find.search, a.find.replacement)
+	if err != nil {
+		a.status = "Replace failed: " + err.Error()
+	} else {
+		a.status = fmt.Sprintf("Replaced %d match(es)", n)
+	}
+	a.clearImageInteraction()
+	a.pendingFollowCaret = true
+}
+
+// handleFindInput feeds keys to the focused find bar. It reports whether
+// the bar took the frame's input.
+func (a *App) handleFindInput(ctrl, shift, alt bool) bool {
+	if !a.find.visible || !a.find.focused {
+		return false
+	}
+	field := &a.find.query
+	if a.find.onReplace {
+		field = &a.find.replacement
+	}
+	if alt {
+		// Only the option toggles are taken, so AltGr still types.
+		switch {
+		case inpututil.IsKeyJustPressed(ebiten.KeyC):
+			a.find.opts.CaseSensitive = !a.find.opts.CaseSensitive
+			return true
+		case inpututil.IsKeyJustPressed(ebiten.KeyW):
+			a.find.opts.WholeWord = !a.find.opts.WholeWord
+			return true
+		case inpututil.IsKeyJustPressed(ebiten.KeyR):
+			a.find.opts.Regexp = !a.find.opts.Regexp
+			return true
+		}
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyTab) && a.find.replacing {
+		a.find.onReplace = !a.find.onReplace
+		return true
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyEnter) || inpututil.IsKeyJustPressed(ebiten.KeyKPEnter) {
+		switch {
+		case a.find.onReplace && ctrl:
+			a.replaceAllMatches()
+		case a.find.onReplace:
+			a.replaceCurrentMatch()
+		case shift:
+			a.findStep(-1)
+		default:
+			a.findStep(1)
+		}
+		return true
+	}
+	if ctrl {
+		if inpututil.IsKeyJustPressed(ebiten.KeyV) {
+			if clip, err := textclipboard.ReadAll(); err == nil {
+				*field = trimFindInput(*field + firstLine(clip))
+				if !a.find.onReplace {
+					a.findAsYouType()
+				}
+			}
+			return true
+		}
+		// Other shortcuts such as save and undo still reach the document.
+		return false
+	}
+	consumed := false
+	if inpututil.IsKeyJustPressed(ebiten.KeyBackspace) {
+		if len(*field) > 0 {
+			_, size := utf8.DecodeLastRuneInString(*field)
+			*field = (*field)[:len(*field)-size]
+		}
+		consumed = true
+	}
+	for _, r := range ebiten.AppendInputChars(nil) {
+		if r < 0x20 || r == 0x7F || !utf8.ValidRune(r) {
+			continue
+		}
+		*field = trimFindInput(*field + string(r))
+		consumed = true
+	}
+	if consumed && !a.find.onReplace {
+		a.findAsYouType()
+	}
+	// Navigation and editing keys other than these stay with the bar so
+	// they do not move the caret behind it.
+	return true
+}
+
+// findAsYouType moves to the first match at or after the start of the
+// current selection, so typing more of the query keeps the same match.
+func (a *App) findAsYouType() {
+	a.refreshFindMatches()
+	if len(a.find.matches) == 0 {
+		a.state.ClearSelection()
+		return
+	}
+	from := editor.Position{Block: a.state.CurrentBlock, Byte: a.state.CaretByte}
+	if start, _, ok := a.state.SelectionRange(); ok {
+		from = start
+	}
+	a.selectFindMatch(a.state.MatchAfterCaret(a.find.matches, from))
+}
+
+func trimFindInput(s string) string {
+	for len(s) > findInputLimit {
+		_, size := utf8.DecodeLastRuneInString(s)
+		s = s[:len(s)-size]
+	}
+	return s
+}
+
+func firstLine(s string) string {
+	if i := strings.IndexAny(s, "\r\n"); i >= 0 {
+		return s[:i]
+	}
+	return s
+}
+
+func (a *App) findBarHeight() int {
+	if !a.find.visible {
+		return 0
+	}
+	row := int(34 * a.uiScales[a.uiScaleIdx])
+	if a.find.replacing {
+		return row * 2
+	}
+	return row
+}
+
+func (a *App) layoutFindBar(face font.Face, top rect) {
+	a.find.rect = rect{}
+	h := a.findBarHeight()
+	if h <= 0 {
+		return
+	}
+	a.find.rect = rect{x: top.x, y: top.y - h - 4, w: top.w, h: h}
+	row := int(34 * a.uiScales[a.uiScaleIdx])
+	pad := 6
+	btnH := row - pad*2
+	labelW := a.measureString(face, "Replace") + 24
+
+	x := a.find.rect.x + a.find.rect.w - pad
+	place := func(y int, label string) rect {
+		w := a.measureString(face, label) + 20
+		x -= w
+		r := rect{x: x, y: y + pad, w: w, h: btnH}
+		x -= pad
+		return r
+	}
+	y := a.find.rect.y
+	a.find.closeRect = place(y, "Close")
+	a.find.nextRect = place(y, "Next")
+	a.find.prevRect = place(y, "Prev")
+	a.find.regexRect = place(y, ".*")
+	a.find.wordRect = place(y, "Word")
+	a.find.caseRect = place(y, "Aa")
+	countW := a.measureString(face, "9999 of 9999")
+	inputX := a.find.rect.x + labelW
+	a.find.queryRect = rect{x: inputX, y: y + pad, w: max(60, x-countW-pad-inputX), h: btnH}
+
+	a.find.replaceRect = rect{}
+	a.find.replaceOneRect = rect{}
+	a.find.replaceAllRect = rect{}
+	if a.find.replacing {
+		y += row
+		x = a.find.rect.x + a.find.rect.w - pad
+		a.find.replaceAllRect = place(y, "Replace all")
+		a.find.replaceOneRect = place(y, "Replace")
+		a.find.replaceRect = rect{x: inputX, y: y + pad, w: a.find.queryRect.w, h: btnH}
+	}
+}
+
+func (a *App) handleFindBarClick(x, y int) bool {
+	if !a.find.visible || !a.find.rect.contains(x, y) {
+		return false
+	}
+	switch {
+	case a.find.queryRect.contains(x, y):
+		a.find.focused = true
+		a.find.onReplace = false
+	case a.find.replaceRect.contains(x, y):
+		a.find.focused = true
+		a.find.onReplace = true
+	case a.find.caseRect.contains(x, y):
+		a.find.opts.CaseSensitive = !a.find.opts.CaseSensitive
+	case a.find.wordRect.contains(x, y):
+		a.find.opts.WholeWord = !a.find.opts.WholeWord
+	case a.find.regexRect.contains(x, y):
+		a.find.opts.Regexp = !a.find.opts.Regexp
+	case a.find.prevRect.contains(x, y):
+		a.findStep(-1)
+	case a.find.nextRect.contains(x, y):
+		a.findStep(1)
+	case a.find.replaceOneRect.contains(x, y):
+		a.replaceCurrentMatch()
+	case a.find.replaceAllRect.contains(x, y):
+		a.replaceAllMatches()
+	case a.find.closeRect.contains(x, y):
+		a.closeFindBar()
+	}
+	return true
+}
+
+// findMatchCount is the status bar summary of the current search.
+func (a *App) findMatchCount() string {
+	switch {
+	case a.find.err != "":
+		return a.find.err
+	case a.find.query == "":
+		return ""
+	case len(a.find.matches) == 0:
+		return "No matches"
+	case a.find.current >= 0:
+		return fmt.Sprintf("%d of %d", a.find.current+1, len(a.find.matches))
+	default:
+		return fmt.Sprintf("%d matches", len(a.find.matches))
+	}
+}
+
+// drawFindHighlights marks every match on the visible lines, the current one
+// in a stronger colour than the rest.
+func (a *App) drawFindHighlights() {
+	if !a.find.visible || len(a.find.matches) == 0 {
+		return
+	}
+	matches := a.find.matches
+	for _, ll := range a.lineLayouts {
+		if ll.y+ll.height < a.contentRect.y || ll.y > a.contentRect.y+a.contentRect.h {
+			continue
+		}
+		lineStart := editor.Position{Block: ll.block, Byte: ll.startByte}
+		lineEnd := editor.Position{Block: ll.block, Byte: ll.startByte + len(ll.text)}
+		i := sort.Search(len(matches), func(i int) bool { return !positionBefore(matches[i].End, lineStart) })
+		if ll.inCell {
+			// Matches in a table run cell by cell, so scan the whole block.
+			i = sort.Search(len(matches), func(i int) bool { return matches[i].End.Block >= ll.block })
+		}
+		for ; i < len(matches) && (ll.inCell && matches[i].Start.Block == ll.block || !ll.inCell && positionBefore(matches[i].Start, lineEnd)); i++ {
+			m := matches[i]
+			if m.InCell != ll.inCell || m.InCell && (m.Row != ll.row || m.Col != ll.col) {
+				continue
+			}
+			from, to := ll.startByte, ll.startByte+len(ll.text)
+			if m.Start.Block == ll.block {
+				from = max(from, m.Start.Byte)
+			}
+			if m.End.Block == ll.block {
+				to = min(to, m.End.Byte)
+			}
+			if to <= from {
+				continue
+			}
+			c := color.RGBA{R: 255, G: 236, B: 153, A: 255}
+			if i == a.find.current {
+				c = color.RGBA{R: 255, G: 190, B: 92, A: 255}
+			}
+			x0 := ll.viewX + a.lineAdvance(ll, from-ll.startByte)
+			x1 := ll.viewX + a.lineAdvance(ll, to-ll.startByte)
+			a.fillRectWithinContent(x0, ll.y+1, x1-x0, ll.height-2, c)
+		}
+	}
+}
+
+func positionBefore(p, q editor.Position) bool {
+	return p.Block < q.Block || (p.Block == q.Block && p.Byte < q.Byte)
+}
+
+func (a *App) drawFindBar(screen *ebiten.Image, face font.Face) {
+	if !a.find.visible {
+		return
+	}
+	r := a.find.rect
+	a.drawFilledRectOnScreen(screen, r.x, r.y, r.w, r.h, color.RGBA{R: 241, G: 245, B: 251, A: 255})
+	border := color.RGBA{R: 187, G: 196, B: 210, A: 255}
+	ebitenutil.DrawLine(screen, float64(r.x), float64(r.y), float64(r.x+r.w), float64(r.y), border)
+	ebitenutil.DrawLine(screen, float64(r.x), float64(r.y+r.h), float64(r.x+r.w), float64(r.y+r.h), border)
+
+	labelColor := color.RGBA{R: 42, G: 56, B: 80, A: 255}
+	text.Draw(screen, "Find", face, r.x+12, a.centeredTextBaseline(a.find.queryRect, face), labelColor)
+	a.drawFindInput(screen, face, a.find.queryRect, a.find.query, a.find.focused && !a.find.onReplace)
+	count := a.findMatchCount()
+	countColor := labelColor
+	if a.find.err != "" {
+		countColor = color.RGBA{R: 165, G: 35, B: 35, A: 255}
+	}
+	text.Draw(screen, count, face, a.find.queryRect.x+a.find.queryRect.w+8, a.centeredTextBaseline(a.find.queryRect, face), countColor)
+
+	buttons := []findButton{
+		{a.find.caseRect, "Aa", a.find.opts.CaseSensitive},
+		{a.find.wordRect, "Word", a.find.opts.WholeWord},
+		{a.find.regexRect, ".*", a.find.opts.Regexp},
+		{a.find.prevRect, "Prev", false},
+		{a.find.nextRect, "Next", false},
+		{a.find.closeRect, "Close", false},
+	}
+	if a.find.replacing {
+		text.Draw(screen, "Replace", face, r.x+12, a.centeredTextBaseline(a.find.replaceRect, face), labelColor)
+		a.drawFindInput(screen, face, a.find.replaceRect, a.find.replacement, a.find.focused && a.find.onReplace)
+		buttons = append(buttons,
+			findButton{a.find.replaceOneRect, "Replace", false},
+			findButton{a.find.replaceAllRect, "Replace all", false},
+		)
+	}
+	for _, btn := range buttons {
+		bg := color.RGBA{R: 252, G: 253, B: 255, A: 255}
+		if btn.on {
+			bg = color.RGBA{R: 205, G: 224, B: 248, A: 255}
+		}
+		a.drawFilledRectOnScreen(screen, btn.r.x, btn.r.y, btn.r.w, btn.r.h, bg)
+		tw := a.measureString(face, btn.label)
+		text.Draw(screen, btn.label, face, btn.r.x+(btn.r.w-tw)/2, a.centeredTextBaseline(btn.r, face), labelColor)
+	}
+}
+
+// drawFindInput draws a one-line input, keeping the end of long text in view.
+func (a *App) drawFindInput(screen *ebiten.Image, face font.Face, r rect, value string, focused bool) {
+	bg := color.RGBA{R: 255, G: 255, B: 255, A: 255}
+	border := color.RGBA{R: 170, G: 184, B: 202, A: 255}
+	if focused {
+		bg = color.RGBA{R: 244, G: 249, B: 255, A: 255}
+		border = color.RGBA{R: 77, G: 134, B: 205, A: 255}
+	}
+	a.drawFilledRectOnScreen(screen, r.x, r.y, r.w, r.h, bg)
+	ebitenutil.DrawLine(screen, float64(r.x), float64(r.y), float64(r.x+r.w), float64(r.y), border)
+	ebitenutil.DrawLine(screen, float64(r.x), float64(r.y+r.h), float64(r.x+r.w), float64(r.y+r.h), border)
+	ebitenutil.DrawLine(screen, float64(r.x), float64(r.y), float64(r.x), float64(r.y+r.h), border)
+	ebitenutil.DrawLine(screen, float64(r.x+r.w), float64(r.y), float64(r.x+r.w), float64(r.y+r.h), border)
+
+	shown := value
+	for shown != "" && a.measureString(face, shown) > r.w-16 {
+		_, size := utf8.DecodeRuneInString(shown)
+		shown = shown[size:]
+	}
+	text.Draw(screen, shown, face, r.x+8, a.centeredTextBaseline(r, face), color.RGBA{R: 42, G: 56, B: 80, A: 255})
+	if focused && (a.frameTick/30)%2 == 0 {
+		caretX := float64(r.x + 8 + a.measureString(face, shown))
+		ebitenutil.DrawLine(screen, caretX, float64(r.y+5), caretX, float64(r.y+r.h-5), color.RGBA{R: 21, G: 84, B: 164, A: 255})
+	}
+}
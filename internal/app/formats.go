@@ -0,0 +1,298 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"image/color"
+	"path/filepath"
+	"strings"
+
+	"sqdoc/internal/editor"
+	"sqdoc/pkg/convert/docx"
+	"sqdoc/pkg/convert/epub"
+	htmlconv "sqdoc/pkg/convert/html"
+	"sqdoc/pkg/convert/markdown"
+	"sqdoc/pkg/convert/odt"
+	"sqdoc/pkg/convert/pdf"
+	"sqdoc/pkg/convert/rtf"
+	"sqdoc/pkg/sqdoc"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+	"github.com/hajimehoshi/ebiten/v2/text"
+	"github.com/sqweek/dialog"
+	"golang.org/x/image/font"
+)
+
+// documentFormat is a foreign file format SIDE can import from or export to.
+// Either function may be nil when only one direction is supported. Import
+// warnings describe content the conversion simplified or dropped.
+type documentFormat struct {
+	name      string
+	exts      []string
+	importDoc func(path string) (*sqdoc.Document, []string, error)
+	exportDoc func(path string, doc *sqdoc.Document) error
+}
+
+var documentFormats = []documentFormat{
+	{
+		name: "Markdown",
+		exts: []string{"md", "markdown"},
+		importDoc: func(path string) (*sqdoc.Document, []string, error) {
+			doc, err := markdown.ImportFile(path, markdown.Options{})
+			return doc, nil, err
+		},
+		exportDoc: func(path string, doc *sqdoc.Document) error {
+			return markdown.ExportFile(path, doc, markdown.Options{})
+		},
+	},
+	{
+		name: "Word",
+		exts: []string{"docx"},
+		importDoc: func(path string) (*sqdoc.Document, []string, error) {
+			return docx.ImportFile(path, docx.Options{})
+		},
+		exportDoc: func(path string, doc *sqdoc.Document) error {
+			return docx.ExportFile(path, doc, docx.Options{})
+		},
+	},
+	{
+		name: "OpenDocument",
+		exts: []string{"odt"},
+		importDoc: func(path string) (*sqdoc.Document, []string, error) {
+			return odt.ImportFile(path, odt.Options{})
+		},
+		exportDoc: func(path string, doc *sqdoc.Document) error {
+			return odt.ExportFile(path, doc, odt.Options{})
+		},
+	},
+	{
+		name: "Rich Text",
+		exts: []string{"rtf"},
+		importDoc: func(path string) (*sqdoc.Document, []string, error) {
+			return rtf.ImportFile(path, rtf.Options{})
+		},
+		exportDoc: func(path string, doc *sqdoc.Document) error {
+			return rtf.ExportFile(path, doc, rtf.Options{})
+		},
+	},
+	{
+		name: "HTML",
+		exts: []string{"html", "htm"},
+		importDoc: func(path string) (*sqdoc.Document, []string, error) {
+			return htmlconv.ImportFile(path, htmlconv.Options{})
+		},
+		exportDoc: func(path string, doc *sqdoc.Document) error {
+			return htmlconv.ExportFile(path, doc, htmlconv.Options{})
+		},
+	},
+	{
+		name: "EPUB",
+		exts: []string{"epub"},
+		exportDoc: func(path string, doc *sqdoc.Document) error {
+			return epub.ExportFile(path, doc, epub.Options{})
+		},
+	},
+	{
+		name: "PDF",
+		exts: []string{"pdf"},
+		exportDoc: func(path string, doc *sqdoc.Document) error {
+			return pdf.ExportFile(path, doc, pdf.Options{})
+		},
+	},
+}
+
+type convertMenuItem struct {
+	label  string
+	format int
+	export bool
+	r      rect
+}
+
+func (a *App) layoutConvertMenuBounds() {
+	a.convertMenuRect = rect{}
+	a.convertMenuItems = a.convertMenuItems[:0]
+	if !a.showConvertMenu {
+		return
+	}
+	anchor, ok := a.topActionRect("convert")
+	if !ok {
+		return
+	}
+	for i, f := range documentFormats {
+		if f.importDoc != nil {
+			a.convertMenuItems = append(a.convertMenuItems, convertMenuItem{label: "Import " + f.name + "...", format: i})
+		}
+	}
+	for i, f := range documentFormats {
+		if f.exportDoc != nil {
+			a.convertMenuItems = append(a.convertMenuItems, convertMenuItem{label: "Export " + f.name + "...", format: i, export: true})
+		}
+	}
+	w := int(230 * a.uiScales[a.uiScaleIdx])
+	if w < 210 {
+		w = 210
+	}
+	rowH := int(30 * a.uiScales[a.uiScaleIdx])
+	if rowH < 24 {
+		rowH = 24
+	}
+	x := anchor.x
+	y := anchor.y + anchor.h + 2
+	a.convertMenuRect = rect{x: x, y: y, w: w, h: rowH*len(a.convertMenuItems) + 8}
+	for i := range a.convertMenuItems {
+		a.convertMenuItems[i].r = rect{x: x + 4, y: y + 4 + rowH*i, w: w - 8, h: rowH}
+	}
+}
+
+func (a *App) drawConvertMenu(screen *ebiten.Image, face font.Face) {
+	if !a.showConvertMenu {
+		return
+	}
+	a.layoutConvertMenuBounds()
+	if a.convertMenuRect.w <= 0 {
+		return
+	}
+	r := a.convertMenuRect
+	border := color.RGBA{R: 172, G: 184, B: 202, A: 255}
+	a.drawFilledRectOnScreen(screen, r.x, r.y, r.w, r.h, color.RGBA{R: 249, G: 251, B: 254, A: 255})
+	ebitenutil.DrawLine(screen, float64(r.x), float64(r.y), float64(r.x+r.w), float64(r.y), border)
+	ebitenutil.DrawLine(screen, float64(r.x), float64(r.y+r.h), float64(r.x+r.w), float64(r.y+r.h), border)
+	ebitenutil.DrawLine(screen, float64(r.x), float64(r.y), float64(r.x), float64(r.y+r.h), border)
+	ebitenutil.DrawLine(screen, float64(r.x+r.w), float64(r.y), float64(r.x+r.w), float64(r.y+r.h), border)
+
+	mx, my := ebiten.CursorPosition()
+	for _, item := range a.convertMenuItems {
+		bg := color.RGBA{R: 241, G: 245, B: 251, A: 255}
+		if item.r.contains(mx, my) {
+			bg = color.RGBA{R: 223, G: 236, B: 252, A: 255}
+		}
+		a.drawFilledRectOnScreen(screen, item.r.x, item.r.y, item.r.w, item.r.h, bg)
+		text.Draw(screen, item.label, face, item.r.x+10, item.r.y+item.r.h-8, color.RGBA{R: 42, G: 58, B: 82, A: 255})
+	}
+}
+
+func (a *App) handleConvertMenuClick(x, y int) bool {
+	a.layoutConvertMenuBounds()
+	if a.convertMenuRect.w <= 0 {
+		return false
+	}
+	if !a.convertMenuRect.contains(x, y) {
+		if btn, ok := a.topActionRect("convert"); ok && btn.contains(x, y) {
+			return false
+		}
+		a.showConvertMenu = false
+		return true
+	}
+	for _, item := range a.convertMenuItems {
+		if !item.r.contains(x, y) {
+			continue
+		}
+		a.showConvertMenu = false
+		f := documentFormats[item.format]
+		var err error
+		if item.export {
+			err = a.exportDocument(f)
+		} else {
+			err = a.importDocument(f)
+		}
+		if err != nil && !errors.Is(err, dialog.ErrCancelled) {
+			verb := "Import"
+			if item.export {
+				verb = "Export"
+			}
+			a.status = verb + " failed: " + err.Error()
+		}
+		return true
+	}
+	return true
+}
+
+// importDocument opens the converted file in a new untitled tab so the
+// original stays untouched until the user picks a .sqdoc path.
+func (a *App) importDocument(f documentFormat) error {
+	path, err := dialog.File().Filter(f.name+" files", f.exts...).Load()
+	if err != nil {
+		return err
+	}
+	return a.importPath(f, filepath.Clean(path))
+}
+
+func (a *App) importPath(f documentFormat, path string) error {
+	doc, warnings, err := f.importDoc(path)
+	if err != nil {
+		return err
+	}
+	state := editor.NewState(doc)
+	a.syncActiveTabFromRuntime()
+	idx := a.appendTab(state, "")
+	a.switchTab(idx)
+	a.status = "Imported " + filepath.Base(path)
+	if len(warnings) > 0 {
+		a.status += fmt.Sprintf(" with %d warning(s)", len(warnings))
+		shown := warnings[:min(len(warnings), 12)]
+		dialog.Message("Some content in %s could not be converted exactly:\n\n- %s", filepath.Base(path), strings.Join(shown, "\n- ")).
+			Title("Import warnings").
+			Info()
+	}
+	return nil
+}
+
+func (a *App) exportDocument(f documentFormat) error {
+	if a.state == nil || a.state.Doc == nil {
+		return errors.New("no document to export")
+	}
+	path, err := dialog.File().Filter(f.name+" files", f.exts...).Save()
+	if err != nil {
+		return err
+	}
+	path = filepath.Clean(path)
+	if ext := strings.TrimPrefix(strings.ToLower(filepath.Ext(path)), "."); !containsString(f.exts, ext) {
+		path += "." + f.exts[0]
+	}
+	return a.exportPath(f, path)
+}
+
+func (a *App) exportPath(f documentFormat, path string) error {
+	if err := f.exportDoc(path, a.state.Doc); err != nil {
+		return err
+	}
+	a.status = "Exported " + filepath.Base(path)
+	return nil
+}
+
+// formatForPath finds the registered format for a file extension, limited to
+// formats that can be imported or exported.
+func formatForPath(path string, export bool) (documentFormat, bool) {
+	ext := strings.TrimPrefix(strings.ToLower(filepath.Ext(path)), ".")
+	for _, f := range documentFormats {
+		if (export && f.exportDoc == nil) || (!export && f.importDoc == nil) {
+			continue
+		}
+		if containsString(f.exts, ext) {
+			return f, true
+		}
+	}
+	return documentFormat{}, false
+}
+
+// documentFileDialog lists .sqdoc first, then every format usable in the
+// given direction, so Open and Save As double as import and export.
+func documentFileDialog(export bool) *dialog.FileBuilder {
+	d := dialog.File().Filter("SQDoc files", "sqdoc")
+	for _, f := range documentFormats {
+		if (export && f.exportDoc != nil) || (!export && f.importDoc != nil) {
+			d = d.Filter(f.name+" files", f.exts...)
+		}
+	}
+	return d
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,295 @@
+package app
+
+import (
+	"image/color"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+
+	"sqdoc/pkg/sqdoc"
+
+	textclipboard "github.com/atotto/clipboard"
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+	"github.com/hajimehoshi/ebiten/v2/text"
+	"golang.org/x/image/font"
+)
+
+// linkSchemes are the URL schemes Ctrl+Click hands to the system. Others,
+// such as file: and program-specific schemes, could run anything, so
+// documents cannot open them.
+var linkSchemes = map[string]bool{"http": true, "https": true, "mailto": true, "ftp": true}
+
+// linkDialog is the Insert/Edit Link dialog. Text is only offered when the
+// link covers text within one paragraph.
+type linkDialog struct {
+	visible  bool
+	editing  bool
+	hasText  bool
+	onTarget bool
+	text     string
+	target   string
+	err      string
+
+	rect       rect
+	textRect   rect
+	targetRect rect
+	applyRect  rect
+	removeRect rect
+	cancelRect rect
+}
+
+func (a *App) openLinkDialog() {
+	a.showInsertMenu = false
+	target, editing := a.state.SelectLinkAtCaret()
+	if !editing {
+		target = a.state.SelectionLink()
+		editing = target != ""
+	}
+	sel := a.state.SelectedText()
+	start, end, has := a.state.SelectionRange()
+	a.link = linkDialog{
+		visible:  true,
+		editing:  editing,
+		hasText:  !has || (start.Block == end.Block && !strings.ContainsAny(sel, "\r\n")),
+		onTarget: true,
+		text:     sel,
+		target:   target,
+	}
+}
+
+func (a *App) closeLinkDialog() {
+	a.link = linkDialog{}
+}
+
+func (a *App) applyLinkDialog() {
+	target := strings.TrimSpace(a.link.target)
+	if target == "" {
+		a.link.err = "Enter an address"
+		return
+	}
+	if _, ok := sqdoc.LinkAnchor(target); !ok {
+		if u, err := url.Parse(target); err != nil || u.Scheme == "" {
+			// Bare addresses are web addresses, as in a browser.
+			target = "https://" + target
+		}
+	}
+	if !a.beginEdit() {
+		return
+	}
+	text := ""
+	if a.link.hasText {
+		text = a.link.text
+	}
+	if err := a.state.SetLink(text, target); err != nil {
+		a.link.err = err.Error()
+		return
+	}
+	a.status = "Link set to " + target
+	a.closeLinkDialog()
+}
+
+func (a *App) removeDialogLink() {
+	if !a.beginEdit() {
+		return
+	}
+	a.state.RemoveLink()
+	a.status = "Link removed"
+	a.closeLinkDialog()
+}
+
+// handleLinkDialogInput feeds keys to the link dialog. It reports whether
+// the dialog is open, since it takes all input while it is.
+func (a *App) handleLinkDialogInput(ctrl bool) bool {
+	if !a.link.visible {
+		return false
+	}
+	field := &a.link.target
+	if !a.link.onTarget {
+		field = &a.link.text
+	}
+	switch {
+	case inpututil.IsKeyJustPressed(ebiten.KeyTab):
+		a.link.onTarget = !a.link.onTarget || !a.link.hasText
+		return true
+	case inpututil.IsKeyJustPressed(ebiten.KeyEnter) || inpututil.IsKeyJustPressed(ebiten.KeyKPEnter):
+		a.applyLinkDialog()
+		return true
+	case ctrl && inpututil.IsKeyJustPressed(ebiten.KeyV):
+		if clip, err := textclipboard.ReadAll(); err == nil {
+			*field = trimLinkInput(*field + strings.TrimSpace(firstLine(clip)))
+		}
+		return true
+	case inpututil.IsKeyJustPressed(ebiten.KeyBackspace):
+		if len(*field) > 0 {
+			_, size := utf8.DecodeLastRuneInString(*field)
+			*field = (*field)[:len(*field)-size]
+		}
+	}
+	if ctrl {
+		return true
+	}
+	for _, r := range ebiten.AppendInputChars(nil) {
+		if r < 0x20 || r == 0x7F || !utf8.ValidRune(r) {
+			continue
+		}
+		*field = trimLinkInput(*field + string(r))
+	}
+	return true
+}
+
+func trimLinkInput(s string) string {
+	for len(s) > sqdoc.MaxLinkLength {
+		_, size := utf8.DecodeLastRuneInString(s)
+		s = s[:len(s)-size]
+	}
+	return s
+}
+
+func (a *App) layoutLinkDialog(w, h int, face font.Face) {
+	scale := a.uiScales[a.uiScaleIdx]
+	pw := min(int(480*scale), w-40)
+	ph := min(int(190*scale), h-40)
+	px, py := (w-pw)/2, (h-ph)/2
+	a.link.rect = rect{x: px, y: py, w: pw, h: ph}
+	labelW := a.measureString(face, "Address") + 30
+	rowH := int(30 * scale)
+	a.link.textRect = rect{x: px + labelW, y: py + int(44*scale), w: pw - labelW - 20, h: rowH}
+	a.link.targetRect = rect{x: px + labelW, y: a.link.textRect.y + rowH + 10, w: pw - labelW - 20, h: rowH}
+
+	labels := []string{"Cancel", "OK"}
+	if a.link.editing {
+		labels = append(labels, "Remove link")
+	}
+	buttons := a.placeDialogButtons(face, a.link.rect, rowH, labels...)
+	a.link.cancelRect, a.link.applyRect, a.link.removeRect = buttons[0], buttons[1], rect{}
+	if a.link.editing {
+		a.link.removeRect = buttons[2]
+	}
+}
+
+func (a *App) handleLinkDialogClick(x, y int) {
+	switch {
+	case !a.link.rect.contains(x, y) || a.link.cancelRect.contains(x, y):
+		a.closeLinkDialog()
+	case a.link.textRect.contains(x, y) && a.link.hasText:
+		a.link.onTarget = false
+	case a.link.targetRect.contains(x, y):
+		a.link.onTarget = true
+	case a.link.applyRect.contains(x, y):
+		a.applyLinkDialog()
+	case a.link.removeRect.contains(x, y):
+		a.removeDialogLink()
+	}
+}
+
+func (a *App) drawLinkDialog(screen *ebiten.Image, w, h int, face font.Face) {
+	if !a.link.visible {
+		return
+	}
+	a.layoutLinkDialog(w, h, face)
+	r := a.link.rect
+	title := "Insert Link"
+	if a.link.editing {
+		title = "Edit Link"
+	}
+	a.drawDialogFrame(screen, w, h, r, title)
+	if a.link.hasText {
+		text.Draw(screen, "Text", face, r.x+20, a.centeredTextBaseline(a.link.textRect, face), dialogLabelColor)
+		a.drawFindInput(screen, face, a.link.textRect, a.link.text, !a.link.onTarget)
+	} else {
+		text.Draw(screen, "Links the selected text", face, r.x+20, a.centeredTextBaseline(a.link.textRect, face), dialogLabelColor)
+	}
+	text.Draw(screen, "Address", face, r.x+20, a.centeredTextBaseline(a.link.targetRect, face), dialogLabelColor)
+	a.drawFindInput(screen, face, a.link.targetRect, a.link.target, a.link.onTarget)
+	hint := ""
+	if a.link.target == "" {
+		hint = "A web address, or #name for a heading or bookmark"
+	}
+	a.drawDialogNote(screen, face, r.x+20, a.link.applyRect, a.link.err, hint)
+	a.drawDialogButtons(screen, face, dialogButton{a.link.applyRect, "OK"}, dialogButton{a.link.cancelRect, "Cancel"}, dialogButton{a.link.removeRect, "Remove link"})
+}
+
+// linkAtPoint returns the target of the link drawn at x, y, if any. A
+// cross-reference links to its target.
+func (a *App) linkAtPoint(x, y int) string {
+	if !a.contentRect.contains(x, y) {
+		return ""
+	}
+	for _, ll := range a.lineLayouts {
+		if y < ll.y || y >= ll.y+ll.height {
+			continue
+		}
+		segX := ll.viewX
+		for _, seg := range ll.segments {
+			if x >= segX && x < segX+seg.width {
+				if seg.attr.Link == "" && seg.attr.Ref.Target != "" {
+					return "#" + seg.attr.Ref.Target
+				}
+				return seg.attr.Link
+			}
+			segX += seg.width
+		}
+	}
+	return ""
+}
+
+// followLink jumps to an internal link's anchor or hands a web address to
+// the system.
+func (a *App) followLink(target string) {
+	if name, ok := sqdoc.LinkAnchor(target); ok {
+		if !a.state.FollowAnchor(name) {
+			a.status = "No heading or bookmark named " + target
+			return
+		}
+		a.pendingFollowCaret = true
+		return
+	}
+	u, err := url.Parse(target)
+	if err != nil || !linkSchemes[strings.ToLower(u.Scheme)] {
+		a.status = "Links to " + target + " are not opened"
+		return
+	}
+	if err := openURL(u.String()); err != nil {
+		a.status = "Open link failed: " + err.Error()
+		return
+	}
+	a.status = "Opened " + target
+}
+
+// linkColor is the colour links are drawn in; stored text colour does not
+// apply to them.
+func (a *App) linkColor() uint32 {
+	c := a.theme.Accent
+	return uint32(c.R)<<24 | uint32(c.G)<<16 | uint32(c.B)<<8 | uint32(c.A)
+}
+
+// drawLinkTooltip shows the target of the link under the mouse.
+func (a *App) drawLinkTooltip(screen *ebiten.Image, face font.Face) {
+	if a.link.visible || a.bookmark.visible || a.crossRef.visible || a.dragSelecting {
+		return
+	}
+	mx, my := ebiten.CursorPosition()
+	target := a.linkAtPoint(mx, my)
+	if target == "" {
+		target = a.noteTextAtPoint(mx, my)
+	}
+	if target == "" {
+		return
+	}
+	label := target + "  ·  Ctrl+Click to follow"
+	for a.measureString(face, label) > a.contentRect.w-40 && len(target) > 8 {
+		_, size := utf8.DecodeLastRuneInString(target)
+		target = target[:len(target)-size]
+		label = target + "…  ·  Ctrl+Click to follow"
+	}
+	w := a.measureString(face, label) + 16
+	h := face.Metrics().Height.Round() + 10
+	x := min(mx+12, a.contentRect.x+a.contentRect.w-w)
+	y := my + 20
+	if y+h > a.contentRect.y+a.contentRect.h {
+		y = my - h - 8
+	}
+	a.drawFilledRectOnScreen(screen, x, y, w, h, color.RGBA{R: 46, G: 58, B: 78, A: 240})
+	text.Draw(screen, label, face, x+8, a.centeredTextBaseline(rect{x: x, y: y, w: w, h: h}, face), color.RGBA{R: 246, G: 249, B: 253, A: 255})
+}
@@ -0,0 +1,69 @@
+package app
+
+import (
+	"fmt"
+
+	"sqdoc/pkg/sqdoc"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"golang.org/x/image/font"
+)
+
+// listIndentPt is how far each list level indents its items; the marker
+// sits in the gap.
+const listIndentPt = 24
+
+var listStyleLabels = []struct {
+	style sqdoc.ListStyle
+	label string
+}{
+	{sqdoc.ListBullet, "Bulleted  •  ◦  ▪"},
+	{sqdoc.ListDecimal, "Numbered  1.  2.  3."},
+	{sqdoc.ListLowerAlpha, "Lettered  a.  b.  c."},
+	{sqdoc.ListUpperAlpha, "Lettered  A.  B.  C."},
+	{sqdoc.ListLowerRoman, "Roman  i.  ii.  iii."},
+	{sqdoc.ListUpperRoman, "Roman  I.  II.  III."},
+}
+
+func (a *App) layoutListMenuBounds() {
+	a.listMenuRect = rect{}
+	a.listMenuItems = a.listMenuItems[:0]
+	if !a.showListMenu {
+		return
+	}
+	item := a.state.ListItem()
+	add := func(label string, active bool, apply func()) {
+		a.listMenuItems = append(a.listMenuItems, menuItem{label: label, active: active, apply: apply})
+	}
+	for _, l := range listStyleLabels {
+		style := l.style
+		add(l.label, item.ID != 0 && item.Style == style, func() { a.state.ToggleList(style) })
+	}
+	if item.ID != 0 {
+		add(fmt.Sprintf("Increase level (%d)", item.Level+1), false, func() { a.state.IndentList(1) })
+		add("Decrease level", false, func() { a.state.IndentList(-1) })
+	}
+	if item.ID != 0 && item.Style.Numbered() {
+		n := a.state.ListNumber()
+		add(fmt.Sprintf("Start at %d", n+1), false, func() { a.state.SetListStart(n + 1) })
+		if n > 1 {
+			add(fmt.Sprintf("Start at %d", n-1), false, func() { a.state.SetListStart(n - 1) })
+		}
+		add("Restart at 1", item.Start == 1, func() { a.state.SetListStart(1) })
+		add("Continue numbering", item.Start == 0, func() { a.state.SetListStart(0) })
+	}
+	a.listMenuRect = a.layoutMenuItems("list_menu", a.listMenuItems, 220)
+}
+
+func (a *App) drawListMenu(screen *ebiten.Image, face font.Face) {
+	if !a.showListMenu {
+		return
+	}
+	a.layoutListMenuBounds()
+	a.drawMenuItems(screen, face, a.listMenuRect, a.listMenuItems)
+}
+
+func (a *App) handleListMenuClick(x, y int) bool {
+	a.layoutListMenuBounds()
+	return a.clickMenuItems(x, y, "list_menu", a.listMenuRect, a.listMenuItems, &a.showListMenu)
+}
@@ -0,0 +1,98 @@
+package app
+
+import (
+	"image/color"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+	"github.com/hajimehoshi/ebiten/v2/text"
+	"golang.org/x/image/font"
+)
+
+// menuItem is one row of a toolbar dropdown whose rows act directly on the
+// document.
+type menuItem struct {
+	label  string
+	active bool
+	// nav items change the view or the mode, not the document, so they
+	// are no undo step.
+	nav   bool
+	apply func()
+	r     rect
+}
+
+// layoutMenuItems places items in a column under the toolbar button id and
+// returns the menu's bounds.
+func (a *App) layoutMenuItems(id string, items []menuItem, width int) rect {
+	anchor, ok := a.toolbarActionRect(id)
+	if !ok {
+		return rect{}
+	}
+	w := int(float32(width) * a.uiScales[a.uiScaleIdx])
+	if w < width-20 {
+		w = width - 20
+	}
+	rowH := int(28 * a.uiScales[a.uiScaleIdx])
+	if rowH < 22 {
+		rowH = 22
+	}
+	x := anchor.x
+	y := anchor.y + anchor.h + 2
+	for i := range items {
+		items[i].r = rect{x: x + 4, y: y + 4 + rowH*i, w: w - 8, h: rowH}
+	}
+	return rect{x: x, y: y, w: w, h: rowH*len(items) + 8}
+}
+
+func (a *App) drawMenuItems(screen *ebiten.Image, face font.Face, r rect, items []menuItem) {
+	if r.w <= 0 {
+		return
+	}
+	border := color.RGBA{R: 172, G: 184, B: 202, A: 255}
+	a.drawFilledRectOnScreen(screen, r.x, r.y, r.w, r.h, color.RGBA{R: 249, G: 251, B: 254, A: 255})
+	ebitenutil.DrawLine(screen, float64(r.x), float64(r.y), float64(r.x+r.w), float64(r.y), border)
+	ebitenutil.DrawLine(screen, float64(r.x), float64(r.y+r.h), float64(r.x+r.w), float64(r.y+r.h), border)
+	ebitenutil.DrawLine(screen, float64(r.x), float64(r.y), float64(r.x), float64(r.y+r.h), border)
+	ebitenutil.DrawLine(screen, float64(r.x+r.w), float64(r.y), float64(r.x+r.w), float64(r.y+r.h), border)
+
+	mx, my := ebiten.CursorPosition()
+	for _, item := range items {
+		bg := color.RGBA{R: 241, G: 245, B: 251, A: 255}
+		if item.active {
+			bg = color.RGBA{R: 215, G: 229, B: 248, A: 255}
+		}
+		if item.r.contains(mx, my) {
+			bg = color.RGBA{R: 223, G: 236, B: 252, A: 255}
+		}
+		a.drawFilledRectOnScreen(screen, item.r.x, item.r.y, item.r.w, item.r.h, bg)
+		text.Draw(screen, item.label, face, item.r.x+10, item.r.y+item.r.h-7, color.RGBA{R: 42, G: 58, B: 82, A: 255})
+	}
+}
+
+// clickMenuItems applies the clicked item as one undo step. A click outside
+// the menu closes it, except on its own toolbar button, which toggles it.
+// The menu stays open after an item so values can be stepped repeatedly.
+func (a *App) clickMenuItems(x, y int, id string, r rect, items []menuItem, show *bool) bool {
+	if r.w <= 0 {
+		return false
+	}
+	if !r.contains(x, y) {
+		if btn, ok := a.toolbarActionRect(id); ok && btn.contains(x, y) {
+			return false
+		}
+		*show = false
+		return true
+	}
+	for _, item := range items {
+		if !item.r.contains(x, y) {
+			continue
+		}
+		if !item.nav && !a.beginEdit() {
+			return true
+		}
+		item.apply()
+		a.status = item.label
+		return true
+	}
+	return true
+}
@@ -0,0 +1,123 @@
+package app
+
+import (
+	"image/color"
+	"strings"
+
+	"sqdoc/pkg/sqdoc"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+)
+
+// insertNote anchors a new footnote or endnote at the caret and moves the
+// caret into it.
+func (a *App) insertNote(kind sqdoc.NoteKind) {
+	if !a.beginEdit() {
+		return
+	}
+	if err := a.state.InsertNote(kind); err != nil {
+		a.status = "Insert note failed: " + err.Error()
+		return
+	}
+	a.status = "Footnote inserted"
+	if kind == sqdoc.NoteEndnote {
+		a.status = "Endnote inserted"
+	}
+	a.pendingFollowCaret = true
+}
+
+// noteMarker returns the label drawn before note block bi, and whether a
+// rule goes above it because it starts the footnotes or the endnotes.
+func (a *App) noteMarker(bi int, numbers map[uint64]string) (string, bool) {
+	b := a.state.Doc.Blocks[bi]
+	first := !a.state.IsNote(bi-1) || a.state.Doc.Blocks[bi-1].Note != b.Note
+	return numbers[b.ID] + ".", first
+}
+
+// noteAtPoint returns the ID of the note whose anchor is drawn at x, y, or
+// 0 when there is none.
+func (a *App) noteAtPoint(x, y int) uint64 {
+	if !a.contentRect.contains(x, y) {
+		return 0
+	}
+	for _, ll := range a.lineLayouts {
+		if y < ll.y || y >= ll.y+ll.height {
+			continue
+		}
+		segX := ll.viewX
+		for _, seg := range ll.segments {
+			if x >= segX && x < segX+seg.width {
+				return seg.attr.Note
+			}
+			segX += seg.width
+		}
+	}
+	return 0
+}
+
+// noteNumberAt reports the note block whose number is drawn at x, y.
+func (a *App) noteNumberAt(x, y int) (int, bool) {
+	if !a.contentRect.contains(x, y) {
+		return 0, false
+	}
+	for _, ll := range a.lineLayouts {
+		if ll.inCell || ll.marker == "" || !a.state.IsNote(ll.block) || y < ll.y || y >= ll.y+ll.height {
+			continue
+		}
+		left := a.contentRect.x + ll.markerDocX - int(a.scrollX)
+		if x >= left && x < ll.viewX {
+			return ll.block, true
+		}
+	}
+	return 0, false
+}
+
+// followNote jumps from an anchor to its note.
+func (a *App) followNote(id uint64) {
+	if !a.state.GoToNote(id) {
+		a.status = "The note is missing"
+		return
+	}
+	a.pendingFollowCaret = true
+}
+
+// returnFromNote jumps from note block bi back to its anchor.
+func (a *App) returnFromNote(bi int) {
+	a.state.ClearSelection()
+	a.state.SetCaret(bi, 0)
+	if a.state.ReturnFromNote() {
+		a.pendingFollowCaret = true
+	}
+}
+
+// noteTextAtPoint returns the text of the note anchored at x, y, on one
+// line, for the tooltip.
+func (a *App) noteTextAtPoint(x, y int) string {
+	id := a.noteAtPoint(x, y)
+	if id == 0 {
+		return ""
+	}
+	for _, b := range a.state.Doc.Blocks {
+		if b.Kind == sqdoc.BlockKindNote && b.ID == id && b.Text != nil {
+			if s := strings.Join(strings.Fields(string(b.Text.UTF8)), " "); s != "" {
+				return s
+			}
+			return "(empty note)"
+		}
+	}
+	return ""
+}
+
+// drawNoteRules draws the rules above the footnotes and the endnotes.
+func (a *App) drawNoteRules(screen *ebiten.Image) {
+	clr := color.RGBA{R: 160, G: 170, B: 186, A: 255}
+	x := float64(8 + a.pages.shift - int(a.scrollX))
+	for _, docY := range a.noteRules {
+		y := float64(docY - int(a.scrollY))
+		if y < 0 || y > float64(a.contentRect.h) {
+			continue
+		}
+		ebitenutil.DrawLine(screen, x, y, x+float64(a.contentRect.w)/3, y, clr)
+	}
+}
@@ -0,0 +1,22 @@
+//go:build !windows
+
+package app
+
+import (
+	"os/exec"
+	"runtime"
+)
+
+// openURL hands a URL to the desktop's opener without waiting for it.
+func openURL(u string) error {
+	opener := "xdg-open"
+	if runtime.GOOS == "darwin" {
+		opener = "open"
+	}
+	cmd := exec.Command(opener, u)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	go cmd.Wait()
+	return nil
+}
@@ -0,0 +1,26 @@
+//go:build windows
+
+package app
+
+import (
+	"fmt"
+	"syscall"
+	"unsafe"
+)
+
+var shellExecute = syscall.NewLazyDLL("shell32.dll").NewProc("ShellExecuteW")
+
+// openURL hands a URL to the default handler for its scheme.
+func openURL(u string) error {
+	verb, _ := syscall.UTF16PtrFromString("open")
+	target, err := syscall.UTF16PtrFromString(u)
+	if err != nil {
+		return err
+	}
+	const swShowNormal = 1
+	// ShellExecute reports success with a value above 32.
+	if r, _, _ := shellExecute.Call(0, uintptr(unsafe.Pointer(verb)), uintptr(unsafe.Pointer(target)), 0, 0, swShowNormal); r <= 32 {
+		return fmt.Errorf("ShellExecute failed with code %d", r)
+	}
+	return nil
+}
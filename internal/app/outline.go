@@ -0,0 +1,213 @@
+package app
+
+import (
+	"image/color"
+	"sort"
+
+	"sqdoc/internal/editor"
+	"sqdoc/pkg/sqdoc"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/text"
+	"golang.org/x/image/font"
+)
+
+// outlineEntry is a heading or bookmark, as the outline panel and the
+// cross-reference dialog list them.
+type outlineEntry struct {
+	label string
+	// depth is the indent: heading levels count from the top level the
+	// document uses, and bookmarks sit one below their section.
+	depth    int
+	name     string
+	block    int
+	offset   int
+	bookmark bool
+}
+
+type outlineKey struct {
+	state    *editor.State
+	revision uint64
+}
+
+// outlinePanel is the navigation panel left of the document.
+type outlinePanel struct {
+	visible bool
+	rect    rect
+	scroll  int
+	rows    []rect
+
+	key     outlineKey
+	entries []outlineEntry
+}
+
+// outlineEntries lists the headings and bookmarks of the document in order.
+// Headings whose anchor name an earlier heading took have no name, since
+// links cannot reach them.
+func (a *App) outlineEntries() []outlineEntry {
+	key := outlineKey{state: a.state, revision: a.docRevision}
+	if key == a.outline.key && a.outline.entries != nil {
+		return a.outline.entries
+	}
+	doc := a.state.Doc
+	anchors := doc.Anchors()
+	headings := doc.Outline()
+	var out []outlineEntry
+	top := 0
+	for _, h := range headings {
+		if top == 0 || h.Level < top {
+			top = h.Level
+		}
+	}
+	for _, h := range headings {
+		e := outlineEntry{label: h.Number + "  " + h.Text, depth: h.Level - top, block: h.Block}
+		name := sqdoc.HeadingAnchor(string(doc.Blocks[h.Block].Text.UTF8))
+		if at, ok := anchors[name]; ok && at == (sqdoc.Anchor{Block: h.Block}) {
+			e.name = name
+		}
+		out = append(out, e)
+	}
+	for _, bm := range a.state.Bookmarks() {
+		out = append(out, outlineEntry{label: "# " + bm.Name, name: bm.Name, block: bm.Block, offset: bm.Offset, bookmark: true})
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		if out[i].block != out[j].block {
+			return out[i].block < out[j].block
+		}
+		return out[i].offset < out[j].offset
+	})
+	depth := 0
+	for i := range out {
+		if out[i].bookmark {
+			out[i].depth = depth
+			continue
+		}
+		depth = out[i].depth + 1
+	}
+	a.outline.key = key
+	a.outline.entries = out
+	return out
+}
+
+func (a *App) toggleOutline() {
+	a.outline.visible = !a.outline.visible
+	a.outline.scroll = 0
+}
+
+func (a *App) outlineRowHeight() int {
+	return max(int(24*a.uiScales[a.uiScaleIdx]), 20)
+}
+
+// layoutOutlineRows places the visible entries below the panel title.
+func (a *App) layoutOutlineRows() {
+	a.outline.rows = a.outline.rows[:0]
+	r := a.outline.rect
+	if !a.outline.visible || r.w <= 0 {
+		return
+	}
+	rowH := a.outlineRowHeight()
+	entries := a.outlineEntries()
+	a.outline.scroll = max(min(a.outline.scroll, len(entries)-(r.h-34)/rowH), 0)
+	y := r.y + 30
+	for i := a.outline.scroll; i < len(entries) && y+rowH <= r.y+r.h-4; i++ {
+		a.outline.rows = append(a.outline.rows, rect{x: r.x + 4, y: y, w: r.w - 8, h: rowH})
+		y += rowH
+	}
+}
+
+// currentOutlineEntry returns the index of the last entry at or before the
+// caret, or -1.
+func (a *App) currentOutlineEntry(entries []outlineEntry) int {
+	at := -1
+	for i, e := range entries {
+		if e.block < a.state.CurrentBlock || e.block == a.state.CurrentBlock && e.offset <= a.state.CaretByte {
+			at = i
+		}
+	}
+	return at
+}
+
+func (a *App) drawOutlinePanel(screen *ebiten.Image, face font.Face) {
+	r := a.outline.rect
+	if !a.outline.visible || r.w <= 0 || r.h <= 0 {
+		return
+	}
+	a.layoutOutlineRows()
+	a.drawFilledRectOnScreen(screen, r.x, r.y, r.w, r.h, color.RGBA{R: 247, G: 250, B: 254, A: 255})
+	a.drawFilledRectOnScreen(screen, r.x, r.y, r.w, 26, color.RGBA{R: 235, G: 241, B: 249, A: 255})
+	a.drawFilledRectOnScreen(screen, r.x+r.w-1, r.y, 1, r.h, color.RGBA{R: 188, G: 198, B: 214, A: 255})
+	text.Draw(screen, "Outline", face, r.x+10, r.y+17, color.RGBA{R: 47, G: 60, B: 78, A: 255})
+
+	entries := a.outlineEntries()
+	if len(entries) == 0 {
+		text.Draw(screen, "No headings or bookmarks", face, r.x+10, r.y+48, color.RGBA{R: 110, G: 122, B: 140, A: 255})
+		return
+	}
+	current := a.currentOutlineEntry(entries)
+	mx, my := ebiten.CursorPosition()
+	indent := int(14 * a.uiScales[a.uiScaleIdx])
+	for n, row := range a.outline.rows {
+		i := a.outline.scroll + n
+		e := entries[i]
+		switch {
+		case row.contains(mx, my):
+			a.drawFilledRectOnScreen(screen, row.x, row.y, row.w, row.h, color.RGBA{R: 223, G: 236, B: 252, A: 255})
+		case i == current:
+			a.drawFilledRectOnScreen(screen, row.x, row.y, row.w, row.h, color.RGBA{R: 215, G: 229, B: 248, A: 255})
+		}
+		fg := color.RGBA{R: 42, G: 58, B: 82, A: 255}
+		if e.bookmark {
+			fg = color.RGBA{R: 96, G: 108, B: 128, A: 255}
+		}
+		x := row.x + 6 + e.depth*indent
+		label := a.fitLabel(face, e.label, row.x+row.w-4-x)
+		text.Draw(screen, label, face, x, a.centeredTextBaseline(row, face), fg)
+	}
+}
+
+// fitLabel shortens label with an ellipsis to fit w pixels.
+func (a *App) fitLabel(face font.Face, label string, w int) string {
+	if a.measureString(face, label) <= w {
+		return label
+	}
+	runes := []rune(label)
+	for len(runes) > 0 && a.measureString(face, string(runes)+"…") > w {
+		runes = runes[:len(runes)-1]
+	}
+	return string(runes) + "…"
+}
+
+// handleOutlineClick jumps to the clicked entry. It reports whether the
+// click was on the panel.
+func (a *App) handleOutlineClick(x, y int) bool {
+	if !a.outline.visible || !a.outline.rect.contains(x, y) {
+		return false
+	}
+	entries := a.outlineEntries()
+	for n, row := range a.outline.rows {
+		if i := a.outline.scroll + n; row.contains(x, y) && i < len(entries) {
+			a.state.ClearSelection()
+			a.state.SetCaret(entries[i].block, entries[i].offset)
+			a.selectedImageValid = false
+			a.pendingFollowCaret = true
+			return true
+		}
+	}
+	return true
+}
+
+// scrollOutline scrolls the panel when the mouse is over it, reporting
+// whether it took the wheel.
+func (a *App) scrollOutline(wheelY float64) bool {
+	mx, my := ebiten.CursorPosition()
+	if !a.outline.visible || !a.outline.rect.contains(mx, my) {
+		return false
+	}
+	if wheelY > 0 {
+		a.outline.scroll--
+	} else if wheelY < 0 {
+		a.outline.scroll++
+	}
+	a.outline.scroll = max(a.outline.scroll, 0)
+	return true
+}
@@ -0,0 +1,479 @@
+package app
+
+import (
+	"fmt"
+	"image/color"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"sqdoc/internal/render"
+	"sqdoc/pkg/sqdoc"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+	"github.com/hajimehoshi/ebiten/v2/text"
+	"golang.org/x/image/font"
+)
+
+// pageGapPt is the grey space around page sheets.
+const pageGapPt = 16
+
+// pageMargins are the margin presets of the Page menu, in points.
+var pageMargins = []struct {
+	name string
+	pt   uint16
+}{
+	{"Narrow margins (0.5 in)", 36},
+	{"Normal margins (1 in)", 72},
+	{"Wide margins (1.5 in)", 108},
+}
+
+// pageView is where paged mode put the pages, in document pixels.
+type pageView struct {
+	sheets []rect
+	// shift is how far right of the usual left edge the text moved.
+	shift int
+}
+
+// flowBlock is a block, or a row group of a table, as laid out in one long
+// column, before paged mode moves it onto its page: where its layouts start
+// and the space it takes.
+type flowBlock struct {
+	block                      int
+	lines, cells, codes, rules int
+	// top and bottom bound its content, without the space around it.
+	top, bottom   int
+	before, after int
+	breakBefore   bool
+	whole         bool
+}
+
+func (a *App) pagePixels(pt int) int {
+	return int(float32(pt) * a.uiScales[a.uiScaleIdx])
+}
+
+// pageTextWidth is the width paged mode wraps text to.
+func (a *App) pageTextWidth() int {
+	return max(80, a.pagePixels(a.state.Doc.Metadata.Page.TextWidth()))
+}
+
+// paginate moves the layouts of flow onto page sheets, keeping paragraphs
+// from leaving widows and orphans, and returns the height and right edge
+// of the sheets. Footnotes go at the foot of the page their first anchor
+// is on, below a rule, as in PDF export.
+func (a *App) paginate(flow []flowBlock) (int, int) {
+	setup := a.state.Doc.Metadata.Page.Resolved()
+	pageW, pageH := a.pagePixels(int(setup.Width)), a.pagePixels(int(setup.Height))
+	gap := max(8, a.pagePixels(pageGapPt))
+	sheetX := max(gap, (a.contentRect.w-12-pageW)/2)
+	lineGap := max(2, a.pagePixels(4))
+	noteRule := a.pagePixels(12)
+	textH := a.pagePixels(setup.TextHeight())
+	shift := sheetX + a.pagePixels(int(setup.Left)) - 8
+
+	footnotes := map[uint64]int{}
+	for i, fb := range flow {
+		if b := a.state.Doc.Blocks[fb.block]; b.Kind == sqdoc.BlockKindNote && b.Note == sqdoc.NoteFootnote {
+			footnotes[b.ID] = i
+		}
+	}
+	noteHeight := func(i int) int { return flow[i].bottom - flow[i].top + lineGap }
+
+	// A last entry marks where the layouts of the real ones end.
+	flow = append(flow, flowBlock{lines: len(a.lineLayouts), cells: len(a.cellLayouts), codes: len(a.codeLayouts), rules: len(a.noteRules)})
+	// anchored records the box and line of each footnote's first anchor.
+	// Note blocks follow the body, so a footnote is anchored by the time
+	// the loop reaches it; one with no anchor found stays in the flow.
+	type anchor struct{ box, line int }
+	anchored := map[int]anchor{}
+	var order []int
+	var boxes []render.PageBlock
+	var body []int
+	for i := 0; i < len(flow)-1; i++ {
+		if _, ok := anchored[i]; ok {
+			continue
+		}
+		fb, next := flow[i], flow[i+1]
+		box := render.PageBlock{Before: float64(fb.before), After: float64(fb.after), BreakBefore: fb.breakBefore, Whole: fb.whole}
+		if fb.whole || fb.lines == next.lines {
+			box.Whole = true
+			box.Lines = []float64{float64(fb.bottom - fb.top)}
+		} else {
+			for k := fb.lines; k < next.lines; k++ {
+				below := fb.bottom
+				if k+1 < next.lines {
+					below = a.lineLayouts[k+1].docY
+				}
+				box.Lines = append(box.Lines, float64(below-a.lineLayouts[k].docY))
+			}
+		}
+		for k := fb.lines; k < next.lines; k++ {
+			for _, seg := range a.lineLayouts[k].segments {
+				j, ok := footnotes[seg.attr.Note]
+				if _, done := anchored[j]; !ok || done || j <= i {
+					continue
+				}
+				line := 0
+				if !box.Whole {
+					line = k - fb.lines
+				}
+				if box.Notes == nil {
+					box.Notes = make([]float64, len(box.Lines))
+				}
+				box.Notes[line] += float64(noteHeight(j))
+				anchored[j] = anchor{box: len(boxes), line: line}
+				order = append(order, j)
+			}
+		}
+		boxes = append(boxes, box)
+		body = append(body, i)
+	}
+	pages := render.Paginate(boxes, float64(textH+lineGap), float64(noteRule))
+	pageTop := func(p int) int {
+		return gap + p*(pageH+gap) + a.pagePixels(int(setup.Top))
+	}
+
+	var rules []int
+	// move shifts the layouts of flow block i down by dy, onto page.
+	move := func(i, dy, page int) {
+		fb, next := flow[i], flow[i+1]
+		for k := fb.lines; k < next.lines; k++ {
+			ll := &a.lineLayouts[k]
+			ll.docY += dy
+			ll.docX += shift
+			ll.markerDocX += shift
+			ll.page = page
+		}
+		for k := fb.cells; k < next.cells; k++ {
+			a.cellLayouts[k].docY += dy
+			a.cellLayouts[k].docX += shift
+		}
+		for k := fb.codes; k < next.codes; k++ {
+			a.codeLayouts[k].docY += dy
+			a.codeLayouts[k].docX += shift
+		}
+		for k := fb.rules; k < next.rules; k++ {
+			rules = append(rules, a.noteRules[k]+dy)
+		}
+	}
+	for n, box := range boxes {
+		i, spots := body[n], pages.Spots[n]
+		fb := flow[i]
+		move(i, pageTop(spots[0].Page)+int(spots[0].Y)-fb.top, spots[0].Page)
+		if box.Whole {
+			continue
+		}
+		// Lines of a paragraph that breaks across pages move one by one.
+		for k := fb.lines; k < flow[i+1].lines; k++ {
+			ll := &a.lineLayouts[k]
+			spot := spots[k-fb.lines]
+			ll.docY = pageTop(spot.Page) + int(spot.Y)
+			ll.page = spot.Page
+		}
+	}
+
+	// Each page's footnotes fill the space Paginate left at its foot,
+	// starting with the rule.
+	feet := make([]int, pages.Count())
+	for p := range feet {
+		feet[p] = pageTop(p) + textH + lineGap - int(pages.Notes[p])
+	}
+	ruled := make([]bool, pages.Count())
+	for _, j := range order {
+		at := anchored[j]
+		p := pages.Spots[at.box][at.line].Page
+		if !ruled[p] {
+			ruled[p] = true
+			rules = append(rules, feet[p]+noteRule/2)
+			feet[p] += noteRule
+		}
+		rulesFrom := len(rules)
+		move(j, feet[p]-flow[j].top, p)
+		// The rule the first footnote had in the long column is not needed.
+		rules = rules[:rulesFrom]
+		feet[p] += noteHeight(j)
+	}
+	a.noteRules = rules
+
+	a.pages = pageView{shift: shift}
+	for p := 0; p < pages.Count(); p++ {
+		a.pages.sheets = append(a.pages.sheets, rect{x: sheetX, y: gap + p*(pageH+gap), w: pageW, h: pageH})
+	}
+	return gap + pages.Count()*(pageH+gap), sheetX + pageW + gap
+}
+
+// drawPageSheets draws the pages of paged mode on a grey desk.
+func (a *App) drawPageSheets() {
+	if !a.pagedMode {
+		return
+	}
+	r := a.contentRect
+	a.fillRectWithinContent(r.x, r.y, r.w, r.h, color.RGBA{R: 226, G: 230, B: 236, A: 255})
+	shadow := max(2, a.pagePixels(3))
+	for _, s := range a.pages.sheets {
+		x, y := r.x+s.x-int(a.scrollX), r.y+s.y-int(a.scrollY)
+		if y > r.y+r.h || y+s.h < r.y {
+			continue
+		}
+		a.fillRectWithinContent(x+shadow, y+shadow, s.w, s.h, color.RGBA{R: 196, G: 202, B: 212, A: 255})
+		a.fillRectWithinContent(x-1, y-1, s.w+2, s.h+2, color.RGBA{R: 176, G: 184, B: 196, A: 255})
+		a.fillRectWithinContent(x, y, s.w, s.h, color.RGBA{R: 255, G: 255, B: 255, A: 255})
+	}
+}
+
+// caretPage returns the page the caret is on, from 1.
+func (a *App) caretPage() int {
+	for _, ll := range a.lineLayouts {
+		if ll.block == a.state.CurrentBlock && a.lineInActiveCell(ll) &&
+			a.state.CaretByte >= ll.startByte && a.state.CaretByte <= ll.startByte+len(ll.text) {
+			return ll.page + 1
+		}
+	}
+	return 1
+}
+
+// pageStatus is the page counter of the status bar, or "" outside paged
+// mode.
+func (a *App) pageStatus() string {
+	if !a.pagedMode || len(a.pages.sheets) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("Page %d/%d", a.caretPage(), len(a.pages.sheets))
+}
+
+func (a *App) togglePagedMode() {
+	a.pagedMode = !a.pagedMode
+	a.scrollX = 0
+	a.status = "Paged mode disabled"
+	if a.pagedMode {
+		a.status = "Paged mode enabled"
+	}
+	a.pendingFollowCaret = true
+}
+
+// setPageSetup makes p the document's page setup if it is valid.
+func (a *App) setPageSetup(p sqdoc.PageSetup) {
+	if err := p.Validate(); err != nil {
+		a.status = "Page setup: " + err.Error()
+		return
+	}
+	if p == sqdoc.DefaultPageSetup() {
+		p = sqdoc.PageSetup{}
+	}
+	a.state.Doc.Metadata.Page = p
+	a.status = fmt.Sprintf("Page %s %d x %d pt", p.SizeName(), p.Resolved().Width, p.Resolved().Height)
+	a.pendingFollowCaret = true
+}
+
+// setPageSize keeps the way the page is turned.
+func (a *App) setPageSize(size sqdoc.PageSize) {
+	p := a.state.Doc.Metadata.Page.Resolved()
+	p.Width, p.Height = size.Width, size.Height
+	if a.state.Doc.Metadata.Page.Landscape() {
+		p.Width, p.Height = p.Height, p.Width
+	}
+	a.setPageSetup(p)
+}
+
+// turnPage swaps portrait and landscape, turning the margins with the page.
+func (a *App) turnPage() {
+	p := a.state.Doc.Metadata.Page.Resolved()
+	a.setPageSetup(sqdoc.PageSetup{Width: p.Height, Height: p.Width, Top: p.Left, Right: p.Top, Bottom: p.Right, Left: p.Bottom})
+}
+
+func (a *App) setPageMargins(pt uint16) {
+	p := a.state.Doc.Metadata.Page.Resolved()
+	p.Top, p.Right, p.Bottom, p.Left = pt, pt, pt, pt
+	a.setPageSetup(p)
+}
+
+func (a *App) insertPageBreak() {
+	if !a.state.InsertPageBreak() {
+		a.status = "Page breaks cannot go in tables, notes or code"
+		if a.state.Suggesting {
+			a.status = "Page breaks cannot be suggested"
+		}
+		return
+	}
+	a.status = "Page break inserted"
+	a.pendingFollowCaret = true
+}
+
+func (a *App) layoutPageMenuBounds() {
+	a.pageMenuRect = rect{}
+	a.pageMenuItems = a.pageMenuItems[:0]
+	if !a.showPageMenu {
+		return
+	}
+	add := func(label string, active, nav bool, apply func()) {
+		a.pageMenuItems = append(a.pageMenuItems, menuItem{label: label, active: active, nav: nav, apply: apply})
+	}
+	page := a.state.Doc.Metadata.Page
+	p := page.Resolved()
+	add("Paged view", a.pagedMode, true, a.togglePagedMode)
+	for _, size := range sqdoc.PageSizes {
+		add(fmt.Sprintf("%s  %d x %d pt", size.Name, size.Width, size.Height), page.SizeName() == size.Name, false, func() { a.setPageSize(size) })
+	}
+	add("Landscape", page.Landscape(), false, a.turnPage)
+	for _, m := range pageMargins {
+		add(m.name, p.Top == m.pt && p.Right == m.pt && p.Bottom == m.pt && p.Left == m.pt, false, func() { a.setPageMargins(m.pt) })
+	}
+	add("Page setup...", page.SizeName() == "Custom", true, a.openPageSetupDialog)
+	add("Page break before", a.state.ParagraphFormat().PageBreakBefore, false, func() { a.state.TogglePageBreak() })
+	add("Insert page break  Ctrl+Enter", false, false, a.insertPageBreak)
+	a.pageMenuRect = a.layoutMenuItems("page_menu", a.pageMenuItems, 240)
+}
+
+func (a *App) drawPageMenu(screen *ebiten.Image, face font.Face) {
+	if !a.showPageMenu {
+		return
+	}
+	a.layoutPageMenuBounds()
+	a.drawMenuItems(screen, face, a.pageMenuRect, a.pageMenuItems)
+}
+
+func (a *App) handlePageMenuClick(x, y int) bool {
+	a.layoutPageMenuBounds()
+	return a.clickMenuItems(x, y, "page_menu", a.pageMenuRect, a.pageMenuItems, &a.showPageMenu)
+}
+
+// pageSetupDialog sets the page size and margins in points.
+type pageSetupDialog struct {
+	visible   bool
+	onMargins bool
+	size      string
+	margins   string
+	err       string
+
+	rect        rect
+	sizeRect    rect
+	marginsRect rect
+	applyRect   rect
+	cancelRect  rect
+}
+
+func (a *App) openPageSetupDialog() {
+	a.showPageMenu = false
+	p := a.state.Doc.Metadata.Page.Resolved()
+	margins := fmt.Sprintf("%d %d %d %d", p.Top, p.Right, p.Bottom, p.Left)
+	if p.Top == p.Right && p.Top == p.Bottom && p.Top == p.Left {
+		margins = strconv.Itoa(int(p.Top))
+	}
+	a.pageSetup = pageSetupDialog{visible: true, size: fmt.Sprintf("%d x %d", p.Width, p.Height), margins: margins}
+}
+
+func (a *App) closePageSetupDialog() {
+	a.pageSetup = pageSetupDialog{}
+}
+
+// parsePoints reads whole numbers of points separated by spaces, commas or
+// an x.
+func parsePoints(s string) ([]uint16, bool) {
+	fields := strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return r == ' ' || r == ',' || r == 'x'
+	})
+	out := make([]uint16, 0, len(fields))
+	for _, f := range fields {
+		v, err := strconv.ParseUint(f, 10, 16)
+		if err != nil {
+			return nil, false
+		}
+		out = append(out, uint16(v))
+	}
+	return out, true
+}
+
+func (a *App) applyPageSetupDialog() {
+	size, ok := parsePoints(a.pageSetup.size)
+	if !ok || len(size) != 2 {
+		a.pageSetup.err = "Enter the size as width x height"
+		return
+	}
+	margins, ok := parsePoints(a.pageSetup.margins)
+	if ok && len(margins) == 1 {
+		margins = []uint16{margins[0], margins[0], margins[0], margins[0]}
+	}
+	if !ok || len(margins) != 4 {
+		a.pageSetup.err = "Enter one margin, or top right bottom left"
+		return
+	}
+	p := sqdoc.PageSetup{Width: size[0], Height: size[1], Top: margins[0], Right: margins[1], Bottom: margins[2], Left: margins[3]}
+	if err := p.Validate(); err != nil {
+		a.pageSetup.err = err.Error()
+		return
+	}
+	if !a.beginEdit() {
+		return
+	}
+	a.setPageSetup(p)
+	a.closePageSetupDialog()
+}
+
+func (a *App) handlePageSetupDialogInput() {
+	field := &a.pageSetup.size
+	if a.pageSetup.onMargins {
+		field = &a.pageSetup.margins
+	}
+	switch {
+	case inpututil.IsKeyJustPressed(ebiten.KeyTab):
+		a.pageSetup.onMargins = !a.pageSetup.onMargins
+		return
+	case inpututil.IsKeyJustPressed(ebiten.KeyEnter) || inpututil.IsKeyJustPressed(ebiten.KeyKPEnter):
+		a.applyPageSetupDialog()
+		return
+	case inpututil.IsKeyJustPressed(ebiten.KeyBackspace):
+		if len(*field) > 0 {
+			_, size := utf8.DecodeLastRuneInString(*field)
+			*field = (*field)[:len(*field)-size]
+		}
+	}
+	for _, r := range ebiten.AppendInputChars(nil) {
+		if (r >= '0' && r <= '9' || r == ' ' || r == ',' || r == 'x' || r == 'X') && len(*field) < 32 {
+			*field += string(r)
+			a.pageSetup.err = ""
+		}
+	}
+}
+
+func (a *App) layoutPageSetupDialog(w, h int, face font.Face) {
+	scale := a.uiScales[a.uiScaleIdx]
+	pw := min(int(440*scale), w-40)
+	ph := min(int(190*scale), h-40)
+	a.pageSetup.rect = rect{x: (w - pw) / 2, y: (h - ph) / 2, w: pw, h: ph}
+	r := a.pageSetup.rect
+	labelW := a.measureString(face, "Margins") + 30
+	rowH := int(30 * scale)
+	a.pageSetup.sizeRect = rect{x: r.x + labelW, y: r.y + int(44*scale), w: pw - labelW - 20, h: rowH}
+	a.pageSetup.marginsRect = rect{x: r.x + labelW, y: a.pageSetup.sizeRect.y + rowH + 10, w: pw - labelW - 20, h: rowH}
+	buttons := a.placeDialogButtons(face, r, rowH, "Cancel", "OK")
+	a.pageSetup.cancelRect, a.pageSetup.applyRect = buttons[0], buttons[1]
+}
+
+func (a *App) handlePageSetupDialogClick(x, y int) {
+	switch {
+	case !a.pageSetup.rect.contains(x, y) || a.pageSetup.cancelRect.contains(x, y):
+		a.closePageSetupDialog()
+	case a.pageSetup.sizeRect.contains(x, y):
+		a.pageSetup.onMargins = false
+	case a.pageSetup.marginsRect.contains(x, y):
+		a.pageSetup.onMargins = true
+	case a.pageSetup.applyRect.contains(x, y):
+		a.applyPageSetupDialog()
+	}
+}
+
+func (a *App) drawPageSetupDialog(screen *ebiten.Image, w, h int, face font.Face) {
+	if !a.pageSetup.visible {
+		return
+	}
+	a.layoutPageSetupDialog(w, h, face)
+	r := a.pageSetup.rect
+	a.drawDialogFrame(screen, w, h, r, "Page Setup")
+	text.Draw(screen, "Size", face, r.x+20, a.centeredTextBaseline(a.pageSetup.sizeRect, face), dialogLabelColor)
+	a.drawFindInput(screen, face, a.pageSetup.sizeRect, a.pageSetup.size, !a.pageSetup.onMargins)
+	text.Draw(screen, "Margins", face, r.x+20, a.centeredTextBaseline(a.pageSetup.marginsRect, face), dialogLabelColor)
+	a.drawFindInput(screen, face, a.pageSetup.marginsRect, a.pageSetup.margins, a.pageSetup.onMargins)
+	a.drawDialogNote(screen, face, r.x+20, a.pageSetup.applyRect, a.pageSetup.err, "In points, 72 to the inch")
+	a.drawDialogButtons(screen, face, dialogButton{a.pageSetup.applyRect, "OK"}, dialogButton{a.pageSetup.cancelRect, "Cancel"})
+}
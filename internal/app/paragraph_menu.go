@@ -0,0 +1,80 @@
+package app
+
+import (
+	"fmt"
+
+	"sqdoc/pkg/sqdoc"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"golang.org/x/image/font"
+)
+
+// indentStepPt is how far Ctrl+M and the indent menu items move a paragraph.
+const indentStepPt = 36
+
+var alignmentKeys = []struct {
+	key   ebiten.Key
+	id    string
+	name  string
+	align sqdoc.Alignment
+}{
+	{ebiten.KeyL, "align_left", "left", sqdoc.AlignLeft},
+	{ebiten.KeyE, "align_center", "centre", sqdoc.AlignCenter},
+	{ebiten.KeyR, "align_right", "right", sqdoc.AlignRight},
+	{ebiten.KeyJ, "align_justify", "justified", sqdoc.AlignJustify},
+}
+
+var lineHeightKeys = []struct {
+	key     ebiten.Key
+	percent int
+}{
+	{ebiten.Key1, 100},
+	{ebiten.Key2, 200},
+	{ebiten.Key5, 150},
+}
+
+func (a *App) layoutParagraphMenuBounds() {
+	a.paragraphMenuRect = rect{}
+	a.paragraphMenuItems = a.paragraphMenuItems[:0]
+	if !a.showParagraphMenu {
+		return
+	}
+	para := a.state.ParagraphFormat()
+	lineHeight := int(para.LineHeight)
+	if lineHeight == 0 {
+		lineHeight = 100
+	}
+	add := func(label string, active bool, apply func()) {
+		a.paragraphMenuItems = append(a.paragraphMenuItems, menuItem{label: label, active: active, apply: apply})
+	}
+	add(fmt.Sprintf("Increase indent (%dpt)", para.IndentLeft), false, func() { a.state.AdjustIndent(indentStepPt) })
+	add("Decrease indent", false, func() { a.state.AdjustIndent(-indentStepPt) })
+	add("First-line indent", para.IndentFirst > 0, func() { a.state.SetFirstLineIndent(indentStepPt) })
+	add("Hanging indent", para.IndentFirst < 0, func() { a.state.SetFirstLineIndent(-indentStepPt) })
+	add("No first-line indent", para.IndentFirst == 0, func() { a.state.SetFirstLineIndent(0) })
+	add(fmt.Sprintf("Increase right indent (%dpt)", para.IndentRight), false, func() { a.state.AdjustRightIndent(indentStepPt) })
+	add("Decrease right indent", false, func() { a.state.AdjustRightIndent(-indentStepPt) })
+	for _, percent := range []int{100, 115, 150, 200} {
+		percent := percent
+		add(fmt.Sprintf("Line spacing %d.%02d", percent/100, percent%100), lineHeight == percent, func() { a.state.SetLineHeight(percent) })
+	}
+	add(fmt.Sprintf("Space before +6pt (%dpt)", para.SpaceBefore), false, func() { a.state.AdjustSpacing(6, 0) })
+	add("Space before -6pt", false, func() { a.state.AdjustSpacing(-6, 0) })
+	add(fmt.Sprintf("Space after +6pt (%dpt)", para.SpaceAfter), false, func() { a.state.AdjustSpacing(0, 6) })
+	add("Space after -6pt", false, func() { a.state.AdjustSpacing(0, -6) })
+
+	a.paragraphMenuRect = a.layoutMenuItems("paragraph_menu", a.paragraphMenuItems, 240)
+}
+
+func (a *App) drawParagraphMenu(screen *ebiten.Image, face font.Face) {
+	if !a.showParagraphMenu {
+		return
+	}
+	a.layoutParagraphMenuBounds()
+	a.drawMenuItems(screen, face, a.paragraphMenuRect, a.paragraphMenuItems)
+}
+
+func (a *App) handleParagraphMenuClick(x, y int) bool {
+	a.layoutParagraphMenuBounds()
+	return a.clickMenuItems(x, y, "paragraph_menu", a.paragraphMenuRect, a.paragraphMenuItems, &a.showParagraphMenu)
+}
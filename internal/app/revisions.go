@@ -0,0 +1,185 @@
+package app
+
+import (
+	"hash/fnv"
+	"time"
+
+	"sqdoc/pkg/sqdoc"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+	"github.com/hajimehoshi/ebiten/v2/text"
+	"golang.org/x/image/font"
+)
+
+// revisionColors tell the authors of suggestions apart.
+var revisionColors = []uint32{0xC0392BFF, 0x1F63A8FF, 0x2E7D32FF, 0x8A3FA0FF, 0xB35900FF, 0x00796BFF}
+
+func revisionColor(author string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(author))
+	return revisionColors[h.Sum32()%uint32(len(revisionColors))]
+}
+
+func (a *App) revisionByID(id uint64) *sqdoc.Revision {
+	if id == 0 {
+		return nil
+	}
+	for k := range a.state.Doc.Revisions {
+		if a.state.Doc.Revisions[k].ID == id {
+			return &a.state.Doc.Revisions[k]
+		}
+	}
+	return nil
+}
+
+// markRevision colours inserted and deleted text in its author's colour as
+// it is laid out.
+func (a *App) markRevision(attr *sqdoc.StyleAttr) {
+	if r := a.revisionByID(attr.Revision); r != nil && r.Kind != sqdoc.RevisionFormat {
+		attr.ColorRGBA = revisionColor(r.Author)
+	}
+}
+
+// drawRevisionMark underlines inserted text, strikes through deleted text
+// and dots a line under reformatted text.
+func (a *App) drawRevisionMark(dst *ebiten.Image, face font.Face, attr sqdoc.StyleAttr, x, baseline, w int) {
+	r := a.revisionByID(attr.Revision)
+	if r == nil || w <= 0 {
+		return
+	}
+	clr := rgbaFromUint32(revisionColor(r.Author))
+	under := baseline + max(1, face.Metrics().Descent.Round()/2)
+	if attr.Underline {
+		under += 2
+	}
+	switch r.Kind {
+	case sqdoc.RevisionInsert:
+		ebitenutil.DrawLine(dst, float64(x), float64(under), float64(x+w), float64(under), clr)
+	case sqdoc.RevisionDelete:
+		y := float64(baseline - face.Metrics().Ascent.Round()/3)
+		ebitenutil.DrawLine(dst, float64(x), y, float64(x+w), y, clr)
+	case sqdoc.RevisionFormat:
+		for dx := 0; dx < w; dx += 4 {
+			ebitenutil.DrawLine(dst, float64(x+dx), float64(under), float64(x+min(dx+2, w)), float64(under), clr)
+		}
+	}
+}
+
+// drawBreakMark draws a pilcrow after the last line of a paragraph whose
+// break is a suggested change, marked like text of that change.
+func (a *App) drawBreakMark(dst *ebiten.Image, ll lineLayout, x, baseline int) {
+	if ll.inCell || len(ll.segments) == 0 || ll.block < 0 || ll.block >= len(a.state.Doc.Blocks) {
+		return
+	}
+	tb := a.state.Doc.Blocks[ll.block].Text
+	if tb == nil || tb.Break == 0 || ll.startByte+len(ll.text) != len(tb.UTF8) {
+		return
+	}
+	r := a.revisionByID(tb.Break)
+	if r == nil {
+		return
+	}
+	seg := ll.segments[len(ll.segments)-1]
+	attr := seg.attr
+	attr.Revision, attr.Underline = r.ID, false
+	const pilcrow = "\u00b6"
+	text.Draw(dst, pilcrow, seg.face, x+2, baseline, rgbaFromUint32(revisionColor(r.Author)))
+	a.drawRevisionMark(dst, seg.face, attr, x+2, baseline, a.measureString(seg.face, pilcrow))
+}
+
+func (a *App) toggleSuggesting() {
+	a.state.Suggesting = !a.state.Suggesting
+	a.state.Author = sqdoc.UserName()
+	a.status = "Suggesting off: edits are made directly"
+	if a.state.Suggesting {
+		a.status = "Suggesting on: edits are recorded as changes; paragraph formatting is locked"
+	}
+}
+
+// currentRevision returns the change at the caret, or at the start of the
+// selection, or 0.
+func (a *App) currentRevision() uint64 {
+	block, pos := a.state.CurrentBlock, a.state.CaretByte
+	if start, _, has := a.state.SelectionRange(); has {
+		block, pos = start.Block, start.Byte
+	}
+	if id := a.state.RevisionAt(block, pos); id != 0 || pos == 0 {
+		return id
+	}
+	return a.state.RevisionAt(block, pos-1)
+}
+
+// settleCurrentRevision accepts or rejects the change at the caret and
+// moves on to the next one.
+func (a *App) settleCurrentRevision(accept bool) {
+	id := a.currentRevision()
+	if id == 0 {
+		return
+	}
+	if accept {
+		a.state.AcceptRevision(id)
+	} else {
+		a.state.RejectRevision(id)
+	}
+	a.state.NextRevision(true)
+	a.pendingFollowCaret = true
+}
+
+func (a *App) goToRevision(forward bool) {
+	if a.state.NextRevision(forward) != 0 {
+		a.pendingFollowCaret = true
+	}
+}
+
+// revisionStatus describes the change at the caret for the status bar.
+func (a *App) revisionStatus() string {
+	r := a.revisionByID(a.currentRevision())
+	if r == nil {
+		return ""
+	}
+	what := map[sqdoc.RevisionKind]string{
+		sqdoc.RevisionInsert: "Inserted",
+		sqdoc.RevisionDelete: "Deleted",
+		sqdoc.RevisionFormat: "Formatted",
+	}[r.Kind]
+	if r.Author != "" {
+		what += " by " + r.Author
+	}
+	if r.CreatedUnix != 0 {
+		what += " " + time.Unix(r.CreatedUnix, 0).Format("Jan 2 15:04")
+	}
+	return what
+}
+
+func (a *App) layoutReviewMenuBounds() {
+	a.reviewMenuRect = rect{}
+	a.reviewMenuItems = a.reviewMenuItems[:0]
+	if !a.showReviewMenu {
+		return
+	}
+	add := func(label string, active, nav bool, apply func()) {
+		a.reviewMenuItems = append(a.reviewMenuItems, menuItem{label: label, active: active, nav: nav, apply: apply})
+	}
+	add("Suggest changes  Ctrl+Alt+E", a.state.Suggesting, true, a.toggleSuggesting)
+	add("Previous change", false, true, func() { a.goToRevision(false) })
+	add("Next change", false, true, func() { a.goToRevision(true) })
+	add("Accept change", false, false, func() { a.settleCurrentRevision(true) })
+	add("Reject change", false, false, func() { a.settleCurrentRevision(false) })
+	add("Accept all changes", false, false, func() { a.state.AcceptAllRevisions() })
+	add("Reject all changes", false, false, func() { a.state.RejectAllRevisions() })
+	a.reviewMenuRect = a.layoutMenuItems("review_menu", a.reviewMenuItems, 220)
+}
+
+func (a *App) drawReviewMenu(screen *ebiten.Image, face font.Face) {
+	if !a.showReviewMenu {
+		return
+	}
+	a.layoutReviewMenuBounds()
+	a.drawMenuItems(screen, face, a.reviewMenuRect, a.reviewMenuItems)
+}
+
+func (a *App) handleReviewMenuClick(x, y int) bool {
+	a.layoutReviewMenuBounds()
+	return a.clickMenuItems(x, y, "review_menu", a.reviewMenuRect, a.reviewMenuItems, &a.showReviewMenu)
+}
@@ -0,0 +1,164 @@
+package app
+
+import (
+	"image/color"
+
+	"sqdoc/pkg/sqdoc"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+	"github.com/hajimehoshi/ebiten/v2/text"
+	"golang.org/x/image/font"
+)
+
+// styleMenuItem is one row of the style picker: applying a style or, with
+// update set, redefining it from the text at the caret.
+type styleMenuItem struct {
+	label  string
+	name   string
+	style  uint32
+	kind   sqdoc.StyleKind
+	update bool
+	active bool
+	r      rect
+}
+
+func (a *App) toolbarActionRect(id string) (rect, bool) {
+	for _, btn := range a.toolbarActions {
+		if btn.id == id {
+			return btn.r, true
+		}
+	}
+	return rect{}, false
+}
+
+// currentStyleName is the label of the toolbar's style button.
+func (a *App) currentStyleName() string {
+	if st, ok := a.state.Styles().Lookup(a.state.ParagraphStyle()); ok {
+		return st.Name
+	}
+	return "Style"
+}
+
+func (a *App) layoutStyleMenuBounds() {
+	a.styleMenuRect = rect{}
+	a.styleMenuItems = a.styleMenuItems[:0]
+	if !a.showStyleMenu {
+		return
+	}
+	anchor, ok := a.toolbarActionRect("style_menu")
+	if !ok {
+		return
+	}
+	sheet := a.state.Styles()
+	para := a.state.ParagraphStyle()
+	char := a.state.CharacterStyle()
+	for _, kind := range []sqdoc.StyleKind{sqdoc.StyleKindParagraph, sqdoc.StyleKindCharacter} {
+		if kind == sqdoc.StyleKindCharacter {
+			a.styleMenuItems = append(a.styleMenuItems, styleMenuItem{label: "No character style", kind: kind, active: char == 0})
+		}
+		for _, st := range sheet.Styles {
+			if st.Kind != kind {
+				continue
+			}
+			active := st.ID == para
+			if kind == sqdoc.StyleKindCharacter {
+				active = st.ID == char
+			}
+			a.styleMenuItems = append(a.styleMenuItems, styleMenuItem{label: st.Name, name: st.Name, style: st.ID, kind: kind, active: active})
+		}
+	}
+	for _, id := range []uint32{para, char} {
+		if st, ok := sheet.Lookup(id); ok {
+			a.styleMenuItems = append(a.styleMenuItems, styleMenuItem{label: "Update " + st.Name + " to match", name: st.Name, style: id, kind: st.Kind, update: true})
+		}
+	}
+
+	w := int(230 * a.uiScales[a.uiScaleIdx])
+	if w < 210 {
+		w = 210
+	}
+	rowH := int(30 * a.uiScales[a.uiScaleIdx])
+	if rowH < 24 {
+		rowH = 24
+	}
+	x := anchor.x
+	y := anchor.y + anchor.h + 2
+	a.styleMenuRect = rect{x: x, y: y, w: w, h: rowH*len(a.styleMenuItems) + 8}
+	for i := range a.styleMenuItems {
+		a.styleMenuItems[i].r = rect{x: x + 4, y: y + 4 + rowH*i, w: w - 8, h: rowH}
+	}
+}
+
+func (a *App) drawStyleMenu(screen *ebiten.Image, face font.Face) {
+	if !a.showStyleMenu {
+		return
+	}
+	a.layoutStyleMenuBounds()
+	if a.styleMenuRect.w <= 0 {
+		return
+	}
+	r := a.styleMenuRect
+	border := color.RGBA{R: 172, G: 184, B: 202, A: 255}
+	a.drawFilledRectOnScreen(screen, r.x, r.y, r.w, r.h, color.RGBA{R: 249, G: 251, B: 254, A: 255})
+	ebitenutil.DrawLine(screen, float64(r.x), float64(r.y), float64(r.x+r.w), float64(r.y), border)
+	ebitenutil.DrawLine(screen, float64(r.x), float64(r.y+r.h), float64(r.x+r.w), float64(r.y+r.h), border)
+	ebitenutil.DrawLine(screen, float64(r.x), float64(r.y), float64(r.x), float64(r.y+r.h), border)
+	ebitenutil.DrawLine(screen, float64(r.x+r.w), float64(r.y), float64(r.x+r.w), float64(r.y+r.h), border)
+
+	mx, my := ebiten.CursorPosition()
+	for _, item := range a.styleMenuItems {
+		bg := color.RGBA{R: 241, G: 245, B: 251, A: 255}
+		if item.active {
+			bg = color.RGBA{R: 215, G: 229, B: 248, A: 255}
+		}
+		if item.r.contains(mx, my) {
+			bg = color.RGBA{R: 223, G: 236, B: 252, A: 255}
+		}
+		a.drawFilledRectOnScreen(screen, item.r.x, item.r.y, item.r.w, item.r.h, bg)
+		indent := 10
+		if item.kind == sqdoc.StyleKindCharacter && !item.update {
+			indent = 22
+		}
+		text.Draw(screen, item.label, face, item.r.x+indent, item.r.y+item.r.h-8, color.RGBA{R: 42, G: 58, B: 82, A: 255})
+	}
+}
+
+func (a *App) handleStyleMenuClick(x, y int) bool {
+	a.layoutStyleMenuBounds()
+	if a.styleMenuRect.w <= 0 {
+		return false
+	}
+	if !a.styleMenuRect.contains(x, y) {
+		if btn, ok := a.toolbarActionRect("style_menu"); ok && btn.contains(x, y) {
+			return false
+		}
+		a.showStyleMenu = false
+		return true
+	}
+	for _, item := range a.styleMenuItems {
+		if !item.r.contains(x, y) {
+			continue
+		}
+		a.showStyleMenu = false
+		if !a.beginEdit() {
+			return true
+		}
+		switch {
+		case item.update:
+			a.state.UpdateStyleFromSelection(item.style)
+			a.status = "Updated style " + item.name
+		case item.kind == sqdoc.StyleKindParagraph:
+			a.state.SetParagraphStyle(item.style)
+			a.status = "Paragraph style " + item.name
+		case item.style == 0:
+			a.state.SetCharacterStyle(0)
+			a.status = "Removed character style"
+		default:
+			a.state.SetCharacterStyle(item.style)
+			a.status = "Character style " + item.name
+		}
+		return true
+	}
+	return true
+}
@@ -0,0 +1,316 @@
+package app
+
+import (
+	"fmt"
+	"image/color"
+
+	"sqdoc/pkg/sqdoc"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"golang.org/x/image/font"
+)
+
+const (
+	// cellPaddingPt is the gap between a cell's border and its text.
+	cellPaddingPt = 4
+	// columnStepPt is how much the table menu widens or narrows a column.
+	columnStepPt = 12
+)
+
+var tableSizes = [][2]int{{2, 2}, {3, 3}, {4, 4}, {5, 3}}
+
+var cellShades = []struct {
+	label string
+	rgba  uint32
+}{
+	{"No shading", 0},
+	{"Grey shading", 0xECEFF3FF},
+	{"Blue shading", 0xDCE8F8FF},
+	{"Yellow shading", 0xFFF4C2FF},
+}
+
+// cellLayout is the box of one table cell, in document pixels and on
+// screen.
+type cellLayout struct {
+	block, row, col int
+	docX, docY      int
+	x, y            int
+	w, h            int
+	background      uint32
+	borders         sqdoc.Border
+	header          bool
+}
+
+// tableColumnWidths returns each column's width in pixels for a table width
+// pixels wide, following sqdoc.TableBlock.ColumnWidths.
+func (a *App) tableColumnWidths(t *sqdoc.TableBlock, width int) []int {
+	scale := float64(a.uiScales[a.uiScaleIdx])
+	out := make([]int, len(t.Columns))
+	for c, w := range t.ColumnWidths(float64(width) / scale) {
+		out[c] = int(w * scale)
+	}
+	return out
+}
+
+// layoutTable lays out table block bi from docY down and returns the y below
+// it, its right edge and its row groups for paged mode. Cell text always
+// wraps; a row is as tall as its tallest cell, and a cell spanning rows
+// grows the last of them.
+func (a *App) layoutTable(bi, docY, width int) (int, int, []flowBlock) {
+	t := a.state.Doc.Blocks[bi].Table
+	pad := max(2, int(cellPaddingPt*a.uiScales[a.uiScaleIdx]))
+	colX := []int{8}
+	for _, w := range a.tableColumnWidths(t, width) {
+		colX = append(colX, colX[len(colX)-1]+w)
+	}
+
+	type placed struct {
+		row, col int
+		lines    []lineLayout
+		height   int
+	}
+	var cells []placed
+	rowH := make([]int, len(t.Rows))
+	for r, row := range t.Rows {
+		for c, cell := range row {
+			if t.Covered(r, c) {
+				continue
+			}
+			cs, rs := cell.Span()
+			lines, bottom := a.layoutParagraph(paragraphLayout{
+				block: bi,
+				text:  cell.Text.UTF8,
+				runs:  a.state.CellRuns(bi, r, c),
+				para:  cell.Text.Para,
+				left:  colX[c] + pad,
+				width: colX[c+cs] - colX[c] - 2*pad,
+				wrap:  true,
+			}, pad)
+			for i := range lines {
+				lines[i].inCell, lines[i].row, lines[i].col = true, r, c
+			}
+			cells = append(cells, placed{row: r, col: c, lines: lines, height: bottom + pad})
+			if rs == 1 {
+				rowH[r] = max(rowH[r], bottom+pad)
+			}
+		}
+	}
+	for _, p := range cells {
+		_, rs := t.Rows[p.row][p.col].Span()
+		sum := 0
+		for r := p.row; r < p.row+rs; r++ {
+			sum += rowH[r]
+		}
+		if p.height > sum {
+			rowH[p.row+rs-1] += p.height - sum
+		}
+	}
+	rowY := []int{docY}
+	for _, h := range rowH {
+		rowY = append(rowY, rowY[len(rowY)-1]+h)
+	}
+
+	// Rows joined by a cell spanning them form a group that paged mode
+	// keeps on one page; it breaks tables between groups.
+	var groups []flowBlock
+	var firstRows []int
+	for start := 0; start < len(t.Rows); {
+		end := start + 1
+		for r := start; r < end; r++ {
+			for c := range t.Rows[r] {
+				if !t.Covered(r, c) {
+					_, rs := t.Rows[r][c].Span()
+					end = max(end, r+rs)
+				}
+			}
+		}
+		groups = append(groups, flowBlock{block: bi, top: rowY[start], bottom: rowY[end], whole: true})
+		firstRows = append(firstRows, start)
+		start = end
+	}
+	group := -1
+	for _, p := range cells {
+		for group+1 < len(groups) && p.row >= firstRows[group+1] {
+			group++
+			groups[group].lines, groups[group].cells, groups[group].codes, groups[group].rules = len(a.lineLayouts), len(a.cellLayouts), len(a.codeLayouts), len(a.noteRules)
+		}
+		cell := t.Rows[p.row][p.col]
+		cs, rs := cell.Span()
+		a.cellLayouts = append(a.cellLayouts, cellLayout{
+			block:      bi,
+			row:        p.row,
+			col:        p.col,
+			docX:       colX[p.col],
+			docY:       rowY[p.row],
+			w:          colX[p.col+cs] - colX[p.col],
+			h:          rowY[p.row+rs] - rowY[p.row],
+			background: cell.Background,
+			borders:    cell.Borders,
+			header:     p.row < int(t.HeaderRows),
+		})
+		for _, ll := range p.lines {
+			ll.docY += rowY[p.row]
+			a.lineLayouts = append(a.lineLayouts, ll)
+		}
+	}
+	return rowY[len(rowY)-1], colX[len(colX)-1], groups
+}
+
+// lineInActiveCell reports whether ll is text the caret can reach: of a
+// table's cells only the edited one maps caret positions to lines.
+func (a *App) lineInActiveCell(ll lineLayout) bool {
+	if !ll.inCell {
+		return true
+	}
+	row, col := a.state.ActiveCell(ll.block)
+	return ll.row == row && ll.col == col
+}
+
+// cellAt returns the cell in the table row under y nearest to x.
+func (a *App) cellAt(x, y int) (cellLayout, bool) {
+	var best cellLayout
+	bestDist := -1
+	for _, cl := range a.cellLayouts {
+		if y < cl.y || y >= cl.y+cl.h {
+			continue
+		}
+		dist := 0
+		if x < cl.x {
+			dist = cl.x - x
+		} else if x >= cl.x+cl.w {
+			dist = x - cl.x - cl.w + 1
+		}
+		if bestDist < 0 || dist < bestDist {
+			best, bestDist = cl, dist
+		}
+	}
+	return best, bestDist >= 0
+}
+
+// drawTableCells draws cell shading and borders under the selection and
+// document text.
+func (a *App) drawTableCells() {
+	border := color.RGBA{R: 120, G: 134, B: 156, A: 255}
+	for _, cl := range a.cellLayouts {
+		switch {
+		case cl.background != 0:
+			a.fillRectWithinContent(cl.x, cl.y, cl.w, cl.h, rgbaFromUint32(cl.background))
+		case cl.header:
+			a.fillRectWithinContent(cl.x, cl.y, cl.w, cl.h, color.RGBA{R: 234, G: 239, B: 247, A: 255})
+		}
+		if cl.borders&sqdoc.BorderTop != 0 {
+			a.fillRectWithinContent(cl.x, cl.y, cl.w+1, 1, border)
+		}
+		if cl.borders&sqdoc.BorderRight != 0 {
+			a.fillRectWithinContent(cl.x+cl.w, cl.y, 1, cl.h+1, border)
+		}
+		if cl.borders&sqdoc.BorderBottom != 0 {
+			a.fillRectWithinContent(cl.x, cl.y+cl.h, cl.w+1, 1, border)
+		}
+		if cl.borders&sqdoc.BorderLeft != 0 {
+			a.fillRectWithinContent(cl.x, cl.y, 1, cl.h+1, border)
+		}
+	}
+}
+
+// fillSelectedCells marks the tables inside a selection spanning several
+// blocks, which it takes whole.
+func (a *App) fillSelectedCells(first, last int, c color.RGBA) {
+	for _, cl := range a.cellLayouts {
+		if cl.block >= first && cl.block <= last {
+			a.fillRectWithinContent(cl.x+1, cl.y+1, cl.w-1, cl.h-1, c)
+		}
+	}
+}
+
+func (a *App) layoutTableMenuBounds() {
+	a.tableMenuRect = rect{}
+	a.tableMenuItems = a.tableMenuItems[:0]
+	if !a.showTableMenu {
+		return
+	}
+	add := func(label string, active bool, apply func()) {
+		a.tableMenuItems = append(a.tableMenuItems, menuItem{label: label, active: active, apply: apply})
+	}
+	t := a.state.Table()
+	if t == nil {
+		for _, size := range tableSizes {
+			rows, cols := size[0], size[1]
+			add(fmt.Sprintf("Insert %d × %d table", rows, cols), false, func() {
+				a.state.InsertTable(rows, cols)
+				a.showTableMenu = false
+			})
+		}
+		a.tableMenuRect = a.layoutMenuItems("table_menu", a.tableMenuItems, 200)
+		return
+	}
+	row, col := a.state.ActiveCell(a.state.CurrentBlock)
+	cell := t.Rows[row][col]
+	add("Insert row above", false, func() { a.state.InsertRow(false) })
+	add("Insert row below", false, func() { a.state.InsertRow(true) })
+	add("Insert column left", false, func() { a.state.InsertColumn(false) })
+	add("Insert column right", false, func() { a.state.InsertColumn(true) })
+	add("Delete row", false, a.state.DeleteRow)
+	add("Delete column", false, a.state.DeleteColumn)
+	add("Merge with cell right", false, func() { a.state.MergeCell(false) })
+	add("Merge with cell below", false, func() { a.state.MergeCell(true) })
+	if cs, rs := cell.Span(); cs > 1 || rs > 1 {
+		add("Split cell", false, a.state.SplitCell)
+	}
+	header := t.HeaderRows > 0
+	add("Header row", header, func() {
+		if header {
+			a.state.SetHeaderRows(0)
+		} else {
+			a.state.SetHeaderRows(1)
+		}
+	})
+	for _, shade := range cellShades {
+		rgba := shade.rgba
+		add(shade.label, cell.Background == rgba, func() { a.state.SetCellBackground(rgba) })
+	}
+	bordered := cell.Borders == sqdoc.BorderAll
+	add("Cell borders", bordered, func() {
+		if bordered {
+			a.state.SetCellBorders(0)
+		} else {
+			a.state.SetCellBorders(sqdoc.BorderAll)
+		}
+	})
+	widthPt := a.columnWidthPt(t, col)
+	add(fmt.Sprintf("Wider column (%dpt)", widthPt+columnStepPt), false, func() { a.state.AdjustColumnWidth(columnStepPt, widthPt) })
+	add(fmt.Sprintf("Narrower column (%dpt)", max(widthPt-columnStepPt, 0)), false, func() { a.state.AdjustColumnWidth(-columnStepPt, widthPt) })
+	add("Delete table", false, func() {
+		a.state.DeleteTable()
+		a.showTableMenu = false
+	})
+	a.tableMenuRect = a.layoutMenuItems("table_menu", a.tableMenuItems, 220)
+}
+
+// columnWidthPt returns the laid-out width of column col of t in points.
+func (a *App) columnWidthPt(t *sqdoc.TableBlock, col int) int {
+	if w := t.Columns[col]; w != 0 {
+		return int(w)
+	}
+	for _, cl := range a.cellLayouts {
+		if cl.block == a.state.CurrentBlock && cl.col == col {
+			if cs, _ := t.Rows[cl.row][cl.col].Span(); cs == 1 {
+				return int(float32(cl.w) / a.uiScales[a.uiScaleIdx])
+			}
+		}
+	}
+	return sqdoc.MinColumnWidth
+}
+
+func (a *App) drawTableMenu(screen *ebiten.Image, face font.Face) {
+	if !a.showTableMenu {
+		return
+	}
+	a.layoutTableMenuBounds()
+	a.drawMenuItems(screen, face, a.tableMenuRect, a.tableMenuItems)
+}
+
+func (a *App) handleTableMenuClick(x, y int) bool {
+	a.layoutTableMenuBounds()
+	return a.clickMenuItems(x, y, "table_menu", a.tableMenuRect, a.tableMenuItems, &a.showTableMenu)
+}
@@ -0,0 +1,299 @@
+package editor
+
+import (
+	"fmt"
+	"sort"
+
+	"sqdoc/pkg/sqdoc"
+)
+
+// shiftBookmarks keeps the bookmarks of block i in place as its bytes
+// start..end are replaced and the text grows by delta: those after the
+// range shift with the text, and those inside it go to its start.
+func (s *State) shiftBookmarks(i, start, end, delta int) {
+	id := s.Doc.Blocks[i].ID
+	for k := range s.Doc.Bookmarks {
+		bm := &s.Doc.Bookmarks[k]
+		off := int(bm.Offset)
+		if bm.BlockID != id || off <= start {
+			continue
+		}
+		if off >= end {
+			bm.Offset = uint32(off + delta)
+		} else {
+			bm.Offset = uint32(start)
+		}
+	}
+}
+
+// moveBookmarks moves the bookmarks of block from to block to, where byte
+// at of from lands at byte base. Bookmarks before at go to base.
+func (s *State) moveBookmarks(from, to, at, base int) {
+	fromID, toID := s.Doc.Blocks[from].ID, s.Doc.Blocks[to].ID
+	for k := range s.Doc.Bookmarks {
+		bm := &s.Doc.Bookmarks[k]
+		if bm.BlockID == fromID {
+			bm.BlockID = toID
+			bm.Offset = uint32(base + max(int(bm.Offset)-at, 0))
+		}
+	}
+}
+
+// bookmarksFrom maps the bookmarks that go with the text of block i from
+// pos on when it is split off to their offsets from pos: those after pos,
+// and one at pos unless that is the end of the text.
+func (s *State) bookmarksFrom(i, pos int) map[int]int {
+	id, textLen := s.Doc.Blocks[i].ID, len(s.text(i).UTF8)
+	out := map[int]int{}
+	for k, bm := range s.Doc.Bookmarks {
+		if bm.BlockID == id && (int(bm.Offset) > pos || int(bm.Offset) == pos && pos < textLen) {
+			out[k] = int(bm.Offset) - pos
+		}
+	}
+	return out
+}
+
+// clampBookmarks keeps bookmarks within the text of their blocks.
+func (s *State) clampBookmarks() {
+	if len(s.Doc.Bookmarks) == 0 {
+		return
+	}
+	lengths := map[uint64]int{}
+	for _, b := range s.Doc.Blocks {
+		if b.Text != nil {
+			lengths[b.ID] = len(b.Text.UTF8)
+		}
+	}
+	for k := range s.Doc.Bookmarks {
+		bm := &s.Doc.Bookmarks[k]
+		if n, ok := lengths[bm.BlockID]; ok && int(bm.Offset) > n {
+			bm.Offset = uint32(n)
+		}
+	}
+}
+
+// AddBookmark names the start of the selection, or the caret. A bookmark
+// of the same name moves there.
+func (s *State) AddBookmark(name string) error {
+	s.Normalize()
+	if !sqdoc.ValidBookmarkName(name) {
+		return fmt.Errorf("bookmark names use letters, digits, '-', '_' and '.'")
+	}
+	at, _, has := s.SelectionRange()
+	if !has {
+		at = s.caretPos()
+	}
+	if s.IsTable(at.Block) || s.IsNote(at.Block) {
+		return fmt.Errorf("bookmarks cannot go in tables or notes")
+	}
+	bm := sqdoc.Bookmark{Name: name, BlockID: s.Doc.Blocks[at.Block].ID, Offset: uint32(at.Byte)}
+	for k := range s.Doc.Bookmarks {
+		if s.Doc.Bookmarks[k].Name == name {
+			s.Doc.Bookmarks[k] = bm
+			return nil
+		}
+	}
+	s.Doc.Bookmarks = append(s.Doc.Bookmarks, bm)
+	return nil
+}
+
+// RemoveBookmark deletes the named bookmark, reporting whether there was
+// one.
+func (s *State) RemoveBookmark(name string) bool {
+	for k, bm := range s.Doc.Bookmarks {
+		if bm.Name == name {
+			s.Doc.Bookmarks = append(s.Doc.Bookmarks[:k], s.Doc.Bookmarks[k+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// BookmarkAtCaret returns the name of a bookmark at the caret, or "".
+func (s *State) BookmarkAtCaret() string {
+	s.Normalize()
+	if s.IsTable(s.CurrentBlock) {
+		return ""
+	}
+	id := s.Doc.Blocks[s.CurrentBlock].ID
+	for _, bm := range s.Doc.Bookmarks {
+		if bm.BlockID == id && int(bm.Offset) == s.CaretByte {
+			return bm.Name
+		}
+	}
+	return ""
+}
+
+// NamedAnchor is a bookmark with the place it names.
+type NamedAnchor struct {
+	Name string
+	sqdoc.Anchor
+}
+
+// Bookmarks lists the bookmarks in document order.
+func (s *State) Bookmarks() []NamedAnchor {
+	s.Normalize()
+	anchors := s.Doc.Anchors()
+	var out []NamedAnchor
+	for _, bm := range s.Doc.Bookmarks {
+		if at, ok := anchors[bm.Name]; ok {
+			out = append(out, NamedAnchor{Name: bm.Name, Anchor: at})
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		if out[i].Block != out[j].Block {
+			return out[i].Block < out[j].Block
+		}
+		return out[i].Offset < out[j].Offset
+	})
+	return out
+}
+
+// RefAt returns the cross-reference on the character at pos of block i
+// with the byte range of the whole field, or a zero CrossRef when there is
+// none.
+func (s *State) RefAt(i, pos int) (sqdoc.CrossRef, int, int) {
+	return spanAt(s, i, pos, func(attr sqdoc.StyleAttr) sqdoc.CrossRef { return attr.Ref })
+}
+
+// InsertCrossRef replaces the selection, or inserts at the caret, a field
+// showing target as show asks. It fails for a target the document does not
+// have.
+func (s *State) InsertCrossRef(target string, show sqdoc.RefShow) error {
+	s.Normalize()
+	ref := sqdoc.CrossRef{Target: target, Show: show}
+	text, ok := s.Doc.RefResolver()(ref)
+	if !ok {
+		return fmt.Errorf("no heading or bookmark named %q", target)
+	}
+	if text == "" {
+		text = target
+	}
+	if s.HasSelection() {
+		s.DeleteSelection()
+		s.Normalize()
+	}
+	if s.IsCode(s.CurrentBlock) {
+		return fmt.Errorf("cross-references cannot go in code blocks")
+	}
+	attr := s.currentStyleAttr()
+	attr.Link = ""
+	attr.Ref = ref
+	pos := s.CaretByte
+	s.replaceRangeInBlock(s.CurrentBlock, pos, pos, []byte(text), attr)
+	s.CaretByte = pos + len(text)
+	return nil
+}
+
+// UpdateCrossRefs sets the text of every cross-reference to what its target
+// shows now, keeping the caret and selection on the same text. Fields whose
+// target is gone keep their last text. It reports whether any changed.
+func (s *State) UpdateCrossRefs() bool {
+	s.Normalize()
+	var resolve func(sqdoc.CrossRef) (string, bool)
+	return s.updateFields(func(attr sqdoc.StyleAttr) (string, bool) {
+		if attr.Ref.Target == "" {
+			return "", false
+		}
+		if resolve == nil {
+			resolve = s.Doc.RefResolver()
+		}
+		return resolve(attr.Ref)
+	})
+}
+
+// updateFields sets the text of the field runs of every text block and
+// table cell to what value gives for them. value reports false for runs it
+// leaves alone.
+func (s *State) updateFields(value func(sqdoc.StyleAttr) (string, bool)) bool {
+	changed := false
+	for i := range s.Doc.Blocks {
+		b := &s.Doc.Blocks[i]
+		if b.Kind != sqdoc.BlockKindTable {
+			if b.Text != nil && hasFields(b.Text) {
+				changed = s.updateBlockFields(i, true, value) || changed
+			}
+			continue
+		}
+		if b.Table == nil {
+			continue
+		}
+		// replaceRangeInBlock edits the cell the caret is in, so point it at
+		// each cell in turn.
+		if s.cells == nil {
+			s.cells = map[uint64]cellPos{}
+		}
+		active := s.cells[b.ID]
+		for r, row := range b.Table.Rows {
+			for c := range row {
+				if row[c].Text == nil || !hasFields(row[c].Text) {
+					continue
+				}
+				pos := cellPos{row: r, col: c}
+				s.cells[b.ID] = pos
+				changed = s.updateBlockFields(i, pos == active, value) || changed
+			}
+		}
+		s.cells[b.ID] = active
+	}
+	return changed
+}
+
+func hasFields(tb *sqdoc.TextBlock) bool {
+	for _, r := range tb.Runs {
+		if r.Attr.Ref.Target != "" || r.Attr.Note != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// fieldKey tells apart the fields of neighbouring runs.
+type fieldKey struct {
+	ref  sqdoc.CrossRef
+	note uint64
+}
+
+// updateBlockFields refreshes the fields of block i, last first so earlier
+// offsets stay valid. The caret and selection follow when track is set.
+func (s *State) updateBlockFields(i int, track bool, value func(sqdoc.StyleAttr) (string, bool)) bool {
+	tb := s.text(i)
+	runs := coverageRuns(len(tb.UTF8), tb.Runs)
+	key := func(attr sqdoc.StyleAttr) fieldKey { return fieldKey{ref: attr.Ref, note: attr.Note} }
+	changed := false
+	for n := len(runs) - 1; n >= 0; n-- {
+		k := key(runs[n].Attr)
+		if k == (fieldKey{}) {
+			continue
+		}
+		start, end := int(runs[n].Start), int(runs[n].End)
+		for n > 0 && key(runs[n-1].Attr) == k && int(runs[n-1].End) == start {
+			n--
+			start = int(runs[n].Start)
+		}
+		text, ok := value(runs[n].Attr)
+		if !ok || text == "" || text == string(tb.UTF8[start:end]) {
+			continue
+		}
+		attr := runs[n].Attr
+		s.replaceRangeInBlock(i, start, end, []byte(text), attr)
+		tb = s.text(i)
+		changed = true
+		delta := len(text) - (end - start)
+		fix := func(p *Position) {
+			if !track || p.Block != i || p.Byte <= start {
+				return
+			}
+			if p.Byte >= end {
+				p.Byte += delta
+			} else {
+				p.Byte = start + len(text)
+			}
+		}
+		caret := s.caretPos()
+		fix(&caret)
+		s.CaretByte = caret.Byte
+		fix(&s.selectionAnchor)
+	}
+	return changed
+}
@@ -0,0 +1,108 @@
+package editor
+
+import (
+	"testing"
+
+	"sqdoc/pkg/sqdoc"
+)
+
+func bookmarkAt(t *testing.T, s *State, name string) sqdoc.Anchor {
+	t.Helper()
+	at, ok := s.Doc.Anchors()[name]
+	if !ok {
+		t.Fatalf("bookmark %q is gone", name)
+	}
+	return at
+}
+
+func TestBookmarksFollowEdits(t *testing.T) {
+	s := searchState(t, "alpha beta gamma\ndelta")
+	s.SetCaret(0, 11)
+	if err := s.AddBookmark("g"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddBookmark("bad name"); err == nil {
+		t.Fatal("AddBookmark accepted a space")
+	}
+	if s.BookmarkAtCaret() != "g" {
+		t.Fatalf("BookmarkAtCaret() = %q", s.BookmarkAtCaret())
+	}
+
+	s.SetCaret(0, 0)
+	_ = s.InsertTextAtCaret(">> ")
+	if at := bookmarkAt(t, s, "g"); at != (sqdoc.Anchor{Block: 0, Offset: 14}) {
+		t.Fatalf("after typing before it: %v", at)
+	}
+	s.SetCaret(0, 20)
+	_ = s.InsertTextAtCaret("!")
+	if at := bookmarkAt(t, s, "g"); at.Offset != 14 {
+		t.Fatalf("typing after it moved it to %v", at)
+	}
+
+	s.SetCaret(0, 9)
+	s.SplitBlockAtCaret()
+	if at := bookmarkAt(t, s, "g"); at != (sqdoc.Anchor{Block: 1, Offset: 5}) {
+		t.Fatalf("after a split: %v", at)
+	}
+	s.Backspace()
+	if at := bookmarkAt(t, s, "g"); at != (sqdoc.Anchor{Block: 0, Offset: 14}) {
+		t.Fatalf("after a merge: %v", at)
+	}
+
+	s.SelectRange(Position{Block: 0, Byte: 12}, Position{Block: 1, Byte: 2})
+	s.DeleteSelection()
+	if at := bookmarkAt(t, s, "g"); at != (sqdoc.Anchor{Block: 0, Offset: 12}) {
+		t.Fatalf("after deleting around it: %v", at)
+	}
+
+	s.SetCaret(0, 0)
+	if !s.FollowAnchor("g") || s.CurrentBlock != 0 || s.CaretByte != 12 {
+		t.Fatalf("FollowAnchor moved to %d:%d", s.CurrentBlock, s.CaretByte)
+	}
+	if !s.RemoveBookmark("g") || len(s.Bookmarks()) != 0 {
+		t.Fatal("RemoveBookmark left the bookmark")
+	}
+}
+
+func TestCrossRefsUpdate(t *testing.T) {
+	s := searchState(t, "Intro\nSetup\nSee  now")
+	for _, i := range []int{0, 1} {
+		s.SetCaret(i, 0)
+		s.SetParagraphStyle(sqdoc.StyleHeading1)
+	}
+	s.SetCaret(1, 0)
+	_ = s.AddBookmark("setup")
+
+	s.SetCaret(2, 4)
+	if err := s.InsertCrossRef("setup", sqdoc.RefShowNumber); err != nil {
+		t.Fatal(err)
+	}
+	_ = s.InsertTextAtCaret(" ")
+	if err := s.InsertCrossRef("setup", sqdoc.RefShowText); err != nil {
+		t.Fatal(err)
+	}
+	if s.CurrentText() != "See 2 Setup now" {
+		t.Fatalf("inserted fields: %q", s.CurrentText())
+	}
+	if err := s.InsertCrossRef("missing", sqdoc.RefShowText); err == nil {
+		t.Fatal("InsertCrossRef accepted a missing target")
+	}
+
+	s.SetCaret(1, 0)
+	_ = s.InsertTextAtCaret("First ")
+	s.SetCaret(0, 0)
+	s.SplitBlockAtCaret()
+	s.SetCaret(3, 14)
+	if !s.UpdateCrossRefs() {
+		t.Fatal("UpdateCrossRefs changed nothing")
+	}
+	if s.CurrentText() != "See 3 First Setup now" || s.CaretByte != 20 {
+		t.Fatalf("updated fields: %q, caret %d", s.CurrentText(), s.CaretByte)
+	}
+	if ref, start, end := s.RefAt(3, 7); ref.Target != "setup" || start != 6 || end != 17 {
+		t.Fatalf("RefAt = %v %d..%d", ref, start, end)
+	}
+	if s.UpdateCrossRefs() {
+		t.Fatal("UpdateCrossRefs changed fields already up to date")
+	}
+}
@@ -0,0 +1,323 @@
+package editor
+
+import (
+	"bytes"
+	"strings"
+	"unicode/utf8"
+
+	"sqdoc/pkg/sqdoc"
+)
+
+// codeTabWidth is how many columns Tab indents code by.
+const codeTabWidth = 4
+
+// IsCode reports whether block i is a code block.
+func (s *State) IsCode(i int) bool {
+	return s.Doc != nil && i >= 0 && i < len(s.Doc.Blocks) && s.Doc.Blocks[i].Text != nil && s.Doc.Blocks[i].Text.Code != nil
+}
+
+// CodeBlock returns the code block properties of the block at the caret,
+// or nil outside a code block.
+func (s *State) CodeBlock() *sqdoc.CodeBlock {
+	s.Normalize()
+	if s.IsTable(s.CurrentBlock) {
+		return nil
+	}
+	return s.text(s.CurrentBlock).Code
+}
+
+// SelectionInCode reports whether the selection, or the caret when nothing
+// is selected, lies within one code block.
+func (s *State) SelectionInCode() bool {
+	if start, end, has := s.SelectionRange(); has {
+		return start.Block == end.Block && s.IsCode(start.Block)
+	}
+	return s.IsCode(s.CurrentBlock)
+}
+
+// ToggleCodeBlock joins the selected paragraphs into one code block in the
+// given language, one line each, or turns the code block at the caret back
+// into paragraphs. Code is never formatted, so the text's runs are reset
+// either way. Selections that include a table or note are left alone, as
+// is everything while suggesting.
+func (s *State) ToggleCodeBlock(language string) {
+	s.Normalize()
+	if s.suggestingParagraphs() {
+		return
+	}
+	if s.IsCode(s.CurrentBlock) {
+		s.splitCode(s.CurrentBlock)
+		return
+	}
+	first, last := s.selectedParagraphs()
+	for i := first; i <= last; i++ {
+		if s.IsTable(i) || s.IsNote(i) {
+			return
+		}
+	}
+	var joined []byte
+	caret := 0
+	for i := first; i <= last; i++ {
+		if i > first {
+			joined = append(joined, '\n')
+			s.moveBookmarks(i, first, 0, len(joined))
+			s.moveComments(i, first, 0, len(joined))
+		}
+		if i == s.CurrentBlock {
+			caret = len(joined) + s.CaretByte
+		}
+		joined = append(joined, s.text(i).UTF8...)
+	}
+	tb := s.text(first)
+	*tb = sqdoc.TextBlock{
+		UTF8: joined,
+		Runs: []sqdoc.StyleRun{{Start: 0, End: uint32(len(joined)), Attr: codeStyleAttr()}},
+		Code: &sqdoc.CodeBlock{Language: language},
+	}
+	s.Doc.Blocks = append(s.Doc.Blocks[:first+1], s.Doc.Blocks[last+1:]...)
+	s.ClearSelection()
+	s.CurrentBlock, s.CaretByte = first, caret
+}
+
+// splitCode turns code block i into one paragraph per line.
+func (s *State) splitCode(i int) {
+	lines := bytes.Split(s.text(i).UTF8, []byte("\n"))
+	caret := s.CaretByte
+	blocks := make([]sqdoc.Block, len(lines))
+	current := i
+	nextID := s.nextBlockID()
+	for n, line := range lines {
+		id := s.Doc.Blocks[i].ID
+		if n > 0 {
+			id, nextID = nextID, nextID+1
+		}
+		blocks[n] = sqdoc.Block{ID: id, Kind: sqdoc.BlockKindText, Text: &sqdoc.TextBlock{
+			UTF8: line,
+			Runs: []sqdoc.StyleRun{{Start: 0, End: uint32(len(line)), Attr: defaultStyleAttr()}},
+		}}
+		if caret >= 0 {
+			current = i + n
+			s.CaretByte = caret
+		}
+		caret -= len(line) + 1
+	}
+	for k := range s.Doc.Bookmarks {
+		bm := &s.Doc.Bookmarks[k]
+		if bm.BlockID != s.Doc.Blocks[i].ID {
+			continue
+		}
+		for n, line := range lines {
+			if int(bm.Offset) <= len(line) || n == len(lines)-1 {
+				bm.BlockID = blocks[n].ID
+				break
+			}
+			bm.Offset -= uint32(len(line) + 1)
+		}
+	}
+	// A comment goes to the line its range starts on, ending with it.
+	for k := range s.Doc.Comments {
+		t := &s.Doc.Comments[k]
+		if t.BlockID != s.Doc.Blocks[i].ID {
+			continue
+		}
+		at := 0
+		for n, line := range lines {
+			if int(t.Start) < at+len(line) || n == len(lines)-1 {
+				t.BlockID = blocks[n].ID
+				t.Start = uint32(max(int(t.Start)-at, 0))
+				t.End = uint32(max(min(int(t.End)-at, len(line)), int(t.Start)))
+				break
+			}
+			at += len(line) + 1
+		}
+	}
+	s.Doc.Blocks = append(s.Doc.Blocks[:i], append(blocks, s.Doc.Blocks[i+1:]...)...)
+	s.ClearSelection()
+	s.CurrentBlock = current
+}
+
+// MoveCaretLine moves the caret delta lines up or down within text that
+// keeps line breaks, staying in the same column where the line is long
+// enough. It reports false, leaving the caret, when there is no such line
+// in the block.
+func (s *State) MoveCaretLine(delta int) bool {
+	s.Normalize()
+	text := s.CurrentBlockText()
+	at := lineStart(text, s.CaretByte)
+	col := utf8.RuneCount(text[at:s.CaretByte])
+	for ; delta < 0; delta++ {
+		if at == 0 {
+			return false
+		}
+		at = lineStart(text, at-1)
+	}
+	for ; delta > 0; delta-- {
+		n := bytes.IndexByte(text[at:], '\n')
+		if n < 0 {
+			return false
+		}
+		at += n + 1
+	}
+	pos := at
+	for ; col > 0 && pos < len(text) && text[pos] != '\n'; col-- {
+		pos = nextRuneBoundary(text, pos)
+	}
+	s.CaretByte = pos
+	return true
+}
+
+// SetCodeLanguage changes the language of the code block at the caret.
+func (s *State) SetCodeLanguage(language string) {
+	if code := s.CodeBlock(); code != nil {
+		code.Language = language
+	}
+}
+
+// SetCodeLineNumbers shows or hides the line numbers of the code block at
+// the caret.
+func (s *State) SetCodeLineNumbers(on bool) {
+	if code := s.CodeBlock(); code != nil {
+		code.LineNumbers = on
+	}
+}
+
+// IndentCode handles Tab and Shift+Tab in a code block. Tab with nothing
+// selected inserts spaces up to the next tab stop; otherwise the lines the
+// selection touches are indented, or outdented by up to one tab stop. It
+// reports false, changing nothing, outside a code block.
+func (s *State) IndentCode(outdent bool) bool {
+	s.Normalize()
+	if !s.IsCode(s.CurrentBlock) {
+		return false
+	}
+	text := s.CurrentBlockText()
+	start, end, has := s.SelectionRange()
+	if has && (start.Block != s.CurrentBlock || end.Block != s.CurrentBlock) {
+		return true
+	}
+	if !has && !outdent {
+		col := s.CaretByte - lineStart(text, s.CaretByte)
+		_ = s.InsertTextAtCaret(strings.Repeat(" ", codeTabWidth-col%codeTabWidth))
+		return true
+	}
+	if !has {
+		start, end = s.caretPos(), s.caretPos()
+	}
+	var starts []int
+	for at := lineStart(text, start.Byte); ; {
+		starts = append(starts, at)
+		next := bytes.IndexByte(text[at:], '\n')
+		if next < 0 || at+next+1 > end.Byte || (at+next+1 == end.Byte && end.Byte > start.Byte) {
+			break
+		}
+		at += next + 1
+	}
+
+	anchor, caret := s.selectionAnchor.Byte, s.CaretByte
+	attr := codeStyleAttr()
+	// Later lines first, so the earlier line starts stay put.
+	for n := len(starts) - 1; n >= 0; n-- {
+		at := starts[n]
+		if !outdent {
+			s.replaceRangeInBlock(s.CurrentBlock, at, at, []byte(strings.Repeat(" ", codeTabWidth)), attr)
+			anchor, caret = shiftPast(anchor, at, codeTabWidth), shiftPast(caret, at, codeTabWidth)
+			continue
+		}
+		line := s.CurrentBlockText()[at:]
+		cut := 0
+		for cut < codeTabWidth && cut < len(line) && line[cut] == ' ' {
+			cut++
+		}
+		if cut == 0 && len(line) > 0 && line[0] == '\t' {
+			cut = 1
+		}
+		s.replaceRangeInBlock(s.CurrentBlock, at, at+cut, nil, attr)
+		anchor, caret = shiftPast(anchor, at, -cut), shiftPast(caret, at, -cut)
+	}
+	s.CaretByte = caret
+	if has {
+		s.selectionAnchor.Byte = anchor
+	}
+	s.Normalize()
+	return true
+}
+
+// splitCodeLine is Enter in a code block: a new line indented like the
+// caret's. Enter on an empty last line leaves the block for a new
+// paragraph instead, unless suggesting.
+func (s *State) splitCodeLine() {
+	text := s.CurrentBlockText()
+	pos := s.CaretByte
+	if pos == len(text) && pos > 0 && text[pos-1] == '\n' && !s.suggesting(s.CurrentBlock) {
+		s.replaceRangeInBlock(s.CurrentBlock, pos-1, pos, nil, codeStyleAttr())
+		attr := defaultStyleAttr()
+		block := sqdoc.Block{ID: s.nextBlockID(), Kind: sqdoc.BlockKindText, Text: &sqdoc.TextBlock{Runs: []sqdoc.StyleRun{{Attr: attr}}}}
+		at := s.CurrentBlock + 1
+		s.Doc.Blocks = append(s.Doc.Blocks[:at], append([]sqdoc.Block{block}, s.Doc.Blocks[at:]...)...)
+		s.CurrentBlock, s.CaretByte = at, 0
+		return
+	}
+	line := text[lineStart(text, pos):pos]
+	indent := line[:len(line)-len(bytes.TrimLeft(line, " \t"))]
+	_ = s.InsertTextAtCaret("\n" + string(indent))
+}
+
+// codeFragments prepares pasted blocks for a paragraph that is not code: a
+// code block becomes a paragraph per line, unless it is all there is and
+// the paragraph is empty, which then takes it over.
+func (s *State) codeFragments(frags []*sqdoc.TextBlock) []*sqdoc.TextBlock {
+	if len(frags) == 1 && frags[0].Code != nil && len(s.CurrentBlockText()) == 0 && !s.IsTable(s.CurrentBlock) && !s.IsNote(s.CurrentBlock) {
+		s.text(s.CurrentBlock).Code = cloneCode(frags[0].Code)
+		s.text(s.CurrentBlock).List = sqdoc.ListItem{}
+		return frags
+	}
+	var out []*sqdoc.TextBlock
+	for _, tb := range frags {
+		if tb.Code == nil {
+			out = append(out, tb)
+			continue
+		}
+		from := 0
+		for _, line := range bytes.Split(tb.UTF8, []byte("\n")) {
+			runs := []sqdoc.StyleRun{}
+			for _, r := range sanitizeRuns(len(tb.UTF8), tb.Runs) {
+				rs, re := max(int(r.Start), from), min(int(r.End), from+len(line))
+				if rs < re {
+					runs = append(runs, sqdoc.StyleRun{Start: uint32(rs - from), End: uint32(re - from), Attr: r.Attr})
+				}
+			}
+			out = append(out, &sqdoc.TextBlock{UTF8: line, Runs: runs})
+			from += len(line) + 1
+		}
+	}
+	return out
+}
+
+// codeStyleAttr is the formatting of code block text.
+func codeStyleAttr() sqdoc.StyleAttr {
+	attr := defaultStyleAttr()
+	attr.FontFamily = sqdoc.FontFamilyMonospace
+	return attr
+}
+
+// lineStart returns the offset of the line of text that pos is on.
+func lineStart(text []byte, pos int) int {
+	return bytes.LastIndexByte(text[:pos], '\n') + 1
+}
+
+// shiftPast moves offset pos by delta when it lies after an edit at at. A
+// removal never moves it before at.
+func shiftPast(pos, at, delta int) int {
+	if pos < at {
+		return pos
+	}
+	return max(at, pos+delta)
+}
+
+func cloneCode(c *sqdoc.CodeBlock) *sqdoc.CodeBlock {
+	if c == nil {
+		return nil
+	}
+	out := *c
+	return &out
+}
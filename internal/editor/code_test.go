@@ -0,0 +1,147 @@
+package editor
+
+import (
+	"testing"
+
+	"sqdoc/pkg/sqdoc"
+)
+
+func TestToggleCodeBlock(t *testing.T) {
+	s := searchState(t, "intro\nif x {\ny()\n}")
+	s.SetParagraphStyle(sqdoc.StyleHeading2)
+	s.SelectRange(Position{Block: 1, Byte: 0}, Position{Block: 3, Byte: 1})
+	s.ToggleCodeBlock("go")
+	if s.BlockCount() != 2 || !s.IsCode(1) || s.IsCode(0) {
+		t.Fatalf("blocks = %q", s.AllBlockTexts())
+	}
+	if s.CurrentText() != "if x {\ny()\n}" || s.CaretByte != len("if x {\ny()\n}") || s.CodeBlock().Language != "go" {
+		t.Fatalf("code %q, caret %d", s.CurrentText(), s.CaretByte)
+	}
+	if attr := s.CurrentStyleAttr(); attr.FontFamily != sqdoc.FontFamilyMonospace || s.ParagraphStyle() == sqdoc.StyleHeading2 {
+		t.Fatalf("code block formatting: %+v, style %d", attr, s.ParagraphStyle())
+	}
+
+	s.SetCaret(1, len("if x {"))
+	_ = s.InsertTextAtCaret("\n\tz()")
+	s.SplitBlockAtCaret()
+	if got := s.CurrentText(); got != "if x {\n\tz()\n\t\ny()\n}" || s.BlockCount() != 2 {
+		t.Fatalf("Enter in code gave %q and %d blocks", got, s.BlockCount())
+	}
+
+	s.SetCaret(1, len(s.CurrentText()))
+	s.SplitBlockAtCaret()
+	s.SplitBlockAtCaret()
+	if s.BlockCount() != 3 || s.CurrentBlock != 2 || s.IsCode(2) || s.text(1).UTF8[len(s.text(1).UTF8)-1] != '}' {
+		t.Fatalf("Enter on an empty last line: %q at block %d", s.AllBlockTexts(), s.CurrentBlock)
+	}
+
+	s.SetCaret(1, len("if x {\n\tz"))
+	s.ToggleCodeBlock("")
+	if s.BlockCount() != 7 || s.IsCode(2) || s.CurrentBlock != 2 || s.CaretByte != 2 {
+		t.Fatalf("splitting code gave %q, caret %d:%d", s.AllBlockTexts(), s.CurrentBlock, s.CaretByte)
+	}
+	if errs := sqdoc.ValidateAll(s.Doc); len(errs) != 0 {
+		t.Fatalf("ValidateAll() = %v", errs)
+	}
+}
+
+func TestIndentCode(t *testing.T) {
+	s := searchState(t, "ab")
+	if s.IndentCode(false) {
+		t.Fatal("IndentCode worked outside code")
+	}
+	s.ToggleCodeBlock("python")
+	_ = s.UpdateCurrentText("ab\ncd\nef")
+	s.SetCaret(0, 1)
+	s.IndentCode(false)
+	if s.CurrentText() != "a   b\ncd\nef" || s.CaretByte != 4 {
+		t.Fatalf("Tab gave %q, caret %d", s.CurrentText(), s.CaretByte)
+	}
+
+	s.SelectRange(Position{Block: 0, Byte: 2}, Position{Block: 0, Byte: len("a   b\ncd\n")})
+	s.IndentCode(false)
+	if s.CurrentText() != "    a   b\n    cd\nef" {
+		t.Fatalf("indenting lines gave %q", s.CurrentText())
+	}
+	if got := s.SelectedText(); got != "  b\n    cd\n" {
+		t.Fatalf("selection after indent = %q", got)
+	}
+	s.IndentCode(true)
+	s.IndentCode(true)
+	if s.CurrentText() != "a   b\ncd\nef" || s.SelectedText() != "  b\ncd\n" {
+		t.Fatalf("outdent gave %q with %q selected", s.CurrentText(), s.SelectedText())
+	}
+}
+
+func TestCodeBlockEdges(t *testing.T) {
+	s := searchState(t, "x\ncode\ny")
+	s.SetCaret(1, 0)
+	s.ToggleCodeBlock("sh")
+
+	s.SetCaret(0, 1)
+	s.DeleteForward()
+	if s.BlockCount() != 3 || s.text(0).Code != nil {
+		t.Fatalf("Delete joined a paragraph with code: %q", s.AllBlockTexts())
+	}
+	s.SetCaret(2, 0)
+	s.Backspace()
+	if s.BlockCount() != 2 || !s.IsCode(1) || s.CurrentText() != "codey" {
+		t.Fatalf("Backspace after code gave %q", s.AllBlockTexts())
+	}
+
+	s.SelectRange(Position{Block: 0, Byte: 0}, Position{Block: 1, Byte: 2})
+	s.DeleteSelection()
+	if s.BlockCount() != 2 || !s.IsCode(1) || string(s.text(1).UTF8) != "dey" {
+		t.Fatalf("deleting into code gave %q", s.AllBlockTexts())
+	}
+
+	s.SetCaret(1, 3)
+	blocks := []sqdoc.Block{
+		{Kind: sqdoc.BlockKindText, Text: &sqdoc.TextBlock{UTF8: []byte("a"), Runs: []sqdoc.StyleRun{{Start: 0, End: 1, Attr: sqdoc.StyleAttr{Bold: true}}}}},
+		{Kind: sqdoc.BlockKindText, Text: &sqdoc.TextBlock{UTF8: []byte("b")}},
+	}
+	_ = s.InsertBlocksAtCaret(blocks)
+	if s.CurrentText() != "deya\nb" || s.CurrentStyleAttr().Bold {
+		t.Fatalf("paste into code gave %q", s.CurrentText())
+	}
+
+	s.SelectRange(Position{Block: 1, Byte: 0}, Position{Block: 1, Byte: 4})
+	if !s.SelectionInCode() || s.SelectedText() != "deya" {
+		t.Fatalf("copy from code = %q", s.SelectedText())
+	}
+	copied := s.SelectedBlocks()
+	s.SetCaret(0, 0)
+	_ = s.InsertBlocksAtCaret(copied)
+	if !s.IsCode(0) || s.CurrentText() != "deya" {
+		t.Fatalf("pasting code into an empty paragraph gave %q", s.AllBlockTexts())
+	}
+	s.SetCaret(0, 1)
+	_ = s.InsertBlocksAtCaret(copied)
+	if s.CurrentText() != "ddeyaeya" {
+		t.Fatalf("pasting code into code gave %q", s.CurrentText())
+	}
+}
+
+func TestMoveCaretByCodeLine(t *testing.T) {
+	s := searchState(t, "")
+	s.ToggleCodeBlock("")
+	_ = s.InsertTextAtCaret("first\nab\nthird")
+	s.SetCaret(0, len("first\nab\nthi"))
+	if !s.MoveCaretLine(-1) || s.CaretByte != len("first\nab") {
+		t.Fatalf("up to a short line: caret %d", s.CaretByte)
+	}
+	s.MoveCaretToLineStart()
+	if s.CaretByte != len("first\n") {
+		t.Fatalf("Home went to %d", s.CaretByte)
+	}
+	s.MoveCaretToLineEnd()
+	if s.CaretByte != len("first\nab") {
+		t.Fatalf("End went to %d", s.CaretByte)
+	}
+	if !s.MoveCaretLine(-1) || s.CaretByte != 2 || s.MoveCaretLine(-1) {
+		t.Fatalf("up to the first line: caret %d", s.CaretByte)
+	}
+	if !s.MoveCaretLine(2) || s.CaretByte != len("first\nab\nth") || s.MoveCaretLine(1) {
+		t.Fatalf("down two lines: caret %d", s.CaretByte)
+	}
+}
@@ -0,0 +1,292 @@
+package editor
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"sqdoc/pkg/sqdoc"
+)
+
+// CommentRef is a comment thread with the index of the block it is on.
+type CommentRef struct {
+	sqdoc.CommentThread
+	Block int
+}
+
+// shiftOffset moves one end of a comment's range as bytes start..end of its
+// block are replaced and the text grows by delta. Text inserted at either
+// end of a range stays outside it.
+func shiftOffset(off uint32, start, end, delta int, isEnd bool) uint32 {
+	o := int(off)
+	switch {
+	case o < start || isEnd && o == start:
+		return off
+	case o >= end:
+		return uint32(o + delta)
+	default:
+		return uint32(start)
+	}
+}
+
+// shiftComments keeps the comment ranges of block i on their text as its
+// bytes start..end are replaced and the text grows by delta.
+func (s *State) shiftComments(i, start, end, delta int) {
+	id := s.Doc.Blocks[i].ID
+	for k := range s.Doc.Comments {
+		t := &s.Doc.Comments[k]
+		if t.BlockID != id {
+			continue
+		}
+		t.Start = shiftOffset(t.Start, start, end, delta, false)
+		t.End = max32(shiftOffset(t.End, start, end, delta, true), t.Start)
+	}
+}
+
+// moveComments moves the comment ranges of block from to block to, where
+// byte at of from lands at byte base, as moveBookmarks does.
+func (s *State) moveComments(from, to, at, base int) {
+	fromID, toID := s.Doc.Blocks[from].ID, s.Doc.Blocks[to].ID
+	for k := range s.Doc.Comments {
+		t := &s.Doc.Comments[k]
+		if t.BlockID == fromID {
+			t.BlockID = toID
+			t.Start = uint32(base + max(int(t.Start)-at, 0))
+			t.End = uint32(base + max(int(t.End)-at, 0))
+		}
+	}
+}
+
+// commentsFrom prepares block i to be split at pos. Ranges that start at
+// or after pos go with the text after it and are returned with their
+// offsets from pos; ranges across pos end there.
+func (s *State) commentsFrom(i, pos int) map[int][2]int {
+	id, textLen := s.Doc.Blocks[i].ID, len(s.text(i).UTF8)
+	out := map[int][2]int{}
+	for k := range s.Doc.Comments {
+		t := &s.Doc.Comments[k]
+		if t.BlockID != id {
+			continue
+		}
+		if int(t.Start) > pos || int(t.Start) == pos && pos < textLen {
+			out[k] = [2]int{int(t.Start) - pos, int(t.End) - pos}
+		} else if int(t.End) > pos {
+			t.End = uint32(pos)
+		}
+	}
+	return out
+}
+
+// placeComments puts the ranges commentsFrom returned in block i, base
+// bytes into its text.
+func (s *State) placeComments(marks map[int][2]int, i, base int) {
+	for k, r := range marks {
+		t := &s.Doc.Comments[k]
+		t.BlockID = s.Doc.Blocks[i].ID
+		t.Start, t.End = uint32(base+r[0]), uint32(base+r[1])
+	}
+}
+
+// clampComments drops the threads whose text is gone: those whose block
+// was deleted and those whose range edits have emptied, as deleting an
+// anchor deletes its note.
+func (s *State) clampComments() {
+	if len(s.Doc.Comments) == 0 {
+		return
+	}
+	lengths := map[uint64]int{}
+	for _, b := range s.Doc.Blocks {
+		if b.Kind == sqdoc.BlockKindText && b.Text != nil {
+			lengths[b.ID] = len(b.Text.UTF8)
+		}
+	}
+	kept := s.Doc.Comments[:0]
+	for _, t := range s.Doc.Comments {
+		n, ok := lengths[t.BlockID]
+		if !ok {
+			continue
+		}
+		t.End = min32(t.End, uint32(n))
+		t.Start = min32(t.Start, t.End)
+		if t.Start < t.End {
+			kept = append(kept, t)
+		}
+	}
+	s.Doc.Comments = kept
+}
+
+// AddComment starts a thread on the selection, or on the word at the caret,
+// and returns its ID. The selection must lie within one paragraph.
+func (s *State) AddComment(author, text string, now int64) (uint64, error) {
+	s.Normalize()
+	text = strings.TrimSpace(text)
+	if err := checkComment(text); err != nil {
+		return 0, err
+	}
+	start, end, has := s.SelectionRange()
+	if !has {
+		start, end = s.wordAtCaret()
+	}
+	if start.Block != end.Block {
+		return 0, fmt.Errorf("comments cover text within one paragraph")
+	}
+	if s.IsTable(start.Block) || s.IsNote(start.Block) {
+		return 0, fmt.Errorf("comments cannot go in tables or notes")
+	}
+	if start.Byte == end.Byte {
+		return 0, fmt.Errorf("select the text to comment on")
+	}
+	id := s.nextBlockID()
+	s.Doc.Comments = append(s.Doc.Comments, sqdoc.CommentThread{
+		ID:       id,
+		BlockID:  s.Doc.Blocks[start.Block].ID,
+		Start:    uint32(start.Byte),
+		End:      uint32(end.Byte),
+		Comments: []sqdoc.Comment{{Author: author, CreatedUnix: now, Text: text}},
+	})
+	return id, nil
+}
+
+// wordAtCaret returns the word the caret is in or touches, or the caret
+// twice when there is none.
+func (s *State) wordAtCaret() (Position, Position) {
+	text := s.CurrentBlockText()
+	start, end := s.CaretByte, s.CaretByte
+	for start > 0 {
+		r, size := utf8.DecodeLastRune(text[:start])
+		if !isWordRune(r) {
+			break
+		}
+		start -= size
+	}
+	for end < len(text) {
+		r, size := utf8.DecodeRune(text[end:])
+		if !isWordRune(r) {
+			break
+		}
+		end += size
+	}
+	return Position{Block: s.CurrentBlock, Byte: start}, Position{Block: s.CurrentBlock, Byte: end}
+}
+
+func checkComment(text string) error {
+	if text == "" {
+		return fmt.Errorf("the comment is empty")
+	}
+	if len(text) > sqdoc.MaxCommentText {
+		return fmt.Errorf("comments are at most %d KiB", sqdoc.MaxCommentText>>10)
+	}
+	return nil
+}
+
+func (s *State) thread(id uint64) *sqdoc.CommentThread {
+	for k := range s.Doc.Comments {
+		if s.Doc.Comments[k].ID == id {
+			return &s.Doc.Comments[k]
+		}
+	}
+	return nil
+}
+
+// ReplyToComment adds a reply to thread id. Replying reopens a resolved
+// thread.
+func (s *State) ReplyToComment(id uint64, author, text string, now int64) error {
+	text = strings.TrimSpace(text)
+	if err := checkComment(text); err != nil {
+		return err
+	}
+	t := s.thread(id)
+	if t == nil {
+		return fmt.Errorf("the comment is gone")
+	}
+	t.Comments = append(t.Comments, sqdoc.Comment{Author: author, CreatedUnix: now, Text: text})
+	t.Resolved = false
+	return nil
+}
+
+// ResolveComment marks thread id resolved or open again, reporting whether
+// there is such a thread.
+func (s *State) ResolveComment(id uint64, resolved bool) bool {
+	t := s.thread(id)
+	if t == nil {
+		return false
+	}
+	t.Resolved = resolved
+	return true
+}
+
+// DeleteComment removes thread id with its replies, reporting whether there
+// was one.
+func (s *State) DeleteComment(id uint64) bool {
+	for k, t := range s.Doc.Comments {
+		if t.ID == id {
+			s.Doc.Comments = append(s.Doc.Comments[:k], s.Doc.Comments[k+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Comments lists the comment threads in the order of their ranges in the
+// document.
+func (s *State) Comments() []CommentRef {
+	s.Normalize()
+	index := map[uint64]int{}
+	for i, b := range s.Doc.Blocks {
+		index[b.ID] = i
+	}
+	out := make([]CommentRef, 0, len(s.Doc.Comments))
+	for _, t := range s.Doc.Comments {
+		out = append(out, CommentRef{CommentThread: t, Block: index[t.BlockID]})
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		if out[i].Block != out[j].Block {
+			return out[i].Block < out[j].Block
+		}
+		return out[i].Start < out[j].Start
+	})
+	return out
+}
+
+// CommentsAt returns the IDs of the threads whose ranges hold the byte at
+// pos of block i.
+func (s *State) CommentsAt(i, pos int) []uint64 {
+	if s.Doc == nil || i < 0 || i >= len(s.Doc.Blocks) {
+		return nil
+	}
+	id := s.Doc.Blocks[i].ID
+	var out []uint64
+	for _, t := range s.Doc.Comments {
+		if t.BlockID == id && int(t.Start) <= pos && pos < int(t.End) {
+			out = append(out, t.ID)
+		}
+	}
+	return out
+}
+
+// SelectComment selects the text thread id is about, reporting whether
+// there is such a thread.
+func (s *State) SelectComment(id uint64) bool {
+	for _, c := range s.Comments() {
+		if c.ID == id {
+			s.SelectRange(Position{Block: c.Block, Byte: int(c.Start)}, Position{Block: c.Block, Byte: int(c.End)})
+			return true
+		}
+	}
+	return false
+}
+
+func min32(a, b uint32) uint32 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max32(a, b uint32) uint32 {
+	if a > b {
+		return a
+	}
+	return b
+}
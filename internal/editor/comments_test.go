@@ -0,0 +1,75 @@
+package editor
+
+import (
+	"testing"
+)
+
+func TestCommentsFollowTheirText(t *testing.T) {
+	s := searchState(t, "alpha beta gamma\ndelta")
+	// commented returns the text of the only thread, or "" with none.
+	commented := func() string {
+		t.Helper()
+		threads := s.Comments()
+		if len(threads) != 1 {
+			return ""
+		}
+		c := threads[0]
+		return string(s.text(c.Block).UTF8[c.Start:c.End])
+	}
+
+	s.SetCaret(0, 7)
+	id, err := s.AddComment("Ana", "Which beta?", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := commented(); got != "beta" {
+		t.Fatalf("commented %q", got)
+	}
+	s.SetCaret(0, 0)
+	_ = s.InsertTextAtCaret("The ")
+	s.SetCaret(0, 14)
+	_ = s.InsertTextAtCaret("s")
+	s.SetCaret(0, 12)
+	_ = s.InsertTextAtCaret("-")
+	if got := commented(); got != "be-ta" {
+		t.Fatalf("after typing around it: %q", got)
+	}
+
+	// Splitting before the range takes it along, and joining brings it back.
+	s.SetCaret(0, 4)
+	s.SplitBlockAtCaret()
+	if c := s.Comments()[0]; c.Block != 1 || commented() != "be-ta" {
+		t.Fatalf("after the split: block %d, %q", c.Block, commented())
+	}
+	s.Backspace()
+	if c := s.Comments()[0]; c.Block != 0 || commented() != "be-ta" {
+		t.Fatalf("after the join: block %d, %q", c.Block, commented())
+	}
+
+	if err := s.ReplyToComment(id, "Rui", "  The second one.  ", 200); err != nil {
+		t.Fatal(err)
+	}
+	s.ResolveComment(id, true)
+	_ = s.ReplyToComment(id, "Ana", "Thanks", 300)
+	if c := s.Comments()[0]; c.Resolved || len(c.Comments) != 3 || c.Comments[1].Text != "The second one." {
+		t.Fatalf("thread = %+v", c.CommentThread)
+	}
+	if ids := s.CommentsAt(0, 10); len(ids) != 1 || ids[0] != id {
+		t.Fatalf("CommentsAt = %v", ids)
+	}
+
+	s.SelectRange(Position{Block: 0, Byte: 2}, Position{Block: 1, Byte: 2})
+	if _, err := s.AddComment("Ana", "Too long", 400); err == nil {
+		t.Fatal("a comment spanned paragraphs")
+	}
+	if _, err := s.AddComment("Ana", " ", 400); err == nil {
+		t.Fatal("an empty comment was added")
+	}
+
+	// Deleting the text deletes its thread.
+	s.SelectRange(Position{Block: 0, Byte: 8}, Position{Block: 1, Byte: 0})
+	s.DeleteSelection()
+	if got := s.Comments(); len(got) != 0 {
+		t.Fatalf("threads left: %+v", got)
+	}
+}
@@ -0,0 +1,141 @@
+package editor
+
+import (
+	"fmt"
+	"strings"
+
+	"sqdoc/pkg/sqdoc"
+)
+
+// LinkAt returns the link on the character at pos of block i with the byte
+// range of the whole link, or an empty target when there is none.
+func (s *State) LinkAt(i, pos int) (string, int, int) {
+	return spanAt(s, i, pos, func(attr sqdoc.StyleAttr) string { return attr.Link })
+}
+
+// spanAt returns key of the character at pos of block i with the byte
+// range of the runs around it that share it, or the zero key when it has
+// none.
+func spanAt[K comparable](s *State, i, pos int, key func(sqdoc.StyleAttr) K) (K, int, int) {
+	var zero K
+	if s.Doc == nil || i < 0 || i >= len(s.Doc.Blocks) {
+		return zero, 0, 0
+	}
+	tb := s.text(i)
+	runs := coverageRuns(len(tb.UTF8), tb.Runs)
+	at := -1
+	for n, r := range runs {
+		if int(r.Start) <= pos && pos < int(r.End) {
+			at = n
+			break
+		}
+	}
+	if at < 0 || key(runs[at].Attr) == zero {
+		return zero, 0, 0
+	}
+	k := key(runs[at].Attr)
+	first, last := at, at
+	for first > 0 && key(runs[first-1].Attr) == k && runs[first-1].End == runs[first].Start {
+		first--
+	}
+	for last+1 < len(runs) && key(runs[last+1].Attr) == k && runs[last+1].Start == runs[last].End {
+		last++
+	}
+	return k, int(runs[first].Start), int(runs[last].End)
+}
+
+// linkInside returns the link that text typed at pos of block i joins: one
+// that runs on both sides of pos. Typing at either end of a link does not
+// extend it.
+func (s *State) linkInside(i, pos int) string {
+	link, start, _ := s.LinkAt(i, pos)
+	if start >= pos {
+		return ""
+	}
+	return link
+}
+
+// SelectLinkAtCaret selects the link the caret is in or just after and
+// returns its target. It reports false, changing nothing, when there is a
+// selection already or no link there.
+func (s *State) SelectLinkAtCaret() (string, bool) {
+	s.Normalize()
+	if s.HasSelection() {
+		return "", false
+	}
+	link, start, end := s.LinkAt(s.CurrentBlock, s.CaretByte)
+	if link == "" && s.CaretByte > 0 {
+		link, start, end = s.LinkAt(s.CurrentBlock, previousRuneBoundary(s.CurrentBlockText(), s.CaretByte))
+	}
+	if link == "" {
+		return "", false
+	}
+	s.SelectRange(Position{Block: s.CurrentBlock, Byte: start}, Position{Block: s.CurrentBlock, Byte: end})
+	return link, true
+}
+
+// SelectionLink returns the link on the first character of the selection,
+// or of the character at the caret.
+func (s *State) SelectionLink() string {
+	return s.currentStyleAttr().Link
+}
+
+// SetLink links the selection to target. When text is given and differs
+// from a selection within one paragraph, it replaces the selected text;
+// with nothing selected it is inserted at the caret as a new link, or the
+// target itself is when text is empty.
+func (s *State) SetLink(text, target string) error {
+	s.Normalize()
+	if len(target) > sqdoc.MaxLinkLength || strings.ContainsAny(target, "\r\n\t") {
+		return fmt.Errorf("invalid link target")
+	}
+	start, end, has := s.SelectionRange()
+	if !has {
+		if target == "" {
+			return nil
+		}
+		if text == "" {
+			text = target
+		}
+		attr := s.currentStyleAttr()
+		attr.Link = target
+		pos := s.CaretByte
+		s.replaceRangeInBlock(s.CurrentBlock, pos, pos, []byte(text), attr)
+		s.CaretByte = pos + len(text)
+		return nil
+	}
+	if text != "" && start.Block == end.Block && text != s.SelectedText() {
+		attr := s.styleAt(start.Block, start.Byte)
+		attr.Link = target
+		s.replaceRangeInBlock(start.Block, start.Byte, end.Byte, []byte(text), attr)
+		s.SelectRange(start, Position{Block: start.Block, Byte: start.Byte + len(text)})
+		return nil
+	}
+	s.mutateSelection(func(attr *sqdoc.StyleAttr) { attr.Link = target })
+	return nil
+}
+
+// RemoveLink unlinks the selection, or the whole link at the caret.
+func (s *State) RemoveLink() {
+	s.Normalize()
+	if !s.HasSelection() {
+		if _, ok := s.SelectLinkAtCaret(); !ok {
+			return
+		}
+		defer s.ClearSelection()
+	}
+	s.mutateSelection(func(attr *sqdoc.StyleAttr) { attr.Link = "" })
+}
+
+// FollowAnchor moves the caret to the start of the place an internal link
+// names. It reports false when the document has no such anchor.
+func (s *State) FollowAnchor(name string) bool {
+	s.Normalize()
+	at, ok := s.Doc.Anchors()[name]
+	if !ok {
+		return false
+	}
+	s.ClearSelection()
+	s.SetCaret(at.Block, at.Offset)
+	return true
+}
@@ -0,0 +1,73 @@
+package editor
+
+import (
+	"testing"
+
+	"sqdoc/pkg/sqdoc"
+)
+
+func TestSetLink(t *testing.T) {
+	s := searchState(t, "read the docs now")
+	s.SelectRange(Position{Block: 0, Byte: 9}, Position{Block: 0, Byte: 13})
+	if err := s.SetLink("", "https://example.com/docs"); err != nil {
+		t.Fatal(err)
+	}
+	if link, start, end := s.LinkAt(0, 10); link != "https://example.com/docs" || start != 9 || end != 13 {
+		t.Fatalf("LinkAt = %q %d..%d", link, start, end)
+	}
+	if link, _, _ := s.LinkAt(0, 13); link != "" {
+		t.Fatalf("link runs past its text: %q", link)
+	}
+
+	s.ClearSelection()
+	s.SetCaret(0, 13)
+	_ = s.InsertTextAtCaret("!")
+	s.SetCaret(0, 9)
+	_ = s.InsertTextAtCaret("(")
+	if link, start, end := s.LinkAt(0, 11); link == "" || start != 10 || end != 14 {
+		t.Fatalf("typing beside a link changed it to %d..%d", start, end)
+	}
+	s.SetCaret(0, 12)
+	_ = s.InsertTextAtCaret("o")
+	if _, start, end := s.LinkAt(0, 10); start != 10 || end != 15 || s.CurrentText() != "read the (doocs! now" {
+		t.Fatalf("typing inside a link: %q, link %d..%d", s.CurrentText(), start, end)
+	}
+
+	if target, ok := s.SelectLinkAtCaret(); !ok || target != "https://example.com/docs" || s.SelectedText() != "doocs" {
+		t.Fatalf("SelectLinkAtCaret = %q, %v, selected %q", target, ok, s.SelectedText())
+	}
+	_ = s.SetLink("guide", "#guide")
+	if s.CurrentText() != "read the (guide! now" || s.SelectionLink() != "#guide" {
+		t.Fatalf("editing the link gave %q -> %q", s.CurrentText(), s.SelectionLink())
+	}
+
+	s.ClearSelection()
+	s.SetCaret(0, 12)
+	s.RemoveLink()
+	if link, _, _ := s.LinkAt(0, 12); link != "" || s.HasSelection() {
+		t.Fatalf("RemoveLink left %q", link)
+	}
+}
+
+func TestInsertLinkAndFollowAnchor(t *testing.T) {
+	s := searchState(t, "Intro\nbody ")
+	s.SetCaret(0, 0)
+	s.SetParagraphStyle(sqdoc.StyleHeading1)
+	s.SetCaret(1, 5)
+	_ = s.SetLink("", "#intro")
+	if s.CurrentText() != "body #intro" || s.CaretByte != len("body #intro") {
+		t.Fatalf("inserted link: %q, caret %d", s.CurrentText(), s.CaretByte)
+	}
+	if link, start, _ := s.LinkAt(1, 5); link != "#intro" || start != 5 {
+		t.Fatalf("inserted link = %q at %d", link, start)
+	}
+	if err := s.SetLink("x", "bad\ntarget"); err == nil {
+		t.Fatal("SetLink accepted a line break")
+	}
+	if !s.FollowAnchor("intro") || s.CurrentBlock != 0 || s.CaretByte != 0 {
+		t.Fatalf("FollowAnchor moved to %d:%d", s.CurrentBlock, s.CaretByte)
+	}
+	if s.FollowAnchor("missing") {
+		t.Fatal("FollowAnchor found a missing anchor")
+	}
+}
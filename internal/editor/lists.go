@@ -0,0 +1,190 @@
+package editor
+
+import (
+	"strconv"
+	"strings"
+
+	"sqdoc/pkg/sqdoc"
+)
+
+// ListItem returns the list membership of the current block.
+func (s *State) ListItem() sqdoc.ListItem {
+	s.Normalize()
+	return s.text(s.CurrentBlock).List
+}
+
+// ToggleList makes the selected paragraphs items of a list with the given
+// style, or takes them out of the list when they already are. New items
+// join the list just above them when it has the same style. Nothing
+// changes while suggesting.
+func (s *State) ToggleList(style sqdoc.ListStyle) {
+	s.Normalize()
+	if s.IsTable(s.CurrentBlock) || s.IsNote(s.CurrentBlock) || s.IsCode(s.CurrentBlock) || s.suggestingParagraphs() {
+		return
+	}
+	// Table cells, notes and code blocks cannot be list items, so they are
+	// left alone.
+	first, last := s.selectedParagraphs()
+	all := true
+	for i := first; i <= last; i++ {
+		if s.IsTable(i) || s.IsNote(i) || s.IsCode(i) {
+			continue
+		}
+		item := s.text(i).List
+		all = all && item.ID != 0 && item.Style == style
+	}
+	if all {
+		for i := first; i <= last; i++ {
+			s.text(i).List = sqdoc.ListItem{}
+		}
+		return
+	}
+	id := s.text(first).List.ID
+	if id == 0 {
+		id = s.adjacentListID(first, style)
+	}
+	for i := first; i <= last; i++ {
+		if s.IsTable(i) || s.IsNote(i) || s.IsCode(i) {
+			continue
+		}
+		item := &s.text(i).List
+		if item.ID == 0 {
+			*item = sqdoc.ListItem{ID: id}
+		}
+		item.Style = style
+	}
+}
+
+// IndentList moves the selected list items delta levels deeper; outdenting
+// past the first level takes an item out of its list. It reports false,
+// changing nothing, when the current paragraph is not a list item. While
+// suggesting it changes nothing either, but still reports true.
+func (s *State) IndentList(delta int) bool {
+	s.Normalize()
+	if s.text(s.CurrentBlock).List.ID == 0 {
+		return false
+	}
+	if s.suggestingParagraphs() {
+		return true
+	}
+	first, last := s.selectedParagraphs()
+	for i := first; i <= last; i++ {
+		item := &s.text(i).List
+		if item.ID == 0 {
+			continue
+		}
+		level := int(item.Level) + delta
+		if level < 0 {
+			*item = sqdoc.ListItem{}
+			continue
+		}
+		item.Level = uint8(min(level, sqdoc.MaxListLevel))
+	}
+	return true
+}
+
+// SetListStart restarts the numbering of the current list item at n; 0
+// continues from the item before it.
+func (s *State) SetListStart(n int) {
+	s.Normalize()
+	if s.suggestingParagraphs() {
+		return
+	}
+	if item := &s.text(s.CurrentBlock).List; item.ID != 0 {
+		item.Start = uint32(max(n, 0))
+	}
+}
+
+// ListNumber returns the number of the current list item, or 0 outside a
+// list.
+func (s *State) ListNumber() int {
+	s.Normalize()
+	return int(s.Doc.ListNumbers()[s.CurrentBlock])
+}
+
+// AutoFormatList turns a paragraph that starts with "- ", "* ", "1. " or
+// "1) " into a list item once the space is typed, removing the prefix. It
+// reports whether it did.
+func (s *State) AutoFormatList() bool {
+	s.Normalize()
+	tb := s.text(s.CurrentBlock)
+	if tb.List.ID != 0 || s.IsTable(s.CurrentBlock) || s.IsNote(s.CurrentBlock) || tb.Code != nil || s.HasSelection() || s.CaretByte > len(tb.UTF8) {
+		return false
+	}
+	prefix := string(tb.UTF8[:s.CaretByte])
+	if !strings.HasSuffix(prefix, " ") {
+		return false
+	}
+	style := sqdoc.ListBullet
+	var start uint64
+	switch marker := strings.TrimSuffix(prefix, " "); marker {
+	case "-", "*":
+	default:
+		if len(marker) < 2 || (marker[len(marker)-1] != '.' && marker[len(marker)-1] != ')') {
+			return false
+		}
+		n, err := strconv.ParseUint(marker[:len(marker)-1], 10, 32)
+		if err != nil || n == 0 {
+			return false
+		}
+		style, start = sqdoc.ListDecimal, n
+	}
+	s.replaceRangeInBlock(s.CurrentBlock, 0, s.CaretByte, nil, s.styleAt(s.CurrentBlock, 0))
+	s.CaretByte = 0
+	id := s.adjacentListID(s.CurrentBlock, style)
+	tb.List = sqdoc.ListItem{ID: id, Style: style}
+	if start != 1 && !s.listExists(id) {
+		tb.List.Start = uint32(start)
+	}
+	return true
+}
+
+// adjacentListID returns the list of the item just above block index when
+// it has the given style, or an unused list ID.
+func (s *State) adjacentListID(index int, style sqdoc.ListStyle) uint32 {
+	if index > 0 {
+		if prev := s.text(index - 1).List; prev.ID != 0 && prev.Style == style {
+			return prev.ID
+		}
+	}
+	return s.maxListID() + 1
+}
+
+func (s *State) maxListID() uint32 {
+	var id uint32
+	for _, b := range s.Doc.Blocks {
+		if b.Text != nil && b.Text.List.ID > id {
+			id = b.Text.List.ID
+		}
+	}
+	return id
+}
+
+// pasteLists gives the list items in frags fresh list IDs, so they do not
+// join or renumber an unrelated list that happens to share an ID here.
+// Items pasted from the same list stay together.
+func (s *State) pasteLists(frags []*sqdoc.TextBlock) {
+	ids := map[uint32]uint32{}
+	next := s.maxListID()
+	for _, tb := range frags {
+		if tb.List.ID == 0 {
+			continue
+		}
+		id, ok := ids[tb.List.ID]
+		if !ok {
+			next++
+			id = next
+			ids[tb.List.ID] = id
+		}
+		tb.List.ID = id
+	}
+}
+
+func (s *State) listExists(id uint32) bool {
+	for i, b := range s.Doc.Blocks {
+		if i != s.CurrentBlock && b.Text != nil && b.Text.List.ID == id {
+			return true
+		}
+	}
+	return false
+}
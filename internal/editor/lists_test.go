@@ -0,0 +1,92 @@
+package editor
+
+import (
+	"testing"
+
+	"sqdoc/pkg/sqdoc"
+)
+
+func TestAutoFormatStartsAndEndsList(t *testing.T) {
+	s := searchState(t, "")
+	for _, r := range "1. " {
+		_ = s.InsertTextAtCaret(string(r))
+		s.AutoFormatList()
+	}
+	_ = s.InsertTextAtCaret("first")
+	if item := s.ListItem(); item.ID == 0 || item.Style != sqdoc.ListDecimal || s.CurrentText() != "first" {
+		t.Fatalf("item = %+v, text = %q", item, s.CurrentText())
+	}
+
+	s.SplitBlockAtCaret()
+	_ = s.InsertTextAtCaret("second")
+	if !s.IndentList(1) {
+		t.Fatal("Tab in a list item was not handled")
+	}
+	if got := s.Doc.ListMarkers(); got[0] != "1." || got[1] != "1." || s.ListItem().Level != 1 {
+		t.Fatalf("markers = %q, item = %+v", got, s.ListItem())
+	}
+
+	s.SetListStart(5)
+	s.SplitBlockAtCaret()
+	_ = s.InsertTextAtCaret("third")
+	if got := s.Doc.ListMarkers(); got[1] != "5." || got[2] != "6." || s.ListNumber() != 6 {
+		t.Fatalf("restarted markers = %q", got)
+	}
+
+	s.SplitBlockAtCaret()
+	s.SplitBlockAtCaret()
+	if s.BlockCount() != 4 || s.ListItem().ID != 0 {
+		t.Fatalf("Enter on an empty item left %d blocks, item %+v", s.BlockCount(), s.ListItem())
+	}
+	if s.IndentList(1) {
+		t.Fatal("Tab outside a list was handled")
+	}
+}
+
+func TestToggleListJoinsListAbove(t *testing.T) {
+	s := searchState(t, "a\nb\nc")
+	s.SetCaret(0, 0)
+	s.ToggleList(sqdoc.ListBullet)
+	s.SelectRange(Position{Block: 1, Byte: 0}, Position{Block: 2, Byte: 1})
+	s.ToggleList(sqdoc.ListBullet)
+	id := s.Doc.Blocks[0].Text.List.ID
+	for i := range s.Doc.Blocks {
+		if s.Doc.Blocks[i].Text.List.ID != id {
+			t.Fatalf("block %d list = %+v", i, s.Doc.Blocks[i].Text.List)
+		}
+	}
+	s.ToggleList(sqdoc.ListBullet)
+	if s.Doc.Blocks[1].Text.List.ID != 0 || s.Doc.Blocks[0].Text.List.ID == 0 {
+		t.Fatal("toggling a list off changed the wrong items")
+	}
+
+	s.ClearSelection()
+	s.SetCaret(0, 0)
+	s.Backspace()
+	if s.Doc.Blocks[0].Text.List.ID != 0 || s.CurrentText() != "a" {
+		t.Fatalf("Backspace at item start = %+v, %q", s.Doc.Blocks[0].Text.List, s.CurrentText())
+	}
+}
+
+func TestPastedListItemsGetFreshLists(t *testing.T) {
+	s := searchState(t, "a\nb\nz")
+	s.SelectRange(Position{Block: 0, Byte: 0}, Position{Block: 1, Byte: 1})
+	s.ToggleList(sqdoc.ListDecimal)
+	s.ClearSelection()
+	s.SetCaret(2, 1)
+	existing := s.Doc.Blocks[0].Text.List.ID
+
+	item := func(text string, id uint32) sqdoc.Block {
+		return sqdoc.Block{Kind: sqdoc.BlockKindText, Text: &sqdoc.TextBlock{UTF8: []byte(text), List: sqdoc.ListItem{ID: id, Style: sqdoc.ListDecimal}}}
+	}
+	if err := s.InsertBlocksAtCaret([]sqdoc.Block{item("", 0), item("x", existing), item("y", existing), item("w", existing+1)}); err != nil {
+		t.Fatal(err)
+	}
+	x, y, w := s.Doc.Blocks[3].Text.List.ID, s.Doc.Blocks[4].Text.List.ID, s.Doc.Blocks[5].Text.List.ID
+	if x == existing || x != y || w == x || w == existing || w == 0 {
+		t.Fatalf("pasted list IDs = %d, %d, %d; existing list %d", x, y, w, existing)
+	}
+	if got := s.Doc.ListMarkers(); got[1] != "2." || got[3] != "1." || got[4] != "2." || got[5] != "1." {
+		t.Fatalf("markers = %q", got)
+	}
+}
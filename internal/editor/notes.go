@@ -0,0 +1,307 @@
+package editor
+
+import (
+	"fmt"
+
+	"sqdoc/pkg/sqdoc"
+)
+
+// noteFontPt is the size new note text starts at.
+const noteFontPt = 11
+
+// IsNote reports whether block i holds the body of a footnote or endnote.
+func (s *State) IsNote(i int) bool {
+	return s.Doc != nil && i >= 0 && i < len(s.Doc.Blocks) && s.Doc.Blocks[i].Kind == sqdoc.BlockKindNote
+}
+
+// bodyEnd returns the index of the first note block, which is the number
+// of blocks in the body of the document.
+func (s *State) bodyEnd() int {
+	for i := len(s.Doc.Blocks); i > 0; i-- {
+		if !s.IsNote(i - 1) {
+			return i
+		}
+	}
+	return 0
+}
+
+// arrangeNotes keeps note blocks after the body in the order of their
+// numbers and drops those no anchor refers to any more, so deleting an
+// anchor deletes its note.
+func (s *State) arrangeNotes() {
+	first := -1
+	for i, b := range s.Doc.Blocks {
+		if b.Kind == sqdoc.BlockKindNote {
+			first = i
+			break
+		}
+	}
+	if first < 0 {
+		return
+	}
+	notes := s.Doc.Notes()
+	arranged := len(notes) == len(s.Doc.Blocks)-first
+	for k := 0; arranged && k < len(notes); k++ {
+		arranged = notes[k].Block == first+k
+	}
+	if arranged {
+		return
+	}
+	old := s.Doc.Blocks
+	moved := make([]int, len(old))
+	blocks := make([]sqdoc.Block, 0, len(old))
+	for i, b := range old {
+		moved[i] = -1
+		if b.Kind != sqdoc.BlockKindNote {
+			moved[i] = len(blocks)
+			blocks = append(blocks, b)
+		}
+	}
+	body := len(blocks)
+	for _, n := range notes {
+		moved[n.Block] = len(blocks)
+		blocks = append(blocks, old[n.Block])
+	}
+	if body == 0 {
+		blocks = append([]sqdoc.Block{{ID: s.nextBlockID(), Kind: sqdoc.BlockKindText, Text: &sqdoc.TextBlock{}}}, blocks...)
+		for i := range moved {
+			if moved[i] >= 0 {
+				moved[i]++
+			}
+		}
+		body = 1
+	}
+	s.Doc.Blocks = blocks
+	// Places in a dropped note go to the end of the body.
+	remap := func(p Position) Position {
+		if p.Block < 0 || p.Block >= len(moved) {
+			return p
+		}
+		if n := moved[p.Block]; n >= 0 {
+			return Position{Block: n, Byte: p.Byte}
+		}
+		return Position{Block: body - 1, Byte: len(s.text(body - 1).UTF8)}
+	}
+	caret := remap(s.caretPos())
+	s.CurrentBlock, s.CaretByte = caret.Block, caret.Byte
+	if s.selectionAnchored {
+		s.selectionAnchor = remap(s.selectionAnchor)
+	}
+}
+
+// InsertNote anchors a new footnote or endnote at the caret, replacing any
+// selection, and moves the caret into its empty text. The anchor shows the
+// note's number. Notes cannot go in code blocks or in other notes.
+func (s *State) InsertNote(kind sqdoc.NoteKind) error {
+	s.Normalize()
+	if s.HasSelection() {
+		s.DeleteSelection()
+		s.Normalize()
+	}
+	if s.IsCode(s.CurrentBlock) {
+		return fmt.Errorf("notes cannot go in code blocks")
+	}
+	if s.IsNote(s.CurrentBlock) {
+		return fmt.Errorf("notes cannot go in other notes")
+	}
+	id := s.nextBlockID()
+	attr := s.currentStyleAttr()
+	attr.Link = ""
+	attr.Ref = sqdoc.CrossRef{}
+	attr.Note = id
+	body := defaultStyleAttr()
+	body.FontSizePt = noteFontPt
+	// Normalize drops notes without anchors, so both go in before it runs
+	// again. The placeholder becomes the note's number below.
+	s.Doc.Blocks = append(s.Doc.Blocks, sqdoc.Block{
+		ID:   id,
+		Kind: sqdoc.BlockKindNote,
+		Note: kind,
+		Text: &sqdoc.TextBlock{UTF8: []byte{}, Runs: []sqdoc.StyleRun{{Start: 0, End: 0, Attr: body}}},
+	})
+	pos := s.CaretByte
+	s.replaceRangeInBlock(s.CurrentBlock, pos, pos, []byte("*"), attr)
+	s.CaretByte = pos + 1
+	s.ClearSelection()
+	s.UpdateNoteNumbers()
+	s.GoToNote(id)
+	return nil
+}
+
+// NoteAt returns the ID of the note anchored by the character at pos of
+// block i with the byte range of the whole anchor, or 0 when there is none.
+func (s *State) NoteAt(i, pos int) (uint64, int, int) {
+	return spanAt(s, i, pos, func(attr sqdoc.StyleAttr) uint64 { return attr.Note })
+}
+
+// GoToNote moves the caret to the end of the text of note id, reporting
+// whether the document has it.
+func (s *State) GoToNote(id uint64) bool {
+	s.Normalize()
+	for i, b := range s.Doc.Blocks {
+		if b.Kind == sqdoc.BlockKindNote && b.ID == id {
+			s.ClearSelection()
+			s.SetCaret(i, len(s.text(i).UTF8))
+			return true
+		}
+	}
+	return false
+}
+
+// ReturnFromNote moves the caret from the text of a note to just after its
+// first anchor. It reports false outside a note.
+func (s *State) ReturnFromNote() bool {
+	s.Normalize()
+	if !s.IsNote(s.CurrentBlock) {
+		return false
+	}
+	id := s.Doc.Blocks[s.CurrentBlock].ID
+	// anchorEnd returns the end of the first anchor to id in tb.
+	anchorEnd := func(tb *sqdoc.TextBlock) (int, bool) {
+		end := -1
+		for _, r := range coverageRuns(len(tb.UTF8), tb.Runs) {
+			if r.Attr.Note == id && (end < 0 || int(r.Start) == end) {
+				end = int(r.End)
+			} else if end >= 0 {
+				break
+			}
+		}
+		return end, end >= 0
+	}
+	for i, b := range s.Doc.Blocks {
+		switch {
+		case b.Kind == sqdoc.BlockKindNote:
+		case b.Table != nil:
+			for r, row := range b.Table.Rows {
+				for c, cell := range row {
+					if cell.Text == nil {
+						continue
+					}
+					if end, ok := anchorEnd(cell.Text); ok {
+						s.ClearSelection()
+						s.SetCaretInCell(i, r, c, end)
+						return true
+					}
+				}
+			}
+		case b.Text != nil:
+			if end, ok := anchorEnd(b.Text); ok {
+				s.ClearSelection()
+				s.SetCaret(i, end)
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// UpdateNoteNumbers sets the text of every note anchor to its note's
+// number, keeping the caret and selection on the same text. It reports
+// whether any changed.
+func (s *State) UpdateNoteNumbers() bool {
+	s.Normalize()
+	numbers := s.Doc.NoteNumbers()
+	return s.updateFields(func(attr sqdoc.StyleAttr) (string, bool) {
+		n, ok := numbers[attr.Note]
+		return n, ok && attr.Note != 0
+	})
+}
+
+// deleteAcrossNotes deletes a selection that ends in a note. Notes never
+// join other blocks, so the notes in it only lose the selected text, and
+// the body part goes as usual.
+func (s *State) deleteAcrossNotes(start, end Position) {
+	first := s.bodyEnd()
+	for i := end.Block; i >= max(start.Block, first); i-- {
+		from, to := 0, len(s.text(i).UTF8)
+		if i == start.Block {
+			from = start.Byte
+		}
+		if i == end.Block {
+			to = end.Byte
+		}
+		s.replaceRangeInBlock(i, from, to, nil, s.styleAt(i, from))
+	}
+	s.ClearSelection()
+	if start.Block >= first {
+		s.CurrentBlock, s.CaretByte = start.Block, start.Byte
+		return
+	}
+	last := first - 1
+	s.SelectRange(start, Position{Block: last, Byte: len(s.text(last).UTF8)})
+	if !s.DeleteSelection() {
+		s.CurrentBlock, s.CaretByte = start.Block, start.Byte
+	}
+}
+
+// noteBlocks copies the notes that the anchors in blocks refer to after
+// them, renumbering their IDs to follow on from the blocks', for the
+// clipboard.
+func (s *State) noteBlocks(blocks []sqdoc.Block) []sqdoc.Block {
+	ids := map[uint64]uint64{}
+	var notes []sqdoc.Block
+	for _, b := range blocks {
+		if b.Text == nil {
+			continue
+		}
+		for k := range b.Text.Runs {
+			attr := &b.Text.Runs[k].Attr
+			if attr.Note == 0 {
+				continue
+			}
+			id, ok := ids[attr.Note]
+			if !ok {
+				for i := s.bodyEnd(); i < len(s.Doc.Blocks); i++ {
+					if note := s.Doc.Blocks[i]; note.ID == attr.Note {
+						id = uint64(len(blocks) + len(notes) + 1)
+						notes = append(notes, sqdoc.Block{ID: id, Kind: sqdoc.BlockKindNote, Note: note.Note, Text: cloneText(note.Text)})
+						break
+					}
+				}
+				ids[attr.Note] = id
+			}
+			attr.Note = id
+		}
+	}
+	return append(blocks, notes...)
+}
+
+// pasteNotes adds the note blocks among blocks to the document with fresh
+// IDs and points the anchors in frags at them. Anchors to notes the paste
+// does not carry, or pasted into a note, lose their note.
+func (s *State) pasteNotes(blocks []sqdoc.Block, frags []*sqdoc.TextBlock) {
+	ids := map[uint64]uint64{}
+	if !s.IsNote(s.CurrentBlock) {
+		for _, b := range blocks {
+			if b.Kind != sqdoc.BlockKindNote || b.Text == nil || b.Note > sqdoc.NoteEndnote {
+				continue
+			}
+			id := s.nextBlockID()
+			text := s.localStyles(cloneText(b.Text))
+			text.List, text.Code = sqdoc.ListItem{}, nil
+			for k := range text.Runs {
+				text.Runs[k].Attr.Note = 0
+			}
+			s.Doc.Blocks = append(s.Doc.Blocks, sqdoc.Block{ID: id, Kind: sqdoc.BlockKindNote, Note: b.Note, Text: text})
+			ids[b.ID] = id
+		}
+	}
+	for _, tb := range frags {
+		for k := range tb.Runs {
+			if attr := &tb.Runs[k].Attr; attr.Note != 0 {
+				attr.Note = ids[attr.Note]
+			}
+		}
+	}
+}
+
+func cloneText(tb *sqdoc.TextBlock) *sqdoc.TextBlock {
+	if tb == nil {
+		return &sqdoc.TextBlock{}
+	}
+	out := *tb
+	out.UTF8 = append([]byte(nil), tb.UTF8...)
+	out.Runs = append([]sqdoc.StyleRun(nil), tb.Runs...)
+	out.Code = cloneCode(tb.Code)
+	return &out
+}
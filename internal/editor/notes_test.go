@@ -0,0 +1,75 @@
+package editor
+
+import (
+	"testing"
+
+	"sqdoc/pkg/sqdoc"
+)
+
+func TestNotesFollowTheirAnchors(t *testing.T) {
+	s := searchState(t, "alpha beta\ngamma")
+	s.SetCaret(1, 5)
+	if err := s.InsertNote(sqdoc.NoteFootnote); err != nil {
+		t.Fatal(err)
+	}
+	if !s.IsNote(s.CurrentBlock) || s.CurrentBlock != 2 {
+		t.Fatalf("caret went to %d, not the note", s.CurrentBlock)
+	}
+	_ = s.InsertTextAtCaret("Second.\nStill second.")
+	if err := s.InsertNote(sqdoc.NoteFootnote); err == nil {
+		t.Fatal("InsertNote worked inside a note")
+	}
+	s.SetCaret(0, 5)
+	if err := s.InsertNote(sqdoc.NoteFootnote); err != nil {
+		t.Fatal(err)
+	}
+	_ = s.InsertTextAtCaret("First.")
+	if got := s.AllBlockTexts(); len(got) != 4 || got[0] != "alpha1 beta" || got[1] != "gamma2" || got[2] != "First." || got[3] != "Second.\nStill second." {
+		t.Fatalf("blocks = %q", got)
+	}
+
+	if !s.ReturnFromNote() || s.CurrentBlock != 0 || s.CaretByte != 6 {
+		t.Fatalf("ReturnFromNote went to %d:%d", s.CurrentBlock, s.CaretByte)
+	}
+	_ = s.InsertTextAtCaret("x")
+	if id, _, _ := s.NoteAt(0, 6); id != 0 {
+		t.Fatal("text typed after an anchor joined it")
+	}
+
+	// Cutting the first anchor takes its note along, and pasting it back
+	// brings a copy.
+	s.SelectRange(Position{Block: 0, Byte: 0}, Position{Block: 0, Byte: 7})
+	clip := s.SelectedBlocks()
+	if len(clip) != 2 || clip[1].Kind != sqdoc.BlockKindNote || string(clip[1].Text.UTF8) != "First." {
+		t.Fatalf("copied %+v", clip)
+	}
+	s.DeleteSelection()
+	if got := s.AllBlockTexts(); len(got) != 3 || got[2] != "Second.\nStill second." {
+		t.Fatalf("after the cut: %q", got)
+	}
+	s.UpdateNoteNumbers()
+	if got := s.AllBlockTexts(); got[1] != "gamma1" {
+		t.Fatalf("renumbered to %q", got[1])
+	}
+	s.SetCaret(1, 6)
+	if err := s.InsertBlocksAtCaret(clip); err != nil {
+		t.Fatal(err)
+	}
+	s.UpdateNoteNumbers()
+	if got := s.AllBlockTexts(); len(got) != 4 || got[1] != "gamma1alpha2x" || got[3] != "First." {
+		t.Fatalf("after the paste: %q", got)
+	}
+
+	// Select All in a note stays in it, and deleting across the body and a
+	// note keeps the note but drops those whose anchors went.
+	s.SetCaret(3, 0)
+	s.SelectAll()
+	if s.SelectedText() != "First." {
+		t.Fatalf("Select All in a note took %q", s.SelectedText())
+	}
+	s.SelectRange(Position{Block: 1, Byte: 6}, Position{Block: 2, Byte: 8})
+	s.DeleteSelection()
+	if got := s.AllBlockTexts(); len(got) != 3 || got[1] != "gamma1" || got[2] != "Still second." {
+		t.Fatalf("after deleting into a note: %q", got)
+	}
+}
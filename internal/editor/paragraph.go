@@ -0,0 +1,132 @@
+package editor
+
+import "sqdoc/pkg/sqdoc"
+
+const (
+	// maxIndentPt bounds indents so a paragraph always keeps some width.
+	maxIndentPt  = 360
+	maxSpacingPt = 144
+)
+
+// ParagraphFormat returns the paragraph format of the current block.
+func (s *State) ParagraphFormat() sqdoc.ParagraphFormat {
+	s.Normalize()
+	return s.text(s.CurrentBlock).Para
+}
+
+// UpdateParagraphFormat runs mut on every paragraph the selection touches,
+// or on the current one. Suggestions do not change paragraph formatting.
+func (s *State) UpdateParagraphFormat(mut func(*sqdoc.ParagraphFormat)) {
+	s.Normalize()
+	if s.suggestingParagraphs() {
+		return
+	}
+	first, last := s.selectedParagraphs()
+	for i := first; i <= last; i++ {
+		mut(&s.text(i).Para)
+	}
+}
+
+func (s *State) SetAlignment(align sqdoc.Alignment) {
+	if align > sqdoc.AlignJustify {
+		align = sqdoc.AlignLeft
+	}
+	s.UpdateParagraphFormat(func(p *sqdoc.ParagraphFormat) { p.Align = align })
+}
+
+// AdjustIndent moves the left indent by delta points, keeping a hanging
+// first line inside the page.
+func (s *State) AdjustIndent(delta int) {
+	s.UpdateParagraphFormat(func(p *sqdoc.ParagraphFormat) {
+		left := min(max(int(p.IndentLeft)+delta, 0), maxIndentPt)
+		p.IndentLeft = uint16(left)
+		if left+int(p.IndentFirst) < 0 {
+			p.IndentFirst = int16(-left)
+		}
+	})
+}
+
+func (s *State) AdjustRightIndent(delta int) {
+	s.UpdateParagraphFormat(func(p *sqdoc.ParagraphFormat) {
+		p.IndentRight = uint16(min(max(int(p.IndentRight)+delta, 0), maxIndentPt))
+	})
+}
+
+// SetFirstLineIndent sets the first line's offset from the left indent. A
+// negative value makes a hanging indent, widening the left indent to fit.
+func (s *State) SetFirstLineIndent(pt int) {
+	pt = min(max(pt, -maxIndentPt), maxIndentPt)
+	s.UpdateParagraphFormat(func(p *sqdoc.ParagraphFormat) {
+		p.IndentFirst = int16(pt)
+		if int(p.IndentLeft)+pt < 0 {
+			p.IndentLeft = uint16(-pt)
+		}
+	})
+}
+
+// AdjustSpacing changes the space before and after paragraphs by the given
+// points.
+func (s *State) AdjustSpacing(before, after int) {
+	s.UpdateParagraphFormat(func(p *sqdoc.ParagraphFormat) {
+		p.SpaceBefore = uint16(min(max(int(p.SpaceBefore)+before, 0), maxSpacingPt))
+		p.SpaceAfter = uint16(min(max(int(p.SpaceAfter)+after, 0), maxSpacingPt))
+	})
+}
+
+// SetLineHeight sets the line height in percent, clamped to 50..500; 100
+// is stored as the default.
+func (s *State) SetLineHeight(percent int) {
+	percent = min(max(percent, 50), 500)
+	if percent == 100 {
+		percent = 0
+	}
+	s.UpdateParagraphFormat(func(p *sqdoc.ParagraphFormat) { p.LineHeight = uint16(percent) })
+}
+
+// pageBreakable reports whether block i can start a page: table cells and
+// notes cannot.
+func (s *State) pageBreakable(i int) bool {
+	return !s.IsTable(i) && !s.IsNote(i)
+}
+
+// TogglePageBreak starts the selected paragraphs on new pages, or stops
+// them if the first already does, except while suggesting.
+func (s *State) TogglePageBreak() {
+	s.Normalize()
+	if s.suggestingParagraphs() {
+		return
+	}
+	first, last := s.selectedParagraphs()
+	on := !s.text(first).Para.PageBreakBefore
+	for i := first; i <= last; i++ {
+		if s.pageBreakable(i) {
+			s.text(i).Para.PageBreakBefore = on
+		}
+	}
+}
+
+// InsertPageBreak starts a new paragraph at the caret on a new page. At the
+// start of a paragraph the paragraph itself moves to a new page. It reports
+// whether the caret's block can break pages, which it cannot while
+// suggesting.
+func (s *State) InsertPageBreak() bool {
+	s.Normalize()
+	if !s.pageBreakable(s.CurrentBlock) || s.IsCode(s.CurrentBlock) || s.suggestingParagraphs() {
+		return false
+	}
+	_ = s.DeleteSelection()
+	if s.CaretByte > 0 {
+		_ = s.InsertTextAtCaret("\n")
+	}
+	s.text(s.CurrentBlock).Para.PageBreakBefore = true
+	return true
+}
+
+// selectedParagraphs returns the first and last block the selection
+// touches, or the current block twice.
+func (s *State) selectedParagraphs() (int, int) {
+	if start, end, has := s.SelectionRange(); has {
+		return start.Block, end.Block
+	}
+	return s.CurrentBlock, s.CurrentBlock
+}
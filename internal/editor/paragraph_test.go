@@ -0,0 +1,86 @@
+package editor
+
+import (
+	"testing"
+
+	"sqdoc/pkg/sqdoc"
+)
+
+func TestParagraphFormatAppliesToSelectedBlocks(t *testing.T) {
+	s := searchState(t, "one\ntwo\nthree")
+	s.SelectRange(Position{Block: 0, Byte: 1}, Position{Block: 1, Byte: 1})
+	s.SetAlignment(sqdoc.AlignCenter)
+	s.AdjustIndent(36)
+	s.SetLineHeight(150)
+	for i, want := range []sqdoc.Alignment{sqdoc.AlignCenter, sqdoc.AlignCenter, sqdoc.AlignLeft} {
+		if got := s.Doc.Blocks[i].Text.Para.Align; got != want {
+			t.Fatalf("block %d align = %d", i, got)
+		}
+	}
+	if p := s.Doc.Blocks[1].Text.Para; p.IndentLeft != 36 || p.LineHeight != 150 {
+		t.Fatalf("block 1 = %+v", p)
+	}
+
+	s.ClearSelection()
+	s.SetCaret(1, 3)
+	s.SplitBlockAtCaret()
+	if p := s.ParagraphFormat(); p.Align != sqdoc.AlignCenter || p.IndentLeft != 36 {
+		t.Fatalf("split paragraph = %+v", p)
+	}
+}
+
+func TestIndentsStayInsideThePage(t *testing.T) {
+	s := searchState(t, "text")
+	s.AdjustIndent(-10)
+	s.SetFirstLineIndent(-24)
+	if p := s.ParagraphFormat(); p.IndentLeft != 24 || p.IndentFirst != -24 {
+		t.Fatalf("hanging indent = %+v", p)
+	}
+	s.AdjustIndent(-12)
+	if p := s.ParagraphFormat(); p.IndentLeft != 12 || p.IndentFirst != -12 {
+		t.Fatalf("after outdent = %+v", p)
+	}
+	s.AdjustSpacing(-6, 500)
+	s.SetLineHeight(100)
+	if p := s.ParagraphFormat(); p.SpaceBefore != 0 || p.SpaceAfter != maxSpacingPt || p.LineHeight != 0 {
+		t.Fatalf("spacing = %+v", p)
+	}
+	if errs := sqdoc.ValidateAll(s.Doc); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+}
+
+func TestPageBreaks(t *testing.T) {
+	s := searchState(t, "one two\nthree")
+	s.SetCaret(0, 4)
+	if !s.InsertPageBreak() {
+		t.Fatal("no page break")
+	}
+	breaks := func() []bool {
+		var out []bool
+		for _, b := range s.Doc.Blocks {
+			out = append(out, b.Text.Para.PageBreakBefore)
+		}
+		return out
+	}
+	if got := breaks(); len(got) != 3 || got[0] || !got[1] || got[2] || string(s.CurrentBlockText()) != "two" {
+		t.Fatalf("breaks = %v, caret in %q", got, s.CurrentBlockText())
+	}
+
+	// Splitting the paragraph leaves the break on its first half.
+	s.SetCaret(1, 1)
+	s.SplitBlockAtCaret()
+	if got := breaks(); !got[1] || got[2] {
+		t.Fatalf("after a split: %v", got)
+	}
+
+	s.SelectRange(Position{Block: 0, Byte: 0}, Position{Block: 3, Byte: 1})
+	s.TogglePageBreak()
+	s.TogglePageBreak()
+	if got := breaks(); got[0] || got[1] || got[3] {
+		t.Fatalf("after toggling: %v", got)
+	}
+	if err := sqdoc.Validate(s.Doc); err != nil {
+		t.Fatal(err)
+	}
+}
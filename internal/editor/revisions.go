@@ -0,0 +1,476 @@
+package editor
+
+import (
+	"sort"
+	"time"
+
+	"sqdoc/pkg/sqdoc"
+)
+
+// unixNow dates new revisions.
+var unixNow = func() int64 { return time.Now().Unix() }
+
+// RevisionRef is a suggested change with the text it covers, from Start to
+// End, which may be in different blocks.
+type RevisionRef struct {
+	sqdoc.Revision
+	Start, End Position
+}
+
+// suggesting reports whether an edit of block i is recorded as a
+// suggestion. Table cells are always edited directly.
+func (s *State) suggesting(i int) bool {
+	return s.Suggesting && !s.IsTable(i)
+}
+
+func (s *State) revision(id uint64) *sqdoc.Revision {
+	if id == 0 {
+		return nil
+	}
+	for k := range s.Doc.Revisions {
+		if s.Doc.Revisions[k].ID == id {
+			return &s.Doc.Revisions[k]
+		}
+	}
+	return nil
+}
+
+// addRevision records a change of the given kind by the current author and
+// returns its ID.
+func (s *State) addRevision(kind sqdoc.RevisionKind, old sqdoc.StyleAttr) uint64 {
+	var id uint64
+	for _, r := range s.Doc.Revisions {
+		if r.ID > id {
+			id = r.ID
+		}
+	}
+	id++
+	old.Revision = 0
+	s.Doc.Revisions = append(s.Doc.Revisions, sqdoc.Revision{ID: id, Kind: kind, Author: s.Author, CreatedUnix: unixNow(), Old: old})
+	return id
+}
+
+// adjacentRevision returns the change of the given kind by the current
+// author that the text or paragraph break either side of pos in block i is
+// part of, so that typing or deleting a character at a time makes one
+// change.
+func (s *State) adjacentRevision(i, pos int, kind sqdoc.RevisionKind) uint64 {
+	n := len(s.text(i).UTF8)
+	for _, at := range []int{pos - 1, pos} {
+		var id uint64
+		switch {
+		case at < 0 && i > 0 && !s.IsTable(i-1):
+			id = s.text(i - 1).Break
+		case at < 0:
+			continue
+		case at >= n:
+			id = s.text(i).Break
+		default:
+			id = s.styleAt(i, at).Revision
+		}
+		if r := s.revision(id); r != nil && r.Kind == kind && r.Author == s.Author {
+			return r.ID
+		}
+	}
+	return 0
+}
+
+// insertRevision returns the revision text typed at pos of block i is part
+// of: 0 when not suggesting.
+func (s *State) insertRevision(i, pos int) uint64 {
+	if !s.suggesting(i) {
+		return 0
+	}
+	if id := s.adjacentRevision(i, pos, sqdoc.RevisionInsert); id != 0 {
+		return id
+	}
+	return s.addRevision(sqdoc.RevisionInsert, sqdoc.StyleAttr{})
+}
+
+// trackFormatting wraps a formatting change so that it is recorded as a
+// suggestion. Text with the same formatting before shares one revision,
+// and text changed back to its old formatting has none. Inserted and
+// deleted text takes the change directly.
+func (s *State) trackFormatting(mut func(*sqdoc.StyleAttr)) func(*sqdoc.StyleAttr) {
+	made := map[sqdoc.StyleAttr]uint64{}
+	return func(attr *sqdoc.StyleAttr) {
+		before := *attr
+		mut(attr)
+		r := s.revision(before.Revision)
+		switch {
+		case r != nil && r.Kind != sqdoc.RevisionFormat:
+		case r != nil:
+			if sqdoc.SameFormatting(*attr, r.Old) {
+				attr.Revision = 0
+			}
+		case sqdoc.SameFormatting(before, *attr):
+		default:
+			key := sqdoc.WithFormatting(sqdoc.StyleAttr{}, before)
+			if made[key] == 0 {
+				made[key] = s.addRevision(sqdoc.RevisionFormat, key)
+			}
+			attr.Revision = made[key]
+		}
+	}
+}
+
+// suggestDeletion marks bytes start..end of block i deleted as part of
+// change id, or of the deletion next to them when id is 0, and returns
+// where the range now ends. Text inserted as a suggestion goes at once,
+// and text already marked stays with its change.
+func (s *State) suggestDeletion(i, start, end int, id uint64) int {
+	if id == 0 {
+		id = s.adjacentRevision(i, start, sqdoc.RevisionDelete)
+	}
+	if id == 0 {
+		id = s.adjacentRevision(i, end, sqdoc.RevisionDelete)
+	}
+	if id == 0 {
+		id = s.addRevision(sqdoc.RevisionDelete, sqdoc.StyleAttr{})
+	}
+	runs := coverageRuns(len(s.text(i).UTF8), s.text(i).Runs)
+	for k := len(runs) - 1; k >= 0; k-- {
+		from, to := max(int(runs[k].Start), start), min(int(runs[k].End), end)
+		if r := s.revision(runs[k].Attr.Revision); from < to && r != nil && r.Kind == sqdoc.RevisionInsert {
+			s.replaceRangeInBlock(i, from, to, nil, runs[k].Attr)
+			end -= to - from
+		}
+	}
+	if start < end {
+		s.applyStyleToBlockRange(i, start, end, func(attr *sqdoc.StyleAttr) {
+			if r := s.revision(attr.Revision); r == nil || r.Kind == sqdoc.RevisionFormat {
+				attr.Revision = id
+			}
+		})
+	}
+	return end
+}
+
+// suggestJoin marks the paragraph break after block i deleted as part of
+// change id, or of the deletion next to it when id is 0. A break suggested
+// for insertion goes at once, joining the blocks, and suggestJoin reports
+// whether it did.
+func (s *State) suggestJoin(i int, id uint64) bool {
+	tb := s.text(i)
+	if r := s.revision(tb.Break); r != nil {
+		if r.Kind != sqdoc.RevisionInsert {
+			return false
+		}
+		s.mergeBlocks(i, i+1)
+		return true
+	}
+	if id == 0 {
+		id = s.adjacentRevision(i, len(tb.UTF8), sqdoc.RevisionDelete)
+	}
+	if id == 0 {
+		id = s.adjacentRevision(i+1, 0, sqdoc.RevisionDelete)
+	}
+	if id == 0 {
+		id = s.addRevision(sqdoc.RevisionDelete, sqdoc.StyleAttr{})
+	}
+	tb.Break = id
+	return false
+}
+
+// suggestJoinAtCaret deletes the paragraph break before the caret, or
+// with forward the one after it, as a suggestion and leaves the caret on
+// the other side of it. Breaks next to a table, note or code block stay.
+func (s *State) suggestJoinAtCaret(forward bool) {
+	left := s.CurrentBlock - 1
+	if forward {
+		left = s.CurrentBlock
+	}
+	if s.joinable(left, left+1) {
+		n := len(s.text(left).UTF8)
+		if s.suggestJoin(left, 0) {
+			s.CurrentBlock, s.CaretByte = left, n
+			return
+		}
+	}
+	if forward {
+		s.MoveCaretRight()
+	} else {
+		s.MoveCaretLeft()
+	}
+}
+
+// suggestDeleteSelection marks the selection deleted as one change, the
+// paragraph breaks in it included, leaving the caret after it. Tables in
+// it are left alone.
+func (s *State) suggestDeleteSelection(start, end Position) {
+	id := s.adjacentRevision(start.Block, start.Byte, sqdoc.RevisionDelete)
+	if id == 0 {
+		id = s.adjacentRevision(end.Block, end.Byte, sqdoc.RevisionDelete)
+	}
+	if id == 0 {
+		id = s.addRevision(sqdoc.RevisionDelete, sqdoc.StyleAttr{})
+	}
+	caret, placed := start, false
+	for i := end.Block; i >= start.Block; i-- {
+		if s.IsTable(i) {
+			continue
+		}
+		from, to := 0, len(s.text(i).UTF8)
+		if i == start.Block {
+			from = start.Byte
+		}
+		if i == end.Block {
+			to = end.Byte
+		}
+		to = s.suggestDeletion(i, from, to, id)
+		if !placed {
+			caret, placed = Position{Block: i, Byte: to}, true
+		}
+		if i == end.Block || !s.joinable(i, i+1) {
+			continue
+		}
+		// A break suggested for insertion joins its blocks at once, which
+		// moves the caret's block up.
+		n := len(s.text(i).UTF8)
+		if s.suggestJoin(i, id) {
+			if caret.Block == i+1 {
+				caret = Position{Block: i, Byte: n + caret.Byte}
+			} else if caret.Block > i+1 {
+				caret.Block--
+			}
+		}
+	}
+	s.ClearSelection()
+	s.CurrentBlock, s.CaretByte = caret.Block, caret.Byte
+}
+
+// suggestingParagraphs reports whether a paragraph the selection touches
+// is edited as a suggestion. Suggestions record text, paragraph breaks and
+// character formatting, so paragraph formatting, styles and lists are then
+// left as they are.
+func (s *State) suggestingParagraphs() bool {
+	first, last := s.selectedParagraphs()
+	for i := first; i <= last; i++ {
+		if s.suggesting(i) {
+			return true
+		}
+	}
+	return false
+}
+
+// clampRevisions drops references to revisions that are gone, and the
+// revisions no text refers to any more.
+func (s *State) clampRevisions() {
+	used := map[uint64]bool{}
+	for i, b := range s.Doc.Blocks {
+		if b.Kind == sqdoc.BlockKindTable || b.Text == nil {
+			continue
+		}
+		tb := s.text(i)
+		for k := range tb.Runs {
+			attr := &tb.Runs[k].Attr
+			if attr.Revision == 0 {
+				continue
+			}
+			if len(tb.UTF8) == 0 || s.revision(attr.Revision) == nil {
+				attr.Revision = 0
+				continue
+			}
+			used[attr.Revision] = true
+		}
+		if r := s.revision(tb.Break); r == nil || r.Kind == sqdoc.RevisionFormat || !s.joinable(i, i+1) {
+			tb.Break = 0
+		} else {
+			used[tb.Break] = true
+		}
+	}
+	kept := s.Doc.Revisions[:0]
+	for _, r := range s.Doc.Revisions {
+		if used[r.ID] {
+			kept = append(kept, r)
+		}
+	}
+	s.Doc.Revisions = kept
+}
+
+// AcceptRevision makes change id part of the document, reporting whether
+// there is such a change.
+func (s *State) AcceptRevision(id uint64) bool {
+	return s.settleRevision(id, true)
+}
+
+// RejectRevision undoes change id, reporting whether there is such a
+// change.
+func (s *State) RejectRevision(id uint64) bool {
+	return s.settleRevision(id, false)
+}
+
+// AcceptAllRevisions accepts every change and returns how many there were.
+func (s *State) AcceptAllRevisions() int {
+	return s.settleAll(true)
+}
+
+// RejectAllRevisions rejects every change and returns how many there were.
+func (s *State) RejectAllRevisions() int {
+	return s.settleAll(false)
+}
+
+func (s *State) settleAll(accept bool) int {
+	s.Normalize()
+	n := 0
+	for len(s.Doc.Revisions) > 0 {
+		s.settleRevision(s.Doc.Revisions[0].ID, accept)
+		n++
+	}
+	return n
+}
+
+// settleRevision accepts or rejects change id: the text it covers either
+// goes or stays, and stays with no change recorded. So do the paragraph
+// breaks it covers, and a break that goes joins its paragraphs.
+func (s *State) settleRevision(id uint64, accept bool) bool {
+	s.Normalize()
+	r := s.revision(id)
+	if r == nil {
+		return false
+	}
+	rev := *r
+	remove := rev.Kind == sqdoc.RevisionInsert && !accept || rev.Kind == sqdoc.RevisionDelete && accept
+	for i := len(s.Doc.Blocks) - 1; i >= 0; i-- {
+		if s.IsTable(i) {
+			continue
+		}
+		runs := coverageRuns(len(s.text(i).UTF8), s.text(i).Runs)
+		for k := len(runs) - 1; k >= 0; k-- {
+			run := runs[k]
+			if run.Attr.Revision != id || run.Start == run.End {
+				continue
+			}
+			if remove {
+				s.replaceRangeInBlock(i, int(run.Start), int(run.End), nil, run.Attr)
+				continue
+			}
+			s.applyStyleToBlockRange(i, int(run.Start), int(run.End), func(attr *sqdoc.StyleAttr) {
+				if rev.Kind == sqdoc.RevisionFormat && !accept {
+					*attr = sqdoc.WithFormatting(*attr, rev.Old)
+				}
+				attr.Revision = 0
+			})
+		}
+	}
+	for i := len(s.Doc.Blocks) - 1; i >= 0; i-- {
+		if s.IsTable(i) || s.text(i).Break != id {
+			continue
+		}
+		s.text(i).Break = 0
+		if remove && s.joinable(i, i+1) {
+			s.mergeBlocks(i, i+1)
+		}
+	}
+	for k := range s.Doc.Revisions {
+		if s.Doc.Revisions[k].ID == id {
+			s.Doc.Revisions = append(s.Doc.Revisions[:k], s.Doc.Revisions[k+1:]...)
+			break
+		}
+	}
+	s.ClearSelection()
+	s.Normalize()
+	return true
+}
+
+// Revisions lists the suggested changes in the order of their text.
+func (s *State) Revisions() []RevisionRef {
+	s.Normalize()
+	spans := map[uint64]*RevisionRef{}
+	var out []*RevisionRef
+	for i := range s.Doc.Blocks {
+		if s.IsTable(i) {
+			continue
+		}
+		for _, run := range s.text(i).Runs {
+			r := s.revision(run.Attr.Revision)
+			if r == nil {
+				continue
+			}
+			start, end := Position{Block: i, Byte: int(run.Start)}, Position{Block: i, Byte: int(run.End)}
+			if ref := spans[r.ID]; ref != nil {
+				ref.End = end
+				continue
+			}
+			spans[r.ID] = &RevisionRef{Revision: *r, Start: start, End: end}
+			out = append(out, spans[r.ID])
+		}
+		// A paragraph break runs from the end of its block to the start of
+		// the next.
+		if r := s.revision(s.text(i).Break); r != nil {
+			start, end := Position{Block: i, Byte: len(s.text(i).UTF8)}, Position{Block: i + 1}
+			if ref := spans[r.ID]; ref != nil {
+				ref.End = end
+				continue
+			}
+			spans[r.ID] = &RevisionRef{Revision: *r, Start: start, End: end}
+			out = append(out, spans[r.ID])
+		}
+	}
+	refs := make([]RevisionRef, len(out))
+	for k, ref := range out {
+		refs[k] = *ref
+	}
+	sort.SliceStable(refs, func(a, b int) bool { return comparePos(refs[a].Start, refs[b].Start) < 0 })
+	return refs
+}
+
+// RevisionAt returns the ID of the change the byte at pos of block i is
+// part of, or at the end of the block the paragraph break is, or 0.
+func (s *State) RevisionAt(i, pos int) uint64 {
+	if s.Doc == nil || i < 0 || i >= len(s.Doc.Blocks) || s.IsTable(i) || pos > len(s.text(i).UTF8) {
+		return 0
+	}
+	if pos == len(s.text(i).UTF8) {
+		return s.text(i).Break
+	}
+	return s.styleAt(i, pos).Revision
+}
+
+// SelectRevision selects the text of change id, reporting whether there is
+// such a change.
+func (s *State) SelectRevision(id uint64) bool {
+	for _, ref := range s.Revisions() {
+		if ref.ID == id {
+			s.SelectRange(ref.Start, ref.End)
+			return true
+		}
+	}
+	return false
+}
+
+// NextRevision selects the first change after the caret, or with forward
+// false the last one before it, and returns its ID, or 0 when there is
+// none.
+func (s *State) NextRevision(forward bool) uint64 {
+	at := s.caretPos()
+	if start, _, has := s.SelectionRange(); has {
+		at = start
+	}
+	refs := s.Revisions()
+	if !forward {
+		for k := len(refs) - 1; k >= 0; k-- {
+			if comparePos(refs[k].Start, at) < 0 {
+				s.SelectRange(refs[k].Start, refs[k].End)
+				return refs[k].ID
+			}
+		}
+		return 0
+	}
+	for _, ref := range refs {
+		if comparePos(ref.Start, at) > 0 {
+			s.SelectRange(ref.Start, ref.End)
+			return ref.ID
+		}
+	}
+	return 0
+}
+
+// withoutRevisions clears the revisions of copied runs, which mean nothing
+// outside their document.
+func withoutRevisions(runs []sqdoc.StyleRun) []sqdoc.StyleRun {
+	for k := range runs {
+		runs[k].Attr.Revision = 0
+	}
+	return runs
+}
@@ -0,0 +1,159 @@
+package editor
+
+import (
+	"slices"
+	"testing"
+
+	"sqdoc/pkg/sqdoc"
+)
+
+func TestSuggestionsAcceptAndReject(t *testing.T) {
+	s := searchState(t, "one two three")
+	s.Suggesting, s.Author = true, "Ana"
+	// kinds lists the changes in order, as their kind and text.
+	kinds := func() []string {
+		var out []string
+		for _, ref := range s.Revisions() {
+			tb := s.text(ref.Start.Block)
+			out = append(out, string("?idf"[ref.Kind])+":"+string(tb.UTF8[ref.Start.Byte:ref.End.Byte]))
+		}
+		return out
+	}
+
+	s.SetCaret(0, 4)
+	_ = s.InsertTextAtCaret("big ")
+	s.SetCaret(0, 11)
+	s.Backspace()
+	s.Backspace()
+	s.Backspace()
+	s.SelectRange(Position{Block: 0, Byte: 12}, Position{Block: 0, Byte: 17})
+	s.ToggleBold()
+	if got, want := kinds(), []string{"i:big ", "d:two", "f:three"}; !slices.Equal(got, want) {
+		t.Fatalf("changes = %v, want %v", got, want)
+	}
+	if string(s.CurrentBlockText()) != "one big two three" {
+		t.Fatalf("text = %q", s.CurrentBlockText())
+	}
+
+	// Deleting suggested text takes it out at once.
+	s.ClearSelection()
+	s.SetCaret(0, 8)
+	s.Backspace()
+	if got := kinds(); len(got) != 3 || got[0] != "i:big" {
+		t.Fatalf("after deleting an insertion: %v", got)
+	}
+
+	s.ClearSelection()
+	s.SetCaret(0, 0)
+	if id := s.NextRevision(true); id == 0 || s.SelectedText() != "big" {
+		t.Fatalf("next change selected %q", s.SelectedText())
+	}
+	s.NextRevision(true)
+	if !s.AcceptRevision(s.RevisionAt(0, 8)) || string(s.CurrentBlockText()) != "one big three" {
+		t.Fatalf("after accepting the deletion: %q", s.CurrentBlockText())
+	}
+	if n := s.RejectAllRevisions(); n != 2 || string(s.CurrentBlockText()) != "one  three" {
+		t.Fatalf("rejected %d, text %q", n, s.CurrentBlockText())
+	}
+	if s.styleAt(0, 6).Bold || len(s.Doc.Revisions) != 0 {
+		t.Fatalf("formatting kept: %+v", s.styleAt(0, 6))
+	}
+
+	// Without suggestions, edits are made directly again.
+	s.Suggesting = false
+	s.ClearSelection()
+	s.SetCaret(0, 4)
+	_ = s.InsertTextAtCaret("x")
+	if len(s.Revisions()) != 0 || s.styleAt(0, 4).Revision != 0 {
+		t.Fatal("an edit was recorded")
+	}
+	if err := sqdoc.Validate(s.Doc); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSuggestedDeletionJoinsParagraphs(t *testing.T) {
+	for _, accept := range []bool{true, false} {
+		s := searchState(t, "abc\ndef\nghi")
+		s.Suggesting, s.Author = true, "Ana"
+		s.SelectRange(Position{Block: 0, Byte: 1}, Position{Block: 2, Byte: 1})
+		s.Backspace()
+		refs := s.Revisions()
+		if len(refs) != 1 || refs[0].Kind != sqdoc.RevisionDelete || refs[0].Start != (Position{Block: 0, Byte: 1}) || refs[0].End != (Position{Block: 2, Byte: 1}) {
+			t.Fatalf("changes = %+v", refs)
+		}
+		if got := s.AllBlockTexts(); !slices.Equal(got, []string{"abc", "def", "ghi"}) {
+			t.Fatalf("suggested deletion changed the text: %q", got)
+		}
+		if err := sqdoc.Validate(s.Doc); err != nil {
+			t.Fatal(err)
+		}
+		want := []string{"ahi"}
+		if !accept {
+			want = []string{"abc", "def", "ghi"}
+		}
+		if n := s.settleAll(accept); n != 1 || !slices.Equal(s.AllBlockTexts(), want) {
+			t.Fatalf("accept %v: settled %d, blocks %q", accept, n, s.AllBlockTexts())
+		}
+		if len(s.Revisions()) != 0 || s.text(0).Break != 0 {
+			t.Fatalf("accept %v left a change behind", accept)
+		}
+	}
+}
+
+func TestSuggestedParagraphBreaks(t *testing.T) {
+	s := searchState(t, "one two\nthree")
+	s.Suggesting, s.Author = true, "Ana"
+
+	// Enter splits the paragraph as an insertion, and rejecting it joins
+	// them again.
+	s.SetCaret(0, 3)
+	s.SplitBlockAtCaret()
+	_ = s.InsertTextAtCaret("x")
+	if got := s.AllBlockTexts(); !slices.Equal(got, []string{"one", "x two", "three"}) {
+		t.Fatalf("after Enter: %q", got)
+	}
+	if refs := s.Revisions(); len(refs) != 1 || refs[0].Kind != sqdoc.RevisionInsert {
+		t.Fatalf("Enter and typing made %+v", refs)
+	}
+	if n := s.RejectAllRevisions(); n != 1 || !slices.Equal(s.AllBlockTexts(), []string{"one two", "three"}) {
+		t.Fatalf("rejecting the split: %d, %q", n, s.AllBlockTexts())
+	}
+
+	// Backspace at the start of a paragraph marks the break deleted, and
+	// Delete at the end of the one before adds to the same change.
+	s.SetCaret(1, 0)
+	s.Backspace()
+	if s.CurrentBlock != 0 || s.CaretByte != 7 || len(s.Doc.Blocks) != 2 {
+		t.Fatalf("Backspace left the caret at %d:%d in %q", s.CurrentBlock, s.CaretByte, s.AllBlockTexts())
+	}
+	s.Backspace()
+	if refs := s.Revisions(); len(refs) != 1 || refs[0].Kind != sqdoc.RevisionDelete || refs[0].Start != (Position{Block: 0, Byte: 6}) {
+		t.Fatalf("deleting the break made %+v", refs)
+	}
+	if id := s.RevisionAt(0, 7); id == 0 || !s.AcceptRevision(id) || !slices.Equal(s.AllBlockTexts(), []string{"one twthree"}) {
+		t.Fatalf("accepting the join: %q", s.AllBlockTexts())
+	}
+
+	// Deleting a suggested break joins the paragraphs at once.
+	s.SetCaret(0, 3)
+	s.SplitBlockAtCaret()
+	s.SetCaret(0, 3)
+	s.DeleteForward()
+	if got := s.AllBlockTexts(); !slices.Equal(got, []string{"one twthree"}) || len(s.Doc.Revisions) != 0 {
+		t.Fatalf("deleting a suggested break: %q, %+v", got, s.Doc.Revisions)
+	}
+
+	// Paragraph formatting, styles and lists stay as they are.
+	before := *s.text(0)
+	s.SetAlignment(sqdoc.AlignCenter)
+	s.ToggleList(sqdoc.ListBullet)
+	s.SetParagraphStyle(s.Styles().Default)
+	s.TogglePageBreak()
+	if got := *s.text(0); got.Para != before.Para || got.List != before.List || got.Style != before.Style {
+		t.Fatalf("paragraph changed while suggesting: %+v", got)
+	}
+	if err := sqdoc.Validate(s.Doc); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,355 @@
+package editor
+
+import (
+	"regexp"
+	"sort"
+	"unicode/utf8"
+
+	"sqdoc/pkg/sqdoc"
+)
+
+// imagePlaceholder stands in for an inline image token in the searched text,
+// so queries never match inside a token's encoded path.
+const imagePlaceholder = "\uFFFC"
+
+type SearchOptions struct {
+	CaseSensitive bool
+	WholeWord     bool
+	// Regexp treats the query as Go regexp syntax and the replacement as a
+	// template where $1 or ${name} expand to capture groups.
+	Regexp bool
+}
+
+// Search is a compiled query. The whole document is searched as one text
+// with blocks joined by "\n", so matches may span block boundaries and ^ and
+// $ match at the start and end of every paragraph. Each table cell is
+// searched as a paragraph of its own that matches never cross out of.
+type Search struct {
+	opts SearchOptions
+	re   *regexp.Regexp
+}
+
+// Match is one occurrence of a Search in the document it was found in.
+// A match inside a table has InCell set and Row and Col naming the cell of
+// table block Start.Block that Start and End are bytes of.
+type Match struct {
+	Start  Position
+	End    Position
+	InCell bool
+	Row    int
+	Col    int
+
+	// cell orders the matches of one table block, counting cells from one
+	// in row order.
+	cell   int
+	src    []byte
+	groups []int
+}
+
+func CompileSearch(query string, opts SearchOptions) (*Search, error) {
+	expr := query
+	if !opts.Regexp {
+		expr = regexp.QuoteMeta(query)
+	}
+	flags := "(?m)"
+	if !opts.CaseSensitive {
+		flags = "(?mi)"
+	}
+	re, err := regexp.Compile(flags + expr)
+	if err != nil {
+		return nil, err
+	}
+	return &Search{opts: opts, re: re}, nil
+}
+
+// Expand returns the text that replaces m. Outside regexp mode the
+// replacement is used literally.
+func (q *Search) Expand(m Match, replacement string) string {
+	if !q.opts.Regexp {
+		return replacement
+	}
+	return string(q.re.ExpandString(nil, replacement, string(m.src), m.groups))
+}
+
+// FindAll returns the non-empty matches of q in document order. Matches that
+// would include part of an inline image or run into or out of a table cell
+// are skipped.
+func (s *State) FindAll(q *Search) []Match {
+	s.Normalize()
+	st := s.searchText()
+	var out []Match
+	for _, loc := range q.re.FindAllSubmatchIndex(st.text, -1) {
+		start, end := loc[0], loc[1]
+		if start == end {
+			continue
+		}
+		if st.blocked(start, end) {
+			continue
+		}
+		if q.opts.WholeWord && !isWordEdge(st.text, start, end) {
+			continue
+		}
+		groups := make([]int, len(loc))
+		for i, v := range loc {
+			groups[i] = v
+			if v >= 0 {
+				groups[i] = v - start
+			}
+		}
+		from, in := st.position(start)
+		to, _ := st.position(end)
+		out = append(out, Match{
+			Start:  from,
+			End:    to,
+			InCell: in.cell > 0,
+			Row:    in.row,
+			Col:    in.col,
+			cell:   in.cell,
+			src:    append([]byte(nil), st.text[start:end]...),
+			groups: groups,
+		})
+	}
+	return out
+}
+
+// MatchAfter returns the index of the first match starting at or after p,
+// wrapping to the first match, or -1 when there are none. A p in a table
+// block comes before the matches in its cells.
+func MatchAfter(matches []Match, p Position) int {
+	return matchAfter(matches, p, 0)
+}
+
+// MatchBefore returns the index of the last match ending at or before p,
+// wrapping to the last match, or -1 when there are none.
+func MatchBefore(matches []Match, p Position) int {
+	return matchBefore(matches, p, 0)
+}
+
+// MatchAfterCaret is MatchAfter from p in the cell being edited when p is in
+// a table.
+func (s *State) MatchAfterCaret(matches []Match, p Position) int {
+	return matchAfter(matches, p, s.cellOrdinal(p.Block))
+}
+
+// MatchBeforeCaret is MatchBefore from p in the cell being edited when p is
+// in a table.
+func (s *State) MatchBeforeCaret(matches []Match, p Position) int {
+	return matchBefore(matches, p, s.cellOrdinal(p.Block))
+}
+
+// MatchSelected reports whether the selection covers m exactly.
+func (s *State) MatchSelected(m Match) bool {
+	start, end, ok := s.SelectionRange()
+	return ok && start == m.Start && end == m.End && s.cellOrdinal(m.Start.Block) == m.cell
+}
+
+// SelectMatch selects m, moving into its cell first when it is in a table.
+func (s *State) SelectMatch(m Match) {
+	if m.InCell {
+		s.SelectCell(m.Start.Block, m.Row, m.Col)
+	}
+	s.SelectRange(m.Start, m.End)
+}
+
+func matchAfter(matches []Match, p Position, cell int) int {
+	if len(matches) == 0 {
+		return -1
+	}
+	i := sort.Search(len(matches), func(i int) bool { return compareMatchPos(matches[i].Start, matches[i].cell, p, cell) >= 0 })
+	if i == len(matches) {
+		return 0
+	}
+	return i
+}
+
+func matchBefore(matches []Match, p Position, cell int) int {
+	if len(matches) == 0 {
+		return -1
+	}
+	i := sort.Search(len(matches), func(i int) bool { return compareMatchPos(matches[i].End, matches[i].cell, p, cell) > 0 })
+	if i == 0 {
+		return len(matches) - 1
+	}
+	return i - 1
+}
+
+// compareMatchPos orders positions by block, then by cell within a table,
+// then by byte.
+func compareMatchPos(a Position, aCell int, b Position, bCell int) int {
+	if a.Block != b.Block || aCell == bCell {
+		return comparePos(a, b)
+	}
+	if aCell < bCell {
+		return -1
+	}
+	return 1
+}
+
+// cellOrdinal is the search order of the cell being edited in block i, or 0
+// when i is not a table.
+func (s *State) cellOrdinal(i int) int {
+	if !s.IsTable(i) {
+		return 0
+	}
+	row, col := s.ActiveCell(i)
+	return row*len(s.Doc.Blocks[i].Table.Columns) + col + 1
+}
+
+// SelectRange selects from start to end with the caret at end.
+func (s *State) SelectRange(start, end Position) {
+	s.Normalize()
+	s.selectionAnchor = s.clampPosition(start)
+	s.selectionAnchored = true
+	end = s.clampPosition(end)
+	s.CurrentBlock = end.Block
+	s.CaretByte = end.Byte
+	s.selectionIsVisible = comparePos(s.selectionAnchor, end) != 0
+}
+
+// ReplaceMatch replaces m, which must come from FindAll on the current
+// document, with text. The replacement takes the style at the start of the
+// match and the caret ends up after it.
+func (s *State) ReplaceMatch(m Match, text string) error {
+	s.SelectMatch(m)
+	s.DeleteSelection()
+	return s.InsertTextAtCaret(text)
+}
+
+// ReplaceAll replaces every match of q with the expanded replacement and
+// returns how many were replaced.
+func (s *State) ReplaceAll(q *Search, replacement string) (int, error) {
+	matches := s.FindAll(q)
+	// Working backwards keeps the positions of earlier matches valid.
+	for i := len(matches) - 1; i >= 0; i-- {
+		if err := s.ReplaceMatch(matches[i], q.Expand(matches[i], replacement)); err != nil {
+			return len(matches) - 1 - i, err
+		}
+	}
+	return len(matches), nil
+}
+
+// searchText is the document flattened for searching, with a map back from
+// offsets in text to block positions.
+type searchText struct {
+	text []byte
+	segs []searchSegment
+	end  Position
+	// in is the cell end is in.
+	in searchCell
+}
+
+// searchCell is a table cell in search order; cell is 0 outside tables.
+type searchCell struct {
+	cell, row, col int
+}
+
+// searchSegment maps text[at:at+n] to the block bytes starting at pos. An
+// image segment stands for a whole token and a break segment for the
+// newline joining two blocks or cells. A fence is a break into or out of a
+// table cell, which no match may cover.
+type searchSegment struct {
+	at    int
+	n     int
+	pos   Position
+	in    searchCell
+	image bool
+	brk   bool
+	fence bool
+}
+
+func (s *State) searchText() searchText {
+	var st searchText
+	for i, b := range s.Doc.Blocks {
+		if i > 0 {
+			st.add([]byte("\n"), st.end, searchSegment{brk: true, in: st.in, fence: st.in.cell > 0})
+		}
+		if b.Kind != sqdoc.BlockKindTable || b.Table == nil {
+			var text []byte
+			if b.Text != nil {
+				text = b.Text.UTF8
+			}
+			st.addText(text, i, searchCell{})
+			continue
+		}
+		first := true
+		for r, row := range b.Table.Rows {
+			for c, cell := range row {
+				if b.Table.Covered(r, c) {
+					continue
+				}
+				if !first || i > 0 {
+					st.add([]byte("\n"), st.end, searchSegment{brk: true, in: st.in, fence: true})
+				}
+				first = false
+				var text []byte
+				if cell.Text != nil {
+					text = cell.Text.UTF8
+				}
+				st.addText(text, i, searchCell{cell: r*len(b.Table.Columns) + c + 1, row: r, col: c})
+			}
+		}
+		if first {
+			st.end, st.in = Position{Block: i}, searchCell{}
+		}
+	}
+	return st
+}
+
+// addText adds the text of paragraph i, or of one of its cells.
+func (st *searchText) addText(text []byte, i int, in searchCell) {
+	last := 0
+	for _, tok := range sqdoc.ParseImageTokens(text) {
+		st.add(text[last:tok.Start], Position{Block: i, Byte: last}, searchSegment{in: in})
+		st.add([]byte(imagePlaceholder), Position{Block: i, Byte: tok.Start}, searchSegment{in: in, image: true})
+		last = tok.End
+	}
+	st.add(text[last:], Position{Block: i, Byte: last}, searchSegment{in: in})
+	st.end, st.in = Position{Block: i, Byte: len(text)}, in
+}
+
+func (st *searchText) add(text []byte, pos Position, seg searchSegment) {
+	if len(text) == 0 {
+		return
+	}
+	seg.at, seg.n, seg.pos = len(st.text), len(text), pos
+	st.segs = append(st.segs, seg)
+	st.text = append(st.text, text...)
+}
+
+// position maps a match boundary in text to a document position and the
+// cell it is in. A boundary at an image maps to the start of its token and
+// one at a break to the end of the block or cell before it.
+func (st *searchText) position(off int) (Position, searchCell) {
+	i := sort.Search(len(st.segs), func(i int) bool { return st.segs[i].at+st.segs[i].n > off })
+	if i == len(st.segs) {
+		return st.end, st.in
+	}
+	seg := st.segs[i]
+	if seg.image || seg.brk {
+		return seg.pos, seg.in
+	}
+	return Position{Block: seg.pos.Block, Byte: seg.pos.Byte + off - seg.at}, seg.in
+}
+
+// blocked reports whether text[start:end] covers part of an image or a
+// break into or out of a cell.
+func (st *searchText) blocked(start, end int) bool {
+	for _, seg := range st.segs {
+		if (seg.image || seg.fence) && seg.at < end && seg.at+seg.n > start {
+			return true
+		}
+	}
+	return false
+}
+
+// isWordEdge reports whether text[start:end] is neither preceded nor
+// followed by a word character.
+func isWordEdge(text []byte, start, end int) bool {
+	if r, _ := utf8.DecodeLastRune(text[:start]); start > 0 && isWordRune(r) {
+		return false
+	}
+	if r, _ := utf8.DecodeRune(text[end:]); end < len(text) && isWordRune(r) {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,169 @@
+package editor
+
+import (
+	"strings"
+	"testing"
+
+	"sqdoc/pkg/sqdoc"
+)
+
+func searchState(t *testing.T, text string) *State {
+	t.Helper()
+	s := NewState(sqdoc.NewDocument("", ""))
+	if err := s.InsertTextAtCaret(text); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func mustCompile(t *testing.T, query string, opts SearchOptions) *Search {
+	t.Helper()
+	q, err := CompileSearch(query, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return q
+}
+
+func TestFindAllOptions(t *testing.T) {
+	s := searchState(t, "Cat scatter cat\ncat.")
+	cases := []struct {
+		query string
+		opts  SearchOptions
+		want  int
+	}{
+		{"cat", SearchOptions{}, 4},
+		{"cat", SearchOptions{CaseSensitive: true}, 3},
+		{"cat", SearchOptions{WholeWord: true}, 3},
+		{"c.t", SearchOptions{}, 0},
+		{"c.t", SearchOptions{Regexp: true}, 4},
+		{"^cat", SearchOptions{Regexp: true}, 2},
+		{"(", SearchOptions{}, 0},
+	}
+	for _, c := range cases {
+		if got := len(s.FindAll(mustCompile(t, c.query, c.opts))); got != c.want {
+			t.Errorf("%q %+v: %d matches, want %d", c.query, c.opts, got, c.want)
+		}
+	}
+	if _, err := CompileSearch("(", SearchOptions{Regexp: true}); err == nil {
+		t.Fatal("invalid regexp compiled")
+	}
+}
+
+func TestFindAcrossBlocks(t *testing.T) {
+	s := searchState(t, "one two\n\nthree")
+	m := s.FindAll(mustCompile(t, `two\n\nth`, SearchOptions{Regexp: true}))
+	if len(m) != 1 {
+		t.Fatalf("got %d matches", len(m))
+	}
+	if m[0].Start != (Position{Block: 0, Byte: 4}) || m[0].End != (Position{Block: 2, Byte: 2}) {
+		t.Fatalf("match = %+v..%+v", m[0].Start, m[0].End)
+	}
+	if err := s.ReplaceMatch(m[0], "2 3"); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(s.AllBlockTexts(), "|"); got != "one 2 3ree" {
+		t.Fatalf("blocks = %q", got)
+	}
+}
+
+func TestFindSkipsImageTokens(t *testing.T) {
+	img := sqdoc.MakeImageToken("/tmp/cat.png", 10, 10)
+	s := searchState(t, "a cat "+img+" cat")
+	m := s.FindAll(mustCompile(t, "cat", SearchOptions{}))
+	if len(m) != 2 {
+		t.Fatalf("got %d matches, want the two outside the image", len(m))
+	}
+	if m[1].Start.Byte != len("a cat "+img+" ") {
+		t.Fatalf("second match at %d", m[1].Start.Byte)
+	}
+	if got := len(s.FindAll(mustCompile(t, "cat .* cat", SearchOptions{Regexp: true}))); got != 0 {
+		t.Fatalf("match across an image: %d", got)
+	}
+	if got := len(s.FindAll(mustCompile(t, `cat\b`, SearchOptions{Regexp: true}))); got != 2 {
+		t.Fatalf("word boundary before an image: %d", got)
+	}
+}
+
+func TestReplaceAllExpandsGroups(t *testing.T) {
+	s := searchState(t, "2024-01-05 and 1999-12-31\nnone")
+	q := mustCompile(t, `(\d{4})-(\d\d)-(?P<day>\d\d)`, SearchOptions{Regexp: true})
+	n, err := s.ReplaceAll(q, "${day}/$2/$1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Fatalf("replaced %d", n)
+	}
+	if got := strings.Join(s.AllBlockTexts(), "|"); got != "05/01/2024 and 31/12/1999|none" {
+		t.Fatalf("blocks = %q", got)
+	}
+
+	lit := mustCompile(t, "and", SearchOptions{})
+	if _, err := s.ReplaceAll(lit, "$1"); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.AllBlockTexts()[0]; got != "05/01/2024 $1 31/12/1999" {
+		t.Fatalf("literal replacement = %q", got)
+	}
+}
+
+func TestMatchNavigationWraps(t *testing.T) {
+	s := searchState(t, "ab ab ab")
+	m := s.FindAll(mustCompile(t, "ab", SearchOptions{}))
+	if i := MatchAfter(m, Position{Byte: 1}); i != 1 {
+		t.Fatalf("after = %d", i)
+	}
+	if i := MatchAfter(m, Position{Byte: 7}); i != 0 {
+		t.Fatalf("after wrap = %d", i)
+	}
+	if i := MatchBefore(m, Position{Byte: 5}); i != 1 {
+		t.Fatalf("before = %d", i)
+	}
+	if i := MatchBefore(m, Position{Byte: 1}); i != 2 {
+		t.Fatalf("before wrap = %d", i)
+	}
+}
+
+func TestFindInTableCells(t *testing.T) {
+	s := searchState(t, "cat")
+	s.InsertTable(1, 2)
+	s.SetCaretInCell(1, 0, 0, 0)
+	_ = s.InsertTextAtCaret("a cat")
+	s.SetCaretInCell(1, 0, 1, 0)
+	_ = s.InsertTextAtCaret("cat")
+
+	m := s.FindAll(mustCompile(t, "cat", SearchOptions{}))
+	if len(m) != 3 {
+		t.Fatalf("got %d matches, want 3", len(m))
+	}
+	if !m[1].InCell || m[1].Row != 0 || m[1].Col != 0 || m[1].Start.Byte != 2 {
+		t.Fatalf("first cell match = %+v", m[1])
+	}
+	if !m[2].InCell || m[2].Col != 1 {
+		t.Fatalf("second cell match = %+v", m[2])
+	}
+	if got := len(s.FindAll(mustCompile(t, `cat\na`, SearchOptions{Regexp: true}))); got != 0 {
+		t.Fatalf("%d matches ran into a cell", got)
+	}
+	if got := len(s.FindAll(mustCompile(t, `^cat$`, SearchOptions{Regexp: true}))); got != 2 {
+		t.Fatalf("^cat$ matched %d times, want 2", got)
+	}
+
+	s.SetCaretInCell(1, 0, 0, 0)
+	if i := s.MatchAfterCaret(m, Position{Block: 1, Byte: 3}); i != 2 {
+		t.Fatalf("after caret in first cell = %d, want 2", i)
+	}
+	s.SelectMatch(m[2])
+	if !s.MatchSelected(m[2]) || s.MatchSelected(m[1]) {
+		t.Fatal("SelectMatch did not move into the second cell")
+	}
+
+	if n, err := s.ReplaceAll(mustCompile(t, "cat", SearchOptions{}), "dog"); err != nil || n != 3 {
+		t.Fatalf("ReplaceAll = %d, %v", n, err)
+	}
+	cells := s.Doc.Blocks[1].Table.Rows[0]
+	if string(cells[0].Text.UTF8) != "a dog" || string(cells[1].Text.UTF8) != "dog" || s.AllBlockTexts()[0] != "dog" {
+		t.Fatalf("cells = %q, %q", cells[0].Text.UTF8, cells[1].Text.UTF8)
+	}
+}
@@ -1,6 +1,7 @@
 package editor
 
 import (
+	"bytes"
 	"fmt"
 	"sort"
 	"strings"
@@ -22,10 +23,17 @@ type State struct {
 	ZoomPercent  int
 	UIScale      int
 	HelpVisible  bool
+	// Suggesting records edits as revisions by Author instead of making
+	// them; see revisions.go.
+	Suggesting bool
+	Author     string
 
 	selectionAnchor    Position
 	selectionAnchored  bool
 	selectionIsVisible bool
+
+	// cells maps table block IDs to the cell the caret edits in each.
+	cells map[uint64]cellPos
 }
 
 func NewState(doc *sqdoc.Document) *State {
@@ -39,14 +47,15 @@ func NewState(doc *sqdoc.Document) *State {
 
 func (s *State) Normalize() {
 	s.ensureDocument()
+	s.arrangeNotes()
 	for i := range s.Doc.Blocks {
-		if s.Doc.Blocks[i].Kind != sqdoc.BlockKindText {
-			continue
-		}
-		if s.Doc.Blocks[i].Text == nil {
-			s.Doc.Blocks[i].Text = &sqdoc.TextBlock{}
+		switch s.Doc.Blocks[i].Kind {
+		case sqdoc.BlockKindText, sqdoc.BlockKindNote:
+			s.text(i)
+			s.sanitizeBlockRuns(i)
+		case sqdoc.BlockKindTable:
+			s.normalizeTable(i)
 		}
-		s.sanitizeBlockRuns(i)
 	}
 	if len(s.Doc.Blocks) == 0 {
 		s.Doc.Blocks = append(s.Doc.Blocks, sqdoc.Block{
@@ -62,6 +71,9 @@ func (s *State) Normalize() {
 		s.CurrentBlock = len(s.Doc.Blocks) - 1
 	}
 	s.CaretByte = clampToRuneBoundary(s.CurrentBlockText(), s.CaretByte)
+	s.clampBookmarks()
+	s.clampComments()
+	s.clampRevisions()
 	if s.selectionAnchored {
 		s.selectionAnchor = s.clampPosition(s.selectionAnchor)
 		s.selectionIsVisible = comparePos(s.selectionAnchor, s.caretPos()) != 0
@@ -77,7 +89,7 @@ func (s *State) BlockCount() int {
 
 func (s *State) AddTextBlock(text string) uint64 {
 	s.ensureDocument()
-	id := nextBlockID(s.Doc.Blocks)
+	id := s.nextBlockID()
 	attr := s.currentStyleAttr()
 	tb := &sqdoc.TextBlock{UTF8: []byte(text)}
 	if len(tb.UTF8) == 0 {
@@ -134,7 +146,7 @@ func (s *State) SetCaret(block, bytePos int) {
 	if block >= len(s.Doc.Blocks) {
 		block = len(s.Doc.Blocks) - 1
 	}
-	txt := blockText(s.Doc.Blocks[block])
+	txt := s.text(block).UTF8
 	bytePos = clampToRuneBoundary(txt, bytePos)
 	s.CurrentBlock = block
 	s.CaretByte = bytePos
@@ -151,10 +163,7 @@ func (s *State) MoveCaretLeft() {
 	s.Normalize()
 	text := s.CurrentBlockText()
 	if s.CaretByte <= 0 {
-		if s.CurrentBlock > 0 {
-			s.CurrentBlock--
-			s.CaretByte = len(s.CurrentBlockText())
-		}
+		s.caretToPrevious()
 		return
 	}
 	_, size := utf8.DecodeLastRune(text[:s.CaretByte])
@@ -169,10 +178,7 @@ func (s *State) MoveCaretRight() {
 	s.Normalize()
 	text := s.CurrentBlockText()
 	if s.CaretByte >= len(text) {
-		if s.CurrentBlock < len(s.Doc.Blocks)-1 {
-			s.CurrentBlock++
-			s.CaretByte = 0
-		}
+		s.caretToNext()
 		return
 	}
 	_, size := utf8.DecodeRune(text[s.CaretByte:])
@@ -187,10 +193,7 @@ func (s *State) MoveCaretWordLeft() {
 	s.Normalize()
 	text := s.CurrentBlockText()
 	if s.CaretByte <= 0 {
-		if s.CurrentBlock > 0 {
-			s.CurrentBlock--
-			s.CaretByte = len(s.CurrentBlockText())
-		}
+		s.caretToPrevious()
 		return
 	}
 	pos := s.CaretByte
@@ -221,10 +224,7 @@ func (s *State) MoveCaretWordRight() {
 	s.Normalize()
 	text := s.CurrentBlockText()
 	if s.CaretByte >= len(text) {
-		if s.CurrentBlock < len(s.Doc.Blocks)-1 {
-			s.CurrentBlock++
-			s.CaretByte = 0
-		}
+		s.caretToNext()
 		return
 	}
 	pos := s.CaretByte
@@ -251,14 +251,21 @@ func (s *State) MoveCaretWordRight() {
 	s.CaretByte = clampToRuneBoundary(text, pos)
 }
 
+// MoveCaretToLineStart moves the caret to the start of its paragraph, or of
+// its line in text that keeps line breaks, like code and table cells.
 func (s *State) MoveCaretToLineStart() {
 	s.Normalize()
-	s.CaretByte = 0
+	s.CaretByte = lineStart(s.CurrentBlockText(), s.CaretByte)
 }
 
 func (s *State) MoveCaretToLineEnd() {
 	s.Normalize()
-	s.CaretByte = len(s.CurrentBlockText())
+	text := s.CurrentBlockText()
+	if n := bytes.IndexByte(text[s.CaretByte:], '\n'); n >= 0 {
+		s.CaretByte += n
+		return
+	}
+	s.CaretByte = len(text)
 }
 
 func (s *State) InsertTextAtCaret(input string) error {
@@ -279,10 +286,16 @@ func (s *State) InsertTextAtCaret(input string) error {
 	text := s.CurrentBlockText()
 	pos := clampToRuneBoundary(text, s.CaretByte)
 	insertAttr := s.currentStyleAttr()
+	insertAttr.Link = s.linkInside(s.CurrentBlock, pos)
+	insertAttr.Ref = sqdoc.CrossRef{}
+	insertAttr.Note = 0
+	insertAttr.Revision = s.insertRevision(s.CurrentBlock, pos)
 	parts := strings.Split(input, "\n")
-	if len(parts) == 1 {
-		s.replaceRangeInBlock(s.CurrentBlock, pos, pos, []byte(parts[0]), insertAttr)
-		s.CaretByte = pos + len(parts[0])
+	// A cell or note is a single paragraph and a code block keeps its lines,
+	// so line breaks stay in their text.
+	if len(parts) == 1 || s.IsTable(s.CurrentBlock) || s.IsNote(s.CurrentBlock) || s.IsCode(s.CurrentBlock) {
+		s.replaceRangeInBlock(s.CurrentBlock, pos, pos, []byte(input), insertAttr)
+		s.CaretByte = pos + len(input)
 		s.ClearSelection()
 		return nil
 	}
@@ -290,10 +303,22 @@ func (s *State) InsertTextAtCaret(input string) error {
 	oldText := append([]byte(nil), s.CurrentBlockText()...)
 	rightText := append([]byte(nil), oldText[pos:]...)
 	rightRuns := s.clipBlockRuns(s.CurrentBlock, pos, len(oldText), 0)
+	rightMarks := s.bookmarksFrom(s.CurrentBlock, pos)
+	rightComments := s.commentsFrom(s.CurrentBlock, pos)
 
 	s.replaceRangeInBlock(s.CurrentBlock, pos, len(oldText), []byte(parts[0]), insertAttr)
+	// The block's own break now ends the last new paragraph, and the new
+	// breaks are part of the insertion.
+	lastBreak := s.text(s.CurrentBlock).Break
+	s.text(s.CurrentBlock).Break = insertAttr.Revision
 
 	insertAt := s.CurrentBlock
+	// New list items continue the numbering rather than restart it, and
+	// only the first paragraph keeps a page break.
+	list := s.text(s.CurrentBlock).List
+	list.Start = 0
+	para := s.text(s.CurrentBlock).Para
+	para.PageBreakBefore = false
 	for i := 1; i < len(parts); i++ {
 		segText := []byte(parts[i])
 		segRuns := []sqdoc.StyleRun{}
@@ -317,13 +342,22 @@ func (s *State) InsertTextAtCaret(input string) error {
 			segRuns = sanitizeRuns(len(segText), mergedRuns)
 		}
 
-		newID := nextBlockID(s.Doc.Blocks)
-		newBlock := sqdoc.Block{ID: newID, Kind: sqdoc.BlockKindText, Text: &sqdoc.TextBlock{UTF8: segText, Runs: segRuns}}
+		brk := insertAttr.Revision
+		if i == len(parts)-1 {
+			brk = lastBreak
+		}
+		newID := s.nextBlockID()
+		newBlock := sqdoc.Block{ID: newID, Kind: sqdoc.BlockKindText, Text: &sqdoc.TextBlock{UTF8: segText, Runs: segRuns, Style: s.text(s.CurrentBlock).Style, Para: para, List: list, Break: brk}}
 		s.Doc.Blocks = append(s.Doc.Blocks, sqdoc.Block{})
 		copy(s.Doc.Blocks[insertAt+2:], s.Doc.Blocks[insertAt+1:])
 		s.Doc.Blocks[insertAt+1] = newBlock
 		insertAt++
 	}
+	for k, off := range rightMarks {
+		s.Doc.Bookmarks[k].BlockID = s.Doc.Blocks[insertAt].ID
+		s.Doc.Bookmarks[k].Offset = uint32(off + len(parts[len(parts)-1]))
+	}
+	s.placeComments(rightComments, insertAt, len(parts[len(parts)-1]))
 
 	s.CurrentBlock = insertAt
 	s.CaretByte = len(parts[len(parts)-1])
@@ -331,7 +365,19 @@ func (s *State) InsertTextAtCaret(input string) error {
 	return nil
 }
 
+// SplitBlockAtCaret starts a new paragraph at the caret. Enter on an empty
+// list item ends the list instead, unless suggesting, and in a code block
+// starts a new line.
 func (s *State) SplitBlockAtCaret() {
+	s.Normalize()
+	if tb := s.text(s.CurrentBlock); !s.HasSelection() && len(tb.UTF8) == 0 && tb.List.ID != 0 && !s.suggesting(s.CurrentBlock) {
+		tb.List = sqdoc.ListItem{}
+		return
+	}
+	if s.IsCode(s.CurrentBlock) && !s.HasSelection() {
+		s.splitCodeLine()
+		return
+	}
 	_ = s.InsertTextAtCaret("\n")
 }
 
@@ -344,17 +390,42 @@ func (s *State) Backspace() {
 	text := s.CurrentBlockText()
 	if s.CaretByte > 0 {
 		start := previousRuneBoundary(text, s.CaretByte)
+		if s.suggesting(s.CurrentBlock) {
+			s.suggestDeletion(s.CurrentBlock, start, s.CaretByte, 0)
+			s.CaretByte = start
+			return
+		}
 		insertAttr := s.styleAt(s.CurrentBlock, start)
 		s.replaceRangeInBlock(s.CurrentBlock, start, s.CaretByte, nil, insertAttr)
 		s.CaretByte = start
 		return
 	}
 
+	// Suggestions leave paragraph formatting alone and delete the break
+	// before the paragraph.
+	if s.suggesting(s.CurrentBlock) {
+		s.suggestJoinAtCaret(false)
+		return
+	}
+	// At the start of a list item, Backspace first takes it out of the list,
+	// and at the start of a code block turns it back into paragraphs.
+	if tb := s.text(s.CurrentBlock); tb.List.ID != 0 {
+		tb.List = sqdoc.ListItem{}
+		return
+	}
+	if s.IsCode(s.CurrentBlock) {
+		s.splitCode(s.CurrentBlock)
+		return
+	}
 	if s.CurrentBlock == 0 {
 		return
 	}
+	if !s.joinable(s.CurrentBlock-1, s.CurrentBlock) {
+		s.dropEmptyBeside(-1)
+		return
+	}
 	oldIdx := s.CurrentBlock
-	prevLen := len(blockText(s.Doc.Blocks[oldIdx-1]))
+	prevLen := len(s.text(oldIdx - 1).UTF8)
 	s.mergeBlocks(oldIdx-1, oldIdx)
 	s.CurrentBlock--
 	s.CaretByte = prevLen
@@ -369,6 +440,10 @@ func (s *State) DeleteForward() {
 	text := s.CurrentBlockText()
 	if s.CaretByte < len(text) {
 		end := nextRuneBoundary(text, s.CaretByte)
+		if s.suggesting(s.CurrentBlock) {
+			s.CaretByte = s.suggestDeletion(s.CurrentBlock, s.CaretByte, end, 0)
+			return
+		}
 		insertAttr := s.styleAt(s.CurrentBlock, s.CaretByte)
 		s.replaceRangeInBlock(s.CurrentBlock, s.CaretByte, end, nil, insertAttr)
 		return
@@ -377,6 +452,14 @@ func (s *State) DeleteForward() {
 	if s.CurrentBlock >= len(s.Doc.Blocks)-1 {
 		return
 	}
+	if s.suggesting(s.CurrentBlock) {
+		s.suggestJoinAtCaret(true)
+		return
+	}
+	if !s.joinable(s.CurrentBlock, s.CurrentBlock+1) {
+		s.dropEmptyBeside(1)
+		return
+	}
 	s.mergeBlocks(s.CurrentBlock, s.CurrentBlock+1)
 }
 
@@ -388,9 +471,13 @@ func (s *State) DeleteWordBackward() {
 
 	text := s.CurrentBlockText()
 	if s.CaretByte == 0 {
-		if s.CurrentBlock > 0 {
+		if s.suggesting(s.CurrentBlock) {
+			s.suggestJoinAtCaret(false)
+		} else if !s.joinable(s.CurrentBlock-1, s.CurrentBlock) {
+			s.dropEmptyBeside(-1)
+		} else {
 			oldIdx := s.CurrentBlock
-			prevLen := len(blockText(s.Doc.Blocks[oldIdx-1]))
+			prevLen := len(s.text(oldIdx - 1).UTF8)
 			s.mergeBlocks(oldIdx-1, oldIdx)
 			s.CurrentBlock--
 			s.CaretByte = prevLen
@@ -399,6 +486,11 @@ func (s *State) DeleteWordBackward() {
 	}
 
 	start := previousWordBoundary(text, s.CaretByte)
+	if s.suggesting(s.CurrentBlock) {
+		s.suggestDeletion(s.CurrentBlock, start, s.CaretByte, 0)
+		s.CaretByte = start
+		return
+	}
 	insertAttr := s.styleAt(s.CurrentBlock, start)
 	s.replaceRangeInBlock(s.CurrentBlock, start, s.CaretByte, nil, insertAttr)
 	s.CaretByte = start
@@ -412,13 +504,21 @@ func (s *State) DeleteWordForward() {
 
 	text := s.CurrentBlockText()
 	if s.CaretByte >= len(text) {
-		if s.CurrentBlock < len(s.Doc.Blocks)-1 {
+		if s.suggesting(s.CurrentBlock) {
+			s.suggestJoinAtCaret(true)
+		} else if !s.joinable(s.CurrentBlock, s.CurrentBlock+1) {
+			s.dropEmptyBeside(1)
+		} else {
 			s.mergeBlocks(s.CurrentBlock, s.CurrentBlock+1)
 		}
 		return
 	}
 
 	end := nextWordBoundary(text, s.CaretByte)
+	if s.suggesting(s.CurrentBlock) {
+		s.CaretByte = s.suggestDeletion(s.CurrentBlock, s.CaretByte, end, 0)
+		return
+	}
 	insertAttr := s.styleAt(s.CurrentBlock, s.CaretByte)
 	s.replaceRangeInBlock(s.CurrentBlock, s.CaretByte, end, nil, insertAttr)
 }
@@ -522,7 +622,7 @@ func (s *State) BlockRuns(index int) []sqdoc.StyleRun {
 	if s.Doc == nil || index < 0 || index >= len(s.Doc.Blocks) {
 		return nil
 	}
-	tb := s.Doc.Blocks[index].Text
+	tb := s.text(index)
 	if tb == nil {
 		return nil
 	}
@@ -536,11 +636,7 @@ func (s *State) CurrentBlockText() []byte {
 	if s.Doc == nil || s.CurrentBlock < 0 || s.CurrentBlock >= len(s.Doc.Blocks) {
 		return nil
 	}
-	block := &s.Doc.Blocks[s.CurrentBlock]
-	if block.Text == nil {
-		block.Text = &sqdoc.TextBlock{}
-	}
-	return block.Text.UTF8
+	return s.text(s.CurrentBlock).UTF8
 }
 
 func (s *State) HasSelection() bool {
@@ -590,11 +686,15 @@ func (s *State) SelectAll() {
 	if len(s.Doc.Blocks) == 0 {
 		return
 	}
-	s.selectionAnchor = Position{Block: 0, Byte: 0}
+	// In a note it selects the note's text, and elsewhere the body.
+	first, last := 0, s.bodyEnd()-1
+	if s.IsNote(s.CurrentBlock) {
+		first, last = s.CurrentBlock, s.CurrentBlock
+	}
+	s.selectionAnchor = Position{Block: first, Byte: 0}
 	s.selectionAnchored = true
-	last := len(s.Doc.Blocks) - 1
 	s.CurrentBlock = last
-	s.CaretByte = len(blockText(s.Doc.Blocks[last]))
+	s.CaretByte = len(s.text(last).UTF8)
 	s.selectionIsVisible = comparePos(s.selectionAnchor, s.caretPos()) != 0
 }
 
@@ -604,23 +704,185 @@ func (s *State) SelectedText() string {
 		return ""
 	}
 	if start.Block == end.Block {
-		b := blockText(s.Doc.Blocks[start.Block])
+		b := s.text(start.Block).UTF8
 		return string(b[start.Byte:end.Byte])
 	}
 
+	// Tables are taken whole, as tab-separated rows.
 	var out strings.Builder
-	first := blockText(s.Doc.Blocks[start.Block])
-	out.Write(first[start.Byte:])
-	for i := start.Block + 1; i < end.Block; i++ {
-		out.WriteByte('\n')
-		out.Write(blockText(s.Doc.Blocks[i]))
-	}
-	out.WriteByte('\n')
-	last := blockText(s.Doc.Blocks[end.Block])
-	out.Write(last[:end.Byte])
+	for i := start.Block; i <= end.Block; i++ {
+		if i > start.Block {
+			out.WriteByte('\n')
+		}
+		if s.IsTable(i) {
+			out.WriteString(tablePlainText(s.Doc.Blocks[i].Table))
+			continue
+		}
+		text := s.text(i).UTF8
+		from, to := 0, len(text)
+		if i == start.Block {
+			from = start.Byte
+		}
+		if i == end.Block {
+			to = end.Byte
+		}
+		out.Write(text[from:to])
+	}
 	return out.String()
 }
 
+// SelectedBlocks returns copies of the selected text with its style runs,
+// one block per paragraph, for rich clipboard formats. The notes its
+// anchors refer to follow as note blocks; notes the selection runs into
+// are left out, and text selected in a single note comes as a paragraph.
+func (s *State) SelectedBlocks() []sqdoc.Block {
+	start, end, ok := s.SelectionRange()
+	if !ok {
+		return nil
+	}
+	var out []sqdoc.Block
+	for i := start.Block; i <= end.Block; i++ {
+		if s.IsNote(i) && start.Block != end.Block {
+			continue
+		}
+		if s.IsTable(i) && start.Block != end.Block {
+			for _, row := range strings.Split(tablePlainText(s.Doc.Blocks[i].Table), "\n") {
+				out = append(out, sqdoc.Block{ID: uint64(len(out) + 1), Kind: sqdoc.BlockKindText, Text: &sqdoc.TextBlock{UTF8: []byte(row)}})
+			}
+			continue
+		}
+		text := s.text(i).UTF8
+		from, to := 0, len(text)
+		if i == start.Block {
+			from = start.Byte
+		}
+		if i == end.Block {
+			to = end.Byte
+		}
+		out = append(out, sqdoc.Block{
+			ID:   uint64(len(out) + 1),
+			Kind: sqdoc.BlockKindText,
+			Text: &sqdoc.TextBlock{
+				UTF8:  append([]byte(nil), text[from:to]...),
+				Runs:  withoutRevisions(sanitizeRuns(to-from, s.clipBlockRuns(i, from, to, 0))),
+				Style: s.text(i).Style,
+				Para:  s.text(i).Para,
+				List:  s.text(i).List,
+				Code:  cloneCode(s.text(i).Code),
+			},
+		})
+	}
+	return s.noteBlocks(out)
+}
+
+// InsertBlocksAtCaret pastes styled blocks at the caret, replacing any
+// selection. The first block joins the text before the caret and the last
+// joins the text after it, as with a multi-line InsertTextAtCaret. Style
+// references this document does not define are dropped, leaving the pasted
+// text formatted directly. Note blocks come with the anchors that refer to
+// them as new notes, and pasted list items form new lists.
+func (s *State) InsertBlocksAtCaret(blocks []sqdoc.Block) error {
+	var lines []string
+	for _, b := range blocks {
+		if b.Kind == sqdoc.BlockKindText && b.Text != nil {
+			lines = append(lines, string(b.Text.UTF8))
+		}
+	}
+	// A cell or note holds one paragraph, so blocks pasted into it become
+	// lines, and code takes them as plain text.
+	if ((s.IsTable(s.CurrentBlock) || s.IsNote(s.CurrentBlock)) && len(lines) > 1) || s.IsCode(s.CurrentBlock) {
+		return s.InsertTextAtCaret(strings.Join(lines, "\n"))
+	}
+	var frags []*sqdoc.TextBlock
+	for _, b := range blocks {
+		if b.Kind != sqdoc.BlockKindText || b.Text == nil {
+			continue
+		}
+		if !utf8.Valid(b.Text.UTF8) {
+			return fmt.Errorf("input must be valid UTF-8")
+		}
+		frags = append(frags, s.localStyles(b.Text))
+	}
+	if len(frags) == 0 {
+		return nil
+	}
+	s.Normalize()
+	if s.HasSelection() {
+		s.DeleteSelection()
+		s.Normalize()
+	}
+	frags = s.codeFragments(frags)
+	s.pasteNotes(blocks, frags)
+	s.pasteLists(frags)
+
+	oldText := append([]byte(nil), s.CurrentBlockText()...)
+	pos := clampToRuneBoundary(oldText, s.CaretByte)
+	leftRuns := s.clipBlockRuns(s.CurrentBlock, 0, pos, 0)
+	rightText := oldText[pos:]
+	rightRuns := s.clipBlockRuns(s.CurrentBlock, pos, len(oldText), 0)
+	rightMarks := s.bookmarksFrom(s.CurrentBlock, pos)
+	rightComments := s.commentsFrom(s.CurrentBlock, pos)
+	revision := s.insertRevision(s.CurrentBlock, pos)
+	lastBreak := s.text(s.CurrentBlock).Break
+
+	// fragment appends a pasted block's text and runs after prefix.
+	fragment := func(prefix []byte, prefixRuns []sqdoc.StyleRun, tb *sqdoc.TextBlock) ([]byte, []sqdoc.StyleRun) {
+		text := append(append([]byte(nil), prefix...), tb.UTF8...)
+		runs := append([]sqdoc.StyleRun(nil), prefixRuns...)
+		for _, r := range sanitizeRuns(len(tb.UTF8), tb.Runs) {
+			r.Start += uint32(len(prefix))
+			r.End += uint32(len(prefix))
+			r.Attr.Revision = revision
+			runs = append(runs, r)
+		}
+		return text, runs
+	}
+	appendRight := func(text []byte, runs []sqdoc.StyleRun) ([]byte, []sqdoc.StyleRun) {
+		shift := len(text)
+		for _, r := range rightRuns {
+			runs = append(runs, sqdoc.StyleRun{Start: r.Start + uint32(shift), End: r.End + uint32(shift), Attr: r.Attr})
+		}
+		return append(text, rightText...), runs
+	}
+
+	insertAt := s.CurrentBlock
+	text, runs := fragment(oldText[:pos], leftRuns, frags[0])
+	caret := len(text)
+	if len(frags) == 1 {
+		text, runs = appendRight(text, runs)
+	}
+	s.text(insertAt).UTF8 = text
+	s.text(insertAt).Runs = sanitizeRuns(len(text), runs)
+	if len(frags) > 1 {
+		s.text(insertAt).Break = revision
+	}
+
+	for i := 1; i < len(frags); i++ {
+		text, runs := fragment(nil, nil, frags[i])
+		caret = len(text)
+		brk := revision
+		if i == len(frags)-1 {
+			text, runs = appendRight(text, runs)
+			brk = lastBreak
+		}
+		newBlock := sqdoc.Block{ID: s.nextBlockID(), Kind: sqdoc.BlockKindText, Text: &sqdoc.TextBlock{UTF8: text, Runs: sanitizeRuns(len(text), runs), Style: frags[i].Style, Para: frags[i].Para, List: frags[i].List, Break: brk}}
+		s.Doc.Blocks = append(s.Doc.Blocks, sqdoc.Block{})
+		copy(s.Doc.Blocks[insertAt+2:], s.Doc.Blocks[insertAt+1:])
+		s.Doc.Blocks[insertAt+1] = newBlock
+		insertAt++
+	}
+	for k, off := range rightMarks {
+		s.Doc.Bookmarks[k].BlockID = s.Doc.Blocks[insertAt].ID
+		s.Doc.Bookmarks[k].Offset = uint32(off + caret)
+	}
+	s.placeComments(rightComments, insertAt, caret)
+
+	s.CurrentBlock = insertAt
+	s.CaretByte = caret
+	s.ClearSelection()
+	return nil
+}
+
 func (s *State) DeleteSelection() bool {
 	start, end, ok := s.SelectionRange()
 	if !ok {
@@ -630,6 +892,10 @@ func (s *State) DeleteSelection() bool {
 		s.ClearSelection()
 		return false
 	}
+	if s.Suggesting && !s.IsTable(start.Block) {
+		s.suggestDeleteSelection(start, end)
+		return true
+	}
 
 	if start.Block == end.Block {
 		insertAttr := s.styleAt(start.Block, start.Byte)
@@ -639,13 +905,26 @@ func (s *State) DeleteSelection() bool {
 		s.ClearSelection()
 		return true
 	}
+	if s.IsNote(end.Block) {
+		s.deleteAcrossNotes(start, end)
+		return true
+	}
 
-	leftPrefix := append([]byte(nil), blockText(s.Doc.Blocks[start.Block])[:start.Byte]...)
-	rightSuffix := append([]byte(nil), blockText(s.Doc.Blocks[end.Block])[end.Byte:]...)
+	joinable := s.joinable(start.Block, end.Block)
+	for i := start.Block + 1; i < end.Block && joinable; i++ {
+		joinable = !s.IsTable(i)
+	}
+	if !joinable {
+		s.deleteWithoutJoining(start, end)
+		return true
+	}
+
+	leftPrefix := append([]byte(nil), s.text(start.Block).UTF8[:start.Byte]...)
+	rightSuffix := append([]byte(nil), s.text(end.Block).UTF8[end.Byte:]...)
 	merged := append(leftPrefix, rightSuffix...)
 
 	leftRuns := s.clipBlockRuns(start.Block, 0, start.Byte, 0)
-	rightRuns := s.clipBlockRuns(end.Block, end.Byte, len(blockText(s.Doc.Blocks[end.Block])), start.Byte)
+	rightRuns := s.clipBlockRuns(end.Block, end.Byte, len(s.text(end.Block).UTF8), start.Byte)
 	newRuns := append(leftRuns, rightRuns...)
 	if len(merged) == 0 {
 		newRuns = []sqdoc.StyleRun{{Start: 0, End: 0, Attr: normalizeAttr(s.styleAt(start.Block, start.Byte))}}
@@ -653,8 +932,21 @@ func (s *State) DeleteSelection() bool {
 		newRuns = sanitizeRuns(len(merged), newRuns)
 	}
 
-	s.Doc.Blocks[start.Block].Text.UTF8 = merged
-	s.Doc.Blocks[start.Block].Text.Runs = newRuns
+	// Bookmarks and comment ranges in the deleted text go to where it was.
+	leftLen := len(s.text(start.Block).UTF8)
+	s.shiftBookmarks(start.Block, start.Byte, leftLen, 0)
+	s.shiftComments(start.Block, start.Byte, leftLen, start.Byte-leftLen)
+	for i := start.Block + 1; i <= end.Block; i++ {
+		at := len(s.text(i).UTF8)
+		if i == end.Block {
+			at = end.Byte
+		}
+		s.moveBookmarks(i, start.Block, at, start.Byte)
+		s.moveComments(i, start.Block, at, start.Byte)
+	}
+	s.text(start.Block).UTF8 = merged
+	s.text(start.Block).Runs = newRuns
+	s.text(start.Block).Break = s.text(end.Block).Break
 	s.Doc.Blocks = append(s.Doc.Blocks[:start.Block+1], s.Doc.Blocks[end.Block+1:]...)
 	s.CurrentBlock = start.Block
 	s.CaretByte = start.Byte
@@ -663,6 +955,33 @@ func (s *State) DeleteSelection() bool {
 	return true
 }
 
+// deleteWithoutJoining deletes a selection whose ends cannot join, because
+// it includes a table or ends in a code block. Tables it touches go whole;
+// paragraphs at its ends are trimmed but not joined.
+func (s *State) deleteWithoutJoining(start, end Position) {
+	lo, hi := start.Block, end.Block
+	if !s.IsTable(hi) {
+		s.replaceRangeInBlock(hi, 0, end.Byte, nil, s.styleAt(hi, 0))
+		hi--
+	}
+	if !s.IsTable(lo) {
+		s.replaceRangeInBlock(lo, start.Byte, len(s.text(lo).UTF8), nil, s.styleAt(lo, start.Byte))
+		lo++
+	}
+	s.Doc.Blocks = append(s.Doc.Blocks[:lo], s.Doc.Blocks[hi+1:]...)
+	s.CurrentBlock, s.CaretByte = lo, 0
+	if lo > start.Block {
+		s.CurrentBlock, s.CaretByte = start.Block, start.Byte
+	}
+	if end := s.bodyEnd(); end == 0 || s.IsTable(end-1) {
+		para := sqdoc.Block{ID: s.nextBlockID(), Kind: sqdoc.BlockKindText, Text: &sqdoc.TextBlock{}}
+		s.Doc.Blocks = append(s.Doc.Blocks[:end], append([]sqdoc.Block{para}, s.Doc.Blocks[end:]...)...)
+	}
+	s.ClearSelection()
+	s.Normalize()
+	s.enterTable(s.CurrentBlock, false)
+}
+
 func (s *State) ensureDocument() {
 	if s.Doc == nil {
 		s.Doc = sqdoc.NewDocument("", "Untitled")
@@ -673,7 +992,7 @@ func (s *State) AllBlockTexts() []string {
 	s.Normalize()
 	out := make([]string, 0, len(s.Doc.Blocks))
 	for i := range s.Doc.Blocks {
-		tb := s.Doc.Blocks[i].Text
+		tb := s.text(i)
 		if tb == nil {
 			out = append(out, "")
 			continue
@@ -700,15 +1019,30 @@ func (s *State) currentStyleAttr() sqdoc.StyleAttr {
 	return s.styleAt(s.CurrentBlock, s.CaretByte)
 }
 
+// applyStyleMutation applies direct formatting: attributes mut changes stop
+// following the styles.
 func (s *State) applyStyleMutation(mut func(*sqdoc.StyleAttr)) {
-	s.Normalize()
 	if mut == nil {
 		return
 	}
+	s.mutateSelection(func(attr *sqdoc.StyleAttr) {
+		before := *attr
+		mut(attr)
+		attr.Inherit &^= sqdoc.DiffAttrs(before, *attr)
+	})
+}
+
+// mutateSelection runs mut on the attributes of the selection, or of the
+// character at the caret when nothing is selected.
+func (s *State) mutateSelection(mut func(*sqdoc.StyleAttr)) {
+	s.Normalize()
+	if s.suggesting(s.CurrentBlock) {
+		mut = s.trackFormatting(mut)
+	}
 	if start, end, has := s.SelectionRange(); has {
 		for b := start.Block; b <= end.Block; b++ {
 			segStart := 0
-			segEnd := len(blockText(s.Doc.Blocks[b]))
+			segEnd := len(s.text(b).UTF8)
 			if b == start.Block {
 				segStart = start.Byte
 			}
@@ -737,11 +1071,7 @@ func (s *State) applyStyleToBlockRange(blockIndex, start, end int, mut func(*sqd
 	if s.Doc == nil || blockIndex < 0 || blockIndex >= len(s.Doc.Blocks) {
 		return
 	}
-	tb := s.Doc.Blocks[blockIndex].Text
-	if tb == nil {
-		tb = &sqdoc.TextBlock{}
-		s.Doc.Blocks[blockIndex].Text = tb
-	}
+	tb := s.text(blockIndex)
 	textLen := len(tb.UTF8)
 	if start < 0 {
 		start = 0
@@ -799,11 +1129,7 @@ func (s *State) replaceRangeInBlock(blockIndex, start, end int, insert []byte, i
 	if s.Doc == nil || blockIndex < 0 || blockIndex >= len(s.Doc.Blocks) {
 		return
 	}
-	tb := s.Doc.Blocks[blockIndex].Text
-	if tb == nil {
-		tb = &sqdoc.TextBlock{}
-		s.Doc.Blocks[blockIndex].Text = tb
-	}
+	tb := s.text(blockIndex)
 	text := tb.UTF8
 	start = clampToRuneBoundary(text, start)
 	end = clampToRuneBoundary(text, end)
@@ -842,7 +1168,17 @@ func (s *State) replaceRangeInBlock(blockIndex, start, end int, insert []byte, i
 	}
 
 	tb.UTF8 = newText
+	if !s.IsTable(blockIndex) {
+		s.shiftBookmarks(blockIndex, start, end, delta)
+		s.shiftComments(blockIndex, start, end, delta)
+	}
 	if len(newText) == 0 {
+		// An empty paragraph keeps the formatting for what is typed next,
+		// which never continues a link or field.
+		insertAttr.Link = ""
+		insertAttr.Ref = sqdoc.CrossRef{}
+		insertAttr.Note = 0
+		insertAttr.Revision = 0
 		tb.Runs = []sqdoc.StyleRun{{Start: 0, End: 0, Attr: normalizeAttr(insertAttr)}}
 		return
 	}
@@ -853,8 +1189,8 @@ func (s *State) mergeBlocks(left, right int) {
 	if s.Doc == nil || left < 0 || right <= left || right >= len(s.Doc.Blocks) {
 		return
 	}
-	leftText := append([]byte(nil), blockText(s.Doc.Blocks[left])...)
-	rightText := append([]byte(nil), blockText(s.Doc.Blocks[right])...)
+	leftText := append([]byte(nil), s.text(left).UTF8...)
+	rightText := append([]byte(nil), s.text(right).UTF8...)
 	leftRuns := s.clipBlockRuns(left, 0, len(leftText), 0)
 	rightRuns := s.clipBlockRuns(right, 0, len(rightText), len(leftText))
 	mergedText := append(leftText, rightText...)
@@ -864,8 +1200,11 @@ func (s *State) mergeBlocks(left, right int) {
 	} else {
 		mergedRuns = sanitizeRuns(len(mergedText), mergedRuns)
 	}
-	s.Doc.Blocks[left].Text.UTF8 = mergedText
-	s.Doc.Blocks[left].Text.Runs = mergedRuns
+	s.text(left).UTF8 = mergedText
+	s.text(left).Runs = mergedRuns
+	s.text(left).Break = s.text(right).Break
+	s.moveBookmarks(right, left, 0, len(leftText))
+	s.moveComments(right, left, 0, len(leftText))
 	s.Doc.Blocks = append(s.Doc.Blocks[:right], s.Doc.Blocks[right+1:]...)
 }
 
@@ -873,7 +1212,7 @@ func (s *State) clipBlockRuns(blockIndex, from, to, shift int) []sqdoc.StyleRun
 	if s.Doc == nil || blockIndex < 0 || blockIndex >= len(s.Doc.Blocks) {
 		return nil
 	}
-	tb := s.Doc.Blocks[blockIndex].Text
+	tb := s.text(blockIndex)
 	if tb == nil {
 		return nil
 	}
@@ -916,7 +1255,7 @@ func (s *State) styleAt(blockIndex, bytePos int) sqdoc.StyleAttr {
 	if s.Doc == nil || blockIndex < 0 || blockIndex >= len(s.Doc.Blocks) {
 		return defaultStyleAttr()
 	}
-	tb := s.Doc.Blocks[blockIndex].Text
+	tb := s.text(blockIndex)
 	if tb == nil {
 		return defaultStyleAttr()
 	}
@@ -950,21 +1289,12 @@ func (s *State) sanitizeBlockRuns(blockIndex int) {
 	if s.Doc == nil || blockIndex < 0 || blockIndex >= len(s.Doc.Blocks) {
 		return
 	}
-	tb := s.Doc.Blocks[blockIndex].Text
-	if tb == nil {
-		return
-	}
+	tb := s.text(blockIndex)
 	tb.Runs = sanitizeRuns(len(tb.UTF8), tb.Runs)
 }
 
 func (s *State) currentBlockTextRef() *sqdoc.TextBlock {
-	if s.Doc == nil || s.CurrentBlock < 0 || s.CurrentBlock >= len(s.Doc.Blocks) {
-		return &sqdoc.TextBlock{}
-	}
-	if s.Doc.Blocks[s.CurrentBlock].Text == nil {
-		s.Doc.Blocks[s.CurrentBlock].Text = &sqdoc.TextBlock{}
-	}
-	return s.Doc.Blocks[s.CurrentBlock].Text
+	return s.text(s.CurrentBlock)
 }
 
 func (s *State) caretPos() Position {
@@ -981,24 +1311,23 @@ func (s *State) clampPosition(p Position) Position {
 	if p.Block >= len(s.Doc.Blocks) {
 		p.Block = len(s.Doc.Blocks) - 1
 	}
-	p.Byte = clampToRuneBoundary(blockText(s.Doc.Blocks[p.Block]), p.Byte)
+	p.Byte = clampToRuneBoundary(s.text(p.Block).UTF8, p.Byte)
 	return p
 }
 
-func blockText(b sqdoc.Block) []byte {
-	if b.Text == nil {
-		return nil
-	}
-	return b.Text.UTF8
-}
-
-func nextBlockID(blocks []sqdoc.Block) uint64 {
+// nextBlockID returns an ID no block or comment thread uses.
+func (s *State) nextBlockID() uint64 {
 	var maxID uint64
-	for _, b := range blocks {
+	for _, b := range s.Doc.Blocks {
 		if b.ID > maxID {
 			maxID = b.ID
 		}
 	}
+	for _, t := range s.Doc.Comments {
+		if t.ID > maxID {
+			maxID = t.ID
+		}
+	}
 	return maxID + 1
 }
 
@@ -1134,7 +1463,13 @@ func attrsEqual(a, b sqdoc.StyleAttr) bool {
 		a.Highlight == b.Highlight &&
 		a.FontFamily == b.FontFamily &&
 		a.FontSizePt == b.FontSizePt &&
-		a.ColorRGBA == b.ColorRGBA
+		a.ColorRGBA == b.ColorRGBA &&
+		a.CharStyle == b.CharStyle &&
+		a.Inherit == b.Inherit &&
+		a.Link == b.Link &&
+		a.Ref == b.Ref &&
+		a.Note == b.Note &&
+		a.Revision == b.Revision
 }
 
 func isValidFontFamily(f sqdoc.FontFamily) bool {
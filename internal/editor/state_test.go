@@ -197,3 +197,46 @@ func TestToggleHighlightOnSelection(t *testing.T) {
 		t.Fatalf("expected non-selected suffix to remain unhighlighted")
 	}
 }
+
+func TestSelectedBlocksPasteKeepsRuns(t *testing.T) {
+	src := NewState(sqdoc.NewDocument("", ""))
+	if err := src.InsertTextAtCaret("plain bold\nsecond line"); err != nil {
+		t.Fatal(err)
+	}
+	src.SetCaret(0, len("plain "))
+	src.EnsureSelectionAnchor()
+	src.SetCaret(0, len("plain bold"))
+	src.UpdateSelectionFromCaret()
+	src.ToggleBold()
+	src.SetCaret(0, len("plain "))
+	src.EnsureSelectionAnchor()
+	src.SetCaret(1, len("second"))
+	src.UpdateSelectionFromCaret()
+	blocks := src.SelectedBlocks()
+	if len(blocks) != 2 || string(blocks[0].Text.UTF8) != "bold" || string(blocks[1].Text.UTF8) != "second" {
+		t.Fatalf("unexpected selection blocks: %+v", blocks)
+	}
+
+	dst := NewState(sqdoc.NewDocument("", ""))
+	if err := dst.UpdateCurrentText("[]"); err != nil {
+		t.Fatal(err)
+	}
+	dst.SetCaret(0, 1)
+	if err := dst.InsertBlocksAtCaret(blocks); err != nil {
+		t.Fatal(err)
+	}
+	if got := dst.AllBlockTexts(); len(got) != 2 || got[0] != "[bold" || got[1] != "second]" {
+		t.Fatalf("unexpected blocks after paste: %q", got)
+	}
+	if dst.CurrentBlock != 1 || dst.CaretByte != len("second") {
+		t.Fatalf("caret at %d:%d", dst.CurrentBlock, dst.CaretByte)
+	}
+	dst.SetCaret(0, 2)
+	if !dst.CurrentStyleAttr().Bold {
+		t.Fatal("expected pasted text to stay bold")
+	}
+	dst.SetCaret(1, 2)
+	if dst.CurrentStyleAttr().Bold {
+		t.Fatal("expected second pasted line to stay plain")
+	}
+}
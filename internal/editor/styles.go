@@ -0,0 +1,151 @@
+package editor
+
+import "sqdoc/pkg/sqdoc"
+
+// Styles returns the document's style sheet, or the built-in one when the
+// document has not stored its own.
+func (s *State) Styles() sqdoc.StyleSheet {
+	s.ensureDocument()
+	return s.Doc.StyleSheet()
+}
+
+// ParagraphStyle returns the paragraph style of the current block, with 0
+// resolved to the sheet's default.
+func (s *State) ParagraphStyle() uint32 {
+	s.Normalize()
+	if id := s.text(s.CurrentBlock).Style; id != 0 {
+		return id
+	}
+	return s.Styles().Default
+}
+
+// CharacterStyle returns the character style at the caret, or 0.
+func (s *State) CharacterStyle() uint32 {
+	return s.currentStyleAttr().CharStyle
+}
+
+// SetParagraphStyle gives the selected paragraphs, or the current one, the
+// paragraph style id. Formatting that is uniform across a paragraph or
+// matches its old style is replaced by the new style; formatting that only
+// covers part of it, such as a bold word, is kept. Nothing changes while
+// suggesting.
+func (s *State) SetParagraphStyle(id uint32) {
+	s.Normalize()
+	sheet := s.Styles()
+	if !sheet.CheckRef(id, sqdoc.StyleKindParagraph) || s.suggestingParagraphs() {
+		return
+	}
+	first, last := s.selectedParagraphs()
+	for i := first; i <= last; i++ {
+		tb := s.text(i)
+		var varies sqdoc.AttrMask
+		for _, r := range tb.Runs {
+			varies |= sqdoc.DiffAttrs(tb.Runs[0].Attr, r.Attr)
+		}
+		for j := range tb.Runs {
+			attr := &tb.Runs[j].Attr
+			matches := sqdoc.AttrAll &^ sqdoc.DiffAttrs(*attr, sheet.Resolve(tb.Style, attr.CharStyle))
+			attr.Inherit |= (sqdoc.AttrAll &^ varies) | matches
+		}
+		tb.Style = id
+	}
+	s.Doc.ApplyStyles()
+	s.Normalize()
+}
+
+// SetCharacterStyle applies character style id to the selection, or to the
+// character at the caret; 0 removes the character style. Attributes the
+// style defines replace direct formatting.
+func (s *State) SetCharacterStyle(id uint32) {
+	s.Normalize()
+	sheet := s.Styles()
+	if !sheet.CheckRef(id, sqdoc.StyleKindCharacter) {
+		return
+	}
+	s.mutateSelection(func(attr *sqdoc.StyleAttr) {
+		// Attributes the old style set go back to the paragraph's.
+		attr.Inherit |= sheet.Defines(attr.CharStyle) | sheet.Defines(id)
+		attr.CharStyle = id
+	})
+	s.Doc.ApplyStyles()
+	s.Normalize()
+}
+
+// UpdateStyleFromSelection redefines style id to look like the text at the
+// start of the selection or at the caret, then restyles every paragraph and
+// run that uses it.
+func (s *State) UpdateStyleFromSelection(id uint32) {
+	s.Normalize()
+	if len(s.Doc.Styles.Styles) == 0 {
+		s.Doc.Styles = sqdoc.DefaultStyleSheet()
+	}
+	idx := -1
+	for i, st := range s.Doc.Styles.Styles {
+		if st.ID == id {
+			idx = i
+		}
+	}
+	if idx < 0 {
+		return
+	}
+	sheet := s.Doc.Styles
+	st := &s.Doc.Styles.Styles[idx]
+	attr := s.currentStyleAttr()
+	var current sqdoc.StyleAttr
+	if st.Kind == sqdoc.StyleKindParagraph {
+		current = sheet.Resolve(id, attr.CharStyle)
+	} else {
+		current = sheet.Resolve(s.text(s.CurrentBlock).Style, id)
+	}
+	changed := sqdoc.DiffAttrs(current, attr)
+	if st.Kind == sqdoc.StyleKindParagraph {
+		// What the run's character style decides is not the paragraph's.
+		changed &^= sheet.Defines(attr.CharStyle)
+	}
+	if changed == 0 {
+		return
+	}
+	st.Set |= changed
+	sqdoc.CopyAttrs(&st.Attr, attr, changed)
+
+	// Text using the style that already looks like the new definition,
+	// including the example itself, follows the style from here on.
+	for i := range s.Doc.Blocks {
+		tb := s.text(i)
+		para := tb.Style
+		if para == 0 {
+			para = sheet.Default
+		}
+		for j := range tb.Runs {
+			a := &tb.Runs[j].Attr
+			if (st.Kind == sqdoc.StyleKindParagraph && para == id) || a.CharStyle == id {
+				a.Inherit |= changed &^ sqdoc.DiffAttrs(*a, attr)
+			}
+		}
+	}
+	s.Doc.ApplyStyles()
+	s.Normalize()
+}
+
+// localStyles returns tb with the style references this document does not
+// define removed.
+func (s *State) localStyles(tb *sqdoc.TextBlock) *sqdoc.TextBlock {
+	s.ensureDocument()
+	sheet := s.Doc.StyleSheet()
+	out := &sqdoc.TextBlock{UTF8: tb.UTF8, Runs: append([]sqdoc.StyleRun(nil), tb.Runs...), Style: tb.Style, Para: tb.Para, List: tb.List, Code: cloneCode(tb.Code)}
+	paraOK := sheet.CheckRef(out.Style, sqdoc.StyleKindParagraph)
+	if !paraOK {
+		out.Style = 0
+	}
+	for i := range out.Runs {
+		attr := &out.Runs[i].Attr
+		charOK := sheet.CheckRef(attr.CharStyle, sqdoc.StyleKindCharacter)
+		if !charOK {
+			attr.CharStyle = 0
+		}
+		if !paraOK || !charOK {
+			attr.Inherit = 0
+		}
+	}
+	return out
+}
@@ -0,0 +1,103 @@
+package editor
+
+import (
+	"testing"
+
+	"sqdoc/pkg/sqdoc"
+)
+
+func TestSetParagraphStyleKeepsPartialFormatting(t *testing.T) {
+	s := searchState(t, "plain slant")
+	s.SelectRange(Position{Byte: 6}, Position{Byte: 11})
+	s.ToggleItalic()
+	s.ClearSelection()
+	s.SetColor(0xFF0000FF)
+	s.SelectAll()
+	s.SetColor(0xFF0000FF)
+	s.ClearSelection()
+
+	s.SetParagraphStyle(sqdoc.StyleHeading2)
+	for _, r := range s.BlockRuns(0) {
+		if a := r.Attr; !a.Bold || a.FontSizePt != 20 || a.ColorRGBA != 0x202020FF || a.Italic != (r.Start == 6) {
+			t.Fatalf("attr = %+v", a)
+		}
+	}
+
+	s.SetParagraphStyle(sqdoc.StyleNormal)
+	runs := s.BlockRuns(0)
+	if len(runs) != 2 || runs[1].Attr.Bold || !runs[1].Attr.Italic || runs[1].Attr.FontSizePt != 14 {
+		t.Fatalf("runs after Normal = %+v", runs)
+	}
+}
+
+func TestDirectFormattingSurvivesStyleChange(t *testing.T) {
+	s := searchState(t, "title")
+	s.SetParagraphStyle(sqdoc.StyleHeading1)
+	s.SelectAll()
+	s.SetColor(0x0057B8FF)
+	s.UpdateStyleFromSelection(sqdoc.StyleHeading1)
+	s.ClearSelection()
+	s.SplitBlockAtCaret()
+	if err := s.InsertTextAtCaret("second"); err != nil {
+		t.Fatal(err)
+	}
+	if s.ParagraphStyle() != sqdoc.StyleHeading1 {
+		t.Fatalf("split paragraph style = %d", s.ParagraphStyle())
+	}
+	s.SelectRange(Position{Block: 1}, Position{Block: 1, Byte: 6})
+	s.SetFontSize(30)
+
+	h1, _ := s.Doc.Styles.Lookup(sqdoc.StyleHeading1)
+	if h1.Attr.ColorRGBA != 0x0057B8FF || h1.Set&sqdoc.AttrColor == 0 {
+		t.Fatalf("heading 1 = %+v", h1)
+	}
+	s.SelectRange(Position{Block: 0}, Position{Block: 0, Byte: 5})
+	s.SetFontSize(28)
+	s.UpdateStyleFromSelection(sqdoc.StyleHeading1)
+	if got := s.BlockRuns(1)[0].Attr; got.FontSizePt != 30 || got.ColorRGBA != 0x0057B8FF {
+		t.Fatalf("direct size lost: %+v", got)
+	}
+	if got := s.BlockRuns(0)[0].Attr; got.FontSizePt != 28 {
+		t.Fatalf("example = %+v", got)
+	}
+}
+
+func TestCharacterStyle(t *testing.T) {
+	s := searchState(t, "say code here")
+	s.SetParagraphStyle(sqdoc.StyleQuote)
+	s.SelectRange(Position{Byte: 4}, Position{Byte: 8})
+	s.SetCharacterStyle(sqdoc.StyleCode)
+	if s.CharacterStyle() != sqdoc.StyleCode {
+		t.Fatalf("character style = %d", s.CharacterStyle())
+	}
+	runs := s.BlockRuns(0)
+	if len(runs) != 3 || runs[1].Attr.FontFamily != sqdoc.FontFamilyMonospace || !runs[1].Attr.Italic {
+		t.Fatalf("runs = %+v", runs)
+	}
+	s.SetCharacterStyle(0)
+	if runs := s.BlockRuns(0); len(runs) != 1 || runs[0].Attr.FontFamily != sqdoc.FontFamilySans {
+		t.Fatalf("runs after clearing = %+v", runs)
+	}
+	s.SetCharacterStyle(sqdoc.StyleHeading1)
+	if s.CharacterStyle() != 0 {
+		t.Fatal("paragraph style applied as a character style")
+	}
+}
+
+func TestPasteDropsUnknownStyles(t *testing.T) {
+	s := searchState(t, "")
+	attr := defaultStyleAttr()
+	attr.CharStyle = 500
+	attr.Inherit = sqdoc.AttrAll
+	s.InsertBlocksAtCaret([]sqdoc.Block{
+		{Kind: sqdoc.BlockKindText, Text: &sqdoc.TextBlock{UTF8: []byte("a")}},
+		{Kind: sqdoc.BlockKindText, Text: &sqdoc.TextBlock{UTF8: []byte("b"), Style: 400, Runs: []sqdoc.StyleRun{{Start: 0, End: 1, Attr: attr}}}},
+	})
+	tb := s.Doc.Blocks[1].Text
+	if tb.Style != 0 || tb.Runs[0].Attr.CharStyle != 0 || tb.Runs[0].Attr.Inherit != 0 {
+		t.Fatalf("pasted block = %+v", tb)
+	}
+	if errs := sqdoc.ValidateAll(s.Doc); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+}
@@ -0,0 +1,624 @@
+package editor
+
+import (
+	"strings"
+
+	"sqdoc/pkg/sqdoc"
+)
+
+// cellPos is the cell being edited in a table block.
+type cellPos struct {
+	row, col int
+}
+
+// text returns the paragraph block i holds, or for a table the cell the
+// caret is in or was last in. It never returns nil.
+func (s *State) text(i int) *sqdoc.TextBlock {
+	if s.Doc == nil || i < 0 || i >= len(s.Doc.Blocks) {
+		return &sqdoc.TextBlock{}
+	}
+	b := &s.Doc.Blocks[i]
+	if b.Kind == sqdoc.BlockKindTable {
+		if b.Table == nil {
+			return &sqdoc.TextBlock{}
+		}
+		p := s.cells[b.ID]
+		cell := b.Table.Cell(p.row, p.col)
+		if cell == nil {
+			return &sqdoc.TextBlock{}
+		}
+		if cell.Text == nil {
+			cell.Text = &sqdoc.TextBlock{}
+		}
+		return cell.Text
+	}
+	if b.Text == nil {
+		b.Text = &sqdoc.TextBlock{}
+	}
+	return b.Text
+}
+
+// IsTable reports whether block i is a table.
+func (s *State) IsTable(i int) bool {
+	return s.Doc != nil && i >= 0 && i < len(s.Doc.Blocks) && s.Doc.Blocks[i].Kind == sqdoc.BlockKindTable
+}
+
+// Table returns the table the caret is in, or nil.
+func (s *State) Table() *sqdoc.TableBlock {
+	s.Normalize()
+	if !s.IsTable(s.CurrentBlock) {
+		return nil
+	}
+	return s.Doc.Blocks[s.CurrentBlock].Table
+}
+
+// ActiveCell returns the row and column of the cell edited in table block
+// i.
+func (s *State) ActiveCell(i int) (int, int) {
+	if !s.IsTable(i) {
+		return 0, 0
+	}
+	p := s.cells[s.Doc.Blocks[i].ID]
+	return p.row, p.col
+}
+
+// SelectCell makes row, col the edited cell of table block, leaving the
+// caret where it is.
+func (s *State) SelectCell(block, row, col int) {
+	s.Normalize()
+	if s.IsTable(block) {
+		s.moveToCell(block, row, col)
+	}
+}
+
+// SetCaretInCell puts the caret at bytePos in a cell of table block.
+func (s *State) SetCaretInCell(block, row, col, bytePos int) {
+	s.SelectCell(block, row, col)
+	s.SetCaret(block, bytePos)
+}
+
+// CellRuns returns the style runs of a table cell covering all of its
+// text, like BlockRuns.
+func (s *State) CellRuns(block, row, col int) []sqdoc.StyleRun {
+	if !s.IsTable(block) {
+		return nil
+	}
+	cell := s.Doc.Blocks[block].Table.Cell(row, col)
+	if cell == nil || cell.Text == nil {
+		return nil
+	}
+	return append([]sqdoc.StyleRun(nil), coverageRuns(len(cell.Text.UTF8), cell.Text.Runs)...)
+}
+
+// moveToCell makes row, col the edited cell of table block i. Selections
+// cannot span cells, so moving to another cell drops one anchored in this
+// table.
+func (s *State) moveToCell(i, row, col int) {
+	if s.setActiveCell(i, row, col) && s.selectionAnchored && s.selectionAnchor.Block == i {
+		s.ClearSelection()
+	}
+}
+
+// setActiveCell makes the cell covering row, col the edited cell of table
+// block i and reports whether that changed it.
+func (s *State) setActiveCell(i, row, col int) bool {
+	t := s.Doc.Blocks[i].Table
+	row = min(max(row, 0), len(t.Rows)-1)
+	col = min(max(col, 0), len(t.Columns)-1)
+	row, col = t.Owner(row, col)
+	if s.cells == nil {
+		s.cells = map[uint64]cellPos{}
+	}
+	id := s.Doc.Blocks[i].ID
+	old, ok := s.cells[id]
+	s.cells[id] = cellPos{row, col}
+	return !ok || old != (cellPos{row, col})
+}
+
+// normalizeTable repairs table block i so every cell has text and the
+// edited cell is inside the grid and not covered.
+func (s *State) normalizeTable(i int) {
+	b := &s.Doc.Blocks[i]
+	if b.Table == nil || len(b.Table.Rows) == 0 || len(b.Table.Columns) == 0 {
+		b.Table = sqdoc.NewTable(1, 1)
+	}
+	t := b.Table
+	for r := range t.Rows {
+		for len(t.Rows[r]) < len(t.Columns) {
+			t.Rows[r] = append(t.Rows[r], sqdoc.TableCell{Borders: sqdoc.BorderAll})
+		}
+		t.Rows[r] = t.Rows[r][:len(t.Columns)]
+		for c := range t.Rows[r] {
+			tb := t.Rows[r][c].Text
+			if tb == nil {
+				tb = &sqdoc.TextBlock{}
+				t.Rows[r][c].Text = tb
+			}
+			tb.Runs = sanitizeRuns(len(tb.UTF8), tb.Runs)
+		}
+	}
+	p := s.cells[b.ID]
+	s.setActiveCell(i, p.row, p.col)
+}
+
+// InsertTable puts a rows × cols table after the paragraph at the caret,
+// splitting it at the caret first, and moves the caret into the first
+// cell. An empty paragraph is replaced, its bookmarks and comments moving
+// to a neighbouring paragraph. Tables do not nest, so it reports
+// false inside one.
+func (s *State) InsertTable(rows, cols int) bool {
+	s.Normalize()
+	if s.IsTable(s.CurrentBlock) || s.IsNote(s.CurrentBlock) || rows < 1 || cols < 1 {
+		return false
+	}
+	s.DeleteSelection()
+	// The delete can leave the caret at the other end of the selection,
+	// which may be in a table or a note.
+	if s.IsTable(s.CurrentBlock) || s.IsNote(s.CurrentBlock) {
+		return false
+	}
+	if text := s.CurrentBlockText(); s.CaretByte > 0 && s.CaretByte < len(text) {
+		s.SplitBlockAtCaret()
+	}
+	// Take the ID before the empty paragraph goes, so the table never
+	// inherits it and whatever still refers to it.
+	table := sqdoc.Block{ID: s.nextBlockID(), Kind: sqdoc.BlockKindTable, Table: sqdoc.NewTable(rows, cols)}
+	at := s.CurrentBlock
+	if len(s.CurrentBlockText()) > 0 {
+		if s.CaretByte > 0 {
+			at++
+		}
+	} else if s.Doc.Blocks[at].Kind == sqdoc.BlockKindText && s.moveAnchorsFrom(at) {
+		s.Doc.Blocks = append(s.Doc.Blocks[:at], s.Doc.Blocks[at+1:]...)
+	}
+	s.Doc.Blocks = append(s.Doc.Blocks[:at], append([]sqdoc.Block{table}, s.Doc.Blocks[at:]...)...)
+	// Keep a paragraph after the table to type in.
+	if at == s.bodyEnd()-1 {
+		para := sqdoc.Block{ID: s.nextBlockID(), Kind: sqdoc.BlockKindText, Text: &sqdoc.TextBlock{}}
+		s.Doc.Blocks = append(s.Doc.Blocks[:at+1], append([]sqdoc.Block{para}, s.Doc.Blocks[at+1:]...)...)
+	}
+	s.ClearSelection()
+	s.SetCaretInCell(at, 0, 0, 0)
+	return true
+}
+
+// moveAnchorsFrom moves the bookmarks and comment anchors on the empty
+// paragraph i to the end of the paragraph before it, or the start of the
+// one after, so i can be removed. It reports false, moving nothing, when neither is a paragraph.
+func (s *State) moveAnchorsFrom(i int) bool {
+	to, offset := -1, 0
+	if i > 0 && !s.IsTable(i-1) && !s.IsNote(i-1) {
+		to, offset = i-1, len(s.text(i-1).UTF8)
+	} else if i+1 < s.bodyEnd() && !s.IsTable(i+1) {
+		to = i + 1
+	}
+	if to < 0 {
+		return false
+	}
+	from, id := s.Doc.Blocks[i].ID, s.Doc.Blocks[to].ID
+	for k := range s.Doc.Bookmarks {
+		if bm := &s.Doc.Bookmarks[k]; bm.BlockID == from {
+			bm.BlockID, bm.Offset = id, uint32(offset)
+		}
+	}
+	for k := range s.Doc.Comments {
+		if t := &s.Doc.Comments[k]; t.BlockID == from {
+			t.BlockID, t.Start, t.End = id, uint32(offset), uint32(offset)
+		}
+	}
+	return true
+}
+
+// NextCell moves the caret delta cells along the table in reading order,
+// skipping merged-over cells. Tab past the last cell adds a row. It reports
+// false outside a table.
+func (s *State) NextCell(delta int) bool {
+	t := s.Table()
+	if t == nil {
+		return false
+	}
+	s.ClearSelection()
+	row, col := s.ActiveCell(s.CurrentBlock)
+	for delta != 0 {
+		r, c, ok := nextOwnedCell(t, row, col, delta)
+		if !ok {
+			if delta < 0 {
+				break
+			}
+			s.InsertRow(true)
+			t = s.Doc.Blocks[s.CurrentBlock].Table
+			r, c = len(t.Rows)-1, 0
+		}
+		row, col = r, c
+		if delta > 0 {
+			delta--
+		} else {
+			delta++
+		}
+	}
+	s.setActiveCell(s.CurrentBlock, row, col)
+	s.CaretByte = len(s.CurrentBlockText())
+	return true
+}
+
+// nextOwnedCell steps one cell forward (step > 0) or back from row, col.
+func nextOwnedCell(t *sqdoc.TableBlock, row, col, step int) (int, int, bool) {
+	cols := len(t.Columns)
+	i := row*cols + col
+	for {
+		if step > 0 {
+			i++
+		} else {
+			i--
+		}
+		if i < 0 || i >= len(t.Rows)*cols {
+			return row, col, false
+		}
+		if r, c := i/cols, i%cols; !t.Covered(r, c) {
+			return r, c, true
+		}
+	}
+}
+
+// enterTable makes the first or last cell the edited one when the caret
+// moves into table block i from outside.
+func (s *State) enterTable(i int, last bool) {
+	if !s.IsTable(i) {
+		return
+	}
+	t := s.Doc.Blocks[i].Table
+	if last {
+		s.setActiveCell(i, len(t.Rows)-1, len(t.Columns)-1)
+	} else {
+		s.setActiveCell(i, 0, 0)
+	}
+}
+
+// caretToPrevious moves the caret to the end of the previous cell or block.
+func (s *State) caretToPrevious() {
+	if s.IsTable(s.CurrentBlock) {
+		row, col := s.ActiveCell(s.CurrentBlock)
+		if r, c, ok := nextOwnedCell(s.Doc.Blocks[s.CurrentBlock].Table, row, col, -1); ok {
+			s.moveToCell(s.CurrentBlock, r, c)
+			s.CaretByte = len(s.CurrentBlockText())
+			return
+		}
+	}
+	if s.CurrentBlock > 0 {
+		s.CurrentBlock--
+		s.enterTable(s.CurrentBlock, true)
+		s.CaretByte = len(s.CurrentBlockText())
+	}
+}
+
+// caretToNext moves the caret to the start of the next cell or block.
+func (s *State) caretToNext() {
+	if s.IsTable(s.CurrentBlock) {
+		row, col := s.ActiveCell(s.CurrentBlock)
+		if r, c, ok := nextOwnedCell(s.Doc.Blocks[s.CurrentBlock].Table, row, col, 1); ok {
+			s.moveToCell(s.CurrentBlock, r, c)
+			s.CaretByte = 0
+			return
+		}
+	}
+	if s.CurrentBlock < len(s.Doc.Blocks)-1 {
+		s.CurrentBlock++
+		s.enterTable(s.CurrentBlock, false)
+		s.CaretByte = 0
+	}
+}
+
+// joinable reports whether blocks left and right may merge into one
+// paragraph. Tables never merge with their neighbours, and a code block's
+// lines never join the paragraph before it. Notes join nothing.
+func (s *State) joinable(left, right int) bool {
+	return left >= 0 && right < len(s.Doc.Blocks) && !s.IsTable(left) && !s.IsTable(right) && !s.IsCode(right) && !s.IsNote(left) && !s.IsNote(right)
+}
+
+// dropEmptyBeside removes the current paragraph when it is empty and the
+// block on the side given by dir (-1 before, 1 after) is a table or code
+// block, moving the caret into that block. Backspace and Delete use it
+// where they would otherwise merge with one.
+func (s *State) dropEmptyBeside(dir int) {
+	next := s.CurrentBlock + dir
+	if (!s.IsTable(next) && !s.IsCode(next)) || s.IsTable(s.CurrentBlock) || s.IsCode(s.CurrentBlock) || s.IsNote(s.CurrentBlock) || len(s.CurrentBlockText()) > 0 {
+		return
+	}
+	// The paragraph after a closing table or code block stays so there is
+	// somewhere to type; the caret still moves into the block.
+	if dir > 0 || s.CurrentBlock < s.bodyEnd()-1 {
+		s.Doc.Blocks = append(s.Doc.Blocks[:s.CurrentBlock], s.Doc.Blocks[s.CurrentBlock+1:]...)
+	}
+	if dir < 0 {
+		s.CurrentBlock--
+	}
+	s.enterTable(s.CurrentBlock, dir < 0)
+	s.CaretByte = 0
+	if dir < 0 {
+		s.CaretByte = len(s.CurrentBlockText())
+	}
+}
+
+// InsertRow adds an empty row below or above the caret's row. Cells merged
+// across the new row's position grow to cover it.
+func (s *State) InsertRow(below bool) {
+	t := s.Table()
+	if t == nil {
+		return
+	}
+	row, col := s.ActiveCell(s.CurrentBlock)
+	at := row
+	if below {
+		_, rs := t.Rows[row][col].Span()
+		at = row + rs
+	}
+	newRow := make([]sqdoc.TableCell, len(t.Columns))
+	for c := range newRow {
+		newRow[c] = sqdoc.TableCell{Text: &sqdoc.TextBlock{}, Borders: t.Rows[row][c].Borders, Background: t.Rows[row][c].Background}
+		if at == 0 || at == len(t.Rows) {
+			continue
+		}
+		// A cell spanning the boundary between at-1 and at grows instead.
+		if or, oc := t.Owner(at, c); or < at {
+			if oc == c {
+				_, rs := t.Rows[or][oc].Span()
+				t.Rows[or][oc].RowSpan = uint16(rs + 1)
+			}
+			newRow[c].Background = 0
+		}
+	}
+	t.Rows = append(t.Rows[:at], append([][]sqdoc.TableCell{newRow}, t.Rows[at:]...)...)
+	if at < int(t.HeaderRows) {
+		t.HeaderRows++
+	}
+	if !below {
+		s.setActiveCell(s.CurrentBlock, row+1, col)
+	}
+}
+
+// DeleteRow removes the caret's row; removing the last row removes the
+// table.
+func (s *State) DeleteRow() {
+	t := s.Table()
+	if t == nil {
+		return
+	}
+	row, col := s.ActiveCell(s.CurrentBlock)
+	if len(t.Rows) == 1 {
+		s.DeleteTable()
+		return
+	}
+	for c := 0; c < len(t.Columns); c++ {
+		or, oc := t.Owner(row, c)
+		if oc != c {
+			continue
+		}
+		owner := t.Rows[or][oc]
+		_, rs := owner.Span()
+		if rs == 1 {
+			continue
+		}
+		if or < row {
+			t.Rows[or][oc].RowSpan = uint16(rs - 1)
+			continue
+		}
+		// The cell moves down a row to keep covering the rest of its span.
+		owner.RowSpan = uint16(rs - 1)
+		t.Rows[row+1][c] = owner
+	}
+	t.Rows = append(t.Rows[:row], t.Rows[row+1:]...)
+	if row < int(t.HeaderRows) {
+		t.HeaderRows--
+	}
+	s.setActiveCell(s.CurrentBlock, min(row, len(t.Rows)-1), col)
+	s.CaretByte = 0
+	s.ClearSelection()
+}
+
+// InsertColumn adds an empty column right or left of the caret's column.
+// Cells merged across the new column's position grow to cover it.
+func (s *State) InsertColumn(right bool) {
+	t := s.Table()
+	if t == nil {
+		return
+	}
+	row, col := s.ActiveCell(s.CurrentBlock)
+	at := col
+	if right {
+		cs, _ := t.Rows[row][col].Span()
+		at = col + cs
+	}
+	for r := range t.Rows {
+		cell := sqdoc.TableCell{Text: &sqdoc.TextBlock{}, Borders: t.Rows[r][col].Borders}
+		if at > 0 && at < len(t.Columns) {
+			if or, oc := t.Owner(r, at); oc < at && or == r {
+				cs, _ := t.Rows[or][oc].Span()
+				t.Rows[or][oc].ColSpan = uint16(cs + 1)
+			}
+		}
+		t.Rows[r] = append(t.Rows[r][:at], append([]sqdoc.TableCell{cell}, t.Rows[r][at:]...)...)
+	}
+	t.Columns = append(t.Columns[:at], append([]uint16{0}, t.Columns[at:]...)...)
+	if !right {
+		s.setActiveCell(s.CurrentBlock, row, col+1)
+	}
+}
+
+// DeleteColumn removes the caret's column; removing the last column
+// removes the table.
+func (s *State) DeleteColumn() {
+	t := s.Table()
+	if t == nil {
+		return
+	}
+	row, col := s.ActiveCell(s.CurrentBlock)
+	if len(t.Columns) == 1 {
+		s.DeleteTable()
+		return
+	}
+	for r := range t.Rows {
+		or, oc := t.Owner(r, col)
+		if or != r {
+			continue
+		}
+		owner := t.Rows[or][oc]
+		cs, _ := owner.Span()
+		if cs == 1 {
+			continue
+		}
+		if oc < col {
+			t.Rows[or][oc].ColSpan = uint16(cs - 1)
+			continue
+		}
+		owner.ColSpan = uint16(cs - 1)
+		t.Rows[r][col+1] = owner
+	}
+	for r := range t.Rows {
+		t.Rows[r] = append(t.Rows[r][:col], t.Rows[r][col+1:]...)
+	}
+	t.Columns = append(t.Columns[:col], t.Columns[col+1:]...)
+	s.setActiveCell(s.CurrentBlock, row, min(col, len(t.Columns)-1))
+	s.CaretByte = 0
+	s.ClearSelection()
+}
+
+// MergeCell merges the caret's cell with its neighbour to the right, or
+// below when down is set. The neighbour must line up with it exactly; its
+// text moves to the end of the merged cell on a new line.
+func (s *State) MergeCell(down bool) bool {
+	t := s.Table()
+	if t == nil {
+		return false
+	}
+	row, col := s.ActiveCell(s.CurrentBlock)
+	cell := &t.Rows[row][col]
+	cs, rs := cell.Span()
+	nr, nc := row, col+cs
+	if down {
+		nr, nc = row+rs, col
+	}
+	next := t.Cell(nr, nc)
+	if next == nil || t.Covered(nr, nc) {
+		return false
+	}
+	ncs, nrs := next.Span()
+	if (!down && nrs != rs) || (down && ncs != cs) {
+		return false
+	}
+	if len(next.Text.UTF8) > 0 {
+		if len(cell.Text.UTF8) > 0 {
+			s.appendCellText(cell.Text, []byte("\n"), nil)
+		}
+		s.appendCellText(cell.Text, next.Text.UTF8, next.Text.Runs)
+	}
+	if down {
+		cell.RowSpan = uint16(rs + nrs)
+	} else {
+		cell.ColSpan = uint16(cs + ncs)
+	}
+	*next = sqdoc.TableCell{Text: &sqdoc.TextBlock{}, Borders: next.Borders}
+	s.ClearSelection()
+	return true
+}
+
+func (s *State) appendCellText(dst *sqdoc.TextBlock, text []byte, runs []sqdoc.StyleRun) {
+	shift := len(dst.UTF8)
+	out := coverageRuns(shift, dst.Runs)
+	attr := defaultStyleAttr()
+	if len(out) > 0 {
+		attr = out[len(out)-1].Attr
+	}
+	added := coverageRuns(len(text), runs)
+	if len(runs) == 0 {
+		added = []sqdoc.StyleRun{{Start: 0, End: uint32(len(text)), Attr: attr}}
+	}
+	for _, r := range added {
+		out = append(out, sqdoc.StyleRun{Start: r.Start + uint32(shift), End: r.End + uint32(shift), Attr: r.Attr})
+	}
+	dst.UTF8 = append(dst.UTF8, text...)
+	dst.Runs = sanitizeRuns(len(dst.UTF8), out)
+}
+
+// SplitCell undoes merging on the caret's cell, leaving the cells it
+// covered empty.
+func (s *State) SplitCell() {
+	t := s.Table()
+	if t == nil {
+		return
+	}
+	row, col := s.ActiveCell(s.CurrentBlock)
+	t.Rows[row][col].ColSpan = 0
+	t.Rows[row][col].RowSpan = 0
+}
+
+// SetCellBackground colours the caret's cell; 0 removes the colour.
+func (s *State) SetCellBackground(rgba uint32) {
+	if t := s.Table(); t != nil {
+		row, col := s.ActiveCell(s.CurrentBlock)
+		t.Rows[row][col].Background = rgba
+	}
+}
+
+// SetCellBorders sets which sides of the caret's cell have a border.
+func (s *State) SetCellBorders(b sqdoc.Border) {
+	if t := s.Table(); t != nil {
+		row, col := s.ActiveCell(s.CurrentBlock)
+		t.Rows[row][col].Borders = b & sqdoc.BorderAll
+	}
+}
+
+// SetHeaderRows makes the first n rows of the table the header.
+func (s *State) SetHeaderRows(n int) {
+	if t := s.Table(); t != nil {
+		t.HeaderRows = uint16(min(max(n, 0), len(t.Rows)))
+	}
+}
+
+// AdjustColumnWidth widens the caret's column by delta points. A column
+// sharing the free width gets a fixed width first.
+func (s *State) AdjustColumnWidth(delta, current int) {
+	t := s.Table()
+	if t == nil {
+		return
+	}
+	_, col := s.ActiveCell(s.CurrentBlock)
+	w := int(t.Columns[col])
+	if w == 0 {
+		w = current
+	}
+	t.Columns[col] = uint16(min(max(w+delta, minColumnPt), maxColumnPt))
+}
+
+const (
+	minColumnPt = 24
+	maxColumnPt = 720
+)
+
+// DeleteTable removes the table the caret is in.
+func (s *State) DeleteTable() {
+	if !s.IsTable(s.CurrentBlock) {
+		return
+	}
+	s.Doc.Blocks = append(s.Doc.Blocks[:s.CurrentBlock], s.Doc.Blocks[s.CurrentBlock+1:]...)
+	s.ClearSelection()
+	s.CaretByte = 0
+	s.Normalize()
+	s.enterTable(s.CurrentBlock, false)
+}
+
+// tablePlainText is a table as tab-separated lines.
+func tablePlainText(t *sqdoc.TableBlock) string {
+	var rows []string
+	for r, row := range t.Rows {
+		var cells []string
+		for c, cell := range row {
+			if t.Covered(r, c) || cell.Text == nil {
+				cells = append(cells, "")
+				continue
+			}
+			cells = append(cells, strings.ReplaceAll(string(cell.Text.UTF8), "\n", " "))
+		}
+		rows = append(rows, strings.Join(cells, "\t"))
+	}
+	return strings.Join(rows, "\n")
+}
@@ -0,0 +1,283 @@
+package editor
+
+import (
+	"testing"
+
+	"sqdoc/pkg/sqdoc"
+)
+
+func TestInsertTableAndTabThroughCells(t *testing.T) {
+	s := searchState(t, "before after")
+	s.SetCaret(0, len("before "))
+	if !s.InsertTable(2, 2) {
+		t.Fatal("InsertTable refused a paragraph")
+	}
+	if s.BlockCount() != 3 || !s.IsTable(1) || s.CurrentBlock != 1 {
+		t.Fatalf("blocks = %q, current %d", s.AllBlockTexts(), s.CurrentBlock)
+	}
+	if s.InsertTable(1, 1) {
+		t.Fatal("InsertTable nested a table")
+	}
+
+	for _, text := range []string{"a", "b", "c", "d", "e"} {
+		_ = s.InsertTextAtCaret(text)
+		s.NextCell(1)
+	}
+	table := s.Doc.Blocks[1].Table
+	if len(table.Rows) != 3 || string(table.Rows[2][0].Text.UTF8) != "e" {
+		t.Fatalf("rows = %d, cell 2,0 = %q", len(table.Rows), table.Rows[2][0].Text.UTF8)
+	}
+	if row, col := s.ActiveCell(1); row != 2 || col != 1 {
+		t.Fatalf("active cell = %d,%d, want 2,1", row, col)
+	}
+	s.NextCell(-1)
+	if row, col := s.ActiveCell(1); row != 2 || col != 0 || s.CurrentText() != "e" {
+		t.Fatalf("active cell = %d,%d with %q", row, col, s.CurrentText())
+	}
+
+	s.SplitBlockAtCaret()
+	_ = s.InsertTextAtCaret("f")
+	if s.CurrentText() != "e\nf" || s.BlockCount() != 3 {
+		t.Fatalf("Enter in a cell gave %q and %d blocks", s.CurrentText(), s.BlockCount())
+	}
+}
+
+func TestCaretMovesThroughTable(t *testing.T) {
+	s := searchState(t, "x\ny")
+	s.SetCaret(0, 1)
+	s.InsertTable(1, 2)
+	s.SetCaretInCell(1, 0, 1, 0)
+	_ = s.InsertTextAtCaret("z")
+
+	s.SetCaret(0, 1)
+	s.MoveCaretRight()
+	if s.CurrentBlock != 1 {
+		t.Fatalf("caret in block %d", s.CurrentBlock)
+	}
+	if row, col := s.ActiveCell(1); row != 0 || col != 0 {
+		t.Fatalf("entered cell %d,%d, want 0,0", row, col)
+	}
+	s.MoveCaretRight()
+	if _, col := s.ActiveCell(1); col != 1 || s.CaretByte != 0 {
+		t.Fatalf("moved to cell column %d byte %d", col, s.CaretByte)
+	}
+
+	s.SetCaret(2, 0)
+	s.MoveCaretLeft()
+	if _, col := s.ActiveCell(1); s.CurrentBlock != 1 || col != 1 || s.CaretByte != 1 {
+		t.Fatalf("Left from below reached block %d column %d byte %d", s.CurrentBlock, col, s.CaretByte)
+	}
+}
+
+func TestBackspaceDoesNotMergeTables(t *testing.T) {
+	s := searchState(t, "x")
+	s.SetCaret(0, 1)
+	s.InsertTable(1, 1)
+	_ = s.InsertTextAtCaret("cell")
+	s.SetCaret(2, 0)
+	_ = s.InsertTextAtCaret("after")
+	s.SetCaret(2, 0)
+
+	s.Backspace()
+	if s.BlockCount() != 3 || s.CurrentBlock != 2 {
+		t.Fatalf("Backspace before text merged into the table: %q", s.AllBlockTexts())
+	}
+
+	s.SetCaretInCell(1, 0, 0, 0)
+	s.Backspace()
+	if s.BlockCount() != 3 || s.CurrentText() != "cell" {
+		t.Fatalf("Backspace at a cell start changed %q", s.AllBlockTexts())
+	}
+
+	s.SetCaret(0, 1)
+	s.SplitBlockAtCaret()
+	s.DeleteForward()
+	if s.BlockCount() != 3 || s.CurrentBlock != 1 || s.CurrentText() != "cell" {
+		t.Fatalf("Delete in an empty paragraph left %q at block %d", s.AllBlockTexts(), s.CurrentBlock)
+	}
+}
+
+func TestTableRowAndColumnCommands(t *testing.T) {
+	s := searchState(t, "")
+	s.InsertTable(2, 2)
+	s.SetHeaderRows(1)
+	s.SetCaretInCell(0, 0, 0, 0)
+	s.InsertRow(false)
+	table := s.Table()
+	if len(table.Rows) != 3 || table.HeaderRows != 2 {
+		t.Fatalf("rows = %d, header rows = %d", len(table.Rows), table.HeaderRows)
+	}
+	if row, _ := s.ActiveCell(0); row != 1 {
+		t.Fatalf("inserting above left the caret in row %d", row)
+	}
+
+	s.InsertColumn(true)
+	if len(table.Columns) != 3 || len(table.Rows[0]) != 3 {
+		t.Fatalf("columns = %d", len(table.Columns))
+	}
+	s.DeleteColumn()
+	s.DeleteRow()
+	if len(table.Columns) != 2 || len(table.Rows) != 2 || table.HeaderRows != 1 {
+		t.Fatalf("after deletes: %d columns, %d rows, %d header rows", len(table.Columns), len(table.Rows), table.HeaderRows)
+	}
+	if errs := sqdoc.ValidateAll(s.Doc); len(errs) != 0 {
+		t.Fatalf("ValidateAll() = %v", errs)
+	}
+
+	s.DeleteTable()
+	if s.BlockCount() != 1 || s.IsTable(0) {
+		t.Fatalf("DeleteTable left %d blocks", s.BlockCount())
+	}
+}
+
+func TestMergeAndSplitCells(t *testing.T) {
+	s := searchState(t, "")
+	s.InsertTable(3, 3)
+	_ = s.InsertTextAtCaret("a")
+	s.SetCaretInCell(0, 0, 1, 0)
+	_ = s.InsertTextAtCaret("b")
+
+	s.SetCaretInCell(0, 0, 0, 0)
+	if !s.MergeCell(false) {
+		t.Fatal("MergeCell(false) failed")
+	}
+	table := s.Table()
+	if got := string(table.Rows[0][0].Text.UTF8); got != "a\nb" || !table.Covered(0, 1) {
+		t.Fatalf("merged text %q, covered %v", got, table.Covered(0, 1))
+	}
+	if s.MergeCell(true) {
+		t.Fatal("merged down into a cell of a different width")
+	}
+
+	s.InsertRow(true)
+	if len(table.Rows) != 4 || table.Covered(1, 1) {
+		t.Fatalf("row below a merged cell: %d rows, covered(1,1) = %v", len(table.Rows), table.Covered(1, 1))
+	}
+	s.InsertColumn(false)
+	if cs, _ := table.Rows[0][1].Span(); cs != 2 {
+		t.Fatalf("merged cell span after a column left of it = %d", cs)
+	}
+	s.SetCaretInCell(0, 1, 2, 0)
+	s.InsertColumn(false)
+	if cs, _ := table.Rows[0][1].Span(); cs != 3 {
+		t.Fatalf("merged cell span after a column inside it = %d", cs)
+	}
+
+	s.SetCaretInCell(0, 0, 1, 0)
+	s.SplitCell()
+	if table.Covered(0, 2) {
+		t.Fatal("SplitCell left cells covered")
+	}
+
+	s.SetCaretInCell(0, 2, 0, 0)
+	if !s.MergeCell(true) {
+		t.Fatal("MergeCell(true) failed")
+	}
+	s.DeleteRow()
+	if _, rs := table.Rows[2][0].Span(); rs != 1 || len(table.Rows) != 3 {
+		t.Fatalf("deleting the first row of a merged cell left span %d", rs)
+	}
+	if errs := sqdoc.ValidateAll(s.Doc); len(errs) != 0 {
+		t.Fatalf("ValidateAll() = %v", errs)
+	}
+}
+
+func TestSelectionAcrossTable(t *testing.T) {
+	s := searchState(t, "ab\ncd")
+	s.SetCaret(0, 2)
+	s.InsertTable(1, 2)
+	_ = s.InsertTextAtCaret("x")
+	s.NextCell(1)
+	_ = s.InsertTextAtCaret("y")
+
+	s.SelectRange(Position{Block: 0, Byte: 1}, Position{Block: 2, Byte: 1})
+	if got := s.SelectedText(); got != "b\nx\ty\nc" {
+		t.Fatalf("SelectedText() = %q", got)
+	}
+	s.DeleteSelection()
+	if got := s.AllBlockTexts(); len(got) != 2 || got[0] != "a" || got[1] != "d" || s.CurrentBlock != 0 || s.CaretByte != 1 {
+		t.Fatalf("after delete: %q, caret %d:%d", got, s.CurrentBlock, s.CaretByte)
+	}
+	if s.Doc.Blocks[0].Kind != sqdoc.BlockKindText || s.Doc.Blocks[1].Kind != sqdoc.BlockKindText {
+		t.Fatal("table survived a selection across it")
+	}
+}
+
+func TestInsertTableMovesBookmarksOffReplacedParagraph(t *testing.T) {
+	for _, text := range []string{"gone", "before\n\nafter"} {
+		s := searchState(t, text)
+		if text == "gone" {
+			s.SelectAll()
+			s.DeleteSelection()
+		} else {
+			s.SetCaret(1, 0)
+		}
+		if err := s.AddBookmark("here"); err != nil {
+			t.Fatal(err)
+		}
+		replaced := s.Doc.Blocks[s.CurrentBlock].ID
+		if !s.InsertTable(2, 2) {
+			t.Fatal("InsertTable refused an empty paragraph")
+		}
+		if id := s.Doc.Blocks[s.CurrentBlock].ID; id == replaced {
+			t.Fatalf("%q: table reused the ID %d of the paragraph it replaced", text, id)
+		}
+		if err := sqdoc.Validate(s.Doc); err != nil {
+			t.Fatalf("%q: %v", text, err)
+		}
+		found := false
+		for _, b := range s.Doc.Blocks {
+			found = found || b.ID == s.Doc.Bookmarks[0].BlockID && b.Kind == sqdoc.BlockKindText
+		}
+		if !found {
+			t.Fatalf("%q: bookmark left on missing block %d", text, s.Doc.Bookmarks[0].BlockID)
+		}
+	}
+}
+
+func TestInsertTableKeepsCommentsOffTheTable(t *testing.T) {
+	s := searchState(t, "x")
+	s.SelectRange(Position{Block: 0, Byte: 0}, Position{Block: 0, Byte: 1})
+	if _, err := s.AddComment("sam", "check", 1); err != nil {
+		t.Fatal(err)
+	}
+	// Splitting before the comment moves it to a block newer than itself.
+	s.SetCaret(0, 0)
+	s.SplitBlockAtCaret()
+	s.SelectRange(Position{Block: 1, Byte: 0}, Position{Block: 1, Byte: 1})
+	if !s.InsertTable(1, 1) {
+		t.Fatal("InsertTable refused a selection")
+	}
+	table := s.Doc.Blocks[s.CurrentBlock].ID
+	for _, c := range s.Doc.Comments {
+		if c.BlockID == table {
+			t.Fatalf("comment %d anchored on the table", c.ID)
+		}
+	}
+	s.Normalize()
+	if err := sqdoc.Validate(s.Doc); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInsertTableNeverReplacesANote(t *testing.T) {
+	s := searchState(t, "ab")
+	s.SetCaret(0, 1)
+	if err := s.InsertNote(sqdoc.NoteFootnote); err != nil {
+		t.Fatal(err)
+	}
+	note := s.CurrentBlock
+	// A suggested delete marks the text and leaves the caret where the
+	// selection was anchored, here in the empty note.
+	s.Suggesting = true
+	s.SelectRange(Position{Block: note, Byte: 0}, Position{Block: 0, Byte: 0})
+	if s.InsertTable(1, 1) {
+		t.Fatal("InsertTable put a table in place of a note")
+	}
+	if !s.IsNote(note) {
+		t.Fatalf("note block %d is gone: %q", note, s.AllBlockTexts())
+	}
+	if err := sqdoc.Validate(s.Doc); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,82 @@
+// Package fonts embeds the Liberation faces shared by the editor and the
+// exporters that need real glyph metrics.
+package fonts
+
+import (
+	_ "embed"
+
+	"sqdoc/pkg/sqdoc"
+)
+
+var (
+	//go:embed LiberationSans-Regular.ttf
+	SansRegular []byte
+	//go:embed LiberationSans-Bold.ttf
+	SansBold []byte
+	//go:embed LiberationSans-Italic.ttf
+	SansItalic []byte
+	//go:embed LiberationSans-BoldItalic.ttf
+	SansBoldItalic []byte
+
+	//go:embed LiberationSerif-Regular.ttf
+	SerifRegular []byte
+	//go:embed LiberationSerif-Bold.ttf
+	SerifBold []byte
+	//go:embed LiberationSerif-Italic.ttf
+	SerifItalic []byte
+	//go:embed LiberationSerif-BoldItalic.ttf
+	SerifBoldItalic []byte
+
+	//go:embed LiberationMono-Regular.ttf
+	MonoRegular []byte
+	//go:embed LiberationMono-Bold.ttf
+	MonoBold []byte
+	//go:embed LiberationMono-Italic.ttf
+	MonoItalic []byte
+	//go:embed LiberationMono-BoldItalic.ttf
+	MonoBoldItalic []byte
+)
+
+// TTF returns the face for a family and style. Unknown families use Sans.
+func TTF(family sqdoc.FontFamily, bold, italic bool) []byte {
+	faces := [...][4][]byte{
+		{SansRegular, SansBold, SansItalic, SansBoldItalic},
+		{SerifRegular, SerifBold, SerifItalic, SerifBoldItalic},
+		{MonoRegular, MonoBold, MonoItalic, MonoBoldItalic},
+	}
+	set := faces[0]
+	switch family {
+	case sqdoc.FontFamilySerif:
+		set = faces[1]
+	case sqdoc.FontFamilyMonospace:
+		set = faces[2]
+	}
+	i := 0
+	if bold {
+		i |= 1
+	}
+	if italic {
+		i |= 2
+	}
+	return set[i]
+}
+
+// Name returns the PostScript name of the face TTF would pick.
+func Name(family sqdoc.FontFamily, bold, italic bool) string {
+	name := "LiberationSans"
+	switch family {
+	case sqdoc.FontFamilySerif:
+		name = "LiberationSerif"
+	case sqdoc.FontFamilyMonospace:
+		name = "LiberationMono"
+	}
+	switch {
+	case bold && italic:
+		return name + "-BoldItalic"
+	case bold:
+		return name + "-Bold"
+	case italic:
+		return name + "-Italic"
+	}
+	return name
+}
@@ -0,0 +1,107 @@
+package render
+
+// PageBlock is a block as pagination sees it: the heights of its lines and
+// the space around them.
+type PageBlock struct {
+	// Lines are the heights of the block's lines, each with the gap below it.
+	Lines []float64
+	// Notes are the heights of the footnotes each line brings to the foot of
+	// its page, parallel to Lines; nil when it brings none.
+	Notes []float64
+	// Before goes above the first line unless it starts a page, and After
+	// below the last.
+	Before, After float64
+	// BreakBefore starts the block on a new page.
+	BreakBefore bool
+	// Whole keeps the lines on one page, moving them to the next as a unit.
+	Whole bool
+}
+
+// Spot is where a line goes: its page, and how far its top is below the top
+// of the page's text area.
+type Spot struct {
+	Page int
+	Y    float64
+}
+
+// Pages is where Paginate put every line.
+type Pages struct {
+	// Spots are parallel to the blocks and their lines.
+	Spots [][]Spot
+	// Notes is the height the footnotes of each page take at its foot,
+	// with the rule above them; there is one per page.
+	Notes []float64
+}
+
+func (p Pages) Count() int {
+	return len(p.Notes)
+}
+
+// Paginate flows blocks onto pages whose text area is height tall, less the
+// footnotes the lines on it bring, which take noteRule more above them.
+// A paragraph breaks across pages only where that leaves at least two of
+// its lines on each, so no line is left alone as a widow or orphan. A line
+// taller than a page gets one to itself.
+func Paginate(blocks []PageBlock, height, noteRule float64) Pages {
+	out := Pages{Spots: make([][]Spot, len(blocks)), Notes: []float64{0}}
+	y := 0.0
+	// empty reports whether nothing is on the current page yet.
+	empty := true
+	newPage := func() {
+		out.Notes = append(out.Notes, 0)
+		y, empty = 0, true
+	}
+	// fits reports whether lines from..to of b fit on the current page.
+	fits := func(b PageBlock, from, to int) bool {
+		h, notes := 0.0, out.Notes[len(out.Notes)-1]
+		for k := from; k < to; k++ {
+			h += b.Lines[k]
+			if k < len(b.Notes) && b.Notes[k] > 0 {
+				if notes == 0 {
+					notes = noteRule
+				}
+				notes += b.Notes[k]
+			}
+		}
+		return y+h+notes <= height
+	}
+
+	for bi, b := range blocks {
+		if b.BreakBefore && !empty {
+			newPage()
+		}
+		if !empty {
+			y += b.Before
+		}
+		n := len(b.Lines)
+		switch {
+		case empty:
+		case b.Whole:
+			if !fits(b, 0, n) {
+				newPage()
+			}
+		case n >= 2 && (!fits(b, 0, 2) || n < 4 && !fits(b, 0, n)):
+			// Any break would leave a line on its own.
+			newPage()
+		}
+		spots := make([]Spot, n)
+		for k := 0; k < n; k++ {
+			if !b.Whole && !empty && (!fits(b, k, k+1) || k >= 2 && k == n-2 && !fits(b, k, n)) {
+				newPage()
+			}
+			page := len(out.Notes) - 1
+			spots[k] = Spot{Page: page, Y: y}
+			y += b.Lines[k]
+			if k < len(b.Notes) && b.Notes[k] > 0 {
+				if out.Notes[page] == 0 {
+					out.Notes[page] = noteRule
+				}
+				out.Notes[page] += b.Notes[k]
+			}
+			empty = false
+		}
+		y += b.After
+		out.Spots[bi] = spots
+	}
+	return out
+}
@@ -0,0 +1,40 @@
+package render
+
+import (
+	"unicode"
+	"unicode/utf8"
+)
+
+// Advance returns the width of the item starting at pos and the offset just
+// past it. Items are runes or whole inline image tokens.
+type Advance func(pos int) (width, next int)
+
+// WrapEnd returns where a line starting at start has to end to fit within
+// maxWidth. It breaks before the last whitespace that fit, or mid-word when a
+// word alone is wider than the line. The first item always fits.
+func WrapEnd(text []byte, start, end, maxWidth int, advance Advance) int {
+	if start >= end || maxWidth <= 0 {
+		return end
+	}
+	width := 0
+	lastBreak := -1
+	pos := start
+	for pos < end {
+		w, next := advance(pos)
+		if next <= pos {
+			next = pos + 1
+		}
+		if width+w > maxWidth && pos > start {
+			if lastBreak > start {
+				return lastBreak
+			}
+			return pos
+		}
+		width += w
+		if r, _ := utf8.DecodeRune(text[pos:next]); unicode.IsSpace(r) {
+			lastBreak = pos
+		}
+		pos = next
+	}
+	return end
+}
@@ -0,0 +1,30 @@
+package convert
+
+import "sqdoc/pkg/sqdoc"
+
+const (
+	// CodeShadeRGBA fills the box behind a code block, as in the editor.
+	CodeShadeRGBA uint32 = 0xF3F5F8FF
+	// CodePaddingPt is the gap between a code block's shading and its text.
+	CodePaddingPt = 6
+)
+
+// CodeSpans splits code block b like Spans, with every span in the
+// monospace family whatever its runs say.
+func CodeSpans(b sqdoc.Block) []Span {
+	spans := Spans(b)
+	for i := range spans {
+		spans[i].Attr.FontFamily = sqdoc.FontFamilyMonospace
+	}
+	return spans
+}
+
+// ParaFormat returns the paragraph format text block tb is laid out with:
+// code blocks keep to the left whatever alignment they carry.
+func ParaFormat(tb *sqdoc.TextBlock) sqdoc.ParagraphFormat {
+	p := tb.Para
+	if tb.Code != nil {
+		p.Align = sqdoc.AlignLeft
+	}
+	return p
+}
@@ -0,0 +1,345 @@
+// Package convert holds the pieces shared by the format converters in its
+// subpackages: splitting text blocks into styled spans for export and
+// building blocks back up on import.
+package convert
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"sqdoc/pkg/sqdoc"
+)
+
+// HeadingSizes maps heading levels 1..5 to the font size importers use.
+// Exporters treat a block that is entirely bold at one of these sizes as a
+// heading of that level.
+var HeadingSizes = [...]uint16{24, 20, 18, 16, 15}
+
+func DefaultAttr() sqdoc.StyleAttr {
+	return sqdoc.StyleAttr{FontSizePt: 14, ColorRGBA: 0x202020FF, FontFamily: sqdoc.FontFamilySans}
+}
+
+func HeadingAttr(level int) sqdoc.StyleAttr {
+	attr := DefaultAttr()
+	attr.Bold = true
+	if level >= 1 && level <= len(HeadingSizes) {
+		attr.FontSizePt = HeadingSizes[level-1]
+	}
+	return attr
+}
+
+type Span struct {
+	Text  string
+	Attr  sqdoc.StyleAttr
+	Image *sqdoc.ImageToken
+}
+
+// Spans splits a text block at style-run and image-token boundaries. Bytes
+// not covered by any run get DefaultAttr.
+func Spans(b sqdoc.Block) []Span {
+	if b.Text == nil || len(b.Text.UTF8) == 0 {
+		return nil
+	}
+	text := b.Text.UTF8
+	runs := append([]sqdoc.StyleRun(nil), b.Text.Runs...)
+	sort.Slice(runs, func(i, j int) bool { return runs[i].Start < runs[j].Start })
+	images := sqdoc.ParseImageTokens(text)
+
+	cuts := map[int]bool{0: true, len(text): true}
+	for _, r := range runs {
+		cuts[clamp(int(r.Start), len(text))] = true
+		cuts[clamp(int(r.End), len(text))] = true
+	}
+	for _, img := range images {
+		cuts[img.Start] = true
+		cuts[img.End] = true
+	}
+	points := make([]int, 0, len(cuts))
+	for p := range cuts {
+		points = append(points, p)
+	}
+	sort.Ints(points)
+
+	out := make([]Span, 0, len(points))
+	for i := 0; i+1 < len(points); i++ {
+		start, end := points[i], points[i+1]
+		span := Span{Text: string(text[start:end]), Attr: attrAt(runs, start)}
+		// Other formats get cross-references as links to their targets.
+		if span.Attr.Link == "" && span.Attr.Ref.Target != "" {
+			span.Attr.Link = "#" + span.Attr.Ref.Target
+		}
+		for k := range images {
+			if images[k].Start == start && images[k].End == end {
+				img := images[k]
+				span.Image = &img
+				break
+			}
+		}
+		// Merge plain text that only got cut by a boundary with no effect.
+		if n := len(out); n > 0 && span.Image == nil && out[n-1].Image == nil && sqdoc.DiffAttrs(out[n-1].Attr, span.Attr) == 0 && out[n-1].Attr.Link == span.Attr.Link && out[n-1].Attr.Ref == span.Attr.Ref && out[n-1].Attr.Note == span.Attr.Note {
+			out[n-1].Text += span.Text
+			continue
+		}
+		out = append(out, span)
+	}
+	return out
+}
+
+// HeadingLevel reports a block's heading level from its paragraph style or,
+// failing that, from its styling. It returns 0 for body text.
+func HeadingLevel(b sqdoc.Block) int {
+	if b.Text != nil {
+		if level := sqdoc.HeadingStyleLevel(b.Text.Style); level > 0 {
+			return level
+		}
+	}
+	spans := Spans(b)
+	if len(spans) == 0 {
+		return 0
+	}
+	size := spans[0].Attr.FontSizePt
+	for _, s := range spans {
+		if s.Image != nil || !s.Attr.Bold || s.Attr.FontSizePt != size {
+			return 0
+		}
+	}
+	for i, hs := range HeadingSizes {
+		if size == hs {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// BlockAnchors maps the IDs of blocks that internal links can name to
+// their anchor names in order, for exporters that mark the blocks as link
+// targets. Bookmarks within a block mark its start.
+func BlockAnchors(doc *sqdoc.Document) map[uint64][]string {
+	out := map[uint64][]string{}
+	for name, at := range doc.Anchors() {
+		id := doc.Blocks[at.Block].ID
+		out[id] = append(out[id], name)
+	}
+	for _, names := range out {
+		sort.Strings(names)
+	}
+	return out
+}
+
+// Note is a footnote or endnote with the spans of its text.
+type Note struct {
+	sqdoc.NoteRef
+	Spans []Span
+}
+
+// NoteSet holds the notes of a document during export. Exporters write a
+// note where its first anchor is, or list them all at the end, and show
+// later anchors to the same note as its number.
+type NoteSet struct {
+	// List holds the notes in the order of their numbers, footnotes first.
+	List []Note
+	byID map[uint64]int
+	seen map[uint64]bool
+}
+
+func NewNoteSet(doc *sqdoc.Document) *NoteSet {
+	ns := &NoteSet{byID: map[uint64]int{}, seen: map[uint64]bool{}}
+	for _, ref := range doc.Notes() {
+		ns.byID[ref.ID] = len(ns.List)
+		ns.List = append(ns.List, Note{NoteRef: ref, Spans: Spans(doc.Blocks[ref.Block])})
+	}
+	return ns
+}
+
+// Anchor returns the note span s is an anchor to, or nil, and whether this
+// is the first time Anchor has seen it.
+func (ns *NoteSet) Anchor(s Span) (*Note, bool) {
+	i, ok := ns.byID[s.Attr.Note]
+	if !ok || s.Attr.Note == 0 {
+		return nil, false
+	}
+	first := !ns.seen[s.Attr.Note]
+	ns.seen[s.Attr.Note] = true
+	return &ns.List[i], first
+}
+
+// LinkTarget returns an imported hyperlink target as a run may carry it, or
+// "" when it is empty, too long or holds control characters.
+func LinkTarget(href string) string {
+	href = strings.TrimSpace(href)
+	if len(href) > sqdoc.MaxLinkLength || !utf8.ValidString(href) || strings.ContainsFunc(href, unicode.IsControl) {
+		return ""
+	}
+	return href
+}
+
+// ImageDisplaySize is the size the editor draws an inline image at 100%
+// zoom. natW and natH are the decoded pixel size, or zero when the file is
+// unreadable; reqW and reqH come from the image token. Callers cap the
+// result to their page width.
+func ImageDisplaySize(natW, natH, reqW, reqH int, fontSizePt uint16) (int, int) {
+	if fontSizePt == 0 {
+		fontSizePt = 14
+	}
+	targetH := min(max(int(float64(fontSizePt)*2.1), 26), 400)
+	if natW <= 0 || natH <= 0 {
+		w, h := reqW, reqH
+		switch {
+		case w <= 0 && h <= 0:
+			w, h = int(float64(targetH)*1.4), targetH
+		case h <= 0:
+			h = targetH
+		case w <= 0:
+			w = int(float64(h) * 1.4)
+		}
+		return max(24, w), max(20, h)
+	}
+	switch {
+	case reqW > 0 && reqH > 0:
+		return reqW, reqH
+	case reqW > 0:
+		return reqW, max(20, int(float64(natH)*float64(reqW)/float64(natW)))
+	case reqH > 0:
+		return max(24, int(float64(natW)*float64(reqH)/float64(natH))), reqH
+	}
+	return int(float64(natW) * float64(targetH) / float64(natH)), targetH
+}
+
+// FamilyForFont maps a font name from another format onto the closest
+// SQDoc family. Unknown names are treated as sans-serif.
+func FamilyForFont(name string) sqdoc.FontFamily {
+	n := strings.ToLower(name)
+	for _, mono := range []string{"mono", "courier", "consolas", "menlo", "code", "fixed"} {
+		if strings.Contains(n, mono) {
+			return sqdoc.FontFamilyMonospace
+		}
+	}
+	if strings.Contains(n, "sans") {
+		return sqdoc.FontFamilySans
+	}
+	for _, serif := range []string{"serif", "times", "georgia", "cambria", "garamond", "book", "palatino", "roman"} {
+		if strings.Contains(n, serif) {
+			return sqdoc.FontFamilySerif
+		}
+	}
+	return sqdoc.FontFamilySans
+}
+
+func attrAt(runs []sqdoc.StyleRun, pos int) sqdoc.StyleAttr {
+	for _, r := range runs {
+		if int(r.Start) <= pos && pos < int(r.End) {
+			return r.Attr
+		}
+	}
+	return DefaultAttr()
+}
+
+func clamp(v, hi int) int {
+	if v < 0 {
+		return 0
+	}
+	if v > hi {
+		return hi
+	}
+	return v
+}
+
+// BlockBuilder accumulates styled text for one block, merging adjacent
+// writes that share an attribute into a single run.
+type BlockBuilder struct {
+	text []byte
+	runs []sqdoc.StyleRun
+}
+
+func (bb *BlockBuilder) WriteString(s string, attr sqdoc.StyleAttr) {
+	if s == "" {
+		return
+	}
+	start := uint32(len(bb.text))
+	bb.text = append(bb.text, s...)
+	end := uint32(len(bb.text))
+	if n := len(bb.runs); n > 0 && bb.runs[n-1].End == start && bb.runs[n-1].Attr == attr {
+		bb.runs[n-1].End = end
+		return
+	}
+	bb.runs = append(bb.runs, sqdoc.StyleRun{Start: start, End: end, Attr: attr})
+}
+
+func (bb *BlockBuilder) WriteImage(path string, w, h int, attr sqdoc.StyleAttr) {
+	bb.WriteString(sqdoc.MakeImageToken(path, w, h), attr)
+}
+
+func (bb *BlockBuilder) Len() int {
+	return len(bb.text)
+}
+
+// Bytes returns the text written so far; callers must not modify it.
+func (bb *BlockBuilder) Bytes() []byte {
+	return bb.text
+}
+
+// TrimTrailingSpace drops trailing ASCII spaces, shrinking runs to match.
+func (bb *BlockBuilder) TrimTrailingSpace() {
+	n := len(bb.text)
+	for n > 0 && (bb.text[n-1] == ' ' || bb.text[n-1] == '\t') {
+		n--
+	}
+	bb.truncate(n)
+}
+
+func (bb *BlockBuilder) truncate(n int) {
+	bb.text = bb.text[:n]
+	keep := bb.runs[:0]
+	for _, r := range bb.runs {
+		if int(r.Start) >= n {
+			continue
+		}
+		if int(r.End) > n {
+			r.End = uint32(n)
+		}
+		keep = append(keep, r)
+	}
+	bb.runs = keep
+}
+
+// TextBlock returns a copy of the accumulated text and resets the builder.
+func (bb *BlockBuilder) TextBlock() *sqdoc.TextBlock {
+	tb := &sqdoc.TextBlock{
+		UTF8: append([]byte(nil), bb.text...),
+		Runs: append([]sqdoc.StyleRun(nil), bb.runs...),
+	}
+	bb.text = bb.text[:0]
+	bb.runs = bb.runs[:0]
+	return tb
+}
+
+// DocBuilder assigns sequential block IDs as blocks are appended.
+type DocBuilder struct {
+	doc    *sqdoc.Document
+	nextID uint64
+}
+
+func NewDocBuilder(title string) *DocBuilder {
+	return &DocBuilder{doc: sqdoc.NewDocument("", title), nextID: 1}
+}
+
+func (d *DocBuilder) Add(tb *sqdoc.TextBlock) {
+	d.doc.Blocks = append(d.doc.Blocks, sqdoc.Block{ID: d.nextID, Kind: sqdoc.BlockKindText, Text: tb})
+	d.nextID++
+}
+
+func (d *DocBuilder) AddTable(t *sqdoc.TableBlock) {
+	d.doc.Blocks = append(d.doc.Blocks, sqdoc.Block{ID: d.nextID, Kind: sqdoc.BlockKindTable, Table: t})
+	d.nextID++
+}
+
+// Document returns the built document, with one empty block if nothing was
+// added so it always opens in the editor.
+func (d *DocBuilder) Document() *sqdoc.Document {
+	if len(d.doc.Blocks) == 0 {
+		d.Add(&sqdoc.TextBlock{})
+	}
+	return d.doc
+}
@@ -0,0 +1,211 @@
+package convert
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"sqdoc/pkg/sqdoc"
+)
+
+func TestSpansSplitAtRunsAndImages(t *testing.T) {
+	bold := DefaultAttr()
+	bold.Bold = true
+	var bb BlockBuilder
+	bb.WriteString("ab", DefaultAttr())
+	bb.WriteString("cd", bold)
+	bb.WriteImage("/tmp/x.png", 10, 20, bold)
+	bb.WriteString("e", DefaultAttr())
+	block := sqdoc.Block{ID: 1, Kind: sqdoc.BlockKindText, Text: bb.TextBlock()}
+	if err := sqdoc.Validate(&sqdoc.Document{Blocks: []sqdoc.Block{block}}); err != nil {
+		t.Fatalf("builder produced invalid block: %v", err)
+	}
+
+	spans := Spans(block)
+	if len(spans) != 4 {
+		t.Fatalf("expected 4 spans, got %#v", spans)
+	}
+	if spans[0].Text != "ab" || spans[1].Text != "cd" || !spans[1].Attr.Bold {
+		t.Fatalf("unexpected text spans: %#v", spans[:2])
+	}
+	if spans[2].Image == nil || spans[2].Image.Path != "/tmp/x.png" || spans[2].Image.Width != 10 || !spans[2].Attr.Bold {
+		t.Fatalf("unexpected image span: %#v", spans[2])
+	}
+	if spans[3].Text != "e" || spans[3].Attr.Bold {
+		t.Fatalf("unexpected trailing span: %#v", spans[3])
+	}
+}
+
+func TestHeadingLevelRequiresUniformBoldSize(t *testing.T) {
+	var bb BlockBuilder
+	bb.WriteString("Title", HeadingAttr(2))
+	if got := HeadingLevel(sqdoc.Block{Text: bb.TextBlock()}); got != 2 {
+		t.Fatalf("heading level = %d, want 2", got)
+	}
+	bb.WriteString("Title", HeadingAttr(2))
+	bb.WriteString(" body", DefaultAttr())
+	if got := HeadingLevel(sqdoc.Block{Text: bb.TextBlock()}); got != 0 {
+		t.Fatalf("mixed block heading level = %d, want 0", got)
+	}
+}
+
+func TestNoteSetFirstAnchor(t *testing.T) {
+	anchor := DefaultAttr()
+	anchor.Note = 5
+	var bb BlockBuilder
+	bb.WriteString("See", DefaultAttr())
+	bb.WriteString("1", anchor)
+	bb.WriteString(" and", DefaultAttr())
+	bb.WriteString("1", anchor)
+	body := sqdoc.Block{ID: 1, Kind: sqdoc.BlockKindText, Text: bb.TextBlock()}
+	bb.WriteString("The note", DefaultAttr())
+	note := sqdoc.Block{ID: 5, Kind: sqdoc.BlockKindNote, Text: bb.TextBlock()}
+	ns := NewNoteSet(&sqdoc.Document{Blocks: []sqdoc.Block{body, note}})
+
+	spans := Spans(body)
+	if len(spans) != 4 {
+		t.Fatalf("expected 4 spans, got %#v", spans)
+	}
+	if n, first := ns.Anchor(spans[1]); n == nil || !first || n.Number != "1" || n.Spans[0].Text != "The note" {
+		t.Fatalf("first anchor = %#v, %v", n, first)
+	}
+	if n, first := ns.Anchor(spans[3]); n == nil || first {
+		t.Fatalf("second anchor = %#v, %v", n, first)
+	}
+	if n, _ := ns.Anchor(spans[0]); n != nil {
+		t.Fatal("plain text counted as an anchor")
+	}
+}
+
+func TestTableRowsMarksCoveredCells(t *testing.T) {
+	table := sqdoc.NewTable(3, 3)
+	table.HeaderRows = 1
+	table.Rows[1][0].ColSpan, table.Rows[1][0].RowSpan = 2, 5
+	table.Rows[1][0].Text = &sqdoc.TextBlock{UTF8: []byte("big")}
+	rows := TableRows(table)
+	big := rows[1][0]
+	if big.Covered || big.ColSpan != 2 || big.RowSpan != 2 || big.Header || len(big.Spans) != 1 {
+		t.Fatalf("spanning cell = %+v", big)
+	}
+	for _, rc := range [][2]int{{1, 1}, {2, 0}, {2, 1}} {
+		c := rows[rc[0]][rc[1]]
+		if !c.Covered || c.OwnerRow != 1 || c.OwnerCol != 0 {
+			t.Fatalf("cell %v = %+v", rc, c)
+		}
+	}
+	if rows[2][2].Covered || !rows[0][1].Header || rows[0][1].Shade() != HeaderShadeRGBA {
+		t.Fatalf("plain cells = %+v, %+v", rows[2][2], rows[0][1])
+	}
+}
+
+func TestTableBuilderRepairsRaggedTables(t *testing.T) {
+	text := func(s string) *sqdoc.TextBlock {
+		var bb BlockBuilder
+		bb.WriteString(s, DefaultAttr())
+		return bb.TextBlock()
+	}
+	var tb TableBuilder
+	tb.Column(90)
+	tb.Row()
+	tb.HeaderRow()
+	tb.Cell(sqdoc.TableCell{Text: text("wide"), ColSpan: 2, Background: HeaderShadeRGBA})
+	tb.Covered() // listed by formats that write covered cells
+	tb.Cell(sqdoc.TableCell{Text: text("tall"), RowSpan: 3})
+	tb.Row()
+	tb.Cell(sqdoc.TableCell{Text: text("merged down"), Borders: sqdoc.BorderAll})
+	tb.Cell(sqdoc.TableCell{})
+	tb.Cell(sqdoc.TableCell{Text: text("in the way")})
+	tb.Row()
+	tb.HeaderRow() // not a leading row
+	tb.Continue()
+
+	table := tb.Table()
+	if err := validateTableBlock(table); err != nil {
+		t.Fatal(err)
+	}
+	if len(table.Columns) != 3 || table.Columns[0] != 90 || table.HeaderRows != 1 {
+		t.Fatalf("columns %v, %d header rows", table.Columns, table.HeaderRows)
+	}
+	if c := table.Rows[0][0]; c.ColSpan != 2 || c.Background != 0 {
+		t.Fatalf("header cell %+v", c)
+	}
+	// The tall cell runs into a cell below, so it is cut back to one row.
+	if c := table.Rows[0][2]; c.RowSpan != 0 || string(c.Text.UTF8) != "tall" {
+		t.Fatalf("tall cell %+v", c)
+	}
+	if c := table.Rows[1][0]; c.RowSpan != 2 || !table.Covered(2, 0) || table.Covered(2, 1) {
+		t.Fatalf("merged cell %+v", c)
+	}
+	if tb := (&TableBuilder{}); tb.Table() != nil {
+		t.Fatal("empty table built")
+	}
+}
+
+// validateTableBlock checks a table the way loading a document would.
+func validateTableBlock(table *sqdoc.TableBlock) error {
+	doc := sqdoc.NewDocument("", "")
+	doc.Blocks = []sqdoc.Block{{ID: 1, Kind: sqdoc.BlockKindTable, Table: table}}
+	return sqdoc.Validate(doc)
+}
+
+func TestCellTextJoinsParagraphsAndFlattensTables(t *testing.T) {
+	para := func(s string) sqdoc.Block {
+		var bb BlockBuilder
+		bb.WriteString(s, DefaultAttr())
+		return sqdoc.Block{Kind: sqdoc.BlockKindText, Text: bb.TextBlock()}
+	}
+	nested := sqdoc.NewTable(2, 2)
+	for i, s := range []string{"a", "b", "c", "d"} {
+		nested.Rows[i/2][i%2].Text = para(s).Text
+	}
+	got := CellText([]sqdoc.Block{para("first"), para(""), {Kind: sqdoc.BlockKindTable, Table: nested}})
+	if string(got.UTF8) != "first\n\na\tb\nc\td" {
+		t.Fatalf("cell text %q", got.UTF8)
+	}
+}
+
+func TestSaveMediaSharesOneTemporaryFolder(t *testing.T) {
+	if got := DefaultMediaDir(filepath.Join("docs", "report.docx")); got != filepath.Join("docs", "report_media") {
+		t.Fatalf("DefaultMediaDir = %q", got)
+	}
+	var dir string
+	first, err := SaveMedia(&dir, "sqdoc-test-", "a.png", []byte("one"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	second, err := SaveMedia(&dir, "sqdoc-test-", "b.png", []byte("two"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dir == "" || filepath.Dir(first) != filepath.Dir(second) || !filepath.IsAbs(first) {
+		t.Fatalf("media went to %q and %q, dir %q", first, second, dir)
+	}
+	if data, err := os.ReadFile(second); err != nil || string(data) != "two" {
+		t.Fatalf("read back %q, %v", data, err)
+	}
+}
+
+func TestListNestingOpensAndClosesLevels(t *testing.T) {
+	var ln ListNesting
+	step := func(item sqdoc.ListItem, wantClosed, wantOpened int) {
+		t.Helper()
+		closed, opened := ln.Next(item)
+		if len(closed) != wantClosed || len(opened) != wantOpened {
+			t.Fatalf("Next(%+v) closed %d and opened %d, want %d and %d", item, len(closed), len(opened), wantClosed, wantOpened)
+		}
+	}
+	step(sqdoc.ListItem{ID: 1}, 0, 1)
+	step(sqdoc.ListItem{ID: 1, Level: 2, Style: sqdoc.ListDecimal}, 0, 2)
+	if ln.Depth() != 3 {
+		t.Fatalf("depth = %d, want 3", ln.Depth())
+	}
+	step(sqdoc.ListItem{ID: 1, Level: 1, Style: sqdoc.ListDecimal}, 1, 0)
+	// A new style at a level starts a new list there.
+	step(sqdoc.ListItem{ID: 1, Level: 1, Style: sqdoc.ListLowerAlpha}, 1, 1)
+	step(sqdoc.ListItem{ID: 2}, 2, 1)
+	step(sqdoc.ListItem{}, 1, 0)
+	if ln.Depth() != 0 {
+		t.Fatalf("depth = %d after leaving the list", ln.Depth())
+	}
+}
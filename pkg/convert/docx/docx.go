@@ -0,0 +1,108 @@
+// Package docx converts between Office Open XML word processing files and
+// SQDoc documents using only archive/zip and encoding/xml.
+//
+// Paragraphs map to text blocks and run properties (bold, italic, underline,
+// highlight, size, colour and font) to style runs; hyperlinks keep their
+// targets. Numbered and bulleted paragraphs become list items through
+// word/numbering.xml, and code blocks take the Source Code paragraph style.
+// Export writes paragraph alignment, indents, spacing and line height as
+// w:jc, w:ind and w:spacing.
+// Tables map to w:tbl with their merged cells, shading and borders.
+// Images are extracted from word/media and core properties map to the
+// document metadata. Constructs SQDoc has no equivalent for are reduced to
+// their text and reported as warnings.
+package docx
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"sqdoc/pkg/convert"
+	"sqdoc/pkg/sqdoc"
+)
+
+const (
+	nsW   = "http://schemas.openxmlformats.org/wordprocessingml/2006/main"
+	nsR   = "http://schemas.openxmlformats.org/officeDocument/2006/relationships"
+	nsWP  = "http://schemas.openxmlformats.org/drawingml/2006/wordprocessingDrawing"
+	nsA   = "http://schemas.openxmlformats.org/drawingml/2006/main"
+	nsPic = "http://schemas.openxmlformats.org/drawingml/2006/picture"
+
+	relImage     = "http://schemas.openxmlformats.org/officeDocument/2006/relationships/image"
+	relHyperlink = "http://schemas.openxmlformats.org/officeDocument/2006/relationships/hyperlink"
+	relStyles    = "http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles"
+	relNumbering = "http://schemas.openxmlformats.org/officeDocument/2006/relationships/numbering"
+	relFootnotes = "http://schemas.openxmlformats.org/officeDocument/2006/relationships/footnotes"
+	relEndnotes  = "http://schemas.openxmlformats.org/officeDocument/2006/relationships/endnotes"
+	relOffice    = "http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument"
+	relCore      = "http://schemas.openxmlformats.org/package/2006/relationships/metadata/core-properties"
+
+	// emuPerPixel converts DrawingML extents at 96 DPI.
+	emuPerPixel = 9525
+
+	// codeStyle is the paragraph style of code blocks.
+	codeStyle = "SourceCode"
+)
+
+type Options struct {
+	// MediaDir receives the word/media parts that drawings show, under their
+	// part names. ImportFile defaults it to convert.DefaultMediaDir; Import
+	// uses a new temporary folder.
+	MediaDir string
+	// BaseDir resolves relative image paths that export packs into
+	// word/media.
+	BaseDir string
+}
+
+// ImportFile reads a .docx package, titling the document after the file
+// when docProps/core.xml has no title. The returned warnings describe
+// content that was simplified or dropped.
+func ImportFile(path string, opts Options) (*sqdoc.Document, []string, error) {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if opts.MediaDir == "" {
+		opts.MediaDir = convert.DefaultMediaDir(path)
+	}
+	doc, warnings, err := Import(src, opts)
+	if err != nil {
+		return nil, warnings, err
+	}
+	if doc.Metadata.Title == "" {
+		doc.Metadata.Title = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	return doc, warnings, nil
+}
+
+func ExportFile(path string, doc *sqdoc.Document, opts Options) error {
+	out, err := Export(doc, opts)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, out, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// valXML is the common <w:x w:val="..."/> shape.
+type valXML struct {
+	Val string `xml:"val,attr"`
+}
+
+type rPrXML struct {
+	RStyle    *valXML `xml:"rStyle"`
+	B         *valXML `xml:"b"`
+	I         *valXML `xml:"i"`
+	U         *valXML `xml:"u"`
+	Highlight *valXML `xml:"highlight"`
+	Sz        *valXML `xml:"sz"`
+	Color     *valXML `xml:"color"`
+	RFonts    *struct {
+		ASCII string `xml:"ascii,attr"`
+		HAnsi string `xml:"hAnsi,attr"`
+	} `xml:"rFonts"`
+}
@@ -0,0 +1,498 @@
+package docx
+
+import (
+	"archive/zip"
+	"bytes"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"sqdoc/pkg/convert"
+	"sqdoc/pkg/sqdoc"
+)
+
+func TestExportImportRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	imgPath := filepath.Join(dir, "dot.png")
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 30, 20))); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(imgPath, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	doc := sqdoc.NewDocument("Mina Park", "Report")
+	doc.Metadata.CreatedUnix = 1700000000
+	doc.Metadata.ModifiedUnix = 1700000500
+	doc.Metadata.ParagraphGap = 10
+
+	var bb convert.BlockBuilder
+	bb.WriteString("Summary", convert.HeadingAttr(2))
+	doc.Blocks = append(doc.Blocks, sqdoc.Block{ID: 1, Kind: sqdoc.BlockKindText, Text: bb.TextBlock()})
+
+	fancy := convert.DefaultAttr()
+	fancy.Italic = true
+	fancy.Underline = true
+	fancy.Highlight = true
+	fancy.FontSizePt = 11
+	fancy.ColorRGBA = 0x1F4E79FF
+	fancy.FontFamily = sqdoc.FontFamilySerif
+	code := convert.DefaultAttr()
+	code.FontFamily = sqdoc.FontFamilyMonospace
+	bb.WriteString("plain & <odd>\t", convert.DefaultAttr())
+	bb.WriteString("fancy", fancy)
+	bb.WriteString("\nx := 1 ", code)
+	bb.WriteImage(imgPath, 60, 40, convert.DefaultAttr())
+	body := bb.TextBlock()
+	doc.Blocks = append(doc.Blocks, sqdoc.Block{ID: 2, Kind: sqdoc.BlockKindText, Text: body})
+
+	out, err := Export(doc, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mediaDir := filepath.Join(dir, "media")
+	back, warnings, err := Import(out, Options{MediaDir: mediaDir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(warnings) != 0 {
+		t.Fatalf("unexpected warnings: %v", warnings)
+	}
+	if back.Metadata.Title != "Report" || back.Metadata.Author != "Mina Park" ||
+		back.Metadata.CreatedUnix != 1700000000 || back.Metadata.ModifiedUnix != 1700000500 {
+		t.Fatalf("metadata = %+v", back.Metadata)
+	}
+	if len(back.Blocks) != 2 {
+		t.Fatalf("got %d blocks", len(back.Blocks))
+	}
+	if convert.HeadingLevel(back.Blocks[0]) != 2 {
+		t.Fatal("heading level lost")
+	}
+
+	got := convert.Spans(back.Blocks[1])
+	want := convert.Spans(sqdoc.Block{Kind: sqdoc.BlockKindText, Text: body})
+	if len(got) != len(want) {
+		t.Fatalf("spans: got %d want %d\n%+v", len(got), len(want), got)
+	}
+	for i := range want {
+		if want[i].Image != nil {
+			img := got[i].Image
+			if img == nil || img.Width != 60 || img.Height != 40 || filepath.Dir(img.Path) != mediaDir {
+				t.Fatalf("image span = %+v", got[i])
+			}
+			if data, err := os.ReadFile(img.Path); err != nil || !bytes.Equal(data, buf.Bytes()) {
+				t.Fatalf("extracted image differs: %v", err)
+			}
+			continue
+		}
+		if got[i].Text != want[i].Text || got[i].Attr != want[i].Attr {
+			t.Fatalf("span %d: got %q %+v want %q %+v", i, got[i].Text, got[i].Attr, want[i].Text, want[i].Attr)
+		}
+	}
+}
+
+func buildDocx(t *testing.T, parts map[string]string) []byte {
+	t.Helper()
+	var out bytes.Buffer
+	zw := zip.NewWriter(&out)
+	for name, content := range parts {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return out.Bytes()
+}
+
+// readPart returns a part of an exported package, failing if it is missing.
+func readPart(t *testing.T, pkg []byte, name string) string {
+	t.Helper()
+	zr, err := zip.NewReader(bytes.NewReader(pkg), int64(len(pkg)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range zr.File {
+		if f.Name != name {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rc.Close()
+		var data bytes.Buffer
+		if _, err := data.ReadFrom(rc); err != nil {
+			t.Fatal(err)
+		}
+		return data.String()
+	}
+	t.Fatalf("package lacks %s", name)
+	return ""
+}
+
+const wordNS = `xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main"`
+
+func TestImportStylesListsAndDegradedContent(t *testing.T) {
+	src := buildDocx(t, map[string]string{
+		"word/styles.xml": `<w:styles ` + wordNS + `>
+<w:docDefaults><w:rPrDefault><w:rPr><w:rFonts w:ascii="Calibri"/><w:sz w:val="22"/></w:rPr></w:rPrDefault></w:docDefaults>
+<w:style w:type="paragraph" w:styleId="Title"><w:name w:val="Title"/><w:rPr><w:sz w:val="56"/></w:rPr></w:style>
+<w:style w:type="character" w:styleId="Code"><w:name w:val="Code"/><w:rPr><w:rFonts w:ascii="Consolas"/></w:rPr></w:style>
+<w:style w:type="character" w:styleId="Strong"><w:name w:val="Strong"/><w:basedOn w:val="Code"/><w:rPr><w:b/></w:rPr></w:style>
+</w:styles>`,
+		"word/numbering.xml": `<w:numbering ` + wordNS + `>
+<w:abstractNum w:abstractNumId="0"><w:lvl w:ilvl="0"><w:start w:val="1"/><w:numFmt w:val="decimal"/></w:lvl><w:lvl w:ilvl="1"><w:numFmt w:val="bullet"/></w:lvl></w:abstractNum>
+<w:num w:numId="3"><w:abstractNumId w:val="0"/></w:num>
+</w:numbering>`,
+		"word/document.xml": `<w:document ` + wordNS + `><w:body>
+<w:p><w:pPr><w:pStyle w:val="Title"/></w:pPr><w:r><w:t>Big</w:t></w:r></w:p>
+<w:p><w:r><w:rPr><w:rStyle w:val="Strong"/></w:rPr><w:t>strong code</w:t></w:r><w:hyperlink><w:r><w:t xml:space="preserve"> link</w:t></w:r></w:hyperlink><w:del><w:r><w:delText>gone</w:delText></w:r></w:del></w:p>
+<w:p><w:pPr><w:numPr><w:ilvl w:val="0"/><w:numId w:val="3"/></w:numPr></w:pPr><w:r><w:t>one</w:t></w:r></w:p>
+<w:p><w:pPr><w:numPr><w:ilvl w:val="1"/><w:numId w:val="3"/></w:numPr></w:pPr><w:r><w:t>sub</w:t></w:r></w:p>
+<w:p><w:pPr><w:numPr><w:ilvl w:val="0"/><w:numId w:val="3"/></w:numPr></w:pPr><w:r><w:t>two</w:t></w:r></w:p>
+<w:tbl><w:tblPr/><w:tr><w:tc><w:p><w:r><w:t>a</w:t></w:r></w:p></w:tc><w:tc><w:p><w:r><w:t>b</w:t></w:r></w:p></w:tc></w:tr></w:tbl>
+<w:sectPr/></w:body></w:document>`,
+	})
+	doc, warnings, err := Import(src, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var texts []string
+	for _, b := range doc.Blocks[:5] {
+		texts = append(texts, string(b.Text.UTF8))
+	}
+	if got := strings.Join(texts, "|"); got != "Big|strong code link|one|sub|two" {
+		t.Fatalf("texts = %q", got)
+	}
+	table := doc.Blocks[5].Table
+	if len(doc.Blocks) != 6 || table == nil {
+		t.Fatalf("imported %d blocks, last %+v", len(doc.Blocks), doc.Blocks[len(doc.Blocks)-1])
+	}
+	if a, b := table.Rows[0][0].Text.UTF8, table.Rows[0][1].Text.UTF8; string(a) != "a" || string(b) != "b" || table.Rows[0][0].Borders != 0 {
+		t.Fatalf("table cells %q %q, borders %04b", a, b, table.Rows[0][0].Borders)
+	}
+	for i, want := range []sqdoc.ListItem{{}, {}, {ID: 1, Style: sqdoc.ListDecimal}, {ID: 1, Level: 1}, {ID: 1, Style: sqdoc.ListDecimal}} {
+		if got := doc.Blocks[i].Text.List; got != want {
+			t.Fatalf("block %d list = %+v, want %+v", i, got, want)
+		}
+	}
+	if attr := doc.Blocks[0].Text.Runs[0].Attr; !attr.Bold || attr.FontSizePt != 28 {
+		t.Fatalf("title attr = %+v", attr)
+	}
+	if attr := doc.Blocks[1].Text.Runs[0].Attr; !attr.Bold || attr.FontFamily != sqdoc.FontFamilyMonospace || attr.FontSizePt != 11 {
+		t.Fatalf("character style attr = %+v", attr)
+	}
+	joined := strings.Join(warnings, "\n")
+	for _, want := range []string{"hyperlink", "deletions"} {
+		if !strings.Contains(joined, want) {
+			t.Fatalf("warnings %q lack %q", joined, want)
+		}
+	}
+}
+
+func TestImportRejectsNonDocx(t *testing.T) {
+	if _, _, err := Import(buildDocx(t, map[string]string{"x.txt": "hi"}), Options{}); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestLinksRoundTrip(t *testing.T) {
+	web := convert.DefaultAttr()
+	web.Link = "https://example.com/a?b=c&d=e"
+	internal := convert.DefaultAttr()
+	internal.Link = "#intro"
+	var bb convert.BlockBuilder
+	bb.WriteString("Intro", convert.HeadingAttr(1))
+	heading := bb.TextBlock()
+	heading.Style = sqdoc.StyleHeading1
+	bb = convert.BlockBuilder{}
+	bb.WriteString("See ", convert.DefaultAttr())
+	bb.WriteString("the site", web)
+	bb.WriteString(" or ", convert.DefaultAttr())
+	bb.WriteString("intro", internal)
+	doc := sqdoc.NewDocument("", "Links")
+	doc.Blocks = append(doc.Blocks,
+		sqdoc.Block{ID: 1, Kind: sqdoc.BlockKindText, Text: heading},
+		sqdoc.Block{ID: 2, Kind: sqdoc.BlockKindText, Text: bb.TextBlock()},
+	)
+
+	out, err := Export(doc, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	back, _, err := Import(out, Options{MediaDir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	links := map[string]string{}
+	for _, s := range convert.Spans(back.Blocks[1]) {
+		if s.Attr.Link != "" {
+			links[s.Text] = s.Attr.Link
+		}
+	}
+	if len(links) != 2 || links["the site"] != web.Link || links["intro"] != "#intro" {
+		t.Fatalf("links = %q", links)
+	}
+}
+
+func TestListsRoundTrip(t *testing.T) {
+	items := []struct {
+		text string
+		item sqdoc.ListItem
+	}{
+		{"one", sqdoc.ListItem{ID: 1, Style: sqdoc.ListDecimal}},
+		{"sub", sqdoc.ListItem{ID: 1, Level: 1, Style: sqdoc.ListLowerAlpha}},
+		{"two", sqdoc.ListItem{ID: 1, Style: sqdoc.ListDecimal}},
+		{"aside", sqdoc.ListItem{}},
+		{"three", sqdoc.ListItem{ID: 1, Style: sqdoc.ListDecimal}},
+		{"seven", sqdoc.ListItem{ID: 1, Style: sqdoc.ListDecimal, Start: 7}},
+		{"dot", sqdoc.ListItem{ID: 2}},
+	}
+	doc := sqdoc.NewDocument("", "Lists")
+	for i, it := range items {
+		var bb convert.BlockBuilder
+		bb.WriteString(it.text, convert.DefaultAttr())
+		tb := bb.TextBlock()
+		tb.List = it.item
+		doc.Blocks = append(doc.Blocks, sqdoc.Block{ID: uint64(i + 1), Kind: sqdoc.BlockKindText, Text: tb})
+	}
+
+	out, err := Export(doc, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	numbering := readPart(t, out, "word/numbering.xml")
+	for _, want := range []string{`<w:numFmt w:val="lowerLetter"/>`, `<w:startOverride w:val="7"/>`, `<w:lvlText w:val="•"/>`} {
+		if !strings.Contains(numbering, want) {
+			t.Fatalf("numbering.xml lacks %s:\n%s", want, numbering)
+		}
+	}
+	back, _, err := Import(out, Options{MediaDir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(back.Blocks) != len(items) {
+		t.Fatalf("got %d blocks", len(back.Blocks))
+	}
+	for i, it := range items {
+		if got := back.Blocks[i].Text; string(got.UTF8) != it.text || got.List != it.item {
+			t.Errorf("block %d = %q %+v, want %q %+v", i, got.UTF8, got.List, it.text, it.item)
+		}
+	}
+	if got, want := strings.Join(back.ListMarkers(), "|"), "1.|a.|2.||3.|7.|•"; got != want {
+		t.Fatalf("markers = %q, want %q", got, want)
+	}
+}
+
+func TestCodeBlocksRoundTrip(t *testing.T) {
+	var bb convert.BlockBuilder
+	bb.WriteString("if x {\n\treturn\n}", convert.DefaultAttr())
+	code := bb.TextBlock()
+	code.Code = &sqdoc.CodeBlock{Language: "go"}
+	doc := sqdoc.NewDocument("", "Code")
+	doc.Blocks = append(doc.Blocks, sqdoc.Block{ID: 1, Kind: sqdoc.BlockKindText, Text: code})
+
+	out, err := Export(doc, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if styles := readPart(t, out, "word/styles.xml"); !strings.Contains(styles, `<w:style w:type="paragraph" w:styleId="SourceCode">`) {
+		t.Fatalf("styles.xml has no code style:\n%s", styles)
+	}
+	body := readPart(t, out, "word/document.xml")
+	for _, want := range []string{`<w:pPr><w:pStyle w:val="SourceCode"/></w:pPr>`, `<w:rFonts w:ascii="Courier New"`, "<w:br/><w:tab/>"} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("document.xml lacks %s:\n%s", want, body)
+		}
+	}
+	// Word has nowhere to keep the language.
+	back, _, err := Import(out, Options{MediaDir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := back.Blocks[0].Text; len(back.Blocks) != 1 || got.Code == nil || string(got.UTF8) != "if x {\n\treturn\n}" {
+		t.Fatalf("imported %d blocks, first %q code %+v", len(back.Blocks), got.UTF8, got.Code)
+	}
+}
+
+func TestExportNotes(t *testing.T) {
+	foot := convert.DefaultAttr()
+	foot.Note = 5
+	end := convert.DefaultAttr()
+	end.Note = 6
+	web := convert.DefaultAttr()
+	web.Link = "https://example.com/"
+	var bb convert.BlockBuilder
+	bb.WriteString("Claim", convert.DefaultAttr())
+	bb.WriteString("1", foot)
+	bb.WriteString(" again", convert.DefaultAttr())
+	bb.WriteString("1", foot)
+	bb.WriteString("i", end)
+	doc := sqdoc.NewDocument("", "Notes")
+	doc.Blocks = append(doc.Blocks, sqdoc.Block{ID: 1, Kind: sqdoc.BlockKindText, Text: bb.TextBlock()})
+	bb.WriteString("See ", convert.DefaultAttr())
+	bb.WriteString("the source", web)
+	doc.Blocks = append(doc.Blocks, sqdoc.Block{ID: 5, Kind: sqdoc.BlockKindNote, Text: bb.TextBlock()})
+	bb.WriteString("Later.", convert.DefaultAttr())
+	doc.Blocks = append(doc.Blocks, sqdoc.Block{ID: 6, Kind: sqdoc.BlockKindNote, Note: sqdoc.NoteEndnote, Text: bb.TextBlock()})
+
+	out, err := Export(doc, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(out), int64(len(out)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{}
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var data bytes.Buffer
+		_, err = data.ReadFrom(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[f.Name] = data.String()
+	}
+	for name, wants := range map[string][]string{
+		"word/document.xml": {
+			`<w:vertAlign w:val="superscript"/></w:rPr><w:footnoteReference w:id="1"/></w:r>`,
+			`<w:vertAlign w:val="superscript"/></w:rPr><w:t xml:space="preserve">1</w:t></w:r>`,
+			`<w:endnoteReference w:id="1"/>`,
+			`<w:endnotePr><w:numFmt w:val="lowerRoman"/></w:endnotePr>`,
+		},
+		"word/footnotes.xml":            {`<w:footnote w:type="separator" w:id="-1">`, `<w:footnoteRef/></w:r>`, `<w:hyperlink r:id="rIdLink1">`},
+		"word/endnotes.xml":             {`<w:endnote w:id="1">`, "Later."},
+		"word/_rels/document.xml.rels":  {`Target="footnotes.xml"`, `Target="endnotes.xml"`},
+		"word/_rels/footnotes.xml.rels": {`Id="rIdLink1"`},
+		"[Content_Types].xml":           {`PartName="/word/footnotes.xml"`, `PartName="/word/endnotes.xml"`},
+	} {
+		for _, want := range wants {
+			if !strings.Contains(files[name], want) {
+				t.Fatalf("%s lacks %s:\n%s", name, want, files[name])
+			}
+		}
+	}
+}
+
+func TestExportTables(t *testing.T) {
+	table := sqdoc.NewTable(3, 2)
+	table.HeaderRows = 1
+	table.Columns[0] = 90
+	for i, text := range []string{"Name", "Qty", "Apples", "3", "", "4"} {
+		var bb convert.BlockBuilder
+		bb.WriteString(text, convert.DefaultAttr())
+		table.Rows[i/2][i%2].Text = bb.TextBlock()
+	}
+	table.Rows[1][0].RowSpan = 2
+	table.Rows[1][1].Background = 0xFFF4C2FF
+	doc := sqdoc.NewDocument("", "")
+	doc.Blocks = append(doc.Blocks, sqdoc.Block{ID: 1, Kind: sqdoc.BlockKindTable, Table: table})
+
+	out, err := Export(doc, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(out), int64(len(out)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var body bytes.Buffer
+	for _, f := range zr.File {
+		if f.Name == "word/document.xml" {
+			rc, err := f.Open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, _ = body.ReadFrom(rc)
+			rc.Close()
+		}
+	}
+	for _, want := range []string{
+		`<w:tblGrid><w:gridCol w:w="1800"/><w:gridCol w:w="7220"/></w:tblGrid>`,
+		`<w:tr><w:trPr><w:tblHeader/></w:trPr>`,
+		`<w:vMerge w:val="restart"/>`,
+		`<w:vMerge/><w:tcBorders>`,
+		`<w:shd w:val="clear" w:color="auto" w:fill="FFF4C2"/>`,
+		`<w:t xml:space="preserve">Apples</w:t>`,
+		"</w:tbl>\n<w:p/>\n<w:sectPr>",
+	} {
+		if !strings.Contains(body.String(), want) {
+			t.Fatalf("document.xml lacks %s:\n%s", want, body.String())
+		}
+	}
+
+	back, warnings, err := Import(out, Options{MediaDir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := back.Blocks[0].Table
+	if got == nil || len(warnings) > 0 {
+		t.Fatalf("imported %+v with warnings %q", back.Blocks[0], warnings)
+	}
+	if len(got.Columns) != 2 || got.Columns[0] != 90 || got.Columns[1] == 0 || got.HeaderRows != 1 || len(got.Rows) != 3 {
+		t.Fatalf("imported columns %v, %d header rows, %d rows", got.Columns, got.HeaderRows, len(got.Rows))
+	}
+	for r, row := range table.Rows {
+		for c, want := range row {
+			if table.Covered(r, c) {
+				if !got.Covered(r, c) {
+					t.Fatalf("cell %d,%d not covered", r, c)
+				}
+				continue
+			}
+			cell := got.Rows[r][c]
+			if string(cell.Text.UTF8) != string(want.Text.UTF8) || cell.ColSpan != want.ColSpan || cell.RowSpan != want.RowSpan || cell.Background != want.Background || cell.Borders != want.Borders {
+				t.Fatalf("cell %d,%d = %q %+v, want %q %+v", r, c, cell.Text.UTF8, cell, want.Text.UTF8, want)
+			}
+		}
+	}
+	if err := sqdoc.Validate(back); err != nil {
+		t.Fatalf("imported table invalid: %v", err)
+	}
+}
+
+func TestExportParagraphFormats(t *testing.T) {
+	doc := sqdoc.NewDocument("", "Formats")
+	formats := []sqdoc.ParagraphFormat{
+		{Align: sqdoc.AlignJustify, IndentLeft: 36, IndentRight: 18, IndentFirst: 12, SpaceBefore: 6, SpaceAfter: 4, LineHeight: 150, PageBreakBefore: true},
+		{Align: sqdoc.AlignCenter, IndentLeft: 12},
+	}
+	for i, p := range formats {
+		var bb convert.BlockBuilder
+		bb.WriteString("text", convert.DefaultAttr())
+		tb := bb.TextBlock()
+		tb.Para = p
+		doc.Blocks = append(doc.Blocks, sqdoc.Block{ID: uint64(i + 1), Kind: sqdoc.BlockKindText, Text: tb})
+	}
+	doc.Blocks[1].Text.List = sqdoc.ListItem{ID: 1, Level: 1, Style: sqdoc.ListBullet}
+
+	out, err := Export(doc, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := readPart(t, out, "word/document.xml")
+	for _, want := range []string{
+		`<w:pPr><w:pageBreakBefore/><w:spacing w:before="120" w:after="240" w:line="360" w:lineRule="auto"/><w:ind w:left="720" w:right="360" w:firstLine="240"/><w:jc w:val="both"/></w:pPr>`,
+		// The list item keeps its marker hanging in the level's indent.
+		`<w:numId w:val="1"/></w:numPr><w:ind w:left="1200" w:right="0" w:hanging="480"/><w:jc w:val="center"/></w:pPr>`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("document.xml lacks %s:\n%s", want, body)
+		}
+	}
+}
@@ -0,0 +1,666 @@
+package docx
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"sqdoc/pkg/convert"
+	"sqdoc/pkg/sqdoc"
+)
+
+// maxImageWidth keeps pictures inside 6.5 inch text columns.
+const maxImageWidth = 624
+
+type mediaPart struct {
+	relID  string
+	name   string
+	data   []byte
+	w, h   int
+	exists bool
+}
+
+type exporter struct {
+	opts  Options
+	media map[string]*mediaPart
+	parts []*mediaPart
+	shape int
+	// links are the external hyperlink targets, numbered by relationship.
+	links     []string
+	anchors   map[uint64][]string
+	bookmarks int
+	notes     *convert.NoteSet
+	// footnotes and endnotes collect the notes as they are first referred
+	// to, counted by footnoteIDs and endnoteIDs.
+	footnotes, endnotes     bytes.Buffer
+	footnoteIDs, endnoteIDs int
+	// lists become abstract numbering definitions in the order of their
+	// first items, and nums the w:num instances paragraphs refer to.
+	lists    []*wordList
+	listByID map[uint32]*wordList
+	nums     []wordNum
+	// gap is the document's paragraph gap, which space after adds to.
+	gap uint16
+}
+
+// wordList is a list as an abstract numbering definition. Each level takes
+// the style of its first item.
+type wordList struct {
+	abstract int
+	styles   [sqdoc.MaxListLevel + 1]sqdoc.ListStyle
+	seen     [sqdoc.MaxListLevel + 1]bool
+	// num is the w:num the list's items currently refer to.
+	num int
+}
+
+// wordNum is a w:num instance. An item that restarts its count starts a
+// new instance of its list that overrides the start of its level.
+type wordNum struct {
+	abstract int
+	level    int
+	start    uint32
+}
+
+func Export(doc *sqdoc.Document, opts Options) ([]byte, error) {
+	if doc == nil {
+		return nil, errors.New("docx: document is nil")
+	}
+	ex := &exporter{opts: opts, media: map[string]*mediaPart{}, anchors: convert.BlockAnchors(doc), notes: convert.NewNoteSet(doc), listByID: map[uint32]*wordList{}, gap: doc.Metadata.ParagraphGap}
+	var body bytes.Buffer
+	// Word joins adjacent tables and wants a paragraph last in the body,
+	// so a table is followed by an empty paragraph where nothing else
+	// comes between.
+	afterTable := false
+	for _, b := range doc.Blocks {
+		if b.Kind == sqdoc.BlockKindTable && b.Table != nil {
+			if afterTable {
+				body.WriteString("<w:p/>\n")
+			}
+			ex.table(&body, b.Table, float64(doc.Metadata.Page.TextWidth()))
+			afterTable = true
+			continue
+		}
+		if b.Kind != sqdoc.BlockKindText || b.Text == nil {
+			continue
+		}
+		ex.paragraph(&body, b)
+		afterTable = false
+	}
+	if afterTable {
+		body.WriteString("<w:p/>\n")
+	}
+
+	var out bytes.Buffer
+	zw := zip.NewWriter(&out)
+	write := func(name, content string) error {
+		w, err := zw.Create(name)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write([]byte(content))
+		return err
+	}
+	files := []struct{ name, content string }{
+		{"[Content_Types].xml", ex.contentTypes()},
+		{"_rels/.rels", packageRels},
+		{"docProps/core.xml", coreProperties(doc.Metadata)},
+		{"word/styles.xml", stylesXML(doc.Metadata)},
+		{"word/_rels/document.xml.rels", ex.documentRels()},
+		{"word/document.xml", documentXML(body.String(), ex.footnoteIDs+ex.endnoteIDs > 0)},
+	}
+	if len(ex.nums) > 0 {
+		files = append(files, struct{ name, content string }{"word/numbering.xml", ex.numberingXML()})
+	}
+	// Notes may hold images and links too, so their parts get the same
+	// relationships as the document.
+	if ex.footnoteIDs > 0 {
+		files = append(files,
+			struct{ name, content string }{"word/footnotes.xml", notesXML("footnote", ex.footnotes.String())},
+			struct{ name, content string }{"word/_rels/footnotes.xml.rels", ex.relationships("")})
+	}
+	if ex.endnoteIDs > 0 {
+		files = append(files,
+			struct{ name, content string }{"word/endnotes.xml", notesXML("endnote", ex.endnotes.String())},
+			struct{ name, content string }{"word/_rels/endnotes.xml.rels", ex.relationships("")})
+	}
+	for _, f := range files {
+		if err := write(f.name, f.content); err != nil {
+			return nil, err
+		}
+	}
+	for _, p := range ex.parts {
+		w, err := zw.Create("word/media/" + p.name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(p.data); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+func (ex *exporter) paragraph(out *bytes.Buffer, b sqdoc.Block) {
+	out.WriteString("<w:p>")
+	var ppr strings.Builder
+	spans := convert.Spans(b)
+	if b.Text.Code != nil {
+		fmt.Fprintf(&ppr, `<w:pStyle w:val="%s"/>`, codeStyle)
+		spans = convert.CodeSpans(b)
+	} else if level := convert.HeadingLevel(b); level > 0 {
+		fmt.Fprintf(&ppr, `<w:pStyle w:val="Heading%d"/>`, level)
+	}
+	para := convert.ParaFormat(b.Text)
+	if para.PageBreakBefore {
+		ppr.WriteString("<w:pageBreakBefore/>")
+	}
+	list := 0
+	if item := b.Text.List; item.ID != 0 {
+		fmt.Fprintf(&ppr, `<w:numPr><w:ilvl w:val="%d"/><w:numId w:val="%d"/></w:numPr>`, min(item.Level, sqdoc.MaxListLevel), ex.numID(item))
+		list = convert.ListIndentPt * (min(int(item.Level), sqdoc.MaxListLevel) + 1)
+	}
+	ppr.WriteString(paragraphLayout(para, ex.gap, list))
+	if ppr.Len() > 0 {
+		fmt.Fprintf(out, "<w:pPr>%s</w:pPr>", ppr.String())
+	}
+	for _, name := range ex.anchors[b.ID] {
+		ex.bookmarks++
+		fmt.Fprintf(out, `<w:bookmarkStart w:id="%d" w:name="%s"/><w:bookmarkEnd w:id="%d"/>`, ex.bookmarks, escape(name), ex.bookmarks)
+	}
+	ex.runs(out, spans)
+	out.WriteString("</w:p>\n")
+}
+
+// paragraphLayout returns the w:spacing, w:ind and w:jc for paragraph
+// format p, or "" for a plain paragraph. A list item's indent of list
+// points, which its numbering level otherwise supplies, is added to its
+// own with the marker hanging in it.
+func paragraphLayout(p sqdoc.ParagraphFormat, gap uint16, list int) string {
+	var b strings.Builder
+	if p.SpaceBefore != 0 || p.SpaceAfter != 0 || p.LineHeight != 0 {
+		b.WriteString("<w:spacing")
+		if p.SpaceBefore != 0 {
+			fmt.Fprintf(&b, ` w:before="%d"`, int(p.SpaceBefore)*20)
+		}
+		if p.SpaceAfter != 0 {
+			fmt.Fprintf(&b, ` w:after="%d"`, (int(gap)+int(p.SpaceAfter))*20)
+		}
+		if p.LineHeight != 0 {
+			fmt.Fprintf(&b, ` w:line="%d" w:lineRule="auto"`, 240*int(p.LineHeight)/100)
+		}
+		b.WriteString("/>")
+	}
+	if p.IndentLeft != 0 || p.IndentRight != 0 || p.IndentFirst != 0 {
+		fmt.Fprintf(&b, `<w:ind w:left="%d" w:right="%d"`, (int(p.IndentLeft)+list)*20, int(p.IndentRight)*20)
+		first := int(p.IndentFirst)
+		if list > 0 {
+			first -= convert.ListIndentPt
+		}
+		if first < 0 {
+			fmt.Fprintf(&b, ` w:hanging="%d"/>`, -first*20)
+		} else {
+			fmt.Fprintf(&b, ` w:firstLine="%d"/>`, first*20)
+		}
+	}
+	switch p.Align {
+	case sqdoc.AlignCenter:
+		b.WriteString(`<w:jc w:val="center"/>`)
+	case sqdoc.AlignRight:
+		b.WriteString(`<w:jc w:val="right"/>`)
+	case sqdoc.AlignJustify:
+		b.WriteString(`<w:jc w:val="both"/>`)
+	}
+	return b.String()
+}
+
+// numID returns the w:num a list item refers to.
+func (ex *exporter) numID(item sqdoc.ListItem) int {
+	l := ex.listByID[item.ID]
+	if l == nil {
+		l = &wordList{abstract: len(ex.lists)}
+		ex.lists = append(ex.lists, l)
+		ex.listByID[item.ID] = l
+	}
+	level := min(int(item.Level), sqdoc.MaxListLevel)
+	if !l.seen[level] {
+		l.seen[level] = true
+		l.styles[level] = item.Style
+	}
+	if l.num == 0 || item.Start != 0 {
+		ex.nums = append(ex.nums, wordNum{abstract: l.abstract, level: level, start: item.Start})
+		l.num = len(ex.nums)
+	}
+	return l.num
+}
+
+// numberingXML defines the lists, each level indented one step further
+// with its marker hanging in the indent.
+func (ex *exporter) numberingXML() string {
+	indent := convert.ListIndentPt * 20
+	var b strings.Builder
+	b.WriteString(xml.Header)
+	fmt.Fprintf(&b, `<w:numbering xmlns:w="%s">`, nsW)
+	for _, l := range ex.lists {
+		fmt.Fprintf(&b, `<w:abstractNum w:abstractNumId="%d"><w:multiLevelType w:val="hybridMultilevel"/>`, l.abstract)
+		for level, style := range l.styles {
+			text := style.Marker(uint8(level), 0)
+			if style.Numbered() {
+				text = fmt.Sprintf("%%%d.", level+1)
+			}
+			fmt.Fprintf(&b, `<w:lvl w:ilvl="%d"><w:start w:val="1"/><w:numFmt w:val="%s"/><w:lvlText w:val="%s"/><w:lvlJc w:val="left"/>`, level, numFmt(style), escape(text))
+			fmt.Fprintf(&b, `<w:pPr><w:ind w:left="%d" w:hanging="%d"/></w:pPr></w:lvl>`, (level+1)*indent, indent)
+		}
+		b.WriteString(`</w:abstractNum>`)
+	}
+	for i, n := range ex.nums {
+		fmt.Fprintf(&b, `<w:num w:numId="%d"><w:abstractNumId w:val="%d"/>`, i+1, n.abstract)
+		if n.start != 0 {
+			fmt.Fprintf(&b, `<w:lvlOverride w:ilvl="%d"><w:startOverride w:val="%d"/></w:lvlOverride>`, n.level, n.start)
+		}
+		b.WriteString(`</w:num>`)
+	}
+	b.WriteString(`</w:numbering>`)
+	return b.String()
+}
+
+func numFmt(s sqdoc.ListStyle) string {
+	switch s {
+	case sqdoc.ListBullet:
+		return "bullet"
+	case sqdoc.ListLowerAlpha:
+		return "lowerLetter"
+	case sqdoc.ListUpperAlpha:
+		return "upperLetter"
+	case sqdoc.ListLowerRoman:
+		return "lowerRoman"
+	case sqdoc.ListUpperRoman:
+		return "upperRoman"
+	}
+	return "decimal"
+}
+
+// table writes t as a fixed-layout w:tbl across width points. A cell
+// spanning rows starts a vertical merge that the cells it covers in later
+// rows continue.
+func (ex *exporter) table(out *bytes.Buffer, t *sqdoc.TableBlock, width float64) {
+	widths := t.ColumnWidths(width)
+	twips := make([]int, len(widths))
+	total := 0
+	for c, w := range widths {
+		twips[c] = int(w * 20)
+		total += twips[c]
+	}
+	pad := convert.CellPaddingPt * 20
+	fmt.Fprintf(out, `<w:tbl><w:tblPr><w:tblW w:w="%d" w:type="dxa"/><w:tblLayout w:type="fixed"/>`, total)
+	fmt.Fprintf(out, `<w:tblCellMar><w:top w:w="%d" w:type="dxa"/><w:left w:w="%d" w:type="dxa"/><w:bottom w:w="%d" w:type="dxa"/><w:right w:w="%d" w:type="dxa"/></w:tblCellMar></w:tblPr>`, pad, pad, pad, pad)
+	out.WriteString("<w:tblGrid>")
+	for _, w := range twips {
+		fmt.Fprintf(out, `<w:gridCol w:w="%d"/>`, w)
+	}
+	out.WriteString("</w:tblGrid>\n")
+	rows := convert.TableRows(t)
+	for r, row := range rows {
+		out.WriteString("<w:tr>")
+		if r < int(t.HeaderRows) {
+			out.WriteString("<w:trPr><w:tblHeader/></w:trPr>")
+		}
+		for c := 0; c < len(row); {
+			cell := row[c]
+			merge := ""
+			switch {
+			case cell.Covered && cell.OwnerCol == c && cell.OwnerRow < r:
+				cell, merge = rows[cell.OwnerRow][cell.OwnerCol], "<w:vMerge/>"
+			case cell.Covered:
+				c++
+				continue
+			case cell.RowSpan > 1:
+				merge = `<w:vMerge w:val="restart"/>`
+			}
+			w := 0
+			for _, tw := range twips[c : c+cell.ColSpan] {
+				w += tw
+			}
+			fmt.Fprintf(out, `<w:tc><w:tcPr><w:tcW w:w="%d" w:type="dxa"/>`, w)
+			if cell.ColSpan > 1 {
+				fmt.Fprintf(out, `<w:gridSpan w:val="%d"/>`, cell.ColSpan)
+			}
+			out.WriteString(merge)
+			out.WriteString(cellBorders(cell.Borders))
+			if shade := cell.Shade(); shade != 0 {
+				fmt.Fprintf(out, `<w:shd w:val="clear" w:color="auto" w:fill="%06X"/>`, shade>>8)
+			}
+			out.WriteString("</w:tcPr><w:p>")
+			if merge != "<w:vMerge/>" {
+				ex.runs(out, cell.Spans)
+			}
+			out.WriteString("</w:p></w:tc>")
+			c += cell.ColSpan
+		}
+		out.WriteString("</w:tr>\n")
+	}
+	out.WriteString("</w:tbl>\n")
+}
+
+// cellBorders returns the w:tcBorders for the sides in b, or "" for none.
+func cellBorders(b sqdoc.Border) string {
+	if b == 0 {
+		return ""
+	}
+	var sb strings.Builder
+	sb.WriteString("<w:tcBorders>")
+	for _, side := range []struct {
+		border sqdoc.Border
+		name   string
+	}{{sqdoc.BorderTop, "top"}, {sqdoc.BorderLeft, "left"}, {sqdoc.BorderBottom, "bottom"}, {sqdoc.BorderRight, "right"}} {
+		if b&side.border != 0 {
+			fmt.Fprintf(&sb, `<w:%s w:val="single" w:sz="4" w:space="0" w:color="%06X"/>`, side.name, convert.TableBorderRGBA>>8)
+		}
+	}
+	sb.WriteString("</w:tcBorders>")
+	return sb.String()
+}
+
+// runs writes the spans of a paragraph.
+func (ex *exporter) runs(out *bytes.Buffer, spans []convert.Span) {
+	link := ""
+	for _, s := range spans {
+		if n, first := ex.notes.Anchor(s); n != nil {
+			ex.switchLink(out, link, "")
+			link = ""
+			ex.noteReference(out, s, n, first)
+			continue
+		}
+		if s.Attr.Link != link {
+			ex.switchLink(out, link, s.Attr.Link)
+			link = s.Attr.Link
+		}
+		if s.Image != nil {
+			ex.image(out, s)
+			continue
+		}
+		out.WriteString("<w:r>")
+		out.WriteString(runProperties(s.Attr))
+		for i, line := range strings.Split(s.Text, "\n") {
+			if i > 0 {
+				out.WriteString("<w:br/>")
+			}
+			for j, part := range strings.Split(line, "\t") {
+				if j > 0 {
+					out.WriteString("<w:tab/>")
+				}
+				if part != "" {
+					fmt.Fprintf(out, `<w:t xml:space="preserve">%s</w:t>`, escape(part))
+				}
+			}
+		}
+		out.WriteString("</w:r>")
+	}
+	ex.switchLink(out, link, "")
+}
+
+// noteReference writes the first anchor to n as a reference to a new
+// footnote or endnote holding it. Word has no second reference to a note,
+// so later anchors show its number.
+func (ex *exporter) noteReference(out *bytes.Buffer, s convert.Span, n *convert.Note, first bool) {
+	if !first {
+		fmt.Fprintf(out, `<w:r>%s<w:t xml:space="preserve">%s</w:t></w:r>`, runProperties(s.Attr), escape(n.Number))
+		return
+	}
+	kind, part, id := "footnote", &ex.footnotes, &ex.footnoteIDs
+	if n.Kind == sqdoc.NoteEndnote {
+		kind, part, id = "endnote", &ex.endnotes, &ex.endnoteIDs
+	}
+	*id++
+	fmt.Fprintf(out, `<w:r>%s<w:%sReference w:id="%d"/></w:r>`, runProperties(s.Attr), kind, *id)
+	var body bytes.Buffer
+	fmt.Fprintf(&body, `<w:%s w:id="%d"><w:p><w:r>%s<w:%sRef/></w:r><w:r><w:t xml:space="preserve"> </w:t></w:r>`, kind, *id, runProperties(s.Attr), kind)
+	ex.runs(&body, n.Spans)
+	fmt.Fprintf(&body, "</w:p></w:%s>\n", kind)
+	part.Write(body.Bytes())
+}
+
+// switchLink closes the w:hyperlink for the link from and opens one for to.
+// Internal links name a bookmark; others get an external relationship.
+func (ex *exporter) switchLink(out *bytes.Buffer, from, to string) {
+	if from != "" {
+		out.WriteString("</w:hyperlink>")
+	}
+	if to == "" {
+		return
+	}
+	if name, ok := sqdoc.LinkAnchor(to); ok {
+		fmt.Fprintf(out, `<w:hyperlink w:anchor="%s">`, escape(name))
+		return
+	}
+	ex.links = append(ex.links, to)
+	fmt.Fprintf(out, `<w:hyperlink r:id="rIdLink%d">`, len(ex.links))
+}
+
+func runProperties(attr sqdoc.StyleAttr) string {
+	var b strings.Builder
+	b.WriteString("<w:rPr>")
+	font := fontName(attr.FontFamily)
+	fmt.Fprintf(&b, `<w:rFonts w:ascii="%s" w:hAnsi="%s" w:cs="%s"/>`, font, font, font)
+	if attr.Bold {
+		b.WriteString("<w:b/>")
+	}
+	if attr.Italic {
+		b.WriteString("<w:i/>")
+	}
+	fmt.Fprintf(&b, `<w:color w:val="%06X"/>`, attr.ColorRGBA>>8)
+	size := attr.FontSizePt
+	if size == 0 {
+		size = convert.DefaultAttr().FontSizePt
+	}
+	fmt.Fprintf(&b, `<w:sz w:val="%d"/><w:szCs w:val="%d"/>`, size*2, size*2)
+	if attr.Highlight {
+		b.WriteString(`<w:highlight w:val="yellow"/>`)
+	}
+	if attr.Underline {
+		b.WriteString(`<w:u w:val="single"/>`)
+	}
+	if attr.Note != 0 {
+		b.WriteString(`<w:vertAlign w:val="superscript"/>`)
+	}
+	b.WriteString("</w:rPr>")
+	return b.String()
+}
+
+// fontName picks fonts Word has everywhere; the bundled Liberation faces are
+// metric-compatible with them.
+func fontName(f sqdoc.FontFamily) string {
+	switch f {
+	case sqdoc.FontFamilySerif:
+		return "Times New Roman"
+	case sqdoc.FontFamilyMonospace:
+		return "Courier New"
+	}
+	return "Arial"
+}
+
+func (ex *exporter) image(out *bytes.Buffer, s convert.Span) {
+	p := ex.mediaFor(s.Image.Path)
+	if !p.exists {
+		fmt.Fprintf(out, `<w:r>%s<w:t xml:space="preserve">%s</w:t></w:r>`, runProperties(s.Attr), escape("[missing image: "+filepath.Base(s.Image.Path)+"]"))
+		return
+	}
+	w, h := convert.ImageDisplaySize(p.w, p.h, s.Image.Width, s.Image.Height, s.Attr.FontSizePt)
+	if w > maxImageWidth {
+		h, w = h*maxImageWidth/w, maxImageWidth
+	}
+	ex.shape++
+	cx, cy := w*emuPerPixel, h*emuPerPixel
+	fmt.Fprintf(out, `<w:r><w:drawing><wp:inline distT="0" distB="0" distL="0" distR="0"><wp:extent cx="%d" cy="%d"/><wp:docPr id="%d" name="Picture %d"/>`, cx, cy, ex.shape, ex.shape)
+	out.WriteString(`<wp:cNvGraphicFramePr><a:graphicFrameLocks noChangeAspect="1"/></wp:cNvGraphicFramePr>`)
+	out.WriteString(`<a:graphic><a:graphicData uri="http://schemas.openxmlformats.org/drawingml/2006/picture"><pic:pic>`)
+	fmt.Fprintf(out, `<pic:nvPicPr><pic:cNvPr id="%d" name="%s"/><pic:cNvPicPr/></pic:nvPicPr>`, ex.shape, escape(p.name))
+	fmt.Fprintf(out, `<pic:blipFill><a:blip r:embed="%s"/><a:stretch><a:fillRect/></a:stretch></pic:blipFill>`, p.relID)
+	fmt.Fprintf(out, `<pic:spPr><a:xfrm><a:off x="0" y="0"/><a:ext cx="%d" cy="%d"/></a:xfrm><a:prstGeom prst="rect"><a:avLst/></a:prstGeom></pic:spPr>`, cx, cy)
+	out.WriteString(`</pic:pic></a:graphicData></a:graphic></wp:inline></w:drawing></w:r>`)
+}
+
+// mediaFor loads an image once.
+func (ex *exporter) mediaFor(path string) *mediaPart {
+	if p, ok := ex.media[path]; ok {
+		return p
+	}
+	p := &mediaPart{}
+	ex.media[path] = p
+	img, err := convert.LoadEmbeddedImage(path, ex.opts.BaseDir)
+	if err != nil {
+		return p
+	}
+	n := len(ex.parts) + 1
+	*p = mediaPart{relID: fmt.Sprintf("rIdImg%d", n), name: fmt.Sprintf("image%d.%s", n, img.Format), data: img.Data, w: img.Width, h: img.Height, exists: true}
+	ex.parts = append(ex.parts, p)
+	return p
+}
+
+func (ex *exporter) documentRels() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, `<Relationship Id="rIdStyles" Type="%s" Target="styles.xml"/>`, relStyles)
+	if len(ex.nums) > 0 {
+		fmt.Fprintf(&b, `<Relationship Id="rIdNumbering" Type="%s" Target="numbering.xml"/>`, relNumbering)
+	}
+	if ex.footnoteIDs > 0 {
+		fmt.Fprintf(&b, `<Relationship Id="rIdFootnotes" Type="%s" Target="footnotes.xml"/>`, relFootnotes)
+	}
+	if ex.endnoteIDs > 0 {
+		fmt.Fprintf(&b, `<Relationship Id="rIdEndnotes" Type="%s" Target="endnotes.xml"/>`, relEndnotes)
+	}
+	return ex.relationships(b.String())
+}
+
+func (ex *exporter) relationships(extra string) string {
+	var b strings.Builder
+	b.WriteString(xml.Header)
+	b.WriteString(`<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">`)
+	b.WriteString(extra)
+	for _, p := range ex.parts {
+		fmt.Fprintf(&b, `<Relationship Id="%s" Type="%s" Target="media/%s"/>`, p.relID, relImage, p.name)
+	}
+	for i, target := range ex.links {
+		fmt.Fprintf(&b, `<Relationship Id="rIdLink%d" Type="%s" Target="%s" TargetMode="External"/>`, i+1, relHyperlink, escape(target))
+	}
+	b.WriteString(`</Relationships>`)
+	return b.String()
+}
+
+func escape(s string) string {
+	var b strings.Builder
+	_ = xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+var contentTypes = xml.Header + `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+	`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+	`<Default Extension="xml" ContentType="application/xml"/>` +
+	`<Default Extension="png" ContentType="image/png"/>` +
+	`<Default Extension="jpeg" ContentType="image/jpeg"/>` +
+	`<Default Extension="gif" ContentType="image/gif"/>` +
+	`<Override PartName="/word/document.xml" ContentType="application/vnd.openxmlformats-officedocument.wordprocessingml.document.main+xml"/>` +
+	`<Override PartName="/word/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.wordprocessingml.styles+xml"/>` +
+	`<Override PartName="/docProps/core.xml" ContentType="application/vnd.openxmlformats-package.core-properties+xml"/>` +
+	`</Types>`
+
+func (ex *exporter) contentTypes() string {
+	var b strings.Builder
+	b.WriteString(strings.TrimSuffix(contentTypes, "</Types>"))
+	if len(ex.nums) > 0 {
+		b.WriteString(`<Override PartName="/word/numbering.xml" ContentType="application/vnd.openxmlformats-officedocument.wordprocessingml.numbering+xml"/>`)
+	}
+	if ex.footnoteIDs > 0 {
+		b.WriteString(`<Override PartName="/word/footnotes.xml" ContentType="application/vnd.openxmlformats-officedocument.wordprocessingml.footnotes+xml"/>`)
+	}
+	if ex.endnoteIDs > 0 {
+		b.WriteString(`<Override PartName="/word/endnotes.xml" ContentType="application/vnd.openxmlformats-officedocument.wordprocessingml.endnotes+xml"/>`)
+	}
+	b.WriteString("</Types>")
+	return b.String()
+}
+
+var packageRels = xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+	`<Relationship Id="rId1" Type="` + relOffice + `" Target="word/document.xml"/>` +
+	`<Relationship Id="rId2" Type="` + relCore + `" Target="docProps/core.xml"/>` +
+	`</Relationships>`
+
+func coreProperties(m sqdoc.Metadata) string {
+	var b strings.Builder
+	b.WriteString(xml.Header)
+	b.WriteString(`<cp:coreProperties xmlns:cp="http://schemas.openxmlformats.org/package/2006/metadata/core-properties" xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:dcterms="http://purl.org/dc/terms/" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance">`)
+	fmt.Fprintf(&b, "<dc:title>%s</dc:title><dc:creator>%s</dc:creator>", escape(m.Title), escape(m.Author))
+	for _, d := range []struct {
+		tag  string
+		unix int64
+	}{{"created", m.CreatedUnix}, {"modified", m.ModifiedUnix}} {
+		if d.unix != 0 {
+			fmt.Fprintf(&b, `<dcterms:%s xsi:type="dcterms:W3CDTF">%s</dcterms:%s>`, d.tag, time.Unix(d.unix, 0).UTC().Format(time.RFC3339), d.tag)
+		}
+	}
+	if m.Language != "" {
+		fmt.Fprintf(&b, "<dc:language>%s</dc:language>", escape(m.Language))
+	}
+	b.WriteString(`</cp:coreProperties>`)
+	return b.String()
+}
+
+// stylesXML carries the document-wide font and paragraph gap and defines the
+// heading styles that outline views and the importer key on, and the shaded
+// monospace style of code blocks.
+func stylesXML(m sqdoc.Metadata) string {
+	def := convert.DefaultAttr()
+	font := fontName(m.PreferredFontFamily)
+	var b strings.Builder
+	b.WriteString(xml.Header)
+	fmt.Fprintf(&b, `<w:styles xmlns:w="%s">`, nsW)
+	fmt.Fprintf(&b, `<w:docDefaults><w:rPrDefault><w:rPr><w:rFonts w:ascii="%s" w:hAnsi="%s" w:cs="%s"/><w:color w:val="%06X"/><w:sz w:val="%d"/><w:szCs w:val="%d"/></w:rPr></w:rPrDefault>`,
+		font, font, font, def.ColorRGBA>>8, def.FontSizePt*2, def.FontSizePt*2)
+	fmt.Fprintf(&b, `<w:pPrDefault><w:pPr><w:spacing w:after="%d" w:line="240" w:lineRule="auto"/></w:pPr></w:pPrDefault></w:docDefaults>`, int(m.ParagraphGap)*20)
+	b.WriteString(`<w:style w:type="paragraph" w:default="1" w:styleId="Normal"><w:name w:val="Normal"/><w:qFormat/></w:style>`)
+	for i, size := range convert.HeadingSizes {
+		fmt.Fprintf(&b, `<w:style w:type="paragraph" w:styleId="Heading%d"><w:name w:val="heading %d"/><w:basedOn w:val="Normal"/><w:next w:val="Normal"/><w:qFormat/>`, i+1, i+1)
+		fmt.Fprintf(&b, `<w:pPr><w:keepNext/><w:outlineLvl w:val="%d"/></w:pPr><w:rPr><w:b/><w:sz w:val="%d"/><w:szCs w:val="%d"/></w:rPr></w:style>`, i, size*2, size*2)
+	}
+	mono := fontName(sqdoc.FontFamilyMonospace)
+	fmt.Fprintf(&b, `<w:style w:type="paragraph" w:styleId="%s"><w:name w:val="Source Code"/><w:basedOn w:val="Normal"/><w:qFormat/>`, codeStyle)
+	fmt.Fprintf(&b, `<w:pPr><w:keepLines/><w:shd w:val="clear" w:color="auto" w:fill="%06X"/></w:pPr><w:rPr><w:rFonts w:ascii="%s" w:hAnsi="%s" w:cs="%s"/></w:rPr></w:style>`,
+		convert.CodeShadeRGBA>>8, mono, mono, mono)
+	b.WriteString(`</w:styles>`)
+	return b.String()
+}
+
+func documentXML(body string, notes bool) string {
+	var b strings.Builder
+	b.WriteString(xml.Header)
+	fmt.Fprintf(&b, `<w:document xmlns:w="%s" xmlns:r="%s" xmlns:wp="%s" xmlns:a="%s" xmlns:pic="%s"><w:body>`, nsW, nsR, nsWP, nsA, nsPic)
+	b.WriteString(body)
+	b.WriteString(`<w:sectPr>`)
+	if notes {
+		b.WriteString(`<w:footnotePr><w:numFmt w:val="decimal"/></w:footnotePr><w:endnotePr><w:numFmt w:val="lowerRoman"/></w:endnotePr>`)
+	}
+	// A4 with one inch margins, in twentieths of a point.
+	b.WriteString(`<w:pgSz w:w="11906" w:h="16838"/><w:pgMar w:top="1440" w:right="1440" w:bottom="1440" w:left="1440" w:header="708" w:footer="708" w:gutter="0"/></w:sectPr>`)
+	b.WriteString(`</w:body></w:document>`)
+	return b.String()
+}
+
+// notesXML wraps the footnotes or endnotes of a document, after the
+// separators Word draws above them.
+func notesXML(kind, notes string) string {
+	var b strings.Builder
+	b.WriteString(xml.Header)
+	fmt.Fprintf(&b, `<w:%ss xmlns:w="%s" xmlns:r="%s" xmlns:wp="%s" xmlns:a="%s" xmlns:pic="%s">`, kind, nsW, nsR, nsWP, nsA, nsPic)
+	fmt.Fprintf(&b, `<w:%s w:type="separator" w:id="-1"><w:p><w:r><w:separator/></w:r></w:p></w:%s>`, kind, kind)
+	fmt.Fprintf(&b, `<w:%s w:type="continuationSeparator" w:id="0"><w:p><w:r><w:continuationSeparator/></w:r></w:p></w:%s>`, kind, kind)
+	b.WriteString(notes)
+	fmt.Fprintf(&b, `</w:%ss>`, kind)
+	return b.String()
+}
@@ -0,0 +1,929 @@
+package docx
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"sqdoc/pkg/convert"
+	"sqdoc/pkg/sqdoc"
+)
+
+var errNotDocx = errors.New("docx: word/document.xml not found")
+
+type styleDef struct {
+	basedOn string
+	name    string
+	rPr     rPrXML
+	outline int
+}
+
+// numLevel is one level of a numbering definition.
+type numLevel struct {
+	style sqdoc.ListStyle
+	start int
+}
+
+// numDef is a w:num instance of an abstract numbering definition, with
+// the levels whose start it overrides.
+type numDef struct {
+	abstract  string
+	levels    map[int]numLevel
+	overrides map[int]int
+}
+
+type importer struct {
+	opts     Options
+	files    map[string]*zip.File
+	rels     map[string]string
+	links    map[string]string
+	styles   map[string]styleDef
+	defaults rPrXML
+	numbers  map[string]numDef
+	// lists maps abstract numbering definitions to list IDs, and begun
+	// marks the levels of each that have had an item since the level
+	// above last did.
+	lists     map[string]uint32
+	begun     map[string][]bool
+	restarted map[string]bool
+
+	doc      *convert.DocBuilder
+	meta     sqdoc.Metadata
+	media    map[string]string
+	warnings []string
+	warned   map[string]bool
+}
+
+func Import(src []byte, opts Options) (*sqdoc.Document, []string, error) {
+	zr, err := zip.NewReader(bytes.NewReader(src), int64(len(src)))
+	if err != nil {
+		return nil, nil, fmt.Errorf("docx: %w", err)
+	}
+	im := &importer{
+		opts:      opts,
+		files:     map[string]*zip.File{},
+		rels:      map[string]string{},
+		links:     map[string]string{},
+		styles:    map[string]styleDef{},
+		numbers:   map[string]numDef{},
+		lists:     map[string]uint32{},
+		begun:     map[string][]bool{},
+		restarted: map[string]bool{},
+		media:     map[string]string{},
+		warned:    map[string]bool{},
+	}
+	for _, f := range zr.File {
+		im.files[strings.TrimPrefix(f.Name, "/")] = f
+	}
+	if im.files["word/document.xml"] == nil {
+		return nil, nil, errNotDocx
+	}
+	im.readRels()
+	im.readStyles()
+	im.readNumbering()
+	im.readCore()
+
+	im.doc = convert.NewDocBuilder(im.meta.Title)
+	data, err := im.read("word/document.xml")
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := im.body(xml.NewDecoder(bytes.NewReader(data))); err != nil {
+		return nil, im.warnings, fmt.Errorf("docx: %w", err)
+	}
+	doc := im.doc.Document()
+	doc.Metadata.Author = im.meta.Author
+	doc.Metadata.Title = im.meta.Title
+	if im.meta.CreatedUnix != 0 {
+		doc.Metadata.CreatedUnix = im.meta.CreatedUnix
+	}
+	if im.meta.ModifiedUnix != 0 {
+		doc.Metadata.ModifiedUnix = im.meta.ModifiedUnix
+	}
+	return doc, im.warnings, nil
+}
+
+func (im *importer) warn(format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	if im.warned[msg] {
+		return
+	}
+	im.warned[msg] = true
+	im.warnings = append(im.warnings, msg)
+}
+
+func (im *importer) read(name string) ([]byte, error) {
+	f := im.files[name]
+	if f == nil {
+		return nil, os.ErrNotExist
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
+
+func (im *importer) readRels() {
+	data, err := im.read("word/_rels/document.xml.rels")
+	if err != nil {
+		return
+	}
+	var rels struct {
+		Rel []struct {
+			ID     string `xml:"Id,attr"`
+			Target string `xml:"Target,attr"`
+			Mode   string `xml:"TargetMode,attr"`
+		} `xml:"Relationship"`
+	}
+	if xml.Unmarshal(data, &rels) != nil {
+		return
+	}
+	for _, r := range rels.Rel {
+		if r.Mode == "External" {
+			im.links[r.ID] = r.Target
+			continue
+		}
+		target := r.Target
+		if strings.HasPrefix(target, "/") {
+			target = strings.TrimPrefix(target, "/")
+		} else {
+			target = path.Join("word", target)
+		}
+		im.rels[r.ID] = target
+	}
+}
+
+func (im *importer) readStyles() {
+	data, err := im.read("word/styles.xml")
+	if err != nil {
+		return
+	}
+	var styles struct {
+		Defaults rPrXML `xml:"docDefaults>rPrDefault>rPr"`
+		Style    []struct {
+			ID      string  `xml:"styleId,attr"`
+			Name    valXML  `xml:"name"`
+			BasedOn valXML  `xml:"basedOn"`
+			Outline *valXML `xml:"pPr>outlineLvl"`
+			RPr     rPrXML  `xml:"rPr"`
+		} `xml:"style"`
+	}
+	if xml.Unmarshal(data, &styles) != nil {
+		im.warn("styles.xml could not be read; using default formatting")
+		return
+	}
+	im.defaults = styles.Defaults
+	for _, s := range styles.Style {
+		def := styleDef{basedOn: s.BasedOn.Val, name: strings.ToLower(s.Name.Val), rPr: s.RPr, outline: -1}
+		if s.Outline != nil {
+			if n, err := strconv.Atoi(s.Outline.Val); err == nil {
+				def.outline = n
+			}
+		}
+		im.styles[s.ID] = def
+	}
+}
+
+func (im *importer) readNumbering() {
+	data, err := im.read("word/numbering.xml")
+	if err != nil {
+		return
+	}
+	var numbering struct {
+		Abstract []struct {
+			ID  string `xml:"abstractNumId,attr"`
+			Lvl []struct {
+				Ilvl   int    `xml:"ilvl,attr"`
+				Start  valXML `xml:"start"`
+				NumFmt valXML `xml:"numFmt"`
+			} `xml:"lvl"`
+		} `xml:"abstractNum"`
+		Num []struct {
+			ID       string `xml:"numId,attr"`
+			Abstract valXML `xml:"abstractNumId"`
+			Override []struct {
+				Ilvl  int    `xml:"ilvl,attr"`
+				Start valXML `xml:"startOverride"`
+			} `xml:"lvlOverride"`
+		} `xml:"num"`
+	}
+	if xml.Unmarshal(data, &numbering) != nil {
+		return
+	}
+	abstract := map[string]map[int]numLevel{}
+	for _, a := range numbering.Abstract {
+		levels := map[int]numLevel{}
+		for _, l := range a.Lvl {
+			start, err := strconv.Atoi(l.Start.Val)
+			if err != nil {
+				start = 1
+			}
+			levels[l.Ilvl] = numLevel{style: listStyle(l.NumFmt.Val), start: start}
+		}
+		abstract[a.ID] = levels
+	}
+	for _, n := range numbering.Num {
+		def := numDef{abstract: n.Abstract.Val, levels: abstract[n.Abstract.Val], overrides: map[int]int{}}
+		for _, o := range n.Override {
+			if start, err := strconv.Atoi(o.Start.Val); err == nil {
+				def.overrides[o.Ilvl] = start
+			}
+		}
+		im.numbers[n.ID] = def
+	}
+}
+
+func listStyle(numFmt string) sqdoc.ListStyle {
+	switch numFmt {
+	case "bullet", "none":
+		return sqdoc.ListBullet
+	case "lowerLetter":
+		return sqdoc.ListLowerAlpha
+	case "upperLetter":
+		return sqdoc.ListUpperAlpha
+	case "lowerRoman":
+		return sqdoc.ListLowerRoman
+	case "upperRoman":
+		return sqdoc.ListUpperRoman
+	}
+	return sqdoc.ListDecimal
+}
+
+func (im *importer) readCore() {
+	data, err := im.read("docProps/core.xml")
+	if err != nil {
+		return
+	}
+	var core struct {
+		Title    string `xml:"title"`
+		Creator  string `xml:"creator"`
+		Created  string `xml:"created"`
+		Modified string `xml:"modified"`
+		Language string `xml:"language"`
+	}
+	if xml.Unmarshal(data, &core) != nil {
+		return
+	}
+	im.meta.Title = strings.TrimSpace(core.Title)
+	im.meta.Author = strings.TrimSpace(core.Creator)
+	im.meta.CreatedUnix = parseW3CDTF(core.Created)
+	im.meta.ModifiedUnix = parseW3CDTF(core.Modified)
+	im.meta.Language = strings.TrimSpace(core.Language)
+}
+
+func parseW3CDTF(s string) int64 {
+	t, err := time.Parse(time.RFC3339, strings.TrimSpace(s))
+	if err != nil {
+		return 0
+	}
+	return t.Unix()
+}
+
+// styleChain returns the run properties of a style and the styles it is
+// based on, outermost first.
+func (im *importer) styleChain(id string) []rPrXML {
+	var chain []rPrXML
+	for depth := 0; id != "" && depth < 16; depth++ {
+		s, ok := im.styles[id]
+		if !ok {
+			break
+		}
+		chain = append([]rPrXML{s.rPr}, chain...)
+		id = s.basedOn
+	}
+	return chain
+}
+
+// headingLevel reads the heading level of a paragraph style from its id,
+// name or outline level, following basedOn.
+func (im *importer) headingLevel(id string) int {
+	for depth := 0; id != "" && depth < 16; depth++ {
+		s, ok := im.styles[id]
+		name := strings.ToLower(id)
+		if ok {
+			name = s.name
+		}
+		if name == "title" {
+			return 1
+		}
+		if n, err := strconv.Atoi(strings.TrimPrefix(strings.TrimPrefix(name, "heading"), " ")); err == nil && strings.HasPrefix(name, "heading") {
+			return clampLevel(n)
+		}
+		if ok && s.outline >= 0 && s.outline < 9 {
+			return clampLevel(s.outline + 1)
+		}
+		if !ok {
+			break
+		}
+		id = s.basedOn
+	}
+	return 0
+}
+
+func clampLevel(n int) int {
+	if n < 1 {
+		return 1
+	}
+	if n > len(convert.HeadingSizes) {
+		return len(convert.HeadingSizes)
+	}
+	return n
+}
+
+func applyRPr(attr sqdoc.StyleAttr, p rPrXML) sqdoc.StyleAttr {
+	if v, ok := onOff(p.B); ok {
+		attr.Bold = v
+	}
+	if v, ok := onOff(p.I); ok {
+		attr.Italic = v
+	}
+	if p.U != nil {
+		attr.Underline = p.U.Val != "none" && p.U.Val != "0"
+	}
+	if p.Highlight != nil {
+		attr.Highlight = p.Highlight.Val != "none"
+	}
+	if p.Sz != nil {
+		if half, err := strconv.Atoi(p.Sz.Val); err == nil && half > 0 {
+			attr.FontSizePt = uint16((half + 1) / 2)
+		}
+	}
+	if p.Color != nil {
+		if p.Color.Val == "auto" {
+			attr.ColorRGBA = convert.DefaultAttr().ColorRGBA
+		} else if rgb, err := strconv.ParseUint(p.Color.Val, 16, 32); err == nil && len(p.Color.Val) == 6 {
+			attr.ColorRGBA = uint32(rgb)<<8 | 0xFF
+		}
+	}
+	if p.RFonts != nil {
+		name := p.RFonts.ASCII
+		if name == "" {
+			name = p.RFonts.HAnsi
+		}
+		if name != "" {
+			attr.FontFamily = convert.FamilyForFont(name)
+		}
+	}
+	return attr
+}
+
+func onOff(v *valXML) (bool, bool) {
+	if v == nil {
+		return false, false
+	}
+	switch v.Val {
+	case "0", "false", "off":
+		return false, true
+	}
+	return true, true
+}
+
+func (im *importer) body(d *xml.Decoder) error {
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		switch start.Name.Local {
+		case "document", "body", "sdt", "sdtContent", "customXml":
+		case "p":
+			if err := im.paragraph(d); err != nil {
+				return err
+			}
+		case "tbl":
+			if err := im.table(d); err != nil {
+				return err
+			}
+		case "sectPr", "sdtPr", "sdtEndPr", "bookmarkStart", "bookmarkEnd", "proofErr", "background":
+			if err := d.Skip(); err != nil {
+				return err
+			}
+		default:
+			im.warn("unsupported element <%s> skipped", start.Name.Local)
+			if err := d.Skip(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+type bordersXML struct {
+	Top    *valXML `xml:"top"`
+	Left   *valXML `xml:"left"`
+	Start  *valXML `xml:"start"`
+	Bottom *valXML `xml:"bottom"`
+	Right  *valXML `xml:"right"`
+	End    *valXML `xml:"end"`
+}
+
+type tblPrXML struct {
+	Style   valXML      `xml:"tblStyle"`
+	Borders *bordersXML `xml:"tblBorders"`
+}
+
+type tcPrXML struct {
+	GridSpan *valXML     `xml:"gridSpan"`
+	VMerge   *valXML     `xml:"vMerge"`
+	Borders  *bordersXML `xml:"tcBorders"`
+	Shd      *struct {
+		Fill string `xml:"fill,attr"`
+	} `xml:"shd"`
+}
+
+// apply turns on the sides of b that have a line and turns off those that
+// say none.
+func (bx *bordersXML) apply(b sqdoc.Border) sqdoc.Border {
+	if bx == nil {
+		return b
+	}
+	for _, side := range []struct {
+		v      []*valXML
+		border sqdoc.Border
+	}{{[]*valXML{bx.Top}, sqdoc.BorderTop}, {[]*valXML{bx.Left, bx.Start}, sqdoc.BorderLeft}, {[]*valXML{bx.Bottom}, sqdoc.BorderBottom}, {[]*valXML{bx.Right, bx.End}, sqdoc.BorderRight}} {
+		for _, v := range side.v {
+			switch {
+			case v == nil:
+			case v.Val == "nil" || v.Val == "none":
+				b &^= side.border
+			default:
+				b |= side.border
+			}
+		}
+	}
+	return b
+}
+
+// table reads a w:tbl into a table block. Cell borders default to those of
+// the table, or to a full grid for Word's Table Grid style; a nested table
+// is flattened into its cell's text.
+func (im *importer) table(d *xml.Decoder) error {
+	var tb convert.TableBuilder
+	var borders sqdoc.Border
+	depth := 0
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "tblPr":
+				var pr tblPrXML
+				if err := d.DecodeElement(&pr, &t); err != nil {
+					return err
+				}
+				if pr.Style.Val == "TableGrid" {
+					borders = sqdoc.BorderAll
+				}
+				borders = pr.Borders.apply(borders)
+				continue
+			case "gridCol":
+				w, _ := strconv.Atoi(attrValue(t, "w"))
+				tb.Column(uint16(min(max(w/20, 0), 0xFFFF)))
+			case "tr":
+				tb.Row()
+			case "trPr":
+				var pr struct {
+					Header *valXML `xml:"tblHeader"`
+				}
+				if err := d.DecodeElement(&pr, &t); err != nil {
+					return err
+				}
+				if on, _ := onOff(pr.Header); on {
+					tb.HeaderRow()
+				}
+				continue
+			case "tc":
+				if err := im.tableCell(d, &tb, borders); err != nil {
+					return err
+				}
+				continue
+			case "tblGrid", "sdt", "sdtContent", "customXml":
+			default:
+				if err := d.Skip(); err != nil {
+					return err
+				}
+				continue
+			}
+			depth++
+		case xml.EndElement:
+			if depth == 0 {
+				if table := tb.Table(); table != nil {
+					im.doc.AddTable(table)
+				}
+				return nil
+			}
+			depth--
+		}
+	}
+}
+
+// tableCell reads a w:tc onto tb. A cell continuing a vertical merge only
+// extends the cell above.
+func (im *importer) tableCell(d *xml.Decoder, tb *convert.TableBuilder, borders sqdoc.Border) error {
+	var pr tcPrXML
+	outer := im.doc
+	im.doc = convert.NewDocBuilder("")
+	defer func() { im.doc = outer }()
+	depth := 0
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "tcPr":
+				if err := d.DecodeElement(&pr, &t); err != nil {
+					return err
+				}
+				continue
+			case "p":
+				if err := im.paragraph(d); err != nil {
+					return err
+				}
+				continue
+			case "tbl":
+				im.warn("nested tables flattened")
+				if err := im.table(d); err != nil {
+					return err
+				}
+				continue
+			case "sdt", "sdtContent", "customXml":
+			case "sdtPr", "sdtEndPr", "bookmarkStart", "bookmarkEnd", "proofErr":
+				if err := d.Skip(); err != nil {
+					return err
+				}
+				continue
+			default:
+				im.warn("unsupported element <%s> skipped", t.Name.Local)
+				if err := d.Skip(); err != nil {
+					return err
+				}
+				continue
+			}
+			depth++
+		case xml.EndElement:
+			if depth > 0 {
+				depth--
+				continue
+			}
+			if pr.VMerge != nil && pr.VMerge.Val != "restart" {
+				tb.Continue()
+				return nil
+			}
+			cell := sqdoc.TableCell{Text: convert.CellText(im.doc.Document().Blocks), Borders: pr.Borders.apply(borders)}
+			if pr.GridSpan != nil {
+				n, _ := strconv.Atoi(pr.GridSpan.Val)
+				cell.ColSpan = uint16(min(max(n, 0), 0xFFFF))
+			}
+			if pr.Shd != nil {
+				if fill, err := strconv.ParseUint(pr.Shd.Fill, 16, 32); err == nil && len(pr.Shd.Fill) == 6 {
+					cell.Background = uint32(fill)<<8 | 0xFF
+				}
+			}
+			tb.Cell(cell)
+			return nil
+		}
+	}
+}
+
+type pPrXML struct {
+	PStyle valXML `xml:"pStyle"`
+	NumPr  *struct {
+		Ilvl  valXML `xml:"ilvl"`
+		NumID valXML `xml:"numId"`
+	} `xml:"numPr"`
+}
+
+// baseAttr is the formatting a run in a paragraph of the given style starts
+// from before its own properties apply.
+func (im *importer) baseAttr(pStyle string) sqdoc.StyleAttr {
+	attr := applyRPr(convert.DefaultAttr(), im.defaults)
+	if level := im.headingLevel(pStyle); level > 0 {
+		attr.Bold = true
+		attr.FontSizePt = convert.HeadingSizes[level-1]
+	}
+	for _, p := range im.styleChain(pStyle) {
+		attr = applyRPr(attr, p)
+	}
+	return attr
+}
+
+func (im *importer) paragraph(d *xml.Decoder) error {
+	var bb convert.BlockBuilder
+	var list sqdoc.ListItem
+	code := false
+	base := im.baseAttr("")
+	depth, linkDepth := 0, 0
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "pPr":
+				var ppr pPrXML
+				if err := d.DecodeElement(&ppr, &t); err != nil {
+					return err
+				}
+				base = im.baseAttr(ppr.PStyle.Val)
+				code = ppr.PStyle.Val == codeStyle
+				if ppr.NumPr != nil {
+					list = im.listItem(ppr.NumPr.NumID.Val, ppr.NumPr.Ilvl.Val)
+				}
+				continue
+			case "r":
+				if err := im.run(d, &bb, base); err != nil {
+					return err
+				}
+				continue
+			case "hyperlink":
+				base.Link = im.hyperlinkTarget(t)
+				linkDepth = depth + 1
+			case "ins", "smartTag", "fldSimple", "sdt", "sdtContent", "customXml", "moveTo":
+			case "del", "moveFrom":
+				im.warn("tracked deletions dropped")
+				if err := d.Skip(); err != nil {
+					return err
+				}
+				continue
+			case "oMath", "oMathPara":
+				im.warn("equations imported as plain text")
+				if err := im.plainText(d, &bb, base); err != nil {
+					return err
+				}
+				continue
+			case "bookmarkStart", "bookmarkEnd", "proofErr", "commentRangeStart", "commentRangeEnd", "permStart", "permEnd", "sdtPr", "sdtEndPr":
+				if err := d.Skip(); err != nil {
+					return err
+				}
+				continue
+			default:
+				im.warn("unsupported element <%s> skipped", t.Name.Local)
+				if err := d.Skip(); err != nil {
+					return err
+				}
+				continue
+			}
+			depth++
+		case xml.EndElement:
+			if depth == 0 {
+				tb := bb.TextBlock()
+				tb.List = list
+				if code {
+					tb.Code, tb.List = &sqdoc.CodeBlock{}, sqdoc.ListItem{}
+				}
+				im.doc.Add(tb)
+				return nil
+			}
+			if depth == linkDepth {
+				base.Link, linkDepth = "", 0
+			}
+			depth--
+		}
+	}
+}
+
+// hyperlinkTarget resolves a w:hyperlink to an external address or, for
+// one to a bookmark, an internal link.
+func (im *importer) hyperlinkTarget(e xml.StartElement) string {
+	target := ""
+	if id := attrValue(e, "id"); id != "" {
+		target = im.links[id]
+	}
+	if anchor := attrValue(e, "anchor"); anchor != "" {
+		target += "#" + anchor
+	}
+	if target = convert.LinkTarget(target); target == "" {
+		im.warn("hyperlink targets dropped; link text kept")
+	}
+	return target
+}
+
+// listItem makes a paragraph numbered by the w:num numID an item of the
+// list its abstract definition stands for. Instances of one definition
+// share its count, as in Word, and one that overrides a level's start
+// restarts the count at its first item there. Numbering without a
+// definition becomes a bullet list.
+func (im *importer) listItem(numID, ilvl string) sqdoc.ListItem {
+	if numID == "" || numID == "0" {
+		return sqdoc.ListItem{}
+	}
+	level, _ := strconv.Atoi(ilvl)
+	level = max(0, min(level, sqdoc.MaxListLevel))
+	num, ok := im.numbers[numID]
+	if !ok {
+		num = numDef{abstract: "num " + numID}
+	}
+	id := im.lists[num.abstract]
+	if id == 0 {
+		id = uint32(len(im.lists) + 1)
+		im.lists[num.abstract] = id
+	}
+	def := num.levels[level]
+	item := sqdoc.ListItem{ID: id, Level: uint8(level), Style: def.style}
+	if !ok {
+		item.Style = sqdoc.ListBullet
+	}
+
+	begun := im.begun[num.abstract]
+	for len(begun) <= level {
+		begun = append(begun, false)
+	}
+	begun = begun[:level+1]
+	key := numID + "/" + strconv.Itoa(level)
+	if start, override := num.overrides[level]; override && !im.restarted[key] {
+		im.restarted[key] = true
+		item.Start = uint32(max(start, 1))
+	} else if !begun[level] && def.start > 1 {
+		item.Start = uint32(def.start)
+	}
+	begun[level] = true
+	im.begun[num.abstract] = begun
+	return item
+}
+
+func (im *importer) run(d *xml.Decoder, bb *convert.BlockBuilder, base sqdoc.StyleAttr) error {
+	attr := base
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			var err error
+			switch t.Name.Local {
+			case "rPr":
+				var rpr rPrXML
+				if err := d.DecodeElement(&rpr, &t); err != nil {
+					return err
+				}
+				attr = base
+				if rpr.RStyle != nil {
+					for _, p := range im.styleChain(rpr.RStyle.Val) {
+						attr = applyRPr(attr, p)
+					}
+				}
+				attr = applyRPr(attr, rpr)
+			case "t":
+				var s string
+				if err := d.DecodeElement(&s, &t); err != nil {
+					return err
+				}
+				bb.WriteString(s, attr)
+			case "tab", "ptab":
+				bb.WriteString("\t", attr)
+				err = d.Skip()
+			case "br", "cr":
+				bb.WriteString("\n", attr)
+				err = d.Skip()
+			case "noBreakHyphen":
+				bb.WriteString("-", attr)
+				err = d.Skip()
+			case "sym":
+				if r, perr := strconv.ParseUint(attrValue(t, "char"), 16, 32); perr == nil {
+					bb.WriteString(string(rune(r)), attr)
+				}
+				err = d.Skip()
+			case "drawing":
+				err = im.drawing(d, t, bb, attr)
+			case "pict", "object":
+				im.warn("legacy VML objects skipped")
+				err = d.Skip()
+			case "footnoteReference", "endnoteReference":
+				im.warn("footnotes and endnotes dropped")
+				err = d.Skip()
+			case "commentReference":
+				im.warn("comments dropped")
+				err = d.Skip()
+			case "AlternateContent":
+				im.warn("alternate content (shapes, text boxes) skipped")
+				err = d.Skip()
+			default:
+				err = d.Skip()
+			}
+			if err != nil {
+				return err
+			}
+		case xml.EndElement:
+			return nil
+		}
+	}
+}
+
+// plainText appends the text of every <m:t> or <w:t> inside the element.
+func (im *importer) plainText(d *xml.Decoder, bb *convert.BlockBuilder, attr sqdoc.StyleAttr) error {
+	depth := 0
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if t.Name.Local == "t" {
+				var s string
+				if err := d.DecodeElement(&s, &t); err != nil {
+					return err
+				}
+				bb.WriteString(s, attr)
+				continue
+			}
+			depth++
+		case xml.EndElement:
+			if depth == 0 {
+				return nil
+			}
+			depth--
+		}
+	}
+}
+
+func attrValue(e xml.StartElement, local string) string {
+	for _, a := range e.Attr {
+		if a.Name.Local == local {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+type drawingPartXML struct {
+	Extent struct {
+		Cx int64 `xml:"cx,attr"`
+		Cy int64 `xml:"cy,attr"`
+	} `xml:"extent"`
+	Blip struct {
+		Embed string `xml:"embed,attr"`
+	} `xml:"graphic>graphicData>pic>blipFill>blip"`
+}
+
+func (im *importer) drawing(d *xml.Decoder, start xml.StartElement, bb *convert.BlockBuilder, attr sqdoc.StyleAttr) error {
+	var dr struct {
+		Inline *drawingPartXML `xml:"inline"`
+		Anchor *drawingPartXML `xml:"anchor"`
+	}
+	if err := d.DecodeElement(&dr, &start); err != nil {
+		return err
+	}
+	part := dr.Inline
+	if part == nil {
+		part = dr.Anchor
+		im.warn("floating images placed inline")
+	}
+	if part == nil || part.Blip.Embed == "" {
+		im.warn("drawings other than pictures skipped")
+		return nil
+	}
+	path, err := im.extract(part.Blip.Embed)
+	if err != nil {
+		im.warn("image %s could not be extracted: %v", part.Blip.Embed, err)
+		return nil
+	}
+	bb.WriteImage(path, int(part.Extent.Cx/emuPerPixel), int(part.Extent.Cy/emuPerPixel), attr)
+	return nil
+}
+
+// extract copies a media part out of the package once and returns its path.
+func (im *importer) extract(relID string) (string, error) {
+	target, ok := im.rels[relID]
+	if !ok {
+		return "", errors.New("missing relationship")
+	}
+	if p, ok := im.media[target]; ok {
+		return p, nil
+	}
+	data, err := im.read(target)
+	if err != nil {
+		return "", err
+	}
+	dst, err := convert.SaveMedia(&im.opts.MediaDir, "sqdoc-docx-", path.Base(target), data)
+	if err != nil {
+		return "", err
+	}
+	im.media[target] = dst
+	return dst, nil
+}
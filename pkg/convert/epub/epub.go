@@ -0,0 +1,152 @@
+// Package epub exports SQDoc documents as EPUB 3 publications.
+//
+// The book is split into one XHTML content document per heading at or
+// above the split level, and at every paragraph that starts on a new page;
+// text before the first split point becomes its own document. Paragraph
+// formats and style runs map to the same inline CSS the HTML exporter
+// writes, inline images are stored as publication resources and every
+// heading is listed in the navigation document.
+package epub
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"os"
+	"strings"
+
+	"sqdoc/pkg/convert"
+	"sqdoc/pkg/sqdoc"
+)
+
+const (
+	mimeType = "application/epub+zip"
+
+	// defaultLanguage is the BCP 47 "undetermined" tag, used when the
+	// document does not record a language since dc:language is required.
+	defaultLanguage = "und"
+)
+
+type Options struct {
+	// BaseDir resolves relative image paths.
+	BaseDir string
+	// SplitLevel is the deepest heading level that starts a new content
+	// document; zero means 1. Documents whose shallowest heading is deeper
+	// than this split at that heading level instead.
+	SplitLevel int
+}
+
+func ExportFile(path string, doc *sqdoc.Document, opts Options) error {
+	out, err := Export(doc, opts)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, out, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// part is one file of the container, in writing order.
+type part struct {
+	name string
+	data []byte
+}
+
+func Export(doc *sqdoc.Document, opts Options) ([]byte, error) {
+	if doc == nil {
+		return nil, errors.New("epub: document is nil")
+	}
+	ex := newExporter(doc, opts)
+	parts := ex.parts()
+
+	var out bytes.Buffer
+	zw := zip.NewWriter(&out)
+	// OCF requires the mimetype entry first, stored uncompressed.
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: "mimetype", Method: zip.Store})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write([]byte(mimeType)); err != nil {
+		return nil, err
+	}
+	for _, p := range parts {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: p.name, Method: zip.Deflate})
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(p.data); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// chapter is one XHTML content document.
+type chapter struct {
+	file   string
+	title  string
+	blocks []sqdoc.Block
+}
+
+// splitChapters groups text blocks and tables into chapters, starting one at
+// each split heading and page break. Leading blank paragraphs before a split
+// point do not get a chapter of their own.
+func splitChapters(blocks []sqdoc.Block, splitLevel int) [][]sqdoc.Block {
+	if splitLevel <= 0 {
+		splitLevel = 1
+	}
+	shallowest := 0
+	for _, b := range blocks {
+		if lvl := convert.HeadingLevel(b); lvl > 0 && (shallowest == 0 || lvl < shallowest) {
+			shallowest = lvl
+		}
+	}
+	splitLevel = max(splitLevel, shallowest)
+
+	var chapters [][]sqdoc.Block
+	var cur []sqdoc.Block
+	for _, b := range blocks {
+		table := b.Kind == sqdoc.BlockKindTable && b.Table != nil
+		if !table && (b.Kind != sqdoc.BlockKindText || b.Text == nil) {
+			continue
+		}
+		lvl := convert.HeadingLevel(b)
+		split := lvl > 0 && lvl <= splitLevel || !table && b.Text.Para.PageBreakBefore
+		if split && len(cur) > 0 {
+			if !allBlank(cur) {
+				chapters = append(chapters, cur)
+			}
+			cur = nil
+		}
+		cur = append(cur, b)
+	}
+	if len(cur) > 0 || len(chapters) == 0 {
+		chapters = append(chapters, cur)
+	}
+	return chapters
+}
+
+func allBlank(blocks []sqdoc.Block) bool {
+	for _, b := range blocks {
+		if b.Text == nil || strings.TrimSpace(string(b.Text.UTF8)) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// plainText is a heading's text for titles and the table of contents.
+func plainText(b sqdoc.Block) string {
+	var sb strings.Builder
+	for _, s := range convert.Spans(b) {
+		if s.Image == nil {
+			sb.WriteString(s.Text)
+		}
+	}
+	return strings.Join(strings.Fields(sb.String()), " ")
+}
@@ -0,0 +1,378 @@
+package epub
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"image"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"sqdoc/pkg/convert"
+	"sqdoc/pkg/sqdoc"
+)
+
+func readEntries(t *testing.T, out []byte) (*zip.Reader, map[string]string) {
+	t.Helper()
+	zr, err := zip.NewReader(bytes.NewReader(out), int64(len(out)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{}
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[f.Name] = string(data)
+	}
+	return zr, files
+}
+
+func textBlock(id uint64, text string, attr sqdoc.StyleAttr) sqdoc.Block {
+	var bb convert.BlockBuilder
+	bb.WriteString(text, attr)
+	return sqdoc.Block{ID: id, Kind: sqdoc.BlockKindText, Text: bb.TextBlock()}
+}
+
+func TestExportWritesValidContainer(t *testing.T) {
+	dir := t.TempDir()
+	imgPath := filepath.Join(dir, "dot.png")
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 30, 20))); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(imgPath, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	doc := sqdoc.NewDocument("Zoë Ng", "Field <notes>")
+	doc.Metadata.CreatedUnix = 1700000000
+	doc.Metadata.ModifiedUnix = 1700000500
+	doc.Metadata.Language = "en-GB"
+
+	fancy := convert.DefaultAttr()
+	fancy.Italic = true
+	fancy.ColorRGBA = 0x1F4E79FF
+	var bb convert.BlockBuilder
+	bb.WriteString("Body & more\n", convert.DefaultAttr())
+	bb.WriteString("fancy", fancy)
+	bb.WriteImage(imgPath, 60, 40, convert.DefaultAttr())
+	doc.Blocks = append(doc.Blocks,
+		textBlock(1, "Preface", convert.DefaultAttr()),
+		textBlock(2, "One", convert.HeadingAttr(1)),
+		sqdoc.Block{ID: 3, Kind: sqdoc.BlockKindText, Text: bb.TextBlock()},
+		textBlock(4, "One.A", convert.HeadingAttr(3)),
+		textBlock(5, "Two", convert.HeadingAttr(1)),
+		sqdoc.Block{ID: 6, Kind: sqdoc.BlockKindText, Text: &sqdoc.TextBlock{}},
+	)
+
+	out, err := Export(doc, Options{BaseDir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	zr, files := readEntries(t, out)
+	if zr.File[0].Name != "mimetype" || zr.File[0].Method != zip.Store || files["mimetype"] != mimeType {
+		t.Fatalf("first entry = %s (method %d), want stored mimetype", zr.File[0].Name, zr.File[0].Method)
+	}
+	for name, data := range files {
+		if name == "mimetype" || strings.HasPrefix(name, "OEBPS/images/") || strings.HasSuffix(name, ".css") {
+			continue
+		}
+		dec := xml.NewDecoder(strings.NewReader(data))
+		for {
+			if _, err := dec.Token(); err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatalf("%s is not well-formed: %v", name, err)
+			}
+		}
+	}
+
+	var pkg struct {
+		Title    string `xml:"metadata>title"`
+		Creator  string `xml:"metadata>creator"`
+		Language string `xml:"metadata>language"`
+		Items    []struct {
+			Href       string `xml:"href,attr"`
+			MediaType  string `xml:"media-type,attr"`
+			Properties string `xml:"properties,attr"`
+		} `xml:"manifest>item"`
+		Spine []struct {
+			IDRef string `xml:"idref,attr"`
+		} `xml:"spine>itemref"`
+	}
+	if err := xml.Unmarshal([]byte(files["OEBPS/content.opf"]), &pkg); err != nil {
+		t.Fatal(err)
+	}
+	if pkg.Title != "Field <notes>" || pkg.Creator != "Zoë Ng" || pkg.Language != "en-GB" {
+		t.Fatalf("package metadata = %q %q %q", pkg.Title, pkg.Creator, pkg.Language)
+	}
+	if len(pkg.Spine) != 3 {
+		t.Fatalf("spine has %d items, want preface and two chapters", len(pkg.Spine))
+	}
+	for _, item := range pkg.Items {
+		if _, ok := files["OEBPS/"+item.Href]; !ok {
+			t.Errorf("manifest item %s is missing from the container", item.Href)
+		}
+	}
+	if data := files["OEBPS/images/image1.png"]; data != buf.String() {
+		t.Fatal("image resource differs from the source file")
+	}
+
+	ch2 := files["OEBPS/chapter2.xhtml"]
+	for _, want := range []string{
+		`<h1 id="h1">One</h1>`,
+		`<p>Body &amp; more<br/><span style="font-style: italic; color: #1f4e79">fancy</span><img src="images/image1.png" alt="dot.png" width="60" height="40"/></p>`,
+		`<h3 id="h2">One.A</h3>`,
+	} {
+		if !strings.Contains(ch2, want) {
+			t.Errorf("chapter2.xhtml lacks %s\n%s", want, ch2)
+		}
+	}
+	if !strings.Contains(files["OEBPS/chapter3.xhtml"], "<p><br/></p>") {
+		t.Error("empty paragraph not kept")
+	}
+	nav := files["OEBPS/nav.xhtml"]
+	wantNav := `<li><a href="chapter1.xhtml">Field &lt;notes&gt;</a></li>` +
+		"\n" + `<li><a href="chapter2.xhtml#h1">One</a><ol>` +
+		"\n" + `<li><a href="chapter2.xhtml#h2">One.A</a></li></ol></li>` +
+		"\n" + `<li><a href="chapter3.xhtml#h3">Two</a></li>`
+	if !strings.Contains(nav, wantNav) {
+		t.Fatalf("nav.xhtml lacks nested table of contents\n%s", nav)
+	}
+}
+
+func TestSplitFallsBackToShallowestHeading(t *testing.T) {
+	blocks := []sqdoc.Block{
+		textBlock(1, "", convert.DefaultAttr()),
+		textBlock(2, "A", convert.HeadingAttr(2)),
+		textBlock(3, "a", convert.DefaultAttr()),
+		textBlock(4, "B", convert.HeadingAttr(2)),
+		textBlock(5, "B.1", convert.HeadingAttr(3)),
+	}
+	chapters := splitChapters(blocks, 0)
+	if len(chapters) != 2 || len(chapters[0]) != 2 || len(chapters[1]) != 2 {
+		t.Fatalf("chapters = %d, want A and B with the blank lead dropped", len(chapters))
+	}
+	if got := splitChapters(blocks, 3); len(got) != 3 {
+		t.Fatalf("split level 3 gave %d chapters", len(got))
+	}
+	if got := splitChapters(nil, 0); len(got) != 1 {
+		t.Fatalf("empty document gave %d chapters", len(got))
+	}
+}
+
+func TestSplitAtPageBreaks(t *testing.T) {
+	blocks := []sqdoc.Block{
+		textBlock(1, "A", convert.HeadingAttr(1)),
+		textBlock(2, "a", convert.DefaultAttr()),
+		textBlock(3, "b", convert.DefaultAttr()),
+		textBlock(4, "c", convert.DefaultAttr()),
+	}
+	blocks[2].Text.Para.PageBreakBefore = true
+	chapters := splitChapters(blocks, 0)
+	if len(chapters) != 2 || len(chapters[0]) != 2 || chapters[1][0].ID != 3 {
+		t.Fatalf("chapters = %v, want a split before the page break", chapters)
+	}
+	// A break on the first block does not leave an empty chapter.
+	blocks[0].Text.Para.PageBreakBefore = true
+	if got := splitChapters(blocks, 0); len(got) != 2 {
+		t.Fatalf("a break on the first block gave %d chapters", len(got))
+	}
+}
+
+func TestInternalLinksReachOtherChapters(t *testing.T) {
+	link := convert.DefaultAttr()
+	link.Link = "#later"
+	web := convert.DefaultAttr()
+	web.Link = "https://example.com/?a=1&b=2"
+	later := textBlock(3, "Later", convert.HeadingAttr(1))
+	later.Text.Style = sqdoc.StyleHeading1
+
+	var bb convert.BlockBuilder
+	bb.WriteString("see ", convert.DefaultAttr())
+	bb.WriteString("below", link)
+	bb.WriteString(" or ", convert.DefaultAttr())
+	bb.WriteString("the web", web)
+	doc := sqdoc.NewDocument("", "Links")
+	doc.Blocks = append(doc.Blocks,
+		textBlock(1, "First", convert.HeadingAttr(1)),
+		sqdoc.Block{ID: 2, Kind: sqdoc.BlockKindText, Text: bb.TextBlock()},
+		later,
+	)
+	out, err := Export(doc, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, files := readEntries(t, out)
+	first := files["OEBPS/chapter1.xhtml"]
+	for _, want := range []string{`<a href="chapter2.xhtml#h2">below</a>`, `<a href="https://example.com/?a=1&amp;b=2">the web</a>`} {
+		if !strings.Contains(first, want) {
+			t.Fatalf("chapter 1 lacks %s:\n%s", want, first)
+		}
+	}
+	if !strings.Contains(files["OEBPS/chapter2.xhtml"], `<h1 id="h2">`) {
+		t.Fatalf("chapter 2 = %s", files["OEBPS/chapter2.xhtml"])
+	}
+}
+
+func TestNotesGoToTheChapterOfTheirAnchor(t *testing.T) {
+	anchor := convert.DefaultAttr()
+	anchor.Note = 9
+	var bb convert.BlockBuilder
+	bb.WriteString("claim", convert.DefaultAttr())
+	bb.WriteString("1", anchor)
+	second := sqdoc.Block{ID: 3, Kind: sqdoc.BlockKindText, Text: bb.TextBlock()}
+	bb.WriteString("again", convert.DefaultAttr())
+	bb.WriteString("1", anchor)
+	third := sqdoc.Block{ID: 5, Kind: sqdoc.BlockKindText, Text: bb.TextBlock()}
+	note := textBlock(9, "The source.", convert.DefaultAttr())
+	note.Kind = sqdoc.BlockKindNote
+	doc := sqdoc.NewDocument("", "Notes")
+	doc.Blocks = append(doc.Blocks,
+		textBlock(1, "One", convert.HeadingAttr(1)),
+		textBlock(2, "Two", convert.HeadingAttr(1)),
+		second,
+		textBlock(4, "Three", convert.HeadingAttr(1)),
+		third,
+		note,
+	)
+	out, err := Export(doc, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, files := readEntries(t, out)
+	two, three := files["OEBPS/chapter2.xhtml"], files["OEBPS/chapter3.xhtml"]
+	for _, want := range []string{
+		`claim<sup><a epub:type="noteref" href="#note-1" id="noteref-1">1</a></sup>`,
+		`<aside epub:type="footnote" id="note-1"><p><a href="#noteref-1">1</a>. The source.</p></aside>`,
+	} {
+		if !strings.Contains(two, want) {
+			t.Fatalf("chapter 2 lacks %s:\n%s", want, two)
+		}
+	}
+	if !strings.Contains(three, `again<sup><a epub:type="noteref" href="chapter2.xhtml#note-1">1</a></sup>`) || strings.Contains(three, "The source.") {
+		t.Fatalf("chapter 3 = %s", three)
+	}
+}
+
+func TestTablesKeepTheirChapterAndNotes(t *testing.T) {
+	anchor := convert.DefaultAttr()
+	anchor.Note = 9
+	table := sqdoc.NewTable(2, 2)
+	table.HeaderRows = 1
+	for i, text := range []string{"Name", "Qty", "Apples & pears", ""} {
+		table.Rows[i/2][i%2].Text = textBlock(0, text, convert.DefaultAttr()).Text
+	}
+	var bb convert.BlockBuilder
+	bb.WriteString("3", convert.DefaultAttr())
+	bb.WriteString("1", anchor)
+	table.Rows[1][1].Text = bb.TextBlock()
+	note := textBlock(9, "Counted twice.", convert.DefaultAttr())
+	note.Kind = sqdoc.BlockKindNote
+	doc := sqdoc.NewDocument("", "Stock")
+	doc.Blocks = append(doc.Blocks,
+		textBlock(1, "One", convert.HeadingAttr(1)),
+		textBlock(2, "Two", convert.HeadingAttr(1)),
+		sqdoc.Block{ID: 3, Kind: sqdoc.BlockKindTable, Table: table},
+		note,
+	)
+	out, err := Export(doc, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, files := readEntries(t, out)
+	two := files["OEBPS/chapter2.xhtml"]
+	for _, want := range []string{
+		"<thead>\n<tr><th style=",
+		">Apples &amp; pears</td>",
+		`3<sup><a epub:type="noteref" href="#note-1" id="noteref-1">1</a></sup></td>`,
+		`<aside epub:type="footnote" id="note-1">`,
+	} {
+		if !strings.Contains(two, want) {
+			t.Fatalf("chapter 2 lacks %s:\n%s", want, two)
+		}
+	}
+	if !strings.Contains(files["OEBPS/style.css"], "border-collapse: collapse") {
+		t.Fatal("stylesheet has no table rules")
+	}
+}
+
+func TestListsCloseAtChapterEnds(t *testing.T) {
+	blocks := []sqdoc.Block{
+		textBlock(1, "One", convert.HeadingAttr(1)),
+		textBlock(2, "a", convert.DefaultAttr()),
+		textBlock(3, "b", convert.DefaultAttr()),
+		textBlock(4, "Two", convert.HeadingAttr(1)),
+		textBlock(5, "c", convert.DefaultAttr()),
+	}
+	for _, i := range []int{1, 2, 4} {
+		blocks[i].Text.List = sqdoc.ListItem{ID: 1, Style: sqdoc.ListDecimal}
+	}
+	blocks[2].Text.List.Level = 1
+	doc := sqdoc.NewDocument("", "Lists")
+	doc.Blocks = blocks
+	out, err := Export(doc, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, files := readEntries(t, out)
+	one, two := files["OEBPS/chapter1.xhtml"], files["OEBPS/chapter2.xhtml"]
+	if want := "<ol>\n<li><p>a</p>\n<ol>\n<li><p>b</p>\n</li></ol>\n</li></ol>\n"; !strings.Contains(one, want) {
+		t.Fatalf("chapter 1 lacks %q:\n%s", want, one)
+	}
+	// The list carries on its count in the next chapter.
+	if want := "<ol start=\"2\">\n<li><p>c</p>\n</li></ol>\n"; !strings.Contains(two, want) {
+		t.Fatalf("chapter 2 lacks %q:\n%s", want, two)
+	}
+	if !strings.Contains(files["OEBPS/style.css"], "ul, ol {") {
+		t.Fatal("stylesheet has no list rules")
+	}
+}
+
+func TestCodeBlocksKeepTheirLines(t *testing.T) {
+	code := textBlock(2, "select 1\nwhere a < b", convert.DefaultAttr())
+	code.Text.Code = &sqdoc.CodeBlock{Language: "sql"}
+	doc := sqdoc.NewDocument("", "Code")
+	doc.Blocks = append(doc.Blocks, textBlock(1, "One", convert.HeadingAttr(1)), code)
+	out, err := Export(doc, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, files := readEntries(t, out)
+	if want := "<pre><code class=\"language-sql\">select 1\nwhere a &lt; b</code></pre>\n"; !strings.Contains(files["OEBPS/chapter1.xhtml"], want) {
+		t.Fatalf("chapter 1 lacks %q:\n%s", want, files["OEBPS/chapter1.xhtml"])
+	}
+	if !strings.Contains(files["OEBPS/style.css"], "pre {") {
+		t.Fatal("stylesheet has no code rules")
+	}
+}
+
+func TestParagraphFormatsBecomeInlineCSS(t *testing.T) {
+	heading := textBlock(1, "One", convert.HeadingAttr(1))
+	heading.Text.Para.Align = sqdoc.AlignCenter
+	body := textBlock(2, "Indented", convert.DefaultAttr())
+	body.Text.Para = sqdoc.ParagraphFormat{IndentLeft: 24, LineHeight: 200}
+	doc := sqdoc.NewDocument("", "Formats")
+	doc.Blocks = append(doc.Blocks, heading, body)
+	out, err := Export(doc, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, files := readEntries(t, out)
+	for _, want := range []string{`<h1 id="h1" style="text-align: center">`, `<p style="margin-left: 24pt; line-height: 2">Indented</p>`} {
+		if !strings.Contains(files["OEBPS/chapter1.xhtml"], want) {
+			t.Fatalf("chapter 1 lacks %q:\n%s", want, files["OEBPS/chapter1.xhtml"])
+		}
+	}
+}
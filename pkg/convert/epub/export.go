@@ -0,0 +1,490 @@
+package epub
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/xml"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"sqdoc/pkg/convert"
+	htmlexport "sqdoc/pkg/convert/html"
+	"sqdoc/pkg/sqdoc"
+)
+
+type resource struct {
+	name      string
+	mediaType string
+	data      []byte
+	w, h      int
+}
+
+// tocEntry is one line of the navigation document. Level 0 marks a
+// chapter that does not open with a heading.
+type tocEntry struct {
+	level int
+	title string
+	href  string
+}
+
+type exporter struct {
+	doc       *sqdoc.Document
+	opts      Options
+	lang      string
+	base      sqdoc.StyleAttr
+	chapters  []chapter
+	resources map[string]*resource
+	media     []*resource
+	toc       []tocEntry
+	headings  int
+	// anchors maps the names internal links use to the headings' places.
+	anchors map[string]string
+	notes   *convert.NoteSet
+	// noteFiles maps note IDs to the chapter of their first anchor, which
+	// holds the note.
+	noteFiles map[uint64]string
+	// numbers maps list items' block IDs to their numbers.
+	numbers map[uint64]uint32
+	lists   convert.ListNesting
+}
+
+func newExporter(doc *sqdoc.Document, opts Options) *exporter {
+	ex := &exporter{doc: doc, opts: opts, lang: doc.Metadata.Language, resources: map[string]*resource{}}
+	if ex.lang == "" {
+		ex.lang = defaultLanguage
+	}
+	ex.base = convert.DefaultAttr()
+	ex.base.FontFamily = doc.Metadata.PreferredFontFamily
+	for i, blocks := range splitChapters(doc.Blocks, opts.SplitLevel) {
+		ch := chapter{file: fmt.Sprintf("chapter%d.xhtml", i+1), blocks: blocks}
+		if len(blocks) > 0 && convert.HeadingLevel(blocks[0]) > 0 {
+			ch.title = plainText(blocks[0])
+		}
+		ex.chapters = append(ex.chapters, ch)
+	}
+	// Headings are numbered in document order as they are written; number
+	// them the same way here so links can point into later chapters. Other
+	// paragraphs have no ids, so bookmarks in them lead to their chapter.
+	ex.anchors = map[string]string{}
+	named := convert.BlockAnchors(doc)
+	n := 0
+	for _, ch := range ex.chapters {
+		for _, b := range ch.blocks {
+			href := ch.file
+			if convert.HeadingLevel(b) > 0 {
+				n++
+				href += "#h" + strconv.Itoa(n)
+			}
+			for _, name := range named[b.ID] {
+				ex.anchors[name] = href
+			}
+		}
+	}
+	ex.numbers = map[uint64]uint32{}
+	for i, n := range doc.ListNumbers() {
+		if n != 0 {
+			ex.numbers[doc.Blocks[i].ID] = n
+		}
+	}
+	ex.notes = convert.NewNoteSet(doc)
+	ex.noteFiles = map[uint64]string{}
+	for _, ch := range ex.chapters {
+		for _, b := range ch.blocks {
+			for _, spans := range append([][]convert.Span{convert.Spans(b)}, convert.TableSpans(b)...) {
+				for _, s := range spans {
+					if id := s.Attr.Note; id != 0 && ex.noteFiles[id] == "" {
+						ex.noteFiles[id] = ch.file
+					}
+				}
+			}
+		}
+	}
+	return ex
+}
+
+func (ex *exporter) parts() []part {
+	var parts []part
+	parts = append(parts, part{"META-INF/container.xml", []byte(containerXML)})
+	// Content documents are rendered first so the manifest and navigation
+	// document know every image and heading.
+	var content []part
+	for i := range ex.chapters {
+		content = append(content, part{"OEBPS/" + ex.chapters[i].file, ex.contentDocument(&ex.chapters[i])})
+	}
+	parts = append(parts, part{"OEBPS/content.opf", ex.packageDocument()})
+	parts = append(parts, part{"OEBPS/nav.xhtml", ex.navDocument()})
+	parts = append(parts, part{"OEBPS/style.css", ex.stylesheet()})
+	parts = append(parts, content...)
+	for _, r := range ex.media {
+		parts = append(parts, part{"OEBPS/" + r.name, r.data})
+	}
+	return parts
+}
+
+const containerXML = xml.Header + `<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+<rootfiles><rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/></rootfiles>
+</container>
+`
+
+func (ex *exporter) title() string {
+	if t := strings.TrimSpace(ex.doc.Metadata.Title); t != "" {
+		return t
+	}
+	return "Untitled"
+}
+
+func (ex *exporter) xhtmlHead(out *bytes.Buffer, title string) {
+	out.WriteString(xml.Header)
+	out.WriteString("<!DOCTYPE html>\n")
+	fmt.Fprintf(out, `<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops" lang="%s" xml:lang="%s">`+"\n", escape(ex.lang), escape(ex.lang))
+	fmt.Fprintf(out, "<head>\n<title>%s</title>\n", escape(title))
+	out.WriteString(`<link rel="stylesheet" type="text/css" href="style.css"/>` + "\n</head>\n")
+}
+
+func (ex *exporter) contentDocument(ch *chapter) []byte {
+	title := ch.title
+	if title == "" {
+		title = ex.title()
+	}
+	var out bytes.Buffer
+	ex.xhtmlHead(&out, title)
+	out.WriteString("<body>\n")
+	if ch.title == "" {
+		ex.toc = append(ex.toc, tocEntry{title: title, href: ch.file})
+	}
+	for _, b := range ch.blocks {
+		ex.block(&out, ch.file, b)
+	}
+	out.WriteString(htmlexport.ListTags(&ex.lists, sqdoc.ListItem{}, 0))
+	ex.asides(&out, ch.file)
+	out.WriteString("</body>\n</html>\n")
+	return out.Bytes()
+}
+
+func (ex *exporter) block(out *bytes.Buffer, file string, b sqdoc.Block) {
+	if b.Kind == sqdoc.BlockKindTable {
+		out.WriteString(htmlexport.ListTags(&ex.lists, sqdoc.ListItem{}, 0))
+		ex.table(out, file, b.Table)
+		return
+	}
+	out.WriteString(htmlexport.ListTags(&ex.lists, b.Text.List, ex.numbers[b.ID]))
+	style := ""
+	if css := htmlexport.ParaCSS(convert.ParaFormat(b.Text), ex.doc.Metadata.ParagraphGap); css != "" {
+		style = fmt.Sprintf(` style="%s"`, escape(css))
+	}
+	if b.Text.Code != nil {
+		// The editor keeps notes, references and formatting out of code, so
+		// its text is written as it stands.
+		out.WriteString("<pre" + style + ">" + htmlexport.CodeTag(b.Text.Code))
+		for _, s := range convert.CodeSpans(b) {
+			if s.Image == nil {
+				out.WriteString(strings.ReplaceAll(escape(s.Text), "&#xA;", "\n"))
+			}
+		}
+		out.WriteString("</code></pre>\n")
+		return
+	}
+	level := convert.HeadingLevel(b)
+	base := ex.base
+	tag := "p"
+	if level > 0 {
+		ex.headings++
+		id := "h" + strconv.Itoa(ex.headings)
+		title := plainText(b)
+		if title == "" {
+			title = "Section " + strconv.Itoa(ex.headings)
+		}
+		ex.toc = append(ex.toc, tocEntry{level: level, title: title, href: file + "#" + id})
+		base = convert.HeadingAttr(level)
+		base.FontFamily = ex.base.FontFamily
+		tag = "h" + strconv.Itoa(level)
+		fmt.Fprintf(out, `<%s id="%s"%s>`, tag, id, style)
+	} else {
+		out.WriteString("<p" + style + ">")
+	}
+	spans := convert.Spans(b)
+	if len(spans) == 0 {
+		out.WriteString("<br/>")
+	}
+	ex.inline(out, file, spans, base, level > 0)
+	fmt.Fprintf(out, "</%s>\n", tag)
+}
+
+// table writes t as the HTML exporter does, header rows in <thead>.
+func (ex *exporter) table(out *bytes.Buffer, file string, t *sqdoc.TableBlock) {
+	out.WriteString("<table>\n")
+	rows := convert.TableRows(t)
+	header := min(int(t.HeaderRows), len(rows))
+	for r, row := range rows {
+		switch {
+		case r == 0 && header > 0:
+			out.WriteString("<thead>\n")
+		case r == header:
+			out.WriteString("<tbody>\n")
+		}
+		out.WriteString("<tr>")
+		for _, cell := range row {
+			if cell.Covered {
+				continue
+			}
+			tag := "td"
+			if cell.Header {
+				tag = "th"
+			}
+			fmt.Fprintf(out, "<%s", tag)
+			if cell.ColSpan > 1 {
+				fmt.Fprintf(out, ` colspan="%d"`, cell.ColSpan)
+			}
+			if cell.RowSpan > 1 {
+				fmt.Fprintf(out, ` rowspan="%d"`, cell.RowSpan)
+			}
+			if style := htmlexport.CellCSS(cell); style != "" {
+				fmt.Fprintf(out, ` style="%s"`, escape(style))
+			}
+			out.WriteString(">")
+			ex.inline(out, file, cell.Spans, ex.base, false)
+			fmt.Fprintf(out, "</%s>", tag)
+		}
+		out.WriteString("</tr>\n")
+		switch {
+		case r+1 == header:
+			out.WriteString("</thead>\n")
+		case r+1 == len(rows):
+			out.WriteString("</tbody>\n")
+		}
+	}
+	out.WriteString("</table>\n")
+}
+
+// inline writes the spans of a paragraph. Headings are bold throughout, so
+// their spans leave it to the element.
+func (ex *exporter) inline(out *bytes.Buffer, file string, spans []convert.Span, base sqdoc.StyleAttr, heading bool) {
+	link := ""
+	for _, s := range spans {
+		if n, first := ex.notes.Anchor(s); n != nil {
+			ex.switchLink(out, link, "")
+			link = ""
+			ex.noteRef(out, file, n, first)
+			continue
+		}
+		if s.Attr.Link != link {
+			ex.switchLink(out, link, s.Attr.Link)
+			link = s.Attr.Link
+		}
+		attr := s.Attr
+		if heading {
+			attr.Bold = false
+		}
+		style := htmlexport.SpanCSS(attr, base)
+		if style != "" {
+			fmt.Fprintf(out, `<span style="%s">`, escape(style))
+		}
+		if s.Image != nil {
+			ex.image(out, s)
+		} else {
+			for i, line := range strings.Split(s.Text, "\n") {
+				if i > 0 {
+					out.WriteString("<br/>")
+				}
+				out.WriteString(escape(line))
+			}
+		}
+		if style != "" {
+			out.WriteString("</span>")
+		}
+	}
+	ex.switchLink(out, link, "")
+}
+
+// noteRef writes an anchor as a link to its note, in whichever chapter
+// that went. The first anchor is where the note links back to.
+func (ex *exporter) noteRef(out *bytes.Buffer, file string, n *convert.Note, first bool) {
+	href := "#note-" + n.Number
+	if f := ex.noteFiles[n.ID]; f != file {
+		href = f + href
+	}
+	id := ""
+	if first {
+		id = fmt.Sprintf(` id="noteref-%s"`, n.Number)
+	}
+	fmt.Fprintf(out, `<sup><a epub:type="noteref" href="%s"%s>%s</a></sup>`, escape(href), id, n.Number)
+}
+
+// asides writes the notes first referred to in the chapter file at its
+// end, where reading systems can show them as pop-ups.
+func (ex *exporter) asides(out *bytes.Buffer, file string) {
+	for _, n := range ex.notes.List {
+		if ex.noteFiles[n.ID] != file {
+			continue
+		}
+		kind := "footnote"
+		if n.Kind == sqdoc.NoteEndnote {
+			kind = "endnote"
+		}
+		fmt.Fprintf(out, `<aside epub:type="%s" id="note-%s"><p><a href="#noteref-%s">%s</a>. `, kind, n.Number, n.Number, n.Number)
+		ex.inline(out, file, n.Spans, ex.base, false)
+		out.WriteString("</p></aside>\n")
+	}
+}
+
+// switchLink closes the <a> element for the link from and opens one for to.
+// Internal links point at the chapter file their heading ended up in.
+func (ex *exporter) switchLink(out *bytes.Buffer, from, to string) {
+	if from != "" {
+		out.WriteString("</a>")
+	}
+	if to == "" {
+		return
+	}
+	href := to
+	if name, ok := sqdoc.LinkAnchor(to); ok && ex.anchors[name] != "" {
+		href = ex.anchors[name]
+	}
+	fmt.Fprintf(out, `<a href="%s">`, escape(href))
+}
+
+func (ex *exporter) image(out *bytes.Buffer, s convert.Span) {
+	r := ex.resource(s.Image.Path)
+	if r == nil {
+		out.WriteString(escape("[missing image: " + filepath.Base(s.Image.Path) + "]"))
+		return
+	}
+	w, h := convert.ImageDisplaySize(r.w, r.h, s.Image.Width, s.Image.Height, s.Attr.FontSizePt)
+	fmt.Fprintf(out, `<img src="%s" alt="%s" width="%d" height="%d"/>`, r.name, escape(filepath.Base(s.Image.Path)), w, h)
+}
+
+func (ex *exporter) resource(path string) *resource {
+	if r, ok := ex.resources[path]; ok {
+		return r
+	}
+	var r *resource
+	if img, err := convert.LoadEmbeddedImage(path, ex.opts.BaseDir); err == nil {
+		r = &resource{
+			name:      fmt.Sprintf("images/image%d.%s", len(ex.media)+1, img.Format),
+			mediaType: img.MediaType(),
+			data:      img.Data,
+			w:         img.Width,
+			h:         img.Height,
+		}
+		ex.media = append(ex.media, r)
+	}
+	ex.resources[path] = r
+	return r
+}
+
+func (ex *exporter) stylesheet() []byte {
+	m := ex.doc.Metadata
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "body { font-family: %s; font-size: %dpt; color: %s; }\n",
+		htmlexport.FontStack(m.PreferredFontFamily), ex.base.FontSizePt, htmlexport.CSSColor(ex.base.ColorRGBA))
+	fmt.Fprintf(&b, "p, h1, h2, h3, h4, h5 { margin: 0 0 %dpx 0; white-space: pre-wrap; overflow-wrap: anywhere; }\n", m.ParagraphGap)
+	for i, size := range convert.HeadingSizes {
+		fmt.Fprintf(&b, "h%d { font-size: %dpt; font-weight: bold; }\n", i+1, size)
+	}
+	b.WriteString("img { max-width: 100%; vertical-align: baseline; }\n")
+	b.WriteString(htmlexport.ListCSS)
+	b.WriteString(htmlexport.CodeCSS(m.ParagraphGap))
+	b.WriteString(htmlexport.TableCSS(m.ParagraphGap))
+	return b.Bytes()
+}
+
+func (ex *exporter) packageDocument() []byte {
+	m := ex.doc.Metadata
+	var b bytes.Buffer
+	b.WriteString(xml.Header)
+	fmt.Fprintf(&b, `<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="bookid" xml:lang="%s">`+"\n", escape(ex.lang))
+	b.WriteString(`<metadata xmlns:dc="http://purl.org/dc/elements/1.1/">` + "\n")
+	fmt.Fprintf(&b, "<dc:identifier id=\"bookid\">urn:uuid:%s</dc:identifier>\n", ex.identifier())
+	fmt.Fprintf(&b, "<dc:title>%s</dc:title>\n", escape(ex.title()))
+	if m.Author != "" {
+		fmt.Fprintf(&b, "<dc:creator>%s</dc:creator>\n", escape(m.Author))
+	}
+	fmt.Fprintf(&b, "<dc:language>%s</dc:language>\n", escape(ex.lang))
+	if m.CreatedUnix != 0 {
+		fmt.Fprintf(&b, "<dc:date>%s</dc:date>\n", epubDate(m.CreatedUnix))
+	}
+	modified := m.ModifiedUnix
+	if modified == 0 {
+		modified = m.CreatedUnix
+	}
+	if modified == 0 {
+		modified = time.Now().Unix()
+	}
+	fmt.Fprintf(&b, "<meta property=\"dcterms:modified\">%s</meta>\n", epubDate(modified))
+	b.WriteString("</metadata>\n<manifest>\n")
+	b.WriteString(`<item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>` + "\n")
+	b.WriteString(`<item id="css" href="style.css" media-type="text/css"/>` + "\n")
+	for i, ch := range ex.chapters {
+		fmt.Fprintf(&b, "<item id=\"chapter%d\" href=\"%s\" media-type=\"application/xhtml+xml\"/>\n", i+1, ch.file)
+	}
+	for i, r := range ex.media {
+		fmt.Fprintf(&b, "<item id=\"image%d\" href=\"%s\" media-type=\"%s\"/>\n", i+1, r.name, r.mediaType)
+	}
+	b.WriteString("</manifest>\n<spine>\n")
+	for i := range ex.chapters {
+		fmt.Fprintf(&b, "<itemref idref=\"chapter%d\"/>\n", i+1)
+	}
+	b.WriteString("</spine>\n</package>\n")
+	return b.Bytes()
+}
+
+// identifier derives a stable name-based UUID from the metadata, so
+// re-exporting the same document keeps its identity in reading systems.
+func (ex *exporter) identifier() string {
+	m := ex.doc.Metadata
+	sum := sha1.Sum([]byte(fmt.Sprintf("sqdoc\x00%s\x00%s\x00%d", m.Title, m.Author, m.CreatedUnix)))
+	sum[6] = sum[6]&0x0F | 0x50
+	sum[8] = sum[8]&0x3F | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", sum[0:4], sum[4:6], sum[6:8], sum[8:10], sum[10:16])
+}
+
+// navDocument nests the headings by level. A heading deeper than its
+// predecessor opens one list level however many levels it skips.
+func (ex *exporter) navDocument() []byte {
+	var b bytes.Buffer
+	ex.xhtmlHead(&b, ex.title())
+	b.WriteString("<body>\n<nav epub:type=\"toc\" id=\"toc\">\n")
+	fmt.Fprintf(&b, "<h1>%s</h1>\n<ol>", escape(ex.title()))
+	var open []int
+	depth := 0
+	for i, e := range ex.toc {
+		for len(open) > 0 && (e.level == 0 || open[len(open)-1] >= e.level) {
+			open = open[:len(open)-1]
+		}
+		d := len(open)
+		if e.level > 0 {
+			open = append(open, e.level)
+		}
+		if i > 0 {
+			if d > depth {
+				b.WriteString("<ol>")
+			} else {
+				b.WriteString("</li>")
+				for ; depth > d; depth-- {
+					b.WriteString("</ol></li>")
+				}
+			}
+		}
+		depth = d
+		fmt.Fprintf(&b, "\n<li><a href=\"%s\">%s</a>", e.href, escape(e.title))
+	}
+	b.WriteString("</li>")
+	for ; depth > 0; depth-- {
+		b.WriteString("</ol></li>")
+	}
+	b.WriteString("\n</ol>\n</nav>\n</body>\n</html>\n")
+	return b.Bytes()
+}
+
+func epubDate(unix int64) string {
+	return time.Unix(unix, 0).UTC().Format("2006-01-02T15:04:05Z")
+}
+
+func escape(s string) string {
+	var b strings.Builder
+	_ = xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
@@ -0,0 +1,226 @@
+// Package fragment is SIDE's own clipboard format: copied blocks in the
+// SQDoc layout together with the images they show, packed in one zip so a
+// paste into another tab or another SIDE instance keeps every style run and
+// picture.
+//
+// The zip holds "fragment.sqdoc" and one "media/" entry per image. Image
+// tokens in the blocks point at those entries, and each entry's comment
+// records the path the image had when it was copied.
+package fragment
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"sqdoc/pkg/convert"
+	"sqdoc/pkg/sqdoc"
+)
+
+// MediaType names the format on clipboards that key flavours by MIME type
+// and is the registered clipboard format name on Windows.
+const MediaType = "application/x-sqdoc-fragment"
+
+const (
+	blocksEntry = "fragment.sqdoc"
+	mediaPrefix = "media/"
+	// maxEntrySize bounds what Import reads from one entry, since the data
+	// comes from whichever program last wrote the clipboard.
+	maxEntrySize = 64 << 20
+)
+
+type Options struct {
+	// BaseDir resolves relative image paths on export.
+	BaseDir string
+	// MediaDir receives images on import whose original file is gone or
+	// has changed since the copy. Import uses a new temporary directory when
+	// it is empty.
+	MediaDir string
+}
+
+// Export packs doc's blocks and the images they reference. Images that
+// cannot be read keep their original path.
+func Export(doc *sqdoc.Document, opts Options) ([]byte, error) {
+	if doc == nil {
+		return nil, errors.New("fragment: document is nil")
+	}
+	out := sqdoc.CloneDocument(doc)
+	var media []*zip.FileHeader
+	var mediaData [][]byte
+	entries := map[string]string{}
+	for i := range out.Blocks {
+		out.Blocks[i].Text = rewriteImages(out.Blocks[i].Text, func(p string) string {
+			if name, ok := entries[p]; ok {
+				return name
+			}
+			src := convert.ResolvePath(p, opts.BaseDir)
+			data, err := os.ReadFile(src)
+			if err != nil {
+				return p
+			}
+			if abs, err := filepath.Abs(src); err == nil {
+				src = abs
+			}
+			name := fmt.Sprintf("%simage%d%s", mediaPrefix, len(media)+1, strings.ToLower(filepath.Ext(p)))
+			entries[p] = name
+			media = append(media, &zip.FileHeader{Name: name, Method: zip.Store, Comment: src})
+			mediaData = append(mediaData, data)
+			return name
+		})
+	}
+	blob, err := sqdoc.Encode(out)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: blocksEntry, Method: zip.Deflate})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(blob); err != nil {
+		return nil, err
+	}
+	// Images are already compressed, so they are stored as they are.
+	for i, hdr := range media {
+		w, err := zw.CreateHeader(hdr)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(mediaData[i]); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Import unpacks a fragment. An image whose original file still holds the
+// same bytes keeps that path; any other is written to MediaDir.
+func Import(data []byte, opts Options) (*sqdoc.Document, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("fragment: %w", err)
+	}
+	files := map[string]*zip.File{}
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+	f, ok := files[blocksEntry]
+	if !ok {
+		return nil, errors.New("fragment: missing " + blocksEntry)
+	}
+	blob, err := readEntry(f)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := sqdoc.Decode(blob)
+	if err != nil {
+		return nil, err
+	}
+
+	im := importer{opts: opts, files: files, placed: map[string]string{}}
+	for i := range doc.Blocks {
+		doc.Blocks[i].Text = rewriteImages(doc.Blocks[i].Text, im.place)
+		if im.err != nil {
+			return nil, im.err
+		}
+	}
+	return doc, nil
+}
+
+type importer struct {
+	opts   Options
+	files  map[string]*zip.File
+	placed map[string]string
+	err    error
+}
+
+// place returns the path an image token should use after the paste.
+func (im *importer) place(name string) string {
+	if p, ok := im.placed[name]; ok {
+		return p
+	}
+	f, ok := im.files[name]
+	if !ok || !strings.HasPrefix(name, mediaPrefix) || im.err != nil {
+		return name
+	}
+	data, err := readEntry(f)
+	if err != nil {
+		im.err = err
+		return name
+	}
+	dst := f.Comment
+	if existing, err := os.ReadFile(dst); dst == "" || err != nil || !bytes.Equal(existing, data) {
+		dst, im.err = im.writeMedia(path.Base(name), data)
+	}
+	im.placed[name] = dst
+	return dst
+}
+
+func (im *importer) writeMedia(name string, data []byte) (string, error) {
+	if im.opts.MediaDir == "" {
+		dir, err := os.MkdirTemp("", "sqdoc-fragment-")
+		if err != nil {
+			return "", err
+		}
+		im.opts.MediaDir = dir
+	}
+	if err := os.MkdirAll(im.opts.MediaDir, 0o755); err != nil {
+		return "", err
+	}
+	ext := path.Ext(name)
+	// MediaDir is usually shared by every document, so names must not
+	// collide with earlier pastes.
+	f, err := os.CreateTemp(im.opts.MediaDir, "paste-*"+ext)
+	if err != nil {
+		return "", err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+	return filepath.Abs(f.Name())
+}
+
+func readEntry(f *zip.File) ([]byte, error) {
+	if f.UncompressedSize64 > maxEntrySize {
+		return nil, fmt.Errorf("fragment: %s is too large", f.Name)
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(io.LimitReader(rc, maxEntrySize))
+}
+
+// rewriteImages returns tb with every image token's path replaced by
+// fn(path), moving style runs to match. Blocks without images are returned
+// unchanged.
+func rewriteImages(tb *sqdoc.TextBlock, fn func(string) string) *sqdoc.TextBlock {
+	if tb == nil || len(sqdoc.ParseImageTokens(tb.UTF8)) == 0 {
+		return tb
+	}
+	var bb convert.BlockBuilder
+	for _, s := range convert.Spans(sqdoc.Block{Kind: sqdoc.BlockKindText, Text: tb}) {
+		if s.Image != nil {
+			bb.WriteImage(fn(s.Image.Path), s.Image.Width, s.Image.Height, s.Attr)
+		} else {
+			bb.WriteString(s.Text, s.Attr)
+		}
+	}
+	return bb.TextBlock()
+}
@@ -0,0 +1,94 @@
+package fragment
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"sqdoc/pkg/convert"
+	"sqdoc/pkg/sqdoc"
+)
+
+func TestExportImportKeepsRunsAndImages(t *testing.T) {
+	dir := t.TempDir()
+	imgPath := filepath.Join(dir, "dot.png")
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 3, 2))); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(imgPath, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	bold := convert.DefaultAttr()
+	bold.Bold = true
+	bold.ColorRGBA = 0xAA0000FF
+	var bb convert.BlockBuilder
+	bb.WriteString("plain ", convert.DefaultAttr())
+	bb.WriteString("bold", bold)
+	bb.WriteImage(imgPath, 30, 20, bold)
+	bb.WriteString(" tail", convert.DefaultAttr())
+	doc := &sqdoc.Document{Blocks: []sqdoc.Block{
+		{ID: 1, Kind: sqdoc.BlockKindText, Text: bb.TextBlock()},
+		{ID: 2, Kind: sqdoc.BlockKindText, Text: &sqdoc.TextBlock{UTF8: []byte("second")}},
+	}}
+	want := convert.Spans(doc.Blocks[0])
+
+	out, err := Export(doc, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	check := func(back *sqdoc.Document, wantPath func(string) bool) {
+		t.Helper()
+		if len(back.Blocks) != 2 || string(back.Blocks[1].Text.UTF8) != "second" {
+			t.Fatalf("blocks = %+v", back.Blocks)
+		}
+		got := convert.Spans(back.Blocks[0])
+		if len(got) != len(want) {
+			t.Fatalf("got %d spans, want %d", len(got), len(want))
+		}
+		for i := range want {
+			if got[i].Attr != want[i].Attr {
+				t.Fatalf("span %d attr = %+v, want %+v", i, got[i].Attr, want[i].Attr)
+			}
+			if want[i].Image == nil {
+				if got[i].Text != want[i].Text {
+					t.Fatalf("span %d = %q, want %q", i, got[i].Text, want[i].Text)
+				}
+				continue
+			}
+			img := got[i].Image
+			if img == nil || img.Width != 30 || img.Height != 20 || !wantPath(img.Path) {
+				t.Fatalf("image span = %+v", got[i])
+			}
+			if data, err := os.ReadFile(img.Path); err != nil || !bytes.Equal(data, buf.Bytes()) {
+				t.Fatalf("pasted image differs: %v", err)
+			}
+		}
+	}
+
+	mediaDir := filepath.Join(t.TempDir(), "media")
+	back, err := Import(out, Options{MediaDir: mediaDir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(back, func(p string) bool { return p == imgPath })
+
+	if err := os.Remove(imgPath); err != nil {
+		t.Fatal(err)
+	}
+	back, err = Import(out, Options{MediaDir: mediaDir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(back, func(p string) bool { return filepath.Dir(p) == mediaDir })
+}
+
+func TestImportRejectsOtherData(t *testing.T) {
+	if _, err := Import([]byte("plain text"), Options{}); err == nil {
+		t.Fatal("plain text accepted")
+	}
+}
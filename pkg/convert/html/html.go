@@ -0,0 +1,502 @@
+// Package html converts between HTML and SQDoc documents.
+//
+// Export writes a single self-contained file: each text block becomes a
+// paragraph and each style run a span, both carrying their formatting as
+// inline CSS, with inline images embedded as data URIs. List items nest in <ul>
+// and <ol> elements and code blocks become <pre><code> naming their
+// language. Tables become <table> elements with their spans, borders and
+// shading. Notes are listed at the end, linked both ways with their
+// anchors. Import reads pages and clipboard fragments, keeping the
+// formatting SQDoc can represent.
+package html
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	stdhtml "html"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"sqdoc/pkg/convert"
+	"sqdoc/pkg/sqdoc"
+)
+
+// HighlightCSS matches the highlight colour SIDE paints behind text.
+const HighlightCSS = "#fff4a8"
+
+type Options struct {
+	// BaseDir resolves relative image paths.
+	BaseDir string
+	// MediaDir receives images embedded as data URIs on import. ImportFile
+	// defaults it to a "<name>_media" folder next to the source file; Import
+	// uses a new temporary folder.
+	MediaDir string
+}
+
+func ExportFile(path string, doc *sqdoc.Document, opts Options) error {
+	out, err := Export(doc, opts)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, out, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func Export(doc *sqdoc.Document, opts Options) ([]byte, error) {
+	if doc == nil {
+		return nil, errors.New("html: document is nil")
+	}
+	m := doc.Metadata
+	base := convert.DefaultAttr()
+	base.FontFamily = m.PreferredFontFamily
+
+	var out bytes.Buffer
+	out.WriteString("<!DOCTYPE html>\n")
+	if m.Language != "" {
+		fmt.Fprintf(&out, "<html lang=\"%s\">\n", stdhtml.EscapeString(m.Language))
+	} else {
+		out.WriteString("<html>\n")
+	}
+	out.WriteString("<head>\n<meta charset=\"utf-8\">\n")
+	fmt.Fprintf(&out, "<title>%s</title>\n", stdhtml.EscapeString(m.Title))
+	writeMeta(&out, "author", m.Author)
+	writeMeta(&out, "generator", "SIDE")
+	writeMeta(&out, "dcterms.created", unixRFC3339(m.CreatedUnix))
+	writeMeta(&out, "dcterms.modified", unixRFC3339(m.ModifiedUnix))
+	out.WriteString("<style>\n")
+	fmt.Fprintf(&out, "body { font-family: %s; font-size: %dpt; color: %s; }\n", FontStack(m.PreferredFontFamily), base.FontSizePt, CSSColor(base.ColorRGBA))
+	fmt.Fprintf(&out, "p { margin: 0 0 %dpx 0; white-space: pre-wrap; overflow-wrap: anywhere; }\n", m.ParagraphGap)
+	out.WriteString("img { vertical-align: baseline; }\n")
+	out.WriteString(ListCSS)
+	out.WriteString(CodeCSS(m.ParagraphGap))
+	out.WriteString(TableCSS(m.ParagraphGap))
+	out.WriteString("</style>\n</head>\n<body>\n")
+
+	anchors := convert.BlockAnchors(doc)
+	notes := convert.NewNoteSet(doc)
+	numbers := doc.ListNumbers()
+	var lists convert.ListNesting
+	for i, b := range doc.Blocks {
+		if b.Kind == sqdoc.BlockKindTable && b.Table != nil {
+			out.WriteString(ListTags(&lists, sqdoc.ListItem{}, 0))
+			writeTable(&out, b.Table, notes, base, opts)
+			continue
+		}
+		if b.Kind != sqdoc.BlockKindText || b.Text == nil {
+			continue
+		}
+		out.WriteString(ListTags(&lists, b.Text.List, numbers[i]))
+		tag := "p"
+		if b.Text.Code != nil {
+			tag = "pre"
+		}
+		fmt.Fprintf(&out, "<%s", tag)
+		names := anchors[b.ID]
+		if len(names) > 0 {
+			fmt.Fprintf(&out, " id=\"%s\"", stdhtml.EscapeString(names[0]))
+		}
+		if style := ParaCSS(convert.ParaFormat(b.Text), m.ParagraphGap); style != "" {
+			fmt.Fprintf(&out, " style=\"%s\"", style)
+		}
+		out.WriteString(">")
+		for _, name := range names[min(len(names), 1):] {
+			fmt.Fprintf(&out, "<a id=\"%s\"></a>", stdhtml.EscapeString(name))
+		}
+		if b.Text.Code != nil {
+			code := base
+			code.FontFamily = sqdoc.FontFamilyMonospace
+			out.WriteString(CodeTag(b.Text.Code))
+			writeSpans(&out, convert.CodeSpans(b), notes, code, opts)
+			out.WriteString("</code></pre>\n")
+			continue
+		}
+		spans := convert.Spans(b)
+		if len(spans) == 0 {
+			out.WriteString("<br>")
+		}
+		writeSpans(&out, spans, notes, base, opts)
+		out.WriteString("</p>\n")
+	}
+	out.WriteString(ListTags(&lists, sqdoc.ListItem{}, 0))
+	writeNotes(&out, notes.List, base, opts)
+	out.WriteString("</body>\n</html>\n")
+	return out.Bytes(), nil
+}
+
+func writeMeta(out *bytes.Buffer, name, content string) {
+	if content == "" {
+		return
+	}
+	fmt.Fprintf(out, "<meta name=\"%s\" content=\"%s\">\n", name, stdhtml.EscapeString(content))
+}
+
+// writeSpans writes the text of a paragraph or cell with its links and note
+// anchors.
+func writeSpans(out *bytes.Buffer, spans []convert.Span, notes *convert.NoteSet, base sqdoc.StyleAttr, opts Options) {
+	link := ""
+	for _, s := range spans {
+		if n, first := notes.Anchor(s); n != nil {
+			link = switchLink(out, link, "")
+			writeNoteRef(out, n, first)
+			continue
+		}
+		link = switchLink(out, link, s.Attr.Link)
+		writeSpan(out, s, base, opts)
+	}
+	switchLink(out, link, "")
+}
+
+// ListCSS lays out list elements, indenting each level the same.
+var ListCSS = fmt.Sprintf("ul, ol { margin: 0; padding-left: %dpt; }\n", convert.ListIndentPt)
+
+// ListTags returns the tags that close and open lists before a block with
+// the given list item, then the <li> that holds it; the item's </li> comes
+// with the tags for the next block. number is the item's number from the
+// document's ListNumbers, which numbered lists that open past 1 and items
+// that restart their count carry along. Levels an item skips get empty
+// items of their own.
+func ListTags(ln *convert.ListNesting, item sqdoc.ListItem, number uint32) string {
+	closed, opened := ln.Next(item)
+	var b strings.Builder
+	for _, l := range closed {
+		fmt.Fprintf(&b, "</li></%s>\n", listTag(l.Style))
+	}
+	if item.ID == 0 {
+		return b.String()
+	}
+	if len(opened) == 0 {
+		b.WriteString("</li>\n")
+	}
+	for i, l := range opened {
+		if i > 0 {
+			b.WriteString("<li>")
+		}
+		b.WriteString("<" + listTag(l.Style))
+		if t := listType(l.Style); t != "" {
+			fmt.Fprintf(&b, " type=\"%s\"", t)
+		}
+		if i == len(opened)-1 && l.Style.Numbered() && number != 1 {
+			fmt.Fprintf(&b, " start=\"%d\"", number)
+		}
+		b.WriteString(">\n")
+	}
+	if len(opened) == 0 && item.Style.Numbered() && item.Start != 0 {
+		fmt.Fprintf(&b, "<li value=\"%d\">", number)
+	} else {
+		b.WriteString("<li>")
+	}
+	return b.String()
+}
+
+func listTag(s sqdoc.ListStyle) string {
+	if s.Numbered() {
+		return "ol"
+	}
+	return "ul"
+}
+
+// listType is the type attribute of an <ol>, or "" for the default.
+func listType(s sqdoc.ListStyle) string {
+	switch s {
+	case sqdoc.ListLowerAlpha:
+		return "a"
+	case sqdoc.ListUpperAlpha:
+		return "A"
+	case sqdoc.ListLowerRoman:
+		return "i"
+	case sqdoc.ListUpperRoman:
+		return "I"
+	}
+	return ""
+}
+
+// CodeCSS returns the rules for code blocks, which keep their lines and
+// are shaded and spaced like paragraphs.
+func CodeCSS(gap uint16) string {
+	return fmt.Sprintf("pre { margin: 0 0 %dpx 0; padding: %dpt; background-color: %s; font-family: %s; white-space: pre; overflow-x: auto; }\n",
+		gap, convert.CodePaddingPt, CSSColor(convert.CodeShadeRGBA), FontStack(sqdoc.FontFamilyMonospace)) +
+		"pre code { font-family: inherit; }\n"
+}
+
+// CodeTag opens the <code> element of a code block, naming its language
+// the way syntax highlighters look for it.
+func CodeTag(c *sqdoc.CodeBlock) string {
+	if c.Language == "" {
+		return "<code>"
+	}
+	return fmt.Sprintf("<code class=\"language-%s\">", stdhtml.EscapeString(c.Language))
+}
+
+// writeTable writes t with header rows in <thead> as <th> cells. Columns
+// with a fixed width get it from a <colgroup>.
+func writeTable(out *bytes.Buffer, t *sqdoc.TableBlock, notes *convert.NoteSet, base sqdoc.StyleAttr, opts Options) {
+	out.WriteString("<table>\n")
+	fixed := false
+	for _, w := range t.Columns {
+		fixed = fixed || w != 0
+	}
+	if fixed {
+		out.WriteString("<colgroup>")
+		for _, w := range t.Columns {
+			if w == 0 {
+				out.WriteString("<col>")
+			} else {
+				fmt.Fprintf(out, "<col style=\"width: %dpt\">", w)
+			}
+		}
+		out.WriteString("</colgroup>\n")
+	}
+	rows := convert.TableRows(t)
+	header := min(int(t.HeaderRows), len(rows))
+	for r, row := range rows {
+		switch {
+		case r == 0 && header > 0:
+			out.WriteString("<thead>\n")
+		case r == header:
+			out.WriteString("<tbody>\n")
+		}
+		out.WriteString("<tr>")
+		for _, cell := range row {
+			if cell.Covered {
+				continue
+			}
+			tag := "td"
+			if cell.Header {
+				tag = "th"
+			}
+			fmt.Fprintf(out, "<%s", tag)
+			if cell.ColSpan > 1 {
+				fmt.Fprintf(out, " colspan=\"%d\"", cell.ColSpan)
+			}
+			if cell.RowSpan > 1 {
+				fmt.Fprintf(out, " rowspan=\"%d\"", cell.RowSpan)
+			}
+			if style := CellCSS(cell); style != "" {
+				fmt.Fprintf(out, " style=\"%s\"", style)
+			}
+			out.WriteString(">")
+			writeSpans(out, cell.Spans, notes, base, opts)
+			fmt.Fprintf(out, "</%s>", tag)
+		}
+		out.WriteString("</tr>\n")
+		switch {
+		case r+1 == header:
+			out.WriteString("</thead>\n")
+		case r+1 == len(rows):
+			out.WriteString("</tbody>\n")
+		}
+	}
+	out.WriteString("</table>\n")
+}
+
+// ParaCSS returns the declarations for a paragraph's alignment, indents,
+// spacing and line height, with its space after added to the paragraph gap
+// of gap pixels.
+func ParaCSS(p sqdoc.ParagraphFormat, gap uint16) string {
+	var decl []string
+	switch p.Align {
+	case sqdoc.AlignCenter:
+		decl = append(decl, "text-align: center")
+	case sqdoc.AlignRight:
+		decl = append(decl, "text-align: right")
+	case sqdoc.AlignJustify:
+		decl = append(decl, "text-align: justify")
+	}
+	if p.IndentLeft != 0 {
+		decl = append(decl, fmt.Sprintf("margin-left: %dpt", p.IndentLeft))
+	}
+	if p.IndentRight != 0 {
+		decl = append(decl, fmt.Sprintf("margin-right: %dpt", p.IndentRight))
+	}
+	if p.IndentFirst != 0 {
+		decl = append(decl, fmt.Sprintf("text-indent: %dpt", p.IndentFirst))
+	}
+	if p.SpaceBefore != 0 {
+		decl = append(decl, fmt.Sprintf("margin-top: %dpx", p.SpaceBefore))
+	}
+	if p.SpaceAfter != 0 {
+		decl = append(decl, fmt.Sprintf("margin-bottom: %dpx", int(gap)+int(p.SpaceAfter)))
+	}
+	if p.LineHeight != 0 {
+		decl = append(decl, "line-height: "+strconv.FormatFloat(float64(p.LineHeight)/100, 'f', -1, 64))
+	}
+	if p.PageBreakBefore {
+		decl = append(decl, "break-before: page")
+	}
+	return strings.Join(decl, "; ")
+}
+
+// TableCSS returns the rules tables and their cells share, with tables
+// spaced like paragraphs.
+func TableCSS(gap uint16) string {
+	return fmt.Sprintf("table { border-collapse: collapse; margin: 0 0 %dpx 0; }\n", gap) +
+		fmt.Sprintf("td, th { padding: %dpt; vertical-align: top; text-align: left; font-weight: normal; white-space: pre-wrap; overflow-wrap: anywhere; }\n", convert.CellPaddingPt)
+}
+
+// CellCSS returns the declarations for a cell's borders and shading.
+func CellCSS(cell convert.TableCell) string {
+	var decl []string
+	sides := []struct {
+		border sqdoc.Border
+		name   string
+	}{{sqdoc.BorderTop, "top"}, {sqdoc.BorderRight, "right"}, {sqdoc.BorderBottom, "bottom"}, {sqdoc.BorderLeft, "left"}}
+	for _, side := range sides {
+		if cell.Borders&side.border != 0 {
+			decl = append(decl, fmt.Sprintf("border-%s: 1px solid %s", side.name, CSSColor(convert.TableBorderRGBA)))
+		}
+	}
+	if shade := cell.Shade(); shade != 0 {
+		decl = append(decl, "background-color: "+CSSColor(shade))
+	}
+	return strings.Join(decl, "; ")
+}
+
+func writeSpan(out *bytes.Buffer, s convert.Span, base sqdoc.StyleAttr, opts Options) {
+	style := SpanCSS(s.Attr, base)
+	if style != "" {
+		fmt.Fprintf(out, "<span style=\"%s\">", style)
+	}
+	if s.Image != nil {
+		writeImage(out, s.Image, opts)
+	} else {
+		out.WriteString(stdhtml.EscapeString(s.Text))
+	}
+	if style != "" {
+		out.WriteString("</span>")
+	}
+}
+
+// writeNoteRef writes an anchor as a superscript link to its note. The
+// first anchor is where the note links back to.
+func writeNoteRef(out *bytes.Buffer, n *convert.Note, first bool) {
+	id := ""
+	if first {
+		id = fmt.Sprintf(" id=\"noteref-%s\"", n.Number)
+	}
+	fmt.Fprintf(out, "<sup><a href=\"#note-%s\"%s>%s</a></sup>", n.Number, id, n.Number)
+}
+
+// writeNotes lists the notes after a rule, each numbered with a link back
+// to its anchor.
+func writeNotes(out *bytes.Buffer, notes []convert.Note, base sqdoc.StyleAttr, opts Options) {
+	if len(notes) == 0 {
+		return
+	}
+	out.WriteString("<hr>\n")
+	for _, n := range notes {
+		fmt.Fprintf(out, "<p id=\"note-%s\"><a href=\"#noteref-%s\">%s</a>. ", n.Number, n.Number, n.Number)
+		link := ""
+		for _, s := range n.Spans {
+			link = switchLink(out, link, s.Attr.Link)
+			writeSpan(out, s, base, opts)
+		}
+		switchLink(out, link, "")
+		out.WriteString("</p>\n")
+	}
+}
+
+// switchLink closes the <a> element for the link from and opens one for to
+// when they differ, and returns to.
+func switchLink(out *bytes.Buffer, from, to string) string {
+	if from == to {
+		return to
+	}
+	if from != "" {
+		out.WriteString("</a>")
+	}
+	if to != "" {
+		fmt.Fprintf(out, "<a href=\"%s\">", stdhtml.EscapeString(to))
+	}
+	return to
+}
+
+func writeImage(out *bytes.Buffer, img *sqdoc.ImageToken, opts Options) {
+	src := img.Path
+	if uri, err := DataURI(convert.ResolvePath(img.Path, opts.BaseDir)); err == nil {
+		src = uri
+	}
+	fmt.Fprintf(out, "<img src=\"%s\" alt=\"%s\"", stdhtml.EscapeString(src), stdhtml.EscapeString(filepath.Base(img.Path)))
+	if img.Width > 0 {
+		fmt.Fprintf(out, " width=\"%d\"", img.Width)
+	}
+	if img.Height > 0 {
+		fmt.Fprintf(out, " height=\"%d\"", img.Height)
+	}
+	out.WriteString(">")
+}
+
+// SpanCSS returns the declarations that differ from the body defaults.
+func SpanCSS(attr, base sqdoc.StyleAttr) string {
+	var decl []string
+	if attr.Bold {
+		decl = append(decl, "font-weight: bold")
+	}
+	if attr.Italic {
+		decl = append(decl, "font-style: italic")
+	}
+	if attr.Underline {
+		decl = append(decl, "text-decoration: underline")
+	}
+	if attr.Highlight {
+		decl = append(decl, "background-color: "+HighlightCSS)
+	}
+	if attr.FontFamily != base.FontFamily {
+		decl = append(decl, "font-family: "+FontStack(attr.FontFamily))
+	}
+	if attr.FontSizePt != 0 && attr.FontSizePt != base.FontSizePt {
+		decl = append(decl, fmt.Sprintf("font-size: %dpt", attr.FontSizePt))
+	}
+	if attr.ColorRGBA != base.ColorRGBA {
+		decl = append(decl, "color: "+CSSColor(attr.ColorRGBA))
+	}
+	return strings.Join(decl, "; ")
+}
+
+// FontStack names the bundled Liberation face first, then metric-compatible
+// system fonts.
+func FontStack(f sqdoc.FontFamily) string {
+	switch f {
+	case sqdoc.FontFamilySerif:
+		return "'Liberation Serif', 'Times New Roman', Times, serif"
+	case sqdoc.FontFamilyMonospace:
+		return "'Liberation Mono', 'Courier New', Courier, monospace"
+	default:
+		return "'Liberation Sans', Arial, Helvetica, sans-serif"
+	}
+}
+
+func CSSColor(rgba uint32) string {
+	r, g, b, a := rgba>>24, (rgba>>16)&0xFF, (rgba>>8)&0xFF, rgba&0xFF
+	if a == 0xFF {
+		return fmt.Sprintf("#%02x%02x%02x", r, g, b)
+	}
+	return fmt.Sprintf("rgba(%d, %d, %d, %.3g)", r, g, b, float64(a)/255)
+}
+
+func DataURI(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	mime := http.DetectContentType(data)
+	if strings.EqualFold(filepath.Ext(path), ".svg") {
+		mime = "image/svg+xml"
+	}
+	return "data:" + mime + ";base64," + base64.StdEncoding.EncodeToString(data), nil
+}
+
+func unixRFC3339(sec int64) string {
+	if sec == 0 {
+		return ""
+	}
+	return time.Unix(sec, 0).UTC().Format(time.RFC3339)
+}
@@ -0,0 +1,447 @@
+package html
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"sqdoc/pkg/convert"
+	"sqdoc/pkg/sqdoc"
+)
+
+func TestExportCarriesStylesMetadataAndSettings(t *testing.T) {
+	doc := sqdoc.NewDocument("Alex <a@b>", "Quarterly & Plans")
+	doc.Metadata.ParagraphGap = 12
+	doc.Metadata.PreferredFontFamily = sqdoc.FontFamilySerif
+
+	styled := convert.DefaultAttr()
+	styled.FontFamily = sqdoc.FontFamilySerif
+	styled.Bold = true
+	styled.Highlight = true
+	styled.FontSizePt = 18
+	styled.ColorRGBA = 0xA31515FF
+	var bb convert.BlockBuilder
+	bb.WriteString("a < b ", convert.DefaultAttr())
+	bb.WriteString("loud", styled)
+	doc.Blocks = append(doc.Blocks, sqdoc.Block{ID: 1, Kind: sqdoc.BlockKindText, Text: bb.TextBlock()})
+	doc.Blocks = append(doc.Blocks, sqdoc.Block{ID: 2, Kind: sqdoc.BlockKindText, Text: &sqdoc.TextBlock{}})
+
+	out, err := Export(doc, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := string(out)
+	for _, want := range []string{
+		"<title>Quarterly &amp; Plans</title>",
+		`<meta name="author" content="Alex &lt;a@b&gt;">`,
+		"font-family: 'Liberation Serif'",
+		"margin: 0 0 12px 0",
+		`font-family: 'Liberation Sans', Arial, Helvetica, sans-serif">a &lt; b </span>`,
+		`<span style="font-weight: bold; background-color: #fff4a8; font-size: 18pt; color: #a31515">loud</span>`,
+		"<p><br></p>",
+	} {
+		if !strings.Contains(s, want) {
+			t.Fatalf("export missing %q:\n%s", want, s)
+		}
+	}
+}
+
+func TestExportInlinesImagesAsDataURIs(t *testing.T) {
+	dir := t.TempDir()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 2, 2))); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "dot.png"), buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var bb convert.BlockBuilder
+	bb.WriteImage("dot.png", 40, 30, convert.DefaultAttr())
+	doc := sqdoc.NewDocument("", "Pics")
+	doc.Blocks = append(doc.Blocks, sqdoc.Block{ID: 1, Kind: sqdoc.BlockKindText, Text: bb.TextBlock()})
+
+	out, err := Export(doc, Options{BaseDir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(out), `<img src="data:image/png;base64,`) || !strings.Contains(string(out), `width="40" height="30"`) {
+		t.Fatalf("image not inlined:\n%s", out)
+	}
+}
+
+// chromeClipboard is trimmed from what Chrome puts on the clipboard when
+// copying part of a page.
+const chromeClipboard = `<meta charset='utf-8'><html lang="en-GB"><head><title>Release
+notes</title><style>p { color: red }</style><script>alert("x")</script></head><body>
+<!--StartFragment--><h2 style="color: rgb(32, 33, 36); font-family: Roboto, Arial, sans-serif;">What&#39;s new</h2>
+<p style="color: rgb(32, 33, 36); font-size: 16px;">Faster   <b>saves</b>, <em>smaller</em> files
+and <span style="background-color: rgb(255, 255, 0);">marked</span> <u>text</u>.<br>Second&nbsp;&nbsp;line</p>
+<b style="font-weight: normal;" id="docs-internal-guid-1"><span style="font-weight: 700; color: #a31515">docs bold</span></b>
+<p><br></p>
+<ul><li>one<li><p>two</p><ul><li>nested</li></ul></li></ul>
+<ol><li>first</li><li>second</li></ol>
+<table><tr><th>A</th><td>B</td></tr><tr><td>C<td>D</table>
+<pre><code>x :=  1
+y := 2</code></pre>
+<p>pic <img src="data:image/png;base64,iVBORw0KGgoAAAANSUhEUgAAAAEAAAABCAYAAAAfFcSJAAAADUlEQVR42mP8z8BQDwAEhQGAhKmMIQAAAABJRU5ErkJggg==" width="20" height="10"> <img src="https://example.org/a.png"> end<button>Click</button></p>
+<font color="#0000ff" size="5" face="Courier New">legacy</font><!--EndFragment--></body></html>`
+
+func TestImportBrowserClipboard(t *testing.T) {
+	mediaDir := t.TempDir()
+	doc, warnings, err := Import([]byte(chromeClipboard), Options{MediaDir: mediaDir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc.Metadata.Title != "Release notes" || doc.Metadata.Language != "en-GB" {
+		t.Fatalf("metadata = %+v", doc.Metadata)
+	}
+	var texts []string
+	for _, b := range doc.Blocks {
+		texts = append(texts, string(b.Text.UTF8))
+	}
+	if len(texts) != 14 {
+		t.Fatalf("texts = %q", texts)
+	}
+	imgs := sqdoc.ParseImageTokens(doc.Blocks[12].Text.UTF8)
+	if len(imgs) != 1 || imgs[0].Width != 20 || imgs[0].Height != 10 || filepath.Dir(imgs[0].Path) != mediaDir {
+		t.Fatalf("images = %+v", imgs)
+	}
+	texts[12] = texts[12][:imgs[0].Start] + "<img>" + texts[12][imgs[0].End:]
+	want := []string{
+		"What's new",
+		"Faster saves, smaller files and marked text.\nSecond  line",
+		"docs bold",
+		"",
+		"• one",
+		"• two",
+		"    • nested",
+		"1. first",
+		"2. second",
+		"A\tB",
+		"C\tD",
+		"x :=  1\ny := 2",
+		"pic <img> end",
+		"legacy",
+	}
+	for i := range want {
+		if texts[i] != want[i] {
+			t.Errorf("block %d = %q, want %q", i, texts[i], want[i])
+		}
+	}
+
+	if convert.HeadingLevel(doc.Blocks[0]) != 2 {
+		t.Errorf("heading = %+v", convert.Spans(doc.Blocks[0]))
+	}
+	attrOf := func(block int, text string) sqdoc.StyleAttr {
+		t.Helper()
+		for _, s := range convert.Spans(doc.Blocks[block]) {
+			if strings.Contains(s.Text, text) {
+				return s.Attr
+			}
+		}
+		t.Fatalf("no span %q in block %d", text, block)
+		return sqdoc.StyleAttr{}
+	}
+	body := attrOf(1, "Faster")
+	if body.FontSizePt != 12 || body.ColorRGBA != 0x202124FF || body.Bold {
+		t.Errorf("body = %+v", body)
+	}
+	if !attrOf(1, "saves").Bold || !attrOf(1, "smaller").Italic || !attrOf(1, "marked").Highlight || !attrOf(1, "text").Underline {
+		t.Errorf("inline formatting lost: %+v", convert.Spans(doc.Blocks[1]))
+	}
+	if a := attrOf(2, "docs"); !a.Bold || a.ColorRGBA != 0xA31515FF {
+		t.Errorf("docs span = %+v", a)
+	}
+	if a := attrOf(11, "x :="); a.FontFamily != sqdoc.FontFamilyMonospace {
+		t.Errorf("pre span = %+v", a)
+	}
+	if a := attrOf(13, "legacy"); a.FontFamily != sqdoc.FontFamilyMonospace || a.FontSizePt != 18 || a.ColorRGBA != 0x0000FFFF {
+		t.Errorf("font span = %+v", a)
+	}
+	joined := strings.Join(warnings, "\n")
+	for _, w := range []string{"tables", "remote images"} {
+		if !strings.Contains(joined, w) {
+			t.Errorf("missing %s warning in %q", w, warnings)
+		}
+	}
+}
+
+func TestExportImportRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 3, 2))); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "dot.png"), buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	fancy := convert.DefaultAttr()
+	fancy.Italic = true
+	fancy.Underline = true
+	fancy.Highlight = true
+	fancy.FontSizePt = 11
+	fancy.ColorRGBA = 0x1F4E79FF
+	fancy.FontFamily = sqdoc.FontFamilySerif
+	var bb convert.BlockBuilder
+	bb.WriteString("plain & <odd>\t", convert.DefaultAttr())
+	bb.WriteString("fancy", fancy)
+	bb.WriteString("\nnext line ", convert.DefaultAttr())
+	bb.WriteImage("dot.png", 30, 20, convert.DefaultAttr())
+	doc := sqdoc.NewDocument("Ana", "Round trip")
+	doc.Blocks = append(doc.Blocks,
+		sqdoc.Block{ID: 1, Kind: sqdoc.BlockKindText, Text: bb.TextBlock()},
+		sqdoc.Block{ID: 2, Kind: sqdoc.BlockKindText, Text: &sqdoc.TextBlock{}},
+	)
+	out, err := Export(doc, Options{BaseDir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	back, warnings, err := Import(out, Options{MediaDir: t.TempDir()})
+	if err != nil || len(warnings) != 0 {
+		t.Fatalf("import: %v %v", err, warnings)
+	}
+	if back.Metadata.Title != "Round trip" || back.Metadata.Author != "Ana" || len(back.Blocks) != 2 {
+		t.Fatalf("document = %+v", back)
+	}
+	got, want := convert.Spans(back.Blocks[0]), convert.Spans(doc.Blocks[0])
+	if len(got) != len(want) {
+		t.Fatalf("got %d spans, want %d: %+v", len(got), len(want), got)
+	}
+	for i := range want {
+		if want[i].Image != nil {
+			if img := got[i].Image; img == nil || img.Width != 30 || img.Height != 20 {
+				t.Fatalf("image span = %+v", got[i])
+			}
+			continue
+		}
+		if got[i].Text != want[i].Text || got[i].Attr != want[i].Attr {
+			t.Fatalf("span %d: got %q %+v want %q %+v", i, got[i].Text, got[i].Attr, want[i].Text, want[i].Attr)
+		}
+	}
+}
+
+func TestImportDecodesUTF16(t *testing.T) {
+	src := []byte{0xFF, 0xFE}
+	for _, r := range "<p>café</p>" {
+		src = append(src, byte(r), byte(r>>8))
+	}
+	doc, _, err := Import(src, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(doc.Blocks[0].Text.UTF8); got != "café" {
+		t.Fatalf("text = %q", got)
+	}
+}
+
+func TestLinksRoundTrip(t *testing.T) {
+	web := convert.DefaultAttr()
+	web.Link = "https://example.com/a?b=c&d=e"
+	internal := convert.DefaultAttr()
+	internal.Link = "#intro"
+	var bb convert.BlockBuilder
+	bb.WriteString("Intro", convert.HeadingAttr(1))
+	heading := bb.TextBlock()
+	heading.Style = sqdoc.StyleHeading1
+	bb = convert.BlockBuilder{}
+	bb.WriteString("See ", convert.DefaultAttr())
+	bb.WriteString("the site", web)
+	bb.WriteString(" or ", convert.DefaultAttr())
+	bb.WriteString("intro", internal)
+	doc := sqdoc.NewDocument("", "Links")
+	doc.Blocks = append(doc.Blocks,
+		sqdoc.Block{ID: 1, Kind: sqdoc.BlockKindText, Text: heading},
+		sqdoc.Block{ID: 2, Kind: sqdoc.BlockKindText, Text: bb.TextBlock()},
+	)
+
+	out, err := Export(doc, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(out, []byte(`<p id="intro">`)) || !bytes.Contains(out, []byte(`<a href="#intro">intro</a>`)) {
+		t.Fatalf("heading anchor or link missing:\n%s", out)
+	}
+	back, _, err := Import(out, Options{MediaDir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	links := map[string]string{}
+	for _, s := range convert.Spans(back.Blocks[1]) {
+		if s.Attr.Link != "" {
+			links[s.Text] = s.Attr.Link
+		}
+	}
+	if len(links) != 2 || links["the site"] != web.Link || links["intro"] != "#intro" {
+		t.Fatalf("links = %q", links)
+	}
+}
+
+func TestExportListsNotesAtTheEnd(t *testing.T) {
+	anchor := func(id uint64) sqdoc.StyleAttr {
+		attr := convert.DefaultAttr()
+		attr.Note = id
+		return attr
+	}
+	var bb convert.BlockBuilder
+	bb.WriteString("Claim", convert.DefaultAttr())
+	bb.WriteString("1", anchor(2))
+	bb.WriteString(" again", convert.DefaultAttr())
+	bb.WriteString("1", anchor(2))
+	bb.WriteString("i", anchor(3))
+	doc := sqdoc.NewDocument("", "")
+	doc.Blocks = append(doc.Blocks, sqdoc.Block{ID: 1, Kind: sqdoc.BlockKindText, Text: bb.TextBlock()})
+	bb.WriteString("Source.", convert.DefaultAttr())
+	doc.Blocks = append(doc.Blocks, sqdoc.Block{ID: 2, Kind: sqdoc.BlockKindNote, Text: bb.TextBlock()})
+	bb.WriteString("Later.", convert.DefaultAttr())
+	doc.Blocks = append(doc.Blocks, sqdoc.Block{ID: 3, Kind: sqdoc.BlockKindNote, Note: sqdoc.NoteEndnote, Text: bb.TextBlock()})
+
+	out, err := Export(doc, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := string(out)
+	for _, want := range []string{
+		`Claim<sup><a href="#note-1" id="noteref-1">1</a></sup>`,
+		` again<sup><a href="#note-1">1</a></sup><sup><a href="#note-i" id="noteref-i">i</a></sup>`,
+		`<p id="note-1"><a href="#noteref-1">1</a>. Source.</p>`,
+		`<p id="note-i"><a href="#noteref-i">i</a>. Later.</p>`,
+	} {
+		if !strings.Contains(s, want) {
+			t.Fatalf("export missing %q:\n%s", want, s)
+		}
+	}
+	if strings.Count(s, "Source.") != 1 {
+		t.Fatalf("note text written more than once:\n%s", s)
+	}
+}
+
+func TestExportWritesTables(t *testing.T) {
+	table := sqdoc.NewTable(3, 2)
+	table.HeaderRows = 1
+	table.Columns[0] = 90
+	for i, text := range []string{"Name", "Qty", "Apples", "3", "", "4"} {
+		var bb convert.BlockBuilder
+		bb.WriteString(text, convert.DefaultAttr())
+		table.Rows[i/2][i%2].Text = bb.TextBlock()
+	}
+	table.Rows[1][0].RowSpan = 2
+	table.Rows[1][1].Background = 0xFFF4C2FF
+	table.Rows[2][1].Borders = sqdoc.BorderTop
+	doc := sqdoc.NewDocument("", "")
+	doc.Blocks = append(doc.Blocks, sqdoc.Block{ID: 1, Kind: sqdoc.BlockKindTable, Table: table})
+
+	out, err := Export(doc, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := string(out)
+	for _, want := range []string{
+		"table { border-collapse: collapse;",
+		`<colgroup><col style="width: 90pt"><col></colgroup>`,
+		"<thead>\n<tr><th style=",
+		">Name</th>",
+		`<td rowspan="2" style=`,
+		"background-color: #fff4c2\">3</td>",
+		"<tr><td style=\"border-top: 1px solid #78869c\">4</td></tr>\n</tbody>",
+	} {
+		if !strings.Contains(s, want) {
+			t.Fatalf("export missing %q:\n%s", want, s)
+		}
+	}
+}
+
+func TestExportNestsLists(t *testing.T) {
+	doc := sqdoc.NewDocument("", "")
+	for i, item := range []sqdoc.ListItem{
+		{ID: 1, Style: sqdoc.ListDecimal},
+		{ID: 1, Level: 2, Style: sqdoc.ListLowerRoman},
+		{ID: 1, Style: sqdoc.ListDecimal},
+		{},
+		{ID: 1, Style: sqdoc.ListDecimal},
+		{ID: 1, Style: sqdoc.ListDecimal, Start: 9},
+		{ID: 2},
+	} {
+		var bb convert.BlockBuilder
+		bb.WriteString("p"+strconv.Itoa(i), convert.DefaultAttr())
+		tb := bb.TextBlock()
+		tb.List = item
+		doc.Blocks = append(doc.Blocks, sqdoc.Block{ID: uint64(i + 1), Kind: sqdoc.BlockKindText, Text: tb})
+	}
+
+	out, err := Export(doc, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A skipped level gets an empty item, and the list carries on its count
+	// after the paragraph that interrupts it.
+	want := "<ol>\n<li><p>p0</p>\n<ol type=\"i\">\n<li><ol type=\"i\">\n<li><p>p1</p>\n</li></ol>\n</li></ol>\n</li>\n<li><p>p2</p>\n</li></ol>\n" +
+		"<p>p3</p>\n<ol start=\"3\">\n<li><p>p4</p>\n</li>\n<li value=\"9\"><p>p5</p>\n</li></ol>\n<ul>\n<li><p>p6</p>\n</li></ul>\n</body>"
+	if !strings.Contains(string(out), want) {
+		t.Fatalf("export missing %q:\n%s", want, out)
+	}
+}
+
+func TestExportWritesCodeBlocks(t *testing.T) {
+	code := convert.DefaultAttr()
+	code.FontFamily = sqdoc.FontFamilyMonospace
+	doc := sqdoc.NewDocument("", "")
+	doc.Bookmarks = []sqdoc.Bookmark{{Name: "main", BlockID: 1}}
+	for i, lang := range []string{"go", ""} {
+		var bb convert.BlockBuilder
+		bb.WriteString("if a < b {\n\treturn\n}", code)
+		tb := bb.TextBlock()
+		tb.Code = &sqdoc.CodeBlock{Language: lang}
+		doc.Blocks = append(doc.Blocks, sqdoc.Block{ID: uint64(i + 1), Kind: sqdoc.BlockKindText, Text: tb})
+	}
+	out, err := Export(doc, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"<pre id=\"main\"><code class=\"language-go\">if a &lt; b {\n\treturn\n}</code></pre>\n",
+		"<pre><code>if a &lt; b {",
+		"pre { margin: 0 0 ",
+	} {
+		if !strings.Contains(string(out), want) {
+			t.Fatalf("export missing %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestExportWritesParagraphFormats(t *testing.T) {
+	doc := sqdoc.NewDocument("", "")
+	doc.Bookmarks = []sqdoc.Bookmark{{Name: "top", BlockID: 1}, {Name: "also", BlockID: 1}}
+	formats := []sqdoc.ParagraphFormat{
+		{Align: sqdoc.AlignCenter, IndentLeft: 36, IndentRight: 18, IndentFirst: -12, SpaceBefore: 6, SpaceAfter: 4, LineHeight: 150},
+		{Align: sqdoc.AlignJustify},
+		{},
+	}
+	for i, p := range formats {
+		var bb convert.BlockBuilder
+		bb.WriteString("text", convert.DefaultAttr())
+		tb := bb.TextBlock()
+		tb.Para = p
+		doc.Blocks = append(doc.Blocks, sqdoc.Block{ID: uint64(i + 1), Kind: sqdoc.BlockKindText, Text: tb})
+	}
+	doc.Blocks[2].Text.Code = &sqdoc.CodeBlock{}
+	doc.Blocks[2].Text.Para.Align = sqdoc.AlignRight
+	out, err := Export(doc, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`<p id="also" style="text-align: center; margin-left: 36pt; margin-right: 18pt; text-indent: -12pt; margin-top: 6px; margin-bottom: 12px; line-height: 1.5"><a id="top"></a>text</p>`,
+		`<p style="text-align: justify">text</p>`,
+		"<pre><code>text",
+	} {
+		if !strings.Contains(string(out), want) {
+			t.Fatalf("export missing %q:\n%s", want, out)
+		}
+	}
+}
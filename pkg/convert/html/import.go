@@ -0,0 +1,720 @@
+package html
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"io"
+	"math"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	xhtml "golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+
+	"sqdoc/pkg/convert"
+	"sqdoc/pkg/sqdoc"
+)
+
+// skippedElements are dropped with everything inside them: code, styling,
+// embedded documents and form controls have no place in a text document.
+var skippedElements = map[atom.Atom]bool{
+	atom.Script: true, atom.Style: true, atom.Noscript: true, atom.Template: true,
+	atom.Iframe: true, atom.Object: true, atom.Embed: true, atom.Svg: true,
+	atom.Math: true, atom.Select: true, atom.Textarea: true, atom.Button: true,
+	atom.Canvas: true, atom.Video: true, atom.Audio: true,
+}
+
+// blockElements end the current paragraph when they open or close.
+var blockElements = map[atom.Atom]bool{
+	atom.Html: true, atom.Body: true, atom.P: true, atom.Div: true,
+	atom.H1: true, atom.H2: true, atom.H3: true, atom.H4: true, atom.H5: true, atom.H6: true,
+	atom.Ul: true, atom.Ol: true, atom.Li: true, atom.Dl: true, atom.Dt: true, atom.Dd: true,
+	atom.Blockquote: true, atom.Pre: true, atom.Table: true, atom.Caption: true,
+	atom.Thead: true, atom.Tbody: true, atom.Tfoot: true, atom.Tr: true,
+	atom.Section: true, atom.Article: true, atom.Header: true, atom.Footer: true,
+	atom.Main: true, atom.Nav: true, atom.Aside: true, atom.Figure: true, atom.Figcaption: true,
+	atom.Address: true, atom.Hr: true, atom.Form: true, atom.Fieldset: true, atom.Legend: true,
+	atom.Details: true, atom.Summary: true, atom.Center: true,
+}
+
+// fontSizes maps the legacy <font size> scale onto points.
+var fontSizes = [...]uint16{8, 10, 12, 14, 18, 24, 36}
+
+// ImportFile reads an HTML file. Relative image paths resolve against the
+// file's folder unless opts.BaseDir is set.
+func ImportFile(path string, opts Options) (*sqdoc.Document, []string, error) {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if opts.BaseDir == "" {
+		opts.BaseDir = filepath.Dir(path)
+	}
+	if opts.MediaDir == "" {
+		opts.MediaDir = convert.DefaultMediaDir(path)
+	}
+	doc, warnings, err := Import(src, opts)
+	if err != nil {
+		return nil, warnings, err
+	}
+	if doc.Metadata.Title == "" {
+		doc.Metadata.Title = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	return doc, warnings, nil
+}
+
+// Import converts an HTML document or fragment, such as the HTML flavour a
+// browser puts on the clipboard. Markup is read with a tolerant tokenizer,
+// so unclosed and stray tags are accepted. Images in data URIs are written
+// to opts.MediaDir; local files are referenced where they are.
+func Import(src []byte, opts Options) (*sqdoc.Document, []string, error) {
+	im := &importer{opts: opts, builder: convert.NewDocBuilder(""), seen: map[string]bool{}}
+	im.stack = []frame{{attr: convert.DefaultAttr()}}
+	z := xhtml.NewTokenizer(strings.NewReader(decodeInput(src)))
+	for {
+		tt := z.Next()
+		if tt == xhtml.ErrorToken {
+			if err := z.Err(); err != io.EOF {
+				return nil, im.warnings, err
+			}
+			break
+		}
+		tok := z.Token()
+		switch tt {
+		case xhtml.TextToken:
+			im.text(tok.Data)
+		case xhtml.StartTagToken:
+			im.start(tok, false)
+		case xhtml.SelfClosingTagToken:
+			im.start(tok, true)
+		case xhtml.EndTagToken:
+			im.end(tok)
+		}
+	}
+	im.flush(false)
+	doc := im.builder.Document()
+	doc.Metadata.Title = strings.Join(strings.Fields(im.title.String()), " ")
+	doc.Metadata.Author = im.meta.Author
+	doc.Metadata.Language = im.meta.Language
+	return doc, im.warnings, nil
+}
+
+// frame is an open element and the formatting it gives its content.
+type frame struct {
+	name string
+	tag  atom.Atom
+	attr sqdoc.StyleAttr
+	pre  bool
+}
+
+type list struct {
+	ordered bool
+	n       int
+}
+
+type importer struct {
+	opts     Options
+	builder  *convert.DocBuilder
+	cur      convert.BlockBuilder
+	stack    []frame
+	lists    []list
+	meta     sqdoc.Metadata
+	warnings []string
+	seen     map[string]bool
+
+	skip      string // name of the element being skipped
+	skipDepth int
+	inTitle   bool
+	title     strings.Builder
+	// space records collapsed whitespace not yet written, so none is left
+	// at the end of a paragraph.
+	space bool
+	// marker is the length of a list marker at the start of cur; a
+	// paragraph holding only its marker is not finished by a nested <p>.
+	marker int
+	cells  int
+	images int
+
+	preParagraphs bool
+}
+
+func (im *importer) warn(msg string) {
+	if !im.seen[msg] {
+		im.seen[msg] = true
+		im.warnings = append(im.warnings, msg)
+	}
+}
+
+func (im *importer) top() *frame {
+	return &im.stack[len(im.stack)-1]
+}
+
+func (im *importer) text(s string) {
+	switch {
+	case im.skip != "":
+		return
+	case im.inTitle:
+		im.title.WriteString(s)
+		return
+	}
+	attr := im.top().attr
+	if im.top().pre {
+		s = strings.ReplaceAll(s, "\r\n", "\n")
+		if im.space {
+			im.cur.WriteString(" ", attr)
+			im.space = false
+		}
+		im.cur.WriteString(s, attr)
+		return
+	}
+	var b strings.Builder
+	for _, r := range s {
+		switch r {
+		case ' ', '\t', '\n', '\r', '\f':
+			im.space = true
+			continue
+		case '\u00a0':
+			// A non-breaking space is kept rather than collapsed.
+			r = ' '
+		}
+		if im.space {
+			im.space = false
+			if b.Len() > 0 || !im.atLineStart() {
+				b.WriteByte(' ')
+			}
+		}
+		b.WriteRune(r)
+	}
+	im.cur.WriteString(b.String(), attr)
+}
+
+// atLineStart reports whether cur has no text on its current line, so a
+// collapsed space there is dropped.
+func (im *importer) atLineStart() bool {
+	text := im.cur.Bytes()
+	return len(text) <= im.marker || text[len(text)-1] == '\n' || text[len(text)-1] == '\t'
+}
+
+// flush finishes the current paragraph. A paragraph holding only a <br>
+// stays as an empty line; one holding only a list marker is kept open
+// unless force is set.
+func (im *importer) flush(force bool) {
+	im.space = false
+	if im.cur.Len() == 0 || (!force && im.marker > 0 && im.cur.Len() <= im.marker) {
+		return
+	}
+	im.cur.TrimTrailingSpace()
+	tb := im.cur.TextBlock()
+	if n := len(tb.UTF8); n > 0 && tb.UTF8[n-1] == '\n' {
+		// Browsers do not show a line break that ends a paragraph, which
+		// makes <p><br></p> an empty line.
+		tb = trimLastByte(tb)
+	}
+	im.builder.Add(tb)
+	im.marker = 0
+}
+
+func trimLastByte(tb *sqdoc.TextBlock) *sqdoc.TextBlock {
+	n := uint32(len(tb.UTF8) - 1)
+	out := &sqdoc.TextBlock{UTF8: tb.UTF8[:n]}
+	for _, r := range tb.Runs {
+		r.End = min(r.End, n)
+		if r.Start < r.End {
+			out.Runs = append(out.Runs, r)
+		}
+	}
+	return out
+}
+
+func (im *importer) start(tok xhtml.Token, selfClosing bool) {
+	tag := tok.DataAtom
+	if im.skip != "" {
+		if tok.Data == im.skip && !selfClosing {
+			im.skipDepth++
+		}
+		return
+	}
+	if skippedElements[tag] {
+		if !selfClosing {
+			im.skip, im.skipDepth = tok.Data, 1
+		}
+		return
+	}
+	switch tag {
+	case atom.Title:
+		im.inTitle = !selfClosing
+		return
+	case atom.Html:
+		im.meta.Language = attrValue(tok, "lang")
+	case atom.Meta:
+		switch strings.ToLower(attrValue(tok, "name")) {
+		case "author":
+			im.meta.Author = attrValue(tok, "content")
+		case "generator":
+			// SIDE's export keeps paragraph whitespace through a
+			// stylesheet rule rather than inline styles.
+			im.preParagraphs = attrValue(tok, "content") == "SIDE"
+		}
+		return
+	case atom.Br:
+		im.space = false
+		im.cur.WriteString("\n", im.top().attr)
+		return
+	case atom.Img:
+		im.image(tok)
+		return
+	case atom.Hr:
+		im.flush(false)
+		return
+	case atom.P, atom.Li, atom.Dt, atom.Dd, atom.Tr, atom.Td, atom.Th:
+		im.closeImplied(tag)
+	}
+
+	if blockElements[tag] {
+		im.flush(false)
+	}
+	switch tag {
+	case atom.Ul, atom.Ol:
+		im.lists = append(im.lists, list{ordered: tag == atom.Ol})
+	case atom.Li:
+		im.listItem()
+	case atom.Tr:
+		im.cells = 0
+	case atom.Td, atom.Th:
+		im.warn("tables were flattened to tab-separated lines")
+		if im.cells > 0 {
+			im.cur.WriteString("\t", im.top().attr)
+		}
+		im.space = false
+		im.cells++
+	}
+	if selfClosing || voidElements[tag] {
+		return
+	}
+	parent := im.top()
+	f := frame{name: tok.Data, tag: tag, attr: parent.attr, pre: parent.pre}
+	applyElement(&f, tok)
+	if tag == atom.P && im.preParagraphs {
+		f.pre = true
+	}
+	if style := attrValue(tok, "style"); style != "" {
+		applyCSS(&f, parent.attr, style)
+	}
+	im.stack = append(im.stack, f)
+}
+
+// voidElements lists elements that never have content or an end tag.
+var voidElements = map[atom.Atom]bool{
+	atom.Area: true, atom.Base: true, atom.Col: true, atom.Input: true,
+	atom.Link: true, atom.Param: true, atom.Source: true, atom.Track: true, atom.Wbr: true,
+}
+
+// closeImplied closes an element that HTML lets authors leave open, such
+// as a <p> or <li> followed by another.
+func (im *importer) closeImplied(tag atom.Atom) {
+	same := func(t atom.Atom) bool {
+		switch tag {
+		case atom.Td, atom.Th:
+			return t == atom.Td || t == atom.Th
+		case atom.Dt, atom.Dd:
+			return t == atom.Dt || t == atom.Dd
+		}
+		return t == tag
+	}
+	for i := len(im.stack) - 1; i > 0; i-- {
+		t := im.stack[i].tag
+		if same(t) {
+			im.closeTo(i)
+			return
+		}
+		switch t {
+		case atom.Ul, atom.Ol, atom.Li, atom.Table, atom.Tr, atom.Td, atom.Th, atom.Div, atom.Blockquote, atom.Body:
+			return
+		}
+	}
+}
+
+func (im *importer) end(tok xhtml.Token) {
+	if im.skip != "" {
+		if tok.Data == im.skip {
+			if im.skipDepth--; im.skipDepth == 0 {
+				im.skip = ""
+			}
+		}
+		return
+	}
+	if tok.DataAtom == atom.Title {
+		im.inTitle = false
+		return
+	}
+	// Stray end tags are ignored.
+	for i := len(im.stack) - 1; i > 0; i-- {
+		if im.stack[i].name == tok.Data {
+			im.closeTo(i)
+			return
+		}
+	}
+}
+
+// closeTo pops the stack down to and including index i.
+func (im *importer) closeTo(i int) {
+	for len(im.stack) > i {
+		f := im.stack[len(im.stack)-1]
+		switch {
+		case f.tag == atom.Li || f.tag == atom.Tr:
+			im.flush(true)
+		case blockElements[f.tag]:
+			im.flush(false)
+		}
+		switch f.tag {
+		case atom.Ul, atom.Ol:
+			if len(im.lists) > 0 {
+				im.lists = im.lists[:len(im.lists)-1]
+			}
+		case atom.Pre:
+			im.space = false
+		}
+		im.stack = im.stack[:len(im.stack)-1]
+	}
+}
+
+// listItem starts a paragraph with the item's bullet or number, indented
+// by nesting depth.
+func (im *importer) listItem() {
+	if im.marker > 0 && im.cur.Len() <= im.marker {
+		im.cur.TextBlock()
+	}
+	marker := "• "
+	depth := 0
+	if n := len(im.lists); n > 0 {
+		l := &im.lists[n-1]
+		l.n++
+		depth = n - 1
+		if l.ordered {
+			marker = strconv.Itoa(l.n) + ". "
+		}
+	}
+	im.cur.WriteString(strings.Repeat("    ", depth)+marker, im.top().attr)
+	im.marker = im.cur.Len()
+	im.space = false
+}
+
+func attrValue(tok xhtml.Token, key string) string {
+	for _, a := range tok.Attr {
+		if a.Key == key {
+			return strings.TrimSpace(a.Val)
+		}
+	}
+	return ""
+}
+
+// applyElement sets the formatting an element implies by itself.
+func applyElement(f *frame, tok xhtml.Token) {
+	switch f.tag {
+	case atom.B, atom.Strong, atom.Th:
+		f.attr.Bold = true
+	case atom.I, atom.Em, atom.Cite, atom.Var, atom.Dfn:
+		f.attr.Italic = true
+	case atom.U, atom.Ins:
+		f.attr.Underline = true
+	case atom.A:
+		if href := convert.LinkTarget(attrValue(tok, "href")); href != "" {
+			f.attr.Link = href
+		}
+	case atom.Mark:
+		f.attr.Highlight = true
+	case atom.Code, atom.Kbd, atom.Samp, atom.Tt:
+		f.attr.FontFamily = sqdoc.FontFamilyMonospace
+	case atom.Pre:
+		f.attr.FontFamily = sqdoc.FontFamilyMonospace
+		f.pre = true
+	case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+		level := min(int(f.name[1]-'0'), len(convert.HeadingSizes))
+		family := f.attr.FontFamily
+		f.attr = convert.HeadingAttr(level)
+		f.attr.FontFamily = family
+	case atom.Font:
+		if c, ok := parseColor(attrValue(tok, "color")); ok && c&0xFF != 0 {
+			f.attr.ColorRGBA = c
+		}
+		if n, err := strconv.Atoi(attrValue(tok, "size")); err == nil {
+			f.attr.FontSizePt = fontSizes[min(max(n, 1), len(fontSizes))-1]
+		}
+		if face := attrValue(tok, "face"); face != "" {
+			f.attr.FontFamily = convert.FamilyForFont(face)
+		}
+	}
+}
+
+// applyCSS applies the inline style declarations SQDoc can represent.
+func applyCSS(f *frame, parent sqdoc.StyleAttr, style string) {
+	attr := &f.attr
+	for _, decl := range strings.Split(style, ";") {
+		prop, val, ok := strings.Cut(decl, ":")
+		if !ok {
+			continue
+		}
+		prop = strings.ToLower(strings.TrimSpace(prop))
+		val = strings.ToLower(strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(val), "!important")))
+		switch prop {
+		case "font-weight":
+			if n, err := strconv.Atoi(val); err == nil {
+				attr.Bold = n >= 600
+			} else if val == "bold" || val == "bolder" {
+				attr.Bold = true
+			} else if val == "normal" || val == "lighter" {
+				attr.Bold = false
+			}
+		case "font-style":
+			attr.Italic = val == "italic" || strings.HasPrefix(val, "oblique")
+		case "text-decoration", "text-decoration-line":
+			if strings.Contains(val, "underline") {
+				attr.Underline = true
+			} else if val == "none" {
+				attr.Underline = false
+			}
+		case "color":
+			if c, ok := parseColor(val); ok && c&0xFF != 0 {
+				attr.ColorRGBA = c
+			}
+		case "background-color", "background":
+			if c, ok := parseColor(val); ok {
+				attr.Highlight = c&0xFF != 0 && c|0xFF != 0xFFFFFFFF
+			}
+		case "font-size":
+			if pt, ok := parseFontSize(val, parent.FontSizePt); ok {
+				attr.FontSizePt = pt
+			}
+		case "font-family":
+			attr.FontFamily = convert.FamilyForFont(val)
+		case "white-space":
+			f.pre = strings.HasPrefix(val, "pre") && val != "pre-line" || val == "break-spaces"
+		}
+	}
+}
+
+// parseFontSize converts a CSS font size to whole points, resolving
+// relative units against the parent size.
+func parseFontSize(val string, parent uint16) (uint16, bool) {
+	keywords := map[string]float64{
+		"xx-small": 7, "x-small": 7.5, "small": 10, "medium": 12,
+		"large": 13.5, "x-large": 18, "xx-large": 24, "xxx-large": 36,
+	}
+	pt, ok := keywords[val]
+	if !ok {
+		units := []struct {
+			suffix string
+			pt     float64
+		}{{"pt", 1}, {"px", 0.75}, {"rem", 12}, {"em", float64(parent)}, {"%", float64(parent) / 100}}
+		for _, u := range units {
+			if num, found := strings.CutSuffix(val, u.suffix); found {
+				v, err := strconv.ParseFloat(strings.TrimSpace(num), 64)
+				if err != nil {
+					return 0, false
+				}
+				pt, ok = v*u.pt, true
+				break
+			}
+		}
+	}
+	if !ok {
+		return 0, false
+	}
+	return uint16(min(max(math.Round(pt), 6), 96)), true
+}
+
+var namedColors = map[string]uint32{
+	"black": 0x000000, "white": 0xFFFFFF, "red": 0xFF0000, "green": 0x008000,
+	"blue": 0x0000FF, "yellow": 0xFFFF00, "gray": 0x808080, "grey": 0x808080,
+	"silver": 0xC0C0C0, "maroon": 0x800000, "purple": 0x800080, "fuchsia": 0xFF00FF,
+	"magenta": 0xFF00FF, "lime": 0x00FF00, "olive": 0x808000, "navy": 0x000080,
+	"teal": 0x008080, "aqua": 0x00FFFF, "cyan": 0x00FFFF, "orange": 0xFFA500,
+}
+
+// parseColor reads a CSS colour as RGBA. Transparent has zero alpha.
+func parseColor(s string) (uint32, bool) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "transparent" {
+		return 0, true
+	}
+	if rgb, ok := namedColors[s]; ok {
+		return rgb<<8 | 0xFF, true
+	}
+	if hex, ok := strings.CutPrefix(s, "#"); ok {
+		if len(hex) == 3 || len(hex) == 4 {
+			var long strings.Builder
+			for _, c := range hex {
+				long.WriteRune(c)
+				long.WriteRune(c)
+			}
+			hex = long.String()
+		}
+		v, err := strconv.ParseUint(hex, 16, 32)
+		switch {
+		case err != nil:
+			return 0, false
+		case len(hex) == 6:
+			return uint32(v)<<8 | 0xFF, true
+		case len(hex) == 8:
+			return uint32(v), true
+		}
+		return 0, false
+	}
+	args, ok := strings.CutPrefix(s, "rgba(")
+	if !ok {
+		args, ok = strings.CutPrefix(s, "rgb(")
+	}
+	args, closed := strings.CutSuffix(args, ")")
+	if !ok || !closed {
+		return 0, false
+	}
+	parts := strings.FieldsFunc(args, func(r rune) bool { return r == ',' || r == '/' || unicode.IsSpace(r) })
+	if len(parts) != 3 && len(parts) != 4 {
+		return 0, false
+	}
+	var out uint32
+	for i, p := range parts {
+		num, pct := strings.CutSuffix(p, "%")
+		v, err := strconv.ParseFloat(num, 64)
+		if err != nil {
+			return 0, false
+		}
+		switch {
+		case pct:
+			v = v * 255 / 100
+		case i == 3:
+			v *= 255
+		}
+		out = out<<8 | uint32(min(max(math.Round(v), 0), 255))
+	}
+	if len(parts) == 3 {
+		out = out<<8 | 0xFF
+	}
+	return out, true
+}
+
+func (im *importer) image(tok xhtml.Token) {
+	src := attrValue(tok, "src")
+	w, _ := strconv.Atoi(strings.TrimSuffix(attrValue(tok, "width"), "px"))
+	h, _ := strconv.Atoi(strings.TrimSuffix(attrValue(tok, "height"), "px"))
+	var path string
+	switch {
+	case src == "":
+		return
+	case strings.HasPrefix(src, "data:"):
+		var err error
+		if path, err = im.saveDataURI(src); err != nil {
+			im.warn("images in unsupported formats were left out")
+			return
+		}
+	case strings.HasPrefix(src, "http://"), strings.HasPrefix(src, "https://"), strings.HasPrefix(src, "//"):
+		im.warn("remote images were left out")
+		return
+	default:
+		path = src
+		if u, err := url.Parse(src); err == nil && u.Scheme == "file" {
+			path = filepath.FromSlash(u.Path)
+		} else if !filepath.IsAbs(path) && im.opts.BaseDir != "" {
+			path = filepath.Join(im.opts.BaseDir, filepath.FromSlash(path))
+		}
+		if _, err := os.Stat(path); err != nil {
+			im.warn("missing image files were left out")
+			return
+		}
+	}
+	if im.space {
+		im.space = false
+		if !im.atLineStart() {
+			im.cur.WriteString(" ", im.top().attr)
+		}
+	}
+	im.cur.WriteImage(path, max(w, 0), max(h, 0), im.top().attr)
+}
+
+// saveDataURI writes the image in a data URI to MediaDir.
+func (im *importer) saveDataURI(uri string) (string, error) {
+	header, payload, ok := strings.Cut(strings.TrimPrefix(uri, "data:"), ",")
+	if !ok {
+		return "", fmt.Errorf("malformed data URI")
+	}
+	var data []byte
+	var err error
+	if strings.HasSuffix(header, ";base64") {
+		payload = strings.Map(func(r rune) rune {
+			if unicode.IsSpace(r) {
+				return -1
+			}
+			return r
+		}, payload)
+		data, err = base64.StdEncoding.DecodeString(payload)
+		if err != nil {
+			data, err = base64.RawStdEncoding.DecodeString(strings.TrimRight(payload, "="))
+		}
+	} else {
+		var s string
+		s, err = url.PathUnescape(payload)
+		data = []byte(s)
+	}
+	if err != nil {
+		return "", err
+	}
+	_, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	if im.opts.MediaDir == "" {
+		dir, err := os.MkdirTemp("", "sqdoc-html-")
+		if err != nil {
+			return "", err
+		}
+		im.opts.MediaDir = dir
+	}
+	if err := os.MkdirAll(im.opts.MediaDir, 0o755); err != nil {
+		return "", err
+	}
+	im.images++
+	f, err := os.CreateTemp(im.opts.MediaDir, fmt.Sprintf("image%d-*.%s", im.images, format))
+	if err != nil {
+		return "", err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+	return filepath.Abs(f.Name())
+}
+
+// decodeInput returns src as UTF-8. Firefox offers HTML on the X11
+// clipboard as UTF-16 with a byte order mark.
+func decodeInput(src []byte) string {
+	var order func([]byte) uint16
+	switch {
+	case bytes.HasPrefix(src, []byte{0xFF, 0xFE}):
+		order = func(b []byte) uint16 { return uint16(b[0]) | uint16(b[1])<<8 }
+	case bytes.HasPrefix(src, []byte{0xFE, 0xFF}):
+		order = func(b []byte) uint16 { return uint16(b[1]) | uint16(b[0])<<8 }
+	default:
+		s := string(bytes.TrimPrefix(src, []byte{0xEF, 0xBB, 0xBF}))
+		if !utf8.ValidString(s) {
+			s = strings.ToValidUTF8(s, "�")
+		}
+		return s
+	}
+	src = src[2:]
+	units := make([]uint16, 0, len(src)/2)
+	for i := 0; i+1 < len(src); i += 2 {
+		units = append(units, order(src[i:]))
+	}
+	return string(utf16.Decode(units))
+}
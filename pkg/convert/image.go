@@ -0,0 +1,91 @@
+package convert
+
+import (
+	"bytes"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/webp"
+)
+
+// EmbeddedImage is an image file read for embedding in another format.
+type EmbeddedImage struct {
+	Data []byte
+	// Format is "png", "jpeg" or "gif"; anything else is re-encoded as PNG
+	// since those three are the ones every consumer shows.
+	Format        string
+	Width, Height int
+}
+
+func (im *EmbeddedImage) MediaType() string {
+	return "image/" + im.Format
+}
+
+// LoadEmbeddedImage reads path, relative to baseDir unless absolute.
+func LoadEmbeddedImage(path, baseDir string) (*EmbeddedImage, error) {
+	data, err := os.ReadFile(ResolvePath(path, baseDir))
+	if err != nil {
+		return nil, err
+	}
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	switch format {
+	case "png", "jpeg", "gif":
+	default:
+		img, _, err := image.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, img); err != nil {
+			return nil, err
+		}
+		data, format = buf.Bytes(), "png"
+	}
+	return &EmbeddedImage{Data: data, Format: format, Width: cfg.Width, Height: cfg.Height}, nil
+}
+
+func ResolvePath(path, baseDir string) string {
+	if baseDir == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(baseDir, path)
+}
+
+// DefaultMediaDir is where importers reading path put the images they
+// extract unless told otherwise: a "<name>_media" folder beside the file.
+func DefaultMediaDir(path string) string {
+	return strings.TrimSuffix(path, filepath.Ext(path)) + "_media"
+}
+
+// SaveMedia writes an extracted image to name inside *dir and returns its
+// absolute path. An empty *dir is first set to a new temporary folder named
+// after prefix, so every image of one import lands in the same place.
+func SaveMedia(dir *string, prefix, name string, data []byte) (string, error) {
+	if *dir == "" {
+		tmp, err := os.MkdirTemp("", prefix)
+		if err != nil {
+			return "", err
+		}
+		*dir = tmp
+	}
+	if err := os.MkdirAll(*dir, 0o755); err != nil {
+		return "", err
+	}
+	dst, err := filepath.Abs(filepath.Join(*dir, name))
+	if err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(dst, data, 0o644); err != nil {
+		return "", err
+	}
+	return dst, nil
+}
@@ -0,0 +1,53 @@
+package convert
+
+import "sqdoc/pkg/sqdoc"
+
+// ListIndentPt is how far each list level indents its items, as in the
+// editor, in formats that lay lists out themselves.
+const ListIndentPt = 24
+
+// ListNesting follows the lists around consecutive blocks for writers whose
+// lists are nested elements. Levels an item skips are opened with its own
+// style.
+type ListNesting struct {
+	open []sqdoc.ListItem
+}
+
+// Next moves to the next block's list item, the zero value for blocks
+// outside a list. It returns the lists to close, innermost first, and those
+// to open, outermost first. A change of list or of style at a level closes
+// the list there.
+func (ln *ListNesting) Next(item sqdoc.ListItem) (closed, opened []sqdoc.ListItem) {
+	keep := 0
+	if item.ID != 0 {
+		level := min(int(item.Level), sqdoc.MaxListLevel)
+		for keep < len(ln.open) && keep <= level && ln.open[keep].ID == item.ID && (keep < level || ln.open[keep].Style == item.Style) {
+			keep++
+		}
+		for l := keep; l <= level; l++ {
+			opened = append(opened, sqdoc.ListItem{ID: item.ID, Level: uint8(l), Style: item.Style})
+		}
+	}
+	for i := len(ln.open) - 1; i >= keep; i-- {
+		closed = append(closed, ln.open[i])
+	}
+	ln.open = append(ln.open[:keep], opened...)
+	return closed, opened
+}
+
+// Depth is the number of lists open.
+func (ln *ListNesting) Depth() int {
+	return len(ln.open)
+}
+
+// MarkerAttr is the look of a list marker that an exporter writes as text:
+// that of the start of the item, without its link, note or emphasis lines.
+func MarkerAttr(spans []Span) sqdoc.StyleAttr {
+	attr := DefaultAttr()
+	if len(spans) > 0 {
+		attr = spans[0].Attr
+	}
+	attr.Underline, attr.Highlight = false, false
+	attr.Link, attr.Note, attr.Ref = "", 0, sqdoc.CrossRef{}
+	return attr
+}
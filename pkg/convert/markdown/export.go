@@ -0,0 +1,418 @@
+package markdown
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"sqdoc/pkg/convert"
+	htmlexport "sqdoc/pkg/convert/html"
+	"sqdoc/pkg/sqdoc"
+)
+
+type marker uint8
+
+// Markers nest in this order, outermost first, so a change to an inner
+// attribute never forces outer ones to close and reopen.
+const (
+	markBold marker = iota
+	markItalic
+	markUnderline
+	markHighlight
+	markCode
+)
+
+func Export(doc *sqdoc.Document, opts Options) ([]byte, error) {
+	if doc == nil {
+		return nil, errors.New("markdown: document is nil")
+	}
+	var out bytes.Buffer
+	notes := convert.NewNoteSet(doc)
+	numbers := doc.ListNumbers()
+	// content holds the column each open list level's text starts at, which
+	// is where items nested under it put their markers.
+	var content []int
+	for i, b := range doc.Blocks {
+		if b.Kind == sqdoc.BlockKindTable && b.Table != nil {
+			if out.Len() > 0 {
+				out.WriteString("\n\n")
+			}
+			content = content[:0]
+			writeTable(&out, b.Table, notes, opts)
+			continue
+		}
+		if b.Kind != sqdoc.BlockKindText || b.Text == nil || len(b.Text.UTF8) == 0 {
+			continue
+		}
+		item := b.Text.List
+		switch {
+		case out.Len() == 0:
+		case item.ID != 0 && len(content) > 0:
+			// Items follow each other directly so the list stays tight.
+			out.WriteString("\n")
+		default:
+			out.WriteString("\n\n")
+		}
+		// Markdown has no paragraph layout, so a formatted paragraph outside
+		// a list sits in an HTML block that carries it.
+		style := ""
+		if item.ID == 0 {
+			style = htmlexport.ParaCSS(convert.ParaFormat(b.Text), doc.Metadata.ParagraphGap)
+		}
+		if style != "" {
+			out.WriteString("<div style=\"" + style + "\">\n\n")
+		}
+		if b.Text.Code != nil {
+			content = content[:0]
+			writeCode(&out, b.Text)
+			if style != "" {
+				out.WriteString("\n\n</div>")
+			}
+			continue
+		}
+		if item.ID == 0 {
+			content = content[:0]
+		} else {
+			// Markdown cannot skip levels, so deeper items nest one down.
+			depth := min(int(item.Level), len(content))
+			indent := 0
+			if depth > 0 {
+				indent = content[depth-1]
+			}
+			mark := "-"
+			if item.Style.Numbered() {
+				mark = strconv.FormatUint(uint64(numbers[i]), 10) + "."
+			}
+			out.WriteString(strings.Repeat(" ", indent) + mark + " ")
+			content = append(content[:depth], indent+len(mark)+1)
+		}
+		level := convert.HeadingLevel(b)
+		if level > 0 {
+			out.WriteString(strings.Repeat("#", level) + " ")
+		}
+		w := &inlineWriter{opts: opts, out: &out, heading: level > 0, lineStart: true}
+		w.spans(convert.Spans(b), notes)
+		if style != "" {
+			out.WriteString("\n\n</div>")
+		}
+	}
+	// Notes become footnote definitions after the text; endnotes keep their
+	// own numbers among them.
+	for _, n := range notes.List {
+		var def bytes.Buffer
+		w := &inlineWriter{opts: opts, out: &def}
+		for _, span := range n.Spans {
+			w.span(span)
+		}
+		w.finish()
+		if out.Len() > 0 {
+			out.WriteString("\n\n")
+		}
+		out.WriteString("[^" + n.Number + "]: " + strings.ReplaceAll(def.String(), "\n", "\n    "))
+	}
+	if out.Len() > 0 {
+		out.WriteByte('\n')
+	}
+	return out.Bytes(), nil
+}
+
+// writeTable writes t as a pipe table. Pipe tables always have a header,
+// so the first row heads the table even when t marks none. Spans are
+// dropped and the cells they cover left empty.
+func writeTable(out *bytes.Buffer, t *sqdoc.TableBlock, notes *convert.NoteSet, opts Options) {
+	for r, row := range convert.TableRows(t) {
+		out.WriteString("|")
+		for _, cell := range row {
+			var text bytes.Buffer
+			if !cell.Covered {
+				w := &inlineWriter{opts: opts, out: &text}
+				w.spans(cell.Spans, notes)
+			}
+			out.WriteString(" " + strings.NewReplacer("|", "\\|", "\n", "<br>").Replace(text.String()) + " |")
+		}
+		if r == 0 {
+			out.WriteString("\n|" + strings.Repeat(" --- |", len(row)))
+		}
+		if r+1 < len(t.Rows) {
+			out.WriteString("\n")
+		}
+	}
+}
+
+type inlineWriter struct {
+	opts      Options
+	out       *bytes.Buffer
+	heading   bool
+	link      string
+	open      []marker
+	pendingWS string
+	lineStart bool
+}
+
+func (w *inlineWriter) span(s convert.Span) {
+	want := w.markersFor(s.Attr)
+	if s.Attr.Link != w.link {
+		// A link encloses its emphasis, so every marker closes with it.
+		w.closeLink()
+		if s.Attr.Link != "" {
+			w.flushWS()
+			w.out.WriteString("[")
+			w.link = s.Attr.Link
+			w.lineStart = false
+		}
+	}
+	w.closeTo(want)
+	if s.Image != nil {
+		w.flushWS()
+		w.openFrom(want)
+		w.out.WriteString("![](" + w.imageDest(s.Image.Path) + ")")
+		w.lineStart = false
+		return
+	}
+
+	body := s.Text
+	lead := body[:len(body)-len(strings.TrimLeft(body, " \t"))]
+	body = body[len(lead):]
+	trail := body[len(strings.TrimRight(body, " \t")):]
+	body = body[:len(body)-len(trail)]
+
+	if body == "" {
+		// Whitespace-only spans stay outside any newly opened markers.
+		w.pendingWS += lead + trail
+		return
+	}
+	if len(w.open) < len(want) {
+		w.pendingWS += lead
+		w.flushWS()
+		w.openFrom(want)
+	} else {
+		w.flushWS()
+		w.out.WriteString(lead)
+	}
+	if len(want) > 0 && want[len(want)-1] == markCode {
+		w.out.WriteString(codeText(body))
+	} else {
+		w.out.WriteString(w.escape(body))
+	}
+	w.lineStart = false
+	w.pendingWS = trail
+}
+
+// spans writes a paragraph or cell with its note references and finishes
+// it.
+func (w *inlineWriter) spans(spans []convert.Span, notes *convert.NoteSet) {
+	for _, span := range spans {
+		if n, _ := notes.Anchor(span); n != nil {
+			w.noteRef(n.Number)
+			continue
+		}
+		w.span(span)
+	}
+	w.finish()
+}
+
+// noteRef writes a footnote reference, outside any link or emphasis.
+func (w *inlineWriter) noteRef(label string) {
+	w.closeLink()
+	w.flushWS()
+	w.out.WriteString("[^" + label + "]")
+	w.lineStart = false
+}
+
+func (w *inlineWriter) finish() {
+	w.closeLink()
+	w.pendingWS = ""
+}
+
+func (w *inlineWriter) closeLink() {
+	w.closeTo(nil)
+	if w.link != "" {
+		w.out.WriteString("](" + linkDest(w.link) + ")")
+		w.link = ""
+	}
+}
+
+func (w *inlineWriter) markersFor(attr sqdoc.StyleAttr) []marker {
+	var m []marker
+	if attr.Bold && !w.heading {
+		m = append(m, markBold)
+	}
+	if attr.Italic {
+		m = append(m, markItalic)
+	}
+	if attr.Underline && w.opts.Underline != SyntaxNone {
+		m = append(m, markUnderline)
+	}
+	if attr.Highlight && w.opts.Highlight != SyntaxNone {
+		m = append(m, markHighlight)
+	}
+	if attr.FontFamily == sqdoc.FontFamilyMonospace {
+		m = append(m, markCode)
+	}
+	return m
+}
+
+// closeTo closes open markers until the stack is a prefix of want. Closing
+// markers go before any pending whitespace so emphasis stays right-flanking.
+func (w *inlineWriter) closeTo(want []marker) {
+	keep := 0
+	for keep < len(w.open) && keep < len(want) && w.open[keep] == want[keep] {
+		keep++
+	}
+	for len(w.open) > keep {
+		m := w.open[len(w.open)-1]
+		w.open = w.open[:len(w.open)-1]
+		if m == markCode {
+			// Code spans were written whole; nothing to close.
+			continue
+		}
+		w.out.WriteString(w.closeMarker(m))
+	}
+}
+
+func (w *inlineWriter) openFrom(want []marker) {
+	for _, m := range want[len(w.open):] {
+		w.open = append(w.open, m)
+		if m != markCode {
+			w.out.WriteString(w.openMarker(m))
+		}
+	}
+}
+
+func (w *inlineWriter) flushWS() {
+	w.out.WriteString(w.pendingWS)
+	w.pendingWS = ""
+}
+
+func (w *inlineWriter) openMarker(m marker) string {
+	switch m {
+	case markBold:
+		return "**"
+	case markItalic:
+		return "*"
+	case markUnderline:
+		if w.opts.Underline == SyntaxMarker {
+			return "++"
+		}
+		return "<u>"
+	case markHighlight:
+		if w.opts.Highlight == SyntaxMarker {
+			return "=="
+		}
+		return "<mark>"
+	}
+	return ""
+}
+
+func (w *inlineWriter) closeMarker(m marker) string {
+	switch m {
+	case markUnderline:
+		if w.opts.Underline == SyntaxMarker {
+			return "++"
+		}
+		return "</u>"
+	case markHighlight:
+		if w.opts.Highlight == SyntaxMarker {
+			return "=="
+		}
+		return "</mark>"
+	}
+	return w.openMarker(m)
+}
+
+func (w *inlineWriter) escape(s string) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch c {
+		case '\\', '*', '_', '`', '[', ']', '<':
+			sb.WriteByte('\\')
+		case '&':
+			if i+1 < len(s) && (s[i+1] == '#' || isLetter(s[i+1])) {
+				sb.WriteByte('\\')
+			}
+		case '#', '-', '+', '>':
+			if w.lineStart && i == 0 {
+				sb.WriteByte('\\')
+			} else if c == '+' && w.opts.Underline == SyntaxMarker && i+1 < len(s) && s[i+1] == '+' {
+				sb.WriteByte('\\')
+			}
+		case '=':
+			if w.opts.Highlight == SyntaxMarker && i+1 < len(s) && s[i+1] == '=' {
+				sb.WriteByte('\\')
+			}
+		case '.', ')':
+			if w.lineStart && i > 0 && isDigits(s[:i]) {
+				sb.WriteByte('\\')
+			}
+		}
+		sb.WriteByte(c)
+	}
+	return sb.String()
+}
+
+func isLetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
+
+func codeText(s string) string {
+	fence := "`"
+	for strings.Contains(s, fence) {
+		fence += "`"
+	}
+	if strings.HasPrefix(s, "`") || strings.HasSuffix(s, "`") {
+		return fence + " " + s + " " + fence
+	}
+	return fence + s + fence
+}
+
+// linkDest writes a link target in angle brackets when it would otherwise
+// end the destination early.
+func linkDest(target string) string {
+	if strings.ContainsAny(target, " ()<>") {
+		return "<" + strings.NewReplacer("<", "%3C", ">", "%3E").Replace(target) + ">"
+	}
+	return target
+}
+
+func (w *inlineWriter) imageDest(path string) string {
+	if w.opts.BaseDir != "" && filepath.IsAbs(path) {
+		if rel, err := filepath.Rel(w.opts.BaseDir, path); err == nil && !strings.HasPrefix(rel, "..") {
+			path = rel
+		}
+	}
+	path = filepath.ToSlash(path)
+	if strings.ContainsAny(path, " ()<>") {
+		return "<" + strings.NewReplacer("<", "%3C", ">", "%3E").Replace(path) + ">"
+	}
+	return path
+}
+
+// writeCode writes a code block as a fenced block tagged with its language.
+// The fence is longer than any run of backticks in the code.
+func writeCode(out *bytes.Buffer, tb *sqdoc.TextBlock) {
+	longest, run := 0, 0
+	for _, c := range tb.UTF8 {
+		if c == '`' {
+			run++
+			longest = max(longest, run)
+		} else {
+			run = 0
+		}
+	}
+	fence := strings.Repeat("`", max(3, longest+1))
+	out.WriteString(fence + tb.Code.Language + "\n")
+	out.Write(tb.UTF8)
+	out.WriteString("\n" + fence)
+}
@@ -0,0 +1,285 @@
+package markdown
+
+import (
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"sqdoc/pkg/convert"
+	"sqdoc/pkg/sqdoc"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+func Import(src []byte, opts Options) (*sqdoc.Document, error) {
+	var inline []util.PrioritizedValue
+	if opts.Underline == SyntaxMarker {
+		inline = append(inline, util.Prioritized(&markParser{char: '+', underline: true}, 500))
+	}
+	if opts.Highlight == SyntaxMarker {
+		inline = append(inline, util.Prioritized(&markParser{char: '='}, 500))
+	}
+	md := goldmark.New(goldmark.WithParserOptions(parser.WithInlineParsers(inline...)))
+	root := md.Parser().Parse(text.NewReader(src))
+
+	im := &importer{src: src, opts: opts, out: convert.NewDocBuilder("")}
+	if err := im.blocks(root, 0); err != nil {
+		return nil, err
+	}
+	doc := im.out.Document()
+	doc.Metadata.Title = im.title
+	return doc, nil
+}
+
+type importer struct {
+	src   []byte
+	opts  Options
+	out   *convert.DocBuilder
+	cur   convert.BlockBuilder
+	title string
+	// lists counts the lists imported so far, giving each its ID. item is
+	// the list item the next paragraph starts.
+	lists uint32
+	item  sqdoc.ListItem
+
+	underline int
+	highlight int
+}
+
+func (im *importer) flush() {
+	im.cur.TrimTrailingSpace()
+	tb := im.cur.TextBlock()
+	tb.List = im.item
+	im.item = sqdoc.ListItem{}
+	im.out.Add(tb)
+}
+
+func (im *importer) blocks(parent ast.Node, depth int) error {
+	for n := parent.FirstChild(); n != nil; n = n.NextSibling() {
+		if err := im.block(n, depth); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (im *importer) block(n ast.Node, depth int) error {
+	switch n := n.(type) {
+	case *ast.Paragraph, *ast.TextBlock:
+		im.inlines(n, convert.DefaultAttr())
+		im.flush()
+	case *ast.Heading:
+		attr := convert.HeadingAttr(n.Level)
+		start := im.cur.Len()
+		im.inlines(n, attr)
+		if im.title == "" && n.Level == 1 {
+			im.title = strings.TrimSpace(string(im.cur.Bytes()[start:]))
+		}
+		im.flush()
+	case *ast.Blockquote:
+		return im.blocks(n, depth)
+	case *ast.List:
+		// Nested lists continue the list they sit in one level down; only
+		// the first paragraph of an item is part of the list.
+		if depth == 0 {
+			im.lists++
+		}
+		style := sqdoc.ListBullet
+		if n.IsOrdered() {
+			style = sqdoc.ListDecimal
+		}
+		for item := n.FirstChild(); item != nil; item = item.NextSibling() {
+			im.item = sqdoc.ListItem{ID: im.lists, Level: uint8(min(depth, sqdoc.MaxListLevel)), Style: style}
+			if n.IsOrdered() && item == n.FirstChild() && n.Start != 1 {
+				im.item.Start = uint32(n.Start)
+			}
+			if item.FirstChild() == nil {
+				im.flush()
+				continue
+			}
+			if err := im.blocks(item, depth+1); err != nil {
+				return err
+			}
+		}
+		im.item = sqdoc.ListItem{}
+	case *ast.FencedCodeBlock:
+		im.code(n, string(n.Language(im.src)))
+	case *ast.CodeBlock:
+		im.code(n, "")
+	case *ast.ThematicBreak, *ast.HTMLBlock:
+		// No SQDoc equivalent yet.
+	default:
+		return im.blocks(n, depth)
+	}
+	return nil
+}
+
+// code adds the lines of a code block as one code block. A language tag
+// SQDoc cannot store is dropped. Code is never a list item, so an item the
+// code opens goes to the paragraph after it.
+func (im *importer) code(n ast.Node, language string) {
+	if len(language) > sqdoc.MaxCodeLanguage || !utf8.ValidString(language) {
+		language = ""
+	}
+	attr := convert.DefaultAttr()
+	attr.FontFamily = sqdoc.FontFamilyMonospace
+	lines := n.Lines()
+	for i := 0; i < lines.Len(); i++ {
+		if i > 0 {
+			im.cur.WriteString("\n", attr)
+		}
+		seg := lines.At(i)
+		im.cur.WriteString(strings.TrimRight(string(seg.Value(im.src)), "\r\n"), attr)
+	}
+	tb := im.cur.TextBlock()
+	tb.Code = &sqdoc.CodeBlock{Language: language}
+	im.out.Add(tb)
+}
+
+func (im *importer) inlines(parent ast.Node, attr sqdoc.StyleAttr) {
+	for n := parent.FirstChild(); n != nil; n = n.NextSibling() {
+		im.inline(n, attr)
+	}
+}
+
+func (im *importer) inline(n ast.Node, attr sqdoc.StyleAttr) {
+	if im.underline > 0 {
+		attr.Underline = true
+	}
+	if im.highlight > 0 {
+		attr.Highlight = true
+	}
+	switch n := n.(type) {
+	case *ast.Text:
+		v := n.Segment.Value(im.src)
+		if !n.IsRaw() {
+			v = util.ResolveEntityNames(util.ResolveNumericReferences(util.UnescapePunctuations(v)))
+		}
+		im.cur.WriteString(string(v), attr)
+		if n.HardLineBreak() {
+			im.flush()
+		} else if n.SoftLineBreak() {
+			im.cur.WriteString(" ", attr)
+		}
+	case *ast.String:
+		im.cur.WriteString(string(n.Value), attr)
+	case *ast.Emphasis:
+		if n.Level >= 2 {
+			attr.Bold = true
+		} else {
+			attr.Italic = true
+		}
+		im.inlines(n, attr)
+	case *ast.CodeSpan:
+		attr.FontFamily = sqdoc.FontFamilyMonospace
+		im.inlines(n, attr)
+	case *ast.Image:
+		im.cur.WriteImage(im.resolveImage(string(n.Destination)), 0, 0, attr)
+	case *ast.Link:
+		attr.Link = convert.LinkTarget(string(n.Destination))
+		im.inlines(n, attr)
+	case *ast.AutoLink:
+		attr.Link = convert.LinkTarget(string(n.URL(im.src)))
+		if n.AutoLinkType == ast.AutoLinkEmail && attr.Link != "" && !strings.HasPrefix(strings.ToLower(attr.Link), "mailto:") {
+			attr.Link = "mailto:" + attr.Link
+		}
+		im.cur.WriteString(string(n.Label(im.src)), attr)
+	case *ast.RawHTML:
+		im.rawHTML(n)
+	case *markNode:
+		if n.underline {
+			attr.Underline = true
+		} else {
+			attr.Highlight = true
+		}
+		im.inlines(n, attr)
+	default:
+		im.inlines(n, attr)
+	}
+}
+
+func (im *importer) rawHTML(n *ast.RawHTML) {
+	var sb strings.Builder
+	for i := 0; i < n.Segments.Len(); i++ {
+		seg := n.Segments.At(i)
+		sb.Write(seg.Value(im.src))
+	}
+	tag := strings.ToLower(strings.TrimSpace(sb.String()))
+	switch {
+	case tag == "<br>" || tag == "<br/>" || tag == "<br />":
+		im.flush()
+	case im.opts.Underline == SyntaxHTML && tag == "<u>":
+		im.underline++
+	case im.opts.Underline == SyntaxHTML && tag == "</u>" && im.underline > 0:
+		im.underline--
+	case im.opts.Highlight == SyntaxHTML && tag == "<mark>":
+		im.highlight++
+	case im.opts.Highlight == SyntaxHTML && tag == "</mark>" && im.highlight > 0:
+		im.highlight--
+	}
+}
+
+func (im *importer) resolveImage(dest string) string {
+	if dest == "" || strings.Contains(dest, "://") || filepath.IsAbs(dest) || im.opts.BaseDir == "" {
+		return dest
+	}
+	return filepath.Join(im.opts.BaseDir, filepath.FromSlash(dest))
+}
+
+var kindMark = ast.NewNodeKind("SQDocMark")
+
+// markNode is an ++underline++ or ==highlight== span.
+type markNode struct {
+	ast.BaseInline
+	underline bool
+}
+
+func (n *markNode) Kind() ast.NodeKind {
+	return kindMark
+}
+
+func (n *markNode) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, nil, nil)
+}
+
+// markParser recognises doubled delimiters the same way goldmark's
+// strikethrough extension handles ~~.
+type markParser struct {
+	char      byte
+	underline bool
+}
+
+func (p *markParser) Trigger() []byte {
+	return []byte{p.char}
+}
+
+func (p *markParser) Parse(parent ast.Node, block text.Reader, pc parser.Context) ast.Node {
+	before := block.PrecendingCharacter()
+	line, segment := block.PeekLine()
+	node := parser.ScanDelimiter(line, before, 2, p)
+	if node == nil || node.OriginalLength != 2 || before == rune(p.char) {
+		return nil
+	}
+	node.Segment = segment.WithStop(segment.Start + node.OriginalLength)
+	block.Advance(node.OriginalLength)
+	pc.PushDelimiter(node)
+	return node
+}
+
+func (p *markParser) CloseBlock(parent ast.Node, pc parser.Context) {}
+
+func (p *markParser) IsDelimiter(b byte) bool {
+	return b == p.char
+}
+
+func (p *markParser) CanOpenCloser(opener, closer *parser.Delimiter) bool {
+	return opener.Char == closer.Char
+}
+
+func (p *markParser) OnMatch(consumes int) ast.Node {
+	return &markNode{underline: p.underline}
+}
@@ -0,0 +1,70 @@
+// Package markdown converts between CommonMark and SQDoc documents.
+//
+// Paragraphs, headings and list items become text blocks, emphasis and strong text
+// become italic and bold runs, code spans use the monospace family and
+// images become inline image tokens. Markdown has no underline or
+// highlight, so their syntax is chosen through Options. Nor does it have
+// paragraph layout: a paragraph with an alignment, indents, spacing or line
+// height on export sits in a <div> whose style carries them, and import
+// skips such HTML blocks and keeps their text.
+package markdown
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"sqdoc/pkg/sqdoc"
+)
+
+type Syntax uint8
+
+const (
+	// SyntaxHTML uses inline <u> and <mark> tags, which CommonMark passes through.
+	SyntaxHTML Syntax = iota
+	// SyntaxMarker uses the ++underline++ and ==highlight== extensions.
+	SyntaxMarker
+	// SyntaxNone drops the attribute on export and ignores it on import.
+	SyntaxNone
+)
+
+type Options struct {
+	Underline Syntax
+	Highlight Syntax
+	// BaseDir resolves relative image paths on import. On export, images
+	// under BaseDir are written relative to it.
+	BaseDir string
+}
+
+func ImportFile(path string, opts Options) (*sqdoc.Document, error) {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if opts.BaseDir == "" {
+		opts.BaseDir = filepath.Dir(path)
+	}
+	doc, err := Import(src, opts)
+	if err != nil {
+		return nil, err
+	}
+	if doc.Metadata.Title == "" {
+		doc.Metadata.Title = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	return doc, nil
+}
+
+func ExportFile(path string, doc *sqdoc.Document, opts Options) error {
+	if opts.BaseDir == "" {
+		opts.BaseDir = filepath.Dir(path)
+	}
+	out, err := Export(doc, opts)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, out, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
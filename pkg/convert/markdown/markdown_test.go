@@ -0,0 +1,319 @@
+package markdown
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"sqdoc/pkg/convert"
+	"sqdoc/pkg/sqdoc"
+)
+
+func styledBlock(id uint64, parts ...any) sqdoc.Block {
+	var bb convert.BlockBuilder
+	for i := 0; i+1 < len(parts); i += 2 {
+		bb.WriteString(parts[i].(string), parts[i+1].(sqdoc.StyleAttr))
+	}
+	return sqdoc.Block{ID: id, Kind: sqdoc.BlockKindText, Text: bb.TextBlock()}
+}
+
+func attrWith(mut func(*sqdoc.StyleAttr)) sqdoc.StyleAttr {
+	a := convert.DefaultAttr()
+	mut(&a)
+	return a
+}
+
+func runTexts(b sqdoc.Block, pick func(sqdoc.StyleAttr) bool) []string {
+	var out []string
+	for _, r := range b.Text.Runs {
+		if pick(r.Attr) {
+			out = append(out, string(b.Text.UTF8[r.Start:r.End]))
+		}
+	}
+	return out
+}
+
+func TestBoldItalicSurviveRoundTrip(t *testing.T) {
+	bold := attrWith(func(a *sqdoc.StyleAttr) { a.Bold = true })
+	italic := attrWith(func(a *sqdoc.StyleAttr) { a.Italic = true })
+	both := attrWith(func(a *sqdoc.StyleAttr) { a.Bold = true; a.Italic = true })
+	plain := convert.DefaultAttr()
+
+	doc := sqdoc.NewDocument("", "Notes")
+	doc.Blocks = append(doc.Blocks,
+		styledBlock(1, "Plain ", plain, "bold ", bold, "both", both, " italic", italic, " end", plain),
+		styledBlock(2, "Second paragraph with a*star and snake_case", plain),
+	)
+
+	out, err := Export(doc, Options{})
+	if err != nil {
+		t.Fatalf("export failed: %v", err)
+	}
+	back, err := Import(out, Options{})
+	if err != nil {
+		t.Fatalf("import failed: %v", err)
+	}
+	if len(back.Blocks) != 2 {
+		t.Fatalf("expected 2 blocks, got %d from %q", len(back.Blocks), out)
+	}
+	if got := string(back.Blocks[0].Text.UTF8); got != "Plain bold both italic end" {
+		t.Fatalf("text = %q (markdown %q)", got, out)
+	}
+	if got := strings.Join(runTexts(back.Blocks[0], func(a sqdoc.StyleAttr) bool { return a.Bold }), "|"); got != "bold |both" {
+		t.Fatalf("bold runs = %q (markdown %q)", got, out)
+	}
+	if got := strings.Join(runTexts(back.Blocks[0], func(a sqdoc.StyleAttr) bool { return a.Italic }), "|"); got != "both|italic" {
+		t.Fatalf("italic runs = %q (markdown %q)", got, out)
+	}
+	if got := string(back.Blocks[1].Text.UTF8); got != "Second paragraph with a*star and snake_case" {
+		t.Fatalf("escaped text did not survive: %q (markdown %q)", got, out)
+	}
+}
+
+func TestUnderlineHighlightSyntaxIsConfigurable(t *testing.T) {
+	under := attrWith(func(a *sqdoc.StyleAttr) { a.Underline = true })
+	mark := attrWith(func(a *sqdoc.StyleAttr) { a.Highlight = true })
+	doc := sqdoc.NewDocument("", "")
+	doc.Blocks = append(doc.Blocks, styledBlock(1, "a ", convert.DefaultAttr(), "under", under, " b ", convert.DefaultAttr(), "mark", mark))
+
+	cases := []struct {
+		opts Options
+		want string
+	}{
+		{Options{}, "a <u>under</u> b <mark>mark</mark>\n"},
+		{Options{Underline: SyntaxMarker, Highlight: SyntaxMarker}, "a ++under++ b ==mark==\n"},
+		{Options{Underline: SyntaxNone, Highlight: SyntaxNone}, "a under b mark\n"},
+	}
+	for _, tc := range cases {
+		out, err := Export(doc, tc.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(out) != tc.want {
+			t.Fatalf("export %+v = %q, want %q", tc.opts, out, tc.want)
+		}
+		if tc.opts.Underline == SyntaxNone {
+			continue
+		}
+		back, err := Import(out, tc.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b := back.Blocks[0]
+		if got := runTexts(b, func(a sqdoc.StyleAttr) bool { return a.Underline }); len(got) != 1 || got[0] != "under" {
+			t.Fatalf("underline runs after import %+v = %q", tc.opts, got)
+		}
+		if got := runTexts(b, func(a sqdoc.StyleAttr) bool { return a.Highlight }); len(got) != 1 || got[0] != "mark" {
+			t.Fatalf("highlight runs after import %+v = %q", tc.opts, got)
+		}
+	}
+}
+
+func TestImportHeadingsCodeAndImages(t *testing.T) {
+	src := "# Title\n\nSee `code` and ![pic](img/a.png).\n\n- one\n- two\n"
+	base := filepath.FromSlash("/docs")
+	doc, err := Import([]byte(src), Options{BaseDir: base})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc.Metadata.Title != "Title" {
+		t.Fatalf("title = %q", doc.Metadata.Title)
+	}
+	if convert.HeadingLevel(doc.Blocks[0]) != 1 {
+		t.Fatalf("first block should be a level 1 heading: %#v", doc.Blocks[0].Text.Runs)
+	}
+	if got := runTexts(doc.Blocks[1], func(a sqdoc.StyleAttr) bool { return a.FontFamily == sqdoc.FontFamilyMonospace }); len(got) != 1 || got[0] != "code" {
+		t.Fatalf("code span runs = %q", got)
+	}
+	imgs := sqdoc.ParseImageTokens(doc.Blocks[1].Text.UTF8)
+	if len(imgs) != 1 || imgs[0].Path != filepath.Join(base, "img", "a.png") {
+		t.Fatalf("image tokens = %#v", imgs)
+	}
+	if got := doc.Blocks[3]; string(got.Text.UTF8) != "two" || got.Text.List != (sqdoc.ListItem{ID: 1}) {
+		t.Fatalf("list item = %q %+v", got.Text.UTF8, got.Text.List)
+	}
+
+	out, err := Export(doc, Options{BaseDir: base})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(out), "# Title\n\nSee `code` and ![](img/a.png).") {
+		t.Fatalf("unexpected export: %q", out)
+	}
+}
+
+func TestListsRoundTrip(t *testing.T) {
+	src := "- one\n  1. first\n  2. second\n- two\n\nbetween\n\n3. three\n4. four\n"
+	doc, err := Import([]byte(src), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []sqdoc.ListItem{
+		{ID: 1},
+		{ID: 1, Level: 1, Style: sqdoc.ListDecimal},
+		{ID: 1, Level: 1, Style: sqdoc.ListDecimal},
+		{ID: 1},
+		{},
+		{ID: 2, Style: sqdoc.ListDecimal, Start: 3},
+		{ID: 2, Style: sqdoc.ListDecimal},
+	}
+	if len(doc.Blocks) != len(want) {
+		t.Fatalf("got %d blocks, want %d", len(doc.Blocks), len(want))
+	}
+	for i, b := range doc.Blocks {
+		if b.Text.List != want[i] {
+			t.Errorf("block %d (%q) list = %+v, want %+v", i, b.Text.UTF8, b.Text.List, want[i])
+		}
+	}
+	if err := sqdoc.Validate(doc); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := Export(doc, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != src {
+		t.Fatalf("export = %q, want %q", out, src)
+	}
+}
+
+func TestCodeBlocksRoundTrip(t *testing.T) {
+	src := "Intro\n\n```go\nfunc main() {\n\tprintln(\"`\")\n}\n```\n\n````\n```\n\nquoted\n````\n"
+	doc, err := Import([]byte(src), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.Blocks) != 3 {
+		t.Fatalf("got %d blocks, want 3", len(doc.Blocks))
+	}
+	for i, want := range []struct {
+		text, language string
+	}{{"func main() {\n\tprintln(\"`\")\n}", "go"}, {"```\n\nquoted", ""}} {
+		b := doc.Blocks[i+1]
+		if b.Text.Code == nil || b.Text.Code.Language != want.language || string(b.Text.UTF8) != want.text {
+			t.Errorf("block %d = %q %+v, want %q in %q", i+1, b.Text.UTF8, b.Text.Code, want.text, want.language)
+		}
+	}
+	if err := sqdoc.Validate(doc); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := Export(doc, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != src {
+		t.Fatalf("export = %q, want %q", out, src)
+	}
+}
+
+func TestLinksRoundTrip(t *testing.T) {
+	plain := convert.DefaultAttr()
+	site := attrWith(func(a *sqdoc.StyleAttr) { a.Link = "https://example.com/a_(b)" })
+	boldSite := attrWith(func(a *sqdoc.StyleAttr) { a.Link = site.Link; a.Bold = true })
+	anchor := attrWith(func(a *sqdoc.StyleAttr) { a.Link = "#intro" })
+
+	doc := sqdoc.NewDocument("", "")
+	doc.Blocks = append(doc.Blocks, styledBlock(1, "See ", plain, "the ", site, "site", boldSite, " or ", plain, "intro", anchor, ".", plain))
+	out, err := Export(doc, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "See [the **site**](<https://example.com/a_(b)>) or [intro](#intro).\n"
+	if string(out) != want {
+		t.Fatalf("markdown = %q, want %q", out, want)
+	}
+	back, err := Import(append(out, "\nMail <me@example.com>\n"...), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := runTexts(back.Blocks[0], func(a sqdoc.StyleAttr) bool { return a.Link == site.Link }); strings.Join(got, "") != "the site" {
+		t.Fatalf("linked text = %q", got)
+	}
+	if got := runTexts(back.Blocks[0], func(a sqdoc.StyleAttr) bool { return a.Link == "#intro" }); len(got) != 1 || got[0] != "intro" {
+		t.Fatalf("anchor link text = %q", got)
+	}
+	if got := runTexts(back.Blocks[1], func(a sqdoc.StyleAttr) bool { return a.Link == "mailto:me@example.com" }); len(got) != 1 || got[0] != "me@example.com" {
+		t.Fatalf("autolink = %q in %+v", got, back.Blocks[1].Text.Runs)
+	}
+}
+
+func TestNotesBecomeFootnotes(t *testing.T) {
+	plain := convert.DefaultAttr()
+	bold := attrWith(func(a *sqdoc.StyleAttr) { a.Bold = true })
+	foot := attrWith(func(a *sqdoc.StyleAttr) { a.Note = 7; a.Bold = true })
+	end := attrWith(func(a *sqdoc.StyleAttr) { a.Note = 8 })
+
+	doc := sqdoc.NewDocument("", "")
+	doc.Blocks = append(doc.Blocks, styledBlock(1, "A ", plain, "claim", bold, "1", foot, " and more", plain, "i", end, ".", plain))
+	note := styledBlock(7, "First line\nsecond ", plain, "line", bold)
+	note.Kind = sqdoc.BlockKindNote
+	endnote := styledBlock(8, "Later.", plain)
+	endnote.Kind, endnote.Note = sqdoc.BlockKindNote, sqdoc.NoteEndnote
+	doc.Blocks = append(doc.Blocks, note, endnote)
+	out, err := Export(doc, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "A **claim**[^1] and more[^i].\n\n[^1]: First line\n    second **line**\n\n[^i]: Later.\n"
+	if string(out) != want {
+		t.Fatalf("markdown = %q, want %q", out, want)
+	}
+}
+
+func TestTablesBecomePipeTables(t *testing.T) {
+	plain := convert.DefaultAttr()
+	bold := attrWith(func(a *sqdoc.StyleAttr) { a.Bold = true })
+	table := sqdoc.NewTable(3, 2)
+	cells := []sqdoc.Block{
+		styledBlock(0, "Name", plain), styledBlock(0, "Qty", plain),
+		styledBlock(0, "a|b", bold), styledBlock(0, "3\n4", plain),
+		styledBlock(0, "wide", plain), styledBlock(0, "", plain),
+	}
+	for i, b := range cells {
+		table.Rows[i/2][i%2].Text = b.Text
+	}
+	table.Rows[2][0].ColSpan = 2
+	doc := sqdoc.NewDocument("", "")
+	doc.Blocks = append(doc.Blocks, styledBlock(1, "Stock", plain), sqdoc.Block{ID: 2, Kind: sqdoc.BlockKindTable, Table: table})
+	out, err := Export(doc, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "Stock\n\n| Name | Qty |\n| --- | --- |\n| **a\\|b** | 3<br>4 |\n| wide |  |\n"
+	if string(out) != want {
+		t.Fatalf("markdown = %q, want %q", out, want)
+	}
+}
+
+func TestParagraphFormatsBecomeHTMLBlocks(t *testing.T) {
+	plain := convert.DefaultAttr()
+	centred := styledBlock(1, "Title", plain)
+	centred.Text.Para = sqdoc.ParagraphFormat{Align: sqdoc.AlignCenter, IndentLeft: 36}
+	item := styledBlock(2, "item", plain)
+	item.Text.List = sqdoc.ListItem{ID: 1}
+	item.Text.Para.Align = sqdoc.AlignRight
+	doc := sqdoc.NewDocument("", "")
+	doc.Blocks = append(doc.Blocks, centred, styledBlock(3, "plain", plain), item)
+	out, err := Export(doc, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "<div style=\"text-align: center; margin-left: 36pt\">\n\nTitle\n\n</div>\n\nplain\n\n- item\n"
+	if string(out) != want {
+		t.Fatalf("markdown = %q, want %q", out, want)
+	}
+
+	back, err := Import(out, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var texts []string
+	for _, b := range back.Blocks {
+		texts = append(texts, string(b.Text.UTF8))
+	}
+	if got := strings.Join(texts, "|"); got != "Title|plain|item" {
+		t.Fatalf("imported blocks = %q, want Title|plain|item", got)
+	}
+}
@@ -0,0 +1,740 @@
+package odt
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"sqdoc/pkg/convert"
+	"sqdoc/pkg/sqdoc"
+)
+
+// maxImageWidth keeps pictures inside the 16cm text area of an A4 page.
+const maxImageWidth = 604
+
+// part is one file of the package, in writing order.
+type part struct {
+	name      string
+	mediaType string
+	data      []byte
+}
+
+type picture struct {
+	name   string
+	w, h   int
+	exists bool
+}
+
+type styleKey struct {
+	attr, base sqdoc.StyleAttr
+}
+
+// paraStyleKey is a paragraph's style with the layout an automatic style
+// adds to it; list is the indent of the paragraph's list level.
+type paraStyleKey struct {
+	parent string
+	para   sqdoc.ParagraphFormat
+	list   int
+}
+
+type cellStyleKey struct {
+	borders sqdoc.Border
+	shade   uint32
+}
+
+type exporter struct {
+	opts       Options
+	preferred  sqdoc.FontFamily
+	textStyles map[styleKey]string
+	styleOrder []styleKey
+	// paraStyles name the automatic styles of formatted paragraphs, which
+	// space after adds to gap.
+	paraStyles map[paraStyleKey]string
+	paraOrder  []paraStyleKey
+	gap        uint16
+	pictures   map[string]*picture
+	media      []part
+	frames     int
+	anchors    map[uint64][]string
+	notes      *convert.NoteSet
+	// tableStyles holds the automatic styles of tables and their columns
+	// and cells, which cellStyles names by look.
+	tableStyles strings.Builder
+	tables      int
+	cellStyles  map[cellStyleKey]string
+	// lists become list styles in the order of their first items.
+	lists     convert.ListNesting
+	listOrder []*odtList
+	listByID  map[uint32]*odtList
+}
+
+// odtList is a list with its list style, each level styled after its first
+// item. A list that resumes after other paragraphs is written as a new
+// text:list continuing the last one written of it.
+type odtList struct {
+	name     string
+	styles   [sqdoc.MaxListLevel + 1]sqdoc.ListStyle
+	seen     [sqdoc.MaxListLevel + 1]bool
+	segments int
+}
+
+func Export(doc *sqdoc.Document, opts Options) ([]byte, error) {
+	parts, err := exportParts(doc, opts)
+	if err != nil {
+		return nil, err
+	}
+	var out bytes.Buffer
+	zw := zip.NewWriter(&out)
+	// The mimetype entry must come first and be stored uncompressed.
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: "mimetype", Method: zip.Store})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write([]byte(mimeType)); err != nil {
+		return nil, err
+	}
+	for _, p := range parts {
+		w, err := zw.Create(p.name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(p.data); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+func exportParts(doc *sqdoc.Document, opts Options) ([]part, error) {
+	if doc == nil {
+		return nil, errors.New("odt: document is nil")
+	}
+	preferred := doc.Metadata.PreferredFontFamily
+	if preferred > sqdoc.FontFamilyMonospace {
+		preferred = sqdoc.FontFamilySans
+	}
+	ex := &exporter{opts: opts, preferred: preferred, textStyles: map[styleKey]string{}, paraStyles: map[paraStyleKey]string{}, gap: doc.Metadata.ParagraphGap, pictures: map[string]*picture{}, anchors: convert.BlockAnchors(doc), notes: convert.NewNoteSet(doc), cellStyles: map[cellStyleKey]string{}, listByID: map[uint32]*odtList{}}
+	var body bytes.Buffer
+	for _, b := range doc.Blocks {
+		if b.Kind == sqdoc.BlockKindTable && b.Table != nil {
+			body.WriteString(ex.listTags(sqdoc.ListItem{}))
+			ex.table(&body, b.Table, float64(doc.Metadata.Page.TextWidth()))
+			continue
+		}
+		if b.Kind != sqdoc.BlockKindText || b.Text == nil {
+			continue
+		}
+		body.WriteString(ex.listTags(b.Text.List))
+		ex.paragraph(&body, b)
+	}
+	body.WriteString(ex.listTags(sqdoc.ListItem{}))
+
+	parts := []part{
+		{name: "content.xml", mediaType: "text/xml", data: []byte(ex.content(body.String()))},
+		{name: "styles.xml", mediaType: "text/xml", data: []byte(ex.styles(doc.Metadata))},
+		{name: "meta.xml", mediaType: "text/xml", data: []byte(metaXML(doc.Metadata))},
+	}
+	parts = append(parts, ex.media...)
+	parts = append(parts, part{name: "META-INF/manifest.xml", data: []byte(manifest(parts))})
+	return parts, nil
+}
+
+// baseAttr is what text in a paragraph looks like before any span applies.
+func (ex *exporter) baseAttr(level int) sqdoc.StyleAttr {
+	attr := convert.DefaultAttr()
+	if level > 0 {
+		attr = convert.HeadingAttr(level)
+	}
+	attr.FontFamily = ex.preferred
+	return attr
+}
+
+func (ex *exporter) paragraph(out *bytes.Buffer, b sqdoc.Block) {
+	level := convert.HeadingLevel(b)
+	base := ex.baseAttr(level)
+	spans := convert.Spans(b)
+	if b.Text.Code != nil {
+		level = 0
+		base = ex.baseAttr(0)
+		base.FontFamily = sqdoc.FontFamilyMonospace
+		spans = convert.CodeSpans(b)
+		fmt.Fprintf(out, `<text:p text:style-name="%s">`, ex.paraStyle(codeStyle, b.Text))
+	} else if level > 0 {
+		fmt.Fprintf(out, `<text:h text:style-name="%s" text:outline-level="%d">`, ex.paraStyle("Heading_20_"+strconv.Itoa(level), b.Text), level)
+	} else {
+		fmt.Fprintf(out, `<text:p text:style-name="%s">`, ex.paraStyle("Standard", b.Text))
+	}
+	for _, name := range ex.anchors[b.ID] {
+		fmt.Fprintf(out, `<text:bookmark text:name="%s"/>`, escape(name))
+	}
+	ex.inline(out, spans, base)
+	if level > 0 {
+		out.WriteString("</text:h>\n")
+	} else {
+		out.WriteString("</text:p>\n")
+	}
+}
+
+// paraStyle returns the style for text block tb: parent itself, or an
+// automatic style based on it that carries the block's alignment, indents,
+// spacing and line height. A list item's indent is added to that of its
+// list level, with the marker hanging in it.
+func (ex *exporter) paraStyle(parent string, tb *sqdoc.TextBlock) string {
+	key := paraStyleKey{parent: parent, para: convert.ParaFormat(tb)}
+	if key.para == (sqdoc.ParagraphFormat{}) {
+		return parent
+	}
+	if item := tb.List; item.ID != 0 {
+		key.list = convert.ListIndentPt * (min(int(item.Level), sqdoc.MaxListLevel) + 1)
+	}
+	if name, ok := ex.paraStyles[key]; ok {
+		return name
+	}
+	name := "P" + strconv.Itoa(len(ex.paraOrder)+1)
+	ex.paraStyles[key] = name
+	ex.paraOrder = append(ex.paraOrder, key)
+	return name
+}
+
+// paragraphProperties returns the style:paragraph-properties of key.
+func (ex *exporter) paragraphProperties(key paraStyleKey) string {
+	p := key.para
+	var b strings.Builder
+	b.WriteString("<style:paragraph-properties")
+	switch p.Align {
+	case sqdoc.AlignCenter:
+		b.WriteString(` fo:text-align="center"`)
+	case sqdoc.AlignRight:
+		b.WriteString(` fo:text-align="end"`)
+	case sqdoc.AlignJustify:
+		b.WriteString(` fo:text-align="justify"`)
+	}
+	if p.IndentLeft != 0 || p.IndentRight != 0 || p.IndentFirst != 0 {
+		first := int(p.IndentFirst)
+		if key.list > 0 {
+			first -= convert.ListIndentPt
+		}
+		fmt.Fprintf(&b, ` fo:margin-left="%dpt" fo:margin-right="%dpt" fo:text-indent="%dpt"`, int(p.IndentLeft)+key.list, p.IndentRight, first)
+	}
+	if p.SpaceBefore != 0 {
+		fmt.Fprintf(&b, ` fo:margin-top="%dpt"`, p.SpaceBefore)
+	}
+	if p.SpaceAfter != 0 {
+		fmt.Fprintf(&b, ` fo:margin-bottom="%dpt"`, int(ex.gap)+int(p.SpaceAfter))
+	}
+	if p.LineHeight != 0 {
+		fmt.Fprintf(&b, ` fo:line-height="%d%%"`, p.LineHeight)
+	}
+	if p.PageBreakBefore {
+		b.WriteString(` fo:break-before="page"`)
+	}
+	b.WriteString("/>")
+	return b.String()
+}
+
+// listTags returns the tags that close and open text:list elements before
+// a paragraph with the given list item, then the text:list-item that holds
+// it. Nested lists take their style from the outermost one.
+func (ex *exporter) listTags(item sqdoc.ListItem) string {
+	closed, opened := ex.lists.Next(item)
+	var b strings.Builder
+	for range closed {
+		b.WriteString("</text:list-item></text:list>\n")
+	}
+	if item.ID == 0 {
+		return b.String()
+	}
+	l := ex.listByID[item.ID]
+	if l == nil {
+		l = &odtList{name: "L" + strconv.Itoa(len(ex.listOrder)+1)}
+		ex.listOrder = append(ex.listOrder, l)
+		ex.listByID[item.ID] = l
+	}
+	if level := min(int(item.Level), sqdoc.MaxListLevel); !l.seen[level] {
+		l.seen[level] = true
+		l.styles[level] = item.Style
+	}
+	if len(opened) == 0 {
+		b.WriteString("</text:list-item>\n")
+	}
+	for i, o := range opened {
+		if i > 0 {
+			b.WriteString("<text:list-item>")
+		}
+		if o.Level > 0 {
+			b.WriteString("<text:list>\n")
+			continue
+		}
+		l.segments++
+		fmt.Fprintf(&b, `<text:list xml:id="%s-%d" text:style-name="%s"`, l.name, l.segments, l.name)
+		if l.segments > 1 {
+			fmt.Fprintf(&b, ` text:continue-list="%s-%d"`, l.name, l.segments-1)
+		}
+		b.WriteString(">\n")
+	}
+	if item.Start != 0 {
+		fmt.Fprintf(&b, `<text:list-item text:start-value="%d">`, item.Start)
+	} else {
+		b.WriteString("<text:list-item>")
+	}
+	return b.String()
+}
+
+// writeListStyle writes the text:list-style of l, each level indented one step
+// further with its label hanging in the indent.
+func writeListStyle(b *strings.Builder, l *odtList) {
+	fmt.Fprintf(b, `<text:list-style style:name="%s">`, l.name)
+	for level, style := range l.styles {
+		margin := (level + 1) * convert.ListIndentPt
+		props := fmt.Sprintf(`<style:list-level-properties text:list-level-position-and-space-mode="label-alignment"><style:list-level-label-alignment text:label-followed-by="listtab" text:list-tab-stop-position="%dpt" fo:text-indent="-%dpt" fo:margin-left="%dpt"/></style:list-level-properties>`,
+			margin, convert.ListIndentPt, margin)
+		if style.Numbered() {
+			fmt.Fprintf(b, `<text:list-level-style-number text:level="%d" style:num-suffix="." style:num-format="%s">%s</text:list-level-style-number>`, level+1, numFormat(style), props)
+		} else {
+			fmt.Fprintf(b, `<text:list-level-style-bullet text:level="%d" text:bullet-char="%s">%s</text:list-level-style-bullet>`, level+1, style.Marker(uint8(level), 0), props)
+		}
+	}
+	b.WriteString("</text:list-style>\n")
+}
+
+func numFormat(s sqdoc.ListStyle) string {
+	switch s {
+	case sqdoc.ListLowerAlpha:
+		return "a"
+	case sqdoc.ListUpperAlpha:
+		return "A"
+	case sqdoc.ListLowerRoman:
+		return "i"
+	case sqdoc.ListUpperRoman:
+		return "I"
+	}
+	return "1"
+}
+
+// table writes t across width points, with its header rows repeated on
+// each page and covered cells as table:covered-table-cell.
+func (ex *exporter) table(out *bytes.Buffer, t *sqdoc.TableBlock, width float64) {
+	ex.tables++
+	name := "Table" + strconv.Itoa(ex.tables)
+	widths := t.ColumnWidths(width)
+	total := 0.0
+	for _, w := range widths {
+		total += w
+	}
+	fmt.Fprintf(&ex.tableStyles, `<style:style style:name="%s" style:family="table"><style:table-properties style:width="%s" table:align="left"/></style:style>`+"\n", name, points(total))
+	fmt.Fprintf(out, `<table:table table:name="%s" table:style-name="%s">`+"\n", name, name)
+	for c, w := range widths {
+		col := fmt.Sprintf("%s.C%d", name, c+1)
+		fmt.Fprintf(&ex.tableStyles, `<style:style style:name="%s" style:family="table-column"><style:table-column-properties style:column-width="%s"/></style:style>`+"\n", col, points(w))
+		fmt.Fprintf(out, `<table:table-column table:style-name="%s"/>`, col)
+	}
+	out.WriteString("\n")
+	rows := convert.TableRows(t)
+	header := min(int(t.HeaderRows), len(rows))
+	for r, row := range rows {
+		if r == 0 && header > 0 {
+			out.WriteString("<table:table-header-rows>\n")
+		}
+		out.WriteString("<table:table-row>")
+		for _, cell := range row {
+			if cell.Covered {
+				out.WriteString("<table:covered-table-cell/>")
+				continue
+			}
+			fmt.Fprintf(out, `<table:table-cell table:style-name="%s" office:value-type="string"`, ex.cellStyle(cell))
+			if cell.ColSpan > 1 {
+				fmt.Fprintf(out, ` table:number-columns-spanned="%d"`, cell.ColSpan)
+			}
+			if cell.RowSpan > 1 {
+				fmt.Fprintf(out, ` table:number-rows-spanned="%d"`, cell.RowSpan)
+			}
+			out.WriteString(`><text:p text:style-name="Standard">`)
+			ex.inline(out, cell.Spans, ex.baseAttr(0))
+			out.WriteString("</text:p></table:table-cell>")
+		}
+		out.WriteString("</table:table-row>\n")
+		if r+1 == header {
+			out.WriteString("</table:table-header-rows>\n")
+		}
+	}
+	out.WriteString("</table:table>\n")
+}
+
+// cellStyle returns the automatic style for a cell's borders and shading.
+func (ex *exporter) cellStyle(cell convert.TableCell) string {
+	key := cellStyleKey{borders: cell.Borders, shade: cell.Shade()}
+	if name, ok := ex.cellStyles[key]; ok {
+		return name
+	}
+	name := "Cell" + strconv.Itoa(len(ex.cellStyles)+1)
+	ex.cellStyles[key] = name
+	fmt.Fprintf(&ex.tableStyles, `<style:style style:name="%s" style:family="table-cell"><style:table-cell-properties fo:padding="%dpt"`, name, convert.CellPaddingPt)
+	for _, side := range []struct {
+		border sqdoc.Border
+		name   string
+	}{{sqdoc.BorderTop, "top"}, {sqdoc.BorderRight, "right"}, {sqdoc.BorderBottom, "bottom"}, {sqdoc.BorderLeft, "left"}} {
+		line := "none"
+		if cell.Borders&side.border != 0 {
+			line = fmt.Sprintf("0.5pt solid #%06x", convert.TableBorderRGBA>>8)
+		}
+		fmt.Fprintf(&ex.tableStyles, ` fo:border-%s="%s"`, side.name, line)
+	}
+	if key.shade != 0 {
+		fmt.Fprintf(&ex.tableStyles, ` fo:background-color="#%06x"`, key.shade>>8)
+	}
+	ex.tableStyles.WriteString("/></style:style>\n")
+	return name
+}
+
+func points(pt float64) string {
+	return strconv.FormatFloat(pt, 'f', 2, 64) + "pt"
+}
+
+// inline writes the spans of a paragraph over its base formatting.
+func (ex *exporter) inline(out *bytes.Buffer, spans []convert.Span, base sqdoc.StyleAttr) {
+	atStart := true
+	link := ""
+	for i, s := range spans {
+		if n, first := ex.notes.Anchor(s); n != nil {
+			switchLink(out, link, "")
+			link = ""
+			ex.note(out, n, first)
+			atStart = false
+			continue
+		}
+		if s.Attr.Link != link {
+			switchLink(out, link, s.Attr.Link)
+			link = s.Attr.Link
+		}
+		attr := s.Attr
+		attr.Link = ""
+		style := ""
+		if attr != base {
+			style = ex.textStyle(attr, base)
+			fmt.Fprintf(out, `<text:span text:style-name="%s">`, style)
+		}
+		if s.Image != nil {
+			ex.frame(out, s)
+			atStart = false
+		} else {
+			atStart = writeText(out, s.Text, atStart, i == len(spans)-1)
+		}
+		if style != "" {
+			out.WriteString("</text:span>")
+		}
+	}
+	switchLink(out, link, "")
+}
+
+// note writes the note n at its first anchor and a reference to it at
+// later ones.
+func (ex *exporter) note(out *bytes.Buffer, n *convert.Note, first bool) {
+	class := "footnote"
+	if n.Kind == sqdoc.NoteEndnote {
+		class = "endnote"
+	}
+	if !first {
+		fmt.Fprintf(out, `<text:note-ref text:note-class="%s" text:reference-format="text" text:ref-name="note%d">%s</text:note-ref>`, class, n.ID, n.Number)
+		return
+	}
+	fmt.Fprintf(out, `<text:note text:id="note%d" text:note-class="%s"><text:note-citation>%s</text:note-citation><text:note-body><text:p text:style-name="Standard">`, n.ID, class, n.Number)
+	ex.inline(out, n.Spans, ex.baseAttr(0))
+	out.WriteString("</text:p></text:note-body></text:note>")
+}
+
+// switchLink closes the text:a element for the link from and opens one for
+// to. Internal links name the bookmark written at their heading.
+func switchLink(out *bytes.Buffer, from, to string) {
+	if from != "" {
+		out.WriteString("</text:a>")
+	}
+	if to != "" {
+		fmt.Fprintf(out, `<text:a xlink:type="simple" xlink:href="%s">`, escape(to))
+	}
+}
+
+// writeText escapes s and encodes the whitespace ODF would otherwise
+// collapse: repeated, leading and trailing spaces, tabs and line breaks. It
+// reports whether the next character still follows a space.
+func writeText(out *bytes.Buffer, s string, afterSpace, end bool) bool {
+	spaces := 0
+	flush := func() {
+		if spaces > 0 {
+			if spaces == 1 {
+				out.WriteString("<text:s/>")
+			} else {
+				fmt.Fprintf(out, `<text:s text:c="%d"/>`, spaces)
+			}
+			spaces = 0
+		}
+	}
+	var plain strings.Builder
+	emit := func() {
+		if plain.Len() > 0 {
+			_ = xml.EscapeText(out, []byte(plain.String()))
+			plain.Reset()
+		}
+	}
+	for i, r := range s {
+		switch r {
+		case ' ':
+			if afterSpace || (end && i == len(s)-1) {
+				emit()
+				spaces++
+				continue
+			}
+			plain.WriteRune(r)
+			afterSpace = true
+			continue
+		case '\t':
+			emit()
+			flush()
+			out.WriteString("<text:tab/>")
+		case '\n':
+			emit()
+			flush()
+			out.WriteString("<text:line-break/>")
+		default:
+			flush()
+			plain.WriteRune(r)
+		}
+		afterSpace = r == '\n'
+	}
+	emit()
+	flush()
+	return afterSpace
+}
+
+// textStyle returns the automatic style for attr, written as differences
+// from the paragraph's base formatting.
+func (ex *exporter) textStyle(attr, base sqdoc.StyleAttr) string {
+	key := styleKey{attr: attr, base: base}
+	if name, ok := ex.textStyles[key]; ok {
+		return name
+	}
+	name := "T" + strconv.Itoa(len(ex.styleOrder)+1)
+	ex.textStyles[key] = name
+	ex.styleOrder = append(ex.styleOrder, key)
+	return name
+}
+
+func (ex *exporter) frame(out *bytes.Buffer, s convert.Span) {
+	pic := ex.picture(s.Image.Path)
+	if !pic.exists {
+		_ = xml.EscapeText(out, []byte("[missing image: "+filepath.Base(s.Image.Path)+"]"))
+		return
+	}
+	w, h := convert.ImageDisplaySize(pic.w, pic.h, s.Image.Width, s.Image.Height, s.Attr.FontSizePt)
+	if w > maxImageWidth {
+		h, w = h*maxImageWidth/w, maxImageWidth
+	}
+	ex.frames++
+	fmt.Fprintf(out, `<draw:frame draw:style-name="fr1" draw:name="Image%d" text:anchor-type="as-char" svg:width="%s" svg:height="%s" draw:z-index="0">`,
+		ex.frames, inches(w), inches(h))
+	fmt.Fprintf(out, `<draw:image xlink:href="%s" xlink:type="simple" xlink:show="embed" xlink:actuate="onLoad"/></draw:frame>`, pic.name)
+}
+
+func inches(px int) string {
+	return strconv.FormatFloat(float64(px)/pixelsPerInch, 'f', 4, 64) + "in"
+}
+
+func (ex *exporter) picture(path string) *picture {
+	if p, ok := ex.pictures[path]; ok {
+		return p
+	}
+	p := &picture{}
+	ex.pictures[path] = p
+	img, err := convert.LoadEmbeddedImage(path, ex.opts.BaseDir)
+	if err != nil {
+		return p
+	}
+	*p = picture{name: fmt.Sprintf("Pictures/image%d.%s", len(ex.media)+1, img.Format), w: img.Width, h: img.Height, exists: true}
+	ex.media = append(ex.media, part{name: p.name, mediaType: img.MediaType(), data: img.Data})
+	return p
+}
+
+// textProperties writes the attributes of attr that differ from base, or
+// all of them when base is nil.
+func textProperties(attr sqdoc.StyleAttr, base *sqdoc.StyleAttr) string {
+	all := base == nil
+	if all {
+		base = &sqdoc.StyleAttr{}
+	}
+	var b strings.Builder
+	b.WriteString("<style:text-properties")
+	if all || attr.FontFamily != base.FontFamily {
+		fmt.Fprintf(&b, ` style:font-name="%s"`, fontName(attr.FontFamily))
+	}
+	if (all || attr.FontSizePt != base.FontSizePt) && attr.FontSizePt != 0 {
+		size := strconv.Itoa(int(attr.FontSizePt)) + "pt"
+		fmt.Fprintf(&b, ` fo:font-size="%s" style:font-size-asian="%s" style:font-size-complex="%s"`, size, size, size)
+	}
+	if all || attr.Bold != base.Bold {
+		weight := "normal"
+		if attr.Bold {
+			weight = "bold"
+		}
+		fmt.Fprintf(&b, ` fo:font-weight="%s" style:font-weight-asian="%s" style:font-weight-complex="%s"`, weight, weight, weight)
+	}
+	if all || attr.Italic != base.Italic {
+		style := "normal"
+		if attr.Italic {
+			style = "italic"
+		}
+		fmt.Fprintf(&b, ` fo:font-style="%s" style:font-style-asian="%s" style:font-style-complex="%s"`, style, style, style)
+	}
+	if all || attr.Underline != base.Underline {
+		if attr.Underline {
+			b.WriteString(` style:text-underline-style="solid" style:text-underline-width="auto" style:text-underline-color="font-color"`)
+		} else {
+			b.WriteString(` style:text-underline-style="none"`)
+		}
+	}
+	if all || attr.Highlight != base.Highlight {
+		if attr.Highlight {
+			b.WriteString(` fo:background-color="#fff4a8"`)
+		} else {
+			b.WriteString(` fo:background-color="transparent"`)
+		}
+	}
+	if (all || attr.ColorRGBA != base.ColorRGBA) && attr.ColorRGBA != 0 {
+		fmt.Fprintf(&b, ` fo:color="#%06x"`, attr.ColorRGBA>>8)
+	}
+	b.WriteString("/>")
+	return b.String()
+}
+
+func fontName(f sqdoc.FontFamily) string {
+	switch f {
+	case sqdoc.FontFamilySerif:
+		return "Liberation Serif"
+	case sqdoc.FontFamilyMonospace:
+		return "Liberation Mono"
+	}
+	return "Liberation Sans"
+}
+
+const fontFaceDecls = `<office:font-face-decls>` +
+	`<style:font-face style:name="Liberation Sans" svg:font-family="&apos;Liberation Sans&apos;" style:font-family-generic="swiss" style:font-pitch="variable"/>` +
+	`<style:font-face style:name="Liberation Serif" svg:font-family="&apos;Liberation Serif&apos;" style:font-family-generic="roman" style:font-pitch="variable"/>` +
+	`<style:font-face style:name="Liberation Mono" svg:font-family="&apos;Liberation Mono&apos;" style:font-family-generic="modern" style:font-pitch="fixed"/>` +
+	`</office:font-face-decls>` + "\n"
+
+func rootAttrs() string {
+	return fmt.Sprintf(`xmlns:office="%s" xmlns:style="%s" xmlns:text="%s" xmlns:table="%s" xmlns:draw="%s" xmlns:fo="%s" xmlns:svg="%s" xmlns:xlink="%s" xmlns:dc="%s" xmlns:meta="%s" office:version="1.3"`,
+		nsOffice, nsStyle, nsText, nsTable, nsDraw, nsFO, nsSVG, nsXlink, nsDC, nsMeta)
+}
+
+func (ex *exporter) content(body string) string {
+	var b strings.Builder
+	b.WriteString(xml.Header)
+	fmt.Fprintf(&b, "<office:document-content %s>\n", rootAttrs())
+	b.WriteString(fontFaceDecls)
+	b.WriteString("<office:automatic-styles>\n")
+	for _, key := range ex.paraOrder {
+		fmt.Fprintf(&b, `<style:style style:name="%s" style:family="paragraph" style:parent-style-name="%s">%s</style:style>`+"\n", ex.paraStyles[key], key.parent, ex.paragraphProperties(key))
+	}
+	for _, key := range ex.styleOrder {
+		fmt.Fprintf(&b, `<style:style style:name="%s" style:family="text">%s</style:style>`+"\n", ex.textStyles[key], textProperties(key.attr, &key.base))
+	}
+	b.WriteString(ex.tableStyles.String())
+	for _, l := range ex.listOrder {
+		writeListStyle(&b, l)
+	}
+	b.WriteString(`<style:style style:name="fr1" style:family="graphic" style:parent-style-name="Graphics"><style:graphic-properties style:vertical-pos="bottom" style:vertical-rel="baseline" style:wrap="none"/></style:style>` + "\n")
+	b.WriteString("</office:automatic-styles>\n")
+	b.WriteString("<office:body><office:text>\n")
+	b.WriteString(body)
+	b.WriteString("</office:text></office:body></office:document-content>\n")
+	return b.String()
+}
+
+// styles carries the document-wide font, size and paragraph gap as the
+// default paragraph style, the heading styles and an A4 page layout, with
+// note numbering when there are notes.
+func (ex *exporter) styles(m sqdoc.Metadata) string {
+	var b strings.Builder
+	b.WriteString(xml.Header)
+	fmt.Fprintf(&b, "<office:document-styles %s>\n", rootAttrs())
+	b.WriteString(fontFaceDecls)
+	b.WriteString("<office:styles>\n")
+	def := ex.baseAttr(0)
+	fmt.Fprintf(&b, `<style:default-style style:family="paragraph"><style:paragraph-properties fo:margin-top="0pt" fo:margin-bottom="%dpt"/>%s</style:default-style>`+"\n",
+		m.ParagraphGap, textProperties(def, nil))
+	b.WriteString(`<style:style style:name="Standard" style:family="paragraph" style:class="text"/>` + "\n")
+	b.WriteString(`<style:style style:name="Heading" style:family="paragraph" style:parent-style-name="Standard" style:next-style-name="Standard" style:class="text"><style:paragraph-properties fo:keep-with-next="always"/></style:style>` + "\n")
+	for i := range convert.HeadingSizes {
+		level := i + 1
+		fmt.Fprintf(&b, `<style:style style:name="Heading_20_%d" style:display-name="Heading %d" style:family="paragraph" style:parent-style-name="Heading" style:default-outline-level="%d" style:class="text">%s</style:style>`+"\n",
+			level, level, level, textProperties(ex.baseAttr(level), &def))
+	}
+	code := def
+	code.FontFamily = sqdoc.FontFamilyMonospace
+	fmt.Fprintf(&b, `<style:style style:name="%s" style:display-name="Preformatted Text" style:family="paragraph" style:parent-style-name="Standard" style:class="html"><style:paragraph-properties fo:background-color="#%06x" fo:padding="%dpt" fo:keep-together="always"/>%s</style:style>`+"\n",
+		codeStyle, convert.CodeShadeRGBA>>8, convert.CodePaddingPt, textProperties(code, &def))
+	b.WriteString(`<style:style style:name="Graphics" style:family="graphic"/>` + "\n")
+	if len(ex.notes.List) > 0 {
+		b.WriteString(`<text:notes-configuration text:note-class="footnote" style:num-format="1" text:start-value="0" text:footnotes-position="page" text:start-numbering-at="document"/>` + "\n")
+		b.WriteString(`<text:notes-configuration text:note-class="endnote" style:num-format="i" text:start-value="0"/>` + "\n")
+	}
+	b.WriteString("</office:styles>\n")
+	b.WriteString(`<office:automatic-styles><style:page-layout style:name="pm1"><style:page-layout-properties fo:page-width="21.001cm" fo:page-height="29.7cm" style:print-orientation="portrait" fo:margin-top="2.54cm" fo:margin-bottom="2.54cm" fo:margin-left="2.54cm" fo:margin-right="2.54cm"/></style:page-layout></office:automatic-styles>` + "\n")
+	b.WriteString(`<office:master-styles><style:master-page style:name="Standard" style:page-layout-name="pm1"/></office:master-styles>` + "\n")
+	b.WriteString("</office:document-styles>\n")
+	return b.String()
+}
+
+func metaXML(m sqdoc.Metadata) string {
+	var b strings.Builder
+	b.WriteString(xml.Header)
+	fmt.Fprintf(&b, "<office:document-meta %s><office:meta>", rootAttrs())
+	b.WriteString("<meta:generator>SIDE</meta:generator>")
+	if m.Title != "" {
+		fmt.Fprintf(&b, "<dc:title>%s</dc:title>", escape(m.Title))
+	}
+	if m.Author != "" {
+		fmt.Fprintf(&b, "<meta:initial-creator>%s</meta:initial-creator><dc:creator>%s</dc:creator>", escape(m.Author), escape(m.Author))
+	}
+	if m.CreatedUnix != 0 {
+		fmt.Fprintf(&b, "<meta:creation-date>%s</meta:creation-date>", odfDate(m.CreatedUnix))
+	}
+	if m.ModifiedUnix != 0 {
+		fmt.Fprintf(&b, "<dc:date>%s</dc:date>", odfDate(m.ModifiedUnix))
+	}
+	if m.Language != "" {
+		fmt.Fprintf(&b, "<dc:language>%s</dc:language>", escape(m.Language))
+	}
+	b.WriteString("</office:meta></office:document-meta>\n")
+	return b.String()
+}
+
+func odfDate(unix int64) string {
+	return time.Unix(unix, 0).UTC().Format("2006-01-02T15:04:05Z")
+}
+
+func manifest(parts []part) string {
+	var b strings.Builder
+	b.WriteString(xml.Header)
+	fmt.Fprintf(&b, `<manifest:manifest xmlns:manifest="%s" manifest:version="1.3">`+"\n", nsManifest)
+	fmt.Fprintf(&b, ` <manifest:file-entry manifest:full-path="/" manifest:version="1.3" manifest:media-type="%s"/>`+"\n", mimeType)
+	for _, p := range parts {
+		fmt.Fprintf(&b, ` <manifest:file-entry manifest:full-path="%s" manifest:media-type="%s"/>`+"\n", p.name, p.mediaType)
+	}
+	b.WriteString("</manifest:manifest>\n")
+	return b.String()
+}
+
+func escape(s string) string {
+	var b strings.Builder
+	_ = xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
@@ -0,0 +1,931 @@
+package odt
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"sqdoc/pkg/convert"
+	"sqdoc/pkg/sqdoc"
+)
+
+var errNotODT = errors.New("odt: content.xml not found")
+
+type textPropsXML struct {
+	FontName   string `xml:"font-name,attr"`
+	FontFamily string `xml:"font-family,attr"`
+	FontSize   string `xml:"font-size,attr"`
+	Weight     string `xml:"font-weight,attr"`
+	Style      string `xml:"font-style,attr"`
+	Underline  string `xml:"text-underline-style,attr"`
+	Background string `xml:"background-color,attr"`
+	Color      string `xml:"color,attr"`
+}
+
+type styleXML struct {
+	Name    string        `xml:"name,attr"`
+	Family  string        `xml:"family,attr"`
+	Parent  string        `xml:"parent-style-name,attr"`
+	Outline string        `xml:"default-outline-level,attr"`
+	Text    *textPropsXML `xml:"text-properties"`
+	Para    *struct {
+		MarginBottom string `xml:"margin-bottom,attr"`
+	} `xml:"paragraph-properties"`
+	Column *struct {
+		Width string `xml:"column-width,attr"`
+	} `xml:"table-column-properties"`
+	Cell *cellPropsXML `xml:"table-cell-properties"`
+}
+
+type cellPropsXML struct {
+	Border     string `xml:"border,attr"`
+	Top        string `xml:"border-top,attr"`
+	Right      string `xml:"border-right,attr"`
+	Bottom     string `xml:"border-bottom,attr"`
+	Left       string `xml:"border-left,attr"`
+	Background string `xml:"background-color,attr"`
+}
+
+type listLevelXML struct {
+	Level  int    `xml:"level,attr"`
+	Start  int    `xml:"start-value,attr"`
+	Format string `xml:"num-format,attr"`
+}
+
+type listStyleXML struct {
+	Name   string         `xml:"name,attr"`
+	Bullet []listLevelXML `xml:"list-level-style-bullet"`
+	Number []listLevelXML `xml:"list-level-style-number"`
+	Image  []listLevelXML `xml:"list-level-style-image"`
+}
+
+type fontFaceXML struct {
+	Name    string `xml:"name,attr"`
+	Family  string `xml:"font-family,attr"`
+	Generic string `xml:"font-family-generic,attr"`
+}
+
+// stylesPartXML matches both styles.xml and the style sections of
+// content.xml.
+type stylesPartXML struct {
+	FontFaces []fontFaceXML  `xml:"font-face-decls>font-face"`
+	Defaults  []styleXML     `xml:"styles>default-style"`
+	Styles    []styleXML     `xml:"styles>style"`
+	Lists     []listStyleXML `xml:"styles>list-style"`
+	Auto      []styleXML     `xml:"automatic-styles>style"`
+	AutoLists []listStyleXML `xml:"automatic-styles>list-style"`
+}
+
+type listLevel struct {
+	style sqdoc.ListStyle
+	start int
+}
+
+// listFrame is one open text:list. Nested lists belong to the list that
+// the outermost one stands for.
+type listFrame struct {
+	style   string
+	id      uint32
+	counter int
+	// continued is set for a list that carries on the numbering of an
+	// earlier one.
+	continued bool
+}
+
+type importer struct {
+	opts      Options
+	files     map[string]*zip.File
+	fonts     map[string]sqdoc.FontFamily
+	styles    map[string]styleXML // keyed by family + "/" + name
+	defaults  map[string]styleXML // keyed by family
+	lists     map[string]map[int]listLevel
+	gap       int
+	preferred sqdoc.FontFamily
+
+	doc  *convert.DocBuilder
+	open []listFrame
+	// item is the list item the next paragraph starts. listIDs names the
+	// lists that later ones may continue by their xml:id, and lastList is
+	// the ID of the last outermost list.
+	item     sqdoc.ListItem
+	listIDs  map[string]uint32
+	lastList uint32
+	nextList uint32
+	meta     sqdoc.Metadata
+	media    map[string]string
+	warnings []string
+	warned   map[string]bool
+}
+
+func Import(src []byte, opts Options) (*sqdoc.Document, []string, error) {
+	zr, err := zip.NewReader(bytes.NewReader(src), int64(len(src)))
+	if err != nil {
+		return nil, nil, fmt.Errorf("odt: %w", err)
+	}
+	im := &importer{
+		opts:      opts,
+		files:     map[string]*zip.File{},
+		fonts:     map[string]sqdoc.FontFamily{},
+		styles:    map[string]styleXML{},
+		defaults:  map[string]styleXML{},
+		lists:     map[string]map[int]listLevel{},
+		listIDs:   map[string]uint32{},
+		gap:       -1,
+		preferred: sqdoc.FontFamilySans,
+		media:     map[string]string{},
+		warned:    map[string]bool{},
+	}
+	for _, f := range zr.File {
+		im.files[strings.TrimPrefix(f.Name, "/")] = f
+	}
+	if im.files["content.xml"] == nil {
+		return nil, nil, errNotODT
+	}
+	if mt, err := im.read("mimetype"); err == nil && !strings.HasPrefix(string(mt), mimeType) {
+		return nil, nil, fmt.Errorf("odt: not an OpenDocument text file (%s)", strings.TrimSpace(string(mt)))
+	}
+	content, err := im.read("content.xml")
+	if err != nil {
+		return nil, nil, err
+	}
+	if data, err := im.read("styles.xml"); err == nil {
+		im.readStyles(data)
+	}
+	im.readStyles(content)
+	im.readMeta()
+
+	im.doc = convert.NewDocBuilder(im.meta.Title)
+	if err := im.body(xml.NewDecoder(bytes.NewReader(content))); err != nil {
+		return nil, im.warnings, fmt.Errorf("odt: %w", err)
+	}
+	doc := im.doc.Document()
+	doc.Metadata.Author = im.meta.Author
+	doc.Metadata.Title = im.meta.Title
+	doc.Metadata.PreferredFontFamily = im.preferred
+	if im.gap >= 0 {
+		doc.Metadata.ParagraphGap = uint16(min(im.gap, math.MaxUint16))
+	}
+	if im.meta.CreatedUnix != 0 {
+		doc.Metadata.CreatedUnix = im.meta.CreatedUnix
+	}
+	if im.meta.ModifiedUnix != 0 {
+		doc.Metadata.ModifiedUnix = im.meta.ModifiedUnix
+	}
+	return doc, im.warnings, nil
+}
+
+func (im *importer) warn(format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	if im.warned[msg] {
+		return
+	}
+	im.warned[msg] = true
+	im.warnings = append(im.warnings, msg)
+}
+
+func (im *importer) read(name string) ([]byte, error) {
+	f := im.files[name]
+	if f == nil {
+		return nil, os.ErrNotExist
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
+
+func (im *importer) readStyles(data []byte) {
+	var part stylesPartXML
+	if xml.Unmarshal(data, &part) != nil {
+		im.warn("styles could not be read; using default formatting")
+		return
+	}
+	for _, f := range part.FontFaces {
+		switch f.Generic {
+		case "modern":
+			im.fonts[f.Name] = sqdoc.FontFamilyMonospace
+		case "roman":
+			im.fonts[f.Name] = sqdoc.FontFamilySerif
+		case "swiss":
+			im.fonts[f.Name] = sqdoc.FontFamilySans
+		default:
+			family := strings.Trim(f.Family, `'"`)
+			if family == "" {
+				family = f.Name
+			}
+			im.fonts[f.Name] = convert.FamilyForFont(family)
+		}
+	}
+	for _, s := range part.Defaults {
+		im.defaults[s.Family] = s
+		if s.Family == "paragraph" {
+			im.readParagraphDefaults(s)
+		}
+	}
+	for _, s := range append(part.Styles, part.Auto...) {
+		im.styles[s.Family+"/"+s.Name] = s
+		if s.Family == "paragraph" && s.Name == "Standard" {
+			im.readParagraphDefaults(s)
+		}
+	}
+	for _, l := range append(part.Lists, part.AutoLists...) {
+		levels := map[int]listLevel{}
+		for _, lv := range l.Bullet {
+			levels[lv.Level] = listLevel{style: sqdoc.ListBullet, start: 1}
+		}
+		for _, lv := range l.Image {
+			levels[lv.Level] = listLevel{style: sqdoc.ListBullet, start: 1}
+		}
+		for _, lv := range l.Number {
+			levels[lv.Level] = listLevel{style: listStyle(lv.Format), start: max(lv.Start, 1)}
+		}
+		im.lists[l.Name] = levels
+	}
+}
+
+// readParagraphDefaults takes the paragraph gap and preferred font from the
+// default paragraph style or Standard.
+func (im *importer) readParagraphDefaults(s styleXML) {
+	if s.Para != nil {
+		if pt, ok := parseLength(s.Para.MarginBottom); ok {
+			im.gap = int(math.Round(pt))
+		}
+	}
+	if s.Text != nil {
+		im.preferred = im.applyText(sqdoc.StyleAttr{FontFamily: im.preferred}, s.Text).FontFamily
+	}
+}
+
+func (im *importer) readMeta() {
+	data, err := im.read("meta.xml")
+	if err != nil {
+		return
+	}
+	var meta struct {
+		Title          string `xml:"meta>title"`
+		InitialCreator string `xml:"meta>initial-creator"`
+		Creator        string `xml:"meta>creator"`
+		Created        string `xml:"meta>creation-date"`
+		Modified       string `xml:"meta>date"`
+		Language       string `xml:"meta>language"`
+	}
+	if xml.Unmarshal(data, &meta) != nil {
+		return
+	}
+	im.meta.Title = strings.TrimSpace(meta.Title)
+	im.meta.Author = strings.TrimSpace(meta.InitialCreator)
+	if im.meta.Author == "" {
+		im.meta.Author = strings.TrimSpace(meta.Creator)
+	}
+	im.meta.CreatedUnix = parseDate(meta.Created)
+	im.meta.ModifiedUnix = parseDate(meta.Modified)
+	im.meta.Language = strings.TrimSpace(meta.Language)
+}
+
+// parseDate accepts xsd:dateTime with or without a zone, which is how
+// LibreOffice writes it; zoneless times are taken as UTC.
+func parseDate(s string) int64 {
+	s = strings.TrimSpace(s)
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05.999999999", "2006-01-02"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.Unix()
+		}
+	}
+	return 0
+}
+
+// parseLength converts an ODF length to points.
+func parseLength(s string) (float64, bool) {
+	s = strings.TrimSpace(s)
+	units := []struct {
+		suffix string
+		pt     float64
+	}{{"pt", 1}, {"pc", 12}, {"px", 0.75}, {"in", 72}, {"cm", 72 / 2.54}, {"mm", 72 / 25.4}}
+	for _, u := range units {
+		if num, ok := strings.CutSuffix(s, u.suffix); ok {
+			v, err := strconv.ParseFloat(num, 64)
+			if err != nil {
+				return 0, false
+			}
+			return v * u.pt, true
+		}
+	}
+	return 0, false
+}
+
+// styleChain returns a style and its parents, outermost first.
+func (im *importer) styleChain(family, name string) []styleXML {
+	var chain []styleXML
+	for depth := 0; name != "" && depth < 16; depth++ {
+		s, ok := im.styles[family+"/"+name]
+		if !ok {
+			break
+		}
+		chain = append([]styleXML{s}, chain...)
+		name = s.Parent
+	}
+	return chain
+}
+
+func (im *importer) applyText(attr sqdoc.StyleAttr, p *textPropsXML) sqdoc.StyleAttr {
+	if p == nil {
+		return attr
+	}
+	if p.FontName != "" {
+		if family, ok := im.fonts[p.FontName]; ok {
+			attr.FontFamily = family
+		} else {
+			attr.FontFamily = convert.FamilyForFont(p.FontName)
+		}
+	} else if p.FontFamily != "" {
+		attr.FontFamily = convert.FamilyForFont(strings.Trim(p.FontFamily, `'"`))
+	}
+	if pct, ok := strings.CutSuffix(p.FontSize, "%"); ok {
+		if v, err := strconv.ParseFloat(pct, 64); err == nil && v > 0 {
+			attr.FontSizePt = uint16(math.Round(float64(attr.FontSizePt) * v / 100))
+		}
+	} else if pt, ok := parseLength(p.FontSize); ok && pt >= 1 {
+		attr.FontSizePt = uint16(math.Round(pt))
+	}
+	switch p.Weight {
+	case "":
+	case "normal", "100", "200", "300", "400", "500":
+		attr.Bold = false
+	default:
+		attr.Bold = true
+	}
+	switch p.Style {
+	case "":
+	case "normal":
+		attr.Italic = false
+	default:
+		attr.Italic = true
+	}
+	if p.Underline != "" {
+		attr.Underline = p.Underline != "none"
+	}
+	if p.Background != "" {
+		attr.Highlight = p.Background != "transparent"
+	}
+	if len(p.Color) == 7 && p.Color[0] == '#' {
+		if rgb, err := strconv.ParseUint(p.Color[1:], 16, 32); err == nil {
+			attr.ColorRGBA = uint32(rgb)<<8 | 0xFF
+		}
+	}
+	return attr
+}
+
+// headingLevel reads the level of a text:p from its style chain: an
+// outline level or one of the Title and Heading_20_N names.
+func (im *importer) headingLevel(styleName string) int {
+	chain := im.styleChain("paragraph", styleName)
+	for i := len(chain) - 1; i >= 0; i-- {
+		s := chain[i]
+		if n, err := strconv.Atoi(s.Outline); err == nil && n > 0 {
+			return clampLevel(n)
+		}
+		if s.Name == "Title" {
+			return 1
+		}
+		if n, err := strconv.Atoi(strings.TrimPrefix(s.Name, "Heading_20_")); err == nil && strings.HasPrefix(s.Name, "Heading_20_") {
+			return clampLevel(n)
+		}
+	}
+	return 0
+}
+
+// isCode reports whether a text:p's style chain reaches the preformatted
+// style code blocks are written in.
+func (im *importer) isCode(styleName string) bool {
+	for _, s := range im.styleChain("paragraph", styleName) {
+		if s.Name == codeStyle {
+			return true
+		}
+	}
+	return false
+}
+
+func clampLevel(n int) int {
+	if n < 1 {
+		return 1
+	}
+	if n > len(convert.HeadingSizes) {
+		return len(convert.HeadingSizes)
+	}
+	return n
+}
+
+// baseAttr is the formatting text in a paragraph starts from before any
+// span applies.
+func (im *importer) baseAttr(styleName string, level int) sqdoc.StyleAttr {
+	attr := convert.DefaultAttr()
+	attr.FontFamily = im.preferred
+	if def, ok := im.defaults["paragraph"]; ok {
+		attr = im.applyText(attr, def.Text)
+	}
+	if level > 0 {
+		attr.Bold = true
+		attr.FontSizePt = convert.HeadingSizes[level-1]
+	}
+	for _, s := range im.styleChain("paragraph", styleName) {
+		props := s.Text
+		if level > 0 && props != nil {
+			// Template heading sizes are mostly relative to a base that
+			// differs from SQDoc's, so HeadingSizes wins.
+			p := *props
+			p.FontSize = ""
+			props = &p
+		}
+		attr = im.applyText(attr, props)
+	}
+	return attr
+}
+
+func (im *importer) spanAttr(attr sqdoc.StyleAttr, styleName string) sqdoc.StyleAttr {
+	for _, s := range im.styleChain("text", styleName) {
+		attr = im.applyText(attr, s.Text)
+	}
+	return attr
+}
+
+func attrValue(e xml.StartElement, local string) string {
+	for _, a := range e.Attr {
+		if a.Name.Local == local {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// body walks block-level content: paragraphs, headings, lists, tables and
+// the sections and indexes that wrap them.
+func (im *importer) body(d *xml.Decoder) error {
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		switch start.Name.Local {
+		case "document-content", "body", "text":
+		case "font-face-decls", "automatic-styles", "scripts":
+			if err := d.Skip(); err != nil {
+				return err
+			}
+		default:
+			if err := im.block(d, start); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// blocks handles block-level children until the enclosing end tag.
+func (im *importer) blocks(d *xml.Decoder) error {
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if err := im.block(d, t); err != nil {
+				return err
+			}
+		case xml.EndElement:
+			return nil
+		}
+	}
+}
+
+func (im *importer) block(d *xml.Decoder, start xml.StartElement) error {
+	switch start.Name.Local {
+	case "p", "h":
+		return im.paragraph(d, start)
+	case "list":
+		im.open = append(im.open, im.listFrame(start))
+		err := im.blocks(d)
+		im.open = im.open[:len(im.open)-1]
+		im.item = sqdoc.ListItem{}
+		return err
+	case "list-item":
+		if n := len(im.open); n > 0 {
+			im.open[n-1].counter++
+			im.item = im.listItem(start)
+		}
+		return im.blocks(d)
+	case "list-header", "section", "index-body", "table-of-content", "illustration-index",
+		"table-index", "object-index", "user-index", "alphabetical-index", "bibliography", "index-title":
+		return im.blocks(d)
+	case "table":
+		return im.table(d)
+	case "tracked-changes":
+		im.warn("tracked changes dropped; current text kept")
+		return d.Skip()
+	case "sequence-decls", "variable-decls", "user-field-decls", "forms", "soft-page-break",
+		"table-of-content-source", "illustration-index-source", "table-index-source",
+		"object-index-source", "user-index-source", "alphabetical-index-source", "bibliography-source":
+		return d.Skip()
+	}
+	im.warn("unsupported element <%s> skipped", start.Name.Local)
+	return d.Skip()
+}
+
+// listFrame opens a text:list. An outermost list starts a new SQDoc list
+// unless it continues an earlier one, named by text:continue-list or, with
+// text:continue-numbering, the one before it.
+func (im *importer) listFrame(start xml.StartElement) listFrame {
+	if n := len(im.open); n > 0 {
+		outer := im.open[n-1]
+		style := attrValue(start, "style-name")
+		if style == "" {
+			style = outer.style
+		}
+		return listFrame{style: style, id: outer.id}
+	}
+	frame := listFrame{style: attrValue(start, "style-name")}
+	if id, ok := im.listIDs[attrValue(start, "continue-list")]; ok {
+		frame.id, frame.continued = id, true
+	} else if attrValue(start, "continue-numbering") == "true" && im.lastList != 0 {
+		frame.id, frame.continued = im.lastList, true
+	} else {
+		im.nextList++
+		frame.id = im.nextList
+	}
+	if name := attrValue(start, "id"); name != "" {
+		im.listIDs[name] = frame.id
+	}
+	im.lastList = frame.id
+	return frame
+}
+
+// listItem is the list item a text:list-item makes of its first paragraph.
+// The first item of a list that does not continue another restarts the
+// count when its level starts past 1.
+func (im *importer) listItem(start xml.StartElement) sqdoc.ListItem {
+	level := len(im.open) - 1
+	frame := im.open[level]
+	def, ok := im.lists[frame.style][level+1]
+	if !ok {
+		def = listLevel{start: 1}
+	}
+	item := sqdoc.ListItem{ID: frame.id, Level: uint8(min(level, sqdoc.MaxListLevel)), Style: def.style}
+	if n, err := strconv.Atoi(attrValue(start, "start-value")); err == nil {
+		item.Start = uint32(max(n, 1))
+	} else if frame.counter == 1 && !frame.continued && def.start > 1 {
+		item.Start = uint32(def.start)
+	}
+	return item
+}
+
+// listStyle maps an ODF number format onto a list style.
+func listStyle(format string) sqdoc.ListStyle {
+	switch format {
+	case "a":
+		return sqdoc.ListLowerAlpha
+	case "A":
+		return sqdoc.ListUpperAlpha
+	case "i":
+		return sqdoc.ListLowerRoman
+	case "I":
+		return sqdoc.ListUpperRoman
+	case "":
+		return sqdoc.ListBullet
+	}
+	return sqdoc.ListDecimal
+}
+
+// table reads a table:table into a table block. A nested table is
+// flattened into its cell's text.
+func (im *importer) table(d *xml.Decoder) error {
+	var tb convert.TableBuilder
+	depth, headerDepth := 0, 0
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "table-column":
+				var width uint16
+				if chain := im.styleChain("table-column", attrValue(t, "style-name")); len(chain) > 0 {
+					if c := chain[len(chain)-1].Column; c != nil {
+						if pt, ok := parseLength(c.Width); ok {
+							width = uint16(min(max(math.Round(pt), 0), 0xFFFF))
+						}
+					}
+				}
+				for range repeated(t, "number-columns-repeated") {
+					tb.Column(width)
+				}
+				if err := d.Skip(); err != nil {
+					return err
+				}
+				continue
+			case "table-header-rows":
+				headerDepth = depth + 1
+			case "table-row":
+				tb.Row()
+				if headerDepth > 0 {
+					tb.HeaderRow()
+				}
+			case "table-cell":
+				cell := im.cellStyle(attrValue(t, "style-name"))
+				if n, err := strconv.Atoi(attrValue(t, "number-columns-spanned")); err == nil {
+					cell.ColSpan = uint16(min(max(n, 0), 0xFFFF))
+				}
+				if n, err := strconv.Atoi(attrValue(t, "number-rows-spanned")); err == nil {
+					cell.RowSpan = uint16(min(max(n, 0), 0xFFFF))
+				}
+				outer := im.doc
+				im.doc = convert.NewDocBuilder("")
+				err := im.blocks(d)
+				blocks := im.doc.Document().Blocks
+				im.doc = outer
+				if err != nil {
+					return err
+				}
+				for _, b := range blocks {
+					if b.Kind == sqdoc.BlockKindTable {
+						im.warn("nested tables flattened")
+					}
+				}
+				cell.Text = convert.CellText(blocks)
+				for range repeated(t, "number-columns-repeated") {
+					tb.Cell(cell)
+				}
+				continue
+			case "covered-table-cell":
+				for range repeated(t, "number-columns-repeated") {
+					tb.Covered()
+				}
+				if err := d.Skip(); err != nil {
+					return err
+				}
+				continue
+			case "table-columns", "table-header-columns", "table-column-group", "table-rows", "table-row-group":
+			default:
+				if err := d.Skip(); err != nil {
+					return err
+				}
+				continue
+			}
+			depth++
+		case xml.EndElement:
+			if depth == 0 {
+				if table := tb.Table(); table != nil {
+					im.doc.AddTable(table)
+				}
+				return nil
+			}
+			if depth == headerDepth {
+				headerDepth = 0
+			}
+			depth--
+		}
+	}
+}
+
+// repeated returns how many times a table element repeats, bounded so a
+// spreadsheet-sized run of empty columns stays small.
+func repeated(e xml.StartElement, attr string) int {
+	n, err := strconv.Atoi(attrValue(e, attr))
+	if err != nil {
+		return 1
+	}
+	return min(max(n, 1), 64)
+}
+
+// cellStyle is a cell with the borders and background of the named cell
+// style.
+func (im *importer) cellStyle(name string) sqdoc.TableCell {
+	var cell sqdoc.TableCell
+	for _, s := range im.styleChain("table-cell", name) {
+		p := s.Cell
+		if p == nil {
+			continue
+		}
+		if p.Border != "" {
+			cell.Borders = 0
+			if hasLine(p.Border) {
+				cell.Borders = sqdoc.BorderAll
+			}
+		}
+		for _, side := range []struct {
+			value  string
+			border sqdoc.Border
+		}{{p.Top, sqdoc.BorderTop}, {p.Right, sqdoc.BorderRight}, {p.Bottom, sqdoc.BorderBottom}, {p.Left, sqdoc.BorderLeft}} {
+			if side.value == "" {
+				continue
+			}
+			cell.Borders &^= side.border
+			if hasLine(side.value) {
+				cell.Borders |= side.border
+			}
+		}
+		if p.Background == "transparent" {
+			cell.Background = 0
+		} else if len(p.Background) == 7 && p.Background[0] == '#' {
+			if rgb, err := strconv.ParseUint(p.Background[1:], 16, 32); err == nil {
+				cell.Background = uint32(rgb)<<8 | 0xFF
+			}
+		}
+	}
+	return cell
+}
+
+// hasLine reports whether an fo:border value draws a line.
+func hasLine(border string) bool {
+	border = strings.TrimSpace(border)
+	return border != "" && border != "none" && !strings.Contains(border, "hidden")
+}
+
+// paragraph reads a text:p or text:h. Character data follows the ODF
+// whitespace rules: runs of spaces, tabs and newlines collapse to one space
+// and leading and trailing whitespace is dropped; text:s, text:tab and text:line-break
+// carry the whitespace that must survive.
+func (im *importer) paragraph(d *xml.Decoder, start xml.StartElement) error {
+	styleName := attrValue(start, "style-name")
+	level := 0
+	if start.Name.Local == "h" {
+		level = 1
+		if n, err := strconv.Atoi(attrValue(start, "outline-level")); err == nil {
+			level = clampLevel(n)
+		}
+	} else {
+		level = im.headingLevel(styleName)
+	}
+	var bb convert.BlockBuilder
+	stack := []sqdoc.StyleAttr{im.baseAttr(styleName, level)}
+	list := im.item
+	im.item = sqdoc.ListItem{}
+	// A collapsed space is held back until more content follows, so
+	// trailing whitespace is dropped.
+	afterSpace, pending := true, false
+	var pendingAttr sqdoc.StyleAttr
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return err
+		}
+		attr := stack[len(stack)-1]
+		switch t := tok.(type) {
+		case xml.CharData:
+			var text strings.Builder
+			for _, r := range string(t) {
+				if r == ' ' || r == '\t' || r == '\n' || r == '\r' {
+					if !afterSpace {
+						bb.WriteString(text.String(), attr)
+						text.Reset()
+						pending, pendingAttr = true, attr
+					}
+					afterSpace = true
+					continue
+				}
+				if pending {
+					bb.WriteString(" ", pendingAttr)
+					pending = false
+				}
+				text.WriteRune(r)
+				afterSpace = false
+			}
+			bb.WriteString(text.String(), attr)
+		case xml.StartElement:
+			var err error
+			if pending && t.Name.Local != "span" && t.Name.Local != "a" {
+				bb.WriteString(" ", pendingAttr)
+				pending = false
+			}
+			switch t.Name.Local {
+			case "span":
+				stack = append(stack, im.spanAttr(attr, attrValue(t, "style-name")))
+				continue
+			case "a":
+				link := im.spanAttr(attr, attrValue(t, "style-name"))
+				if link.Link = convert.LinkTarget(attrValue(t, "href")); link.Link == "" {
+					im.warn("hyperlink targets dropped; link text kept")
+				}
+				stack = append(stack, link)
+				continue
+			case "s":
+				n, perr := strconv.Atoi(attrValue(t, "c"))
+				if perr != nil || n < 1 {
+					n = 1
+				}
+				bb.WriteString(strings.Repeat(" ", n), attr)
+				afterSpace = false
+				err = d.Skip()
+			case "tab":
+				bb.WriteString("\t", attr)
+				afterSpace = false
+				err = d.Skip()
+			case "line-break":
+				bb.WriteString("\n", attr)
+				afterSpace = true
+				err = d.Skip()
+			case "frame":
+				err = im.frame(d, t, &bb, attr)
+				afterSpace = false
+			case "note":
+				im.warn("footnotes and endnotes dropped")
+				err = d.Skip()
+			case "annotation":
+				im.warn("comments dropped")
+				err = d.Skip()
+			case "change", "change-start", "change-end":
+				im.warn("tracked changes dropped; current text kept")
+				err = d.Skip()
+			case "bookmark", "bookmark-start", "bookmark-end", "reference-mark", "reference-mark-start",
+				"reference-mark-end", "annotation-end", "soft-page-break", "alphabetical-index-mark",
+				"toc-mark", "user-index-mark", "bibliography-mark":
+				err = d.Skip()
+			default:
+				// Fields such as dates and page numbers keep their
+				// displayed text.
+				stack = append(stack, attr)
+				continue
+			}
+			if err != nil {
+				return err
+			}
+		case xml.EndElement:
+			if len(stack) == 1 {
+				tb := bb.TextBlock()
+				tb.List = list
+				if level == 0 && im.isCode(styleName) {
+					tb.Code, tb.List = &sqdoc.CodeBlock{}, sqdoc.ListItem{}
+				}
+				im.doc.Add(tb)
+				return nil
+			}
+			stack = stack[:len(stack)-1]
+		}
+	}
+}
+
+func (im *importer) frame(d *xml.Decoder, start xml.StartElement, bb *convert.BlockBuilder, attr sqdoc.StyleAttr) error {
+	var fr struct {
+		Width  string `xml:"width,attr"`
+		Height string `xml:"height,attr"`
+		Anchor string `xml:"anchor-type,attr"`
+		Image  *struct {
+			Href string `xml:"href,attr"`
+		} `xml:"image"`
+	}
+	if err := d.DecodeElement(&fr, &start); err != nil {
+		return err
+	}
+	if fr.Image == nil || fr.Image.Href == "" {
+		im.warn("frames other than pictures skipped")
+		return nil
+	}
+	if fr.Anchor != "" && fr.Anchor != "as-char" {
+		im.warn("floating images placed inline")
+	}
+	path, err := im.extract(fr.Image.Href)
+	if err != nil {
+		im.warn("image %s could not be extracted: %v", fr.Image.Href, err)
+		return nil
+	}
+	w, _ := parseLength(fr.Width)
+	h, _ := parseLength(fr.Height)
+	bb.WriteImage(path, int(math.Round(w*pixelsPerInch/72)), int(math.Round(h*pixelsPerInch/72)), attr)
+	return nil
+}
+
+// extract copies a picture out of the package once and returns its path.
+func (im *importer) extract(href string) (string, error) {
+	target := strings.TrimPrefix(path.Clean(strings.TrimPrefix(href, "./")), "/")
+	if p, ok := im.media[target]; ok {
+		return p, nil
+	}
+	data, err := im.read(target)
+	if err != nil {
+		return "", errors.New("linked images are not supported")
+	}
+	dst, err := convert.SaveMedia(&im.opts.MediaDir, "sqdoc-odt-", path.Base(target), data)
+	if err != nil {
+		return "", err
+	}
+	im.media[target] = dst
+	return dst, nil
+}
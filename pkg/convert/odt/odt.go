@@ -0,0 +1,88 @@
+// Package odt converts between OpenDocument Text files and SQDoc documents
+// using only archive/zip and encoding/xml.
+//
+// text:p and text:h become text blocks, text:span formatting (resolved
+// through automatic and common styles) becomes style runs, draw:frame
+// images become inline images, text:list items become list items,
+// paragraphs in the Preformatted Text style become code blocks, table:table
+// becomes a table block with its merged cells, shading and borders, and
+// meta.xml maps to the document metadata. Constructs SQDoc has no
+// equivalent for are reduced to their text and reported as warnings.
+// Export gives paragraphs with an alignment, indents, spacing or line
+// height an automatic paragraph style.
+package odt
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"sqdoc/pkg/convert"
+	"sqdoc/pkg/sqdoc"
+)
+
+const (
+	mimeType = "application/vnd.oasis.opendocument.text"
+
+	nsOffice   = "urn:oasis:names:tc:opendocument:xmlns:office:1.0"
+	nsStyle    = "urn:oasis:names:tc:opendocument:xmlns:style:1.0"
+	nsText     = "urn:oasis:names:tc:opendocument:xmlns:text:1.0"
+	nsTable    = "urn:oasis:names:tc:opendocument:xmlns:table:1.0"
+	nsDraw     = "urn:oasis:names:tc:opendocument:xmlns:drawing:1.0"
+	nsFO       = "urn:oasis:names:tc:opendocument:xmlns:xsl-fo-compatible:1.0"
+	nsSVG      = "urn:oasis:names:tc:opendocument:xmlns:svg-compatible:1.0"
+	nsXlink    = "http://www.w3.org/1999/xlink"
+	nsDC       = "http://purl.org/dc/elements/1.1/"
+	nsMeta     = "urn:oasis:names:tc:opendocument:xmlns:meta:1.0"
+	nsManifest = "urn:oasis:names:tc:opendocument:xmlns:manifest:1.0"
+
+	// pixelsPerInch converts frame sizes, matching the DOCX converter.
+	pixelsPerInch = 96
+
+	// codeStyle is the paragraph style of code blocks.
+	codeStyle = "Preformatted_20_Text"
+)
+
+type Options struct {
+	// MediaDir receives the pictures stored in the package that draw:frame
+	// elements point at, under their entry names; linked images are left
+	// out with a warning. ImportFile defaults it to convert.DefaultMediaDir;
+	// Import uses a new temporary folder.
+	MediaDir string
+	// BaseDir resolves relative image paths that export stores under
+	// Pictures/.
+	BaseDir string
+}
+
+// ImportFile reads a .odt package, titling the document after the file
+// when meta.xml has no dc:title. The returned warnings describe content
+// that was simplified or dropped.
+func ImportFile(path string, opts Options) (*sqdoc.Document, []string, error) {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if opts.MediaDir == "" {
+		opts.MediaDir = convert.DefaultMediaDir(path)
+	}
+	doc, warnings, err := Import(src, opts)
+	if err != nil {
+		return nil, warnings, err
+	}
+	if doc.Metadata.Title == "" {
+		doc.Metadata.Title = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	return doc, warnings, nil
+}
+
+func ExportFile(path string, doc *sqdoc.Document, opts Options) error {
+	out, err := Export(doc, opts)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, out, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
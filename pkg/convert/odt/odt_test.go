@@ -0,0 +1,537 @@
+package odt
+
+import (
+	"archive/zip"
+	"bytes"
+	"flag"
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"sqdoc/pkg/convert"
+	"sqdoc/pkg/sqdoc"
+)
+
+var update = flag.Bool("update", false, "rewrite golden files in testdata")
+
+func checkGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", name+".golden")
+	if *update {
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v (run go test -update to create it)", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s differs from golden file\n--- got\n%s\n--- want\n%s", name, got, want)
+	}
+}
+
+func sampleDocument(t *testing.T) (*sqdoc.Document, []byte) {
+	t.Helper()
+	imgPath := filepath.Join(t.TempDir(), "dot.png")
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 30, 20))); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(imgPath, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	doc := sqdoc.NewDocument("Mina Park", "Report")
+	doc.Metadata.CreatedUnix = 1700000000
+	doc.Metadata.ModifiedUnix = 1700000500
+	doc.Metadata.ParagraphGap = 10
+	doc.Metadata.PreferredFontFamily = sqdoc.FontFamilySerif
+
+	base := convert.DefaultAttr()
+	base.FontFamily = sqdoc.FontFamilySerif
+	heading := convert.HeadingAttr(2)
+	heading.FontFamily = sqdoc.FontFamilySerif
+	var bb convert.BlockBuilder
+	bb.WriteString("Summary", heading)
+	doc.Blocks = append(doc.Blocks, sqdoc.Block{ID: 1, Kind: sqdoc.BlockKindText, Text: bb.TextBlock()})
+
+	fancy := base
+	fancy.Italic = true
+	fancy.Underline = true
+	fancy.Highlight = true
+	fancy.FontSizePt = 11
+	fancy.ColorRGBA = 0x1F4E79FF
+	fancy.FontFamily = sqdoc.FontFamilySans
+	code := base
+	code.FontFamily = sqdoc.FontFamilyMonospace
+	bb.WriteString("  plain & <odd>\t", base)
+	bb.WriteString("fancy", fancy)
+	bb.WriteString("\nx :=   1 ", code)
+	bb.WriteImage(imgPath, 60, 40, base)
+	doc.Blocks = append(doc.Blocks, sqdoc.Block{ID: 2, Kind: sqdoc.BlockKindText, Text: bb.TextBlock()})
+	bb.WriteString(" trailing ", base)
+	doc.Blocks = append(doc.Blocks, sqdoc.Block{ID: 3, Kind: sqdoc.BlockKindText, Text: bb.TextBlock()})
+	return doc, buf.Bytes()
+}
+
+func TestExportMatchesGoldenFiles(t *testing.T) {
+	doc, _ := sampleDocument(t)
+	out, err := Export(doc, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(out), int64(len(out)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if zr.File[0].Name != "mimetype" || zr.File[0].Method != zip.Store {
+		t.Fatalf("first entry = %s (method %d), want stored mimetype", zr.File[0].Name, zr.File[0].Method)
+	}
+	for _, f := range zr.File {
+		if !strings.HasSuffix(f.Name, ".xml") {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var data bytes.Buffer
+		_, err = data.ReadFrom(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkGolden(t, "sample."+strings.ReplaceAll(f.Name, "/", "_"), data.Bytes())
+	}
+}
+
+func TestExportImportRoundTrip(t *testing.T) {
+	doc, pngData := sampleDocument(t)
+	out, err := Export(doc, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mediaDir := filepath.Join(t.TempDir(), "media")
+	back, warnings, err := Import(out, Options{MediaDir: mediaDir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(warnings) != 0 {
+		t.Fatalf("unexpected warnings: %v", warnings)
+	}
+	m := back.Metadata
+	if m.Title != "Report" || m.Author != "Mina Park" || m.CreatedUnix != 1700000000 || m.ModifiedUnix != 1700000500 ||
+		m.ParagraphGap != 10 || m.PreferredFontFamily != sqdoc.FontFamilySerif {
+		t.Fatalf("metadata = %+v", m)
+	}
+	if len(back.Blocks) != len(doc.Blocks) {
+		t.Fatalf("got %d blocks", len(back.Blocks))
+	}
+	for i := range doc.Blocks {
+		got, want := convert.Spans(back.Blocks[i]), convert.Spans(doc.Blocks[i])
+		if len(got) != len(want) {
+			t.Fatalf("block %d spans: got %d want %d\n%+v", i, len(got), len(want), got)
+		}
+		for j := range want {
+			if want[j].Image != nil {
+				img := got[j].Image
+				if img == nil || img.Width != 60 || img.Height != 40 || filepath.Dir(img.Path) != mediaDir {
+					t.Fatalf("image span = %+v", got[j])
+				}
+				if data, err := os.ReadFile(img.Path); err != nil || !bytes.Equal(data, pngData) {
+					t.Fatalf("extracted image differs: %v", err)
+				}
+				continue
+			}
+			if got[j].Text != want[j].Text || got[j].Attr != want[j].Attr {
+				t.Fatalf("block %d span %d: got %q %+v want %q %+v", i, j, got[j].Text, got[j].Attr, want[j].Text, want[j].Attr)
+			}
+		}
+	}
+}
+
+func buildODT(t *testing.T, mime string, dir string) []byte {
+	t.Helper()
+	var out bytes.Buffer
+	zw := zip.NewWriter(&out)
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: "mimetype", Method: zip.Store})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte(mime))
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		data, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		w, err := zw.Create(e.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(data)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return out.Bytes()
+}
+
+// dump renders a document as one line per block, with its list and marker,
+// followed by its runs, so the golden file shows both text and formatting.
+func dump(doc *sqdoc.Document, warnings []string) []byte {
+	var b bytes.Buffer
+	m := doc.Metadata
+	fmt.Fprintf(&b, "title=%q author=%q created=%d modified=%d gap=%d font=%d\n",
+		m.Title, m.Author, m.CreatedUnix, m.ModifiedUnix, m.ParagraphGap, m.PreferredFontFamily)
+	markers := doc.ListMarkers()
+	for i, blk := range doc.Blocks {
+		if blk.Table != nil {
+			dumpTable(&b, blk.Table)
+			continue
+		}
+		fmt.Fprintf(&b, "%q", blk.Text.UTF8)
+		if l := blk.Text.List; l.ID != 0 {
+			fmt.Fprintf(&b, " list=%d level=%d marker=%q", l.ID, l.Level, markers[i])
+		}
+		b.WriteByte('\n')
+		for _, s := range convert.Spans(blk) {
+			a := s.Attr
+			fmt.Fprintf(&b, "  %q b=%t i=%t u=%t hl=%t font=%d size=%d color=%08x",
+				s.Text, a.Bold, a.Italic, a.Underline, a.Highlight, a.FontFamily, a.FontSizePt, a.ColorRGBA)
+			if a.Link != "" {
+				fmt.Fprintf(&b, " link=%q", a.Link)
+			}
+			b.WriteByte('\n')
+		}
+	}
+	for _, w := range warnings {
+		fmt.Fprintf(&b, "warning: %s\n", w)
+	}
+	return b.Bytes()
+}
+
+// dumpTable renders a table as its columns and header rows, then one line
+// per cell that is not covered, with the cell's spans indented under it.
+func dumpTable(b *bytes.Buffer, t *sqdoc.TableBlock) {
+	fmt.Fprintf(b, "table columns=%v header=%d\n", t.Columns, t.HeaderRows)
+	for r, row := range t.Rows {
+		for c, cell := range row {
+			if t.Covered(r, c) {
+				continue
+			}
+			cs, rs := cell.Span()
+			fmt.Fprintf(b, "  cell %d,%d %q span=%dx%d borders=%04b background=%08x\n", r, c, cell.Text.UTF8, cs, rs, cell.Borders, cell.Background)
+			for _, s := range convert.Spans(sqdoc.Block{Kind: sqdoc.BlockKindText, Text: cell.Text}) {
+				a := s.Attr
+				fmt.Fprintf(b, "    %q b=%t font=%d size=%d\n", s.Text, a.Bold, a.FontFamily, a.FontSizePt)
+			}
+		}
+	}
+}
+
+func TestImportLibreOfficeDocument(t *testing.T) {
+	src := buildODT(t, mimeType, filepath.Join("testdata", "writer"))
+	doc, warnings, err := Import(src, Options{MediaDir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkGolden(t, "writer.dump", dump(doc, warnings))
+}
+
+func TestImportRejectsOtherOpenDocumentTypes(t *testing.T) {
+	src := buildODT(t, "application/vnd.oasis.opendocument.spreadsheet", filepath.Join("testdata", "writer"))
+	if _, _, err := Import(src, Options{}); err == nil {
+		t.Fatal("spreadsheet accepted")
+	}
+	if _, _, err := Import([]byte("not a zip"), Options{}); err == nil {
+		t.Fatal("garbage accepted")
+	}
+}
+
+func TestLinksRoundTrip(t *testing.T) {
+	web := convert.DefaultAttr()
+	web.Link = "https://example.com/a?b=c&d=e"
+	internal := convert.DefaultAttr()
+	internal.Link = "#intro"
+	var bb convert.BlockBuilder
+	bb.WriteString("Intro", convert.HeadingAttr(1))
+	heading := bb.TextBlock()
+	heading.Style = sqdoc.StyleHeading1
+	bb = convert.BlockBuilder{}
+	bb.WriteString("See ", convert.DefaultAttr())
+	bb.WriteString("the site", web)
+	bb.WriteString(" or ", convert.DefaultAttr())
+	bb.WriteString("intro", internal)
+	doc := sqdoc.NewDocument("", "Links")
+	doc.Blocks = append(doc.Blocks,
+		sqdoc.Block{ID: 1, Kind: sqdoc.BlockKindText, Text: heading},
+		sqdoc.Block{ID: 2, Kind: sqdoc.BlockKindText, Text: bb.TextBlock()},
+	)
+
+	out, err := Export(doc, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	back, _, err := Import(out, Options{MediaDir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	links := map[string]string{}
+	for _, s := range convert.Spans(back.Blocks[1]) {
+		if s.Attr.Link != "" {
+			links[s.Text] = s.Attr.Link
+		}
+	}
+	if len(links) != 2 || links["the site"] != web.Link || links["intro"] != "#intro" {
+		t.Fatalf("links = %q", links)
+	}
+}
+
+func TestListsRoundTrip(t *testing.T) {
+	items := []struct {
+		text string
+		item sqdoc.ListItem
+	}{
+		{"one", sqdoc.ListItem{ID: 1, Style: sqdoc.ListDecimal}},
+		{"sub", sqdoc.ListItem{ID: 1, Level: 1, Style: sqdoc.ListLowerAlpha}},
+		{"two", sqdoc.ListItem{ID: 1, Style: sqdoc.ListDecimal}},
+		{"aside", sqdoc.ListItem{}},
+		{"three", sqdoc.ListItem{ID: 1, Style: sqdoc.ListDecimal}},
+		{"seven", sqdoc.ListItem{ID: 1, Style: sqdoc.ListDecimal, Start: 7}},
+		{"dot", sqdoc.ListItem{ID: 2}},
+	}
+	doc := sqdoc.NewDocument("", "Lists")
+	for i, it := range items {
+		var bb convert.BlockBuilder
+		bb.WriteString(it.text, convert.DefaultAttr())
+		tb := bb.TextBlock()
+		tb.List = it.item
+		doc.Blocks = append(doc.Blocks, sqdoc.Block{ID: uint64(i + 1), Kind: sqdoc.BlockKindText, Text: tb})
+	}
+
+	parts, err := exportParts(doc, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := string(parts[0].data)
+	for _, want := range []string{
+		`<text:list-level-style-number text:level="2" style:num-suffix="." style:num-format="a">`,
+		`<text:list xml:id="L1-2" text:style-name="L1" text:continue-list="L1-1">`,
+		`<text:list-item text:start-value="7">`,
+		`<text:list xml:id="L2-1" text:style-name="L2">`,
+	} {
+		if !strings.Contains(content, want) {
+			t.Fatalf("content.xml lacks %s:\n%s", want, content)
+		}
+	}
+	out, err := Export(doc, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	back, _, err := Import(out, Options{MediaDir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(back.Blocks) != len(items) {
+		t.Fatalf("got %d blocks", len(back.Blocks))
+	}
+	for i, it := range items {
+		if got := back.Blocks[i].Text; string(got.UTF8) != it.text || got.List != it.item {
+			t.Errorf("block %d = %q %+v, want %q %+v", i, got.UTF8, got.List, it.text, it.item)
+		}
+	}
+	if got, want := strings.Join(back.ListMarkers(), "|"), "1.|a.|2.||3.|7.|•"; got != want {
+		t.Fatalf("markers = %q, want %q", got, want)
+	}
+}
+
+func TestCodeBlocksRoundTrip(t *testing.T) {
+	var bb convert.BlockBuilder
+	bb.WriteString("if x {\n\t  return\n}", convert.DefaultAttr())
+	code := bb.TextBlock()
+	code.Code = &sqdoc.CodeBlock{Language: "go"}
+	doc := sqdoc.NewDocument("", "Code")
+	doc.Blocks = append(doc.Blocks, sqdoc.Block{ID: 1, Kind: sqdoc.BlockKindText, Text: code})
+
+	parts, err := exportParts(doc, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `<text:p text:style-name="Preformatted_20_Text">if x {<text:line-break/><text:tab/> <text:s/>return<text:line-break/>}</text:p>`
+	if content := string(parts[0].data); !strings.Contains(content, want) {
+		t.Fatalf("content.xml lacks %s:\n%s", want, content)
+	}
+	// OpenDocument has nowhere to keep the language.
+	out, err := Export(doc, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	back, _, err := Import(out, Options{MediaDir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := back.Blocks[0].Text; len(back.Blocks) != 1 || got.Code == nil || string(got.UTF8) != "if x {\n\t  return\n}" {
+		t.Fatalf("imported %d blocks, first %q code %+v", len(back.Blocks), got.UTF8, got.Code)
+	}
+}
+
+func TestExportNotes(t *testing.T) {
+	foot := convert.DefaultAttr()
+	foot.Note = 5
+	end := convert.DefaultAttr()
+	end.Note = 6
+	var bb convert.BlockBuilder
+	bb.WriteString("Claim", convert.DefaultAttr())
+	bb.WriteString("1", foot)
+	bb.WriteString(" again", convert.DefaultAttr())
+	bb.WriteString("1", foot)
+	bb.WriteString("i", end)
+	doc := sqdoc.NewDocument("", "Notes")
+	doc.Blocks = append(doc.Blocks, sqdoc.Block{ID: 1, Kind: sqdoc.BlockKindText, Text: bb.TextBlock()})
+	bb.WriteString("Source.", convert.DefaultAttr())
+	doc.Blocks = append(doc.Blocks, sqdoc.Block{ID: 5, Kind: sqdoc.BlockKindNote, Text: bb.TextBlock()})
+	bb.WriteString("Later.", convert.DefaultAttr())
+	doc.Blocks = append(doc.Blocks, sqdoc.Block{ID: 6, Kind: sqdoc.BlockKindNote, Note: sqdoc.NoteEndnote, Text: bb.TextBlock()})
+
+	parts, err := exportParts(doc, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, styles := string(parts[0].data), string(parts[1].data)
+	for _, want := range []string{
+		`Claim<text:note text:id="note5" text:note-class="footnote"><text:note-citation>1</text:note-citation><text:note-body><text:p text:style-name="Standard">Source.</text:p></text:note-body></text:note>`,
+		`<text:note-ref text:note-class="footnote" text:reference-format="text" text:ref-name="note5">1</text:note-ref>`,
+		`<text:note text:id="note6" text:note-class="endnote"><text:note-citation>i</text:note-citation>`,
+	} {
+		if !strings.Contains(content, want) {
+			t.Fatalf("content.xml lacks %s:\n%s", want, content)
+		}
+	}
+	if !strings.Contains(styles, `text:note-class="endnote" style:num-format="i"`) {
+		t.Fatalf("styles.xml = %s", styles)
+	}
+}
+
+func TestExportTables(t *testing.T) {
+	table := sqdoc.NewTable(3, 2)
+	table.HeaderRows = 1
+	table.Columns[0] = 90
+	for i, text := range []string{"Name", "Qty", "Apples", "3", "", "4"} {
+		var bb convert.BlockBuilder
+		bb.WriteString(text, convert.DefaultAttr())
+		table.Rows[i/2][i%2].Text = bb.TextBlock()
+	}
+	table.Rows[1][0].RowSpan = 2
+	table.Rows[1][1].Background = 0xFFF4C2FF
+	doc := sqdoc.NewDocument("", "")
+	doc.Blocks = append(doc.Blocks, sqdoc.Block{ID: 1, Kind: sqdoc.BlockKindTable, Table: table})
+
+	parts, err := exportParts(doc, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := string(parts[0].data)
+	for _, want := range []string{
+		`<style:style style:name="Table1.C1" style:family="table-column"><style:table-column-properties style:column-width="90.00pt"/>`,
+		`fo:border-top="0.5pt solid #78869c"`,
+		`fo:background-color="#fff4c2"`,
+		"<table:table-header-rows>\n<table:table-row>",
+		`table:number-rows-spanned="2"><text:p text:style-name="Standard">Apples</text:p>`,
+		"<table:table-row><table:covered-table-cell/><table:table-cell",
+	} {
+		if !strings.Contains(content, want) {
+			t.Fatalf("content.xml lacks %s:\n%s", want, content)
+		}
+	}
+
+	out, err := Export(doc, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	back, warnings, err := Import(out, Options{MediaDir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := back.Blocks[0].Table
+	if got == nil || len(warnings) > 0 {
+		t.Fatalf("imported %+v with warnings %q", back.Blocks[0], warnings)
+	}
+	if len(got.Columns) != 2 || got.Columns[0] != 90 || got.Columns[1] == 0 || got.HeaderRows != 1 || len(got.Rows) != 3 {
+		t.Fatalf("imported columns %v, %d header rows, %d rows", got.Columns, got.HeaderRows, len(got.Rows))
+	}
+	for r, row := range table.Rows {
+		for c, want := range row {
+			if table.Covered(r, c) {
+				if !got.Covered(r, c) {
+					t.Fatalf("cell %d,%d not covered", r, c)
+				}
+				continue
+			}
+			cell := got.Rows[r][c]
+			if string(cell.Text.UTF8) != string(want.Text.UTF8) || cell.ColSpan != want.ColSpan || cell.RowSpan != want.RowSpan || cell.Background != want.Background || cell.Borders != want.Borders {
+				t.Fatalf("cell %d,%d = %q %+v, want %q %+v", r, c, cell.Text.UTF8, cell, want.Text.UTF8, want)
+			}
+		}
+	}
+	if err := sqdoc.Validate(back); err != nil {
+		t.Fatalf("imported table invalid: %v", err)
+	}
+}
+
+func TestExportParagraphFormats(t *testing.T) {
+	doc := sqdoc.NewDocument("", "Formats")
+	for i, text := range []string{"Title", "body", "item", "same"} {
+		attr := convert.DefaultAttr()
+		if i == 0 {
+			attr = convert.HeadingAttr(1)
+		}
+		var bb convert.BlockBuilder
+		bb.WriteString(text, attr)
+		doc.Blocks = append(doc.Blocks, sqdoc.Block{ID: uint64(i + 1), Kind: sqdoc.BlockKindText, Text: bb.TextBlock()})
+	}
+	doc.Blocks[0].Text.Para.Align = sqdoc.AlignCenter
+	doc.Blocks[1].Text.Para = sqdoc.ParagraphFormat{Align: sqdoc.AlignRight, IndentLeft: 36, IndentRight: 18, IndentFirst: 12, SpaceBefore: 6, SpaceAfter: 4, LineHeight: 150, PageBreakBefore: true}
+	doc.Blocks[2].Text.Para.IndentLeft = 12
+	doc.Blocks[2].Text.List = sqdoc.ListItem{ID: 1, Level: 1, Style: sqdoc.ListBullet}
+	doc.Blocks[3].Text.Para = doc.Blocks[1].Text.Para
+
+	parts, err := exportParts(doc, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := string(parts[0].data)
+	for _, want := range []string{
+		`<style:style style:name="P1" style:family="paragraph" style:parent-style-name="Heading_20_1"><style:paragraph-properties fo:text-align="center"/></style:style>`,
+		`<style:style style:name="P2" style:family="paragraph" style:parent-style-name="Standard"><style:paragraph-properties fo:text-align="end" fo:margin-left="36pt" fo:margin-right="18pt" fo:text-indent="12pt" fo:margin-top="6pt" fo:margin-bottom="12pt" fo:line-height="150%" fo:break-before="page"/></style:style>`,
+		// The list item keeps its marker hanging in the level's indent.
+		`fo:margin-left="60pt" fo:margin-right="0pt" fo:text-indent="-24pt"`,
+		`<text:h text:style-name="P1" text:outline-level="1">`,
+		`<text:p text:style-name="P2">same</text:p>`,
+	} {
+		if !strings.Contains(content, want) {
+			t.Fatalf("content.xml lacks %s:\n%s", want, content)
+		}
+	}
+
+	out, err := Export(doc, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	back, _, err := Import(out, Options{MediaDir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if level := convert.HeadingLevel(back.Blocks[0]); level != 1 {
+		t.Fatalf("centred heading imported at level %d", level)
+	}
+}
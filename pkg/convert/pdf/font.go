@@ -0,0 +1,213 @@
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"unicode/utf16"
+
+	"sqdoc/internal/fonts"
+	"sqdoc/pkg/sqdoc"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/image/math/fixed"
+)
+
+type fontKey struct {
+	family       sqdoc.FontFamily
+	bold, italic bool
+}
+
+// pdfFont is one embedded Liberation face. Metrics are in font units.
+type pdfFont struct {
+	res  string
+	name string
+	data []byte
+	sf   *sfnt.Font
+	buf  sfnt.Buffer
+	ppem fixed.Int26_6
+	upem float64
+
+	ascent, descent, capHeight float64
+	bbox                       [4]float64
+	italicAngle                float64
+	underlinePos               float64
+	underlineThick             float64
+	fixedPitch, serif          bool
+
+	glyphs map[rune]glyph
+	used   map[uint16]rune
+}
+
+type glyph struct {
+	id      uint16
+	advance float64
+}
+
+func loadFont(key fontKey, res string) (*pdfFont, error) {
+	data := fonts.TTF(key.family, key.bold, key.italic)
+	sf, err := sfnt.Parse(data)
+	if err != nil {
+		return nil, err
+	}
+	f := &pdfFont{
+		res:    res,
+		name:   fonts.Name(key.family, key.bold, key.italic),
+		data:   data,
+		sf:     sf,
+		upem:   float64(sf.UnitsPerEm()),
+		serif:  key.family == sqdoc.FontFamilySerif,
+		glyphs: map[rune]glyph{},
+		used:   map[uint16]rune{},
+	}
+	// A ppem equal to unitsPerEm makes sfnt report plain font units.
+	f.ppem = fixed.Int26_6(sf.UnitsPerEm()) << 6
+	m, err := sf.Metrics(&f.buf, f.ppem, font.HintingNone)
+	if err != nil {
+		return nil, err
+	}
+	f.ascent = units(m.Ascent)
+	f.descent = -units(m.Descent)
+	f.capHeight = units(m.CapHeight)
+	b, err := sf.Bounds(&f.buf, f.ppem, font.HintingNone)
+	if err != nil {
+		return nil, err
+	}
+	f.bbox = [4]float64{units(b.Min.X), -units(b.Max.Y), units(b.Max.X), -units(b.Min.Y)}
+	f.underlinePos = -0.1 * f.upem
+	f.underlineThick = 0.05 * f.upem
+	if post := sf.PostTable(); post != nil {
+		f.italicAngle = post.ItalicAngle
+		f.fixedPitch = post.IsFixedPitch
+		if post.UnderlineThickness > 0 {
+			f.underlinePos = float64(post.UnderlinePosition)
+			f.underlineThick = float64(post.UnderlineThickness)
+		}
+	}
+	return f, nil
+}
+
+func units(v fixed.Int26_6) float64 {
+	return float64(v) / 64
+}
+
+// glyph maps r to a glyph and records it for the subset. Tabs draw as spaces.
+func (f *pdfFont) glyph(r rune) glyph {
+	if r == '\t' {
+		r = ' '
+	}
+	if g, ok := f.glyphs[r]; ok {
+		return g
+	}
+	var g glyph
+	if id, err := f.sf.GlyphIndex(&f.buf, r); err == nil {
+		g.id = uint16(id)
+		if adv, err := f.sf.GlyphAdvance(&f.buf, id, f.ppem, font.HintingNone); err == nil {
+			g.advance = units(adv)
+		}
+	}
+	f.glyphs[r] = g
+	if _, ok := f.used[g.id]; !ok && g.id != 0 {
+		f.used[g.id] = r
+	}
+	return g
+}
+
+// scale converts font units at size points to points.
+func (f *pdfFont) scale(v float64, size float64) float64 {
+	return v * size / f.upem
+}
+
+func (f *pdfFont) pdfUnits(v float64) int {
+	return int(v * 1000 / f.upem)
+}
+
+// embed writes the Type0 font, its CIDFontType2 descendant, descriptor,
+// subset font file and ToUnicode map, with the Type0 font as object n.
+func (f *pdfFont) embed(w *writer, n int) error {
+	keep := make(map[uint16]bool, len(f.used))
+	ids := make([]int, 0, len(f.used))
+	for id := range f.used {
+		keep[id] = true
+		ids = append(ids, int(id))
+	}
+	sort.Ints(ids)
+	subset, err := subsetTrueType(f.data, keep)
+	if err != nil {
+		return err
+	}
+	base := subsetTag(ids) + "+" + f.name
+
+	file, desc, cid, cmap := w.alloc(), w.alloc(), w.alloc(), w.alloc()
+	w.stream(file, fmt.Sprintf("/Length1 %d", len(subset)), subset)
+
+	flags := 32
+	if f.fixedPitch {
+		flags |= 1
+	}
+	if f.serif {
+		flags |= 2
+	}
+	if f.italicAngle != 0 {
+		flags |= 64
+	}
+	w.object(desc, fmt.Sprintf("<< /Type /FontDescriptor /FontName /%s /Flags %d /FontBBox [%d %d %d %d] /ItalicAngle %s /Ascent %d /Descent %d /CapHeight %d /StemV 80 /FontFile2 %s >>",
+		base, flags, f.pdfUnits(f.bbox[0]), f.pdfUnits(f.bbox[1]), f.pdfUnits(f.bbox[2]), f.pdfUnits(f.bbox[3]),
+		num(f.italicAngle), f.pdfUnits(f.ascent), f.pdfUnits(f.descent), f.pdfUnits(f.capHeight), ref(file)))
+
+	var widths bytes.Buffer
+	advances := map[uint16]float64{}
+	for _, g := range f.glyphs {
+		advances[g.id] = g.advance
+	}
+	for _, id := range ids {
+		fmt.Fprintf(&widths, "%d [%d] ", id, f.pdfUnits(advances[uint16(id)]))
+	}
+	w.object(cid, fmt.Sprintf("<< /Type /Font /Subtype /CIDFontType2 /BaseFont /%s /CIDSystemInfo << /Registry (Adobe) /Ordering (Identity) /Supplement 0 >> /FontDescriptor %s /W [%s] /CIDToGIDMap /Identity >>",
+		base, ref(desc), widths.String()))
+	w.stream(cmap, "", f.toUnicode(ids))
+	w.object(n, fmt.Sprintf("<< /Type /Font /Subtype /Type0 /BaseFont /%s /Encoding /Identity-H /DescendantFonts [%s] /ToUnicode %s >>",
+		base, ref(cid), ref(cmap)))
+	return nil
+}
+
+// toUnicode maps glyph IDs back to text so viewers can search and copy.
+func (f *pdfFont) toUnicode(ids []int) []byte {
+	var b bytes.Buffer
+	b.WriteString("/CIDInit /ProcSet findresource begin\n12 dict begin\nbegincmap\n")
+	b.WriteString("/CIDSystemInfo << /Registry (Adobe) /Ordering (UCS) /Supplement 0 >> def\n")
+	b.WriteString("/CMapName /Adobe-Identity-UCS def\n/CMapType 2 def\n")
+	b.WriteString("1 begincodespacerange\n<0000> <FFFF>\nendcodespacerange\n")
+	for len(ids) > 0 {
+		chunk := ids[:min(len(ids), 100)]
+		ids = ids[len(chunk):]
+		fmt.Fprintf(&b, "%d beginbfchar\n", len(chunk))
+		for _, id := range chunk {
+			fmt.Fprintf(&b, "<%04X> <", id)
+			for _, u := range utf16.Encode([]rune{f.used[uint16(id)]}) {
+				fmt.Fprintf(&b, "%04X", u)
+			}
+			b.WriteString(">\n")
+		}
+		b.WriteString("endbfchar\n")
+	}
+	b.WriteString("endcmap\nCMapName currentdict /CMap defineresource pop\nend\nend\n")
+	return b.Bytes()
+}
+
+// subsetTag derives the six-letter prefix PDF requires on subset font names.
+func subsetTag(ids []int) string {
+	h := fnv.New32a()
+	for _, id := range ids {
+		h.Write([]byte{byte(id >> 8), byte(id)})
+	}
+	sum := h.Sum32()
+	tag := make([]byte, 6)
+	for i := range tag {
+		tag[i] = 'A' + byte(sum%26)
+		sum /= 26
+	}
+	return string(tag)
+}
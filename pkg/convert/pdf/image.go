@@ -0,0 +1,67 @@
+package pdf
+
+import (
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"os"
+
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/webp"
+)
+
+// pdfImage is a decoded inline image, written once however often it is used.
+type pdfImage struct {
+	res  string
+	img  image.Image
+	w, h int
+}
+
+func loadImage(path, res string) (*pdfImage, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return nil, err
+	}
+	b := img.Bounds()
+	if b.Dx() <= 0 || b.Dy() <= 0 {
+		return nil, fmt.Errorf("pdf: %s: empty image", path)
+	}
+	return &pdfImage{res: res, img: img, w: b.Dx(), h: b.Dy()}, nil
+}
+
+// embed writes the image as RGB samples, with a soft mask when any pixel is
+// not fully opaque.
+func (im *pdfImage) embed(w *writer, n int) {
+	b := im.img.Bounds()
+	rgb := make([]byte, 0, im.w*im.h*3)
+	alpha := make([]byte, 0, im.w*im.h)
+	opaque := true
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			r, g, bl, a := im.img.At(x, y).RGBA()
+			if a > 0 && a < 0xFFFF {
+				// Undo premultiplication.
+				r, g, bl = r*0xFFFF/a, g*0xFFFF/a, bl*0xFFFF/a
+			}
+			rgb = append(rgb, byte(r>>8), byte(g>>8), byte(bl>>8))
+			alpha = append(alpha, byte(a>>8))
+			if a != 0xFFFF {
+				opaque = false
+			}
+		}
+	}
+	dict := fmt.Sprintf("/Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace /DeviceRGB /BitsPerComponent 8", im.w, im.h)
+	if !opaque {
+		mask := w.alloc()
+		w.stream(mask, fmt.Sprintf("/Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace /DeviceGray /BitsPerComponent 8", im.w, im.h), alpha)
+		dict += " /SMask " + ref(mask)
+	}
+	w.stream(n, dict, rgb)
+}
@@ -0,0 +1,741 @@
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"sqdoc/internal/render"
+	"sqdoc/pkg/convert"
+	"sqdoc/pkg/sqdoc"
+)
+
+// lineGap matches the editor's spacing between wrapped lines.
+const lineGap = 4
+
+// markerGap is the space between a list marker and its item's text.
+const markerGap = 6
+
+// widthScale turns point widths into the integers render.WrapEnd compares.
+const widthScale = 64
+
+// item is one rune or one inline image token of a block.
+type item struct {
+	start, end int
+	r          rune
+	attr       sqdoc.StyleAttr
+	font       *pdfFont
+	glyph      glyph
+	isImage    bool
+	image      *pdfImage
+	width      float64
+	height     float64
+	// rise lifts note anchors above the baseline.
+	rise float64
+}
+
+// textLine is one wrapped line, measured but not yet placed. last marks
+// the line that ends its paragraph or a line break, which justified text
+// leaves ragged.
+type textLine struct {
+	pieces          []item
+	ascent, descent float64
+	last            bool
+}
+
+// linkArea is the clickable area of a stretch of linked text on a page.
+type linkArea struct {
+	page           int
+	x0, y0, x1, y1 float64
+	target         string
+}
+
+// dest is where an internal link lands: a page and the top of a line.
+type dest struct {
+	page int
+	top  float64
+}
+
+type layouter struct {
+	opts      Options
+	meta      sqdoc.Metadata
+	preferred sqdoc.FontFamily
+
+	fonts     map[fontKey]*pdfFont
+	fontList  []*pdfFont
+	images    map[string]*pdfImage
+	imageList []*pdfImage
+	alphas    map[uint8]bool
+
+	// blocks are wrapped ahead and put on pages all at once.
+	blocks []laidBlock
+
+	pages []*bytes.Buffer
+	page  *bytes.Buffer
+	// pageNum is the index of page.
+	pageNum int
+
+	links []linkArea
+	dests map[string]dest
+
+	// footnotes are laid out ahead and go at the foot of the page of their
+	// first anchor.
+	footnotes map[uint64]*footnote
+}
+
+// laidBlock is a block wrapped into lines, waiting for its page.
+type laidBlock struct {
+	lines []textLine
+	// notes are the footnotes first anchored in each line.
+	notes [][]*footnote
+	// anchors name the block when internal links target it; its first line
+	// is their destination.
+	anchors []string
+	// rule is the rule above the endnotes, which has a line of space and no
+	// text.
+	rule bool
+	// cells are the cells of a group of table rows, drawn as one line.
+	cells []laidCell
+	box   render.PageBlock
+	// indent moves the block's lines right, first moves its first line
+	// further, and marker is the label drawn before that line when the
+	// block is a list item.
+	indent, first float64
+	marker        []item
+	// width is the room for the lines after the first, and firstWidth for
+	// the first; align places them in it.
+	width, firstWidth float64
+	align             sqdoc.Alignment
+	// code shades the block as a code block, whose padding the heights of
+	// its first and last lines include.
+	code bool
+}
+
+func newLayouter(meta sqdoc.Metadata, opts Options) *layouter {
+	preferred := meta.PreferredFontFamily
+	if preferred > sqdoc.FontFamilyMonospace {
+		preferred = sqdoc.FontFamilySans
+	}
+	return &layouter{
+		opts:      opts,
+		meta:      meta,
+		preferred: preferred,
+		fonts:     map[fontKey]*pdfFont{},
+		images:    map[string]*pdfImage{},
+		alphas:    map[uint8]bool{},
+		dests:     map[string]dest{},
+	}
+}
+
+func (l *layouter) contentWidth() float64 {
+	return l.opts.Page.Width - l.opts.Margins.Left - l.opts.Margins.Right
+}
+
+func (l *layouter) contentHeight() float64 {
+	return l.opts.Page.Height - l.opts.Margins.Top - l.opts.Margins.Bottom
+}
+
+func (l *layouter) turnTo(page int) {
+	l.page, l.pageNum = l.pages[page], page
+}
+
+// normalizeAttr fills in the same defaults the editor applies when drawing.
+func (l *layouter) normalizeAttr(attr sqdoc.StyleAttr) sqdoc.StyleAttr {
+	if attr.FontSizePt == 0 {
+		attr.FontSizePt = 14
+	}
+	if attr.ColorRGBA == 0 {
+		attr.ColorRGBA = 0x202020FF
+	}
+	if attr.FontFamily > sqdoc.FontFamilyMonospace {
+		attr.FontFamily = l.preferred
+	}
+	return attr
+}
+
+func (l *layouter) font(attr sqdoc.StyleAttr) (*pdfFont, error) {
+	key := fontKey{family: attr.FontFamily, bold: attr.Bold, italic: attr.Italic}
+	if f, ok := l.fonts[key]; ok {
+		return f, nil
+	}
+	f, err := loadFont(key, fmt.Sprintf("F%d", len(l.fontList)+1))
+	if err != nil {
+		return nil, err
+	}
+	l.fonts[key] = f
+	l.fontList = append(l.fontList, f)
+	return f, nil
+}
+
+// image returns nil when the file cannot be read; a placeholder box is drawn
+// in its place.
+func (l *layouter) image(path string) *pdfImage {
+	path = convert.ResolvePath(path, l.opts.BaseDir)
+	if im, ok := l.images[path]; ok {
+		return im
+	}
+	im, err := loadImage(path, fmt.Sprintf("Im%d", len(l.imageList)+1))
+	if err != nil {
+		im = nil
+	} else {
+		l.imageList = append(l.imageList, im)
+	}
+	l.images[path] = im
+	return im
+}
+
+// imageSize uses the editor's sizing, then shrinks anything that would not
+// fit on the page.
+func (l *layouter) imageSize(im *pdfImage, tok *sqdoc.ImageToken, fontSize uint16) (float64, float64) {
+	var natW, natH int
+	if im != nil {
+		natW, natH = im.w, im.h
+	}
+	iw, ih := convert.ImageDisplaySize(natW, natH, tok.Width, tok.Height, fontSize)
+	w, h := float64(iw), float64(ih)
+	if maxW := l.contentWidth(); w > maxW {
+		h, w = h*maxW/w, maxW
+	}
+	if maxH := l.contentHeight(); h > maxH {
+		w, h = w*maxH/h, maxH
+	}
+	return w, h
+}
+
+func (l *layouter) items(tb *sqdoc.TextBlock) ([]item, error) {
+	var items []item
+	pos := 0
+	spans := convert.Spans(sqdoc.Block{Kind: sqdoc.BlockKindText, Text: tb})
+	if tb.Code != nil {
+		spans = convert.CodeSpans(sqdoc.Block{Kind: sqdoc.BlockKindText, Text: tb})
+	}
+	for _, span := range spans {
+		attr := l.normalizeAttr(span.Attr)
+		rise := 0.0
+		if attr.Note != 0 {
+			rise = float64(attr.FontSizePt) / 3
+			attr.FontSizePt = max(1, attr.FontSizePt*2/3)
+		}
+		f, err := l.font(attr)
+		if err != nil {
+			return nil, err
+		}
+		if span.Image != nil {
+			im := l.image(span.Image.Path)
+			w, h := l.imageSize(im, span.Image, attr.FontSizePt)
+			items = append(items, item{start: pos, end: pos + len(span.Text), attr: attr, font: f, isImage: true, image: im, width: w, height: h})
+			pos += len(span.Text)
+			continue
+		}
+		for i, r := range span.Text {
+			size := utf8.RuneLen(r)
+			if r == utf8.RuneError {
+				_, size = utf8.DecodeRuneInString(span.Text[i:])
+			}
+			it := item{start: pos + i, end: pos + i + size, r: r, attr: attr, font: f, rise: rise}
+			if r != '\n' {
+				it.glyph = f.glyph(r)
+				it.width = f.scale(it.glyph.advance, float64(attr.FontSizePt))
+			}
+			items = append(items, it)
+		}
+		pos += len(span.Text)
+	}
+	return items, nil
+}
+
+// block wraps tb, which anchors names, and queues it for its page. Its
+// paragraph format indents, aligns and spaces its lines as in the editor. A
+// list item is further indented by its level, with its marker ending just
+// before the text. A code block is padded inside its shading, always
+// aligned left and kept on one page when it fits.
+func (l *layouter) block(tb *sqdoc.TextBlock, marker string, anchors []string) error {
+	para := convert.ParaFormat(tb)
+	indent, right := float64(para.IndentLeft), float64(para.IndentRight)
+	switch {
+	case tb.Code != nil:
+		indent, right = indent+convert.CodePaddingPt, right+convert.CodePaddingPt
+	case marker != "":
+		indent += float64(convert.ListIndentPt * (min(int(tb.List.Level), sqdoc.MaxListLevel) + 1))
+	}
+	first := float64(para.IndentFirst)
+	width := math.Max(40, l.contentWidth()-indent-right)
+	firstWidth := math.Max(40, l.contentWidth()-indent-first-right)
+	lines, err := l.wrapFirst(tb, width, firstWidth)
+	if err != nil {
+		return err
+	}
+	lb := laidBlock{lines: lines, notes: make([][]*footnote, len(lines)), anchors: anchors, indent: indent, first: first,
+		width: width, firstWidth: firstWidth, align: para.Align, code: tb.Code != nil}
+	if marker != "" {
+		attr := convert.MarkerAttr(convert.Spans(sqdoc.Block{Kind: sqdoc.BlockKindText, Text: tb}))
+		lb.marker, err = l.items(&sqdoc.TextBlock{UTF8: []byte(marker), Runs: []sqdoc.StyleRun{{End: uint32(len(marker)), Attr: attr}}})
+		if err != nil {
+			return err
+		}
+	}
+	lb.box = render.PageBlock{
+		Before:      float64(tb.Para.SpaceBefore),
+		After:       float64(l.meta.ParagraphGap) + float64(tb.Para.SpaceAfter),
+		BreakBefore: tb.Para.PageBreakBefore,
+	}
+	for k, ln := range lines {
+		height := ln.ascent + ln.descent + lineGap
+		if para.LineHeight != 0 {
+			height = height * float64(para.LineHeight) / 100
+		}
+		lb.box.Lines = append(lb.box.Lines, height)
+		lb.notes[k] = l.newFootnotes(ln)
+		notes := 0.0
+		for _, fn := range lb.notes[k] {
+			notes += fn.height
+		}
+		lb.box.Notes = append(lb.box.Notes, notes)
+	}
+	if lb.code {
+		lb.box.Lines[0] += convert.CodePaddingPt
+		lb.box.Lines[len(lines)-1] += convert.CodePaddingPt
+		// Code taller than a page breaks between lines like text.
+		height := 0.0
+		for _, h := range lb.box.Lines {
+			height += h
+		}
+		lb.box.Whole = height <= l.contentHeight()+lineGap
+	}
+	l.blocks = append(l.blocks, lb)
+	return nil
+}
+
+// layOut puts the queued blocks on pages and draws them, with each page's
+// footnotes at its foot.
+func (l *layouter) layOut() {
+	boxes := make([]render.PageBlock, len(l.blocks))
+	for i, b := range l.blocks {
+		boxes[i] = b.box
+	}
+	// The last line on a page needs no gap below it.
+	pages := render.Paginate(boxes, l.contentHeight()+lineGap, footRule)
+	for i := 0; i < pages.Count(); i++ {
+		l.pages = append(l.pages, &bytes.Buffer{})
+	}
+	pageNotes := make([][]*footnote, pages.Count())
+	for i, b := range l.blocks {
+		spots := pages.Spots[i]
+		if b.rule {
+			l.turnTo(spots[0].Page)
+			l.rule(l.opts.Margins.Top + spots[0].Y + footRule/2)
+		}
+		if b.cells != nil {
+			l.turnTo(spots[0].Page)
+			l.drawCells(b.cells, l.opts.Margins.Top+spots[0].Y)
+			pageNotes[l.pageNum] = append(pageNotes[l.pageNum], b.notes[0]...)
+			continue
+		}
+		if b.code {
+			l.shadeCode(b, spots)
+		}
+		for k, ln := range b.lines {
+			l.turnTo(spots[k].Page)
+			top := l.opts.Margins.Top + spots[k].Y
+			if b.code && k == 0 {
+				top += convert.CodePaddingPt
+			}
+			baseline := l.opts.Page.Height - top - ln.ascent
+			x, room := l.opts.Margins.Left+b.indent, b.width
+			if k == 0 {
+				x, room = x+b.first, b.firstWidth
+			}
+			offset, spacing := alignLine(ln, b.align, room)
+			if k == 0 {
+				for _, name := range b.anchors {
+					l.dests[name] = dest{page: l.pageNum, top: l.opts.Page.Height - top}
+				}
+				if b.marker != nil {
+					w := 0.0
+					for _, it := range b.marker {
+						w += it.width
+					}
+					mx := math.Max(l.opts.Margins.Left, x-w-markerGap)
+					l.drawLine(b.marker, mx, baseline, ln.ascent, ln.descent, 0)
+				}
+			}
+			l.drawLine(ln.pieces, x+offset, baseline, ln.ascent, ln.descent, spacing)
+			pageNotes[l.pageNum] = append(pageNotes[l.pageNum], b.notes[k]...)
+		}
+	}
+	for i, notes := range pageNotes {
+		if len(notes) > 0 {
+			l.turnTo(i)
+			l.drawFootnotes(notes, pages.Notes[i])
+		}
+	}
+}
+
+// alignLine returns how far right to move line ln within room points for
+// the alignment, and, for justified lines, the space to add after each
+// space between words. Spaces ending the line take no room.
+func alignLine(ln textLine, align sqdoc.Alignment, room float64) (offset, spacing float64) {
+	end := len(ln.pieces)
+	for end > 0 && ln.pieces[end-1].r == ' ' && !ln.pieces[end-1].isImage {
+		end--
+	}
+	width, spaces := 0.0, 0
+	for _, p := range ln.pieces[:end] {
+		width += p.width
+		if p.r == ' ' && !p.isImage {
+			spaces++
+		}
+	}
+	free := math.Max(0, room-width)
+	switch align {
+	case sqdoc.AlignCenter:
+		return free / 2, 0
+	case sqdoc.AlignRight:
+		return free, 0
+	case sqdoc.AlignJustify:
+		if !ln.last && spaces > 0 {
+			return 0, free / float64(spaces)
+		}
+	}
+	return 0, 0
+}
+
+// shadeCode fills the box behind code block b on each page its lines,
+// placed at spots, reach.
+func (l *layouter) shadeCode(b laidBlock, spots []render.Spot) {
+	for k := 0; k < len(spots); {
+		end := k
+		for end+1 < len(spots) && spots[end+1].Page == spots[k].Page {
+			end++
+		}
+		top, bottom := spots[k].Y, spots[end].Y+b.box.Lines[end]-lineGap
+		l.turnTo(spots[k].Page)
+		fmt.Fprintf(l.page, "q %s %s %s %s %s re f Q\n", rgb(convert.CodeShadeRGBA, "rg"),
+			num(l.opts.Margins.Left), num(l.opts.Page.Height-l.opts.Margins.Top-bottom), num(l.contentWidth()), num(bottom-top))
+		k = end + 1
+	}
+}
+
+// wrap breaks a text block into lines width points wide.
+func (l *layouter) wrap(tb *sqdoc.TextBlock, width float64) ([]textLine, error) {
+	return l.wrapFirst(tb, width, width)
+}
+
+// wrapFirst wraps like wrap with the first line firstWidth points wide.
+func (l *layouter) wrapFirst(tb *sqdoc.TextBlock, width, firstWidth float64) ([]textLine, error) {
+	items, err := l.items(tb)
+	if err != nil {
+		return nil, err
+	}
+	text := tb.UTF8
+	at := make([]int, len(text)+1)
+	for i := range at {
+		at[i] = -1
+	}
+	for i, it := range items {
+		at[it.start] = i
+	}
+	advance := func(pos int) (int, int) {
+		if at[pos] < 0 {
+			return 0, pos + 1
+		}
+		it := items[at[pos]]
+		return int(math.Round(it.width * widthScale)), it.end
+	}
+
+	var lines []textLine
+	lineStart := 0
+	for {
+		lineEnd := bytes.IndexByte(text[lineStart:], '\n')
+		if lineEnd < 0 {
+			lineEnd = len(text)
+		} else {
+			lineEnd += lineStart
+		}
+		wrapStart := lineStart
+		for {
+			maxWidth := int(width * widthScale)
+			if len(lines) == 0 {
+				maxWidth = int(firstWidth * widthScale)
+			}
+			end := render.WrapEnd(text, wrapStart, lineEnd, maxWidth, advance)
+			if end <= wrapStart && wrapStart < lineEnd {
+				end = items[at[wrapStart]].end
+			}
+			ln, err := l.measure(items, at, wrapStart, end)
+			if err != nil {
+				return nil, err
+			}
+			ln.last = end >= lineEnd
+			lines = append(lines, ln)
+			if end >= lineEnd {
+				break
+			}
+			wrapStart = end
+		}
+		if lineEnd >= len(text) {
+			break
+		}
+		lineStart = lineEnd + 1
+	}
+	return lines, nil
+}
+
+// measure gathers the items covering [start, end) into a line.
+func (l *layouter) measure(items []item, at []int, start, end int) (textLine, error) {
+	var pieces []item
+	if start < len(at) && at[start] >= 0 {
+		for i := at[start]; i < len(items) && items[i].start < end; i++ {
+			pieces = append(pieces, items[i])
+		}
+	}
+
+	var ascent, descent float64
+	measure := func(f *pdfFont, size float64) {
+		ascent = math.Max(ascent, f.scale(f.ascent, size))
+		descent = math.Max(descent, f.scale(-f.descent, size))
+	}
+	for _, p := range pieces {
+		if p.isImage {
+			ascent = math.Max(ascent, p.height)
+		} else {
+			measure(p.font, float64(p.attr.FontSizePt))
+			ascent = math.Max(ascent, p.font.scale(p.font.ascent, float64(p.attr.FontSizePt))+p.rise)
+		}
+	}
+	if len(pieces) == 0 {
+		// An empty line keeps the height of the style at its position.
+		attr := l.normalizeAttr(convert.DefaultAttr())
+		if start < len(at) && at[start] >= 0 {
+			attr = items[at[start]].attr
+		} else if len(items) > 0 {
+			attr = items[len(items)-1].attr
+		}
+		f, err := l.font(attr)
+		if err != nil {
+			return textLine{}, err
+		}
+		measure(f, float64(attr.FontSizePt))
+	}
+	return textLine{pieces: pieces, ascent: ascent, descent: descent}, nil
+}
+
+// drawLine draws pieces from x along baseline, adding spacing after each
+// space to justify them.
+func (l *layouter) drawLine(pieces []item, x, baseline, ascent, descent, spacing float64) {
+	xs := make([]float64, len(pieces))
+	for i, p := range pieces {
+		xs[i] = x
+		x += p.width
+		if p.r == ' ' && !p.isImage {
+			x += spacing
+		}
+	}
+
+	// Decorations are drawn once per stretch of matching pieces so there are
+	// no seams between glyphs.
+	stretch := func(i int, same func(a, b item) bool) (int, float64) {
+		j := i + 1
+		for j < len(pieces) && same(pieces[i], pieces[j]) {
+			j++
+		}
+		return j, xs[j-1] + pieces[j-1].width - xs[i]
+	}
+
+	for i := 0; i < len(pieces); {
+		if !pieces[i].attr.Highlight {
+			i++
+			continue
+		}
+		j, w := stretch(i, func(_, b item) bool { return b.attr.Highlight })
+		fmt.Fprintf(l.page, "q 1 0.957 0.659 rg %s %s %s %s re f Q\n", num(xs[i]), num(baseline-descent), num(w), num(ascent+descent))
+		i = j
+	}
+
+	for i := 0; i < len(pieces); {
+		p := pieces[i]
+		if p.isImage || p.r == '\n' {
+			i++
+			continue
+		}
+		j := i
+		var glyphs bytes.Buffer
+		for j < len(pieces) && !pieces[j].isImage && pieces[j].r != '\n' && pieces[j].rise == p.rise &&
+			pieces[j].font == p.font && pieces[j].attr.FontSizePt == p.attr.FontSizePt && pieces[j].attr.ColorRGBA == p.attr.ColorRGBA {
+			fmt.Fprintf(&glyphs, "%04X", pieces[j].glyph.id)
+			j++
+			// Justified words are placed one by one.
+			if spacing != 0 && pieces[j-1].r == ' ' {
+				break
+			}
+		}
+		fmt.Fprintf(l.page, "q %s%s BT /%s %d Tf %s %s Td <%s> Tj ET Q\n",
+			l.alphaState(p.attr.ColorRGBA), rgb(p.attr.ColorRGBA, "rg"), p.font.res, p.attr.FontSizePt, num(xs[i]), num(baseline+p.rise), glyphs.String())
+		i = j
+	}
+
+	for i := 0; i < len(pieces); {
+		p := pieces[i]
+		if !p.attr.Underline {
+			i++
+			continue
+		}
+		j, w := stretch(i, func(a, b item) bool {
+			return b.attr.Underline && b.font == a.font && b.attr.FontSizePt == a.attr.FontSizePt && b.attr.ColorRGBA == a.attr.ColorRGBA
+		})
+		size := float64(p.attr.FontSizePt)
+		thick := math.Max(0.5, p.font.scale(p.font.underlineThick, size))
+		top := baseline + p.font.scale(p.font.underlinePos, size)
+		fmt.Fprintf(l.page, "q %s%s %s %s %s %s re f Q\n",
+			l.alphaState(p.attr.ColorRGBA), rgb(p.attr.ColorRGBA, "rg"), num(xs[i]), num(top-thick), num(w), num(thick))
+		i = j
+	}
+
+	for i := 0; i < len(pieces); {
+		if pieces[i].attr.Link == "" {
+			i++
+			continue
+		}
+		j, w := stretch(i, func(a, b item) bool { return b.attr.Link == a.attr.Link })
+		l.links = append(l.links, linkArea{page: l.pageNum, x0: xs[i], y0: baseline - descent, x1: xs[i] + w, y1: baseline + ascent, target: pieces[i].attr.Link})
+		i = j
+	}
+
+	for i, p := range pieces {
+		if !p.isImage {
+			continue
+		}
+		if p.image == nil {
+			fmt.Fprintf(l.page, "q 0.6 0.6 0.6 RG 1 w %s %s %s %s re S Q\n", num(xs[i]+0.5), num(baseline+0.5), num(p.width-1), num(p.height-1))
+			continue
+		}
+		fmt.Fprintf(l.page, "q %s 0 0 %s %s %s cm /%s Do Q\n", num(p.width), num(p.height), num(xs[i]), num(baseline), p.image.res)
+	}
+}
+
+// annotations writes the link annotations of page i and returns the /Annots
+// entry for its page dictionary. Internal links to missing anchors are left
+// out.
+func (l *layouter) annotations(w *writer, i int, pageObjs []int) string {
+	var refs []string
+	for _, a := range l.links {
+		if a.page != i {
+			continue
+		}
+		action := "/A << /S /URI /URI " + uriString(a.target) + " >>"
+		if name, ok := sqdoc.LinkAnchor(a.target); ok {
+			d, found := l.dests[name]
+			if !found {
+				continue
+			}
+			action = fmt.Sprintf("/Dest [%s /XYZ null %s null]", ref(pageObjs[d.page]), num(d.top))
+		}
+		n := w.alloc()
+		w.object(n, fmt.Sprintf("<< /Type /Annot /Subtype /Link /Rect [%s %s %s %s] /Border [0 0 0] %s >>",
+			num(a.x0), num(a.y0), num(a.x1), num(a.y1), action))
+		refs = append(refs, ref(n))
+	}
+	if len(refs) == 0 {
+		return ""
+	}
+	return " /Annots [" + strings.Join(refs, " ") + "]"
+}
+
+// alphaState selects a graphics state for translucent colours.
+func (l *layouter) alphaState(rgba uint32) string {
+	a := uint8(rgba)
+	if a == 0xFF {
+		return ""
+	}
+	l.alphas[a] = true
+	return fmt.Sprintf("/GA%02X gs ", a)
+}
+
+func rgb(rgba uint32, op string) string {
+	c := func(shift uint) string { return num(float64(rgba>>shift&0xFF) / 255) }
+	return c(24) + " " + c(16) + " " + c(8) + " " + op
+}
+
+func (l *layouter) write() ([]byte, error) {
+	w := newWriter()
+	catalog, pages, info, resources := w.alloc(), w.alloc(), w.alloc(), w.alloc()
+
+	fontObjs := make([]int, len(l.fontList))
+	for i := range l.fontList {
+		fontObjs[i] = w.alloc()
+	}
+	imageObjs := make([]int, len(l.imageList))
+	for i := range l.imageList {
+		imageObjs[i] = w.alloc()
+	}
+
+	w.object(catalog, fmt.Sprintf("<< /Type /Catalog /Pages %s /ViewerPreferences << /DisplayDocTitle true >> >>", ref(pages)))
+
+	// Pages are numbered first so links can name pages after their own.
+	pageObjs := make([]int, len(l.pages))
+	kids := make([]string, len(l.pages))
+	for i := range l.pages {
+		pageObjs[i] = w.alloc()
+		kids[i] = ref(pageObjs[i])
+	}
+	for i, content := range l.pages {
+		stream := w.alloc()
+		annots := l.annotations(w, i, pageObjs)
+		w.object(pageObjs[i], fmt.Sprintf("<< /Type /Page /Parent %s /MediaBox [0 0 %s %s] /Resources %s /Contents %s%s >>",
+			ref(pages), num(l.opts.Page.Width), num(l.opts.Page.Height), ref(resources), ref(stream), annots))
+		w.stream(stream, "", content.Bytes())
+	}
+	w.object(pages, fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(l.pages)))
+
+	var res bytes.Buffer
+	res.WriteString("<< /ProcSet [/PDF /Text /ImageC] /Font <<")
+	for i, f := range l.fontList {
+		fmt.Fprintf(&res, " /%s %s", f.res, ref(fontObjs[i]))
+	}
+	res.WriteString(" >> /XObject <<")
+	for i, im := range l.imageList {
+		fmt.Fprintf(&res, " /%s %s", im.res, ref(imageObjs[i]))
+	}
+	res.WriteString(" >> /ExtGState <<")
+	alphas := make([]int, 0, len(l.alphas))
+	for a := range l.alphas {
+		alphas = append(alphas, int(a))
+	}
+	sort.Ints(alphas)
+	for _, a := range alphas {
+		v := num(float64(a) / 255)
+		fmt.Fprintf(&res, " /GA%02X << /ca %s /CA %s >>", a, v, v)
+	}
+	res.WriteString(" >> >>")
+	w.object(resources, res.String())
+
+	for i, f := range l.fontList {
+		if err := f.embed(w, fontObjs[i]); err != nil {
+			return nil, err
+		}
+	}
+	for i, im := range l.imageList {
+		im.embed(w, imageObjs[i])
+	}
+
+	var meta bytes.Buffer
+	meta.WriteString("<< /Producer (SIDE) /Creator (SIDE)")
+	if l.meta.Title != "" {
+		meta.WriteString(" /Title " + textString(l.meta.Title))
+	}
+	if l.meta.Author != "" {
+		meta.WriteString(" /Author " + textString(l.meta.Author))
+	}
+	if l.meta.CreatedUnix != 0 {
+		meta.WriteString(" /CreationDate " + dateString(l.meta.CreatedUnix))
+	}
+	if l.meta.ModifiedUnix != 0 {
+		meta.WriteString(" /ModDate " + dateString(l.meta.ModifiedUnix))
+	}
+	meta.WriteString(" >>")
+	w.object(info, meta.String())
+	return w.finish(catalog, info), nil
+}
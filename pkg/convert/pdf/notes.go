@@ -0,0 +1,115 @@
+package pdf
+
+import (
+	"fmt"
+
+	"sqdoc/internal/render"
+	"sqdoc/pkg/convert"
+	"sqdoc/pkg/sqdoc"
+)
+
+// footRule is the space the rule above footnotes and endnotes takes.
+const footRule = 12
+
+type footnote struct {
+	lines  []textLine
+	height float64
+	// claimed is set once the line of the first anchor is found.
+	claimed bool
+}
+
+// notes lays out the footnotes of doc ahead of the body and returns the
+// endnotes to follow it. Each starts with its number, drawn like an anchor.
+func (l *layouter) notes(doc *sqdoc.Document) ([]*sqdoc.TextBlock, error) {
+	l.footnotes = map[uint64]*footnote{}
+	var endnotes []*sqdoc.TextBlock
+	for _, n := range doc.Notes() {
+		tb := numberedNote(n, doc.Blocks[n.Block].Text)
+		if n.Kind == sqdoc.NoteEndnote {
+			endnotes = append(endnotes, tb)
+			continue
+		}
+		lines, err := l.wrap(tb, l.contentWidth())
+		if err != nil {
+			return nil, err
+		}
+		fn := &footnote{lines: lines}
+		for _, ln := range lines {
+			fn.height += ln.ascent + ln.descent + lineGap
+		}
+		l.footnotes[n.ID] = fn
+	}
+	return endnotes, nil
+}
+
+func numberedNote(n sqdoc.NoteRef, text *sqdoc.TextBlock) *sqdoc.TextBlock {
+	spans := convert.Spans(sqdoc.Block{Kind: sqdoc.BlockKindText, Text: text})
+	attr := sqdoc.StyleAttr{FontSizePt: 11}
+	if len(spans) > 0 {
+		attr = spans[0].Attr
+		attr.Link = ""
+	}
+	prefix := n.Number + " "
+	number := attr
+	number.Note = n.ID
+	tb := &sqdoc.TextBlock{
+		UTF8: append([]byte(prefix), text.UTF8...),
+		Runs: []sqdoc.StyleRun{
+			{Start: 0, End: uint32(len(n.Number)), Attr: number},
+			{Start: uint32(len(n.Number)), End: uint32(len(prefix)), Attr: attr},
+		},
+	}
+	for _, r := range text.Runs {
+		r.Start += uint32(len(prefix))
+		r.End += uint32(len(prefix))
+		tb.Runs = append(tb.Runs, r)
+	}
+	return tb
+}
+
+// newFootnotes returns the footnotes whose first anchors are in ln.
+func (l *layouter) newFootnotes(ln textLine) []*footnote {
+	var notes []*footnote
+	for _, p := range ln.pieces {
+		if fn := l.footnotes[p.attr.Note]; fn != nil && !fn.claimed {
+			fn.claimed = true
+			notes = append(notes, fn)
+		}
+	}
+	return notes
+}
+
+// drawFootnotes draws the footnotes of the current page above its bottom
+// margin, where they and the rule above them take height.
+func (l *layouter) drawFootnotes(notes []*footnote, height float64) {
+	y := l.opts.Page.Height - l.opts.Margins.Bottom - height
+	l.rule(y + footRule/2)
+	y += footRule
+	for _, fn := range notes {
+		for _, ln := range fn.lines {
+			l.drawLine(ln.pieces, l.opts.Margins.Left, l.opts.Page.Height-y-ln.ascent, ln.ascent, ln.descent, 0)
+			y += ln.ascent + ln.descent + lineGap
+		}
+	}
+}
+
+// endnotes queues the endnotes after a rule at the end of the body.
+func (l *layouter) endnotes(notes []*sqdoc.TextBlock) error {
+	if len(notes) == 0 {
+		return nil
+	}
+	l.blocks = append(l.blocks, laidBlock{rule: true, box: render.PageBlock{Lines: []float64{footRule}, Whole: true}})
+	for _, tb := range notes {
+		if err := l.block(tb, "", nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rule draws a short rule across the start of the text area, y points from
+// the top of the page.
+func (l *layouter) rule(y float64) {
+	fmt.Fprintf(l.page, "q 0.6 0.6 0.6 RG 0.5 w %s %s m %s %s l S Q\n",
+		num(l.opts.Margins.Left), num(l.opts.Page.Height-y), num(l.opts.Margins.Left+l.contentWidth()/3), num(l.opts.Page.Height-y))
+}
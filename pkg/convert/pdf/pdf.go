@@ -0,0 +1,124 @@
+// Package pdf renders SQDoc documents to PDF with the bundled Liberation
+// fonts embedded as subsets.
+//
+// Lines wrap and break into pages with the same rules as the editor's page
+// view, on the document's page setup unless Options say otherwise. Text is
+// drawn by glyph ID with a ToUnicode map so it stays selectable and
+// searchable, and inline images are embedded once per file. Paragraphs
+// keep their alignment, indents, spacing and line height, list items are
+// indented by level behind their markers, and code blocks are set in the
+// monospace face on a shaded box. Tables are drawn as grids that break
+// across pages between rows. Hyperlinks become link annotations, internal
+// ones pointing at their heading's line. Footnotes go at the foot of the
+// page of their first anchor and endnotes after the body.
+package pdf
+
+import (
+	"errors"
+	"os"
+
+	"sqdoc/pkg/convert"
+	"sqdoc/pkg/sqdoc"
+)
+
+// PageSize is in PDF points (1/72 inch).
+type PageSize struct {
+	Width, Height float64
+}
+
+var (
+	PageA4     = PageSize{Width: 595.276, Height: 841.89}
+	PageLetter = PageSize{Width: 612, Height: 792}
+	PageLegal  = PageSize{Width: 612, Height: 1008}
+)
+
+// Margins are in points.
+type Margins struct {
+	Top, Right, Bottom, Left float64
+}
+
+// DefaultMargin is one inch, used when Options.Margins is left zero.
+const DefaultMargin = 72
+
+func UniformMargins(pt float64) Margins {
+	return Margins{Top: pt, Right: pt, Bottom: pt, Left: pt}
+}
+
+type Options struct {
+	// Page and Margins default to the document's page setup.
+	Page    PageSize
+	Margins Margins
+	// BaseDir resolves relative image paths.
+	BaseDir string
+}
+
+var errNoRoom = errors.New("pdf: margins leave no room on the page")
+
+func (o Options) normalized(setup sqdoc.PageSetup) (Options, error) {
+	if o.Page.Width <= 0 || o.Page.Height <= 0 {
+		o.Page = PageA4
+		if setup != (sqdoc.PageSetup{}) {
+			o.Page = PageSize{Width: float64(setup.Width), Height: float64(setup.Height)}
+		}
+	}
+	if o.Margins == (Margins{}) {
+		o.Margins = UniformMargins(DefaultMargin)
+		if setup != (sqdoc.PageSetup{}) {
+			o.Margins = Margins{Top: float64(setup.Top), Right: float64(setup.Right), Bottom: float64(setup.Bottom), Left: float64(setup.Left)}
+		}
+	}
+	m := o.Margins
+	if m.Top < 0 || m.Right < 0 || m.Bottom < 0 || m.Left < 0 ||
+		o.Page.Width-m.Left-m.Right < 36 || o.Page.Height-m.Top-m.Bottom < 36 {
+		return o, errNoRoom
+	}
+	return o, nil
+}
+
+func ExportFile(path string, doc *sqdoc.Document, opts Options) error {
+	out, err := Export(doc, opts)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, out, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func Export(doc *sqdoc.Document, opts Options) ([]byte, error) {
+	if doc == nil {
+		return nil, errors.New("pdf: document is nil")
+	}
+	opts, err := opts.normalized(doc.Metadata.Page)
+	if err != nil {
+		return nil, err
+	}
+	l := newLayouter(doc.Metadata, opts)
+	anchors := convert.BlockAnchors(doc)
+	endnotes, err := l.notes(doc)
+	if err != nil {
+		return nil, err
+	}
+	markers := doc.ListMarkers()
+	for i, b := range doc.Blocks {
+		if b.Kind == sqdoc.BlockKindTable && b.Table != nil {
+			if err := l.table(b.Table); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if b.Kind != sqdoc.BlockKindText || b.Text == nil {
+			continue
+		}
+		if err := l.block(b.Text, markers[i], anchors[b.ID]); err != nil {
+			return nil, err
+		}
+	}
+	if err := l.endnotes(endnotes); err != nil {
+		return nil, err
+	}
+	l.layOut()
+	return l.write()
+}
@@ -0,0 +1,467 @@
+package pdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"sqdoc/internal/fonts"
+	"sqdoc/pkg/convert"
+	"sqdoc/pkg/sqdoc"
+
+	"golang.org/x/image/font/sfnt"
+)
+
+func textDoc(paragraphs ...string) *sqdoc.Document {
+	doc := sqdoc.NewDocument("Ana Lima", "Relatório Ω")
+	for i, p := range paragraphs {
+		var bb convert.BlockBuilder
+		bb.WriteString(p, convert.DefaultAttr())
+		doc.Blocks = append(doc.Blocks, sqdoc.Block{ID: uint64(i + 1), Kind: sqdoc.BlockKindText, Text: bb.TextBlock()})
+	}
+	return doc
+}
+
+// streams returns every stream in out, inflated.
+func streams(t *testing.T, out []byte) [][]byte {
+	t.Helper()
+	var all [][]byte
+	rest := out
+	for {
+		i := bytes.Index(rest, []byte(">>\nstream\n"))
+		if i < 0 {
+			return all
+		}
+		rest = rest[i+len(">>\nstream\n"):]
+		end := bytes.Index(rest, []byte("\nendstream"))
+		zr, err := zlib.NewReader(bytes.NewReader(rest[:end]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(zr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		all = append(all, data)
+		rest = rest[end:]
+	}
+}
+
+func TestExportWritesConsistentCrossReferences(t *testing.T) {
+	out, err := Export(textDoc("Hello PDF"), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(out, []byte("%PDF-1.7")) || !bytes.HasSuffix(out, []byte("%%EOF\n")) {
+		t.Fatal("missing header or trailer")
+	}
+	start := bytes.LastIndex(out, []byte("startxref\n"))
+	xref, _ := strconv.Atoi(strings.Fields(string(out[start+len("startxref\n"):]))[0])
+	lines := strings.Split(string(out[xref:]), "\n")
+	count, _ := strconv.Atoi(strings.Fields(lines[1])[1])
+	for n := 1; n < count; n++ {
+		off, _ := strconv.Atoi(strings.Fields(lines[2+n])[0])
+		if want := strconv.Itoa(n) + " 0 obj"; !bytes.HasPrefix(out[off:], []byte(want)) {
+			t.Fatalf("xref entry %d points at %q", n, out[off:off+12])
+		}
+	}
+}
+
+func TestExportMetadataAndSearchableText(t *testing.T) {
+	out, err := Export(textDoc("Hi"), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(out, []byte("/Title "+textString("Relatório Ω"))) || !bytes.Contains(out, []byte("/Author "+textString("Ana Lima"))) {
+		t.Fatal("info dictionary lacks title or author")
+	}
+	f, err := loadFont(fontKey{family: sqdoc.FontFamilySans}, "F1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, i := f.glyph('H'), f.glyph('i')
+	shown := []byte("<" + strings.ToUpper(hex4(h.id)+hex4(i.id)) + "> Tj")
+	mapped := []byte("<" + hex4(h.id) + "> <0048>")
+	var sawText, sawMap bool
+	for _, s := range streams(t, out) {
+		sawText = sawText || bytes.Contains(s, shown)
+		sawMap = sawMap || bytes.Contains(s, mapped)
+	}
+	if !sawText || !sawMap {
+		t.Fatalf("text shown=%v, ToUnicode mapped=%v", sawText, sawMap)
+	}
+}
+
+func hex4(id uint16) string {
+	s := strings.ToUpper(strconv.FormatUint(uint64(id), 16))
+	return strings.Repeat("0", 4-len(s)) + s
+}
+
+func TestExportPaginatesLongDocuments(t *testing.T) {
+	paras := make([]string, 80)
+	for i := range paras {
+		paras[i] = strings.Repeat("wrap these words across the line ", 6)
+	}
+	out, err := Export(textDoc(paras...), Options{Page: PageLetter, Margins: UniformMargins(36)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := regexp.MustCompile(`/Type /Pages /Kids \[[^\]]*\] /Count (\d+)`).FindSubmatch(out)
+	if m == nil {
+		t.Fatal("no page tree")
+	}
+	if n, _ := strconv.Atoi(string(m[1])); n < 3 {
+		t.Fatalf("expected several pages, got %d", n)
+	}
+	if !bytes.Contains(out, []byte("/MediaBox [0 0 612 792]")) {
+		t.Fatal("page size not applied")
+	}
+}
+
+func TestExportPlacesNotes(t *testing.T) {
+	paras := make([]string, 60)
+	for i := range paras {
+		paras[i] = strings.Repeat("wrap these words across the line ", 6)
+	}
+	doc := textDoc(paras...)
+	anchor := func(b int, note uint64) {
+		tb := doc.Blocks[b].Text
+		attr := convert.DefaultAttr()
+		attr.Note = note
+		at := uint32(len(tb.UTF8))
+		tb.UTF8 = append(tb.UTF8, '1')
+		tb.Runs = append(tb.Runs, sqdoc.StyleRun{Start: at, End: at + 1, Attr: attr})
+	}
+	anchor(0, 100)
+	anchor(1, 101)
+	for id, kind := range map[uint64]sqdoc.NoteKind{100: sqdoc.NoteFootnote, 101: sqdoc.NoteEndnote} {
+		var bb convert.BlockBuilder
+		bb.WriteString("Note text", convert.DefaultAttr())
+		doc.Blocks = append(doc.Blocks, sqdoc.Block{ID: id, Kind: sqdoc.BlockKindNote, Note: kind, Text: bb.TextBlock()})
+	}
+	out, err := Export(doc, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pages [][]byte
+	for _, s := range streams(t, out) {
+		if bytes.Contains(s, []byte(" Tj ET")) {
+			pages = append(pages, s)
+		}
+	}
+	if len(pages) < 2 {
+		t.Fatalf("got %d pages", len(pages))
+	}
+	rule := []byte(" l S Q")
+	if !bytes.Contains(pages[0], rule) || !bytes.Contains(pages[len(pages)-1], rule) {
+		t.Fatal("no footnote rule on the first page or endnote rule on the last")
+	}
+	if n := bytes.Count(bytes.Join(pages, nil), rule); n != 2 {
+		t.Fatalf("drew %d rules", n)
+	}
+	if !bytes.Contains(pages[0], []byte(" 9 Tf ")) {
+		t.Fatal("anchors are not superscript")
+	}
+}
+
+func TestExportRejectsMarginsWiderThanPage(t *testing.T) {
+	if _, err := Export(textDoc("x"), Options{Margins: UniformMargins(300)}); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestExportEmbedsImagesWithAlpha(t *testing.T) {
+	dir := t.TempDir()
+	img := image.NewNRGBA(image.Rect(0, 0, 4, 2))
+	img.Set(0, 0, color.NRGBA{R: 255, A: 128})
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "a.png"), buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var bb convert.BlockBuilder
+	bb.WriteImage("a.png", 80, 40, convert.DefaultAttr())
+	bb.WriteImage("a.png", 0, 0, convert.DefaultAttr())
+	doc := sqdoc.NewDocument("", "")
+	doc.Blocks = append(doc.Blocks, sqdoc.Block{ID: 1, Kind: sqdoc.BlockKindText, Text: bb.TextBlock()})
+
+	out, err := Export(doc, Options{BaseDir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := bytes.Count(out, []byte("/Subtype /Image /Width 4 /Height 2 /ColorSpace /DeviceRGB")); got != 1 {
+		t.Fatalf("image written %d times", got)
+	}
+	if !bytes.Contains(out, []byte("/SMask")) {
+		t.Fatal("translucent image lost its alpha")
+	}
+}
+
+func TestSubsetKeepsOnlyUsedOutlines(t *testing.T) {
+	full, err := sfnt.Parse(fonts.SansRegular)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b sfnt.Buffer
+	a, _ := full.GlyphIndex(&b, 'A')
+	z, _ := full.GlyphIndex(&b, 'z')
+	aacute, _ := full.GlyphIndex(&b, 'Á')
+
+	out, err := subsetTrueType(fonts.SansRegular, map[uint16]bool{uint16(a): true, uint16(aacute): true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out) >= len(fonts.SansRegular)/4 {
+		t.Fatalf("subset is %d bytes of %d", len(out), len(fonts.SansRegular))
+	}
+	sub, err := sfnt.Parse(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sub.LoadGlyph(&b, aacute, 1<<6*12, nil); err != nil {
+		t.Fatalf("composite glyph lost a component: %v", err)
+	}
+	if tableChecksum(out) != 0xB1B0AFBA {
+		t.Fatal("checksum adjustment not applied")
+	}
+	tables, err := readTables(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loca := tables["loca"]
+	glyphLen := func(g sfnt.GlyphIndex) uint32 {
+		return be32(loca[4*int(g)+4:]) - be32(loca[4*int(g):])
+	}
+	if glyphLen(a) == 0 || glyphLen(aacute) == 0 || glyphLen(z) != 0 {
+		t.Fatalf("outline lengths A=%d Á=%d z=%d", glyphLen(a), glyphLen(aacute), glyphLen(z))
+	}
+}
+
+func be32(b []byte) uint32 {
+	return uint32(b[0])<<24 | uint32(b[1])<<16 | uint32(b[2])<<8 | uint32(b[3])
+}
+
+func TestExportLinkAnnotations(t *testing.T) {
+	web := convert.DefaultAttr()
+	web.Link = "https://example.com/ü"
+	internal := convert.DefaultAttr()
+	internal.Link = "#intro"
+	var bb convert.BlockBuilder
+	bb.WriteString("web", web)
+	bb.WriteString(" and ", convert.DefaultAttr())
+	bb.WriteString("back", internal)
+	doc := sqdoc.NewDocument("", "")
+	doc.Blocks = []sqdoc.Block{
+		{ID: 1, Kind: sqdoc.BlockKindText, Text: &sqdoc.TextBlock{UTF8: []byte("Intro"), Style: sqdoc.StyleHeading1}},
+		{ID: 2, Kind: sqdoc.BlockKindText, Text: bb.TextBlock()},
+	}
+	out, err := Export(doc, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := bytes.Count(out, []byte("/Subtype /Link")); n != 2 {
+		t.Fatalf("%d link annotations, want 2", n)
+	}
+	uri := fmt.Sprintf("/URI <%X>", "https://example.com/%C3%BC")
+	if !bytes.Contains(out, []byte(uri)) || !regexp.MustCompile(`/Dest \[\d+ 0 R /XYZ null [\d.]+ null\]`).Match(out) {
+		t.Fatal("link actions missing")
+	}
+}
+
+func TestExportKeepsPageBreaksWidowsAndOrphans(t *testing.T) {
+	// pageLines returns the number of lines on each page, one Tj each.
+	pageLines := func(doc *sqdoc.Document, opts Options) []int {
+		out, err := Export(doc, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var n []int
+		for _, s := range streams(t, out) {
+			if c := bytes.Count(s, []byte(" Tj ET")); c > 0 {
+				n = append(n, c)
+			}
+		}
+		return n
+	}
+	doc := textDoc("a\nb\nc\nd", "e\nf\ng")
+	doc.Metadata.ParagraphGap = 0
+	l := newLayouter(doc.Metadata, Options{})
+	lines, err := l.wrap(doc.Blocks[0].Text, l.contentWidth())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Pages hold five lines.
+	lineH := lines[0].ascent + lines[0].descent + lineGap
+	opts := Options{Page: PageSize{Width: 300, Height: 72 + 5*lineH - lineGap + 0.01}, Margins: UniformMargins(36)}
+
+	if got := pageLines(doc, opts); !slices.Equal(got, []int{4, 3}) {
+		t.Fatalf("orphan: lines per page = %v", got)
+	}
+	doc = textDoc("a\nb", "c\nd\ne\nf")
+	doc.Metadata.ParagraphGap = 0
+	if got := pageLines(doc, opts); !slices.Equal(got, []int{4, 2}) {
+		t.Fatalf("widow: lines per page = %v", got)
+	}
+	doc.Blocks[1].Text.Para.PageBreakBefore = true
+	if got := pageLines(doc, opts); !slices.Equal(got, []int{2, 4}) {
+		t.Fatalf("page break: lines per page = %v", got)
+	}
+
+	doc.Metadata.Page = sqdoc.PageSetup{Width: 792, Height: 612, Top: 36, Right: 36, Bottom: 36, Left: 36}
+	out, err := Export(doc, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(out, []byte("/MediaBox [0 0 792 612]")) {
+		t.Fatal("document page setup not applied")
+	}
+}
+
+func TestExportTablesBreakBetweenRowGroups(t *testing.T) {
+	// Every other row starts a cell spanning two rows, so each pair of rows
+	// holds three cells and must stay on one page.
+	table := sqdoc.NewTable(60, 2)
+	for r := range table.Rows {
+		for c := range table.Rows[r] {
+			var bb convert.BlockBuilder
+			bb.WriteString(fmt.Sprintf("r%dc%d", r, c), convert.DefaultAttr())
+			table.Rows[r][c].Text = bb.TextBlock()
+		}
+		if r%2 == 0 {
+			table.Rows[r][0].RowSpan = 2
+			table.Rows[r][0].Background = 0xDCE8F8FF
+		}
+	}
+	doc := sqdoc.NewDocument("", "")
+	doc.Blocks = append(doc.Blocks, sqdoc.Block{ID: 1, Kind: sqdoc.BlockKindTable, Table: table})
+	out, err := Export(doc, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var counts []int
+	for _, s := range streams(t, out) {
+		if c := bytes.Count(s, []byte(" Tj ET")); c > 0 {
+			counts = append(counts, c)
+			if !bytes.Contains(s, []byte("0.471 0.525 0.612 RG 0.5 w")) || !bytes.Contains(s, []byte("0.863 0.91 0.973 rg")) {
+				t.Fatal("page lacks cell borders or shading")
+			}
+		}
+	}
+	total := 0
+	for _, c := range counts {
+		if c%3 != 0 {
+			t.Fatalf("a row group split across pages: cells per page = %v", counts)
+		}
+		total += c
+	}
+	if len(counts) < 2 || total != 90 {
+		t.Fatalf("cells per page = %v, want 90 over several pages", counts)
+	}
+}
+
+func TestExportIndentsListItemsBehindMarkers(t *testing.T) {
+	doc := textDoc("plain", "item")
+	doc.Blocks[1].Text.List = sqdoc.ListItem{ID: 1, Level: 1, Style: sqdoc.ListDecimal}
+	out, err := Export(doc, Options{Margins: UniformMargins(72)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var xs []float64
+	for _, s := range streams(t, out) {
+		for _, m := range regexp.MustCompile(`Tf ([0-9.]+) [0-9.]+ Td`).FindAllSubmatch(s, -1) {
+			x, err := strconv.ParseFloat(string(m[1]), 64)
+			if err != nil {
+				t.Fatal(err)
+			}
+			xs = append(xs, x)
+		}
+	}
+	// The plain paragraph, the marker, then the item's text.
+	if len(xs) != 3 {
+		t.Fatalf("text positions = %v, want 3", xs)
+	}
+	indent := 72 + 2*float64(convert.ListIndentPt)
+	if xs[0] != 72 || xs[2] != indent || xs[1] <= 72 || xs[1] >= indent-markerGap {
+		t.Fatalf("text positions = %v, want 72, a marker before %v, then %v", xs, indent, indent)
+	}
+}
+
+func TestExportShadesAndPadsCodeBlocks(t *testing.T) {
+	doc := textDoc("plain", "a := 1\nb := 2")
+	doc.Blocks[1].Text.Code = &sqdoc.CodeBlock{Language: "go"}
+	out, err := Export(doc, Options{Margins: UniformMargins(72)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	page := streams(t, out)[0]
+	if !bytes.Contains(page, []byte(rgb(convert.CodeShadeRGBA, "rg"))) {
+		t.Fatalf("no code shading in:\n%s", page)
+	}
+	var xs []string
+	for _, m := range regexp.MustCompile(`Tf ([0-9.]+) [0-9.]+ Td`).FindAllSubmatch(page, -1) {
+		xs = append(xs, string(m[1]))
+	}
+	if want := "72|78|78"; strings.Join(xs, "|") != want {
+		t.Fatalf("text positions = %v, want %s", xs, want)
+	}
+	if !bytes.Contains(out, []byte("LiberationMono")) {
+		t.Fatal("code is not in the monospace face")
+	}
+}
+
+func TestExportFormatsParagraphs(t *testing.T) {
+	doc := textDoc("left\nnext", "tall\nnext", "indented", "right", "centered",
+		strings.Repeat("justified words fill every line but the last ", 12))
+	doc.Blocks[1].Text.Para.LineHeight = 200
+	doc.Blocks[2].Text.Para = sqdoc.ParagraphFormat{IndentLeft: 36, IndentFirst: 18}
+	doc.Blocks[3].Text.Para = sqdoc.ParagraphFormat{Align: sqdoc.AlignRight, IndentRight: 36}
+	doc.Blocks[4].Text.Para.Align = sqdoc.AlignCenter
+	doc.Blocks[5].Text.Para.Align = sqdoc.AlignJustify
+	out, err := Export(doc, Options{Margins: UniformMargins(72)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var xs, ys []float64
+	for _, m := range regexp.MustCompile(`Tf ([0-9.]+) ([0-9.]+) Td`).FindAllSubmatch(streams(t, out)[0], -1) {
+		x, _ := strconv.ParseFloat(string(m[1]), 64)
+		y, _ := strconv.ParseFloat(string(m[2]), 64)
+		xs, ys = append(xs, x), append(ys, y)
+	}
+	if len(xs) < 9 {
+		t.Fatalf("only %d text positions", len(xs))
+	}
+	if plain, tall := ys[0]-ys[1], ys[2]-ys[3]; math.Abs(tall-2*plain) > 0.01 {
+		t.Fatalf("line pitch %v at double height, %v plain", tall, plain)
+	}
+	if xs[4] != 126 {
+		t.Fatalf("indented paragraph starts at %v, want 126", xs[4])
+	}
+	right, centre := xs[5], xs[6]
+	if !(72 < centre && centre < right && right > 400 && right < 523-36) {
+		t.Fatalf("centred text at %v, right-aligned at %v", centre, right)
+	}
+	// Justified lines place each word on its own.
+	firstLine := 0
+	for _, y := range ys[7:] {
+		if y == ys[7] {
+			firstLine++
+		}
+	}
+	if firstLine < 4 {
+		t.Fatalf("justified first line drawn in %d pieces", firstLine)
+	}
+}
@@ -0,0 +1,206 @@
+package pdf
+
+import (
+	"encoding/binary"
+	"errors"
+	"sort"
+)
+
+// subsetTables are the TrueType tables a PDF viewer needs to render an
+// embedded CIDFontType2.
+var subsetTables = []string{"cmap", "cvt ", "fpgm", "glyf", "head", "hhea", "hmtx", "loca", "maxp", "post", "prep"}
+
+var errBadFont = errors.New("pdf: malformed TrueType font")
+
+// subsetTrueType keeps the outlines of the glyphs in keep, .notdef and any
+// components they reference, and empties every other glyph. Glyph IDs are
+// unchanged so the Identity CIDToGIDMap still applies.
+func subsetTrueType(src []byte, keep map[uint16]bool) ([]byte, error) {
+	tables, err := readTables(src)
+	if err != nil {
+		return nil, err
+	}
+	head, loca, glyf, maxp := tables["head"], tables["loca"], tables["glyf"], tables["maxp"]
+	if len(head) < 54 || len(maxp) < 6 || glyf == nil || loca == nil {
+		return nil, errBadFont
+	}
+	numGlyphs := int(binary.BigEndian.Uint16(maxp[4:]))
+	longLoca := binary.BigEndian.Uint16(head[50:]) == 1
+
+	offsets := make([]uint32, numGlyphs+1)
+	for i := range offsets {
+		if longLoca {
+			if 4*i+4 > len(loca) {
+				return nil, errBadFont
+			}
+			offsets[i] = binary.BigEndian.Uint32(loca[4*i:])
+		} else {
+			if 2*i+2 > len(loca) {
+				return nil, errBadFont
+			}
+			offsets[i] = uint32(binary.BigEndian.Uint16(loca[2*i:])) * 2
+		}
+		if offsets[i] > uint32(len(glyf)) || (i > 0 && offsets[i] < offsets[i-1]) {
+			return nil, errBadFont
+		}
+	}
+	glyph := func(g int) []byte { return glyf[offsets[g]:offsets[g+1]] }
+
+	want := map[int]bool{0: true}
+	queue := []int{0}
+	for g := range keep {
+		if int(g) < numGlyphs && !want[int(g)] {
+			want[int(g)] = true
+			queue = append(queue, int(g))
+		}
+	}
+	for len(queue) > 0 {
+		g := queue[len(queue)-1]
+		queue = queue[:len(queue)-1]
+		for _, c := range glyphComponents(glyph(g)) {
+			if int(c) < numGlyphs && !want[int(c)] {
+				want[int(c)] = true
+				queue = append(queue, int(c))
+			}
+		}
+	}
+
+	var newGlyf []byte
+	newLoca := make([]byte, 4*(numGlyphs+1))
+	for g := 0; g < numGlyphs; g++ {
+		binary.BigEndian.PutUint32(newLoca[4*g:], uint32(len(newGlyf)))
+		if want[g] {
+			newGlyf = append(newGlyf, glyph(g)...)
+			for len(newGlyf)%4 != 0 {
+				newGlyf = append(newGlyf, 0)
+			}
+		}
+	}
+	binary.BigEndian.PutUint32(newLoca[4*numGlyphs:], uint32(len(newGlyf)))
+
+	newHead := append([]byte(nil), head...)
+	binary.BigEndian.PutUint32(newHead[8:], 0)
+	binary.BigEndian.PutUint16(newHead[50:], 1)
+
+	out := map[string][]byte{}
+	for _, tag := range subsetTables {
+		if t, ok := tables[tag]; ok {
+			out[tag] = t
+		}
+	}
+	if post := tables["post"]; len(post) >= 32 {
+		// Version 3 drops the glyph name list.
+		newPost := append([]byte(nil), post[:32]...)
+		binary.BigEndian.PutUint32(newPost, 0x00030000)
+		out["post"] = newPost
+	}
+	out["head"] = newHead
+	out["loca"] = newLoca
+	out["glyf"] = newGlyf
+	return writeTables(out), nil
+}
+
+func readTables(src []byte) (map[string][]byte, error) {
+	if len(src) < 12 {
+		return nil, errBadFont
+	}
+	n := int(binary.BigEndian.Uint16(src[4:]))
+	if len(src) < 12+16*n {
+		return nil, errBadFont
+	}
+	tables := make(map[string][]byte, n)
+	for i := 0; i < n; i++ {
+		rec := src[12+16*i:]
+		off := binary.BigEndian.Uint32(rec[8:])
+		length := binary.BigEndian.Uint32(rec[12:])
+		if uint64(off)+uint64(length) > uint64(len(src)) {
+			return nil, errBadFont
+		}
+		tables[string(rec[:4])] = src[off : off+length]
+	}
+	return tables, nil
+}
+
+// glyphComponents lists the glyphs a composite glyph is built from.
+func glyphComponents(g []byte) []uint16 {
+	if len(g) < 10 || int16(binary.BigEndian.Uint16(g)) >= 0 {
+		return nil
+	}
+	var out []uint16
+	p := 10
+	for p+4 <= len(g) {
+		flags := binary.BigEndian.Uint16(g[p:])
+		out = append(out, binary.BigEndian.Uint16(g[p+2:]))
+		p += 4
+		if flags&0x0001 != 0 {
+			p += 4
+		} else {
+			p += 2
+		}
+		switch {
+		case flags&0x0008 != 0:
+			p += 2
+		case flags&0x0040 != 0:
+			p += 4
+		case flags&0x0080 != 0:
+			p += 8
+		}
+		if flags&0x0020 == 0 {
+			break
+		}
+	}
+	return out
+}
+
+func writeTables(tables map[string][]byte) []byte {
+	tags := make([]string, 0, len(tables))
+	for tag := range tables {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+
+	n := len(tags)
+	entrySelector := 0
+	for 1<<(entrySelector+1) <= n {
+		entrySelector++
+	}
+	searchRange := (1 << entrySelector) * 16
+
+	out := make([]byte, 12+16*n)
+	binary.BigEndian.PutUint32(out[0:], 0x00010000)
+	binary.BigEndian.PutUint16(out[4:], uint16(n))
+	binary.BigEndian.PutUint16(out[6:], uint16(searchRange))
+	binary.BigEndian.PutUint16(out[8:], uint16(entrySelector))
+	binary.BigEndian.PutUint16(out[10:], uint16(n*16-searchRange))
+
+	headAt := -1
+	for i, tag := range tags {
+		data := tables[tag]
+		rec := out[12+16*i:]
+		copy(rec, tag)
+		binary.BigEndian.PutUint32(rec[4:], tableChecksum(data))
+		binary.BigEndian.PutUint32(rec[8:], uint32(len(out)))
+		binary.BigEndian.PutUint32(rec[12:], uint32(len(data)))
+		if tag == "head" {
+			headAt = len(out)
+		}
+		out = append(out, data...)
+		for len(out)%4 != 0 {
+			out = append(out, 0)
+		}
+	}
+	if headAt >= 0 {
+		binary.BigEndian.PutUint32(out[headAt+8:], 0xB1B0AFBA-tableChecksum(out))
+	}
+	return out
+}
+
+func tableChecksum(data []byte) uint32 {
+	var sum uint32
+	for i := 0; i < len(data); i += 4 {
+		var word [4]byte
+		copy(word[:], data[i:])
+		sum += binary.BigEndian.Uint32(word[:])
+	}
+	return sum
+}
@@ -0,0 +1,149 @@
+package pdf
+
+import (
+	"fmt"
+
+	"sqdoc/internal/render"
+	"sqdoc/pkg/convert"
+	"sqdoc/pkg/sqdoc"
+)
+
+// laidCell is a table cell wrapped into lines, placed relative to the left
+// margin and the top of its row group.
+type laidCell struct {
+	x, y, w, h float64
+	lines      []textLine
+	shade      uint32
+	borders    sqdoc.Border
+}
+
+// table wraps t and queues it as one block per row group: rows joined by a
+// cell spanning them move to the next page together, others break between
+// them. Rows are sized as in the editor, as tall as their tallest cell,
+// with a cell spanning rows growing the last of them.
+func (l *layouter) table(t *sqdoc.TableBlock) error {
+	colX := []float64{0}
+	for _, w := range t.ColumnWidths(l.contentWidth()) {
+		colX = append(colX, colX[len(colX)-1]+w)
+	}
+	const pad = convert.CellPaddingPt
+	rows := convert.TableRows(t)
+	cells := make([][]laidCell, len(rows))
+	rowH := make([]float64, len(rows))
+	for r, row := range rows {
+		cells[r] = make([]laidCell, len(row))
+		for c, cell := range row {
+			if cell.Covered {
+				continue
+			}
+			tb := t.Rows[r][c].Text
+			if tb == nil {
+				tb = &sqdoc.TextBlock{}
+			}
+			w := colX[c+cell.ColSpan] - colX[c]
+			lines, err := l.wrap(tb, w-2*pad)
+			if err != nil {
+				return err
+			}
+			h := 2.0 * pad
+			for _, ln := range lines {
+				h += ln.ascent + ln.descent + lineGap
+			}
+			cells[r][c] = laidCell{x: colX[c], w: w, h: h, lines: lines, shade: cell.Shade(), borders: cell.Borders}
+			if cell.RowSpan == 1 {
+				rowH[r] = max(rowH[r], h)
+			}
+		}
+	}
+	for r, row := range rows {
+		for c, cell := range row {
+			if cell.Covered {
+				continue
+			}
+			sum := 0.0
+			for k := r; k < r+cell.RowSpan; k++ {
+				sum += rowH[k]
+			}
+			if h := cells[r][c].h; h > sum {
+				rowH[r+cell.RowSpan-1] += h - sum
+			}
+		}
+	}
+
+	for start := 0; start < len(rows); {
+		end := start + 1
+		for r := start; r < end; r++ {
+			for _, cell := range rows[r] {
+				if !cell.Covered {
+					end = max(end, r+cell.RowSpan)
+				}
+			}
+		}
+		lb := laidBlock{notes: make([][]*footnote, 1)}
+		top, noteH := 0.0, 0.0
+		for r := start; r < end; r++ {
+			for c, cell := range rows[r] {
+				if cell.Covered {
+					continue
+				}
+				lc := cells[r][c]
+				lc.y = top
+				lc.h = 0
+				for k := r; k < r+cell.RowSpan; k++ {
+					lc.h += rowH[k]
+				}
+				for _, ln := range lc.lines {
+					for _, fn := range l.newFootnotes(ln) {
+						lb.notes[0] = append(lb.notes[0], fn)
+						noteH += fn.height
+					}
+				}
+				lb.cells = append(lb.cells, lc)
+			}
+			top += rowH[r]
+		}
+		lb.box = render.PageBlock{Lines: []float64{top}, Notes: []float64{noteH}}
+		if end == len(rows) {
+			lb.box.After = float64(l.meta.ParagraphGap)
+		}
+		l.blocks = append(l.blocks, lb)
+		start = end
+	}
+	return nil
+}
+
+// drawCells draws a row group whose top is top points below the top of the
+// page: shading first, then text, then borders over both.
+func (l *layouter) drawCells(cells []laidCell, top float64) {
+	left := l.opts.Margins.Left
+	for _, c := range cells {
+		if c.shade != 0 {
+			fmt.Fprintf(l.page, "q %s%s %s %s %s %s re f Q\n", l.alphaState(c.shade), rgb(c.shade, "rg"),
+				num(left+c.x), num(l.opts.Page.Height-top-c.y-c.h), num(c.w), num(c.h))
+		}
+	}
+	for _, c := range cells {
+		y := top + c.y + convert.CellPaddingPt
+		for _, ln := range c.lines {
+			l.drawLine(ln.pieces, left+c.x+convert.CellPaddingPt, l.opts.Page.Height-y-ln.ascent, ln.ascent, ln.descent, 0)
+			y += ln.ascent + ln.descent + lineGap
+		}
+	}
+	for _, c := range cells {
+		x0, x1 := left+c.x, left+c.x+c.w
+		y0, y1 := l.opts.Page.Height-top-c.y, l.opts.Page.Height-top-c.y-c.h
+		for _, side := range []struct {
+			border         sqdoc.Border
+			ax, ay, bx, by float64
+		}{
+			{sqdoc.BorderTop, x0, y0, x1, y0},
+			{sqdoc.BorderRight, x1, y0, x1, y1},
+			{sqdoc.BorderBottom, x0, y1, x1, y1},
+			{sqdoc.BorderLeft, x0, y0, x0, y1},
+		} {
+			if c.borders&side.border != 0 {
+				fmt.Fprintf(l.page, "q %s 0.5 w %s %s m %s %s l S Q\n", rgb(convert.TableBorderRGBA, "RG"), num(side.ax), num(side.ay), num(side.bx), num(side.by))
+			}
+		}
+	}
+}
@@ -0,0 +1,415 @@
+package rtf
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"sqdoc/pkg/convert"
+	"sqdoc/pkg/sqdoc"
+)
+
+// Font table entries, indexed by sqdoc.FontFamily.
+var fontTable = [...]struct{ class, name string }{
+	sqdoc.FontFamilySans:      {"fswiss", "Arial"},
+	sqdoc.FontFamilySerif:     {"froman", "Times New Roman"},
+	sqdoc.FontFamilyMonospace: {"fmodern", "Courier New"},
+}
+
+// highlightIndex is the colour table slot of the highlight colour; text
+// colours follow it.
+const highlightIndex = 1
+
+// codeStyle is the stylesheet entry of code blocks.
+const codeStyle = 1
+
+type exporter struct {
+	opts   Options
+	colors []uint32
+	body   bytes.Buffer
+	// code is set once a code block has been written, which puts its style
+	// in the stylesheet.
+	code bool
+}
+
+func Export(doc *sqdoc.Document, opts Options) ([]byte, error) {
+	if doc == nil {
+		return nil, errors.New("rtf: document is nil")
+	}
+	ex := &exporter{opts: opts}
+	anchors := convert.BlockAnchors(doc)
+	notes := convert.NewNoteSet(doc)
+	markers := doc.ListMarkers()
+	deff := doc.Metadata.PreferredFontFamily
+	if deff > sqdoc.FontFamilyMonospace {
+		deff = sqdoc.FontFamilySans
+	}
+	// A table ends its last paragraph with \row, so only paragraphs need a
+	// \par before whatever follows them.
+	needPar := false
+	for i, b := range doc.Blocks {
+		if b.Kind == sqdoc.BlockKindTable && b.Table != nil {
+			if needPar {
+				ex.body.WriteString("\\par\n")
+			}
+			ex.table(b.Table, float64(doc.Metadata.Page.TextWidth()), notes)
+			needPar = false
+			continue
+		}
+		if b.Kind != sqdoc.BlockKindText || b.Text == nil {
+			continue
+		}
+		if needPar {
+			ex.body.WriteString("\\par\n")
+		}
+		needPar = true
+		para := convert.ParaFormat(b.Text)
+		fmt.Fprintf(&ex.body, "\\pard\\sa%d", (int(doc.Metadata.ParagraphGap)+int(para.SpaceAfter))*20)
+		spans := convert.Spans(b)
+		if b.Text.Code != nil {
+			ex.code = true
+			fmt.Fprintf(&ex.body, "\\s%d\\keep\\cbpat%d", codeStyle, ex.color(convert.CodeShadeRGBA))
+			spans = convert.CodeSpans(b)
+		}
+		// List items hang their marker, written as text, in the indent of
+		// their level.
+		list := 0
+		if markers[i] != "" {
+			list = convert.ListIndentPt * (min(int(b.Text.List.Level), sqdoc.MaxListLevel) + 1)
+		}
+		ex.body.WriteString(paragraphLayout(para, list))
+		for _, name := range anchors[b.ID] {
+			fmt.Fprintf(&ex.body, "{\\*\\bkmkstart %s}{\\*\\bkmkend %s}", escape(name), escape(name))
+		}
+		if markers[i] != "" {
+			ex.span(convert.Span{Text: markers[i] + "\t", Attr: convert.MarkerAttr(spans)})
+		}
+		ex.spans(spans, notes)
+	}
+
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "{\\rtf1\\ansi\\ansicpg1252\\deff%d\\uc1\n{\\fonttbl", deff)
+	for i, f := range fontTable {
+		fmt.Fprintf(&out, "{\\f%d\\%s\\fcharset0 %s;}", i, f.class, f.name)
+	}
+	out.WriteString("}\n{\\colortbl;\\red255\\green244\\blue168;")
+	for _, c := range ex.colors {
+		fmt.Fprintf(&out, "\\red%d\\green%d\\blue%d;", c>>24, c>>16&0xFF, c>>8&0xFF)
+	}
+	out.WriteString("}\n")
+	if ex.code {
+		fmt.Fprintf(&out, "{\\stylesheet{\\s0 Normal;}{\\s%d\\keep\\cbpat%d\\f%d Source Code;}}\n", codeStyle, ex.color(convert.CodeShadeRGBA), sqdoc.FontFamilyMonospace)
+	}
+	out.WriteString("{\\*\\generator SIDE;}\n")
+	out.WriteString(infoGroup(doc.Metadata))
+	if len(notes.List) > 0 {
+		// Footnotes at the foot of the page, endnotes at the end numbered
+		// i, ii, iii.
+		out.WriteString("\\fet2\\ftnbj\\aenddoc\\aftnnrlc\n")
+	}
+	out.Write(ex.body.Bytes())
+	out.WriteString("}\n")
+	return out.Bytes(), nil
+}
+
+// paragraphLayout returns the control words for the alignment, indents,
+// space before and line height of paragraph format p. A list item's indent
+// of list points is added to its own, with the marker hanging in it.
+func paragraphLayout(p sqdoc.ParagraphFormat, list int) string {
+	var b strings.Builder
+	switch p.Align {
+	case sqdoc.AlignCenter:
+		b.WriteString("\\qc")
+	case sqdoc.AlignRight:
+		b.WriteString("\\qr")
+	case sqdoc.AlignJustify:
+		b.WriteString("\\qj")
+	}
+	if left := int(p.IndentLeft) + list; left != 0 {
+		fmt.Fprintf(&b, "\\li%d", left*20)
+	}
+	if p.IndentRight != 0 {
+		fmt.Fprintf(&b, "\\ri%d", int(p.IndentRight)*20)
+	}
+	first := int(p.IndentFirst)
+	if list > 0 {
+		first -= convert.ListIndentPt
+	}
+	if first != 0 {
+		fmt.Fprintf(&b, "\\fi%d", first*20)
+	}
+	if p.SpaceBefore != 0 {
+		fmt.Fprintf(&b, "\\sb%d", int(p.SpaceBefore)*20)
+	}
+	if p.LineHeight != 0 {
+		fmt.Fprintf(&b, "\\sl%d\\slmult1", 240*int(p.LineHeight)/100)
+	}
+	if p.PageBreakBefore {
+		b.WriteString("\\pagebb")
+	}
+	return b.String()
+}
+
+// spans writes the text of a paragraph or cell with its links and notes.
+func (ex *exporter) spans(spans []convert.Span, notes *convert.NoteSet) {
+	link := ""
+	for _, s := range spans {
+		if n, first := notes.Anchor(s); n != nil {
+			ex.switchLink(link, "")
+			link = ""
+			ex.note(s, n, first)
+			continue
+		}
+		if s.Attr.Link != link {
+			ex.switchLink(link, s.Attr.Link)
+			link = s.Attr.Link
+		}
+		ex.span(s)
+	}
+	ex.switchLink(link, "")
+}
+
+// table writes t across width points, one \trowd row definition per row.
+// A cell spanning columns reaches the right edge of the last of them and
+// one spanning rows is continued by empty \clvmrg cells below it.
+func (ex *exporter) table(t *sqdoc.TableBlock, width float64, notes *convert.NoteSet) {
+	var edges []int
+	x := 0.0
+	for _, w := range t.ColumnWidths(width) {
+		x += w
+		edges = append(edges, int(x*20))
+	}
+	rows := convert.TableRows(t)
+	for r, row := range rows {
+		fmt.Fprintf(&ex.body, "\\trowd\\trgaph%d\\trleft0", convert.CellPaddingPt*20)
+		if r < int(t.HeaderRows) {
+			ex.body.WriteString("\\trhdr")
+		}
+		var cells []convert.TableCell
+		for c := 0; c < len(row); {
+			cell := row[c]
+			merge := ""
+			switch {
+			case cell.Covered && cell.OwnerCol == c && cell.OwnerRow < r:
+				cell, merge = rows[cell.OwnerRow][cell.OwnerCol], "\\clvmrg"
+				cell.Spans = nil
+			case cell.Covered:
+				c++
+				continue
+			case cell.RowSpan > 1:
+				merge = "\\clvmgf"
+			}
+			ex.body.WriteString(merge)
+			for _, side := range []struct {
+				border sqdoc.Border
+				word   string
+			}{{sqdoc.BorderTop, "t"}, {sqdoc.BorderLeft, "l"}, {sqdoc.BorderBottom, "b"}, {sqdoc.BorderRight, "r"}} {
+				if cell.Borders&side.border != 0 {
+					fmt.Fprintf(&ex.body, "\\clbrdr%s\\brdrs\\brdrw10\\brdrcf%d", side.word, ex.color(convert.TableBorderRGBA))
+				}
+			}
+			if shade := cell.Shade(); shade != 0 {
+				fmt.Fprintf(&ex.body, "\\clcbpat%d", ex.color(shade))
+			}
+			fmt.Fprintf(&ex.body, "\\cellx%d", edges[c+cell.ColSpan-1])
+			cells = append(cells, cell)
+			c += cell.ColSpan
+		}
+		ex.body.WriteString("\n")
+		for _, cell := range cells {
+			ex.body.WriteString("\\pard\\intbl\\sa0 ")
+			ex.spans(cell.Spans, notes)
+			ex.body.WriteString("\\cell\n")
+		}
+		ex.body.WriteString("\\row\n")
+	}
+}
+
+func infoGroup(m sqdoc.Metadata) string {
+	var b strings.Builder
+	b.WriteString("{\\info")
+	if m.Title != "" {
+		b.WriteString("{\\title " + escape(m.Title) + "}")
+	}
+	if m.Author != "" {
+		b.WriteString("{\\author " + escape(m.Author) + "}")
+	}
+	if m.CreatedUnix != 0 {
+		b.WriteString("{\\creatim" + rtfTime(m.CreatedUnix) + "}")
+	}
+	if m.ModifiedUnix != 0 {
+		b.WriteString("{\\revtim" + rtfTime(m.ModifiedUnix) + "}")
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+func rtfTime(unix int64) string {
+	t := time.Unix(unix, 0).UTC()
+	return fmt.Sprintf("\\yr%d\\mo%d\\dy%d\\hr%d\\min%d\\sec%d", t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second())
+}
+
+func (ex *exporter) color(rgba uint32) int {
+	for i, c := range ex.colors {
+		if c == rgba|0xFF {
+			return highlightIndex + 1 + i
+		}
+	}
+	ex.colors = append(ex.colors, rgba|0xFF)
+	return highlightIndex + len(ex.colors)
+}
+
+// switchLink closes the HYPERLINK field for the link from and opens one for
+// to; the spans between them are the field result.
+func (ex *exporter) switchLink(from, to string) {
+	if from != "" {
+		ex.body.WriteString("}}")
+	}
+	if to == "" {
+		return
+	}
+	inst := `HYPERLINK "` + strings.ReplaceAll(to, `"`, "%22") + `"`
+	if name, ok := sqdoc.LinkAnchor(to); ok {
+		inst = `HYPERLINK \l "` + strings.ReplaceAll(name, `"`, "%22") + `"`
+	}
+	fmt.Fprintf(&ex.body, "{\\field{\\*\\fldinst %s}{\\fldrslt ", escape(inst))
+}
+
+// span writes one group holding the complete character formatting of s.
+func (ex *exporter) span(s convert.Span) {
+	ex.open(s.Attr)
+	if s.Image != nil {
+		ex.picture(s)
+	} else {
+		ex.body.WriteString(" " + escape(s.Text))
+	}
+	ex.body.WriteString("}")
+}
+
+// note writes the first anchor to n as an automatic reference mark followed
+// by the note, and later ones as its number.
+func (ex *exporter) note(s convert.Span, n *convert.Note, first bool) {
+	ex.open(s.Attr)
+	if !first {
+		ex.body.WriteString(" " + escape(n.Number) + "}")
+		return
+	}
+	ex.body.WriteString("\\chftn}{\\footnote")
+	if n.Kind == sqdoc.NoteEndnote {
+		ex.body.WriteString("\\ftnalt")
+	}
+	ex.body.WriteString("\\pard\\plain")
+	ex.open(s.Attr)
+	ex.body.WriteString("\\chftn} ")
+	for _, ns := range n.Spans {
+		ex.span(ns)
+	}
+	ex.body.WriteString("}")
+}
+
+// open starts a group with the complete character formatting of a. Note
+// anchors are superscript.
+func (ex *exporter) open(a sqdoc.StyleAttr) {
+	family := a.FontFamily
+	if family > sqdoc.FontFamilyMonospace {
+		family = sqdoc.FontFamilySans
+	}
+	size := a.FontSizePt
+	if size == 0 {
+		size = convert.DefaultAttr().FontSizePt
+	}
+	color := a.ColorRGBA
+	if color == 0 {
+		color = convert.DefaultAttr().ColorRGBA
+	}
+	fmt.Fprintf(&ex.body, "{\\f%d\\fs%d\\cf%d", family, int(size)*2, ex.color(color))
+	if a.Bold {
+		ex.body.WriteString("\\b")
+	}
+	if a.Italic {
+		ex.body.WriteString("\\i")
+	}
+	if a.Underline {
+		ex.body.WriteString("\\ul")
+	}
+	if a.Highlight {
+		fmt.Fprintf(&ex.body, "\\highlight%d", highlightIndex)
+	}
+	if a.Note != 0 {
+		ex.body.WriteString("\\super")
+	}
+}
+
+func (ex *exporter) picture(s convert.Span) {
+	img, err := convert.LoadEmbeddedImage(s.Image.Path, ex.opts.BaseDir)
+	if err == nil && img.Format == "gif" {
+		err = reencodePNG(img)
+	}
+	if err != nil {
+		ex.body.WriteString(" " + escape("[missing image: "+filepath.Base(s.Image.Path)+"]"))
+		return
+	}
+	w, h := convert.ImageDisplaySize(img.Width, img.Height, s.Image.Width, s.Image.Height, s.Attr.FontSizePt)
+	fmt.Fprintf(&ex.body, "{\\pict\\%sblip\\picw%d\\pich%d\\picwgoal%d\\pichgoal%d\n",
+		img.Format, img.Width, img.Height, w*twipsPerPixel, h*twipsPerPixel)
+	data := hex.EncodeToString(img.Data)
+	for len(data) > 128 {
+		ex.body.WriteString(data[:128] + "\n")
+		data = data[128:]
+	}
+	ex.body.WriteString(data + "}")
+}
+
+// reencodePNG converts a GIF, which RTF has no blip type for.
+func reencodePNG(img *convert.EmbeddedImage) error {
+	decoded, _, err := image.Decode(bytes.NewReader(img.Data))
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, decoded); err != nil {
+		return err
+	}
+	img.Data, img.Format = buf.Bytes(), "png"
+	return nil
+}
+
+// escape quotes RTF syntax characters and writes everything outside ASCII
+// as \u with a '?' fallback for readers that ignore it.
+func escape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '\\' || r == '{' || r == '}':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r == '\t':
+			b.WriteString("\\tab ")
+		case r == '\n':
+			b.WriteString("\\line ")
+		case r == '\r':
+		case r < 0x20:
+		case r < 0x80:
+			b.WriteRune(r)
+		case r > 0xFFFF:
+			r -= 0x10000
+			writeUnicode(&b, 0xD800+(r>>10))
+			writeUnicode(&b, 0xDC00+(r&0x3FF))
+		default:
+			writeUnicode(&b, r)
+		}
+	}
+	return b.String()
+}
+
+// writeUnicode writes \uN? with N as the signed 16-bit value RTF expects.
+func writeUnicode(b *strings.Builder, r rune) {
+	b.WriteString("\\u" + strconv.Itoa(int(int16(uint16(r)))) + "?")
+}
@@ -0,0 +1,642 @@
+package rtf
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"sqdoc/pkg/convert"
+	"sqdoc/pkg/sqdoc"
+)
+
+var errNotRTF = errors.New("rtf: missing {\\rtf header")
+
+type destination uint8
+
+const (
+	destText destination = iota
+	destSkip
+	destFontTable
+	destColorTable
+	destInfo
+	destTitle
+	destAuthor
+	destCreated
+	destRevised
+	destPict
+	destFieldInst
+)
+
+type groupState struct {
+	attr sqdoc.StyleAttr
+	uc   int
+	dest destination
+	// owner marks the group that opened dest, which finishes it on close.
+	owner bool
+}
+
+type pictState struct {
+	format         string
+	w, h           int
+	wGoal, hGoal   int
+	scaleX, scaleY int
+	hex            strings.Builder
+	raw            []byte
+}
+
+type importer struct {
+	opts   Options
+	src    []byte
+	pos    int
+	stack  []groupState
+	st     groupState
+	fonts  map[int]sqdoc.FontFamily
+	deff   int
+	colors []uint32
+
+	// Table entries and info fields being read.
+	font      int
+	fontClass sqdoc.FontFamily
+	hasClass  bool
+	text      strings.Builder
+	rgb       [3]int
+	date      map[string]int
+	pict      *pictState
+
+	skipChars int
+	high      rune
+	gap       int
+	bb        convert.BlockBuilder
+	openPara  bool
+	doc       *convert.DocBuilder
+	meta      sqdoc.Metadata
+	pictures  int
+	warnings  []string
+	warned    map[string]bool
+}
+
+func Import(src []byte, opts Options) (*sqdoc.Document, []string, error) {
+	trimmed := bytes.TrimLeft(src, " \t\r\n\xef\xbb\xbf")
+	if !bytes.HasPrefix(trimmed, []byte("{\\rtf")) {
+		return nil, nil, errNotRTF
+	}
+	im := &importer{
+		opts:   opts,
+		src:    trimmed,
+		fonts:  map[int]sqdoc.FontFamily{},
+		gap:    -1,
+		doc:    convert.NewDocBuilder(""),
+		warned: map[string]bool{},
+	}
+	im.st = groupState{attr: convert.DefaultAttr(), uc: 1}
+	if err := im.parse(); err != nil {
+		return nil, im.warnings, fmt.Errorf("rtf: %w", err)
+	}
+	if im.openPara || im.bb.Len() > 0 {
+		im.endParagraph()
+	}
+	doc := im.doc.Document()
+	doc.Metadata.Title = im.meta.Title
+	doc.Metadata.Author = im.meta.Author
+	if im.meta.CreatedUnix != 0 {
+		doc.Metadata.CreatedUnix = im.meta.CreatedUnix
+	}
+	if im.meta.ModifiedUnix != 0 {
+		doc.Metadata.ModifiedUnix = im.meta.ModifiedUnix
+	}
+	if family, ok := im.fonts[im.deff]; ok {
+		doc.Metadata.PreferredFontFamily = family
+	}
+	if im.gap >= 0 {
+		doc.Metadata.ParagraphGap = uint16(min(im.gap, 0xFFFF))
+	}
+	return doc, im.warnings, nil
+}
+
+func (im *importer) warn(format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	if im.warned[msg] {
+		return
+	}
+	im.warned[msg] = true
+	im.warnings = append(im.warnings, msg)
+}
+
+func (im *importer) parse() error {
+	for im.pos < len(im.src) {
+		c := im.src[im.pos]
+		im.pos++
+		switch c {
+		case '{':
+			im.stack = append(im.stack, im.st)
+			im.st.owner = false
+		case '}':
+			if len(im.stack) == 0 {
+				return nil
+			}
+			if im.st.owner {
+				im.finish(im.st.dest)
+			}
+			im.st = im.stack[len(im.stack)-1]
+			im.stack = im.stack[:len(im.stack)-1]
+			if len(im.stack) == 0 {
+				return nil
+			}
+		case '\\':
+			if err := im.control(); err != nil {
+				return err
+			}
+		case '\r', '\n':
+		default:
+			im.char(rune(c))
+		}
+	}
+	return errors.New("unexpected end of input")
+}
+
+// control reads the control word or symbol after a backslash.
+func (im *importer) control() error {
+	if im.pos >= len(im.src) {
+		return errors.New("unexpected end of input")
+	}
+	c := im.src[im.pos]
+	if !isLetter(c) {
+		im.pos++
+		switch c {
+		case '\\', '{', '}':
+			im.char(rune(c))
+		case '~':
+			im.char(' ')
+		case '_':
+			im.char('-')
+		case '\'':
+			if im.pos+2 > len(im.src) {
+				return errors.New("truncated \\' escape")
+			}
+			b, err := strconv.ParseUint(string(im.src[im.pos:im.pos+2]), 16, 8)
+			if err != nil {
+				return fmt.Errorf("bad \\' escape %q", im.src[im.pos:im.pos+2])
+			}
+			im.pos += 2
+			im.char(cp1252(byte(b)))
+		case '*':
+			im.starred()
+		case '\r', '\n':
+			im.word("par", 0, false)
+		}
+		return nil
+	}
+	start := im.pos
+	for im.pos < len(im.src) && isLetter(im.src[im.pos]) {
+		im.pos++
+	}
+	word := string(im.src[start:im.pos])
+	paramStart := im.pos
+	if im.pos < len(im.src) && im.src[im.pos] == '-' {
+		im.pos++
+	}
+	for im.pos < len(im.src) && im.src[im.pos] >= '0' && im.src[im.pos] <= '9' {
+		im.pos++
+	}
+	param, hasParam := 0, im.pos > paramStart
+	if hasParam {
+		n, err := strconv.Atoi(string(im.src[paramStart:im.pos]))
+		if err != nil {
+			return fmt.Errorf("bad parameter for \\%s", word)
+		}
+		param = n
+	}
+	if im.pos < len(im.src) && im.src[im.pos] == ' ' {
+		im.pos++
+	}
+	if word == "bin" {
+		end := min(im.pos+max(param, 0), len(im.src))
+		if im.st.dest == destPict {
+			im.pict.raw = append(im.pict.raw, im.src[im.pos:end]...)
+		}
+		im.pos = end
+		return nil
+	}
+	im.word(word, param, hasParam)
+	return nil
+}
+
+func isLetter(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// starred handles an ignorable destination: skipped unless it is one of
+// the few that carry content this importer reads.
+func (im *importer) starred() {
+	save := im.pos
+	for im.pos < len(im.src) && (im.src[im.pos] == ' ' || im.src[im.pos] == '\r' || im.src[im.pos] == '\n') {
+		im.pos++
+	}
+	if bytes.HasPrefix(im.src[im.pos:], []byte("\\shppict")) || bytes.HasPrefix(im.src[im.pos:], []byte("\\fldinst")) {
+		im.pos = save
+		return
+	}
+	im.enter(destSkip)
+}
+
+func (im *importer) enter(dest destination) {
+	if im.st.dest == destSkip {
+		return
+	}
+	im.st.dest = dest
+	im.st.owner = true
+	switch dest {
+	case destTitle, destAuthor, destFieldInst:
+		im.text.Reset()
+	case destCreated, destRevised:
+		im.date = map[string]int{}
+	case destPict:
+		im.pict = &pictState{scaleX: 100, scaleY: 100}
+	case destFontTable:
+		im.resetFont()
+	case destColorTable:
+		im.rgb = [3]int{-1, -1, -1}
+	}
+}
+
+func (im *importer) resetFont() {
+	im.text.Reset()
+	im.hasClass = false
+	im.fontClass = sqdoc.FontFamilySans
+}
+
+// finish completes a destination when its group closes.
+func (im *importer) finish(dest destination) {
+	switch dest {
+	case destTitle:
+		im.meta.Title = strings.TrimSpace(im.text.String())
+	case destAuthor:
+		im.meta.Author = strings.TrimSpace(im.text.String())
+	case destCreated:
+		im.meta.CreatedUnix = im.dateValue()
+	case destRevised:
+		im.meta.ModifiedUnix = im.dateValue()
+	case destFontTable:
+		if strings.TrimSpace(im.text.String()) != "" {
+			im.addFont()
+		}
+	case destPict:
+		im.addPicture()
+	case destFieldInst:
+		// The instruction group closes inside its field group, whose
+		// result then carries the link.
+		if link := hyperlinkTarget(im.text.String()); link != "" && len(im.stack) > 0 {
+			im.stack[len(im.stack)-1].attr.Link = link
+		}
+	}
+}
+
+// hyperlinkTarget reads the target of a HYPERLINK field instruction: an
+// address, or with the \l switch a bookmark.
+func hyperlinkTarget(inst string) string {
+	fields := strings.Fields(inst)
+	if len(fields) < 2 || !strings.EqualFold(fields[0], "HYPERLINK") {
+		return ""
+	}
+	target, anchor := "", ""
+	for i := 1; i < len(fields); i++ {
+		switch f := fields[i]; {
+		case f == "\\l" && i+1 < len(fields):
+			i++
+			anchor = strings.Trim(fields[i], `"`)
+		case strings.HasPrefix(f, "\\"):
+			// Other switches, such as \o for a tooltip, take one argument.
+			if i+1 < len(fields) && !strings.HasPrefix(fields[i+1], "\\") {
+				i++
+			}
+		case target == "":
+			target = strings.Trim(f, `"`)
+		}
+	}
+	if anchor != "" {
+		target += "#" + anchor
+	}
+	return convert.LinkTarget(target)
+}
+
+func (im *importer) dateValue() int64 {
+	d := im.date
+	if d["yr"] == 0 {
+		return 0
+	}
+	return time.Date(d["yr"], time.Month(max(d["mo"], 1)), max(d["dy"], 1), d["hr"], d["min"], d["sec"], 0, time.UTC).Unix()
+}
+
+func (im *importer) addFont() {
+	// The family class is more reliable than guessing from the name.
+	family := im.fontClass
+	if !im.hasClass {
+		family = convert.FamilyForFont(strings.TrimSpace(im.text.String()))
+	}
+	im.fonts[im.font] = family
+	im.resetFont()
+}
+
+func (im *importer) addPicture() {
+	p := im.pict
+	im.pict = nil
+	if p == nil {
+		return
+	}
+	if p.format == "" {
+		im.warn("pictures other than PNG and JPEG skipped")
+		return
+	}
+	data := p.raw
+	if len(data) == 0 {
+		decoded, err := hex.DecodeString(p.hex.String())
+		if err != nil {
+			im.warn("damaged picture data skipped")
+			return
+		}
+		data = decoded
+	}
+	path, err := im.extract(data, p.format)
+	if err != nil {
+		im.warn("picture could not be extracted: %v", err)
+		return
+	}
+	w, h := p.w, p.h
+	if p.wGoal > 0 && p.hGoal > 0 {
+		w, h = p.wGoal/twipsPerPixel, p.hGoal/twipsPerPixel
+	}
+	w, h = w*p.scaleX/100, h*p.scaleY/100
+	im.bb.WriteImage(path, w, h, im.st.attr)
+	im.openPara = true
+}
+
+// extract writes a picture to MediaDir, numbered in document order.
+func (im *importer) extract(data []byte, format string) (string, error) {
+	im.pictures++
+	ext := map[string]string{"png": ".png", "jpeg": ".jpg"}[format]
+	return convert.SaveMedia(&im.opts.MediaDir, "sqdoc-rtf-", fmt.Sprintf("image%d%s", im.pictures, ext), data)
+}
+
+// char handles one character of text in the current destination.
+func (im *importer) char(r rune) {
+	if im.skipChars > 0 {
+		im.skipChars--
+		return
+	}
+	switch im.st.dest {
+	case destText:
+		im.bb.WriteString(string(r), im.st.attr)
+		im.openPara = true
+	case destTitle, destAuthor, destFieldInst:
+		im.text.WriteRune(r)
+	case destFontTable:
+		if r == ';' {
+			im.addFont()
+			return
+		}
+		im.text.WriteRune(r)
+	case destColorTable:
+		if r == ';' {
+			if im.rgb[0] < 0 && im.rgb[1] < 0 && im.rgb[2] < 0 {
+				im.colors = append(im.colors, 0)
+			} else {
+				c := uint32(max(im.rgb[0], 0))<<24 | uint32(max(im.rgb[1], 0))<<16 | uint32(max(im.rgb[2], 0))<<8 | 0xFF
+				im.colors = append(im.colors, c)
+			}
+			im.rgb = [3]int{-1, -1, -1}
+		}
+	case destPict:
+		if r < 0x80 && isHex(byte(r)) {
+			im.pict.hex.WriteByte(byte(r))
+		}
+	}
+}
+
+func isHex(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F'
+}
+
+func (im *importer) endParagraph() {
+	im.doc.Add(im.bb.TextBlock())
+	im.openPara = false
+}
+
+// word applies a control word. Unknown words are ignored, as the RTF
+// specification asks of readers.
+func (im *importer) word(word string, param int, hasParam bool) {
+	on := !hasParam || param != 0
+	switch im.st.dest {
+	case destSkip:
+		return
+	case destFontTable:
+		switch word {
+		case "f":
+			im.font = param
+		case "fswiss":
+			im.fontClass, im.hasClass = sqdoc.FontFamilySans, true
+		case "froman":
+			im.fontClass, im.hasClass = sqdoc.FontFamilySerif, true
+		case "fmodern":
+			im.fontClass, im.hasClass = sqdoc.FontFamilyMonospace, true
+		case "falt", "panose", "fname":
+			im.enter(destSkip)
+		}
+		return
+	case destColorTable:
+		switch word {
+		case "red":
+			im.rgb[0] = param
+		case "green":
+			im.rgb[1] = param
+		case "blue":
+			im.rgb[2] = param
+		}
+		return
+	case destInfo:
+		switch word {
+		case "title":
+			im.enter(destTitle)
+		case "author":
+			im.enter(destAuthor)
+		case "creatim":
+			im.enter(destCreated)
+		case "revtim":
+			im.enter(destRevised)
+		default:
+			im.enter(destSkip)
+		}
+		return
+	case destCreated, destRevised:
+		im.date[word] = param
+		return
+	case destPict:
+		p := im.pict
+		switch word {
+		case "pngblip":
+			p.format = "png"
+		case "jpegblip":
+			p.format = "jpeg"
+		case "picw":
+			p.w = param
+		case "pich":
+			p.h = param
+		case "picwgoal":
+			p.wGoal = param
+		case "pichgoal":
+			p.hGoal = param
+		case "picscalex":
+			p.scaleX = max(param, 1)
+		case "picscaley":
+			p.scaleY = max(param, 1)
+		case "blipuid", "picprop":
+			im.enter(destSkip)
+		}
+		return
+	case destTitle, destAuthor, destFieldInst:
+		if word == "u" {
+			im.unicode(param)
+		}
+		return
+	}
+
+	attr := &im.st.attr
+	switch word {
+	case "rtf", "ansi", "mac", "pc", "pca":
+	case "ansicpg":
+		if param != 1252 && param != 0 {
+			im.warn("code page %d read as Windows-1252", param)
+		}
+	case "deff":
+		im.deff = param
+	case "fonttbl":
+		im.enter(destFontTable)
+	case "colortbl":
+		im.enter(destColorTable)
+	case "info":
+		im.enter(destInfo)
+	case "pict":
+		im.enter(destPict)
+	case "stylesheet", "listtable", "listoverridetable", "filetbl", "revtbl", "rsidtbl",
+		"header", "headerl", "headerr", "headerf", "footer", "footerl", "footerr", "footerf",
+		"nonshppict", "xe", "tc", "txe", "bkmkstart", "bkmkend", "template", "userprops":
+		im.enter(destSkip)
+	case "footnote":
+		im.warn("footnotes and endnotes dropped")
+		im.enter(destSkip)
+	case "annotation", "atnid", "atnauthor", "atnref", "atrfstart", "atrfend":
+		im.warn("comments dropped")
+		im.enter(destSkip)
+	case "object":
+		im.warn("embedded objects skipped")
+		im.enter(destSkip)
+	case "fldinst":
+		im.enter(destFieldInst)
+	case "field":
+		// The field result is kept as text; a HYPERLINK instruction links it.
+	case "deleted":
+		im.warn("tracked deletions kept as plain text")
+	case "trowd":
+		im.warn("tables flattened to tab-separated text")
+	case "cell", "nestcell":
+		im.char('\t')
+	case "row", "nestrow":
+		im.bb.TrimTrailingSpace()
+		im.endParagraph()
+	case "par", "sect", "page":
+		im.endParagraph()
+	case "pard":
+		im.openPara = true
+	case "sa":
+		if im.gap < 0 {
+			im.gap = param / 20
+		}
+	case "line":
+		im.char('\n')
+	case "tab":
+		im.char('\t')
+	case "emdash":
+		im.char('—')
+	case "endash":
+		im.char('–')
+	case "bullet":
+		im.char('•')
+	case "lquote":
+		im.char('‘')
+	case "rquote":
+		im.char('’')
+	case "ldblquote":
+		im.char('“')
+	case "rdblquote":
+		im.char('”')
+	case "u":
+		im.unicode(param)
+	case "uc":
+		im.st.uc = max(param, 0)
+	case "plain":
+		*attr = convert.DefaultAttr()
+		if family, ok := im.fonts[im.deff]; ok {
+			attr.FontFamily = family
+		}
+	case "b":
+		attr.Bold = on
+	case "i":
+		attr.Italic = on
+	case "ul", "uld", "uldash", "uldashd", "uldashdd", "uldb", "ulhwave", "ulldash", "ulth", "ulthd",
+		"ulthdash", "ulthdashd", "ulthdashdd", "ulthldash", "ululdbwave", "ulw", "ulwave":
+		attr.Underline = on
+	case "ulnone":
+		attr.Underline = false
+	case "highlight", "cb":
+		attr.Highlight = hasParam && param > 0
+	case "fs":
+		if param > 0 {
+			attr.FontSizePt = uint16((param + 1) / 2)
+		}
+	case "cf":
+		attr.ColorRGBA = convert.DefaultAttr().ColorRGBA
+		if param > 0 && param < len(im.colors) && im.colors[param] != 0 {
+			attr.ColorRGBA = im.colors[param]
+		}
+	case "f":
+		if family, ok := im.fonts[param]; ok {
+			attr.FontFamily = family
+		} else {
+			attr.FontFamily = sqdoc.FontFamilySans
+		}
+	}
+}
+
+// unicode writes \uN, pairing surrogates, and arranges for the fallback
+// characters that follow to be skipped.
+func (im *importer) unicode(param int) {
+	r := rune(uint16(int16(param)))
+	im.skipChars = 0
+	switch {
+	case r >= 0xD800 && r < 0xDC00:
+		im.high = r
+	case r >= 0xDC00 && r < 0xE000 && im.high != 0:
+		im.char(0x10000 + (im.high-0xD800)<<10 + (r - 0xDC00))
+		im.high = 0
+	default:
+		im.char(r)
+	}
+	im.skipChars = im.st.uc
+}
+
+// cp1252High maps Windows-1252 bytes 0x80-0x9F; the rest of the code page
+// matches Latin-1. Undefined slots stay as their C1 control codes.
+var cp1252High = [32]rune{
+	'€', 0x81, '‚', 'ƒ', '„', '…', '†', '‡', 'ˆ', '‰', 'Š', '‹', 'Œ', 0x8D, 'Ž', 0x8F,
+	0x90, '‘', '’', '“', '”', '•', '–', '—', '˜', '™', 'š', '›', 'œ', 0x9D, 'ž', 'Ÿ',
+}
+
+func cp1252(b byte) rune {
+	if b >= 0x80 && b < 0xA0 {
+		return cp1252High[b-0x80]
+	}
+	return rune(b)
+}
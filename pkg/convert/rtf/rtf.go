@@ -0,0 +1,68 @@
+// Package rtf converts between Rich Text Format and SQDoc documents. It
+// covers the subset word processors and mail clients exchange on the
+// clipboard: paragraphs, bold, italic, underline, highlight, font and
+// colour tables, point sizes and PNG or JPEG pictures.
+//
+// Export writes one \pard paragraph per text block with each span in its
+// own group, so a fragment pasted into another document carries its
+// formatting without depending on the surrounding state. Alignment,
+// indents, spacing and line height follow \pard as \qc, \li, \fi, \sb
+// and \sl.
+package rtf
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"sqdoc/pkg/convert"
+	"sqdoc/pkg/sqdoc"
+)
+
+// pixelsPerInch converts picture sizes, matching the DOCX converter.
+const pixelsPerInch = 96
+
+const twipsPerPixel = 1440 / pixelsPerInch
+
+type Options struct {
+	// MediaDir receives the \pict pictures decoded on import, numbered
+	// image1.png, image2.jpg and so on in document order. ImportFile defaults
+	// it to convert.DefaultMediaDir; Import uses a new temporary folder.
+	MediaDir string
+	// BaseDir resolves relative image paths that export writes out as \pict
+	// groups.
+	BaseDir string
+}
+
+// ImportFile reads a .rtf file, titling the document after the file when
+// its \info group has no \title. The returned warnings describe content
+// that was simplified or dropped.
+func ImportFile(path string, opts Options) (*sqdoc.Document, []string, error) {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if opts.MediaDir == "" {
+		opts.MediaDir = convert.DefaultMediaDir(path)
+	}
+	doc, warnings, err := Import(src, opts)
+	if err != nil {
+		return nil, warnings, err
+	}
+	if doc.Metadata.Title == "" {
+		doc.Metadata.Title = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	return doc, warnings, nil
+}
+
+func ExportFile(path string, doc *sqdoc.Document, opts Options) error {
+	out, err := Export(doc, opts)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, out, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,390 @@
+package rtf
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"sqdoc/pkg/convert"
+	"sqdoc/pkg/sqdoc"
+)
+
+func TestExportImportRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	imgPath := filepath.Join(dir, "dot.png")
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 30, 20))); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(imgPath, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	doc := sqdoc.NewDocument("Zoë Ng", "Notes {draft}")
+	doc.Metadata.CreatedUnix = 1700000000
+	doc.Metadata.ModifiedUnix = 1700000500
+	doc.Metadata.ParagraphGap = 10
+	doc.Metadata.PreferredFontFamily = sqdoc.FontFamilySerif
+
+	var bb convert.BlockBuilder
+	bb.WriteString("Summary", convert.HeadingAttr(2))
+	doc.Blocks = append(doc.Blocks, sqdoc.Block{ID: 1, Kind: sqdoc.BlockKindText, Text: bb.TextBlock()})
+
+	fancy := convert.DefaultAttr()
+	fancy.Italic = true
+	fancy.Underline = true
+	fancy.Highlight = true
+	fancy.FontSizePt = 11
+	fancy.ColorRGBA = 0x1F4E79FF
+	fancy.FontFamily = sqdoc.FontFamilySerif
+	code := convert.DefaultAttr()
+	code.FontFamily = sqdoc.FontFamilyMonospace
+	bb.WriteString(`back\slash {braces} café 😀`+"\t", convert.DefaultAttr())
+	bb.WriteString("fancy", fancy)
+	bb.WriteString("\nx := 1 ", code)
+	bb.WriteImage(imgPath, 60, 40, convert.DefaultAttr())
+	body := bb.TextBlock()
+	doc.Blocks = append(doc.Blocks, sqdoc.Block{ID: 2, Kind: sqdoc.BlockKindText, Text: body})
+	doc.Blocks = append(doc.Blocks, sqdoc.Block{ID: 3, Kind: sqdoc.BlockKindText, Text: &sqdoc.TextBlock{}})
+
+	out, err := Export(doc, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.ContainsFunc(out, func(r rune) bool { return r >= 0x80 }) {
+		t.Fatal("export is not 7-bit clean")
+	}
+	mediaDir := filepath.Join(dir, "media")
+	back, warnings, err := Import(out, Options{MediaDir: mediaDir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(warnings) != 0 {
+		t.Fatalf("unexpected warnings: %v", warnings)
+	}
+	m := back.Metadata
+	if m.Title != "Notes {draft}" || m.Author != "Zoë Ng" || m.CreatedUnix != 1700000000 || m.ModifiedUnix != 1700000500 ||
+		m.ParagraphGap != 10 || m.PreferredFontFamily != sqdoc.FontFamilySerif {
+		t.Fatalf("metadata = %+v", m)
+	}
+	if len(back.Blocks) != 3 {
+		t.Fatalf("got %d blocks", len(back.Blocks))
+	}
+	if convert.HeadingLevel(back.Blocks[0]) != 2 {
+		t.Fatal("heading formatting lost")
+	}
+	got := convert.Spans(back.Blocks[1])
+	want := convert.Spans(sqdoc.Block{Kind: sqdoc.BlockKindText, Text: body})
+	if len(got) != len(want) {
+		t.Fatalf("spans: got %d want %d\n%+v", len(got), len(want), got)
+	}
+	for i := range want {
+		if want[i].Image != nil {
+			img := got[i].Image
+			if img == nil || img.Width != 60 || img.Height != 40 || filepath.Dir(img.Path) != mediaDir {
+				t.Fatalf("image span = %+v", got[i])
+			}
+			if data, err := os.ReadFile(img.Path); err != nil || !bytes.Equal(data, buf.Bytes()) {
+				t.Fatalf("extracted image differs: %v", err)
+			}
+			continue
+		}
+		if got[i].Text != want[i].Text || got[i].Attr != want[i].Attr {
+			t.Fatalf("span %d: got %q %+v want %q %+v", i, got[i].Text, got[i].Attr, want[i].Text, want[i].Attr)
+		}
+	}
+	if len(back.Blocks[2].Text.UTF8) != 0 {
+		t.Fatalf("trailing empty paragraph = %q", back.Blocks[2].Text.UTF8)
+	}
+}
+
+// wordRTF is trimmed from what Word puts on the clipboard.
+const wordRTF = `{\rtf1\adeflang1025\ansi\ansicpg1252\uc1\adeff31507\deff0
+{\fonttbl{\f0\fbidi \froman\fcharset0\fprq2{\*\panose 02020603050405020304}Times New Roman;}
+{\f37\fbidi \fswiss\fcharset0\fprq2{\*\panose 020f0502020204030204}Calibri;}
+{\f42\fbidi \fmodern\fcharset0\fprq1 Consolas;}}
+{\colortbl;\red0\green0\blue0;\red255\green0\blue0;\red255\green255\blue0;}
+{\*\defchp \f31506\fs22 }{\stylesheet{\ql \f37\fs22 \snext0 Normal;}}
+{\*\rsidtbl \rsid1}{\info{\title Field report}{\author Ada}{\operator Ada}{\creatim\yr2024\mo3\dy1\hr9\min30}{\revtim\yr2024\mo3\dy2\hr10\min0}{\nofpages1}}
+\paperw12240\paperh15840
+{\*\xmlnstbl {\xmlns1 http://schemas.microsoft.com/office/word/2003/wordml}}
+\pard\plain \ltrpar\sa160\sl259\slmult1\f37\fs22 {\b Bold} and {\i\cf2 red italic} caf\'e9 \u8364\'80\'80 {\highlight3 marked}\par
+\pard {\f42\fs20 mono}{\field{\*\fldinst { HYPERLINK "https://example.org/" }}{\fldrslt {\ul\cf2 link}}}{\super\chftn {\footnote \pard footnote text}}\par
+\trowd \cellx1000\cellx2000\pard\intbl A\cell B\cell\row
+\pard {\*\shppict{\pict{\*\picprop}\pngblip\picw1\pich1\picwgoal150\pichgoal150 89504e470d0a1a0a0000000d4948445200000001000000010806000000
+1f15c4890000000d4944415478da63f8cfc0f01f0005000201a2f1e3c70000000049454e44ae426082}}{\nonshppict{\pict\wmetafile8 0100}}\par
+}`
+
+func TestImportWordClipboard(t *testing.T) {
+	doc, warnings, err := Import([]byte(wordRTF), Options{MediaDir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc.Metadata.Title != "Field report" || doc.Metadata.Author != "Ada" || doc.Metadata.CreatedUnix != 1709285400 ||
+		doc.Metadata.ParagraphGap != 8 || doc.Metadata.PreferredFontFamily != sqdoc.FontFamilySerif {
+		t.Fatalf("metadata = %+v", doc.Metadata)
+	}
+	var texts []string
+	for _, b := range doc.Blocks {
+		texts = append(texts, string(b.Text.UTF8))
+	}
+	if len(texts) != 4 || texts[0] != "Bold and red italic café €€ marked" || texts[1] != "monolink" || texts[2] != "A\tB" {
+		t.Fatalf("texts = %q", texts)
+	}
+	spans := convert.Spans(doc.Blocks[0])
+	if !spans[0].Attr.Bold || spans[0].Attr.FontSizePt != 11 || spans[0].Attr.FontFamily != sqdoc.FontFamilySans {
+		t.Fatalf("bold span = %+v", spans[0])
+	}
+	if !spans[2].Attr.Italic || spans[2].Attr.ColorRGBA != 0xFF0000FF {
+		t.Fatalf("italic span = %+v", spans[2])
+	}
+	if last := spans[len(spans)-1]; !last.Attr.Highlight {
+		t.Fatalf("highlight span = %+v", last)
+	}
+	mono := convert.Spans(doc.Blocks[1])
+	if mono[0].Attr.FontFamily != sqdoc.FontFamilyMonospace || mono[0].Attr.FontSizePt != 10 || !mono[1].Attr.Underline {
+		t.Fatalf("second paragraph spans = %+v", mono)
+	}
+	imgs := sqdoc.ParseImageTokens(doc.Blocks[3].Text.UTF8)
+	if len(imgs) != 1 || imgs[0].Width != 10 || imgs[0].Height != 10 {
+		t.Fatalf("images = %+v", imgs)
+	}
+	joined := strings.Join(warnings, "\n")
+	for _, w := range []string{"footnotes", "tables"} {
+		if !strings.Contains(joined, w) {
+			t.Errorf("missing %s warning in %q", w, warnings)
+		}
+	}
+}
+
+func TestImportRejectsNonRTF(t *testing.T) {
+	if _, _, err := Import([]byte("plain text"), Options{}); err == nil {
+		t.Fatal("plain text accepted")
+	}
+	if _, _, err := Import([]byte(`{\rtf1 unterminated`), Options{}); err == nil {
+		t.Fatal("unterminated document accepted")
+	}
+}
+
+func TestLinksRoundTrip(t *testing.T) {
+	web := convert.DefaultAttr()
+	web.Link = "https://example.com/a?b=c&d=e"
+	internal := convert.DefaultAttr()
+	internal.Link = "#intro"
+	var bb convert.BlockBuilder
+	bb.WriteString("Intro", convert.HeadingAttr(1))
+	heading := bb.TextBlock()
+	heading.Style = sqdoc.StyleHeading1
+	bb = convert.BlockBuilder{}
+	bb.WriteString("See ", convert.DefaultAttr())
+	bb.WriteString("the site", web)
+	bb.WriteString(" or ", convert.DefaultAttr())
+	bb.WriteString("intro", internal)
+	doc := sqdoc.NewDocument("", "Links")
+	doc.Blocks = append(doc.Blocks,
+		sqdoc.Block{ID: 1, Kind: sqdoc.BlockKindText, Text: heading},
+		sqdoc.Block{ID: 2, Kind: sqdoc.BlockKindText, Text: bb.TextBlock()},
+	)
+
+	out, err := Export(doc, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(out, []byte(`{\*\bkmkstart intro}`)) || !bytes.Contains(out, []byte(`HYPERLINK \\l "intro"`)) {
+		t.Fatalf("bookmark or internal link missing:\n%s", out)
+	}
+	back, _, err := Import(out, Options{MediaDir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	links := map[string]string{}
+	for _, s := range convert.Spans(back.Blocks[1]) {
+		if s.Attr.Link != "" {
+			links[s.Text] = s.Attr.Link
+		}
+	}
+	if len(links) != 2 || links["the site"] != web.Link || links["intro"] != "#intro" {
+		t.Fatalf("links = %q", links)
+	}
+}
+
+func TestExportNotes(t *testing.T) {
+	foot := convert.DefaultAttr()
+	foot.Note = 5
+	end := convert.DefaultAttr()
+	end.Note = 6
+	var bb convert.BlockBuilder
+	bb.WriteString("Claim", convert.DefaultAttr())
+	bb.WriteString("1", foot)
+	bb.WriteString(" again", convert.DefaultAttr())
+	bb.WriteString("1", foot)
+	bb.WriteString("i", end)
+	doc := sqdoc.NewDocument("", "Notes")
+	doc.Blocks = append(doc.Blocks, sqdoc.Block{ID: 1, Kind: sqdoc.BlockKindText, Text: bb.TextBlock()})
+	bb.WriteString("Source.", convert.DefaultAttr())
+	doc.Blocks = append(doc.Blocks, sqdoc.Block{ID: 5, Kind: sqdoc.BlockKindNote, Text: bb.TextBlock()})
+	bb.WriteString("Later.", convert.DefaultAttr())
+	doc.Blocks = append(doc.Blocks, sqdoc.Block{ID: 6, Kind: sqdoc.BlockKindNote, Note: sqdoc.NoteEndnote, Text: bb.TextBlock()})
+
+	out, err := Export(doc, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`\fet2\ftnbj\aenddoc\aftnnrlc`,
+		`\super\chftn}{\footnote\pard\plain{\f0\fs28\cf2\super\chftn} {\f0\fs28\cf2 Source.}}`,
+		`{\f0\fs28\cf2\super 1}`,
+		`{\footnote\ftnalt\pard\plain`,
+	} {
+		if !bytes.Contains(out, []byte(want)) {
+			t.Fatalf("export lacks %s:\n%s", want, out)
+		}
+	}
+	back, warnings, err := Import(out, Options{MediaDir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(back.Blocks[0].Text.UTF8); got != "Claim again1" || len(warnings) == 0 {
+		t.Fatalf("imported %q with warnings %v", got, warnings)
+	}
+}
+
+func TestExportTables(t *testing.T) {
+	table := sqdoc.NewTable(3, 2)
+	table.HeaderRows = 1
+	table.Columns[0] = 90
+	for i, text := range []string{"Name", "Qty", "Apples", "3", "", "4"} {
+		var bb convert.BlockBuilder
+		bb.WriteString(text, convert.DefaultAttr())
+		table.Rows[i/2][i%2].Text = bb.TextBlock()
+	}
+	table.Rows[1][0].RowSpan = 2
+	table.Rows[1][1].Background = 0xFFF4C2FF
+	var bb convert.BlockBuilder
+	bb.WriteString("After", convert.DefaultAttr())
+	doc := sqdoc.NewDocument("", "")
+	doc.Blocks = append(doc.Blocks,
+		sqdoc.Block{ID: 1, Kind: sqdoc.BlockKindTable, Table: table},
+		sqdoc.Block{ID: 2, Kind: sqdoc.BlockKindText, Text: bb.TextBlock()})
+
+	out, err := Export(doc, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`\trowd\trgaph80\trleft0\trhdr\clbrdrt\brdrs\brdrw10\brdrcf2`,
+		`\cellx1800`,
+		`\cellx9020`,
+		`\clvmgf\clbrdrt`,
+		`\clvmrg\clbrdrt`,
+		`\clcbpat5\cellx9020`,
+		`\pard\intbl\sa0 {\f0\fs28\cf4 Apples}\cell`,
+		"\\row\n\\pard\\sa",
+	} {
+		if !bytes.Contains(out, []byte(want)) {
+			t.Fatalf("export lacks %s:\n%s", want, out)
+		}
+	}
+	back, _, err := Import(out, Options{MediaDir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(back.Blocks[1].Text.UTF8); !strings.HasPrefix(got, "Apples\t3") {
+		t.Fatalf("second row imported as %q", got)
+	}
+}
+
+func TestExportListMarkers(t *testing.T) {
+	doc := sqdoc.NewDocument("", "")
+	for i, item := range []sqdoc.ListItem{
+		{ID: 1, Style: sqdoc.ListDecimal},
+		{ID: 1, Level: 1, Style: sqdoc.ListLowerAlpha},
+		{ID: 1, Style: sqdoc.ListDecimal, Start: 5},
+		{},
+	} {
+		var bb convert.BlockBuilder
+		bb.WriteString("item", convert.DefaultAttr())
+		tb := bb.TextBlock()
+		tb.List = item
+		doc.Blocks = append(doc.Blocks, sqdoc.Block{ID: uint64(i + 1), Kind: sqdoc.BlockKindText, Text: tb})
+	}
+
+	out, err := Export(doc, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		` 1.\tab }{\f0\fs28\cf2 item}`,
+		`\li960\fi-480{\f0\fs28\cf2 a.\tab }`,
+		` 5.\tab }`,
+		"\\par\n\\pard\\sa160{\\f0\\fs28\\cf2 item}}",
+	} {
+		if !bytes.Contains(out, []byte(want)) {
+			t.Fatalf("export lacks %s:\n%s", want, out)
+		}
+	}
+}
+
+func TestExportCodeBlocks(t *testing.T) {
+	var bb convert.BlockBuilder
+	bb.WriteString("if x {\n\treturn\n}", convert.DefaultAttr())
+	code := bb.TextBlock()
+	code.Code = &sqdoc.CodeBlock{Language: "go"}
+	doc := sqdoc.NewDocument("", "")
+	doc.Blocks = append(doc.Blocks, sqdoc.Block{ID: 1, Kind: sqdoc.BlockKindText, Text: code})
+
+	out, err := Export(doc, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`{\stylesheet{\s0 Normal;}{\s1\keep\cbpat2\f2 Source Code;}}`,
+		`\pard\sa160\s1\keep\cbpat2{\f2\fs28\cf3 if x \{\line \tab return\line \}}`,
+	} {
+		if !bytes.Contains(out, []byte(want)) {
+			t.Fatalf("export lacks %s:\n%s", want, out)
+		}
+	}
+	doc.Blocks[0].Text.Code = nil
+	out, err = Export(doc, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(out, []byte(`\stylesheet`)) {
+		t.Fatal("a document without code has a stylesheet")
+	}
+}
+
+func TestExportParagraphFormats(t *testing.T) {
+	doc := sqdoc.NewDocument("", "")
+	formats := []sqdoc.ParagraphFormat{
+		{Align: sqdoc.AlignCenter, IndentLeft: 36, IndentRight: 18, IndentFirst: -12, SpaceBefore: 6, SpaceAfter: 4, LineHeight: 150, PageBreakBefore: true},
+		{Align: sqdoc.AlignJustify, IndentLeft: 12},
+	}
+	for i, p := range formats {
+		var bb convert.BlockBuilder
+		bb.WriteString("text", convert.DefaultAttr())
+		tb := bb.TextBlock()
+		tb.Para = p
+		doc.Blocks = append(doc.Blocks, sqdoc.Block{ID: uint64(i + 1), Kind: sqdoc.BlockKindText, Text: tb})
+	}
+	doc.Blocks[1].Text.List = sqdoc.ListItem{ID: 1, Level: 1, Style: sqdoc.ListBullet}
+
+	out, err := Export(doc, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`\pard\sa240\qc\li720\ri360\fi-240\sb120\sl360\slmult1\pagebb{`,
+		// The list item keeps its marker hanging in the level's indent.
+		`\pard\sa160\qj\li1200\fi-480{`,
+	} {
+		if !bytes.Contains(out, []byte(want)) {
+			t.Fatalf("export lacks %s:\n%s", want, out)
+		}
+	}
+}
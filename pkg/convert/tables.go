@@ -0,0 +1,283 @@
+package convert
+
+import "sqdoc/pkg/sqdoc"
+
+const (
+	// TableBorderRGBA is the colour of cell borders.
+	TableBorderRGBA uint32 = 0x78869CFF
+	// HeaderShadeRGBA fills header cells that have no shading of their own.
+	HeaderShadeRGBA uint32 = 0xEAEFF7FF
+	// CellPaddingPt is the gap between a cell's border and its text.
+	CellPaddingPt = 4
+)
+
+// TableCell is one grid position of a table during export. A covered cell
+// lies under the span of the cell at OwnerRow, OwnerCol and has no text of
+// its own.
+type TableCell struct {
+	Row, Col int
+	// ColSpan and RowSpan are at least 1 and never run past the grid.
+	ColSpan, RowSpan   int
+	Header             bool
+	Background         uint32
+	Borders            sqdoc.Border
+	Spans              []Span
+	Covered            bool
+	OwnerRow, OwnerCol int
+}
+
+// Shade returns the colour to fill the cell with, or 0 for none.
+func (c TableCell) Shade() uint32 {
+	if c.Background == 0 && c.Header {
+		return HeaderShadeRGBA
+	}
+	return c.Background
+}
+
+// TableRows returns every grid position of t row by row, so writers for
+// formats without spans can emit covered cells empty.
+func TableRows(t *sqdoc.TableBlock) [][]TableCell {
+	out := make([][]TableCell, len(t.Rows))
+	for r, row := range t.Rows {
+		out[r] = make([]TableCell, len(row))
+		for c, cell := range row {
+			tc := TableCell{Row: r, Col: c, ColSpan: 1, RowSpan: 1, Header: r < int(t.HeaderRows)}
+			tc.OwnerRow, tc.OwnerCol = t.Owner(r, c)
+			if tc.Covered = tc.OwnerRow != r || tc.OwnerCol != c; !tc.Covered {
+				cs, rs := cell.Span()
+				tc.ColSpan, tc.RowSpan = min(cs, len(row)-c), min(rs, len(t.Rows)-r)
+				tc.Background, tc.Borders = cell.Background, cell.Borders
+				tc.Spans = Spans(sqdoc.Block{Kind: sqdoc.BlockKindText, Text: cell.Text})
+			}
+			out[r][c] = tc
+		}
+	}
+	return out
+}
+
+// TableSpans returns the spans of each cell of table block b, for
+// exporters that gather notes or anchors before writing.
+func TableSpans(b sqdoc.Block) [][]Span {
+	if b.Kind != sqdoc.BlockKindTable || b.Table == nil {
+		return nil
+	}
+	var out [][]Span
+	for _, row := range TableRows(b.Table) {
+		for _, cell := range row {
+			if !cell.Covered {
+				out = append(out, cell.Spans)
+			}
+		}
+	}
+	return out
+}
+
+// TableBuilder collects an imported table cell by cell, each row left to
+// right, and builds a valid table block from it however ragged the source:
+// short rows are padded, and spans that run past the grid or over other
+// cells are cut back.
+type TableBuilder struct {
+	columns []uint16
+	header  int
+	rows    [][]builtCell
+	// spanned counts the columns the last cell's span skipped that a
+	// source listing covered cells has yet to list.
+	spanned int
+}
+
+// builtCell is one grid position of a table being built. Positions a span
+// covers hold no cell; above records where a vertical merge started.
+type builtCell struct {
+	cell    sqdoc.TableCell
+	covered bool
+	above   int
+}
+
+// Column adds a column width points wide; 0 shares out the free width.
+func (b *TableBuilder) Column(width uint16) {
+	b.columns = append(b.columns, width)
+}
+
+// Row starts a new row.
+func (b *TableBuilder) Row() {
+	b.rows = append(b.rows, nil)
+	b.spanned = 0
+}
+
+// HeaderRow marks the current row as a header row. Only rows that follow
+// header rows from the top of the table can head it.
+func (b *TableBuilder) HeaderRow() {
+	if b.header == len(b.rows)-1 {
+		b.header++
+	}
+}
+
+// Cell adds cell at the next column of the current row, with its ColSpan
+// and RowSpan saying how far it reaches.
+func (b *TableBuilder) Cell(cell sqdoc.TableCell) {
+	if len(b.rows) == 0 {
+		b.Row()
+	}
+	if cell.Text == nil {
+		cell.Text = &sqdoc.TextBlock{}
+	}
+	r := len(b.rows) - 1
+	b.rows[r] = append(b.rows[r], builtCell{cell: cell, above: -1})
+	cs, _ := cell.Span()
+	for i := 1; i < cs; i++ {
+		b.rows[r] = append(b.rows[r], builtCell{covered: true, above: -1})
+	}
+	b.spanned = cs - 1
+}
+
+// Covered skips a column that the span of another cell covers. Columns
+// the last cell's own span already skipped are not skipped again.
+func (b *TableBuilder) Covered() {
+	if b.spanned > 0 {
+		b.spanned--
+		return
+	}
+	if len(b.rows) == 0 {
+		b.Row()
+	}
+	r := len(b.rows) - 1
+	b.rows[r] = append(b.rows[r], builtCell{covered: true, above: -1})
+}
+
+// Continue extends the cell above the next column down over the current
+// row, as Word's vertical merges do, and skips the columns it covers. With
+// no cell above it adds an empty one.
+func (b *TableBuilder) Continue() {
+	r := len(b.rows) - 1
+	c := 0
+	if r >= 0 {
+		c = len(b.rows[r])
+	}
+	if r < 1 || c >= len(b.rows[r-1]) {
+		b.Cell(sqdoc.TableCell{})
+		return
+	}
+	start := r - 1
+	if up := b.rows[r-1][c]; up.covered {
+		start = up.above
+	}
+	if start < 0 || b.rows[start][c].covered {
+		b.Cell(sqdoc.TableCell{})
+		return
+	}
+	b.spanned = 0
+	owner := &b.rows[start][c].cell
+	owner.RowSpan = uint16(r - start + 1)
+	cs, _ := owner.Span()
+	for i := 0; i < cs; i++ {
+		b.rows[r] = append(b.rows[r], builtCell{covered: true, above: start})
+	}
+}
+
+// Table returns the table built, or nil when it has no cells.
+func (b *TableBuilder) Table() *sqdoc.TableBlock {
+	cols := len(b.columns)
+	for _, row := range b.rows {
+		cols = max(cols, len(row))
+	}
+	if cols == 0 || len(b.rows) == 0 {
+		return nil
+	}
+	t := sqdoc.NewTable(len(b.rows), cols)
+	copy(t.Columns, b.columns)
+	t.HeaderRows = uint16(b.header)
+	covered := make([][]bool, len(b.rows))
+	for r := range covered {
+		covered[r] = make([]bool, cols)
+	}
+	for r, row := range b.rows {
+		for c := range t.Rows[r] {
+			t.Rows[r][c].Borders = 0
+			if c >= len(row) || row[c].covered || covered[r][c] {
+				continue
+			}
+			cell := row[c].cell
+			cs, rs := cell.Span()
+			cs, rs = min(cs, cols-c), min(rs, len(b.rows)-r)
+			// A span may only cover positions the source left free.
+			for dr := 0; dr < rs; dr++ {
+				for dc := 0; dc < cs; dc++ {
+					if dr == 0 && dc == 0 {
+						continue
+					}
+					if covered[r+dr][c+dc] || c+dc < len(b.rows[r+dr]) && !b.rows[r+dr][c+dc].covered {
+						cs, rs = 1, 1
+					}
+				}
+			}
+			for dr := 0; dr < rs; dr++ {
+				for dc := 0; dc < cs; dc++ {
+					covered[r+dr][c+dc] = true
+				}
+			}
+			cell.ColSpan, cell.RowSpan = 0, 0
+			if cs > 1 {
+				cell.ColSpan = uint16(cs)
+			}
+			if rs > 1 {
+				cell.RowSpan = uint16(rs)
+			}
+			// Exporters shade header cells that have no colour of their own.
+			if r < b.header && cell.Background == HeaderShadeRGBA {
+				cell.Background = 0
+			}
+			t.Rows[r][c] = cell
+		}
+	}
+	return t
+}
+
+// CellText joins the blocks an importer read from one table cell into the
+// cell's text: paragraphs become its lines and a nested table, which a
+// cell cannot hold, rows of tab-separated text.
+func CellText(blocks []sqdoc.Block) *sqdoc.TextBlock {
+	var bb BlockBuilder
+	lines := 0
+	line := func(cells [][]Span) {
+		sep := DefaultAttr()
+		for _, spans := range cells {
+			if len(spans) > 0 {
+				sep = spans[0].Attr
+				sep.Link, sep.Note, sep.Ref = "", 0, sqdoc.CrossRef{}
+				break
+			}
+		}
+		if lines > 0 {
+			bb.WriteString("\n", sep)
+		}
+		lines++
+		for i, spans := range cells {
+			if i > 0 {
+				bb.WriteString("\t", sep)
+			}
+			for _, s := range spans {
+				if s.Image != nil {
+					bb.WriteImage(s.Image.Path, s.Image.Width, s.Image.Height, s.Attr)
+				} else {
+					bb.WriteString(s.Text, s.Attr)
+				}
+			}
+		}
+	}
+	for _, b := range blocks {
+		if b.Kind != sqdoc.BlockKindTable || b.Table == nil {
+			line([][]Span{Spans(b)})
+			continue
+		}
+		for _, row := range TableRows(b.Table) {
+			var cells [][]Span
+			for _, cell := range row {
+				if !cell.Covered {
+					cells = append(cells, cell.Spans)
+				}
+			}
+			line(cells)
+		}
+	}
+	return bb.TextBlock()
+}
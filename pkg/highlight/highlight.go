@@ -0,0 +1,301 @@
+// Package highlight splits source code into tokens for syntax colouring.
+// Its lexers are deliberately small: they know each language's comments,
+// strings, numbers and keywords, which is enough to colour code in a
+// document, not to parse it.
+package highlight
+
+import (
+	"sort"
+	"strings"
+)
+
+// Kind classifies a token.
+type Kind uint8
+
+const (
+	Plain Kind = iota
+	Keyword
+	// Builtin covers predeclared names: literals such as true and nil,
+	// built-in types and functions.
+	Builtin
+	String
+	Number
+	Comment
+	// Key is a mapping key in JSON and YAML.
+	Key
+	// Variable is a shell parameter expansion such as $HOME.
+	Variable
+)
+
+// Token is a coloured byte range of the source. Text between tokens is
+// Plain.
+type Token struct {
+	Start, End int
+	Kind       Kind
+}
+
+type quote struct {
+	open, close string
+	escapes     bool
+	multiline   bool
+}
+
+type lexer struct {
+	lineComments []string
+	// commentAfterSpace only starts line comments at the start of a word, as
+	// in the shell and YAML where # inside a word is literal.
+	commentAfterSpace bool
+	blockComment      [2]string
+	// Longer quotes come first so """ wins over ".
+	quotes    []quote
+	keywords  map[string]Kind
+	foldCase  bool
+	jsonKeys  bool
+	yamlKeys  bool
+	variables bool
+}
+
+var aliases = map[string]string{
+	"go":         "go",
+	"golang":     "go",
+	"python":     "python",
+	"py":         "python",
+	"javascript": "javascript",
+	"js":         "javascript",
+	"jsx":        "javascript",
+	"json":       "json",
+	"shell":      "shell",
+	"sh":         "shell",
+	"bash":       "shell",
+	"zsh":        "shell",
+	"sql":        "sql",
+	"yaml":       "yaml",
+	"yml":        "yaml",
+}
+
+// Languages returns the canonical names of the supported languages.
+func Languages() []string {
+	names := make([]string, 0, len(lexers))
+	for name := range lexers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Canonical maps a language tag such as "py" or "YAML" to the name of its
+// lexer, or "" when no lexer handles it.
+func Canonical(tag string) string {
+	return aliases[strings.ToLower(strings.TrimSpace(tag))]
+}
+
+// Tokens returns the non-plain tokens of src in order. Languages without a
+// lexer have none.
+func Tokens(language string, src []byte) []Token {
+	lx, ok := lexers[Canonical(language)]
+	if !ok {
+		return nil
+	}
+	return lx.scan(src)
+}
+
+func (lx *lexer) scan(src []byte) []Token {
+	var out []Token
+	emit := func(start, end int, kind Kind) {
+		if end > start {
+			out = append(out, Token{Start: start, End: end, Kind: kind})
+		}
+	}
+	lineStart := true
+	for i := 0; i < len(src); {
+		c := src[i]
+		if c == '\n' {
+			lineStart = true
+			i++
+			continue
+		}
+		if lineStart && lx.yamlKeys {
+			if start, end, ok := yamlKey(src, i); ok {
+				emit(start, end, Key)
+				i, lineStart = end, false
+				continue
+			}
+		}
+		if c != ' ' && c != '\t' {
+			lineStart = false
+		}
+		if end := lx.comment(src, i); end > i {
+			emit(i, end, Comment)
+			i = end
+			continue
+		}
+		if q, ok := lx.quoteAt(src, i); ok {
+			end := q.scan(src, i)
+			kind := String
+			if lx.jsonKeys && followedByColon(src, end) {
+				kind = Key
+			}
+			emit(i, end, kind)
+			i = end
+			continue
+		}
+		if lx.variables && c == '$' {
+			if end := variableEnd(src, i); end > i {
+				emit(i, end, Variable)
+				i = end
+				continue
+			}
+		}
+		if isDigit(c) || (c == '.' && i+1 < len(src) && isDigit(src[i+1])) {
+			end := i + 1
+			for end < len(src) && (isIdent(src[end]) || src[end] == '.') {
+				end++
+			}
+			if i == 0 || !isIdent(src[i-1]) {
+				emit(i, end, Number)
+			}
+			i = end
+			continue
+		}
+		if isIdent(c) {
+			end := i + 1
+			for end < len(src) && isIdent(src[end]) {
+				end++
+			}
+			word := string(src[i:end])
+			if lx.foldCase {
+				word = strings.ToLower(word)
+			}
+			if kind, ok := lx.keywords[word]; ok {
+				emit(i, end, kind)
+			}
+			i = end
+			continue
+		}
+		i++
+	}
+	return out
+}
+
+// comment returns the end of a comment starting at i, or i.
+func (lx *lexer) comment(src []byte, i int) int {
+	rest := src[i:]
+	if open := lx.blockComment[0]; open != "" && hasPrefix(rest, open) {
+		if n := strings.Index(string(rest[len(open):]), lx.blockComment[1]); n >= 0 {
+			return i + len(open) + n + len(lx.blockComment[1])
+		}
+		return len(src)
+	}
+	for _, prefix := range lx.lineComments {
+		if !hasPrefix(rest, prefix) {
+			continue
+		}
+		if lx.commentAfterSpace && i > 0 && src[i-1] != ' ' && src[i-1] != '\t' && src[i-1] != '\n' {
+			return i
+		}
+		end := i
+		for end < len(src) && src[end] != '\n' {
+			end++
+		}
+		return end
+	}
+	return i
+}
+
+func (lx *lexer) quoteAt(src []byte, i int) (quote, bool) {
+	for _, q := range lx.quotes {
+		if hasPrefix(src[i:], q.open) {
+			return q, true
+		}
+	}
+	return quote{}, false
+}
+
+// scan returns the end of the string starting at i. An unterminated string
+// runs to the end of its line, or of src when it may span lines.
+func (q quote) scan(src []byte, i int) int {
+	j := i + len(q.open)
+	for j < len(src) {
+		switch {
+		case q.escapes && src[j] == '\\':
+			j += 2
+			continue
+		case src[j] == '\n' && !q.multiline:
+			return j
+		case hasPrefix(src[j:], q.close):
+			return j + len(q.close)
+		}
+		j++
+	}
+	return len(src)
+}
+
+// yamlKey recognises a line that starts a mapping entry, "key:" after any
+// indentation and sequence dashes, and returns where the key starts and
+// ends.
+func yamlKey(src []byte, i int) (int, int, bool) {
+	j := i
+	for j < len(src) && (src[j] == ' ' || src[j] == '\t' || (src[j] == '-' && j+1 < len(src) && src[j+1] == ' ')) {
+		j++
+	}
+	start := j
+	for j < len(src) && src[j] != '\n' {
+		switch src[j] {
+		case '#', '"', '\'', '{', '[':
+			return 0, 0, false
+		case ':':
+			if j > start && (j+1 == len(src) || src[j+1] == ' ' || src[j+1] == '\n') {
+				return start, j, true
+			}
+		}
+		j++
+	}
+	return 0, 0, false
+}
+
+func variableEnd(src []byte, i int) int {
+	j := i + 1
+	if j >= len(src) {
+		return j
+	}
+	if src[j] == '{' {
+		for j < len(src) && src[j] != '}' && src[j] != '\n' {
+			j++
+		}
+		if j < len(src) && src[j] == '}' {
+			j++
+		}
+		return j
+	}
+	if !isIdent(src[j]) {
+		if strings.IndexByte("?!#$@*-", src[j]) >= 0 {
+			return j + 1
+		}
+		return i
+	}
+	for j < len(src) && isIdent(src[j]) {
+		j++
+	}
+	return j
+}
+
+func followedByColon(src []byte, i int) bool {
+	for i < len(src) && (src[i] == ' ' || src[i] == '\t') {
+		i++
+	}
+	return i < len(src) && src[i] == ':'
+}
+
+func hasPrefix(b []byte, prefix string) bool {
+	return len(b) >= len(prefix) && string(b[:len(prefix)]) == prefix
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// isIdent treats every byte of a multi-byte character as a letter so
+// non-ASCII identifiers stay whole.
+func isIdent(c byte) bool {
+	return c == '_' || isDigit(c) || (c|0x20 >= 'a' && c|0x20 <= 'z') || c >= 0x80
+}
@@ -0,0 +1,56 @@
+package highlight
+
+import (
+	"reflect"
+	"testing"
+)
+
+// spans renders tokens as "kind:text" for readable comparisons.
+func spans(language, src string) []string {
+	names := map[Kind]string{Keyword: "kw", Builtin: "bi", String: "str", Number: "num", Comment: "com", Key: "key", Variable: "var"}
+	var out []string
+	for _, t := range Tokens(language, []byte(src)) {
+		out = append(out, names[t.Kind]+":"+src[t.Start:t.End])
+	}
+	return out
+}
+
+func TestTokens(t *testing.T) {
+	cases := []struct {
+		language, src string
+		want          []string
+	}{
+		{"go", "func f() int { return 0x1F } // done", []string{"kw:func", "bi:int", "kw:return", "num:0x1F", "com:// done"}},
+		{"golang", "s := `a\n\"b` + \"c\\\"\"", []string{"str:`a\n\"b`", `str:"c\""`}},
+		{"py", "def f(x):\n    '''doc\n    # not a comment'''\n    return None  # x2", []string{"kw:def", "str:'''doc\n    # not a comment'''", "kw:return", "bi:None", "com:# x2"}},
+		{"js", "const n = 1.5e3; /* c */ let s = `t`", []string{"kw:const", "num:1.5e3", "com:/* c */", "kw:let", "str:`t`"}},
+		{"json", `{"a": [true, "b", -2]}`, []string{`key:"a"`, "bi:true", `str:"b"`, "num:2"}},
+		{"bash", "echo \"$HOME\" ${x} a#b # c", []string{"bi:echo", `str:"$HOME"`, "var:${x}", "com:# c"}},
+		{"sql", "SELECT id FROM t WHERE name = 'it''s' -- c", []string{"kw:SELECT", "kw:FROM", "kw:WHERE", "str:'it'", "str:'s'", "com:-- c"}},
+		{"yml", "items:\n  - name: x # y\n    on: true\nurl: http://a#b", []string{"key:items", "key:name", "com:# y", "key:on", "bi:true", "key:url"}},
+	}
+	for _, c := range cases {
+		if got := spans(c.language, c.src); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s %q:\n got %q\nwant %q", c.language, c.src, got, c.want)
+		}
+	}
+}
+
+func TestUnknownLanguageIsPlain(t *testing.T) {
+	if got := Tokens("cobol", []byte("MOVE 1 TO X")); got != nil {
+		t.Fatalf("Tokens = %v", got)
+	}
+	if Canonical(" YAML ") != "yaml" || Canonical("text") != "" {
+		t.Fatal("Canonical does not normalise tags")
+	}
+	if got := Languages(); len(got) != 7 || got[0] != "go" {
+		t.Fatalf("Languages() = %q", got)
+	}
+}
+
+func TestUnterminatedString(t *testing.T) {
+	want := []string{`str:"abc`, "kw:return"}
+	if got := spans("go", "\"abc\nreturn"); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
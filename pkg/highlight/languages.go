@@ -0,0 +1,95 @@
+package highlight
+
+import "strings"
+
+var lexers = map[string]*lexer{
+	"go": {
+		lineComments: []string{"//"},
+		blockComment: [2]string{"/*", "*/"},
+		quotes: []quote{
+			{open: `"`, close: `"`, escapes: true},
+			{open: "'", close: "'", escapes: true},
+			{open: "`", close: "`", multiline: true},
+		},
+		keywords: words(map[Kind]string{
+			Keyword: "break case chan const continue default defer else fallthrough for func go goto if import interface map package range return select struct switch type var",
+			Builtin: "true false nil iota any bool byte comparable complex64 complex128 error float32 float64 int int8 int16 int32 int64 rune string uint uint8 uint16 uint32 uint64 uintptr append cap clear close complex copy delete imag len make max min new panic print println real recover",
+		}),
+	},
+	"python": {
+		lineComments: []string{"#"},
+		quotes: []quote{
+			{open: `"""`, close: `"""`, escapes: true, multiline: true},
+			{open: `'''`, close: `'''`, escapes: true, multiline: true},
+			{open: `"`, close: `"`, escapes: true},
+			{open: "'", close: "'", escapes: true},
+		},
+		keywords: words(map[Kind]string{
+			Keyword: "and as assert async await break class continue def del elif else except finally for from global if import in is lambda match nonlocal not or pass raise return try while with yield",
+			Builtin: "True False None self abs all any bool bytes dict enumerate filter float int isinstance len list map max min object open print range repr set sorted str sum super tuple type zip",
+		}),
+	},
+	"javascript": {
+		lineComments: []string{"//"},
+		blockComment: [2]string{"/*", "*/"},
+		quotes: []quote{
+			{open: `"`, close: `"`, escapes: true},
+			{open: "'", close: "'", escapes: true},
+			{open: "`", close: "`", escapes: true, multiline: true},
+		},
+		keywords: words(map[Kind]string{
+			Keyword: "async await break case catch class const continue debugger default delete do else export extends finally for function if import in instanceof let new of return static super switch this throw try typeof var void while with yield",
+			Builtin: "true false null undefined NaN Infinity Array Boolean Date Error JSON Map Math Number Object Promise RegExp Set String Symbol console document window",
+		}),
+	},
+	"json": {
+		quotes:   []quote{{open: `"`, close: `"`, escapes: true}},
+		keywords: words(map[Kind]string{Builtin: "true false null"}),
+		jsonKeys: true,
+	},
+	"shell": {
+		lineComments:      []string{"#"},
+		commentAfterSpace: true,
+		quotes: []quote{
+			{open: `"`, close: `"`, escapes: true, multiline: true},
+			{open: "'", close: "'", multiline: true},
+		},
+		keywords: words(map[Kind]string{
+			Keyword: "case declare do done elif else esac exit export fi for function if in local readonly return select shift then time until unset while",
+			Builtin: "alias cd echo eval exec false printf pwd read set source test trap true",
+		}),
+		variables: true,
+	},
+	"sql": {
+		lineComments: []string{"--"},
+		blockComment: [2]string{"/*", "*/"},
+		quotes:       []quote{{open: "'", close: "'"}},
+		keywords: words(map[Kind]string{
+			Keyword: "add all alter and as asc begin between by case check column commit constraint create cross default delete desc distinct drop else end exists foreign from full group having if in index inner insert into is join key left like limit not null offset on or order outer primary references returning right rollback select set table then transaction union unique update values view when where with",
+			Builtin: "true false bigint blob boolean char date decimal double float int integer numeric real serial smallint text timestamp varchar avg coalesce count max min now sum",
+		}),
+		foldCase: true,
+	},
+	"yaml": {
+		lineComments:      []string{"#"},
+		commentAfterSpace: true,
+		quotes: []quote{
+			{open: `"`, close: `"`, escapes: true},
+			{open: "'", close: "'"},
+		},
+		keywords: words(map[Kind]string{Builtin: "true false null yes no on off"}),
+		jsonKeys: true,
+		yamlKeys: true,
+	},
+}
+
+// words builds a keyword table from space-separated lists.
+func words(lists map[Kind]string) map[string]Kind {
+	out := map[string]Kind{}
+	for kind, list := range lists {
+		for _, w := range strings.Fields(list) {
+			out[w] = kind
+		}
+	}
+	return out
+}
@@ -0,0 +1,174 @@
+package sqdoc
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// MaxBookmarkName is the longest bookmark name.
+const MaxBookmarkName = 64
+
+// Bookmark names a place in a text block, a byte offset into its text.
+// Editors move it as text before it changes, so links and cross-references
+// to it survive edits.
+type Bookmark struct {
+	Name    string
+	BlockID uint64
+	Offset  uint32
+}
+
+// RefShow selects what a cross-reference shows of its target.
+type RefShow uint8
+
+const (
+	RefShowText RefShow = iota
+	RefShowNumber
+)
+
+// CrossRef makes a run a field showing the heading text or number of the
+// section an anchor lies in. The run's text holds the last value shown, so
+// readers that ignore fields still show it.
+type CrossRef struct {
+	// Target is an anchor name, without the '#' of a link.
+	Target string
+	Show   RefShow
+}
+
+// ValidBookmarkName reports whether name may name a bookmark: letters,
+// digits, '-', '_' and '.', at most MaxBookmarkName bytes.
+func ValidBookmarkName(name string) bool {
+	if name == "" || len(name) > MaxBookmarkName || !utf8.ValidString(name) {
+		return false
+	}
+	for _, r := range name {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && !strings.ContainsRune("-_.", r) {
+			return false
+		}
+	}
+	return true
+}
+
+func validateRef(ref CrossRef) error {
+	if ref.Show > RefShowNumber {
+		return fmt.Errorf("unknown cross-reference display %d", ref.Show)
+	}
+	if ref.Target == "" {
+		if ref.Show != RefShowText {
+			return fmt.Errorf("cross-reference without a target")
+		}
+		return nil
+	}
+	return validateLink("#" + ref.Target)
+}
+
+func validateBookmarks(doc *Document) []error {
+	var problems []error
+	seen := map[string]bool{}
+	for _, bm := range doc.Bookmarks {
+		if !ValidBookmarkName(bm.Name) {
+			problems = append(problems, fmt.Errorf("sqdoc: invalid bookmark name %q", bm.Name))
+		} else if seen[bm.Name] {
+			problems = append(problems, fmt.Errorf("sqdoc: duplicate bookmark %q", bm.Name))
+		}
+		seen[bm.Name] = true
+		for _, b := range doc.Blocks {
+			if b.ID != bm.BlockID {
+				continue
+			}
+			if b.Text == nil {
+				problems = append(problems, fmt.Errorf("sqdoc: bookmark %q is not in a text block", bm.Name))
+			} else if int(bm.Offset) > len(b.Text.UTF8) {
+				problems = append(problems, fmt.Errorf("sqdoc: bookmark %q offset %d outside block %d", bm.Name, bm.Offset, b.ID))
+			}
+		}
+	}
+	return problems
+}
+
+// Anchor is a place internal links and cross-references can name.
+type Anchor struct {
+	Block  int
+	Offset int
+}
+
+// OutlineEntry is a heading in the document outline. Number is its
+// section number, such as "4.2", counted from the highest heading level the
+// document uses.
+type OutlineEntry struct {
+	Block  int
+	Level  int
+	Number string
+	Text   string
+}
+
+// Outline lists the headings of d in order.
+func (d *Document) Outline() []OutlineEntry {
+	var out []OutlineEntry
+	top := 0
+	for i, b := range d.Blocks {
+		if b.Text == nil || b.Kind == BlockKindNote {
+			continue
+		}
+		level := HeadingStyleLevel(b.Text.Style)
+		if level == 0 {
+			continue
+		}
+		if top == 0 || level < top {
+			top = level
+		}
+		out = append(out, OutlineEntry{Block: i, Level: level, Text: headingText(b.Text)})
+	}
+	var counters [6]int
+	for i := range out {
+		depth := out[i].Level - top
+		counters[depth]++
+		for k := depth + 1; k < len(counters); k++ {
+			counters[k] = 0
+		}
+		parts := make([]string, depth+1)
+		for k := range parts {
+			parts[k] = strconv.Itoa(counters[k])
+		}
+		out[i].Number = strings.Join(parts, ".")
+	}
+	return out
+}
+
+// headingText is the text of a paragraph as one line, without image tokens.
+func headingText(tb *TextBlock) string {
+	var b strings.Builder
+	pos := 0
+	for _, img := range ParseImageTokens(tb.UTF8) {
+		b.Write(tb.UTF8[pos:img.Start])
+		pos = img.End
+	}
+	b.Write(tb.UTF8[pos:])
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+// RefResolver returns a function giving the text a cross-reference shows in
+// d as it stands: the text or number of the heading at or before its
+// target. Targets before the first heading show their own paragraph's text
+// either way. It reports false for a target d does not have.
+func (d *Document) RefResolver() func(CrossRef) (string, bool) {
+	anchors := d.Anchors()
+	outline := d.Outline()
+	return func(ref CrossRef) (string, bool) {
+		at, ok := anchors[ref.Target]
+		if !ok {
+			return "", false
+		}
+		n := sort.Search(len(outline), func(k int) bool { return outline[k].Block > at.Block }) - 1
+		if n < 0 {
+			return headingText(d.Blocks[at.Block].Text), true
+		}
+		if ref.Show == RefShowNumber {
+			return outline[n].Number, true
+		}
+		return outline[n].Text, true
+	}
+}
@@ -0,0 +1,90 @@
+package sqdoc
+
+import (
+	"fmt"
+	"testing"
+)
+
+func outlineDoc() *Document {
+	heading := func(id uint64, text string, style uint32) Block {
+		return Block{ID: id, Kind: BlockKindText, Text: &TextBlock{UTF8: []byte(text), Style: style}}
+	}
+	doc := NewDocument("", "")
+	doc.Blocks = []Block{
+		heading(1, "Preface text", 0),
+		heading(2, "Scope", StyleHeading2),
+		heading(3, "Body", 0),
+		heading(4, "Design", StyleHeading2),
+		heading(5, "Storage", StyleHeading3),
+		heading(6, "Limits  apply", StyleHeading3),
+		heading(7, "See below", 0),
+	}
+	return doc
+}
+
+func TestOutlineAndRefs(t *testing.T) {
+	doc := outlineDoc()
+	var numbers []string
+	for _, e := range doc.Outline() {
+		numbers = append(numbers, e.Number+" "+e.Text)
+	}
+	if got := fmt.Sprint(numbers); got != "[1 Scope 2 Design 2.1 Storage 2.2 Limits apply]" {
+		t.Fatalf("Outline() = %s", got)
+	}
+
+	doc.Bookmarks = []Bookmark{{Name: "limits", BlockID: 6, Offset: 7}, {Name: "design", BlockID: 3}, {Name: "early", BlockID: 1, Offset: 3}}
+	anchors := doc.Anchors()
+	if anchors["limits"] != (Anchor{Block: 5, Offset: 7}) || anchors["design"] != (Anchor{Block: 2}) || anchors["storage"] != (Anchor{Block: 4}) {
+		t.Fatalf("Anchors() = %v", anchors)
+	}
+	resolve := doc.RefResolver()
+	for _, tc := range []struct {
+		ref  CrossRef
+		want string
+		ok   bool
+	}{
+		{CrossRef{Target: "limits", Show: RefShowNumber}, "2.2", true},
+		{CrossRef{Target: "limits"}, "Limits apply", true},
+		{CrossRef{Target: "design", Show: RefShowNumber}, "1", true},
+		{CrossRef{Target: "early", Show: RefShowNumber}, "Preface text", true},
+		{CrossRef{Target: "gone"}, "", false},
+	} {
+		if got, ok := resolve(tc.ref); got != tc.want || ok != tc.ok {
+			t.Errorf("resolve(%v) = %q, %v", tc.ref, got, ok)
+		}
+	}
+}
+
+func TestBookmarkRoundTrip(t *testing.T) {
+	doc := outlineDoc()
+	doc.Bookmarks = []Bookmark{{Name: "limits", BlockID: 6, Offset: 7}, {Name: "lost", BlockID: 99}}
+	ref := StyleAttr{FontSizePt: 14, Ref: CrossRef{Target: "limits", Show: RefShowNumber}}
+	doc.Blocks[6].Text.Runs = []StyleRun{{Start: 0, End: 4, Attr: StyleAttr{FontSizePt: 14}}, {Start: 4, End: 9, Attr: ref}}
+	table := NewTable(1, 1)
+	table.Rows[0][0].Text.UTF8 = []byte("2.2")
+	table.Rows[0][0].Text.Runs = []StyleRun{{Start: 0, End: 3, Attr: ref}}
+	doc.Blocks = append(doc.Blocks, Block{ID: 8, Kind: BlockKindTable, Table: table})
+
+	blob, err := Encode(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := Decode(blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded.Bookmarks) != 1 || loaded.Bookmarks[0] != doc.Bookmarks[0] {
+		t.Fatalf("bookmarks = %v", loaded.Bookmarks)
+	}
+	if got := loaded.Blocks[6].Text.Runs[1].Attr.Ref; got != ref.Ref {
+		t.Fatalf("ref = %v", got)
+	}
+	if got := loaded.Blocks[7].Table.Rows[0][0].Text.Runs[0].Attr.Ref; got != ref.Ref {
+		t.Fatalf("cell ref = %v", got)
+	}
+
+	doc.Bookmarks = []Bookmark{{Name: "a b", BlockID: 2}, {Name: "x", BlockID: 2, Offset: 99}, {Name: "x", BlockID: 3}}
+	if errs := ValidateAll(doc); len(errs) != 3 {
+		t.Fatalf("ValidateAll() = %v", errs)
+	}
+}
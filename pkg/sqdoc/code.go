@@ -0,0 +1,74 @@
+package sqdoc
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// MaxCodeLanguage is the longest language tag a code block may carry.
+const MaxCodeLanguage = 32
+
+// CodeBlock makes a text block a block of source code. Its text keeps line
+// breaks and is shown in a monospace face without wrapping; colouring is
+// left to the reader and never stored as runs.
+type CodeBlock struct {
+	// Language names the code's language, such as "go" or "sql"; empty
+	// means plain text.
+	Language    string
+	LineNumbers bool
+}
+
+// Code flags in the paragraph record.
+const (
+	codeFlagBlock       = 1 << 0
+	codeFlagLineNumbers = 1 << 1
+)
+
+func validateCode(tb *TextBlock) error {
+	if tb.Code == nil {
+		return nil
+	}
+	if tb.List != (ListItem{}) {
+		return errors.New("code blocks cannot be list items")
+	}
+	lang := tb.Code.Language
+	if len(lang) > MaxCodeLanguage || !utf8.ValidString(lang) || strings.ContainsFunc(lang, func(r rune) bool { return r <= ' ' }) {
+		return fmt.Errorf("invalid code language %q", lang)
+	}
+	return nil
+}
+
+func codeEqual(a, b *CodeBlock) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+func appendCode(out []byte, c *CodeBlock) []byte {
+	if c == nil {
+		return appendString(append(out, 0), "")
+	}
+	flags := byte(codeFlagBlock)
+	if c.LineNumbers {
+		flags |= codeFlagLineNumbers
+	}
+	return appendString(append(out, flags), c.Language)
+}
+
+// readCode is the inverse of appendCode, returning the bytes after it.
+func readCode(b []byte) (*CodeBlock, []byte, bool) {
+	if len(b) < 1 {
+		return nil, nil, false
+	}
+	lang, rest, ok := readString(b[1:])
+	if !ok {
+		return nil, nil, false
+	}
+	if b[0]&codeFlagBlock == 0 {
+		return nil, rest, true
+	}
+	return &CodeBlock{Language: lang, LineNumbers: b[0]&codeFlagLineNumbers != 0}, rest, true
+}
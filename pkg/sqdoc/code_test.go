@@ -0,0 +1,47 @@
+package sqdoc
+
+import "testing"
+
+func TestCodeBlockRoundTrip(t *testing.T) {
+	doc := NewDocument("", "")
+	doc.Blocks = []Block{
+		{ID: 1, Kind: BlockKindText, Text: &TextBlock{UTF8: []byte("func main() {\n\tprintln()\n}"), Code: &CodeBlock{Language: "go", LineNumbers: true}}},
+		{ID: 2, Kind: BlockKindText, Text: &TextBlock{UTF8: []byte("plain"), Code: &CodeBlock{}}},
+		{ID: 3, Kind: BlockKindText, Text: &TextBlock{UTF8: []byte("prose"), Para: ParagraphFormat{Align: AlignCenter}}},
+	}
+	blob, err := Encode(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := Decode(blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range doc.Blocks {
+		if !blocksEqual(doc.Blocks[i], loaded.Blocks[i]) {
+			t.Fatalf("block %d = %+v, want %+v", i, loaded.Blocks[i].Text, doc.Blocks[i].Text)
+		}
+	}
+	if loaded.Blocks[2].Text.Code != nil {
+		t.Fatal("a paragraph came back as code")
+	}
+
+	clone := CloneDocument(doc)
+	clone.Blocks[0].Text.Code.Language = "sql"
+	if doc.Blocks[0].Text.Code.Language != "go" {
+		t.Fatal("CloneDocument shares code properties")
+	}
+}
+
+func TestValidateCodeBlocks(t *testing.T) {
+	doc := NewDocument("", "")
+	doc.Blocks = []Block{
+		{ID: 1, Kind: BlockKindText, Text: &TextBlock{Code: &CodeBlock{Language: "c sharp"}}},
+		{ID: 2, Kind: BlockKindText, Text: &TextBlock{Code: &CodeBlock{}, List: ListItem{ID: 1}}},
+		{ID: 3, Kind: BlockKindTable, Table: NewTable(1, 1)},
+	}
+	doc.Blocks[2].Table.Rows[0][0].Text.Code = &CodeBlock{}
+	if errs := ValidateAll(doc); len(errs) != 3 {
+		t.Fatalf("got %d problems: %v", len(errs), errs)
+	}
+}
@@ -0,0 +1,192 @@
+package sqdoc
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"slices"
+	"unicode/utf8"
+)
+
+// BlockKindComment blocks hold a review comment thread. They are not part of
+// the document's flow: in memory the threads are Document.Comments, and
+// each is written as one block whose ID is the thread's.
+const BlockKindComment BlockKind = 7
+
+// MaxCommentText is the longest comment, in bytes.
+const MaxCommentText = 16 << 10
+
+// Comment is one message of a thread.
+type Comment struct {
+	Author      string
+	CreatedUnix int64
+	Text        string
+}
+
+// CommentThread is a discussion of the bytes Start..End of a text block:
+// the comment that opened it, then the replies. Editors move the range with
+// the text around it, as they move bookmarks.
+type CommentThread struct {
+	ID         uint64
+	BlockID    uint64
+	Start, End uint32
+	Resolved   bool
+	Comments   []Comment
+}
+
+func cloneComments(threads []CommentThread) []CommentThread {
+	out := slices.Clone(threads)
+	for i := range out {
+		out[i].Comments = slices.Clone(out[i].Comments)
+	}
+	return out
+}
+
+func validateComments(doc *Document) []error {
+	var problems []error
+	ids := map[uint64]bool{}
+	blocks := map[uint64]*Block{}
+	for i := range doc.Blocks {
+		ids[doc.Blocks[i].ID] = true
+		blocks[doc.Blocks[i].ID] = &doc.Blocks[i]
+	}
+	for _, t := range doc.Comments {
+		if t.ID == 0 || t.ID == fmtBlockID {
+			problems = append(problems, fmt.Errorf("sqdoc: comment thread id %d is reserved", t.ID))
+		} else if ids[t.ID] {
+			problems = append(problems, fmt.Errorf("sqdoc: duplicate block id %d", t.ID))
+		}
+		ids[t.ID] = true
+		if len(t.Comments) == 0 {
+			problems = append(problems, fmt.Errorf("sqdoc: comment thread %d is empty", t.ID))
+		}
+		for _, c := range t.Comments {
+			if !utf8.ValidString(c.Author) || !utf8.ValidString(c.Text) || len(c.Text) > MaxCommentText {
+				problems = append(problems, fmt.Errorf("sqdoc: comment thread %d holds invalid text", t.ID))
+				break
+			}
+		}
+		if t.Start > t.End {
+			problems = append(problems, fmt.Errorf("sqdoc: comment thread %d range %d..%d is reversed", t.ID, t.Start, t.End))
+		}
+		if b := blocks[t.BlockID]; b != nil {
+			if b.Kind != BlockKindText || b.Text == nil {
+				problems = append(problems, fmt.Errorf("sqdoc: comment thread %d is not on a text block", t.ID))
+			} else if int(t.End) > len(b.Text.UTF8) {
+				problems = append(problems, fmt.Errorf("sqdoc: comment thread %d range outside block %d", t.ID, b.ID))
+			}
+		}
+	}
+	return problems
+}
+
+// encodeComment writes the thread's range and state, then its comments.
+func encodeComment(t CommentThread) []byte {
+	out := appendU64(nil, t.BlockID)
+	out = appendU32(out, t.Start)
+	out = appendU32(out, t.End)
+	flags := byte(0)
+	if t.Resolved {
+		flags |= 1
+	}
+	out = append(out, flags)
+	out = appendU32(out, uint32(len(t.Comments)))
+	for _, c := range t.Comments {
+		out = appendString(out, c.Author)
+		out = appendI64(out, c.CreatedUnix)
+		out = appendString(out, c.Text)
+	}
+	return out
+}
+
+func decodeComment(id uint64, b []byte) (CommentThread, error) {
+	malformed := errors.New("sqdoc: malformed comment block")
+	if len(b) < 8+4+4+1+4 {
+		return CommentThread{}, malformed
+	}
+	t := CommentThread{
+		ID:       id,
+		BlockID:  binary.LittleEndian.Uint64(b[:8]),
+		Start:    binary.LittleEndian.Uint32(b[8:12]),
+		End:      binary.LittleEndian.Uint32(b[12:16]),
+		Resolved: b[16]&1 != 0,
+	}
+	count := int(binary.LittleEndian.Uint32(b[17:21]))
+	b = b[21:]
+	for i := 0; i < count; i++ {
+		var c Comment
+		var ok bool
+		if c.Author, b, ok = readString(b); !ok || len(b) < 8 {
+			return t, malformed
+		}
+		c.CreatedUnix = int64(binary.LittleEndian.Uint64(b[:8]))
+		if c.Text, b, ok = readString(b[8:]); !ok {
+			return t, malformed
+		}
+		t.Comments = append(t.Comments, c)
+	}
+	return t, nil
+}
+
+// mergeComments takes each thread from the side that changed it. A thread
+// both sides changed keeps the local copy with the remote side's new
+// comments after its own, so no reply is lost; one that a side deleted
+// stays deleted unless the other side changed it.
+func mergeComments(base, local, remote []CommentThread, blocks []Block) []CommentThread {
+	index := func(threads []CommentThread) map[uint64]CommentThread {
+		out := make(map[uint64]CommentThread, len(threads))
+		for _, t := range threads {
+			out[t.ID] = t
+		}
+		return out
+	}
+	baseByID, localByID, remoteByID := index(base), index(local), index(remote)
+	var out []CommentThread
+	add := func(t CommentThread) {
+		if !slices.ContainsFunc(out, func(o CommentThread) bool { return o.ID == t.ID }) {
+			out = append(out, cloneComments([]CommentThread{t})[0])
+		}
+	}
+	for _, t := range append(slices.Clone(remote), local...) {
+		b, hasBase := baseByID[t.ID]
+		l, hasLocal := localByID[t.ID]
+		r, hasRemote := remoteByID[t.ID]
+		switch {
+		case hasLocal && hasRemote:
+			switch {
+			case hasBase && threadsEqual(l, b):
+				add(r)
+			case hasBase && threadsEqual(r, b):
+				add(l)
+			default:
+				for _, c := range r.Comments {
+					if !slices.Contains(l.Comments, c) {
+						l.Comments = append(slices.Clone(l.Comments), c)
+					}
+				}
+				add(l)
+			}
+		case hasLocal:
+			if !hasBase || !threadsEqual(l, b) {
+				add(l)
+			}
+		case hasRemote:
+			if !hasBase || !threadsEqual(r, b) {
+				add(r)
+			}
+		}
+	}
+	for i := range out {
+		for _, b := range blocks {
+			if b.ID == out[i].BlockID && b.Text != nil {
+				n := uint32(len(b.Text.UTF8))
+				out[i].Start, out[i].End = min(out[i].Start, n), min(out[i].End, n)
+			}
+		}
+	}
+	return out
+}
+
+func threadsEqual(a, b CommentThread) bool {
+	return a.BlockID == b.BlockID && a.Start == b.Start && a.End == b.End && a.Resolved == b.Resolved && slices.Equal(a.Comments, b.Comments)
+}
@@ -0,0 +1,66 @@
+package sqdoc
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestCommentRoundTrip(t *testing.T) {
+	doc := outlineDoc()
+	doc.Comments = []CommentThread{
+		{ID: 20, BlockID: 6, Start: 0, End: 6, Resolved: true, Comments: []Comment{
+			{Author: "Ana", CreatedUnix: 1700000000, Text: "Which limits?"},
+			{Author: "Rui", CreatedUnix: 1700000100, Text: "Size and depth.\nSee §4."},
+		}},
+		{ID: 21, BlockID: 99, Comments: []Comment{{Author: "Ana", Text: "Lost"}}},
+	}
+	blob, err := Encode(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := Decode(blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded.Comments) != 1 || !threadsEqual(loaded.Comments[0], doc.Comments[0]) || loaded.Comments[0].ID != 20 {
+		t.Fatalf("comments = %+v", loaded.Comments)
+	}
+
+	doc.Comments = []CommentThread{
+		{ID: 3, BlockID: 1, Comments: []Comment{{Text: "x"}}},
+		{ID: 22, BlockID: 1, Start: 4, End: 2, Comments: []Comment{{Text: "x"}}},
+		{ID: 23, BlockID: 1, End: 99},
+	}
+	if errs := ValidateAll(doc); len(errs) != 4 {
+		t.Fatalf("ValidateAll() = %v", errs)
+	}
+}
+
+func TestMergeKeepsRepliesFromBothSides(t *testing.T) {
+	base := outlineDoc()
+	base.Comments = []CommentThread{
+		{ID: 20, BlockID: 1, End: 7, Comments: []Comment{{Author: "Ana", Text: "Shorter?"}}},
+		{ID: 21, BlockID: 2, End: 5, Comments: []Comment{{Author: "Ana", Text: "Rename"}}},
+	}
+	local, remote := CloneDocument(base), CloneDocument(base)
+	local.Comments[0].Comments = append(local.Comments[0].Comments, Comment{Author: "Rui", Text: "Yes"})
+	remote.Comments[0].Comments = append(remote.Comments[0].Comments, Comment{Author: "Eva", Text: "No"})
+	remote.Comments[0].Resolved = true
+	local.Comments = local.Comments[:1]
+	remote.Comments = append(remote.Comments, CommentThread{ID: 30, BlockID: 3, Comments: []Comment{{Text: "New"}}})
+
+	merged, _ := MergeDocuments(base, local, remote)
+	var ids []uint64
+	for _, th := range merged.Comments {
+		ids = append(ids, th.ID)
+	}
+	if !slices.Equal(ids, []uint64{20, 30}) {
+		t.Fatalf("threads = %v", ids)
+	}
+	if got := merged.Comments[0].Comments; len(got) != 3 || got[1].Text != "Yes" || got[2].Text != "No" {
+		t.Fatalf("replies = %+v", got)
+	}
+	if err := Validate(merged); err != nil {
+		t.Fatal(err)
+	}
+}
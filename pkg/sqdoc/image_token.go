@@ -0,0 +1,80 @@
+package sqdoc
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+)
+
+// Inline images are stored in text payloads as self-delimiting tokens so
+// older readers still see ordinary text.
+const (
+	ImageTokenPrefix = "[[imgb64:"
+	ImageTokenSuffix = "]]"
+)
+
+type ImageToken struct {
+	Start  int
+	End    int
+	Path   string
+	Width  int
+	Height int
+}
+
+type imageTokenPayload struct {
+	Path string `json:"p"`
+	W    int    `json:"w,omitempty"`
+	H    int    `json:"h,omitempty"`
+}
+
+// MakeImageToken encodes path and an optional display size (0 = natural).
+func MakeImageToken(path string, w, h int) string {
+	payload := imageTokenPayload{Path: path, W: max(0, w), H: max(0, h)}
+	blob, err := json.Marshal(payload)
+	if err != nil {
+		blob = []byte(payload.Path)
+	}
+	return ImageTokenPrefix + base64.RawURLEncoding.EncodeToString(blob) + ImageTokenSuffix
+}
+
+// ParseImageTokens returns the well-formed tokens in text with byte offsets.
+// Tokens written by early builds hold the bare path instead of JSON.
+func ParseImageTokens(text []byte) []ImageToken {
+	if len(text) == 0 {
+		return nil
+	}
+	s := string(text)
+	out := make([]ImageToken, 0, 2)
+	pos := 0
+	for pos < len(s) {
+		start := strings.Index(s[pos:], ImageTokenPrefix)
+		if start < 0 {
+			break
+		}
+		start += pos
+		payloadStart := start + len(ImageTokenPrefix)
+		endRel := strings.Index(s[payloadStart:], ImageTokenSuffix)
+		if endRel < 0 {
+			break
+		}
+		payloadEnd := payloadStart + endRel
+		end := payloadEnd + len(ImageTokenSuffix)
+		decoded, err := base64.RawURLEncoding.DecodeString(s[payloadStart:payloadEnd])
+		if err == nil {
+			tok := ImageToken{Start: start, End: end}
+			var payload imageTokenPayload
+			if json.Unmarshal(decoded, &payload) == nil && strings.TrimSpace(payload.Path) != "" {
+				tok.Path = payload.Path
+				tok.Width = max(0, payload.W)
+				tok.Height = max(0, payload.H)
+			} else {
+				tok.Path = string(decoded)
+			}
+			if strings.TrimSpace(tok.Path) != "" {
+				out = append(out, tok)
+			}
+		}
+		pos = end
+	}
+	return out
+}
@@ -0,0 +1,92 @@
+package sqdoc
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"os"
+)
+
+// InspectFileLayout reports the layout stored in path as recorded by its
+// index, including CRCs, without decoding payloads. Secure envelopes are
+// opened first, so offsets are relative to the inner document.
+func InspectFileLayout(path string, opts LoadOptions) (*LayoutInfo, error) {
+	blob, err := readDocumentBytes(path, opts)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := parseTOC(blob)
+	if err != nil {
+		return nil, err
+	}
+	tocOffset, tocLength := tocSpan(blob)
+	return buildLayout(entries, tocOffset, tocLength, uint64(len(blob))), nil
+}
+
+// ValidateFile checks everything Load checks but keeps going after the
+// first failure, returning every problem found. Structural errors in the
+// envelope, header or index still end the scan since nothing after them
+// can be located.
+func ValidateFile(path string, opts LoadOptions) []error {
+	blob, err := readDocumentBytes(path, opts)
+	if err != nil {
+		return []error{err}
+	}
+	entries, err := parseTOC(blob)
+	if err != nil {
+		return []error{err}
+	}
+
+	var problems []error
+	for _, e := range entries {
+		payload := blob[e.Offset : e.Offset+uint64(e.Length)]
+		if crc32.ChecksumIEEE(payload) != e.CRC32 {
+			problems = append(problems, fmt.Errorf("sqdoc: crc mismatch for block %d", e.ID))
+			continue
+		}
+		var err error
+		switch e.Kind {
+		case BlockKindMetadata:
+			_, err = decodeMetadata(payload)
+		case BlockKindStyle:
+			_, err = decodeFormattingDirective(payload)
+		case BlockKindText:
+			_, err = decodeTextBlock(payload)
+		case BlockKindTable:
+			_, err = decodeTable(payload)
+		case BlockKindNote:
+			_, _, err = decodeNote(payload)
+		case BlockKindComment:
+			_, err = decodeComment(e.ID, payload)
+		}
+		if err != nil {
+			problems = append(problems, fmt.Errorf("sqdoc: block %d: %w", e.ID, err))
+		}
+	}
+	if len(problems) > 0 {
+		return problems
+	}
+
+	doc, err := decodeDocument(blob)
+	if err != nil {
+		return []error{err}
+	}
+	return ValidateAll(doc)
+}
+
+func readDocumentBytes(path string, opts LoadOptions) ([]byte, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if isSecureEnvelope(b) {
+		return decodeSecureEnvelope(b, opts)
+	}
+	return b, nil
+}
+
+func tocSpan(blob []byte) (uint64, uint32) {
+	offset := binary.LittleEndian.Uint64(blob[30:38])
+	count := binary.LittleEndian.Uint32(blob[38:42])
+	return offset, count * tocEntSize
+}
@@ -0,0 +1,69 @@
+package sqdoc
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestInspectFileLayoutReportsStoredCRCs(t *testing.T) {
+	doc := NewDocument("Alex", "Draft")
+	doc.Blocks = append(doc.Blocks, Block{ID: 1, Kind: BlockKindText, Text: &TextBlock{UTF8: []byte("first")}})
+
+	path := filepath.Join(t.TempDir(), "layout.sqdoc")
+	if err := SaveWithOptions(path, doc, SaveOptions{Compression: true}); err != nil {
+		t.Fatalf("save failed: %v", err)
+	}
+	info, err := InspectFileLayout(path, LoadOptions{})
+	if err != nil {
+		t.Fatalf("inspect failed: %v", err)
+	}
+	want, err := InspectLayout(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(info.Segments) != len(want.Segments) {
+		t.Fatalf("segment count = %d, want %d", len(info.Segments), len(want.Segments))
+	}
+	for i := range want.Segments {
+		if info.Segments[i] != want.Segments[i] {
+			t.Fatalf("segment %d = %#v, want %#v", i, info.Segments[i], want.Segments[i])
+		}
+	}
+}
+
+func TestValidateFileReportsEveryCorruptBlock(t *testing.T) {
+	doc := NewDocument("Alex", "Draft")
+	for id := uint64(1); id <= 3; id++ {
+		doc.Blocks = append(doc.Blocks, Block{ID: id, Kind: BlockKindText, Text: &TextBlock{UTF8: []byte("block text")}})
+	}
+	path := filepath.Join(t.TempDir(), "corrupt.sqdoc")
+	if err := Save(path, doc); err != nil {
+		t.Fatalf("save failed: %v", err)
+	}
+	if problems := ValidateFile(path, LoadOptions{}); len(problems) != 0 {
+		t.Fatalf("expected clean file, got %v", problems)
+	}
+
+	info, err := InspectFileLayout(path, LoadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	blob, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range info.Segments {
+		if s.Kind == BlockKindText && s.BlockID != 2 {
+			blob[s.Offset+uint64(s.Length)-1] ^= 0xFF
+		}
+	}
+	if err := os.WriteFile(path, blob, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	problems := ValidateFile(path, LoadOptions{})
+	if len(problems) != 2 {
+		t.Fatalf("expected 2 problems, got %v", problems)
+	}
+}
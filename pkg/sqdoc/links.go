@@ -0,0 +1,75 @@
+package sqdoc
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// MaxLinkLength is the longest hyperlink target a run may carry.
+const MaxLinkLength = 2048
+
+func validateLink(target string) error {
+	if target == "" {
+		return nil
+	}
+	if len(target) > MaxLinkLength || !utf8.ValidString(target) || strings.ContainsFunc(target, unicode.IsControl) {
+		return fmt.Errorf("invalid link target %q", target)
+	}
+	return nil
+}
+
+// LinkAnchor returns the place a link target names within the document, or
+// false for a target outside it. Internal targets start with '#'.
+func LinkAnchor(target string) (string, bool) {
+	name, ok := strings.CutPrefix(target, "#")
+	return name, ok && name != ""
+}
+
+// HeadingAnchor turns heading text into the name internal links use for
+// it: lower case, with runs of spaces and punctuation as single hyphens.
+func HeadingAnchor(text string) string {
+	var b strings.Builder
+	hyphen := false
+	for _, r := range strings.ToLower(text) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if hyphen && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			hyphen = false
+			b.WriteRune(r)
+			continue
+		}
+		hyphen = true
+	}
+	return b.String()
+}
+
+// Anchors maps the anchor names of d to the places they name. Headings are
+// named by HeadingAnchor, and of two headings with the same name the first
+// wins; bookmarks take their own names, over any heading's. Bookmarks in
+// blocks d no longer has are left out.
+func (d *Document) Anchors() map[string]Anchor {
+	out := map[string]Anchor{}
+	index := map[uint64]int{}
+	for i, b := range d.Blocks {
+		if b.Text == nil {
+			continue
+		}
+		index[b.ID] = i
+		if HeadingStyleLevel(b.Text.Style) == 0 || b.Kind == BlockKindNote {
+			continue
+		}
+		name := HeadingAnchor(string(b.Text.UTF8))
+		if _, ok := out[name]; !ok && name != "" {
+			out[name] = Anchor{Block: i}
+		}
+	}
+	for _, bm := range d.Bookmarks {
+		if i, ok := index[bm.BlockID]; ok {
+			out[bm.Name] = Anchor{Block: i, Offset: min(int(bm.Offset), len(d.Blocks[i].Text.UTF8))}
+		}
+	}
+	return out
+}
@@ -0,0 +1,72 @@
+package sqdoc
+
+import "testing"
+
+func TestLinkRoundTrip(t *testing.T) {
+	attr := StyleAttr{FontSizePt: 14, ColorRGBA: 0x202020FF}
+	link := attr
+	link.Link = "https://example.com/a?b=c"
+	table := NewTable(1, 2)
+	table.Rows[0][1].Text.UTF8 = []byte("see intro")
+	table.Rows[0][1].Text.Runs = []StyleRun{{Start: 0, End: 4, Attr: attr}, {Start: 4, End: 9, Attr: StyleAttr{FontSizePt: 14, Link: "#intro"}}}
+
+	doc := NewDocument("", "")
+	doc.Blocks = []Block{
+		{ID: 1, Kind: BlockKindText, Text: &TextBlock{UTF8: []byte("go here now"), Runs: []StyleRun{{Start: 0, End: 3, Attr: attr}, {Start: 3, End: 7, Attr: link}, {Start: 7, End: 11, Attr: attr}}}},
+		{ID: 2, Kind: BlockKindTable, Table: table},
+	}
+	blob, err := Encode(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := Decode(blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range doc.Blocks {
+		if !blocksEqual(doc.Blocks[i], loaded.Blocks[i]) {
+			t.Fatalf("block %d changed in round trip", i)
+		}
+	}
+	if got := loaded.Blocks[0].Text.Runs[1].Attr.Link; got != link.Link {
+		t.Fatalf("link = %q", got)
+	}
+	if got := loaded.Blocks[1].Table.Rows[0][1].Text.Runs[1].Attr.Link; got != "#intro" {
+		t.Fatalf("cell link = %q", got)
+	}
+}
+
+func TestAnchors(t *testing.T) {
+	for in, want := range map[string]string{
+		"Getting started!":    "getting-started",
+		"  Q&A: part 2 ":      "q-a-part-2",
+		"Überblick und Ziele": "überblick-und-ziele",
+		"***":                 "",
+	} {
+		if got := HeadingAnchor(in); got != want {
+			t.Errorf("HeadingAnchor(%q) = %q, want %q", in, got, want)
+		}
+	}
+
+	doc := NewDocument("", "")
+	doc.Blocks = []Block{
+		{ID: 1, Kind: BlockKindText, Text: &TextBlock{UTF8: []byte("Intro"), Style: StyleHeading1}},
+		{ID: 2, Kind: BlockKindText, Text: &TextBlock{UTF8: []byte("Body")}},
+		{ID: 3, Kind: BlockKindText, Text: &TextBlock{UTF8: []byte("intro"), Style: StyleHeading2}},
+	}
+	anchors := doc.Anchors()
+	if len(anchors) != 1 || anchors["intro"] != (Anchor{}) {
+		t.Fatalf("Anchors() = %v", anchors)
+	}
+	if name, ok := LinkAnchor("#intro"); !ok || name != "intro" {
+		t.Fatalf("LinkAnchor(#intro) = %q, %v", name, ok)
+	}
+	if _, ok := LinkAnchor("https://example.com/#intro"); ok {
+		t.Fatal("an external link read as an anchor")
+	}
+
+	doc.Blocks[1].Text.Runs = []StyleRun{{Start: 0, End: 4, Attr: StyleAttr{FontSizePt: 14, Link: "bad\nlink"}}}
+	if errs := ValidateAll(doc); len(errs) != 1 {
+		t.Fatalf("ValidateAll() = %v", errs)
+	}
+}
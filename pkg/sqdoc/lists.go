@@ -0,0 +1,147 @@
+package sqdoc
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// MaxListLevel is the deepest nesting level a list item may have.
+const MaxListLevel = 8
+
+type ListStyle uint8
+
+const (
+	ListBullet ListStyle = iota
+	ListDecimal
+	ListLowerAlpha
+	ListUpperAlpha
+	ListLowerRoman
+	ListUpperRoman
+)
+
+// ListItem makes a block an item of a list. Items sharing an ID are
+// numbered together even when other paragraphs sit between them; the zero
+// value is not a list item.
+type ListItem struct {
+	ID    uint32
+	Level uint8
+	Style ListStyle
+	// Start, when non-zero, restarts the numbering at this item.
+	Start uint32
+}
+
+var bulletGlyphs = []string{"•", "◦", "▪"}
+
+// Numbered reports whether the style counts its items.
+func (s ListStyle) Numbered() bool {
+	return s != ListBullet
+}
+
+// Marker formats the label for item number n at the given level.
+func (s ListStyle) Marker(level uint8, n uint32) string {
+	switch s {
+	case ListDecimal:
+		return strconv.FormatUint(uint64(n), 10) + "."
+	case ListLowerAlpha:
+		return alphaNumber(n) + "."
+	case ListUpperAlpha:
+		return strings.ToUpper(alphaNumber(n)) + "."
+	case ListLowerRoman:
+		return strings.ToLower(romanNumber(n)) + "."
+	case ListUpperRoman:
+		return romanNumber(n) + "."
+	default:
+		return bulletGlyphs[int(level)%len(bulletGlyphs)]
+	}
+}
+
+// ListMarkers returns each block's list label, or "" for blocks outside a
+// list.
+func (d *Document) ListMarkers() []string {
+	markers := make([]string, len(d.Blocks))
+	for i, n := range d.ListNumbers() {
+		if n != 0 {
+			item := d.Blocks[i].Text.List
+			markers[i] = item.Style.Marker(item.Level, n)
+		}
+	}
+	return markers
+}
+
+// ListNumbers numbers the document's lists, returning 0 for blocks outside
+// a list. An item continues the count of the previous item at its level in
+// the same list; a shallower item restarts the levels below it.
+func (d *Document) ListNumbers() []uint32 {
+	numbers := make([]uint32, len(d.Blocks))
+	counters := map[uint32]*[MaxListLevel + 1]uint32{}
+	for i, b := range d.Blocks {
+		if b.Text == nil || b.Text.List.ID == 0 {
+			continue
+		}
+		item := b.Text.List
+		level := min(int(item.Level), MaxListLevel)
+		count := counters[item.ID]
+		if count == nil {
+			count = new([MaxListLevel + 1]uint32)
+			counters[item.ID] = count
+		}
+		for l := level + 1; l <= MaxListLevel; l++ {
+			count[l] = 0
+		}
+		switch {
+		case item.Start != 0:
+			count[level] = item.Start
+		case count[level] == 0:
+			count[level] = 1
+		default:
+			count[level]++
+		}
+		numbers[i] = count[level]
+	}
+	return numbers
+}
+
+func validateList(item ListItem) error {
+	if item.ID == 0 {
+		if item != (ListItem{}) {
+			return errors.New("list properties set outside a list")
+		}
+		return nil
+	}
+	if item.Level > MaxListLevel {
+		return fmt.Errorf("list level %d deeper than %d", item.Level, MaxListLevel)
+	}
+	if item.Style > ListUpperRoman {
+		return fmt.Errorf("unknown list style %d", item.Style)
+	}
+	return nil
+}
+
+// alphaNumber counts a, b, ..., z, aa, ab, ...
+func alphaNumber(n uint32) string {
+	var out []byte
+	for n > 0 {
+		n--
+		out = append([]byte{byte('a' + n%26)}, out...)
+		n /= 26
+	}
+	return string(out)
+}
+
+func romanNumber(n uint32) string {
+	if n == 0 || n >= 4000 {
+		return strconv.FormatUint(uint64(n), 10)
+	}
+	values := []uint32{1000, 900, 500, 400, 100, 90, 50, 40, 10, 9, 5, 4, 1}
+	symbols := []string{"M", "CM", "D", "CD", "C", "XC", "L", "XL", "X", "IX", "V", "IV", "I"}
+	var sb strings.Builder
+	for i, v := range values {
+		for n >= v {
+			sb.WriteString(symbols[i])
+			n -= v
+		}
+	}
+	return sb.String()
+}
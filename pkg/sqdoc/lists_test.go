@@ -0,0 +1,68 @@
+package sqdoc
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestListMarkersNumberNestedLists(t *testing.T) {
+	doc := NewDocument("", "")
+	items := []ListItem{
+		{ID: 1, Style: ListDecimal},
+		{ID: 1, Level: 1, Style: ListLowerAlpha},
+		{ID: 1, Level: 1, Style: ListLowerAlpha},
+		{ID: 1, Style: ListDecimal},
+		{},
+		{ID: 1, Level: 1, Style: ListLowerRoman},
+		{ID: 2, Style: ListUpperRoman, Start: 4},
+		{ID: 2, Level: 2},
+		{ID: 1, Style: ListDecimal, Start: 10},
+		{ID: 1, Style: ListDecimal},
+	}
+	for i, item := range items {
+		doc.Blocks = append(doc.Blocks, Block{ID: uint64(i + 1), Kind: BlockKindText, Text: &TextBlock{List: item}})
+	}
+	want := []string{"1.", "a.", "b.", "2.", "", "i.", "IV.", "▪", "10.", "11."}
+	if got := doc.ListMarkers(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("markers = %q, want %q", got, want)
+	}
+}
+
+func TestListNumberFormats(t *testing.T) {
+	cases := map[string]string{
+		ListLowerAlpha.Marker(0, 28):   "ab.",
+		ListUpperRoman.Marker(0, 1994): "MCMXCIV.",
+		ListBullet.Marker(4, 1):        "◦",
+	}
+	for got, want := range cases {
+		if got != want {
+			t.Fatalf("marker = %q, want %q", got, want)
+		}
+	}
+}
+
+func TestListItemsRoundTrip(t *testing.T) {
+	doc := NewDocument("", "")
+	item := ListItem{ID: 3, Level: 2, Style: ListUpperAlpha, Start: 5}
+	doc.Blocks = []Block{
+		{ID: 1, Kind: BlockKindText, Text: &TextBlock{UTF8: []byte("a"), List: item}},
+		{ID: 2, Kind: BlockKindText, Text: &TextBlock{UTF8: []byte("b")}},
+	}
+	blob, err := Encode(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := Decode(blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Blocks[0].Text.List != item || loaded.Blocks[1].Text.List != (ListItem{}) {
+		t.Fatalf("lists = %+v / %+v", loaded.Blocks[0].Text.List, loaded.Blocks[1].Text.List)
+	}
+
+	doc.Blocks[0].Text.List.Level = MaxListLevel + 1
+	doc.Blocks[1].Text.List.Level = 1
+	if errs := ValidateAll(doc); len(errs) != 2 {
+		t.Fatalf("got %d problems: %v", len(errs), errs)
+	}
+}
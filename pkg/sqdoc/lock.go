@@ -0,0 +1,252 @@
+package sqdoc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// LockStaleAge is how old a lock from another host may get before it is
+// treated as abandoned. Holders refresh their lock well inside this window.
+const LockStaleAge = time.Hour
+
+var ErrLocked = errors.New("sqdoc: document is locked")
+
+type LockInfo struct {
+	User    string    `json:"user"`
+	Host    string    `json:"host"`
+	PID     int       `json:"pid"`
+	Created time.Time `json:"created"`
+}
+
+type LockedError struct {
+	Path string
+	Info LockInfo
+}
+
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("sqdoc: %s is locked by %s", filepath.Base(e.Path), e.Info.Owner())
+}
+
+func (e *LockedError) Is(target error) bool {
+	return target == ErrLocked
+}
+
+type Lock struct {
+	docPath  string
+	path     string
+	info     LockInfo
+	released bool
+}
+
+// heldLocks counts the open handles on each lock file this process holds,
+// so a document open in two tabs keeps its lock until both let go.
+var (
+	heldMu    sync.Mutex
+	heldLocks = map[string]int{}
+)
+
+func heldKey(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return filepath.Clean(path)
+}
+
+func LockPath(docPath string) string {
+	dir, name := filepath.Split(filepath.Clean(docPath))
+	return filepath.Join(dir, ".~lock."+name+"#")
+}
+
+func (i LockInfo) Owner() string {
+	who := i.User
+	if who == "" {
+		who = "unknown user"
+	}
+	if i.Host != "" {
+		who += "@" + i.Host
+	}
+	return fmt.Sprintf("%s (pid %d, since %s)", who, i.PID, i.Created.Local().Format("2006-01-02 15:04"))
+}
+
+// Stale reports whether the lock holder is gone: a lock from this host whose
+// process has exited, or any lock that has not been refreshed within
+// LockStaleAge. PIDs get reused, so age applies on this host too.
+func (i LockInfo) Stale(now time.Time) bool {
+	if host, _ := os.Hostname(); host != "" && host == i.Host && !processAlive(i.PID) {
+		return true
+	}
+	return now.Sub(i.Created) > LockStaleAge
+}
+
+func (i LockInfo) ownedByUs() bool {
+	host, _ := os.Hostname()
+	return i.Host == host && i.PID == os.Getpid()
+}
+
+func ReadLock(docPath string) (LockInfo, error) {
+	raw, err := os.ReadFile(LockPath(docPath))
+	if err != nil {
+		return LockInfo{}, err
+	}
+	var info LockInfo
+	if err := json.Unmarshal(raw, &info); err != nil {
+		return LockInfo{}, fmt.Errorf("sqdoc: malformed lock file: %w", err)
+	}
+	return info, nil
+}
+
+// AcquireLock creates the owner lock next to docPath. Stale locks are
+// replaced; a live lock held by someone else yields a *LockedError. If this
+// process already holds the lock, the new handle shares it and the file is
+// only removed once every handle is released.
+func AcquireLock(docPath string) (*Lock, error) {
+	l := &Lock{docPath: docPath, path: LockPath(docPath), info: currentLockInfo()}
+	heldMu.Lock()
+	defer heldMu.Unlock()
+	key := heldKey(l.path)
+	if heldLocks[key] > 0 {
+		if err := l.checkHeld(); err == nil {
+			heldLocks[key]++
+			return l, nil
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		// Our lock file vanished underneath us; recreate it below.
+	}
+	for attempt := 0; attempt < 2; attempt++ {
+		err := l.write(os.O_WRONLY | os.O_CREATE | os.O_EXCL)
+		if err == nil {
+			heldLocks[key]++
+			return l, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, err
+		}
+		info, readErr := ReadLock(docPath)
+		if readErr == nil && !info.ownedByUs() && !info.Stale(time.Now()) {
+			return nil, &LockedError{Path: docPath, Info: info}
+		}
+		if err := os.Remove(l.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+	return nil, &LockedError{Path: docPath}
+}
+
+// ForceLock takes the lock regardless of any current holder.
+func ForceLock(docPath string) (*Lock, error) {
+	l := &Lock{docPath: docPath, path: LockPath(docPath), info: currentLockInfo()}
+	heldMu.Lock()
+	defer heldMu.Unlock()
+	if err := l.write(os.O_WRONLY | os.O_CREATE | os.O_TRUNC); err != nil {
+		return nil, err
+	}
+	heldLocks[heldKey(l.path)]++
+	return l, nil
+}
+
+func (l *Lock) Info() LockInfo {
+	return l.info
+}
+
+// Refresh rewrites the timestamp so other hosts keep seeing the lock as live.
+// It fails with a *LockedError if someone else has taken the lock over.
+func (l *Lock) Refresh() error {
+	if l == nil {
+		return nil
+	}
+	if err := l.checkHeld(); err != nil {
+		return err
+	}
+	l.info.Created = time.Now().UTC()
+	return l.write(os.O_WRONLY | os.O_CREATE | os.O_TRUNC)
+}
+
+// Release drops this handle. The lock file is removed once the last handle
+// in this process is released, and only if it still belongs to us.
+func (l *Lock) Release() error {
+	if l == nil {
+		return nil
+	}
+	heldMu.Lock()
+	defer heldMu.Unlock()
+	if l.released {
+		return nil
+	}
+	l.released = true
+	key := heldKey(l.path)
+	if heldLocks[key] > 1 {
+		heldLocks[key]--
+		return nil
+	}
+	delete(heldLocks, key)
+	if err := l.checkHeld(); err != nil {
+		if errors.Is(err, os.ErrNotExist) || errors.Is(err, ErrLocked) {
+			return nil
+		}
+		return err
+	}
+	if err := os.Remove(l.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (l *Lock) checkHeld() error {
+	raw, err := os.ReadFile(l.path)
+	if err != nil {
+		return err
+	}
+	var info LockInfo
+	if err := json.Unmarshal(raw, &info); err != nil {
+		return fmt.Errorf("sqdoc: malformed lock file: %w", err)
+	}
+	if info.Host != l.info.Host || info.PID != l.info.PID || info.User != l.info.User {
+		return &LockedError{Path: l.docPath, Info: info}
+	}
+	return nil
+}
+
+func (l *Lock) write(flag int) error {
+	raw, err := json.Marshal(l.info)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(l.path, flag, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(raw); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func currentLockInfo() LockInfo {
+	info := LockInfo{PID: os.Getpid(), Created: time.Now().UTC(), User: UserName()}
+	info.Host, _ = os.Hostname()
+	return info
+}
+
+// UserName is the login name of the user running the program, as lock
+// files and review comments record it.
+func UserName() string {
+	name := ""
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+	if name == "" {
+		name = os.Getenv("USER")
+	}
+	if name == "" {
+		name = os.Getenv("USERNAME")
+	}
+	return name
+}
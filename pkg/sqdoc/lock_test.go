@@ -0,0 +1,133 @@
+package sqdoc
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeTestLock(t *testing.T, docPath string, info LockInfo) {
+	t.Helper()
+	raw, err := json.Marshal(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(LockPath(docPath), raw, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAcquireLockCreatesAndReleases(t *testing.T) {
+	docPath := filepath.Join(t.TempDir(), "shared.sqdoc")
+	lock, err := AcquireLock(docPath)
+	if err != nil {
+		t.Fatalf("acquire failed: %v", err)
+	}
+	info, err := ReadLock(docPath)
+	if err != nil {
+		t.Fatalf("read lock failed: %v", err)
+	}
+	if info.PID != os.Getpid() {
+		t.Fatalf("lock pid = %d, want %d", info.PID, os.Getpid())
+	}
+	if err := lock.Release(); err != nil {
+		t.Fatalf("release failed: %v", err)
+	}
+	if _, err := os.Stat(LockPath(docPath)); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected lock file to be removed, stat err = %v", err)
+	}
+}
+
+func TestAcquireLockRejectsLiveForeignLock(t *testing.T) {
+	docPath := filepath.Join(t.TempDir(), "shared.sqdoc")
+	writeTestLock(t, docPath, LockInfo{User: "sam", Host: "elsewhere.invalid", PID: 4242, Created: time.Now().UTC()})
+
+	_, err := AcquireLock(docPath)
+	if !errors.Is(err, ErrLocked) {
+		t.Fatalf("expected ErrLocked, got %v", err)
+	}
+	var locked *LockedError
+	if !errors.As(err, &locked) || locked.Info.User != "sam" {
+		t.Fatalf("expected lock owner details, got %#v", err)
+	}
+
+	lock, err := ForceLock(docPath)
+	if err != nil {
+		t.Fatalf("force lock failed: %v", err)
+	}
+	if info, _ := ReadLock(docPath); info.PID != os.Getpid() {
+		t.Fatalf("force lock did not take ownership: %#v", info)
+	}
+	_ = lock.Release()
+}
+
+func TestAcquireLockReplacesStaleLock(t *testing.T) {
+	docPath := filepath.Join(t.TempDir(), "shared.sqdoc")
+	writeTestLock(t, docPath, LockInfo{User: "sam", Host: "elsewhere.invalid", PID: 4242, Created: time.Now().Add(-2 * LockStaleAge)})
+
+	lock, err := AcquireLock(docPath)
+	if err != nil {
+		t.Fatalf("expected stale lock to be replaced, got %v", err)
+	}
+	_ = lock.Release()
+}
+
+func TestReleaseLeavesTakenOverLock(t *testing.T) {
+	docPath := filepath.Join(t.TempDir(), "shared.sqdoc")
+	lock, err := AcquireLock(docPath)
+	if err != nil {
+		t.Fatalf("acquire failed: %v", err)
+	}
+	writeTestLock(t, docPath, LockInfo{User: "sam", Host: "elsewhere.invalid", PID: 4242, Created: time.Now().UTC()})
+	if err := lock.Release(); err != nil {
+		t.Fatalf("release failed: %v", err)
+	}
+	if _, err := os.Stat(LockPath(docPath)); err != nil {
+		t.Fatalf("foreign lock should survive release: %v", err)
+	}
+}
+
+func TestAcquireLockSharedWithinProcess(t *testing.T) {
+	docPath := filepath.Join(t.TempDir(), "shared.sqdoc")
+	first, err := AcquireLock(docPath)
+	if err != nil {
+		t.Fatalf("first acquire failed: %v", err)
+	}
+	second, err := AcquireLock(docPath)
+	if err != nil {
+		t.Fatalf("second acquire failed: %v", err)
+	}
+	if err := first.Release(); err != nil {
+		t.Fatalf("first release failed: %v", err)
+	}
+	if err := first.Release(); err != nil {
+		t.Fatalf("repeated release failed: %v", err)
+	}
+	if _, err := os.Stat(LockPath(docPath)); err != nil {
+		t.Fatalf("lock should survive while the second handle is open: %v", err)
+	}
+	if err := second.Refresh(); err != nil {
+		t.Fatalf("refresh failed: %v", err)
+	}
+	if err := second.Release(); err != nil {
+		t.Fatalf("second release failed: %v", err)
+	}
+	if _, err := os.Stat(LockPath(docPath)); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected lock file to be removed, stat err = %v", err)
+	}
+}
+
+func TestStaleAppliesAgeOnThisHost(t *testing.T) {
+	host, _ := os.Hostname()
+	info := LockInfo{Host: host, PID: os.Getpid(), Created: time.Now().Add(-2 * LockStaleAge)}
+	if !info.Stale(time.Now()) {
+		t.Fatal("an unrefreshed lock from this host should be stale")
+	}
+	info.Created = time.Now()
+	if info.Stale(time.Now()) {
+		t.Fatal("a fresh lock from a live process should not be stale")
+	}
+}
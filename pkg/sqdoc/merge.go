@@ -0,0 +1,295 @@
+package sqdoc
+
+import (
+	"bytes"
+	"slices"
+	"sort"
+)
+
+type MergeConflict struct {
+	BlockID  uint64
+	RemoteID uint64
+	Reason   string
+}
+
+// MergeDocuments performs a three-way, block-level merge keyed by block ID.
+// Blocks edited on only one side take that side's version. When both sides
+// edited the same block the local copy wins and the remote copy is kept
+// directly after it under a fresh ID, so no text is lost.
+func MergeDocuments(base, local, remote *Document) (*Document, []MergeConflict) {
+	if local == nil {
+		return CloneDocument(remote), nil
+	}
+	if remote == nil {
+		return CloneDocument(local), nil
+	}
+	if base == nil {
+		base = &Document{}
+	}
+
+	baseByID := indexBlocks(base.Blocks)
+	localByID := indexBlocks(local.Blocks)
+	remoteByID := indexBlocks(remote.Blocks)
+
+	out := &Document{Metadata: remote.Metadata}
+	if metadataChanged(base.Metadata, local.Metadata) {
+		out.Metadata = local.Metadata
+	}
+	if remote.Metadata.ModifiedUnix > out.Metadata.ModifiedUnix {
+		out.Metadata.ModifiedUnix = remote.Metadata.ModifiedUnix
+	}
+	out.Styles = mergeStyleSheets(base.Styles, local.Styles, remote.Styles)
+
+	order := mergeBlockOrder(local.Blocks, remote.Blocks)
+	nextID := maxBlockID(base.Blocks, local.Blocks, remote.Blocks) + 1
+	// Comment threads share the block IDs.
+	for _, d := range []*Document{base, local, remote} {
+		for _, t := range d.Comments {
+			nextID = max(nextID, t.ID+1)
+		}
+	}
+	var conflicts []MergeConflict
+
+	for _, id := range order {
+		b, hasBase := baseByID[id]
+		l, hasLocal := localByID[id]
+		r, hasRemote := remoteByID[id]
+
+		switch {
+		case hasLocal && hasRemote:
+			switch {
+			case blocksEqual(l, r):
+				out.Blocks = append(out.Blocks, cloneBlock(l))
+			case hasBase && blocksEqual(l, b):
+				out.Blocks = append(out.Blocks, cloneBlock(r))
+			case hasBase && blocksEqual(r, b):
+				out.Blocks = append(out.Blocks, cloneBlock(l))
+			default:
+				out.Blocks = append(out.Blocks, cloneBlock(l))
+				dup := cloneBlock(r)
+				dup.ID = nextID
+				nextID++
+				out.Blocks = append(out.Blocks, dup)
+				reason := "edited on both sides"
+				if !hasBase {
+					reason = "added on both sides"
+				}
+				conflicts = append(conflicts, MergeConflict{BlockID: id, RemoteID: dup.ID, Reason: reason})
+			}
+		case hasLocal:
+			if !hasBase {
+				out.Blocks = append(out.Blocks, cloneBlock(l))
+			} else if !blocksEqual(l, b) {
+				out.Blocks = append(out.Blocks, cloneBlock(l))
+				conflicts = append(conflicts, MergeConflict{BlockID: id, Reason: "edited locally, deleted remotely"})
+			}
+		case hasRemote:
+			if !hasBase {
+				out.Blocks = append(out.Blocks, cloneBlock(r))
+			} else if !blocksEqual(r, b) {
+				out.Blocks = append(out.Blocks, cloneBlock(r))
+				conflicts = append(conflicts, MergeConflict{BlockID: id, RemoteID: id, Reason: "deleted locally, edited remotely"})
+			}
+		}
+	}
+	out.Bookmarks = mergeBookmarks(base, local, remote, out.Blocks)
+	out.Comments = mergeComments(base.Comments, local.Comments, remote.Comments, out.Blocks)
+	out.Revisions = mergeRevisions(local.Revisions, remote.Revisions, out.Blocks)
+	return out, conflicts
+}
+
+// mergeBookmarks takes the bookmarks as mergeStyleSheets takes styles, then
+// clamps them to the text of the merged blocks. A bookmark whose block did
+// not survive goes to where that block was, as the editor moves bookmarks
+// out of deleted text: the end of the nearest text block before it, or the
+// start of the one after. One with nowhere to go is dropped.
+func mergeBookmarks(base, local, remote *Document, blocks []Block) []Bookmark {
+	out, other := remote.Bookmarks, local.Bookmarks
+	if !slices.Equal(base.Bookmarks, local.Bookmarks) {
+		out, other = local.Bookmarks, remote.Bookmarks
+	}
+	out = slices.Clone(out)
+	for _, bm := range other {
+		if !slices.ContainsFunc(out, func(m Bookmark) bool { return m.Name == bm.Name }) {
+			out = append(out, bm)
+		}
+	}
+	lengths := map[uint64]int{}
+	for _, b := range blocks {
+		if b.Kind == BlockKindText && b.Text != nil {
+			lengths[b.ID] = len(b.Text.UTF8)
+		}
+	}
+	kept := out[:0]
+	for _, bm := range out {
+		if n, ok := lengths[bm.BlockID]; ok {
+			bm.Offset = min(bm.Offset, uint32(n))
+			kept = append(kept, bm)
+		} else if id, offset, ok := survivingNeighbour(bm.BlockID, lengths, local, remote, base); ok {
+			bm.BlockID, bm.Offset = id, uint32(offset)
+			kept = append(kept, bm)
+		}
+	}
+	return kept
+}
+
+// survivingNeighbour finds the block with the given ID in the first of docs
+// that has it and returns the nearest text block around it that lengths
+// holds, with the offset of its end if it comes before or its start if
+// after.
+func survivingNeighbour(id uint64, lengths map[uint64]int, docs ...*Document) (uint64, int, bool) {
+	for _, d := range docs {
+		at := slices.IndexFunc(d.Blocks, func(b Block) bool { return b.ID == id })
+		if at < 0 {
+			continue
+		}
+		for i := at - 1; i >= 0; i-- {
+			if n, ok := lengths[d.Blocks[i].ID]; ok {
+				return d.Blocks[i].ID, n, true
+			}
+		}
+		for i := at + 1; i < len(d.Blocks); i++ {
+			if _, ok := lengths[d.Blocks[i].ID]; ok {
+				return d.Blocks[i].ID, 0, true
+			}
+		}
+		return 0, 0, false
+	}
+	return 0, 0, false
+}
+
+// mergeBlockOrder follows the remote ordering and slots local-only blocks in
+// after the closest preceding local block that is already placed.
+func mergeBlockOrder(local, remote []Block) []uint64 {
+	order := make([]uint64, 0, len(local)+len(remote))
+	placed := map[uint64]int{}
+	for _, b := range remote {
+		if _, ok := placed[b.ID]; ok {
+			continue
+		}
+		placed[b.ID] = len(order)
+		order = append(order, b.ID)
+	}
+	var prev uint64
+	hasPrev := false
+	for _, b := range local {
+		if _, ok := placed[b.ID]; ok {
+			prev = b.ID
+			hasPrev = true
+			continue
+		}
+		at := 0
+		if hasPrev {
+			at = placed[prev] + 1
+		}
+		order = append(order, 0)
+		copy(order[at+1:], order[at:])
+		order[at] = b.ID
+		for i := at; i < len(order); i++ {
+			placed[order[i]] = i
+		}
+		prev = b.ID
+		hasPrev = true
+	}
+	return order
+}
+
+// mergeStyleSheets takes the local sheet if it changed and the remote one
+// otherwise, then adds styles only the other side defines so blocks taken
+// from either side keep resolving.
+func mergeStyleSheets(base, local, remote StyleSheet) StyleSheet {
+	out, other := remote, local
+	if !styleSheetsEqual(base, local) {
+		out, other = local, remote
+	}
+	out = cloneStyleSheet(out)
+	if len(out.Styles) == 0 && len(other.Styles) > 0 {
+		out = DefaultStyleSheet()
+	}
+	for _, st := range other.Styles {
+		if _, ok := out.Lookup(st.ID); !ok {
+			out.Styles = append(out.Styles, st)
+		}
+	}
+	return out
+}
+
+func styleSheetsEqual(a, b StyleSheet) bool {
+	if a.Default != b.Default || len(a.Styles) != len(b.Styles) {
+		return false
+	}
+	for i := range a.Styles {
+		if a.Styles[i] != b.Styles[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func metadataChanged(base, m Metadata) bool {
+	base.ModifiedUnix = 0
+	m.ModifiedUnix = 0
+	return base != m
+}
+
+func indexBlocks(blocks []Block) map[uint64]Block {
+	out := make(map[uint64]Block, len(blocks))
+	for _, b := range blocks {
+		out[b.ID] = b
+	}
+	return out
+}
+
+func maxBlockID(sets ...[]Block) uint64 {
+	var maxID uint64
+	for _, blocks := range sets {
+		for _, b := range blocks {
+			if b.ID > maxID && b.ID != fmtBlockID {
+				maxID = b.ID
+			}
+		}
+	}
+	return maxID
+}
+
+func cloneBlock(b Block) Block {
+	doc := CloneDocument(&Document{Blocks: []Block{b}})
+	return doc.Blocks[0]
+}
+
+func blocksEqual(a, b Block) bool {
+	if a.Kind != b.Kind || a.Note != b.Note || !tablesEqual(a.Table, b.Table) {
+		return false
+	}
+	if (a.Text == nil) != (b.Text == nil) {
+		return false
+	}
+	if a.Text == nil {
+		return true
+	}
+	if !bytes.Equal(a.Text.UTF8, b.Text.UTF8) || a.Text.Style != b.Text.Style || a.Text.Para != b.Text.Para || a.Text.List != b.Text.List || !codeEqual(a.Text.Code, b.Text.Code) {
+		return false
+	}
+	ra := sortedRuns(a.Text.Runs)
+	rb := sortedRuns(b.Text.Runs)
+	if len(ra) != len(rb) {
+		return false
+	}
+	for i := range ra {
+		if ra[i] != rb[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func sortedRuns(runs []StyleRun) []StyleRun {
+	out := append([]StyleRun(nil), runs...)
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Start == out[j].Start {
+			return out[i].End < out[j].End
+		}
+		return out[i].Start < out[j].Start
+	})
+	return out
+}
@@ -0,0 +1,150 @@
+package sqdoc
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func mergeTestDoc(texts map[uint64]string, order ...uint64) *Document {
+	doc := NewDocument("Alex", "Draft")
+	for _, id := range order {
+		doc.Blocks = append(doc.Blocks, Block{
+			ID:   id,
+			Kind: BlockKindText,
+			Text: &TextBlock{UTF8: []byte(texts[id])},
+		})
+	}
+	return doc
+}
+
+func blockTexts(doc *Document) []string {
+	out := make([]string, 0, len(doc.Blocks))
+	for _, b := range doc.Blocks {
+		out = append(out, string(b.Text.UTF8))
+	}
+	return out
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestMergeTakesNonConflictingEditsFromBothSides(t *testing.T) {
+	base := mergeTestDoc(map[uint64]string{1: "one", 2: "two", 3: "three"}, 1, 2, 3)
+	local := mergeTestDoc(map[uint64]string{1: "one local", 2: "two", 3: "three", 4: "four"}, 1, 2, 4, 3)
+	remote := mergeTestDoc(map[uint64]string{1: "one", 2: "two remote"}, 1, 2)
+
+	merged, conflicts := MergeDocuments(base, local, remote)
+	if len(conflicts) != 0 {
+		t.Fatalf("expected no conflicts, got %#v", conflicts)
+	}
+	want := []string{"one local", "two remote", "four"}
+	if got := blockTexts(merged); !equalStrings(got, want) {
+		t.Fatalf("merged blocks = %q, want %q", got, want)
+	}
+}
+
+func TestMergeKeepsBothCopiesOnConflict(t *testing.T) {
+	base := mergeTestDoc(map[uint64]string{1: "same", 2: "base"}, 1, 2)
+	local := mergeTestDoc(map[uint64]string{1: "same", 2: "mine"}, 1, 2)
+	remote := mergeTestDoc(map[uint64]string{1: "same", 2: "theirs"}, 1, 2)
+
+	merged, conflicts := MergeDocuments(base, local, remote)
+	if len(conflicts) != 1 || conflicts[0].BlockID != 2 {
+		t.Fatalf("expected one conflict on block 2, got %#v", conflicts)
+	}
+	want := []string{"same", "mine", "theirs"}
+	if got := blockTexts(merged); !equalStrings(got, want) {
+		t.Fatalf("merged blocks = %q, want %q", got, want)
+	}
+	if merged.Blocks[2].ID != conflicts[0].RemoteID || merged.Blocks[2].ID <= 2 {
+		t.Fatalf("remote copy should get a fresh id, got %d", merged.Blocks[2].ID)
+	}
+	if err := Validate(merged); err != nil {
+		t.Fatalf("merged document invalid: %v", err)
+	}
+}
+
+func TestMergeEditWinsOverDelete(t *testing.T) {
+	base := mergeTestDoc(map[uint64]string{1: "keep", 2: "base"}, 1, 2)
+	local := mergeTestDoc(map[uint64]string{1: "keep", 2: "edited"}, 1, 2)
+	remote := mergeTestDoc(map[uint64]string{1: "keep"}, 1)
+
+	merged, conflicts := MergeDocuments(base, local, remote)
+	if len(conflicts) != 1 {
+		t.Fatalf("expected one conflict, got %#v", conflicts)
+	}
+	want := []string{"keep", "edited"}
+	if got := blockTexts(merged); !equalStrings(got, want) {
+		t.Fatalf("merged blocks = %q, want %q", got, want)
+	}
+}
+
+func TestMergeMovesBookmarksOffDeletedBlocks(t *testing.T) {
+	base := mergeTestDoc(map[uint64]string{1: "one", 2: "two", 3: "three"}, 1, 2, 3)
+	base.Bookmarks = []Bookmark{{Name: "two", BlockID: 2, Offset: 1}}
+	local := CloneDocument(base)
+	local.Bookmarks = append(local.Bookmarks, Bookmark{Name: "start", BlockID: 1})
+	remote := mergeTestDoc(map[uint64]string{1: "one", 3: "three"}, 1, 3)
+	remote.Bookmarks = base.Bookmarks
+
+	merged, _ := MergeDocuments(base, local, remote)
+	if err := Validate(merged); err != nil {
+		t.Fatalf("merged document invalid: %v", err)
+	}
+	want := []Bookmark{{Name: "two", BlockID: 1, Offset: 3}, {Name: "start", BlockID: 1}}
+	if len(merged.Bookmarks) != len(want) || merged.Bookmarks[0] != want[0] || merged.Bookmarks[1] != want[1] {
+		t.Fatalf("bookmarks = %+v, want %+v", merged.Bookmarks, want)
+	}
+
+	// A bookmark with no text block left to go to is dropped.
+	local = mergeTestDoc(map[uint64]string{1: "one"}, 1)
+	local.Bookmarks = []Bookmark{{Name: "one", BlockID: 1}}
+	remote = &Document{Blocks: []Block{{ID: 5, Kind: BlockKindTable, Table: NewTable(1, 1)}}}
+	merged, _ = MergeDocuments(&Document{Bookmarks: local.Bookmarks, Blocks: local.Blocks}, local, remote)
+	if len(merged.Bookmarks) != 0 {
+		t.Fatalf("bookmarks = %+v, want none", merged.Bookmarks)
+	}
+}
+
+func TestStampFileDetectsContentChange(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stamp.sqdoc")
+	if err := os.WriteFile(path, []byte("first"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	before, err := StampFile(path)
+	if err != nil {
+		t.Fatalf("stamp failed: %v", err)
+	}
+	if err := os.WriteFile(path, []byte("other"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	later := before.ModTime.Add(2 * time.Second)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !before.StatChanged(info) {
+		t.Fatalf("expected stat change to be detected")
+	}
+	after, err := StampFile(path)
+	if err != nil {
+		t.Fatalf("stamp failed: %v", err)
+	}
+	if before.SameContent(after) {
+		t.Fatalf("expected content hash to differ")
+	}
+}
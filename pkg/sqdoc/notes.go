@@ -0,0 +1,156 @@
+package sqdoc
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// BlockKindNote blocks hold the body of a footnote or endnote: one
+// paragraph of rich text that may contain line breaks, like a table cell.
+// They follow the body of the document and are not part of its flow;
+// readers that predate notes skip them.
+const BlockKindNote BlockKind = 6
+
+// NoteKind tells footnotes from endnotes.
+type NoteKind uint8
+
+const (
+	NoteFootnote NoteKind = iota
+	NoteEndnote
+)
+
+// NoteRef is a note that the text refers to, with the number its anchors
+// show.
+type NoteRef struct {
+	ID     uint64
+	Kind   NoteKind
+	Number string
+	// Block is the index of the note's block and Anchor the place of the
+	// first run that refers to it.
+	Block  int
+	Anchor Anchor
+}
+
+// Notes lists the notes of d that some anchor refers to, footnotes before
+// endnotes and each in the order of their first anchors. Footnotes are
+// numbered 1, 2, 3 and endnotes i, ii, iii. Anchors in table cells count
+// from the start of the table.
+func (d *Document) Notes() []NoteRef {
+	index := map[uint64]int{}
+	for i, b := range d.Blocks {
+		if b.Kind == BlockKindNote && b.Text != nil {
+			index[b.ID] = i
+		}
+	}
+	if len(index) == 0 {
+		return nil
+	}
+	var out []NoteRef
+	seen := map[uint64]bool{}
+	visit := func(block int, tb *TextBlock, inTable bool) {
+		for _, r := range sortedRuns(tb.Runs) {
+			id := r.Attr.Note
+			if id == 0 || seen[id] {
+				continue
+			}
+			n, ok := index[id]
+			if !ok {
+				continue
+			}
+			seen[id] = true
+			at := Anchor{Block: block, Offset: int(r.Start)}
+			if inTable {
+				at.Offset = 0
+			}
+			out = append(out, NoteRef{ID: id, Kind: d.Blocks[n].Note, Block: n, Anchor: at})
+		}
+	}
+	for i, b := range d.Blocks {
+		switch {
+		case b.Kind == BlockKindNote:
+		case b.Text != nil:
+			visit(i, b.Text, false)
+		case b.Table != nil:
+			for _, row := range b.Table.Rows {
+				for _, cell := range row {
+					if cell.Text != nil {
+						visit(i, cell.Text, true)
+					}
+				}
+			}
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Kind < out[j].Kind })
+	var counts [NoteEndnote + 1]uint32
+	for i := range out {
+		counts[out[i].Kind]++
+		if out[i].Kind == NoteEndnote {
+			out[i].Number = strings.ToLower(romanNumber(counts[NoteEndnote]))
+		} else {
+			out[i].Number = strconv.Itoa(int(counts[NoteFootnote]))
+		}
+	}
+	return out
+}
+
+// NoteNumbers maps the IDs of the notes that anchors refer to to their
+// numbers.
+func (d *Document) NoteNumbers() map[uint64]string {
+	out := map[uint64]string{}
+	for _, n := range d.Notes() {
+		out[n.ID] = n.Number
+	}
+	return out
+}
+
+func validateNote(b *Block, sheet StyleSheet) error {
+	if b.Note > NoteEndnote {
+		return fmt.Errorf("unknown note kind %d", b.Note)
+	}
+	if err := validateCellText(b.Text, sheet); err != nil {
+		return err
+	}
+	for _, r := range b.Text.Runs {
+		if r.Attr.Note != 0 {
+			return errors.New("notes cannot hold note anchors")
+		}
+	}
+	return nil
+}
+
+// validateAnchors checks that every note anchor refers to a note block.
+func validateAnchors(doc *Document) []error {
+	notes := map[uint64]bool{}
+	for _, b := range doc.Blocks {
+		if b.Kind == BlockKindNote {
+			notes[b.ID] = true
+		}
+	}
+	var problems []error
+	for _, tb := range doc.TextBlocks() {
+		for _, r := range tb.Runs {
+			if r.Attr.Note != 0 && !notes[r.Attr.Note] {
+				problems = append(problems, fmt.Errorf("sqdoc: anchor refers to missing note %d", r.Attr.Note))
+				break
+			}
+		}
+	}
+	return problems
+}
+
+// encodeNote writes the note kind before the text; runs and paragraph
+// formatting go in the formatting directive as for text blocks.
+func encodeNote(b Block) []byte {
+	return append([]byte{byte(b.Note)}, encodeTextBlock(b.Text)...)
+}
+
+func decodeNote(b []byte) (NoteKind, *TextBlock, error) {
+	if len(b) < 1 {
+		return 0, nil, errors.New("sqdoc: malformed note block")
+	}
+	tb, err := decodeTextBlock(b[1:])
+	return NoteKind(b[0]), tb, err
+}
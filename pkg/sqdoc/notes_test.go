@@ -0,0 +1,73 @@
+package sqdoc
+
+import (
+	"fmt"
+	"testing"
+)
+
+func notesDoc() *Document {
+	anchor := func(note uint64) StyleAttr { return StyleAttr{FontSizePt: 14, Note: note} }
+	plain := StyleAttr{FontSizePt: 14}
+	doc := NewDocument("", "")
+	table := NewTable(1, 1)
+	table.Rows[0][0].Text.UTF8 = []byte("cell*")
+	table.Rows[0][0].Text.Runs = []StyleRun{{Start: 0, End: 4, Attr: plain}, {Start: 4, End: 5, Attr: anchor(13)}}
+	doc.Blocks = []Block{
+		{ID: 1, Kind: BlockKindText, Text: &TextBlock{UTF8: []byte("One*Two*"), Runs: []StyleRun{
+			{Start: 0, End: 3, Attr: plain}, {Start: 3, End: 4, Attr: anchor(11)}, {Start: 4, End: 7, Attr: plain}, {Start: 7, End: 8, Attr: anchor(12)},
+		}}},
+		{ID: 2, Kind: BlockKindTable, Table: table},
+		{ID: 3, Kind: BlockKindText, Text: &TextBlock{UTF8: []byte("Again*"), Runs: []StyleRun{{Start: 0, End: 5, Attr: plain}, {Start: 5, End: 6, Attr: anchor(11)}}}},
+		{ID: 11, Kind: BlockKindNote, Text: &TextBlock{UTF8: []byte("First note")}},
+		{ID: 12, Kind: BlockKindNote, Note: NoteEndnote, Text: &TextBlock{UTF8: []byte("An endnote")}},
+		{ID: 13, Kind: BlockKindNote, Text: &TextBlock{UTF8: []byte("From a cell")}},
+		{ID: 14, Kind: BlockKindNote, Text: &TextBlock{UTF8: []byte("Orphan")}},
+	}
+	return doc
+}
+
+func TestNoteNumbers(t *testing.T) {
+	doc := notesDoc()
+	var got []string
+	for _, n := range doc.Notes() {
+		got = append(got, fmt.Sprintf("%d=%s@%d:%d", n.ID, n.Number, n.Anchor.Block, n.Anchor.Offset))
+	}
+	if fmt.Sprint(got) != "[11=1@0:3 13=2@1:0 12=i@0:7]" {
+		t.Fatalf("Notes() = %v", got)
+	}
+	if len(doc.Outline()) != 0 {
+		t.Fatal("notes count as headings")
+	}
+}
+
+func TestNoteRoundTrip(t *testing.T) {
+	doc := notesDoc()
+	doc.Blocks[4].Text.Runs = []StyleRun{{Start: 0, End: 2, Attr: StyleAttr{FontSizePt: 10, Italic: true}}, {Start: 2, End: 10, Attr: StyleAttr{FontSizePt: 10}}}
+	blob, err := Encode(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := Decode(blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded.Blocks) != 6 {
+		t.Fatalf("got %d blocks; the orphan note should be dropped", len(loaded.Blocks))
+	}
+	if b := loaded.Blocks[4]; b.Kind != BlockKindNote || b.Note != NoteEndnote || string(b.Text.UTF8) != "An endnote" || !b.Text.Runs[0].Attr.Italic {
+		t.Fatalf("endnote = %+v", b)
+	}
+	if loaded.Blocks[0].Text.Runs[1].Attr.Note != 11 || loaded.Blocks[1].Table.Rows[0][0].Text.Runs[1].Attr.Note != 13 {
+		t.Fatal("anchors lost their notes")
+	}
+
+	doc.Blocks[0].Text.Runs[1].Attr.Note = 99
+	if err := Validate(doc); err == nil {
+		t.Fatal("Validate accepted an anchor to a missing note")
+	}
+	doc = notesDoc()
+	doc.Blocks[3].Text.Runs = []StyleRun{{Start: 0, End: 5, Attr: StyleAttr{FontSizePt: 14, Note: 12}}}
+	if err := Validate(doc); err == nil {
+		t.Fatal("Validate accepted an anchor inside a note")
+	}
+}
@@ -0,0 +1,111 @@
+package sqdoc
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// PageSetup is the paper and margins of paged mode, in points. The zero
+// value stands for DefaultPageSetup, so documents only store one once it is
+// changed.
+type PageSetup struct {
+	Width, Height            uint16
+	Top, Right, Bottom, Left uint16
+}
+
+// PageSize is a named paper size, portrait way up, in points.
+type PageSize struct {
+	Name          string
+	Width, Height uint16
+}
+
+// PageSizes are the paper size presets.
+var PageSizes = []PageSize{
+	{Name: "A4", Width: 595, Height: 842},
+	{Name: "Letter", Width: 612, Height: 792},
+	{Name: "Legal", Width: 612, Height: 1008},
+}
+
+const (
+	// DefaultMargin is one inch.
+	DefaultMargin = 72
+	// MinPageSide and MaxPageSide bound a page's width and height; the
+	// largest is 200 inches, as in PDF.
+	MinPageSide = 72
+	MaxPageSide = 14400
+	// minPageText is the least width and height margins must leave.
+	minPageText = 36
+)
+
+// DefaultPageSetup is A4 with one-inch margins.
+func DefaultPageSetup() PageSetup {
+	return PageSetup{Width: 595, Height: 842, Top: DefaultMargin, Right: DefaultMargin, Bottom: DefaultMargin, Left: DefaultMargin}
+}
+
+// Resolved returns p, or DefaultPageSetup for the zero value.
+func (p PageSetup) Resolved() PageSetup {
+	if p == (PageSetup{}) {
+		return DefaultPageSetup()
+	}
+	return p
+}
+
+// SizeName returns the name of the preset p's paper is, either way up, or
+// "Custom".
+func (p PageSetup) SizeName() string {
+	p = p.Resolved()
+	for _, s := range PageSizes {
+		if p.Width == s.Width && p.Height == s.Height || p.Width == s.Height && p.Height == s.Width {
+			return s.Name
+		}
+	}
+	return "Custom"
+}
+
+// Landscape reports whether the page is wider than it is tall.
+func (p PageSetup) Landscape() bool {
+	p = p.Resolved()
+	return p.Width > p.Height
+}
+
+// TextWidth and TextHeight are the size of the text area inside the
+// margins.
+func (p PageSetup) TextWidth() int {
+	p = p.Resolved()
+	return int(p.Width) - int(p.Left) - int(p.Right)
+}
+
+func (p PageSetup) TextHeight() int {
+	p = p.Resolved()
+	return int(p.Height) - int(p.Top) - int(p.Bottom)
+}
+
+// Validate reports why p cannot be a document's page setup.
+func (p PageSetup) Validate() error {
+	if p == (PageSetup{}) {
+		return nil
+	}
+	if p.Width < MinPageSide || p.Height < MinPageSide || p.Width > MaxPageSide || p.Height > MaxPageSide {
+		return fmt.Errorf("page sides must be %d to %d points", MinPageSide, MaxPageSide)
+	}
+	if p.TextWidth() < minPageText || p.TextHeight() < minPageText {
+		return fmt.Errorf("margins must leave at least %d points of text each way", minPageText)
+	}
+	return nil
+}
+
+func appendPageSetup(out []byte, p PageSetup) []byte {
+	for _, v := range []uint16{p.Width, p.Height, p.Top, p.Right, p.Bottom, p.Left} {
+		out = appendU16(out, v)
+	}
+	return out
+}
+
+// readPageSetup is the inverse of appendPageSetup; b must hold at least
+// pageSetupSize bytes.
+func readPageSetup(b []byte) PageSetup {
+	v := func(i int) uint16 { return binary.LittleEndian.Uint16(b[2*i:]) }
+	return PageSetup{Width: v(0), Height: v(1), Top: v(2), Right: v(3), Bottom: v(4), Left: v(5)}
+}
+
+const pageSetupSize = 12
@@ -0,0 +1,40 @@
+package sqdoc
+
+import "testing"
+
+func TestPageSetupRoundTrip(t *testing.T) {
+	doc := NewDocument("", "")
+	doc.Metadata.PagedMode = true
+	doc.Metadata.Page = PageSetup{Width: 792, Height: 612, Top: 36, Right: 54, Bottom: 36, Left: 54}
+	doc.Blocks = []Block{
+		{ID: 1, Kind: BlockKindText, Text: &TextBlock{UTF8: []byte("Title page")}},
+		{ID: 2, Kind: BlockKindText, Text: &TextBlock{UTF8: []byte("Chapter one"), Para: ParagraphFormat{PageBreakBefore: true}}},
+	}
+	blob, err := Encode(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := Decode(blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Metadata.Page != doc.Metadata.Page || loaded.Metadata.Language != "" {
+		t.Fatalf("page = %+v, language %q", loaded.Metadata.Page, loaded.Metadata.Language)
+	}
+	if loaded.Blocks[0].Text.Para.PageBreakBefore || !loaded.Blocks[1].Text.Para.PageBreakBefore {
+		t.Fatal("page break lost")
+	}
+	if p := loaded.Metadata.Page; p.SizeName() != "Letter" || !p.Landscape() || p.TextWidth() != 684 {
+		t.Fatalf("size %s, landscape %t, text width %d", p.SizeName(), p.Landscape(), p.TextWidth())
+	}
+	if p := (PageSetup{}); p.SizeName() != "A4" || p.TextHeight() != 698 {
+		t.Fatalf("default page: %s, text height %d", p.SizeName(), p.TextHeight())
+	}
+
+	doc.Metadata.Page.Left = 720
+	doc.Blocks[0].Kind, doc.Blocks[0].Table = BlockKindTable, NewTable(1, 1)
+	doc.Blocks[0].Table.Rows[0][0].Text.Para.PageBreakBefore = true
+	if errs := ValidateAll(doc); len(errs) != 2 {
+		t.Fatalf("ValidateAll() = %v", errs)
+	}
+}
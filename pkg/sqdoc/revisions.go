@@ -0,0 +1,133 @@
+package sqdoc
+
+import (
+	"encoding/binary"
+	"fmt"
+	"slices"
+	"unicode/utf8"
+)
+
+// RevisionKind is what a suggested change does.
+type RevisionKind uint8
+
+const (
+	// RevisionInsert runs hold text suggested for insertion.
+	RevisionInsert RevisionKind = iota + 1
+	// RevisionDelete runs hold text suggested for deletion, which stays in
+	// the document until the change is accepted.
+	RevisionDelete
+	// RevisionFormat runs had the formatting in Old before the change.
+	RevisionFormat
+)
+
+// Revision is a suggested change recorded in track-changes mode. The runs
+// whose Attr.Revision is its ID are the text it covers, which may span
+// several runs and blocks.
+type Revision struct {
+	ID          uint64
+	Kind        RevisionKind
+	Author      string
+	CreatedUnix int64
+	// Old is the formatting a RevisionFormat change replaced. Only the
+	// character formatting counts, as SameFormatting compares it.
+	Old StyleAttr
+}
+
+// SameFormatting reports whether a and b format text alike. Links, fields,
+// note anchors and revisions are not formatting.
+func SameFormatting(a, b StyleAttr) bool {
+	return WithFormatting(a, b) == a
+}
+
+// WithFormatting returns attr with the character formatting of from.
+func WithFormatting(attr, from StyleAttr) StyleAttr {
+	from.Link, from.Ref, from.Note, from.Revision = attr.Link, attr.Ref, attr.Note, attr.Revision
+	return from
+}
+
+func validateRevisions(doc *Document) []error {
+	var problems []error
+	ids := map[uint64]bool{}
+	for _, r := range doc.Revisions {
+		switch {
+		case r.ID == 0:
+			problems = append(problems, fmt.Errorf("sqdoc: revision id 0 is reserved"))
+		case ids[r.ID]:
+			problems = append(problems, fmt.Errorf("sqdoc: duplicate revision id %d", r.ID))
+		}
+		ids[r.ID] = true
+		if r.Kind < RevisionInsert || r.Kind > RevisionFormat {
+			problems = append(problems, fmt.Errorf("sqdoc: revision %d has unknown kind %d", r.ID, r.Kind))
+		}
+		if !utf8.ValidString(r.Author) {
+			problems = append(problems, fmt.Errorf("sqdoc: revision %d author must be valid UTF-8", r.ID))
+		}
+	}
+	for _, b := range doc.Blocks {
+		if b.Text == nil {
+			continue
+		}
+		for _, run := range b.Text.Runs {
+			if id := run.Attr.Revision; id != 0 && !ids[id] {
+				problems = append(problems, fmt.Errorf("sqdoc: block %d refers to missing revision %d", b.ID, id))
+				break
+			}
+		}
+		if id := b.Text.Break; id != 0 && !ids[id] {
+			problems = append(problems, fmt.Errorf("sqdoc: block %d paragraph break refers to missing revision %d", b.ID, id))
+		}
+	}
+	return problems
+}
+
+// appendRevision writes a revision record for the styled directive.
+func appendRevision(out []byte, r Revision) []byte {
+	out = appendU64(out, r.ID)
+	out = append(out, byte(r.Kind))
+	out = appendI64(out, r.CreatedUnix)
+	out = appendAttr(out, r.Old)
+	out = appendU32(out, r.Old.CharStyle)
+	out = append(out, byte(r.Old.Inherit))
+	return appendString(out, r.Author)
+}
+
+// readRevision is the inverse of appendRevision.
+func readRevision(rec []byte) (Revision, bool) {
+	if len(rec) < 30 {
+		return Revision{}, false
+	}
+	r := Revision{
+		ID:          binary.LittleEndian.Uint64(rec[:8]),
+		Kind:        RevisionKind(rec[8]),
+		CreatedUnix: int64(binary.LittleEndian.Uint64(rec[9:17])),
+		Old:         readAttr(rec[17:25]),
+	}
+	r.Old.CharStyle = binary.LittleEndian.Uint32(rec[25:29])
+	r.Old.Inherit = AttrMask(rec[29]) & AttrAll
+	var ok bool
+	r.Author, _, ok = readString(rec[30:])
+	return r, ok
+}
+
+// mergeRevisions keeps the revisions the merged blocks refer to. Both
+// sides may have numbered new revisions alike; as with blocks added on both
+// sides, the local one wins.
+func mergeRevisions(local, remote []Revision, blocks []Block) []Revision {
+	used := map[uint64]bool{}
+	for _, b := range blocks {
+		if b.Text == nil {
+			continue
+		}
+		for _, r := range b.Text.Runs {
+			used[r.Attr.Revision] = true
+		}
+		used[b.Text.Break] = true
+	}
+	var out []Revision
+	for _, r := range append(slices.Clone(local), remote...) {
+		if used[r.ID] && !slices.ContainsFunc(out, func(o Revision) bool { return o.ID == r.ID }) {
+			out = append(out, r)
+		}
+	}
+	return out
+}
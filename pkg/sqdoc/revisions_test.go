@@ -0,0 +1,49 @@
+package sqdoc
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestRevisionRoundTrip(t *testing.T) {
+	plain := StyleAttr{FontSizePt: 14, ColorRGBA: 0x202020FF}
+	bold := plain
+	bold.Bold = true
+	run := func(start, end uint32, attr StyleAttr, rev uint64) StyleRun {
+		attr.Revision = rev
+		return StyleRun{Start: start, End: end, Attr: attr}
+	}
+	doc := NewDocument("", "")
+	doc.Blocks = []Block{{ID: 1, Kind: BlockKindText, Text: &TextBlock{
+		UTF8:  []byte("keep new old bold"),
+		Runs:  []StyleRun{run(0, 5, plain, 0), run(5, 9, plain, 3), run(9, 13, plain, 4), run(13, 17, bold, 5)},
+		Break: 4,
+	}}, {ID: 2, Kind: BlockKindText, Text: &TextBlock{UTF8: []byte("next"), Runs: []StyleRun{run(0, 4, plain, 0)}}}}
+	doc.Revisions = []Revision{
+		{ID: 3, Kind: RevisionInsert, Author: "Ana", CreatedUnix: 1700000000},
+		{ID: 4, Kind: RevisionDelete, Author: "Rui", CreatedUnix: 1700000100},
+		{ID: 5, Kind: RevisionFormat, Author: "Ana", CreatedUnix: 1700000200, Old: plain},
+	}
+	blob, err := Encode(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := Decode(blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(loaded.Revisions, doc.Revisions) {
+		t.Fatalf("revisions = %+v", loaded.Revisions)
+	}
+	if got := loaded.Blocks[0].Text.Runs; len(got) != 4 || got[1].Attr.Revision != 3 || got[3].Attr.Revision != 5 || !got[3].Attr.Bold {
+		t.Fatalf("runs = %+v", got)
+	}
+	if loaded.Blocks[0].Text.Break != 4 || loaded.Blocks[1].Text.Break != 0 {
+		t.Fatalf("breaks = %d, %d", loaded.Blocks[0].Text.Break, loaded.Blocks[1].Text.Break)
+	}
+
+	doc.Revisions = append(doc.Revisions[:2], Revision{ID: 3, Kind: 9})
+	if errs := ValidateAll(doc); len(errs) != 3 {
+		t.Fatalf("ValidateAll() = %v", errs)
+	}
+}
@@ -75,8 +75,14 @@ const (
 )
 
 type Document struct {
-	Metadata Metadata
-	Blocks   []Block
+	Metadata  Metadata
+	Blocks    []Block
+	Styles    StyleSheet
+	Bookmarks []Bookmark
+	// Comments are the review threads, in the order they were started.
+	Comments []CommentThread
+	// Revisions are the suggested changes the runs refer to.
+	Revisions []Revision
 }
 
 type FontFamily uint8
@@ -95,17 +101,61 @@ type Metadata struct {
 	PagedMode           bool
 	ParagraphGap        uint16
 	PreferredFontFamily FontFamily
+	// Language is a BCP 47 tag such as "en-GB"; empty means unspecified.
+	Language string
+	// Page is the paper and margins paged mode lays the document out on.
+	Page PageSetup
 }
 
 type Block struct {
-	ID   uint64
-	Kind BlockKind
-	Text *TextBlock
+	ID    uint64
+	Kind  BlockKind
+	Text  *TextBlock
+	Table *TableBlock
+	// Note is the kind of a BlockKindNote block.
+	Note NoteKind
 }
 
 type TextBlock struct {
 	UTF8 []byte
 	Runs []StyleRun
+	// Style is the paragraph style ID; 0 means the sheet's default.
+	Style uint32
+	Para  ParagraphFormat
+	List  ListItem
+	// Code, when set, makes the block a code block.
+	Code *CodeBlock
+	// Break is the ID of the suggested change the paragraph break after the
+	// block is part of, 0 for none: an inserted break splits a paragraph and
+	// a deleted one joins the block to the next.
+	Break uint64
+}
+
+type Alignment uint8
+
+const (
+	AlignLeft Alignment = iota
+	AlignCenter
+	AlignRight
+	AlignJustify
+)
+
+// ParagraphFormat is a block's layout. Lengths are in points, and the zero
+// value is a plain left-aligned paragraph.
+type ParagraphFormat struct {
+	Align       Alignment
+	IndentLeft  uint16
+	IndentRight uint16
+	// IndentFirst is added to IndentLeft on the first line; negative values
+	// make a hanging indent.
+	IndentFirst int16
+	// SpaceBefore and SpaceAfter add to the document's paragraph gap.
+	SpaceBefore uint16
+	SpaceAfter  uint16
+	// LineHeight is a percentage of the normal line height; 0 means 100.
+	LineHeight uint16
+	// PageBreakBefore starts the paragraph on a new page in paged layouts.
+	PageBreakBefore bool
 }
 
 type StyleRun struct {
@@ -122,6 +172,23 @@ type StyleAttr struct {
 	FontFamily FontFamily
 	FontSizePt uint16
 	ColorRGBA  uint32
+	// CharStyle is the character style ID, 0 for none.
+	CharStyle uint32
+	// Inherit lists the attributes that follow the paragraph and character
+	// styles; the others are direct formatting. The fields above always hold
+	// the resolved values, so readers that ignore styles still render right.
+	Inherit AttrMask
+	// Link is the target of a hyperlink, empty for none: a URI, or '#' and
+	// an anchor name for a place in the document.
+	Link string
+	// Ref makes the run a cross-reference field when its Target is set.
+	Ref CrossRef
+	// Note makes the run the anchor of the note block with that ID. Its
+	// text is the note's number, kept current like a cross-reference.
+	Note uint64
+	// Revision is the ID of the suggested change the run is part of, 0 for
+	// none.
+	Revision uint64
 }
 
 type FormattingDirectiveEntry struct {
@@ -137,6 +204,7 @@ type LayoutSegment struct {
 	BlockID uint64
 	Offset  uint64
 	Length  uint32
+	CRC32   uint32
 }
 
 type LayoutInfo struct {
@@ -197,18 +265,33 @@ func CloneDocument(doc *Document) *Document {
 	if doc == nil {
 		return nil
 	}
-	out := &Document{Metadata: doc.Metadata, Blocks: make([]Block, len(doc.Blocks))}
+	out := &Document{Metadata: doc.Metadata, Blocks: make([]Block, len(doc.Blocks)), Styles: cloneStyleSheet(doc.Styles)}
+	out.Bookmarks = append([]Bookmark(nil), doc.Bookmarks...)
+	out.Comments = cloneComments(doc.Comments)
+	out.Revisions = append([]Revision(nil), doc.Revisions...)
 	for i, b := range doc.Blocks {
-		out.Blocks[i] = Block{ID: b.ID, Kind: b.Kind}
+		out.Blocks[i] = Block{ID: b.ID, Kind: b.Kind, Note: b.Note}
 		if b.Text != nil {
-			tb := &TextBlock{UTF8: append([]byte(nil), b.Text.UTF8...), Runs: make([]StyleRun, len(b.Text.Runs))}
-			copy(tb.Runs, b.Text.Runs)
-			out.Blocks[i].Text = tb
+			out.Blocks[i].Text = cloneTextBlock(b.Text)
+		}
+		if b.Table != nil {
+			out.Blocks[i].Table = cloneTable(b.Table)
 		}
 	}
 	return out
 }
 
+func cloneTextBlock(tb *TextBlock) *TextBlock {
+	out := *tb
+	out.UTF8 = append([]byte(nil), tb.UTF8...)
+	out.Runs = append([]StyleRun(nil), tb.Runs...)
+	if tb.Code != nil {
+		code := *tb.Code
+		out.Code = &code
+	}
+	return &out
+}
+
 func Save(path string, doc *Document) error {
 	return SaveWithOptions(path, doc, SaveOptions{})
 }
@@ -263,21 +346,36 @@ func SaveWithOptions(path string, doc *Document, opts SaveOptions) error {
 	return os.Rename(tmp, path)
 }
 
+// Encode returns doc in the plain SQDoc layout, without an envelope and
+// without touching its timestamps, for embedding in other containers.
+func Encode(doc *Document) ([]byte, error) {
+	if err := Validate(doc); err != nil {
+		return nil, err
+	}
+	return encodeDocument(doc)
+}
+
+// Decode parses a blob written by Encode.
+func Decode(blob []byte) (*Document, error) {
+	doc, err := decodeDocument(blob)
+	if err != nil {
+		return nil, err
+	}
+	if err := Validate(doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
 func Load(path string) (*Document, error) {
 	return LoadWithOptions(path, LoadOptions{})
 }
 
 func LoadWithOptions(path string, opts LoadOptions) (*Document, error) {
-	b, err := os.ReadFile(path)
+	b, err := readDocumentBytes(path, opts)
 	if err != nil {
 		return nil, err
 	}
-	if isSecureEnvelope(b) {
-		b, err = decodeSecureEnvelope(b, opts)
-		if err != nil {
-			return nil, err
-		}
-	}
 	doc, err := decodeDocument(b)
 	if err != nil {
 		return nil, err
@@ -305,6 +403,10 @@ func InspectLayout(doc *Document) (*LayoutInfo, error) {
 		return nil, err
 	}
 
+	return buildLayout(res.Entries, res.TOCOffset, res.TOCLength, uint64(len(res.Blob))), nil
+}
+
+func buildLayout(entries []tocEntry, tocOffset uint64, tocLength uint32, fileSize uint64) *LayoutInfo {
 	segments := []LayoutSegment{{
 		Name:    "Header",
 		Kind:    BlockKindMetadata,
@@ -315,10 +417,10 @@ func InspectLayout(doc *Document) (*LayoutInfo, error) {
 		Name:    "Index",
 		Kind:    BlockKindStyle,
 		BlockID: fmtBlockID,
-		Offset:  res.TOCOffset,
-		Length:  res.TOCLength,
+		Offset:  tocOffset,
+		Length:  tocLength,
 	}}
-	for _, e := range res.Entries {
+	for _, e := range entries {
 		name := "Block"
 		switch e.Kind {
 		case BlockKindMetadata:
@@ -327,6 +429,12 @@ func InspectLayout(doc *Document) (*LayoutInfo, error) {
 			name = "Formatting Directive"
 		case BlockKindText:
 			name = "Data Block"
+		case BlockKindTable:
+			name = "Table Block"
+		case BlockKindNote:
+			name = "Note Block"
+		case BlockKindComment:
+			name = "Comment Block"
 		}
 		segments = append(segments, LayoutSegment{
 			Name:    name,
@@ -334,55 +442,111 @@ func InspectLayout(doc *Document) (*LayoutInfo, error) {
 			BlockID: e.ID,
 			Offset:  e.Offset,
 			Length:  e.Length,
+			CRC32:   e.CRC32,
 		})
 	}
 	sort.SliceStable(segments, func(i, j int) bool { return segments[i].Offset < segments[j].Offset })
 
 	return &LayoutInfo{
 		HeaderLength: headerSize,
-		IndexOffset:  res.TOCOffset,
-		IndexLength:  res.TOCLength,
-		FileSize:     uint64(len(res.Blob)),
+		IndexOffset:  tocOffset,
+		IndexLength:  tocLength,
+		FileSize:     fileSize,
 		Segments:     segments,
-	}, nil
+	}
 }
 
 func Validate(doc *Document) error {
+	if problems := ValidateAll(doc); len(problems) > 0 {
+		return problems[0]
+	}
+	return nil
+}
+
+// ValidateAll is Validate without stopping at the first problem.
+func ValidateAll(doc *Document) []error {
 	if doc == nil {
-		return errors.New("sqdoc: document is nil")
+		return []error{errors.New("sqdoc: document is nil")}
 	}
+	var problems []error
 	if !utf8.ValidString(doc.Metadata.Author) || !utf8.ValidString(doc.Metadata.Title) {
-		return errors.New("sqdoc: metadata fields must be valid UTF-8")
+		problems = append(problems, errors.New("sqdoc: metadata fields must be valid UTF-8"))
 	}
 	if !isValidFontFamily(doc.Metadata.PreferredFontFamily) {
-		return errors.New("sqdoc: metadata preferred font family is invalid")
+		problems = append(problems, errors.New("sqdoc: metadata preferred font family is invalid"))
 	}
+	if err := doc.Metadata.Page.Validate(); err != nil {
+		problems = append(problems, fmt.Errorf("sqdoc: metadata: %w", err))
+	}
+	problems = append(problems, validateStyleSheet(doc.Styles)...)
+	problems = append(problems, validateBookmarks(doc)...)
+	problems = append(problems, validateAnchors(doc)...)
+	problems = append(problems, validateComments(doc)...)
+	problems = append(problems, validateRevisions(doc)...)
+	sheet := doc.StyleSheet()
 
 	seenIDs := map[uint64]struct{}{}
 	for i := range doc.Blocks {
 		b := &doc.Blocks[i]
 		if b.ID == 0 || b.ID == fmtBlockID {
-			return fmt.Errorf("sqdoc: block[%d] id is reserved", i)
-		}
-		if _, ok := seenIDs[b.ID]; ok {
-			return fmt.Errorf("sqdoc: duplicate block id %d", b.ID)
+			problems = append(problems, fmt.Errorf("sqdoc: block[%d] id is reserved", i))
+		} else if _, ok := seenIDs[b.ID]; ok {
+			problems = append(problems, fmt.Errorf("sqdoc: duplicate block id %d", b.ID))
 		}
 		seenIDs[b.ID] = struct{}{}
 
+		if b.Kind == BlockKindTable {
+			if b.Table == nil {
+				problems = append(problems, fmt.Errorf("sqdoc: table block %d missing payload", b.ID))
+				continue
+			}
+			for _, err := range validateTable(b.Table, sheet) {
+				problems = append(problems, fmt.Errorf("sqdoc: table %d: %w", b.ID, err))
+			}
+			continue
+		}
+		if b.Kind == BlockKindNote {
+			if b.Text == nil {
+				problems = append(problems, fmt.Errorf("sqdoc: note block %d missing payload", b.ID))
+			} else if err := validateNote(b, sheet); err != nil {
+				problems = append(problems, fmt.Errorf("sqdoc: note %d: %w", b.ID, err))
+			}
+			continue
+		}
 		if b.Kind != BlockKindText {
-			return fmt.Errorf("sqdoc: unsupported block kind %d for save", b.Kind)
+			problems = append(problems, fmt.Errorf("sqdoc: unsupported block kind %d for save", b.Kind))
+			continue
 		}
 		if b.Text == nil {
-			return fmt.Errorf("sqdoc: text block %d missing payload", b.ID)
+			problems = append(problems, fmt.Errorf("sqdoc: text block %d missing payload", b.ID))
+			continue
 		}
 		if !utf8.Valid(b.Text.UTF8) {
-			return fmt.Errorf("sqdoc: text block %d is not valid UTF-8", b.ID)
+			problems = append(problems, fmt.Errorf("sqdoc: text block %d is not valid UTF-8", b.ID))
 		}
 		if err := validateRuns(b.Text); err != nil {
-			return fmt.Errorf("sqdoc: block %d: %w", b.ID, err)
+			problems = append(problems, fmt.Errorf("sqdoc: block %d: %w", b.ID, err))
+		}
+		if err := validateParagraph(b.Text.Para); err != nil {
+			problems = append(problems, fmt.Errorf("sqdoc: block %d: %w", b.ID, err))
+		}
+		if err := validateList(b.Text.List); err != nil {
+			problems = append(problems, fmt.Errorf("sqdoc: block %d: %w", b.ID, err))
+		}
+		if err := validateCode(b.Text); err != nil {
+			problems = append(problems, fmt.Errorf("sqdoc: block %d: %w", b.ID, err))
+		}
+		if !sheet.CheckRef(b.Text.Style, StyleKindParagraph) {
+			problems = append(problems, fmt.Errorf("sqdoc: block %d uses unknown paragraph style %d", b.ID, b.Text.Style))
+		}
+		for _, r := range b.Text.Runs {
+			if !sheet.CheckRef(r.Attr.CharStyle, StyleKindCharacter) {
+				problems = append(problems, fmt.Errorf("sqdoc: block %d uses unknown character style %d", b.ID, r.Attr.CharStyle))
+				break
+			}
 		}
 	}
-	return nil
+	return problems
 }
 
 func validateRuns(tb *TextBlock) error {
@@ -417,11 +581,30 @@ func validateRuns(tb *TextBlock) error {
 		if !isValidFontFamily(r.Attr.FontFamily) {
 			return errors.New("font family is invalid")
 		}
+		if err := validateLink(r.Attr.Link); err != nil {
+			return err
+		}
+		if err := validateRef(r.Attr.Ref); err != nil {
+			return err
+		}
 		lastEnd = r.End
 	}
 	return nil
 }
 
+func validateParagraph(p ParagraphFormat) error {
+	if p.Align > AlignJustify {
+		return fmt.Errorf("unknown alignment %d", p.Align)
+	}
+	if int(p.IndentLeft)+int(p.IndentFirst) < 0 {
+		return errors.New("first-line indent reaches past the left margin")
+	}
+	if p.LineHeight != 0 && (p.LineHeight < 50 || p.LineHeight > 500) {
+		return fmt.Errorf("line height %d%% outside 50..500", p.LineHeight)
+	}
+	return nil
+}
+
 func encodeDocument(doc *Document) ([]byte, error) {
 	res, err := encodeDocumentDetailed(doc)
 	if err != nil {
@@ -440,14 +623,19 @@ func encodeDocumentDetailed(doc *Document) (*encodeResult, error) {
 		Payload: metaPayload,
 	})
 
-	fmtPayload := encodeFormattingDirective(collectFormatting(doc))
+	fmtPayload := encodeFormatting(doc)
 	payloads = append(payloads, payloadEntry{
 		ID:      fmtBlockID,
 		Kind:    BlockKindStyle,
 		Payload: fmtPayload,
 	})
 
+	// Notes no anchor refers to are dropped.
+	numbers := doc.NoteNumbers()
 	for _, b := range doc.Blocks {
+		if _, ok := numbers[b.ID]; b.Kind == BlockKindNote && !ok {
+			continue
+		}
 		payload, err := encodeBlockPayload(b)
 		if err != nil {
 			return nil, err
@@ -458,6 +646,16 @@ func encodeDocumentDetailed(doc *Document) (*encodeResult, error) {
 			Payload: payload,
 		})
 	}
+	// Threads whose block was deleted are dropped, like bookmarks.
+	texts := map[uint64]bool{}
+	for _, b := range doc.Blocks {
+		texts[b.ID] = b.Kind == BlockKindText
+	}
+	for _, t := range doc.Comments {
+		if texts[t.BlockID] {
+			payloads = append(payloads, payloadEntry{ID: t.ID, Kind: BlockKindComment, Payload: encodeComment(t)})
+		}
+	}
 
 	tocOffset := uint64(headerSize)
 	tocLength := uint32(len(payloads) * tocEntSize)
@@ -497,49 +695,14 @@ func encodeDocumentDetailed(doc *Document) (*encodeResult, error) {
 }
 
 func decodeDocument(blob []byte) (*Document, error) {
-	if len(blob) < headerSize {
-		return nil, ErrInvalidMagic
-	}
-	if string(blob[:26]) != MagicString {
-		return nil, ErrInvalidMagic
-	}
-	if v := binary.LittleEndian.Uint16(blob[26:28]); v != VersionV1 {
-		return nil, fmt.Errorf("%w: %d", ErrUnsupportedVer, v)
-	}
-	if flags := binary.LittleEndian.Uint16(blob[28:30]); flags&FlagRandomAccess == 0 {
-		return nil, ErrMissingRandomFlag
-	}
-
-	tocOffset := binary.LittleEndian.Uint64(blob[30:38])
-	tocCount := binary.LittleEndian.Uint32(blob[38:42])
-	if tocOffset > uint64(len(blob)) {
-		return nil, ErrInvalidTOC
-	}
-	end := tocOffset + uint64(tocCount)*uint64(tocEntSize)
-	if end > uint64(len(blob)) {
-		return nil, ErrInvalidTOC
-	}
-
-	entries := make([]tocEntry, 0, tocCount)
-	ptr := int(tocOffset)
-	for i := 0; i < int(tocCount); i++ {
-		entries = append(entries, tocEntry{
-			ID:     binary.LittleEndian.Uint64(blob[ptr : ptr+8]),
-			Kind:   BlockKind(blob[ptr+8]),
-			Offset: binary.LittleEndian.Uint64(blob[ptr+9 : ptr+17]),
-			Length: binary.LittleEndian.Uint32(blob[ptr+17 : ptr+21]),
-			CRC32:  binary.LittleEndian.Uint32(blob[ptr+21 : ptr+25]),
-		})
-		ptr += tocEntSize
-	}
-
-	if err := validateEntryRanges(entries, len(blob)); err != nil {
+	entries, err := parseTOC(blob)
+	if err != nil {
 		return nil, err
 	}
 
 	doc := &Document{}
 	blockByID := map[uint64]*Block{}
-	var directive []FormattingDirectiveEntry
+	var directive *formatting
 
 	for _, e := range entries {
 		start := int(e.Offset)
@@ -557,11 +720,11 @@ func decodeDocument(blob []byte) (*Document, error) {
 			}
 			doc.Metadata = m
 		case BlockKindStyle:
-			fmtEntries, err := decodeFormattingDirective(payload)
+			f, err := decodeFormattingDirective(payload)
 			if err != nil {
 				return nil, err
 			}
-			directive = fmtEntries
+			directive = f
 		case BlockKindText:
 			tb, err := decodeTextBlock(payload)
 			if err != nil {
@@ -570,17 +733,48 @@ func decodeDocument(blob []byte) (*Document, error) {
 			blk := Block{ID: e.ID, Kind: BlockKindText, Text: tb}
 			doc.Blocks = append(doc.Blocks, blk)
 			blockByID[e.ID] = &doc.Blocks[len(doc.Blocks)-1]
+		case BlockKindTable:
+			t, err := decodeTable(payload)
+			if err != nil {
+				return nil, err
+			}
+			doc.Blocks = append(doc.Blocks, Block{ID: e.ID, Kind: BlockKindTable, Table: t})
+		case BlockKindNote:
+			kind, tb, err := decodeNote(payload)
+			if err != nil {
+				return nil, err
+			}
+			doc.Blocks = append(doc.Blocks, Block{ID: e.ID, Kind: BlockKindNote, Text: tb, Note: kind})
+			blockByID[e.ID] = &doc.Blocks[len(doc.Blocks)-1]
+		case BlockKindComment:
+			t, err := decodeComment(e.ID, payload)
+			if err != nil {
+				return nil, err
+			}
+			doc.Comments = append(doc.Comments, t)
 		default:
 			// Forward compatible: unknown kinds remain skippable via TOC.
 		}
 	}
 
-	if len(directive) > 0 {
-		for _, d := range directive {
+	if directive != nil {
+		for _, d := range directive.runs {
 			if b := blockByID[d.BlockID]; b != nil && b.Text != nil {
 				b.Text.Runs = append(b.Text.Runs, StyleRun{Start: d.Start, End: d.End, Attr: d.Attr})
 			}
 		}
+		for id, p := range directive.paragraphs {
+			if b := blockByID[id]; b != nil && b.Text != nil {
+				b.Text.Style = p.style
+				b.Text.Para = p.format
+				b.Text.List = p.list
+				b.Text.Code = p.code
+				b.Text.Break = p.brk
+			}
+		}
+		doc.Styles = directive.styles
+		doc.Bookmarks = directive.bookmarks
+		doc.Revisions = directive.revisions
 	}
 	for i := range doc.Blocks {
 		tb := doc.Blocks[i].Text
@@ -598,10 +792,53 @@ func decodeDocument(blob []byte) (*Document, error) {
 	return doc, nil
 }
 
+func parseTOC(blob []byte) ([]tocEntry, error) {
+	if len(blob) < headerSize {
+		return nil, ErrInvalidMagic
+	}
+	if string(blob[:26]) != MagicString {
+		return nil, ErrInvalidMagic
+	}
+	if v := binary.LittleEndian.Uint16(blob[26:28]); v != VersionV1 {
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedVer, v)
+	}
+	if flags := binary.LittleEndian.Uint16(blob[28:30]); flags&FlagRandomAccess == 0 {
+		return nil, ErrMissingRandomFlag
+	}
+
+	tocOffset := binary.LittleEndian.Uint64(blob[30:38])
+	tocCount := binary.LittleEndian.Uint32(blob[38:42])
+	if tocOffset > uint64(len(blob)) {
+		return nil, ErrInvalidTOC
+	}
+	end := tocOffset + uint64(tocCount)*uint64(tocEntSize)
+	if end > uint64(len(blob)) {
+		return nil, ErrInvalidTOC
+	}
+
+	entries := make([]tocEntry, 0, tocCount)
+	ptr := int(tocOffset)
+	for i := 0; i < int(tocCount); i++ {
+		entries = append(entries, tocEntry{
+			ID:     binary.LittleEndian.Uint64(blob[ptr : ptr+8]),
+			Kind:   BlockKind(blob[ptr+8]),
+			Offset: binary.LittleEndian.Uint64(blob[ptr+9 : ptr+17]),
+			Length: binary.LittleEndian.Uint32(blob[ptr+17 : ptr+21]),
+			CRC32:  binary.LittleEndian.Uint32(blob[ptr+21 : ptr+25]),
+		})
+		ptr += tocEntSize
+	}
+
+	if err := validateEntryRanges(entries, len(blob)); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
 func collectFormatting(doc *Document) []FormattingDirectiveEntry {
 	out := make([]FormattingDirectiveEntry, 0)
 	for _, b := range doc.Blocks {
-		if b.Kind != BlockKindText || b.Text == nil {
+		if (b.Kind != BlockKindText && b.Kind != BlockKindNote) || b.Text == nil {
 			continue
 		}
 		for _, r := range b.Text.Runs {
@@ -632,34 +869,56 @@ func encodeFormattingDirective(entries []FormattingDirectiveEntry) []byte {
 		out = appendU64(out, e.BlockID)
 		out = appendU32(out, e.Start)
 		out = appendU32(out, e.End)
-		flags := uint8(0)
-		if e.Attr.Bold {
-			flags |= 1
-		}
-		if e.Attr.Italic {
-			flags |= 2
-		}
-		if e.Attr.Underline {
-			flags |= 4
-		}
-		if e.Attr.Highlight {
-			flags |= 8
-		}
-		out = append(out, flags)
-		out = append(out, byte(normalizeFontFamily(e.Attr.FontFamily)))
-		out = appendU16(out, e.Attr.FontSizePt)
-		out = appendU32(out, e.Attr.ColorRGBA)
+		out = appendAttr(out, e.Attr)
 	}
 	return out
 }
 
-func decodeFormattingDirective(b []byte) ([]FormattingDirectiveEntry, error) {
+// appendAttr writes the flags, font family, size and colour of a style
+// entry.
+func appendAttr(out []byte, a StyleAttr) []byte {
+	flags := uint8(0)
+	if a.Bold {
+		flags |= 1
+	}
+	if a.Italic {
+		flags |= 2
+	}
+	if a.Underline {
+		flags |= 4
+	}
+	if a.Highlight {
+		flags |= 8
+	}
+	out = append(out, flags)
+	out = append(out, byte(normalizeFontFamily(a.FontFamily)))
+	out = appendU16(out, a.FontSizePt)
+	return appendU32(out, a.ColorRGBA)
+}
+
+// readAttr is the inverse of appendAttr; b must hold at least 8 bytes.
+func readAttr(b []byte) StyleAttr {
+	return StyleAttr{
+		Bold:       b[0]&1 != 0,
+		Italic:     b[0]&2 != 0,
+		Underline:  b[0]&4 != 0,
+		Highlight:  b[0]&8 != 0,
+		FontFamily: normalizeFontFamily(FontFamily(b[1])),
+		FontSizePt: binary.LittleEndian.Uint16(b[2:4]),
+		ColorRGBA:  binary.LittleEndian.Uint32(b[4:8]),
+	}
+}
+
+func decodeFormattingDirective(b []byte) (*formatting, error) {
 	if len(b) < 4 {
 		return nil, errors.New("sqdoc: malformed formatting directive block")
 	}
+	if binary.LittleEndian.Uint32(b[:4]) == styledDirectiveMarker {
+		return decodeStyledDirective(b[4:])
+	}
 	count := int(binary.LittleEndian.Uint32(b[:4]))
 	ptr := 4
-	out := make([]FormattingDirectiveEntry, 0, count)
+	out := &formatting{runs: make([]FormattingDirectiveEntry, 0, count)}
 	if count == 0 {
 		return out, nil
 	}
@@ -675,33 +934,22 @@ func decodeFormattingDirective(b []byte) ([]FormattingDirectiveEntry, error) {
 		if len(b[ptr:]) < entrySize {
 			return nil, errors.New("sqdoc: malformed formatting directive entry")
 		}
-		blockID := binary.LittleEndian.Uint64(b[ptr : ptr+8])
-		start := binary.LittleEndian.Uint32(b[ptr+8 : ptr+12])
-		end := binary.LittleEndian.Uint32(b[ptr+12 : ptr+16])
-		flags := b[ptr+16]
-		fontFamily := FontFamilySans
-		fontOffset := 17
+		e := FormattingDirectiveEntry{
+			BlockID: binary.LittleEndian.Uint64(b[ptr : ptr+8]),
+			Start:   binary.LittleEndian.Uint32(b[ptr+8 : ptr+12]),
+			End:     binary.LittleEndian.Uint32(b[ptr+12 : ptr+16]),
+		}
 		if entrySize == styleEntSz {
-			fontFamily = normalizeFontFamily(FontFamily(b[ptr+17]))
-			fontOffset = 18
+			e.Attr = readAttr(b[ptr+16:])
+		} else {
+			// V1 entries have no font family byte.
+			var attr [8]byte
+			attr[0] = b[ptr+16]
+			copy(attr[2:], b[ptr+17:ptr+entrySize])
+			e.Attr = readAttr(attr[:])
 		}
-		fontSize := binary.LittleEndian.Uint16(b[ptr+fontOffset : ptr+fontOffset+2])
-		color := binary.LittleEndian.Uint32(b[ptr+fontOffset+2 : ptr+fontOffset+6])
 		ptr += entrySize
-		out = append(out, FormattingDirectiveEntry{
-			BlockID: blockID,
-			Start:   start,
-			End:     end,
-			Attr: StyleAttr{
-				Bold:       flags&1 != 0,
-				Italic:     flags&2 != 0,
-				Underline:  flags&4 != 0,
-				Highlight:  flags&8 != 0,
-				FontFamily: fontFamily,
-				FontSizePt: fontSize,
-				ColorRGBA:  color,
-			},
-		})
+		out.runs = append(out.runs, e)
 	}
 	return out, nil
 }
@@ -743,6 +991,12 @@ func encodeMetadata(m Metadata) []byte {
 	out = append(out, flags)
 	out = appendU16(out, m.ParagraphGap)
 	out = append(out, byte(normalizeFontFamily(m.PreferredFontFamily)))
+	if m.Language != "" || m.Page != (PageSetup{}) {
+		out = appendString(out, m.Language)
+	}
+	if m.Page != (PageSetup{}) {
+		out = appendPageSetup(out, m.Page)
+	}
 	return out
 }
 
@@ -774,6 +1028,17 @@ func decodeMetadata(b []byte) (Metadata, error) {
 	m.PagedMode = flags&1 != 0
 	m.ParagraphGap = binary.LittleEndian.Uint16(b[1:3])
 	m.PreferredFontFamily = normalizeFontFamily(FontFamily(b[3]))
+	b = b[4:]
+	if len(b) == 0 {
+		return m, nil
+	}
+	if m.Language, b, ok = readString(b); !ok {
+		return m, errors.New("sqdoc: malformed metadata language")
+	}
+	// Files written before page setup end here.
+	if len(b) >= pageSetupSize {
+		m.Page = readPageSetup(b)
+	}
 	return m, nil
 }
 
@@ -784,6 +1049,16 @@ func encodeBlockPayload(b Block) ([]byte, error) {
 			return nil, errors.New("sqdoc: text block payload is nil")
 		}
 		return encodeTextBlock(b.Text), nil
+	case BlockKindTable:
+		if b.Table == nil {
+			return nil, errors.New("sqdoc: table block payload is nil")
+		}
+		return encodeTable(b.Table), nil
+	case BlockKindNote:
+		if b.Text == nil {
+			return nil, errors.New("sqdoc: note block payload is nil")
+		}
+		return encodeNote(b), nil
 	default:
 		return nil, fmt.Errorf("sqdoc: unsupported block kind %d", b.Kind)
 	}
@@ -319,6 +319,7 @@ func TestRoundTripPreservesDocumentSettingsAndFontFamily(t *testing.T) {
 	doc.Metadata.PagedMode = true
 	doc.Metadata.ParagraphGap = 14
 	doc.Metadata.PreferredFontFamily = FontFamilyMonospace
+	doc.Metadata.Language = "pt-BR"
 	doc.Blocks = append(doc.Blocks, Block{
 		ID:   1,
 		Kind: BlockKindText,
@@ -354,6 +355,9 @@ func TestRoundTripPreservesDocumentSettingsAndFontFamily(t *testing.T) {
 	if loaded.Metadata.PreferredFontFamily != FontFamilyMonospace {
 		t.Fatalf("preferred font mismatch: got %d", loaded.Metadata.PreferredFontFamily)
 	}
+	if loaded.Metadata.Language != "pt-BR" {
+		t.Fatalf("language mismatch: got %q", loaded.Metadata.Language)
+	}
 	if got := loaded.Blocks[0].Text.Runs[0].Attr.FontFamily; got != FontFamilySerif {
 		t.Fatalf("run font family mismatch: got %d", got)
 	}
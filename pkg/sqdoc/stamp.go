@@ -0,0 +1,45 @@
+package sqdoc
+
+import (
+	"crypto/sha256"
+	"io"
+	"os"
+	"time"
+)
+
+type FileStamp struct {
+	Size    int64
+	ModTime time.Time
+	SHA256  [32]byte
+}
+
+func StampFile(path string) (FileStamp, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return FileStamp{}, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return FileStamp{}, err
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return FileStamp{}, err
+	}
+	stamp := FileStamp{Size: info.Size(), ModTime: info.ModTime()}
+	copy(stamp.SHA256[:], h.Sum(nil))
+	return stamp, nil
+}
+
+// StatChanged reports whether size or mtime moved without hashing the file.
+func (s FileStamp) StatChanged(info os.FileInfo) bool {
+	if info == nil {
+		return true
+	}
+	return info.Size() != s.Size || !info.ModTime().Equal(s.ModTime)
+}
+
+func (s FileStamp) SameContent(o FileStamp) bool {
+	return s.Size == o.Size && s.SHA256 == o.SHA256
+}
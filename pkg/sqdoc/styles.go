@@ -0,0 +1,604 @@
+package sqdoc
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"unicode/utf8"
+)
+
+// AttrMask selects formatting fields of a StyleAttr.
+type AttrMask uint8
+
+const (
+	AttrBold AttrMask = 1 << iota
+	AttrItalic
+	AttrUnderline
+	AttrHighlight
+	AttrFontFamily
+	AttrFontSize
+	AttrColor
+
+	AttrAll = AttrBold | AttrItalic | AttrUnderline | AttrHighlight | AttrFontFamily | AttrFontSize | AttrColor
+)
+
+type StyleKind uint8
+
+const (
+	StyleKindParagraph StyleKind = 0
+	StyleKindCharacter StyleKind = 1
+)
+
+// Style is a named set of attributes. Attributes outside Set come from the
+// BasedOn style, or for a root style from the built-in base of 14pt sans.
+type Style struct {
+	ID      uint32
+	Name    string
+	Kind    StyleKind
+	BasedOn uint32
+	Set     AttrMask
+	Attr    StyleAttr
+}
+
+// StyleSheet holds a document's named styles. An empty sheet stands for
+// DefaultStyleSheet, so documents only store one once a style is changed.
+type StyleSheet struct {
+	// Default is the paragraph style of blocks that do not name one.
+	Default uint32
+	Styles  []Style
+}
+
+// IDs of the built-in styles. Documents may redefine them but keep the IDs,
+// which converters use to recognise headings.
+const (
+	StyleNormal uint32 = iota + 1
+	StyleHeading1
+	StyleHeading2
+	StyleHeading3
+	StyleHeading4
+	StyleHeading5
+	StyleHeading6
+	StyleQuote
+	StyleCode
+)
+
+var baseStyleAttr = StyleAttr{FontSizePt: 14, ColorRGBA: 0x202020FF, FontFamily: FontFamilySans}
+
+func DefaultStyleSheet() StyleSheet {
+	sheet := StyleSheet{Default: StyleNormal}
+	sheet.Styles = append(sheet.Styles, Style{ID: StyleNormal, Name: "Normal", Set: AttrAll, Attr: baseStyleAttr})
+	for i, size := range [...]uint16{24, 20, 18, 16, 15, 14} {
+		sheet.Styles = append(sheet.Styles, Style{
+			ID:      StyleHeading1 + uint32(i),
+			Name:    fmt.Sprintf("Heading %d", i+1),
+			BasedOn: StyleNormal,
+			Set:     AttrBold | AttrFontSize,
+			Attr:    StyleAttr{Bold: true, FontSizePt: size},
+		})
+	}
+	sheet.Styles = append(sheet.Styles,
+		Style{ID: StyleQuote, Name: "Quote", BasedOn: StyleNormal, Set: AttrItalic | AttrColor, Attr: StyleAttr{Italic: true, ColorRGBA: 0x4A5568FF}},
+		Style{ID: StyleCode, Name: "Code", Kind: StyleKindCharacter, Set: AttrFontFamily, Attr: StyleAttr{FontFamily: FontFamilyMonospace}},
+	)
+	return sheet
+}
+
+// HeadingStyleLevel returns 1..6 for the built-in heading styles and 0 for
+// any other ID.
+func HeadingStyleLevel(id uint32) int {
+	if id >= StyleHeading1 && id <= StyleHeading6 {
+		return int(id-StyleHeading1) + 1
+	}
+	return 0
+}
+
+// StyleSheet returns the document's styles, or the built-in ones when it
+// has not stored any.
+func (d *Document) StyleSheet() StyleSheet {
+	if len(d.Styles.Styles) == 0 {
+		return DefaultStyleSheet()
+	}
+	return d.Styles
+}
+
+func (s StyleSheet) Lookup(id uint32) (Style, bool) {
+	for _, st := range s.Styles {
+		if st.ID == id {
+			return st, true
+		}
+	}
+	return Style{}, false
+}
+
+// chain returns id and its ancestors, root first.
+func (s StyleSheet) chain(id uint32) []Style {
+	var out []Style
+	for id != 0 && len(out) <= len(s.Styles) {
+		st, ok := s.Lookup(id)
+		if !ok {
+			break
+		}
+		out = append([]Style{st}, out...)
+		id = st.BasedOn
+	}
+	return out
+}
+
+// Defines reports which attributes style id or its ancestors set.
+func (s StyleSheet) Defines(id uint32) AttrMask {
+	var mask AttrMask
+	for _, st := range s.chain(id) {
+		mask |= st.Set
+	}
+	return mask
+}
+
+// Resolve returns the attributes of text in a paragraph of style para with
+// character style char, before direct formatting. A para of 0 means the
+// default paragraph style.
+func (s StyleSheet) Resolve(para, char uint32) StyleAttr {
+	if para == 0 {
+		para = s.Default
+	}
+	attr := baseStyleAttr
+	for _, st := range s.chain(para) {
+		CopyAttrs(&attr, st.Attr, st.Set)
+	}
+	for _, st := range s.chain(char) {
+		CopyAttrs(&attr, st.Attr, st.Set)
+	}
+	return attr
+}
+
+// ApplyStyles recomputes the inherited attributes of every run from the
+// style sheet, so a changed style shows everywhere it is used.
+func (d *Document) ApplyStyles() {
+	sheet := d.StyleSheet()
+	for _, tb := range d.TextBlocks() {
+		for j := range tb.Runs {
+			attr := &tb.Runs[j].Attr
+			if attr.Inherit != 0 {
+				CopyAttrs(attr, sheet.Resolve(tb.Style, attr.CharStyle), attr.Inherit)
+			}
+		}
+	}
+}
+
+// CopyAttrs copies the attributes selected by mask from src to dst.
+func CopyAttrs(dst *StyleAttr, src StyleAttr, mask AttrMask) {
+	if mask&AttrBold != 0 {
+		dst.Bold = src.Bold
+	}
+	if mask&AttrItalic != 0 {
+		dst.Italic = src.Italic
+	}
+	if mask&AttrUnderline != 0 {
+		dst.Underline = src.Underline
+	}
+	if mask&AttrHighlight != 0 {
+		dst.Highlight = src.Highlight
+	}
+	if mask&AttrFontFamily != 0 {
+		dst.FontFamily = src.FontFamily
+	}
+	if mask&AttrFontSize != 0 {
+		dst.FontSizePt = src.FontSizePt
+	}
+	if mask&AttrColor != 0 {
+		dst.ColorRGBA = src.ColorRGBA
+	}
+}
+
+// DiffAttrs reports which formatting attributes differ between a and b.
+func DiffAttrs(a, b StyleAttr) AttrMask {
+	var mask AttrMask
+	if a.Bold != b.Bold {
+		mask |= AttrBold
+	}
+	if a.Italic != b.Italic {
+		mask |= AttrItalic
+	}
+	if a.Underline != b.Underline {
+		mask |= AttrUnderline
+	}
+	if a.Highlight != b.Highlight {
+		mask |= AttrHighlight
+	}
+	if a.FontFamily != b.FontFamily {
+		mask |= AttrFontFamily
+	}
+	if a.FontSizePt != b.FontSizePt {
+		mask |= AttrFontSize
+	}
+	if a.ColorRGBA != b.ColorRGBA {
+		mask |= AttrColor
+	}
+	return mask
+}
+
+// usesStyles reports whether doc needs the styled formatting directive,
+// which also carries paragraph formatting.
+func usesStyles(doc *Document) bool {
+	if len(doc.Styles.Styles) > 0 || len(doc.Bookmarks) > 0 || len(doc.Revisions) > 0 {
+		return true
+	}
+	for _, b := range doc.Blocks {
+		if b.Text == nil {
+			continue
+		}
+		if hasParagraphRecord(b.Text) {
+			return true
+		}
+		for _, r := range b.Text.Runs {
+			if r.Attr.CharStyle != 0 || r.Attr.Inherit != 0 || r.Attr.Link != "" || r.Attr.Ref.Target != "" || r.Attr.Note != 0 || r.Attr.Revision != 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func validateStyleSheet(sheet StyleSheet) []error {
+	var problems []error
+	seen := map[uint32]StyleKind{}
+	for _, st := range sheet.Styles {
+		if st.ID == 0 {
+			problems = append(problems, errors.New("sqdoc: style id 0 is reserved"))
+			continue
+		}
+		if _, ok := seen[st.ID]; ok {
+			problems = append(problems, fmt.Errorf("sqdoc: duplicate style id %d", st.ID))
+			continue
+		}
+		seen[st.ID] = st.Kind
+		if st.Name == "" || !utf8.ValidString(st.Name) {
+			problems = append(problems, fmt.Errorf("sqdoc: style %d needs a valid UTF-8 name", st.ID))
+		}
+		if st.Kind != StyleKindParagraph && st.Kind != StyleKindCharacter {
+			problems = append(problems, fmt.Errorf("sqdoc: style %d has unknown kind %d", st.ID, st.Kind))
+		}
+		if st.Set&AttrFontSize != 0 && st.Attr.FontSizePt == 0 {
+			problems = append(problems, fmt.Errorf("sqdoc: style %d font size must be non-zero", st.ID))
+		}
+		if st.Set&AttrFontFamily != 0 && !isValidFontFamily(st.Attr.FontFamily) {
+			problems = append(problems, fmt.Errorf("sqdoc: style %d font family is invalid", st.ID))
+		}
+	}
+	for _, st := range sheet.Styles {
+		if st.BasedOn == 0 {
+			continue
+		}
+		if kind, ok := seen[st.BasedOn]; !ok || kind != st.Kind {
+			problems = append(problems, fmt.Errorf("sqdoc: style %d is based on missing style %d", st.ID, st.BasedOn))
+		} else if len(sheet.chain(st.ID)) > len(sheet.Styles) {
+			problems = append(problems, fmt.Errorf("sqdoc: style %d inherits from itself", st.ID))
+		}
+	}
+	if kind, ok := seen[sheet.Default]; sheet.Default != 0 && (!ok || kind != StyleKindParagraph) {
+		problems = append(problems, fmt.Errorf("sqdoc: default style %d is not a paragraph style", sheet.Default))
+	}
+	return problems
+}
+
+// CheckRef reports whether id is 0 or names a style of the given kind.
+func (s StyleSheet) CheckRef(id uint32, kind StyleKind) bool {
+	if id == 0 {
+		return true
+	}
+	st, ok := s.Lookup(id)
+	return ok && st.Kind == kind
+}
+
+func cloneStyleSheet(s StyleSheet) StyleSheet {
+	return StyleSheet{Default: s.Default, Styles: append([]Style(nil), s.Styles...)}
+}
+
+// The extended formatting directive starts with styledDirectiveMarker where
+// the legacy layout has its entry count, followed by sections of a u8 tag
+// and a u32 record count. Every record starts with its u16 length, so
+// readers skip unknown sections and fields added to known records.
+const (
+	styledDirectiveMarker = ^uint32(0)
+
+	sectionRuns       = 1
+	sectionStyles     = 2
+	sectionParagraphs = 3
+	sectionBookmarks  = 4
+	sectionRevisions  = 5
+
+	paraFlagPageBreak = 1
+
+	styleRoleDefault = 1
+)
+
+// formatting is a decoded formatting directive.
+type formatting struct {
+	runs       []FormattingDirectiveEntry
+	styles     StyleSheet
+	paragraphs map[uint64]paragraph
+	bookmarks  []Bookmark
+	revisions  []Revision
+}
+
+type paragraph struct {
+	style  uint32
+	format ParagraphFormat
+	list   ListItem
+	code   *CodeBlock
+	brk    uint64
+}
+
+// hasParagraphRecord reports whether tb differs from a default paragraph.
+func hasParagraphRecord(tb *TextBlock) bool {
+	return tb.Style != 0 || tb.Para != ParagraphFormat{} || tb.List != ListItem{} || tb.Code != nil || tb.Break != 0
+}
+
+// encodeFormatting keeps the legacy layout for documents without styles so
+// older readers still open them.
+func encodeFormatting(doc *Document) []byte {
+	entries := collectFormatting(doc)
+	if !usesStyles(doc) {
+		return encodeFormattingDirective(entries)
+	}
+	out := appendU32(nil, styledDirectiveMarker)
+
+	out = append(out, sectionRuns)
+	out = appendU32(out, uint32(len(entries)))
+	for _, e := range entries {
+		rec := appendU64(nil, e.BlockID)
+		rec = appendU32(rec, e.Start)
+		rec = appendU32(rec, e.End)
+		rec = appendAttr(rec, e.Attr)
+		rec = appendU32(rec, e.Attr.CharStyle)
+		rec = append(rec, byte(e.Attr.Inherit))
+		rec = appendString(rec, e.Attr.Link)
+		rec = appendRef(rec, e.Attr.Ref)
+		rec = appendU64(rec, e.Attr.Note)
+		rec = appendU64(rec, e.Attr.Revision)
+		out = appendRecord(out, rec)
+	}
+
+	out = append(out, sectionStyles)
+	out = appendU32(out, uint32(len(doc.Styles.Styles)))
+	for _, st := range doc.Styles.Styles {
+		rec := appendU32(nil, st.ID)
+		rec = append(rec, byte(st.Kind))
+		rec = appendU32(rec, st.BasedOn)
+		rec = append(rec, byte(st.Set))
+		role := byte(0)
+		if st.ID == doc.Styles.Default {
+			role |= styleRoleDefault
+		}
+		rec = append(rec, role)
+		rec = appendAttr(rec, st.Attr)
+		rec = appendString(rec, st.Name)
+		out = appendRecord(out, rec)
+	}
+
+	var paras [][]byte
+	for _, b := range doc.Blocks {
+		if b.Text == nil || !hasParagraphRecord(b.Text) {
+			continue
+		}
+		rec := appendU32(appendU64(nil, b.ID), b.Text.Style)
+		rec = appendParagraphFormat(rec, b.Text.Para)
+		rec = appendU32(rec, b.Text.List.ID)
+		rec = append(rec, b.Text.List.Level, byte(b.Text.List.Style))
+		rec = appendU32(rec, b.Text.List.Start)
+		rec = appendCode(rec, b.Text.Code)
+		flags := byte(0)
+		if b.Text.Para.PageBreakBefore {
+			flags |= paraFlagPageBreak
+		}
+		rec = append(rec, flags)
+		rec = appendU64(rec, b.Text.Break)
+		paras = append(paras, rec)
+	}
+	out = append(out, sectionParagraphs)
+	out = appendU32(out, uint32(len(paras)))
+	for _, rec := range paras {
+		out = appendRecord(out, rec)
+	}
+
+	// Bookmarks whose block was deleted are dropped.
+	ids := map[uint64]bool{}
+	for _, b := range doc.Blocks {
+		ids[b.ID] = b.Text != nil
+	}
+	var marks [][]byte
+	for _, bm := range doc.Bookmarks {
+		if ids[bm.BlockID] {
+			marks = append(marks, appendString(appendU32(appendU64(nil, bm.BlockID), bm.Offset), bm.Name))
+		}
+	}
+	out = append(out, sectionBookmarks)
+	out = appendU32(out, uint32(len(marks)))
+	for _, rec := range marks {
+		out = appendRecord(out, rec)
+	}
+
+	if len(doc.Revisions) > 0 {
+		out = append(out, sectionRevisions)
+		out = appendU32(out, uint32(len(doc.Revisions)))
+		for _, r := range doc.Revisions {
+			out = appendRecord(out, appendRevision(nil, r))
+		}
+	}
+	return out
+}
+
+// appendRef writes a cross-reference as its target string and a display
+// byte.
+func appendRef(out []byte, ref CrossRef) []byte {
+	return append(appendString(out, ref.Target), byte(ref.Show))
+}
+
+// readRef is the inverse of appendRef.
+func readRef(b []byte) (CrossRef, []byte, bool) {
+	target, rest, ok := readString(b)
+	if !ok || len(rest) < 1 {
+		return CrossRef{}, nil, false
+	}
+	return CrossRef{Target: target, Show: RefShow(rest[0])}, rest[1:], true
+}
+
+const paragraphFormatSize = 13
+
+func appendParagraphFormat(out []byte, p ParagraphFormat) []byte {
+	out = append(out, byte(p.Align))
+	out = appendU16(out, p.IndentLeft)
+	out = appendU16(out, p.IndentRight)
+	out = appendU16(out, uint16(p.IndentFirst))
+	out = appendU16(out, p.SpaceBefore)
+	out = appendU16(out, p.SpaceAfter)
+	return appendU16(out, p.LineHeight)
+}
+
+// readParagraphFormat is the inverse of appendParagraphFormat; b must hold
+// at least paragraphFormatSize bytes.
+func readParagraphFormat(b []byte) ParagraphFormat {
+	return ParagraphFormat{
+		Align:       Alignment(b[0]),
+		IndentLeft:  binary.LittleEndian.Uint16(b[1:3]),
+		IndentRight: binary.LittleEndian.Uint16(b[3:5]),
+		IndentFirst: int16(binary.LittleEndian.Uint16(b[5:7])),
+		SpaceBefore: binary.LittleEndian.Uint16(b[7:9]),
+		SpaceAfter:  binary.LittleEndian.Uint16(b[9:11]),
+		LineHeight:  binary.LittleEndian.Uint16(b[11:13]),
+	}
+}
+
+func appendRecord(out, rec []byte) []byte {
+	out = appendU16(out, uint16(len(rec)))
+	return append(out, rec...)
+}
+
+func decodeStyledDirective(b []byte) (*formatting, error) {
+	malformed := errors.New("sqdoc: malformed formatting directive section")
+	out := &formatting{paragraphs: map[uint64]paragraph{}}
+	for len(b) > 0 {
+		if len(b) < 5 {
+			return nil, malformed
+		}
+		tag := b[0]
+		count := int(binary.LittleEndian.Uint32(b[1:5]))
+		b = b[5:]
+		for i := 0; i < count; i++ {
+			if len(b) < 2 {
+				return nil, malformed
+			}
+			n := int(binary.LittleEndian.Uint16(b[:2]))
+			if len(b) < 2+n {
+				return nil, malformed
+			}
+			rec := b[2 : 2+n]
+			b = b[2+n:]
+			switch tag {
+			case sectionRuns:
+				if len(rec) < 29 {
+					return nil, malformed
+				}
+				e := FormattingDirectiveEntry{
+					BlockID: binary.LittleEndian.Uint64(rec[:8]),
+					Start:   binary.LittleEndian.Uint32(rec[8:12]),
+					End:     binary.LittleEndian.Uint32(rec[12:16]),
+					Attr:    readAttr(rec[16:24]),
+				}
+				e.Attr.CharStyle = binary.LittleEndian.Uint32(rec[24:28])
+				e.Attr.Inherit = AttrMask(rec[28]) & AttrAll
+				if len(rec) > 29 {
+					rest, ok := rec[29:], false
+					if e.Attr.Link, rest, ok = readString(rest); !ok {
+						return nil, malformed
+					}
+					// Records written before cross-references end here.
+					if len(rest) > 0 {
+						if e.Attr.Ref, rest, ok = readRef(rest); !ok {
+							return nil, malformed
+						}
+					}
+					// And those written before notes here.
+					if len(rest) >= 8 {
+						e.Attr.Note = binary.LittleEndian.Uint64(rest[:8])
+						rest = rest[8:]
+					}
+					// And those written before revisions here.
+					if len(rest) >= 8 {
+						e.Attr.Revision = binary.LittleEndian.Uint64(rest[:8])
+					}
+				}
+				out.runs = append(out.runs, e)
+			case sectionStyles:
+				if len(rec) < 19 {
+					return nil, malformed
+				}
+				st := Style{
+					ID:      binary.LittleEndian.Uint32(rec[:4]),
+					Kind:    StyleKind(rec[4]),
+					BasedOn: binary.LittleEndian.Uint32(rec[5:9]),
+					Set:     AttrMask(rec[9]) & AttrAll,
+					Attr:    readAttr(rec[11:19]),
+				}
+				var ok bool
+				if st.Name, _, ok = readString(rec[19:]); !ok {
+					return nil, malformed
+				}
+				if rec[10]&styleRoleDefault != 0 {
+					out.styles.Default = st.ID
+				}
+				out.styles.Styles = append(out.styles.Styles, st)
+			case sectionParagraphs:
+				if len(rec) < 12 {
+					return nil, malformed
+				}
+				p := paragraph{style: binary.LittleEndian.Uint32(rec[8:12])}
+				// Records written before paragraph formatting stop here.
+				if len(rec) >= 12+paragraphFormatSize {
+					p.format = readParagraphFormat(rec[12:])
+				}
+				if len(rec) >= 35 {
+					p.list = ListItem{
+						ID:    binary.LittleEndian.Uint32(rec[25:29]),
+						Level: rec[29],
+						Style: ListStyle(rec[30]),
+						Start: binary.LittleEndian.Uint32(rec[31:35]),
+					}
+				}
+				if len(rec) > 35 {
+					var ok bool
+					var rest []byte
+					if p.code, rest, ok = readCode(rec[35:]); !ok {
+						return nil, malformed
+					}
+					// Records written before page breaks end here.
+					if len(rest) > 0 {
+						p.format.PageBreakBefore = rest[0]&paraFlagPageBreak != 0
+						rest = rest[1:]
+					}
+					// And those written before revisions here.
+					if len(rest) >= 8 {
+						p.brk = binary.LittleEndian.Uint64(rest[:8])
+					}
+				}
+				out.paragraphs[binary.LittleEndian.Uint64(rec[:8])] = p
+			case sectionBookmarks:
+				if len(rec) < 12 {
+					return nil, malformed
+				}
+				bm := Bookmark{BlockID: binary.LittleEndian.Uint64(rec[:8]), Offset: binary.LittleEndian.Uint32(rec[8:12])}
+				var ok bool
+				if bm.Name, _, ok = readString(rec[12:]); !ok {
+					return nil, malformed
+				}
+				out.bookmarks = append(out.bookmarks, bm)
+			case sectionRevisions:
+				r, ok := readRevision(rec)
+				if !ok {
+					return nil, malformed
+				}
+				out.revisions = append(out.revisions, r)
+			}
+		}
+	}
+	return out, nil
+}
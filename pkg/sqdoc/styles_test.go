@@ -0,0 +1,117 @@
+package sqdoc
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestResolveFollowsBasedOnChain(t *testing.T) {
+	sheet := DefaultStyleSheet()
+	h1 := sheet.Resolve(StyleHeading1, 0)
+	if !h1.Bold || h1.FontSizePt != 24 || h1.ColorRGBA != 0x202020FF {
+		t.Fatalf("heading 1 = %+v", h1)
+	}
+	code := sheet.Resolve(StyleQuote, StyleCode)
+	if !code.Italic || code.FontFamily != FontFamilyMonospace {
+		t.Fatalf("code in quote = %+v", code)
+	}
+	if got := sheet.Resolve(0, 0); got != sheet.Resolve(StyleNormal, 0) {
+		t.Fatalf("default paragraph = %+v", got)
+	}
+}
+
+func TestStyledDocumentRoundTrip(t *testing.T) {
+	doc := NewDocument("", "")
+	doc.Styles = DefaultStyleSheet()
+	doc.Styles.Styles = append(doc.Styles.Styles, Style{ID: 100, Name: "Title", BasedOn: StyleHeading1, Set: AttrColor, Attr: StyleAttr{ColorRGBA: 0x336699FF}})
+	attr := doc.Styles.Resolve(100, StyleCode)
+	attr.CharStyle = StyleCode
+	attr.Inherit = AttrAll &^ AttrUnderline
+	attr.Underline = true
+	doc.Blocks = []Block{
+		{ID: 1, Kind: BlockKindText, Text: &TextBlock{UTF8: []byte("Title"), Style: 100, Runs: []StyleRun{{Start: 0, End: 5, Attr: attr}}}},
+		{ID: 2, Kind: BlockKindText, Text: &TextBlock{UTF8: []byte("body"), Runs: []StyleRun{{Start: 0, End: 4, Attr: DefaultStyleSheet().Resolve(0, 0)}}}},
+	}
+	blob, err := Encode(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := Decode(blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !styleSheetsEqual(loaded.Styles, doc.Styles) {
+		t.Fatalf("styles = %+v", loaded.Styles)
+	}
+	if !blocksEqual(loaded.Blocks[0], doc.Blocks[0]) || !blocksEqual(loaded.Blocks[1], doc.Blocks[1]) {
+		t.Fatalf("blocks = %+v %+v", loaded.Blocks[0].Text, loaded.Blocks[1].Text)
+	}
+	if errs := ValidateAll(loaded); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+}
+
+func TestUnstyledDocumentKeepsLegacyDirective(t *testing.T) {
+	doc := NewDocument("", "")
+	doc.Blocks = []Block{{ID: 1, Kind: BlockKindText, Text: &TextBlock{UTF8: []byte("x"), Runs: []StyleRun{{Start: 0, End: 1, Attr: StyleAttr{FontSizePt: 14}}}}}}
+	payload := encodeFormatting(doc)
+	if !bytes.Equal(payload, encodeFormattingDirective(collectFormatting(doc))) {
+		t.Fatal("unstyled document used the extended directive")
+	}
+}
+
+func TestApplyStylesKeepsDirectFormatting(t *testing.T) {
+	doc := NewDocument("", "")
+	doc.Styles = DefaultStyleSheet()
+	attr := doc.Styles.Resolve(StyleHeading2, 0)
+	attr.Inherit = AttrAll &^ AttrColor
+	attr.ColorRGBA = 0xFF0000FF
+	doc.Blocks = []Block{{ID: 1, Kind: BlockKindText, Text: &TextBlock{UTF8: []byte("h"), Style: StyleHeading2, Runs: []StyleRun{{Start: 0, End: 1, Attr: attr}}}}}
+
+	doc.Styles.Styles[StyleHeading2-1].Attr.FontSizePt = 30
+	doc.Styles.Styles[StyleNormal-1].Attr.ColorRGBA = 0x000000FF
+	doc.ApplyStyles()
+	got := doc.Blocks[0].Text.Runs[0].Attr
+	if got.FontSizePt != 30 || got.ColorRGBA != 0xFF0000FF || !got.Bold {
+		t.Fatalf("attr = %+v", got)
+	}
+}
+
+func TestValidateStyleReferences(t *testing.T) {
+	doc := NewDocument("", "")
+	doc.Styles = DefaultStyleSheet()
+	doc.Styles.Styles = append(doc.Styles.Styles,
+		Style{ID: 200, Name: "A", BasedOn: 201},
+		Style{ID: 201, Name: "B", BasedOn: 200},
+	)
+	doc.Blocks = []Block{{ID: 1, Kind: BlockKindText, Text: &TextBlock{UTF8: []byte("x"), Style: StyleCode, Runs: []StyleRun{{Start: 0, End: 1, Attr: StyleAttr{FontSizePt: 14, CharStyle: StyleQuote}}}}}}
+	if errs := ValidateAll(doc); len(errs) != 4 {
+		t.Fatalf("got %d problems: %v", len(errs), errs)
+	}
+}
+
+func TestParagraphFormatRoundTrip(t *testing.T) {
+	doc := NewDocument("", "")
+	para := ParagraphFormat{Align: AlignJustify, IndentLeft: 36, IndentRight: 12, IndentFirst: -18, SpaceBefore: 6, SpaceAfter: 12, LineHeight: 150}
+	doc.Blocks = []Block{
+		{ID: 1, Kind: BlockKindText, Text: &TextBlock{UTF8: []byte("a"), Para: para}},
+		{ID: 2, Kind: BlockKindText, Text: &TextBlock{UTF8: []byte("b")}},
+	}
+	blob, err := Encode(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := Decode(blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Blocks[0].Text.Para != para || loaded.Blocks[1].Text.Para != (ParagraphFormat{}) {
+		t.Fatalf("paragraphs = %+v / %+v", loaded.Blocks[0].Text.Para, loaded.Blocks[1].Text.Para)
+	}
+
+	doc.Blocks[0].Text.Para.IndentFirst = -40
+	doc.Blocks[1].Text.Para.Align = 9
+	if errs := ValidateAll(doc); len(errs) != 2 {
+		t.Fatalf("got %d problems: %v", len(errs), errs)
+	}
+}
@@ -0,0 +1,397 @@
+package sqdoc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"unicode/utf8"
+)
+
+// BlockKindTable blocks hold a TableBlock. Readers that predate tables skip
+// them.
+const BlockKindTable BlockKind = 5
+
+// MinColumnWidth is the narrowest, in points, that a column sharing the
+// free width of a table gets.
+const MinColumnWidth = 48
+
+// Border selects sides of a table cell.
+type Border uint8
+
+const (
+	BorderTop Border = 1 << iota
+	BorderRight
+	BorderBottom
+	BorderLeft
+
+	BorderAll = BorderTop | BorderRight | BorderBottom | BorderLeft
+)
+
+// TableBlock is a grid of cells. Every row has one cell per column; a cell
+// spanning several columns or rows covers its neighbours, which stay in the
+// grid empty.
+type TableBlock struct {
+	// Columns holds each column's width in points; 0 shares out the width
+	// the others leave.
+	Columns []uint16
+	// HeaderRows is the number of leading rows that head the table.
+	HeaderRows uint16
+	Rows       [][]TableCell
+}
+
+type TableCell struct {
+	// Text is one paragraph of rich text; it may contain line breaks.
+	Text *TextBlock
+	// ColSpan and RowSpan are the columns and rows the cell covers; 0
+	// means 1.
+	ColSpan    uint16
+	RowSpan    uint16
+	Background uint32 // RGBA; 0 means none
+	Borders    Border
+}
+
+// Span returns the cell's column and row span, at least 1 each.
+func (c TableCell) Span() (int, int) {
+	return max(int(c.ColSpan), 1), max(int(c.RowSpan), 1)
+}
+
+// NewTable returns a rows × cols table of empty cells with every border
+// drawn.
+func NewTable(rows, cols int) *TableBlock {
+	t := &TableBlock{Columns: make([]uint16, cols)}
+	for r := 0; r < rows; r++ {
+		row := make([]TableCell, cols)
+		for c := range row {
+			row[c] = TableCell{Text: &TextBlock{}, Borders: BorderAll}
+		}
+		t.Rows = append(t.Rows, row)
+	}
+	return t
+}
+
+// ColumnWidths returns each column's width in points when the table is laid
+// out across width points. Columns without a width share what the others
+// leave, at least MinColumnWidth each, so the table may end up wider.
+func (t *TableBlock) ColumnWidths(width float64) []float64 {
+	out := make([]float64, len(t.Columns))
+	free, shared := width, 0
+	for c, w := range t.Columns {
+		if w == 0 {
+			shared++
+			continue
+		}
+		out[c] = float64(w)
+		free -= out[c]
+	}
+	for c, w := range t.Columns {
+		if w == 0 {
+			out[c] = max(MinColumnWidth, free/float64(shared))
+		}
+	}
+	return out
+}
+
+// Cell returns the cell at row r, column c, or nil outside the grid.
+func (t *TableBlock) Cell(r, c int) *TableCell {
+	if r < 0 || r >= len(t.Rows) || c < 0 || c >= len(t.Rows[r]) {
+		return nil
+	}
+	return &t.Rows[r][c]
+}
+
+// Owner returns the row and column of the cell whose span covers r, c; a
+// cell that is not covered owns itself.
+func (t *TableBlock) Owner(r, c int) (int, int) {
+	for or := 0; or <= r; or++ {
+		for oc := 0; oc <= c; oc++ {
+			cell := t.Cell(or, oc)
+			if cell == nil || (or == r && oc == c) {
+				continue
+			}
+			if cs, rs := cell.Span(); or+rs > r && oc+cs > c {
+				return or, oc
+			}
+		}
+	}
+	return r, c
+}
+
+// Covered reports whether the cell at r, c lies under another cell's span.
+func (t *TableBlock) Covered(r, c int) bool {
+	or, oc := t.Owner(r, c)
+	return or != r || oc != c
+}
+
+// TextBlocks returns the document's paragraphs in order, including the
+// cells of tables row by row and the bodies of notes.
+func (d *Document) TextBlocks() []*TextBlock {
+	var out []*TextBlock
+	for _, b := range d.Blocks {
+		if b.Text != nil {
+			out = append(out, b.Text)
+		}
+		if b.Table == nil {
+			continue
+		}
+		for _, row := range b.Table.Rows {
+			for _, cell := range row {
+				if cell.Text != nil {
+					out = append(out, cell.Text)
+				}
+			}
+		}
+	}
+	return out
+}
+
+func cloneTable(t *TableBlock) *TableBlock {
+	out := &TableBlock{Columns: append([]uint16(nil), t.Columns...), HeaderRows: t.HeaderRows, Rows: make([][]TableCell, len(t.Rows))}
+	for r, row := range t.Rows {
+		out.Rows[r] = make([]TableCell, len(row))
+		for c, cell := range row {
+			out.Rows[r][c] = cell
+			if cell.Text != nil {
+				out.Rows[r][c].Text = cloneTextBlock(cell.Text)
+			}
+		}
+	}
+	return out
+}
+
+func validateTable(t *TableBlock, sheet StyleSheet) []error {
+	var problems []error
+	if len(t.Columns) == 0 || len(t.Rows) == 0 {
+		return []error{errors.New("table has no cells")}
+	}
+	if int(t.HeaderRows) > len(t.Rows) {
+		problems = append(problems, fmt.Errorf("%d header rows in a table of %d", t.HeaderRows, len(t.Rows)))
+	}
+	covered := make([][]bool, len(t.Rows))
+	for r := range covered {
+		covered[r] = make([]bool, len(t.Columns))
+	}
+	for r, row := range t.Rows {
+		if len(row) != len(t.Columns) {
+			problems = append(problems, fmt.Errorf("row %d has %d cells, want %d", r, len(row), len(t.Columns)))
+			continue
+		}
+		for c, cell := range row {
+			if cell.Text == nil {
+				problems = append(problems, fmt.Errorf("cell %d,%d missing text", r, c))
+				continue
+			}
+			if err := validateCellText(cell.Text, sheet); err != nil {
+				problems = append(problems, fmt.Errorf("cell %d,%d: %w", r, c, err))
+			}
+			if covered[r][c] {
+				if len(cell.Text.UTF8) > 0 || cell.ColSpan > 1 || cell.RowSpan > 1 {
+					problems = append(problems, fmt.Errorf("cell %d,%d is merged into another but not empty", r, c))
+				}
+				continue
+			}
+			cs, rs := cell.Span()
+			if r+rs > len(t.Rows) || c+cs > len(t.Columns) {
+				problems = append(problems, fmt.Errorf("cell %d,%d spans past the table", r, c))
+				continue
+			}
+			for dr := 0; dr < rs; dr++ {
+				for dc := 0; dc < cs; dc++ {
+					if dr == 0 && dc == 0 {
+						continue
+					}
+					if covered[r+dr][c+dc] {
+						problems = append(problems, fmt.Errorf("cell %d,%d overlaps another merged cell", r, c))
+					}
+					covered[r+dr][c+dc] = true
+				}
+			}
+		}
+	}
+	return problems
+}
+
+func validateCellText(tb *TextBlock, sheet StyleSheet) error {
+	if !utf8.Valid(tb.UTF8) {
+		return errors.New("text is not valid UTF-8")
+	}
+	if err := validateRuns(tb); err != nil {
+		return err
+	}
+	if err := validateParagraph(tb.Para); err != nil {
+		return err
+	}
+	if tb.List != (ListItem{}) {
+		return errors.New("table cells cannot be list items")
+	}
+	if tb.Code != nil {
+		return errors.New("table cells cannot be code blocks")
+	}
+	if tb.Para.PageBreakBefore {
+		return errors.New("table cells cannot start pages")
+	}
+	if !sheet.CheckRef(tb.Style, StyleKindParagraph) {
+		return fmt.Errorf("unknown paragraph style %d", tb.Style)
+	}
+	for _, r := range tb.Runs {
+		if !sheet.CheckRef(r.Attr.CharStyle, StyleKindCharacter) {
+			return fmt.Errorf("unknown character style %d", r.Attr.CharStyle)
+		}
+	}
+	return nil
+}
+
+func encodeTable(t *TableBlock) []byte {
+	out := appendU16(nil, uint16(len(t.Columns)))
+	out = appendU16(out, uint16(len(t.Rows)))
+	out = appendU16(out, t.HeaderRows)
+	for _, w := range t.Columns {
+		out = appendU16(out, w)
+	}
+	for _, row := range t.Rows {
+		for _, cell := range row {
+			rec := appendU16(nil, cell.ColSpan)
+			rec = appendU16(rec, cell.RowSpan)
+			rec = appendU32(rec, cell.Background)
+			rec = append(rec, byte(cell.Borders))
+			tb := cell.Text
+			if tb == nil {
+				tb = &TextBlock{}
+			}
+			rec = appendU32(rec, tb.Style)
+			rec = appendParagraphFormat(rec, tb.Para)
+			rec = appendU32(rec, uint32(len(tb.UTF8)))
+			rec = append(rec, tb.UTF8...)
+			rec = appendU32(rec, uint32(len(tb.Runs)))
+			for _, r := range sortedRuns(tb.Runs) {
+				rec = appendU32(rec, r.Start)
+				rec = appendU32(rec, r.End)
+				rec = appendAttr(rec, r.Attr)
+				rec = appendU32(rec, r.Attr.CharStyle)
+				rec = append(rec, byte(r.Attr.Inherit))
+			}
+			for _, r := range sortedRuns(tb.Runs) {
+				rec = appendString(rec, r.Attr.Link)
+			}
+			for _, r := range sortedRuns(tb.Runs) {
+				rec = appendRef(rec, r.Attr.Ref)
+			}
+			for _, r := range sortedRuns(tb.Runs) {
+				rec = appendU64(rec, r.Attr.Note)
+			}
+			out = appendU32(out, uint32(len(rec)))
+			out = append(out, rec...)
+		}
+	}
+	return out
+}
+
+const cellRunSize = 4 + 4 + 8 + 4 + 1
+
+func decodeTable(b []byte) (*TableBlock, error) {
+	malformed := errors.New("sqdoc: malformed table block")
+	if len(b) < 6 {
+		return nil, malformed
+	}
+	cols := int(binary.LittleEndian.Uint16(b[:2]))
+	rows := int(binary.LittleEndian.Uint16(b[2:4]))
+	t := &TableBlock{HeaderRows: binary.LittleEndian.Uint16(b[4:6])}
+	b = b[6:]
+	if len(b) < 2*cols {
+		return nil, malformed
+	}
+	for c := 0; c < cols; c++ {
+		t.Columns = append(t.Columns, binary.LittleEndian.Uint16(b[2*c:]))
+	}
+	b = b[2*cols:]
+	for r := 0; r < rows; r++ {
+		row := make([]TableCell, cols)
+		for c := range row {
+			if len(b) < 4 {
+				return nil, malformed
+			}
+			n := int(binary.LittleEndian.Uint32(b[:4]))
+			if n < 0 || len(b) < 4+n {
+				return nil, malformed
+			}
+			rec := b[4 : 4+n]
+			b = b[4+n:]
+			// Readers ignore bytes past the fields they know.
+			if len(rec) < 13+paragraphFormatSize+4 {
+				return nil, malformed
+			}
+			cell := TableCell{
+				ColSpan:    binary.LittleEndian.Uint16(rec[0:2]),
+				RowSpan:    binary.LittleEndian.Uint16(rec[2:4]),
+				Background: binary.LittleEndian.Uint32(rec[4:8]),
+				Borders:    Border(rec[8]),
+				Text:       &TextBlock{Style: binary.LittleEndian.Uint32(rec[9:13])},
+			}
+			cell.Text.Para = readParagraphFormat(rec[13:])
+			rec = rec[13+paragraphFormatSize:]
+			textLen := int(binary.LittleEndian.Uint32(rec[:4]))
+			if textLen < 0 || len(rec) < 4+textLen+4 {
+				return nil, malformed
+			}
+			cell.Text.UTF8 = append([]byte(nil), rec[4:4+textLen]...)
+			rec = rec[4+textLen:]
+			runCount := int(binary.LittleEndian.Uint32(rec[:4]))
+			rec = rec[4:]
+			if runCount < 0 || len(rec) < runCount*cellRunSize {
+				return nil, malformed
+			}
+			for i := 0; i < runCount; i++ {
+				e := rec[i*cellRunSize:]
+				attr := readAttr(e[8:16])
+				attr.CharStyle = binary.LittleEndian.Uint32(e[16:20])
+				attr.Inherit = AttrMask(e[20])
+				cell.Text.Runs = append(cell.Text.Runs, StyleRun{
+					Start: binary.LittleEndian.Uint32(e[0:4]),
+					End:   binary.LittleEndian.Uint32(e[4:8]),
+					Attr:  attr,
+				})
+			}
+			// Link targets follow the runs, one per run, in later files.
+			if rec = rec[runCount*cellRunSize:]; len(rec) > 0 {
+				for i := range cell.Text.Runs {
+					link, rest, ok := readString(rec)
+					if !ok {
+						return nil, malformed
+					}
+					cell.Text.Runs[i].Attr.Link = link
+					rec = rest
+				}
+			}
+			// Then their cross-references.
+			if len(rec) > 0 {
+				for i := range cell.Text.Runs {
+					ref, rest, ok := readRef(rec)
+					if !ok {
+						return nil, malformed
+					}
+					cell.Text.Runs[i].Attr.Ref = ref
+					rec = rest
+				}
+			}
+			// Then the notes they anchor.
+			if len(rec) > 0 {
+				if len(rec) < 8*len(cell.Text.Runs) {
+					return nil, malformed
+				}
+				for i := range cell.Text.Runs {
+					cell.Text.Runs[i].Attr.Note = binary.LittleEndian.Uint64(rec[8*i:])
+				}
+			}
+			row[c] = cell
+		}
+		t.Rows = append(t.Rows, row)
+	}
+	return t, nil
+}
+
+func tablesEqual(a, b *TableBlock) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return bytes.Equal(encodeTable(a), encodeTable(b))
+}
@@ -0,0 +1,94 @@
+package sqdoc
+
+import (
+	"strings"
+	"testing"
+)
+
+func sampleTable() *TableBlock {
+	t := NewTable(3, 3)
+	t.Columns[0] = 120
+	t.HeaderRows = 1
+	t.Rows[0][0].Text.UTF8 = []byte("Name")
+	t.Rows[0][0].Text.Runs = []StyleRun{{Start: 0, End: 4, Attr: StyleAttr{Bold: true, FontSizePt: 14, ColorRGBA: 0x202020FF, Inherit: AttrItalic, CharStyle: StyleCode}}}
+	t.Rows[0][0].Background = 0xDDE6F2FF
+	t.Rows[1][1].ColSpan = 2
+	t.Rows[1][1].RowSpan = 2
+	t.Rows[1][1].Text.UTF8 = []byte("merged\ncell")
+	t.Rows[1][1].Text.Para.Align = AlignCenter
+	t.Rows[2][0].Borders = BorderTop | BorderBottom
+	return t
+}
+
+func TestTableRoundTrip(t *testing.T) {
+	doc := NewDocument("", "")
+	doc.Blocks = []Block{
+		{ID: 1, Kind: BlockKindText, Text: &TextBlock{UTF8: []byte("before")}},
+		{ID: 2, Kind: BlockKindTable, Table: sampleTable()},
+	}
+	blob, err := Encode(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := Decode(blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded.Blocks) != 2 || loaded.Blocks[1].Kind != BlockKindTable {
+		t.Fatalf("blocks = %+v", loaded.Blocks)
+	}
+	if !blocksEqual(doc.Blocks[1], loaded.Blocks[1]) {
+		t.Fatalf("table changed in round trip: %+v", loaded.Blocks[1].Table)
+	}
+	got := loaded.Blocks[1].Table
+	if run := got.Rows[0][0].Text.Runs[0]; !run.Attr.Bold || run.Attr.CharStyle != StyleCode || run.Attr.Inherit != AttrItalic {
+		t.Fatalf("cell run = %+v", run)
+	}
+	if !got.Covered(2, 2) || got.Covered(1, 1) || got.Covered(1, 0) {
+		t.Fatal("merged cell coverage is wrong")
+	}
+	if r, c := got.Owner(2, 1); r != 1 || c != 1 {
+		t.Fatalf("owner of 2,1 = %d,%d", r, c)
+	}
+
+	clone := CloneDocument(loaded)
+	clone.Blocks[1].Table.Rows[0][0].Text.UTF8[0] = 'X'
+	if string(loaded.Blocks[1].Table.Rows[0][0].Text.UTF8) != "Name" {
+		t.Fatal("clone shares cell text")
+	}
+}
+
+func TestTableValidation(t *testing.T) {
+	table := sampleTable()
+	table.Rows[2][2].Text.UTF8 = []byte("hidden")
+	table.Rows[0][2].ColSpan = 2
+	table.Rows[1] = table.Rows[1][:2]
+	table.HeaderRows = 4
+	doc := NewDocument("", "")
+	doc.Blocks = []Block{{ID: 1, Kind: BlockKindTable, Table: table}}
+	errs := ValidateAll(doc)
+	var msgs []string
+	for _, err := range errs {
+		msgs = append(msgs, err.Error())
+	}
+	for _, want := range []string{"header rows", "row 1 has 2 cells", "spans past the table"} {
+		if !strings.Contains(strings.Join(msgs, "\n"), want) {
+			t.Fatalf("missing %q in %q", want, msgs)
+		}
+	}
+
+	if err := Validate(&Document{Blocks: []Block{{ID: 1, Kind: BlockKindTable, Table: &TableBlock{}}}}); err == nil {
+		t.Fatal("empty table passed validation")
+	}
+}
+
+func TestTableColumnWidths(t *testing.T) {
+	table := NewTable(1, 3)
+	table.Columns[0] = 100
+	if got := table.ColumnWidths(400); got[0] != 100 || got[1] != 150 || got[2] != 150 {
+		t.Fatalf("widths = %v", got)
+	}
+	if got := table.ColumnWidths(150); got[1] != MinColumnWidth || got[2] != MinColumnWidth {
+		t.Fatalf("narrow widths = %v, want shared columns at the minimum", got)
+	}
+}
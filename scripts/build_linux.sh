@@ -9,6 +9,7 @@ mkdir -p "$OUT"
 echo "[SIDE] Building Linux binary..."
 cd "$ROOT"
 go build -trimpath -ldflags="-s -w" -o "$OUT/side" ./cmd/side
+go build -trimpath -ldflags="-s -w" -o "$OUT/sqdoc" ./cmd/sqdoc
 
 echo "[SIDE] Build completed."
-echo "[SIDE] Output: $OUT/side"
+echo "[SIDE] Output: $OUT/side, $OUT/sqdoc"
@@ -17,9 +17,14 @@ if errorlevel 1 (
     echo [SIDE] Build failed.
     exit /b 1
 )
+go build -trimpath -ldflags="-s -w" -o "%OUT%\sqdoc.exe" ./cmd/sqdoc
+if errorlevel 1 (
+    echo [SIDE] Build failed.
+    exit /b 1
+)
 
 echo [SIDE] Build completed.
-echo [SIDE] Output: %OUT%\side.exe
+echo [SIDE] Output: %OUT%\side.exe, %OUT%\sqdoc.exe
 
 if exist "%META%" (
     for /f "usebackq tokens=1,* delims==" %%A in ("%META%") do (